@@ -17,6 +17,7 @@ package kanzi
 
 import (
 	"errors"
+	"math/bits"
 )
 
 // LOG2 is an array with 256 elements: int(Math.log2(x-1))
@@ -86,6 +87,11 @@ var SQUASH [4096]int
 // d has range -2047 to 2047 representing -8 to 8. p in [0..4095].
 var STRETCH [4096]int
 
+// 16 bit copies of SQUASH and STRETCH read by Squash() and Stretch(): 16 KB
+// for both instead of 64 KB, which used to take all of L1.
+var _SQUASH16 [4096]int16
+var _STRETCH16 [4096]int16
+
 func init() {
 	// Init squash
 	for x := -2047; x <= 2047; x++ {
@@ -96,8 +102,9 @@ func init() {
 
 	pi := 0
 
+	// Squash() reads _SQUASH16, not filled yet
 	for x := -2047; x <= 2047; x++ {
-		i := Squash(x)
+		i := SQUASH[x+2047]
 
 		for pi <= i {
 			STRETCH[pi] = x
@@ -106,6 +113,11 @@ func init() {
 	}
 
 	STRETCH[4095] = 2047
+
+	for i := range &STRETCH {
+		_SQUASH16[i] = int16(SQUASH[i])
+		_STRETCH16[i] = int16(STRETCH[i])
+	}
 }
 
 // Squash returns p = 1/(1 + exp(-d)), d scaled by 8 bits, p scaled by 12 bits
@@ -118,7 +130,13 @@ func Squash(d int) int {
 		return 0
 	}
 
-	return SQUASH[d+2047]
+	return int(_SQUASH16[(d+2047)&4095])
+}
+
+// Stretch returns d = ln(p/(1-p)), d scaled by 8 bits, p scaled by 12 bits.
+// p must be in [0..4095].
+func Stretch(p int) int {
+	return int(_STRETCH16[p&4095])
 }
 
 // Log2 returns a fast, integer rounded value for log2(x)
@@ -132,21 +150,8 @@ func Log2(x uint32) (uint32, error) {
 
 // Log2NoCheck does the same as Log2() minus a null check on input value
 func Log2NoCheck(x uint32) uint32 {
-	var res uint32
-
-	if x >= 1<<16 {
-		x >>= 16
-		res = 16
-	} else {
-		res = 0
-	}
-
-	if x >= 1<<8 {
-		x >>= 8
-		res += 8
-	}
-
-	return res + LOG2[x-1]
+	// Compiles to a single instruction: no table lookup
+	return uint32(bits.Len32(x)) - 1
 }
 
 // Log2_1024 returns 1024 * log2(x). Max error is around 0.1%
@@ -67,6 +67,18 @@ type ByteTransform interface {
 	Inverse(src, dst []byte) (uint, uint, error)
 }
 
+// InPlaceByteTransform is a ByteTransform (or a ByteFunction) whose inverse
+// can also run in place, without a separate output buffer.
+type InPlaceByteTransform interface {
+	ByteTransform
+
+	// InverseInPlace applies the reverse function to the first 'length'
+	// bytes of the block and writes the result to the start of the block,
+	// which must be large enough for it. Returns the number of bytes
+	// written and possibly an error.
+	InverseInPlace(block []byte, length uint) (uint, error)
+}
+
 // IntFunction is a function that transforms the input int slice and writes
 // the result in the output int slice. The result may have a different size.
 type IntFunction interface {
@@ -112,6 +124,11 @@ type InputBitStream interface {
 	// Panics if closed or EOS is reached.
 	ReadBits(length uint) uint64
 
+	// PeekBits returns the next 'length' (in [1..64]) bits of the bitstream as
+	// an uint64 without consuming them. The bits past the end of the stream
+	// are returned as zeros. Panics if closed.
+	PeekBits(length uint) uint64
+
 	// ReadArray reads 'length' bits from the bitstream and put them in the byte slice.
 	// Returns the number of bits read.
 	// Panics if closed or EOS is reached.
@@ -151,6 +168,35 @@ type OutputBitStream interface {
 	Written() uint64
 }
 
+// SeekableInputBitStream is an InputBitStream that supports random access
+// (if backed by an io.ReadSeeker, EG. a file or a util.BufferStream).
+type SeekableInputBitStream interface {
+	InputBitStream
+
+	// Position returns the bit position of the next bit to read
+	Position() uint64
+
+	// Seek moves the bitstream to the provided bit position (relative to the
+	// start of the bitstream). Returns an error if the underlying stream cannot
+	// seek to the position or the bitstream is closed.
+	Seek(bitPos uint64) error
+}
+
+// SeekableOutputBitStream is an OutputBitStream that supports random access
+// (if backed by an io.WriteSeeker, EG. a file).
+type SeekableOutputBitStream interface {
+	OutputBitStream
+
+	// Position returns the bit position of the next bit to write
+	Position() uint64
+
+	// Seek flushes the bitstream and moves it to the provided byte aligned bit
+	// position (relative to the start of the bitstream). Returns an error if
+	// the position is not byte aligned, the last byte written is incomplete,
+	// the underlying stream cannot seek or the bitstream is closed.
+	Seek(bitPos uint64) error
+}
+
 // Predictor predicts the probability of the next bit being 1.
 type Predictor interface {
 	// Update updates the internal probability model based on the observed bit
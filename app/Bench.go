@@ -0,0 +1,161 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bench"
+)
+
+// runBench implements the bench sub command:
+//
+//	Kanzi bench [-corpus=silesia|enwik8] [-i=file_or_dir] [-levels=1-4]
+//	            [-pipelines=p1,p2] [-b=4m] [-j=1] [-n=1] [-cache=dir]
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	corpus := flags.String("corpus", "", "standard corpus to download and use (silesia or enwik8)")
+	input := flags.String("i", "", "file or directory to use instead of a corpus")
+	levels := flags.String("levels", "1-6", "compression levels (EG. 4 or 1-6), ignored if -pipelines is provided")
+	pipelines := flags.String("pipelines", "", "comma separated pipelines (EG. TEXT+LZ&HUFFMAN,BWT+RANK+ZRLT&ANS0)")
+	block := flags.String("b", "4m", "block size (K or M suffix allowed)")
+	jobs := flags.Uint("j", 1, "number of jobs per compression")
+	iter := flags.Int("n", 1, "number of iterations (the best time is reported)")
+	cache := flags.String("cache", "", "corpus cache directory (default $"+bench.CORPUS_DIR_ENV+" or the user cache directory)")
+	total := flags.Bool("total", true, "report the total per pipeline when there are several inputs")
+
+	if err := flags.Parse(args); err != nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	if (len(*corpus) == 0) == (len(*input) == 0) {
+		fmt.Println("Exactly one of -corpus or -i must be provided")
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	cfg := bench.Config{Jobs: *jobs, Iterations: *iter}
+	var err error
+
+	if cfg.BlockSize, err = parseBenchSize(*block); err != nil {
+		fmt.Println(err)
+		return kanzi.ERR_BLOCK_SIZE
+	}
+
+	if len(*pipelines) > 0 {
+		cfg.Pipelines = bench.ParsePipelines(*pipelines)
+	} else if cfg.Pipelines, err = parseBenchLevels(*levels); err != nil {
+		fmt.Println(err)
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	var files []string
+
+	if len(*corpus) > 0 {
+		c, err := bench.GetCorpus(*corpus)
+
+		if err != nil {
+			fmt.Println(err)
+			return kanzi.ERR_INVALID_PARAM
+		}
+
+		dir := *cache
+
+		if len(dir) == 0 {
+			if dir, err = bench.CacheDir(); err != nil {
+				fmt.Println(err)
+				return kanzi.ERR_OPEN_FILE
+			}
+		}
+
+		if files, err = c.Fetch(dir); err != nil {
+			fmt.Println(err)
+			return kanzi.ERR_OPEN_FILE
+		}
+	} else if files, err = bench.ListFiles(*input); err != nil {
+		fmt.Println(err)
+		return kanzi.ERR_OPEN_FILE
+	}
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	results, err := bench.RunFiles(files, cfg)
+
+	if err != nil {
+		fmt.Println(err)
+		return kanzi.ERR_READ_FILE
+	}
+
+	if *total == true && len(files) > 1 {
+		results = append(results, bench.Total(results)...)
+	}
+
+	if err = bench.WriteReport(os.Stdout, results); err != nil {
+		return kanzi.ERR_WRITE_FILE
+	}
+
+	for _, r := range results {
+		if r.Err != nil {
+			return kanzi.ERR_PROCESS_BLOCK
+		}
+	}
+
+	return 0
+}
+
+func parseBenchSize(str string) (uint, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	scale := uint64(1)
+
+	if strings.HasSuffix(str, "K") {
+		scale = 1024
+		str = str[0 : len(str)-1]
+	} else if strings.HasSuffix(str, "M") {
+		scale = 1024 * 1024
+		str = str[0 : len(str)-1]
+	}
+
+	val, err := strconv.ParseUint(str, 10, 32)
+
+	if err != nil || val == 0 {
+		return 0, fmt.Errorf("Invalid block size provided on command line: %v", str)
+	}
+
+	return uint(val * scale), nil
+}
+
+func parseBenchLevels(str string) ([]string, error) {
+	tokens := strings.SplitN(str, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(tokens[0]))
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid compression levels provided on command line: %v", str)
+	}
+
+	to := from
+
+	if len(tokens) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(tokens[1])); err != nil {
+			return nil, fmt.Errorf("Invalid compression levels provided on command line: %v", str)
+		}
+	}
+
+	return bench.LevelPipelines(from, to)
+}
@@ -26,8 +26,8 @@ import (
 	"time"
 
 	kanzi "github.com/flanglet/kanzi-go"
-	"github.com/flanglet/kanzi-go/function"
 	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
 )
 
 const (
@@ -46,6 +46,14 @@ type BlockCompressor struct {
 	overwrite    bool
 	checksum     bool
 	skipBlocks   bool
+	autoTune     bool
+	warmStart    bool
+	warmROLZ     bool
+	syncMarkers  bool
+	interleave   uint
+	segments     uint
+	tpaqMemory   uint   // 0 if not set
+	textDict     string // text dictionary file name ("" if not set)
 	inputName    string
 	outputName   string
 	entropyCodec string
@@ -85,6 +93,50 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 		this.skipBlocks = false
 	}
 
+	if tune, prst := argsMap["autoTune"]; prst == true {
+		this.autoTune = tune.(bool)
+		delete(argsMap, "autoTune")
+	}
+
+	if warm, prst := argsMap["warmStart"]; prst == true {
+		this.warmStart = warm.(bool)
+		delete(argsMap, "warmStart")
+	}
+
+	if sync, prst := argsMap["syncMarkers"]; prst == true {
+		this.syncMarkers = sync.(bool)
+		delete(argsMap, "syncMarkers")
+	}
+
+	if warm, prst := argsMap["warmROLZ"]; prst == true {
+		this.warmROLZ = warm.(bool)
+		delete(argsMap, "warmROLZ")
+	}
+
+	if interleave, prst := argsMap["ansInterleave"]; prst == true {
+		this.interleave = interleave.(uint)
+		delete(argsMap, "ansInterleave")
+	} else {
+		this.interleave = 1
+	}
+
+	if segments, prst := argsMap["entropySegments"]; prst == true {
+		this.segments = segments.(uint)
+		delete(argsMap, "entropySegments")
+	} else {
+		this.segments = 1
+	}
+
+	if memory, prst := argsMap["tpaqMemory"]; prst == true {
+		this.tpaqMemory = memory.(uint)
+		delete(argsMap, "tpaqMemory")
+	}
+
+	if dict, prst := argsMap["textDictionaryFile"]; prst == true {
+		this.textDict = dict.(string)
+		delete(argsMap, "textDictionaryFile")
+	}
+
 	this.inputName = argsMap["inputName"].(string)
 	delete(argsMap, "inputName")
 	this.outputName = argsMap["outputName"].(string)
@@ -93,10 +145,14 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 	strCodec := ""
 
 	if this.level >= 0 {
-		tranformAndCodec := getTransformAndCodec(this.level)
-		tokens := strings.Split(tranformAndCodec, "&")
-		strTransf = tokens[0]
-		strCodec = tokens[1]
+		desc, err := pipeline.ForLevel(this.level)
+
+		if err != nil {
+			return nil, err
+		}
+
+		strTransf = desc.TransformName()
+		strCodec = desc.Entropy
 	} else {
 		if codec, prst := argsMap["entropy"]; prst == true {
 			strCodec = codec.(string)
@@ -131,7 +187,14 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 	}
 
 	// Extract transform names. Curate input (EG. NONE+NONE+xxxx => xxxx)
-	this.transform = function.GetName(function.GetType(strTransf))
+	desc, err := pipeline.Parse(strTransf + "&" + strCodec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.transform = desc.TransformName()
+	this.entropyCodec = desc.Entropy
 
 	if check, prst := argsMap["checksum"]; prst == true {
 		this.checksum = check.(bool)
@@ -275,7 +338,9 @@ func (this *BlockCompressor) Compress() (int, uint64) {
 	msg = fmt.Sprintf("Checksum set to %t", this.checksum)
 	log.Println(msg, printFlag)
 
-	if printFlag == true {
+	if printFlag == true && this.autoTune == true {
+		msg = "Auto-tuning transform and entropy codec on the first block"
+	} else if printFlag == true {
 		w1 := "no"
 
 		if this.transform != _COMP_NONE {
@@ -378,7 +443,22 @@ func (this *BlockCompressor) Compress() (int, uint64) {
 	ctx := make(map[string]interface{})
 	ctx["verbosity"] = this.verbosity
 	ctx["overwrite"] = this.overwrite
+	ctx["profileStages"] = len(this.cpuProf) > 0
 	ctx["skipBlocks"] = this.skipBlocks
+	ctx["autoTune"] = this.autoTune
+	ctx["warmStart"] = this.warmStart
+	ctx["warmROLZ"] = this.warmROLZ
+	ctx["syncMarkers"] = this.syncMarkers
+	ctx["ansInterleave"] = this.interleave
+	ctx["entropySegments"] = this.segments
+
+	if this.tpaqMemory != 0 {
+		ctx["tpaqMemory"] = this.tpaqMemory
+	}
+
+	if len(this.textDict) > 0 {
+		ctx["textDictionaryFile"] = this.textDict
+	}
 	ctx["blockSize"] = this.blockSize
 	ctx["checksum"] = this.checksum
 	ctx["codec"] = this.entropyCodec
@@ -513,40 +593,6 @@ func notifyBCListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
 	}
 }
 
-func getTransformAndCodec(level int) string {
-	switch level {
-	case 0:
-		return "NONE&NONE"
-
-	case 1:
-		return "TEXT+LZ&HUFFMAN"
-
-	case 2:
-		return "TEXT+ROLZ&NONE"
-
-	case 3:
-		return "TEXT+ROLZX&NONE"
-
-	case 4:
-		return "TEXT+BWT+RANK+ZRLT&ANS0"
-
-	case 5:
-		return "TEXT+BWT+SRT+ZRLT&FPAQ"
-
-	case 6:
-		return "LZP+TEXT+BWT&CM"
-
-	case 7:
-		return "X86+RLT+TEXT&TPAQ"
-
-	case 8:
-		return "X86+RLT+TEXT&TPAQX"
-
-	default:
-		return "Unknown&Unknown"
-	}
-}
-
 type fileCompressTask struct {
 	ctx       map[string]interface{}
 	listeners []kanzi.Listener
@@ -722,6 +768,11 @@ func (this *fileCompressTask) call() (int, uint64, uint64) {
 	msg = fmt.Sprintf("Compression ratio: %f", float64(cos.GetWritten())/float64(read))
 	log.Println(msg, printFlag)
 
+	if tune, prst := this.ctx["autoTune"]; prst == true && tune.(bool) == true {
+		msg = fmt.Sprintf("Tuned pipeline:    %v", cos.Pipeline())
+		log.Println(msg, printFlag)
+	}
+
 	if delta >= 100000 {
 		msg = fmt.Sprintf("%.1f s", float64(delta)/1000)
 	} else {
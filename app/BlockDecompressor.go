@@ -16,8 +16,11 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -26,7 +29,10 @@ import (
 	"time"
 
 	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/bzip2"
 	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/util"
 )
 
 const (
@@ -49,6 +55,8 @@ type BlockDecompressor struct {
 	to         int // end block
 	listeners  []kanzi.Listener
 	cpuProf    string
+	textDict   string // text dictionary file name ("" if not set)
+	recovery   bool   // skip the corrupted blocks (streams with sync markers)
 }
 
 type fileDecompressResult struct {
@@ -113,6 +121,16 @@ func NewBlockDecompressor(argsMap map[string]interface{}) (*BlockDecompressor, e
 		this.cpuProf = ""
 	}
 
+	if dict, hasKey := argsMap["textDictionaryFile"]; hasKey == true {
+		this.textDict = dict.(string)
+		delete(argsMap, "textDictionaryFile")
+	}
+
+	if rec, hasKey := argsMap["recover"]; hasKey == true {
+		this.recovery = rec.(bool)
+		delete(argsMap, "recover")
+	}
+
 	if this.verbosity > 0 && len(argsMap) > 0 {
 		for k := range argsMap {
 			log.Println("Ignoring invalid option ["+k+"]", this.verbosity > 0)
@@ -295,6 +313,12 @@ func (this *BlockDecompressor) Decompress() (int, uint64) {
 	ctx := make(map[string]interface{})
 	ctx["verbosity"] = this.verbosity
 	ctx["overwrite"] = this.overwrite
+	ctx["profileStages"] = len(this.cpuProf) > 0
+	ctx["recover"] = this.recovery
+
+	if len(this.textDict) > 0 {
+		ctx["textDictionaryFile"] = this.textDict
+	}
 
 	if this.from >= 0 {
 		ctx["from"] = this.from
@@ -511,26 +535,65 @@ func (this *fileDecompressTask) call() (int, uint64) {
 		}()
 	}
 
-	cis, err := kio.NewCompressedInputStreamWithCtx(input, this.ctx)
+	// Delegate gzip and bzip2 files to the matching decoders
+	var cis decompressedStream
+	bufInput := bufio.NewReader(input)
+	magic := util.NO_MAGIC
 
-	if err != nil {
-		if err.(*kio.IOError) != nil {
-			fmt.Printf("%s\n", err.(*kio.IOError).Message())
-			return err.(*kio.IOError).ErrorCode(), uint64(read)
+	if sig, err := bufInput.Peek(4); err == nil {
+		magic = util.GetMagicType(sig)
+	}
+
+	if magic == util.GZIP_MAGIC || magic == util.BZIP2_MAGIC {
+		log.Println("Decoding "+util.GetMagicName(magic)+" file", printFlag)
+		fis, err := newForeignInputStream(bufInput, magic)
+
+		if err != nil {
+			fmt.Printf("Cannot create %v decoder: %v\n", util.GetMagicName(magic), err)
+			return kanzi.ERR_CREATE_DECOMPRESSOR, uint64(read)
 		}
 
-		fmt.Printf("Cannot create compressed stream: %v\n", err)
-		return kanzi.ERR_CREATE_DECOMPRESSOR, uint64(read)
-	}
+		cis = fis
+	} else {
+		var kcis *kio.CompressedInputStream
+		var err error
 
-	for _, bl := range this.listeners {
-		cis.AddListener(bl)
+		if file, isFile := input.(*os.File); isFile == true && file != os.Stdin && bitstream.MMAP_SUPPORTED == true {
+			// Read the file mapping directly (built with '-tags mmap')
+			if ibs, errMap := bitstream.NewMmapInputBitStream(file); errMap == nil {
+				kcis, err = kio.NewCompressedInputStreamWithBitStream(ibs, this.ctx)
+			} else {
+				log.Println("Cannot map input file, using buffered reads: "+errMap.Error(), verbosity > 2)
+			}
+		}
+
+		if kcis == nil && err == nil {
+			kcis, err = kio.NewCompressedInputStreamWithCtx(ioutil.NopCloser(bufInput), this.ctx)
+		}
+
+		if err != nil {
+			if err.(*kio.IOError) != nil {
+				fmt.Printf("%s\n", err.(*kio.IOError).Message())
+				return err.(*kio.IOError).ErrorCode(), uint64(read)
+			}
+
+			fmt.Printf("Cannot create compressed stream: %v\n", err)
+			return kanzi.ERR_CREATE_DECOMPRESSOR, uint64(read)
+		}
+
+		for _, bl := range this.listeners {
+			kcis.AddListener(bl)
+		}
+
+		cis = kcis
 	}
 
 	buffer := make([]byte, _DECOMP_DEFAULT_BUFFER_SIZE)
 	decoded := len(buffer)
 	before := time.Now()
 
+	var err error
+
 	// Decode next block
 	for decoded == len(buffer) {
 		if decoded, err = cis.Read(buffer); err != nil {
@@ -562,6 +625,11 @@ func (this *fileDecompressTask) call() (int, uint64) {
 		return kanzi.ERR_PROCESS_BLOCK, uint64(read)
 	}
 
+	if kcis, isKanzi := cis.(*kio.CompressedInputStream); isKanzi == true && kcis.GetLostBlocks() > 0 {
+		msg = fmt.Sprintf("Warning: %d corrupted blocks of %v were skipped", kcis.GetLostBlocks(), inputName)
+		log.Println(msg, verbosity > 0)
+	}
+
 	after := time.Now()
 	delta := after.Sub(before).Nanoseconds() / 1000000 // convert to ms
 	log.Println("", verbosity > 1)
@@ -602,3 +670,67 @@ func (this *fileDecompressTask) call() (int, uint64) {
 
 	return 0, uint64(read)
 }
+
+// decompressedStream is the common interface of the kanzi and foreign decoders
+type decompressedStream interface {
+	io.ReadCloser
+	GetRead() uint64
+}
+
+// foreignInputStream decodes a gzip or bzip2 file. Like CompressedInputStream,
+// Read returns 0 bytes and no error at the end of the stream.
+type foreignInputStream struct {
+	decoder io.Reader
+	input   *countingReader
+}
+
+type countingReader struct {
+	r     io.Reader
+	count uint64
+}
+
+func (this *countingReader) Read(b []byte) (int, error) {
+	n, err := this.r.Read(b)
+	this.count += uint64(n)
+	return n, err
+}
+
+func newForeignInputStream(is io.Reader, magic uint) (*foreignInputStream, error) {
+	this := &foreignInputStream{input: &countingReader{r: is}}
+	var err error
+
+	if magic == util.GZIP_MAGIC {
+		this.decoder, err = gzip.NewReader(this.input)
+	} else {
+		this.decoder, err = bzip2.NewReader(this.input)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *foreignInputStream) Read(b []byte) (int, error) {
+	n, err := io.ReadFull(this.decoder, b)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (this *foreignInputStream) Close() error {
+	if c, ok := this.decoder.(io.Closer); ok == true {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// GetRead returns the number of compressed bytes read so far
+func (this *foreignInputStream) GetRead() uint64 {
+	return this.input.count
+}
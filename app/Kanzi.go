@@ -57,6 +57,10 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+
 	argsMap := make(map[string]interface{})
 
 	if status := processCommandLine(os.Args, argsMap); status != 0 {
@@ -172,6 +176,15 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 	overwrite := false
 	checksum := false
 	skip := false
+	tune := false
+	warm := false
+	warmROLZ := false
+	syncMarkers := false
+	recovery := false
+	interleave := 0
+	segments := 0
+	tpaqMemory := 0
+	textDict := ""
 	from := -1
 	to := -1
 	inputName := ""
@@ -323,18 +336,50 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 				log.Println("        3=TEXT+ROLZX, 4=TEXT+BWT+RANK+ZRLT&ANS0, 5=TEXT+BWT+SRT+ZRLT&FPAQ", true)
 				log.Println("        6=LZP+TEXT+BWT&CM, 7=X86+RLT+TEXT&TPAQ, 8=X86+RLT+TEXT&TPAQX\n", true)
 				log.Println("   -e, --entropy=<codec>", true)
-				log.Println("        entropy codec [None|Huffman|ANS0|ANS1|Range|FPAQ|TPAQ|TPAQX|CM]", true)
+				log.Println("        entropy codec [None|Huffman|Huffman1|HuffmanRL|AHuff|Rice|ANS0|ANS1|Range|FSE|FPAQ|FPAQ32|TPAQ|TPAQX|CM|CM32|LZModel]", true)
 				log.Println("        (default is ANS0)\n", true)
 				log.Println("   -t, --transform=<codec>", true)
-				log.Println("        transform [None|BWT|BWTS|LZ|LZP|ROLZ|ROLZX|RLT|ZRLT]", true)
-				log.Println("                  [MTFT|RANK|SRT|TEXT|X86]", true)
+				log.Println("        transform [None|BWT|BWTS|LZ|LZP|ROLZ|ROLZX|RLT|ZRLT|MRLT]", true)
+				log.Println("                  [MTFT|MTF1|MTF2|RANK|SRT|TEXT|ST3|ST4|ST5|ST6|SHUFFLE|DNA]", true)
+				log.Println("                  [JSON|AUDIO|IMAGE|DELTA|X86|ARM64|RISCV]", true)
 				log.Println("        EG: BWT+RANK or BWTS+MTFT (default is BWT+RANK+ZRLT)\n", true)
 				log.Println("   -x, --checksum", true)
 				log.Println("        enable block checksum\n", true)
 				log.Println("   -s, --skip", true)
 				log.Println("        copy blocks with high entropy instead of compressing them.\n", true)
+				log.Println("   --tune", true)
+				log.Println("        select the transform and entropy codec by compressing samples", true)
+				log.Println("        of the first block with several candidates.\n", true)
+				log.Println("   --warm", true)
+				log.Println("        start each block with the statistics of the previous blocks", true)
+				log.Println("        (better for small blocks, the blocks are processed sequentially).\n", true)
+				log.Println("   --warm-rolz", true)
+				log.Println("        same as --warm, the ROLZ transforms also refer to the previous", true)
+				log.Println("        blocks (until the next flush).\n", true)
+				log.Println("   --interleave=<1|2|4>", true)
+				log.Println("        number of interleaved states of the ANS codecs (default is 1)", true)
+				log.Println("        more states decode faster at the cost of a few bytes per chunk.\n", true)
+				log.Println("   --segments=<segments>", true)
+				log.Println("        max number of segments of a block entropy coded concurrently", true)
+				log.Println("        (default is 1, maximum is 64, at least 64 KB per segment).\n", true)
+				log.Println("   --tpaq-memory=<MB>", true)
+				log.Println("        memory budget of the TPAQ and TPAQX models, a power of 2 in", true)
+				log.Println("        [16..1024] (default is to size the models after the block size).\n", true)
+				log.Println("   --sync-markers", true)
+				log.Println("        write a sync marker before each block so that the corrupted blocks", true)
+				log.Println("        can be skipped when decompressing (see --recover).\n", true)
 			}
 
+			if mode != "c" {
+				log.Println("   --recover", true)
+				log.Println("        skip the corrupted blocks of a stream with sync markers instead of", true)
+				log.Println("        failing (the data of these blocks is lost).\n", true)
+			}
+
+			log.Println("   --text-dict=<file>", true)
+			log.Println("        static dictionary of the text transform (capitalized words, EG.", true)
+			log.Println("        produced by 'DictConverter -type=text'). The same dictionary must be", true)
+			log.Println("        provided to decompress.\n", true)
 			log.Println("   -j, --jobs=<jobs>", true)
 			log.Println("        maximum number of jobs the program may start concurrently", true)
 			log.Println("        (default is 1, maximum is 64).\n", true)
@@ -352,6 +397,8 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 				log.Println("EG. Kanzi --decompress --input=foo.knz --force --verbose=2 --jobs=2\n", true)
 			}
 
+			log.Println("Run 'Kanzi bench -h' for the options of the benchmark sub command.\n", true)
+
 			return 0
 		}
 
@@ -384,6 +431,57 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 			continue
 		}
 
+		if arg == "--tune" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			tune = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--warm" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			warm = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--warm-rolz" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			warm = true
+			warmROLZ = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--sync-markers" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			syncMarkers = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--recover" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			recovery = true
+			ctx = -1
+			continue
+		}
+
 		if arg == "--checksum" || arg == "-x" {
 			if ctx != -1 {
 				log.Println("Warning: ignoring option ["+_CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
@@ -641,6 +739,73 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 			continue
 		}
 
+		if strings.HasPrefix(arg, "--interleave=") && ctx == -1 {
+			strInterleave := strings.TrimPrefix(arg, "--interleave=")
+			var err error
+
+			if interleave != 0 {
+				fmt.Printf("Warning: ignoring duplicate ANS interleave factor: %v\n", strInterleave)
+				continue
+			}
+
+			if interleave, err = strconv.Atoi(strInterleave); err != nil || (interleave != 1 && interleave != 2 && interleave != 4) {
+				fmt.Printf("Invalid ANS interleave factor provided on command line: %v\n", strInterleave)
+				return kanzi.ERR_INVALID_PARAM
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--segments=") && ctx == -1 {
+			strSegments := strings.TrimPrefix(arg, "--segments=")
+			var err error
+
+			if segments != 0 {
+				fmt.Printf("Warning: ignoring duplicate number of entropy segments: %v\n", strSegments)
+				continue
+			}
+
+			if segments, err = strconv.Atoi(strSegments); err != nil || segments < 1 || segments > 64 {
+				fmt.Printf("Invalid number of entropy segments provided on command line: %v\n", strSegments)
+				return kanzi.ERR_INVALID_PARAM
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--tpaq-memory=") && ctx == -1 {
+			strMemory := strings.TrimPrefix(arg, "--tpaq-memory=")
+			var err error
+
+			if tpaqMemory != 0 {
+				fmt.Printf("Warning: ignoring duplicate TPAQ memory budget: %v\n", strMemory)
+				continue
+			}
+
+			if tpaqMemory, err = strconv.Atoi(strMemory); err != nil || tpaqMemory < 16 || tpaqMemory > 1024 || tpaqMemory&(tpaqMemory-1) != 0 {
+				fmt.Printf("Invalid TPAQ memory budget provided on command line: %v\n", strMemory)
+				return kanzi.ERR_INVALID_PARAM
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--text-dict=") && ctx == -1 {
+			strDict := strings.TrimPrefix(arg, "--text-dict=")
+
+			if textDict != "" {
+				fmt.Printf("Warning: ignoring duplicate text dictionary: %v\n", strDict)
+				continue
+			}
+
+			if textDict = strDict; textDict == "" {
+				fmt.Println("Invalid empty text dictionary file name provided on command line")
+				return kanzi.ERR_INVALID_PARAM
+			}
+
+			continue
+		}
+
 		if strings.HasPrefix(arg, "--to=") && ctx == -1 {
 			var strTo string
 			var err error
@@ -733,6 +898,42 @@ func processCommandLine(args []string, argsMap map[string]interface{}) int {
 		argsMap["skipBlocks"] = skip
 	}
 
+	if tune == true {
+		argsMap["autoTune"] = tune
+	}
+
+	if warm == true {
+		argsMap["warmStart"] = warm
+	}
+
+	if warmROLZ == true {
+		argsMap["warmROLZ"] = warmROLZ
+	}
+
+	if syncMarkers == true {
+		argsMap["syncMarkers"] = syncMarkers
+	}
+
+	if recovery == true {
+		argsMap["recover"] = recovery
+	}
+
+	if interleave > 0 {
+		argsMap["ansInterleave"] = uint(interleave)
+	}
+
+	if segments > 0 {
+		argsMap["entropySegments"] = uint(segments)
+	}
+
+	if tpaqMemory > 0 {
+		argsMap["tpaqMemory"] = uint(tpaqMemory)
+	}
+
+	if len(textDict) > 0 {
+		argsMap["textDictionaryFile"] = textDict
+	}
+
 	argsMap["jobs"] = uint(tasks)
 
 	if len(cpuProf) > 0 {
@@ -0,0 +1,432 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/flanglet/kanzi-go/seekable"
+)
+
+// FS is a read-only file system over an archive (see io/fs). Opening a file
+// only reads the catalog: the content is decoded when it is read.
+// An FS is safe for concurrent use.
+type FS struct {
+	r     *seekable.Reader
+	files map[string]*fileInfo
+	dirs  map[string][]fs.DirEntry // sorted by name
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+// NewFS creates a new FS over an archive of 'size' bytes
+func NewFS(r io.ReaderAt, size int64) (*FS, error) {
+	sr, err := seekable.NewReader(r, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFSWithReader(sr)
+}
+
+// NewFSWithReader creates a new FS over an archive opened with the seekable
+// package (EG. with seekable.OpenURL for a remote archive)
+func NewFSWithReader(r *seekable.Reader) (*FS, error) {
+	if r.Size() < _ARCHIVE_TRAILER_SIZE {
+		return nil, errors.New("Invalid archive: missing catalog")
+	}
+
+	trailer, err := r.DecodeRange(nil, r.Size()-_ARCHIVE_TRAILER_SIZE, _ARCHIVE_TRAILER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(trailer[4:8]) != ARCHIVE_MAGIC {
+		return nil, errors.New("Invalid archive: incorrect signature")
+	}
+
+	end := r.Size() - _ARCHIVE_TRAILER_SIZE
+	catalogSize := int64(binary.BigEndian.Uint32(trailer[0:4]))
+
+	if catalogSize > end {
+		return nil, fmt.Errorf("Invalid archive: incorrect catalog size %d", catalogSize)
+	}
+
+	catalog, err := r.DecodeRange(nil, end-catalogSize, catalogSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &FS{r: r, files: make(map[string]*fileInfo), dirs: make(map[string][]fs.DirEntry)}
+
+	if err = this.readCatalog(catalog, end-catalogSize); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Parse the catalog and build the directory tree. The file contents
+// must end before 'end'.
+func (this *FS) readCatalog(catalog []byte, end int64) error {
+	idx := 0
+	var err error
+
+	readUvarint := func() uint64 {
+		v, n := binary.Uvarint(catalog[idx:])
+
+		if n <= 0 {
+			err = errors.New("Invalid archive: truncated catalog")
+			return 0
+		}
+
+		idx += n
+		return v
+	}
+
+	count := readUvarint()
+	this.dirs["."] = make([]fs.DirEntry, 0)
+
+	for i := uint64(0); i < count && err == nil; i++ {
+		nameLen := readUvarint()
+
+		if err != nil || nameLen > uint64(len(catalog)-idx) {
+			return errors.New("Invalid archive: truncated catalog")
+		}
+
+		name := string(catalog[idx : idx+int(nameLen)])
+		idx += int(nameLen)
+
+		if len(catalog)-idx < 4 {
+			return errors.New("Invalid archive: truncated catalog")
+		}
+
+		mode := fs.FileMode(binary.BigEndian.Uint32(catalog[idx:])) & fs.ModePerm
+		idx += 4
+		modTime, n := binary.Varint(catalog[idx:])
+
+		if n <= 0 {
+			return errors.New("Invalid archive: truncated catalog")
+		}
+
+		idx += n
+		offset := readUvarint()
+		size := readUvarint()
+
+		if err != nil {
+			return err
+		}
+
+		if offset > uint64(end) || size > uint64(end)-offset {
+			return fmt.Errorf("Invalid archive: incorrect location of '%v'", name)
+		}
+
+		if err = this.add(name, mode, time.Unix(0, modTime), int64(offset), int64(size)); err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entries := range this.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+
+	return nil
+}
+
+// Register a file and its implicit parent directories
+func (this *FS) add(name string, mode fs.FileMode, modTime time.Time, offset, size int64) error {
+	if fs.ValidPath(name) == false || name == "." {
+		return fmt.Errorf("Invalid archive: incorrect file name '%v'", name)
+	}
+
+	if _, exists := this.files[name]; exists == true {
+		return fmt.Errorf("Invalid archive: duplicate file name '%v'", name)
+	}
+
+	if _, exists := this.dirs[name]; exists == true {
+		return fmt.Errorf("Invalid archive: '%v' is a directory", name)
+	}
+
+	info := &fileInfo{name: name, mode: mode, modTime: modTime, offset: offset, size: size}
+	this.files[name] = info
+
+	for child, dir := fs.DirEntry(info), parent(name); ; dir = parent(dir) {
+		if _, exists := this.files[dir]; exists == true {
+			return fmt.Errorf("Invalid archive: '%v' is a file", dir)
+		}
+
+		entries, exists := this.dirs[dir]
+		this.dirs[dir] = append(entries, child)
+
+		// The parent was already registered with its own parents
+		if exists == true || dir == "." {
+			break
+		}
+
+		child = &fileInfo{name: dir, mode: fs.ModeDir | 0555, modTime: modTime}
+	}
+
+	return nil
+}
+
+// Open opens the named file or directory (see fs.FS)
+func (this *FS) Open(name string) (fs.File, error) {
+	if fs.ValidPath(name) == false {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if info := this.files[name]; info != nil {
+		return &file{info: info, r: io.NewSectionReader(this.r, info.offset, info.size)}, nil
+	}
+
+	if entries, exists := this.dirs[name]; exists == true {
+		return &dir{info: this.dirInfo(name), entries: entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the entries of the named directory sorted by name (see fs.ReadDirFS)
+func (this *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if fs.ValidPath(name) == false {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, exists := this.dirs[name]
+
+	if exists == false {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	res := make([]fs.DirEntry, len(entries))
+	copy(res, entries)
+	return res, nil
+}
+
+// ReadFile returns the content of the named file (see fs.ReadFileFS)
+func (this *FS) ReadFile(name string) ([]byte, error) {
+	if fs.ValidPath(name) == false {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	info := this.files[name]
+
+	if info == nil {
+		if _, exists := this.dirs[name]; exists == true {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+		}
+
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+
+	res, err := this.r.DecodeRange(make([]byte, 0, info.size), info.offset, info.size)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	return res, nil
+}
+
+// Stat returns the description of the named file or directory (see fs.StatFS)
+func (this *FS) Stat(name string) (fs.FileInfo, error) {
+	if fs.ValidPath(name) == false {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if info := this.files[name]; info != nil {
+		return info, nil
+	}
+
+	if _, exists := this.dirs[name]; exists == true {
+		return this.dirInfo(name), nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (this *FS) dirInfo(name string) *fileInfo {
+	if name == "." {
+		return &fileInfo{name: ".", mode: fs.ModeDir | 0555}
+	}
+
+	for _, e := range this.dirs[parent(name)] {
+		if info := e.(*fileInfo); info.name == name {
+			return info
+		}
+	}
+
+	return &fileInfo{name: name, mode: fs.ModeDir | 0555}
+}
+
+// fileInfo describes a file or a directory. It implements both fs.FileInfo
+// and fs.DirEntry.
+type fileInfo struct {
+	name    string // full path
+	mode    fs.FileMode
+	modTime time.Time
+	offset  int64
+	size    int64
+}
+
+func (this *fileInfo) Name() string {
+	for i := len(this.name) - 1; i >= 0; i-- {
+		if this.name[i] == '/' {
+			return this.name[i+1:]
+		}
+	}
+
+	return this.name
+}
+
+func (this *fileInfo) Size() int64 {
+	return this.size
+}
+
+func (this *fileInfo) Mode() fs.FileMode {
+	return this.mode
+}
+
+func (this *fileInfo) ModTime() time.Time {
+	return this.modTime
+}
+
+func (this *fileInfo) IsDir() bool {
+	return this.mode.IsDir()
+}
+
+func (this *fileInfo) Sys() interface{} {
+	return nil
+}
+
+func (this *fileInfo) Type() fs.FileMode {
+	return this.mode.Type()
+}
+
+func (this *fileInfo) Info() (fs.FileInfo, error) {
+	return this, nil
+}
+
+// file is an open file. It implements io.Seeker and io.ReaderAt.
+type file struct {
+	info   *fileInfo
+	r      *io.SectionReader
+	closed bool
+}
+
+func (this *file) Stat() (fs.FileInfo, error) {
+	return this.info, nil
+}
+
+func (this *file) Read(buf []byte) (int, error) {
+	if this.closed == true {
+		return 0, &fs.PathError{Op: "read", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	return this.r.Read(buf)
+}
+
+func (this *file) ReadAt(buf []byte, off int64) (int, error) {
+	if this.closed == true {
+		return 0, &fs.PathError{Op: "read", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	return this.r.ReadAt(buf, off)
+}
+
+func (this *file) Seek(offset int64, whence int) (int64, error) {
+	if this.closed == true {
+		return 0, &fs.PathError{Op: "seek", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	return this.r.Seek(offset, whence)
+}
+
+func (this *file) Close() error {
+	if this.closed == true {
+		return &fs.PathError{Op: "close", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	this.closed = true
+	return nil
+}
+
+// dir is an open directory
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	pos     int
+	closed  bool
+}
+
+func (this *dir) Stat() (fs.FileInfo, error) {
+	return this.info, nil
+}
+
+func (this *dir) Read(buf []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: this.info.name, Err: errors.New("is a directory")}
+}
+
+func (this *dir) Close() error {
+	if this.closed == true {
+		return &fs.PathError{Op: "close", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	this.closed = true
+	return nil
+}
+
+// ReadDir returns the next n entries (all remaining entries if n <= 0),
+// see fs.ReadDirFile
+func (this *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if this.closed == true {
+		return nil, &fs.PathError{Op: "readdir", Path: this.info.name, Err: fs.ErrClosed}
+	}
+
+	remaining := len(this.entries) - this.pos
+
+	if n <= 0 {
+		n = remaining
+	} else if remaining == 0 {
+		return nil, io.EOF
+	}
+
+	if n > remaining {
+		n = remaining
+	}
+
+	res := make([]fs.DirEntry, n)
+	copy(res, this.entries[this.pos:this.pos+n])
+	this.pos += n
+	return res, nil
+}
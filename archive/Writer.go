@@ -0,0 +1,212 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive implements a multi-file archive format on top of the
+// seekable format (see the seekable package): the decoded data is the
+// concatenation of the file contents followed by a catalog, so that any
+// file can be read without decoding the others.
+//
+// Layout of the decoded data (integers are varints unless specified):
+//
+//	file contents
+//	catalog: entry count, then for each entry: name length, name,
+//	         mode (32 bits, big endian), modification time (Unix ns,
+//	         signed varint), offset of the content, size of the content
+//	catalog size (32 bits, big endian), magic (32 bits, big endian)
+//
+// Names are slash separated paths as accepted by fs.ValidPath. Parent
+// directories are implicit.
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/flanglet/kanzi-go/seekable"
+)
+
+const (
+	// ARCHIVE_MAGIC is the signature at the end of the decoded data
+	ARCHIVE_MAGIC = uint32(0x4B4E5A41) // "KNZA"
+
+	_ARCHIVE_TRAILER_SIZE = 8
+)
+
+// FileHeader describes a file in the archive
+type FileHeader struct {
+	Name    string
+	Mode    fs.FileMode // permission bits only
+	ModTime time.Time
+}
+
+type entry struct {
+	FileHeader
+	offset int64
+	size   int64
+}
+
+// Writer creates an archive. Files are added one after the other with
+// Create or CreateHeader, then Close writes the catalog.
+type Writer struct {
+	w       *seekable.Writer
+	entries []entry
+	names   map[string]bool
+	dirs    map[string]bool
+	offset  int64
+	current *fileWriter
+	closed  bool
+}
+
+type fileWriter struct {
+	archive *Writer
+	entry   *entry
+}
+
+// NewWriter creates a new Writer using the provided pipeline string and
+// block size (see seekable.NewWriter)
+func NewWriter(w io.Writer, pipelineStr string, blockSize uint) (*Writer, error) {
+	sw, err := seekable.NewWriter(w, pipelineStr, blockSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Writer{w: sw}
+	this.entries = make([]entry, 0)
+	this.names = make(map[string]bool)
+	this.dirs = make(map[string]bool)
+	return this, nil
+}
+
+// Create adds a file with default mode (0644) and the current time. The
+// content must be written to the returned writer before the next call
+// to Create, CreateHeader or Close.
+func (this *Writer) Create(name string) (io.Writer, error) {
+	return this.CreateHeader(&FileHeader{Name: name, Mode: 0644, ModTime: time.Now()})
+}
+
+// CreateHeader adds a file described by the header. The content must be
+// written to the returned writer before the next call to Create,
+// CreateHeader or Close.
+func (this *Writer) CreateHeader(h *FileHeader) (io.Writer, error) {
+	if this.closed == true {
+		return nil, errors.New("Archive writer already closed")
+	}
+
+	if fs.ValidPath(h.Name) == false || h.Name == "." {
+		return nil, fmt.Errorf("Invalid file name: '%v'", h.Name)
+	}
+
+	if this.names[h.Name] == true {
+		return nil, fmt.Errorf("Duplicate file name: '%v'", h.Name)
+	}
+
+	// A file cannot also be a parent directory of another file
+	for dir := parent(h.Name); dir != "."; dir = parent(dir) {
+		if this.names[dir] == true {
+			return nil, fmt.Errorf("Invalid file name: '%v' ('%v' is a file)", h.Name, dir)
+		}
+	}
+
+	if this.dirs[h.Name] == true {
+		return nil, fmt.Errorf("Invalid file name: '%v' is a directory", h.Name)
+	}
+
+	this.names[h.Name] = true
+
+	for dir := parent(h.Name); dir != "."; dir = parent(dir) {
+		this.dirs[dir] = true
+	}
+
+	this.entries = append(this.entries, entry{FileHeader: *h, offset: this.offset})
+	this.entries[len(this.entries)-1].Mode &= fs.ModePerm
+	this.current = &fileWriter{archive: this, entry: &this.entries[len(this.entries)-1]}
+	return this.current, nil
+}
+
+func (this *fileWriter) Write(data []byte) (int, error) {
+	if this.archive.current != this {
+		return 0, errors.New("Archive file already closed")
+	}
+
+	n, err := this.archive.w.Write(data)
+	this.entry.size += int64(n)
+	this.archive.offset += int64(n)
+	return n, err
+}
+
+// Close writes the catalog and closes the seekable archive. The underlying
+// writer is not closed.
+func (this *Writer) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+	this.current = nil
+	catalog := make([]byte, 0, 64*len(this.entries))
+	catalog = appendUvarint(catalog, uint64(len(this.entries)))
+
+	for i := range this.entries {
+		e := &this.entries[i]
+		catalog = appendUvarint(catalog, uint64(len(e.Name)))
+		catalog = append(catalog, e.Name...)
+		catalog = append(catalog, byte(e.Mode>>24), byte(e.Mode>>16), byte(e.Mode>>8), byte(e.Mode))
+		catalog = appendVarint(catalog, e.ModTime.UnixNano())
+		catalog = appendUvarint(catalog, uint64(e.offset))
+		catalog = appendUvarint(catalog, uint64(e.size))
+	}
+
+	var trailer [_ARCHIVE_TRAILER_SIZE]byte
+	binary.BigEndian.PutUint32(trailer[0:4], uint32(len(catalog)))
+	binary.BigEndian.PutUint32(trailer[4:8], ARCHIVE_MAGIC)
+
+	if _, err := this.w.Write(catalog); err != nil {
+		return err
+	}
+
+	if _, err := this.w.Write(trailer[:]); err != nil {
+		return err
+	}
+
+	return this.w.Close()
+}
+
+func appendUvarint(dst []byte, val uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], val)
+	return append(dst, buf[0:n]...)
+}
+
+func appendVarint(dst []byte, val int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], val)
+	return append(dst, buf[0:n]...)
+}
+
+// Return the parent directory of a valid path ("." for top level names)
+func parent(name string) string {
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == '/' {
+			return name[0:i]
+		}
+	}
+
+	return "."
+}
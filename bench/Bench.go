@@ -0,0 +1,350 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bench is a harness measuring the compression ratio, speed and
+// memory usage of kanzi pipelines on standard corpora (Silesia, enwik8,
+// downloaded and cached on first use) or on local files. The results are
+// returned as values so that the command line tool, the regression tests
+// and external programs share the same measurements.
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// Config holds the parameters of a benchmark run
+type Config struct {
+	Pipelines  []string // pipeline strings (EG. "TEXT+BWT+RANK+ZRLT&ANS0")
+	BlockSize  uint     // defaults to 4 MB
+	Jobs       uint     // defaults to 1
+	Iterations int      // the best time of all iterations is kept, defaults to 1
+}
+
+// Result holds the measurements of one pipeline on one input
+type Result struct {
+	Name           string // name of the input (EG. file name)
+	Pipeline       string // canonical pipeline string
+	InputSize      int64
+	OutputSize     int64
+	CompressTime   time.Duration
+	DecompressTime time.Duration
+	Memory         uint64 // bytes allocated by one compression and decompression
+	Err            error
+}
+
+// Ratio returns the compression ratio (output size / input size)
+func (this Result) Ratio() float64 {
+	if this.InputSize == 0 {
+		return 0
+	}
+
+	return float64(this.OutputSize) / float64(this.InputSize)
+}
+
+// CompressSpeed returns the compression speed in MB/s
+func (this Result) CompressSpeed() float64 {
+	return speed(this.InputSize, this.CompressTime)
+}
+
+// DecompressSpeed returns the decompression speed in MB/s
+func (this Result) DecompressSpeed() float64 {
+	return speed(this.InputSize, this.DecompressTime)
+}
+
+func speed(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return float64(size) / (1024 * 1024) / d.Seconds()
+}
+
+// LevelPipelines returns the pipeline strings of compression levels [from..to]
+func LevelPipelines(from, to int) ([]string, error) {
+	res := make([]string, 0, to-from+1)
+
+	for level := from; level <= to; level++ {
+		desc, err := pipeline.ForLevel(level)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, desc.String())
+	}
+
+	return res, nil
+}
+
+// Run measures every pipeline of the configuration on the data
+func Run(name string, data []byte, cfg Config) []Result {
+	results := make([]Result, 0, len(cfg.Pipelines))
+
+	for _, p := range cfg.Pipelines {
+		results = append(results, runPipeline(name, data, p, cfg))
+	}
+
+	return results
+}
+
+// RunFiles measures every pipeline of the configuration on each file
+func RunFiles(files []string, cfg Config) ([]Result, error) {
+	results := make([]Result, 0, len(files)*len(cfg.Pipelines))
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, Run(filepath.Base(f), data, cfg)...)
+	}
+
+	return results, nil
+}
+
+func runPipeline(name string, data []byte, p string, cfg Config) (res Result) {
+	res = Result{Name: name, Pipeline: p, InputSize: int64(len(data))}
+	desc, err := pipeline.Parse(p)
+
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	res.Pipeline = desc.String()
+
+	defer func() {
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	blockSize := cfg.BlockSize
+
+	if blockSize == 0 {
+		blockSize = 4 * 1024 * 1024
+	}
+
+	jobs := cfg.Jobs
+
+	if jobs == 0 {
+		jobs = 1
+	}
+
+	iter := cfg.Iterations
+
+	if iter <= 0 {
+		iter = 1
+	}
+
+	ctx := map[string]interface{}{
+		"transform": desc.TransformName(),
+		"codec":     desc.Entropy,
+		"blockSize": blockSize,
+		"jobs":      jobs,
+		"checksum":  false,
+		"fileSize":  int64(len(data)),
+	}
+
+	var compressed, decompressed []byte
+
+	for i := 0; i < iter; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		if compressed, err = compress(data, ctx); err != nil {
+			res.Err = fmt.Errorf("Compression failed: %v", err)
+			return res
+		}
+
+		mid := time.Now()
+
+		if decompressed, err = decompress(compressed, jobs); err != nil {
+			res.Err = fmt.Errorf("Decompression failed: %v", err)
+			return res
+		}
+
+		end := time.Now()
+		runtime.ReadMemStats(&after)
+
+		if i == 0 || mid.Sub(start) < res.CompressTime {
+			res.CompressTime = mid.Sub(start)
+		}
+
+		if i == 0 || end.Sub(mid) < res.DecompressTime {
+			res.DecompressTime = end.Sub(mid)
+		}
+
+		res.Memory = after.TotalAlloc - before.TotalAlloc
+	}
+
+	res.OutputSize = int64(len(compressed))
+
+	if bytes.Equal(data, decompressed) == false {
+		res.Err = fmt.Errorf("Decompressed data differs from original data")
+	}
+
+	return res
+}
+
+func compress(data []byte, ctx map[string]interface{}) ([]byte, error) {
+	var bs util.BufferStream
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = cos.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, bs.Len())
+	bs.Read(res)
+	return res, nil
+}
+
+func decompress(data []byte, jobs uint) ([]byte, error) {
+	bs := util.NewBufferStream(data)
+	cis, err := kio.NewCompressedInputStreamWithCtx(bs, map[string]interface{}{"jobs": jobs})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	buf := make([]byte, 1024*1024)
+
+	for {
+		n, err := cis.Read(buf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			break
+		}
+
+		output.Write(buf[0:n])
+	}
+
+	if err = cis.Close(); err != nil {
+		return nil, err
+	}
+
+	return output.Bytes(), nil
+}
+
+// Total aggregates the results of each pipeline over all inputs (the inputs
+// are named "total"). Failed results are skipped.
+func Total(results []Result) []Result {
+	res := make([]Result, 0)
+	indexes := make(map[string]int)
+
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+
+		idx, ok := indexes[r.Pipeline]
+
+		if ok == false {
+			idx = len(res)
+			indexes[r.Pipeline] = idx
+			res = append(res, Result{Name: "total", Pipeline: r.Pipeline})
+		}
+
+		t := &res[idx]
+		t.InputSize += r.InputSize
+		t.OutputSize += r.OutputSize
+		t.CompressTime += r.CompressTime
+		t.DecompressTime += r.DecompressTime
+
+		if r.Memory > t.Memory {
+			t.Memory = r.Memory
+		}
+	}
+
+	return res
+}
+
+// WriteReport writes the results as a table, one line per result
+func WriteReport(w io.Writer, results []Result) error {
+	width := len("Input")
+
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+
+	header := fmt.Sprintf("%-*s  %-28s %12s %12s %7s %10s %10s %10s\n", width, "Input", "Pipeline",
+		"Size", "Compressed", "Ratio", "Comp MB/s", "Dec MB/s", "Alloc MB")
+
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		var line string
+
+		if r.Err != nil {
+			line = fmt.Sprintf("%-*s  %-28s %v\n", width, r.Name, r.Pipeline, r.Err)
+		} else {
+			line = fmt.Sprintf("%-*s  %-28s %12d %12d %7.3f %10.2f %10.2f %10.1f\n", width,
+				r.Name, r.Pipeline, r.InputSize, r.OutputSize, r.Ratio(), r.CompressSpeed(),
+				r.DecompressSpeed(), float64(r.Memory)/(1024*1024))
+		}
+
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParsePipelines splits a comma separated list of pipeline strings
+func ParsePipelines(str string) []string {
+	res := make([]string, 0)
+
+	for _, p := range strings.Split(str, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			res = append(res, p)
+		}
+	}
+
+	return res
+}
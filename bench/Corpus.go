@@ -0,0 +1,220 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bench
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// CORPUS_DIR_ENV is the environment variable overriding the cache directory
+	CORPUS_DIR_ENV = "KANZI_CORPUS_DIR"
+
+	_COMPLETE_MARKER = ".complete"
+)
+
+// Corpus is a set of reference files distributed as a zip archive
+type Corpus struct {
+	Name string
+	URL  string
+}
+
+// CORPORA lists the standard corpora known by the harness
+var CORPORA = map[string]Corpus{
+	"silesia": {Name: "silesia", URL: "https://sun.aei.polsl.pl/~sdeor/corpus/silesia.zip"},
+	"enwik8":  {Name: "enwik8", URL: "https://mattmahoney.net/dc/enwik8.zip"},
+}
+
+// GetCorpus returns the corpus with the provided name (case insensitive)
+func GetCorpus(name string) (Corpus, error) {
+	if c, ok := CORPORA[strings.ToLower(name)]; ok == true {
+		return c, nil
+	}
+
+	names := make([]string, 0, len(CORPORA))
+
+	for n := range CORPORA {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return Corpus{}, fmt.Errorf("Unknown corpus '%v' (must be one of %v)", name, strings.Join(names, ", "))
+}
+
+// CacheDir returns the directory where the corpora are cached: the value of
+// the KANZI_CORPUS_DIR environment variable if set, otherwise a kanzi
+// directory in the user cache directory.
+func CacheDir() (string, error) {
+	if dir := os.Getenv(CORPUS_DIR_ENV); len(dir) > 0 {
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Cannot locate the corpus cache directory: %v", err)
+	}
+
+	return filepath.Join(dir, "kanzi", "corpora"), nil
+}
+
+// Fetch returns the sorted paths of the files of the corpus. The archive is
+// downloaded and extracted into cacheDir the first time only.
+func (this Corpus) Fetch(cacheDir string) ([]string, error) {
+	dir := filepath.Join(cacheDir, this.Name)
+
+	if _, err := os.Stat(filepath.Join(dir, _COMPLETE_MARKER)); err != nil {
+		if err = this.download(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return ListFiles(dir)
+}
+
+func (this Corpus) download(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	archive := filepath.Join(dir, this.Name+".zip.part")
+	defer os.Remove(archive)
+	resp, err := http.Get(this.URL)
+
+	if err != nil {
+		return fmt.Errorf("Cannot download corpus %v: %v", this.Name, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cannot download corpus %v: %v", this.Name, resp.Status)
+	}
+
+	f, err := os.Create(archive)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("Cannot download corpus %v: %v", this.Name, err)
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = extract(archive, dir); err != nil {
+		return fmt.Errorf("Cannot extract corpus %v: %v", this.Name, err)
+	}
+
+	// Only a complete extraction makes the cache valid
+	return ioutil.WriteFile(filepath.Join(dir, _COMPLETE_MARKER), []byte(this.URL), 0644)
+}
+
+func extract(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	for _, f := range r.File {
+		path := filepath.Join(dir, f.Name)
+
+		// Reject entries escaping the target directory
+		if strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) == false {
+			return fmt.Errorf("Invalid file name in archive: %v", f.Name)
+		}
+
+		if f.FileInfo().IsDir() == true {
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err = extractFile(f, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractFile(f *zip.File, path string) error {
+	src, err := f.Open()
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+	dst, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// ListFiles returns the sorted paths of the regular files in 'path' and its
+// sub directories (or 'path' itself if it is a file). Hidden files are skipped.
+func ListFiles(path string) ([]string, error) {
+	res := make([]string, 0)
+
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() == true && strings.HasPrefix(info.Name(), ".") == false {
+			res = append(res, p)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(res)
+	return res, nil
+}
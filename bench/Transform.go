@@ -0,0 +1,149 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/flanglet/kanzi-go/function"
+)
+
+// Report holds the measurements of a transform chain (EG. "BWT+MTFT+ZRLT")
+// applied to one block, without entropy coding and stream framing
+type Report struct {
+	Transform     string // name of the chain
+	InputSize     int
+	OutputSize    int
+	SkipFlags     byte // stages skipped by the forward transform
+	Iterations    int
+	ForwardTime   time.Duration // best time of all iterations
+	InverseTime   time.Duration // best time of all iterations
+	ForwardAllocs uint64        // heap allocations of one forward transform
+	ForwardBytes  uint64        // bytes allocated by one forward transform
+	InverseAllocs uint64        // heap allocations of one inverse transform
+	InverseBytes  uint64        // bytes allocated by one inverse transform
+	Err           error
+}
+
+// Ratio returns the transform ratio (output size / input size)
+func (this Report) Ratio() float64 {
+	if this.InputSize == 0 {
+		return 0
+	}
+
+	return float64(this.OutputSize) / float64(this.InputSize)
+}
+
+// ForwardSpeed returns the speed of the forward transform in MB/s
+func (this Report) ForwardSpeed() float64 {
+	return speed(int64(this.InputSize), this.ForwardTime)
+}
+
+// InverseSpeed returns the speed of the inverse transform in MB/s
+func (this Report) InverseSpeed() float64 {
+	return speed(int64(this.InputSize), this.InverseTime)
+}
+
+// RunTransform applies the forward then the inverse transform chain to the
+// corpus (one block) 'iterations' times and checks the round trip. A new
+// chain is created for each run, as the compressed streams do for each block.
+func RunTransform(transformName string, corpus []byte, iterations int) (res Report) {
+	res = Report{Transform: transformName, InputSize: len(corpus), Iterations: iterations}
+
+	if res.Iterations <= 0 {
+		res.Iterations = 1
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	ctx := map[string]interface{}{"size": uint(len(corpus))}
+	seq, err := function.NewChainWithCtx(&ctx, transformName)
+
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	// The transforms use their input as a work buffer: the corpus is copied
+	output := make([]byte, seq.MaxEncodedLen(len(corpus)))
+	input := make([]byte, len(corpus))
+	reverse := make([]byte, len(output))
+	var before, after runtime.MemStats
+
+	for i := 0; i < res.Iterations; i++ {
+		if i > 0 {
+			seq, _ = function.NewChainWithCtx(&ctx, transformName)
+		}
+
+		copy(input, corpus)
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		_, dstIdx, err := seq.Forward(input, output)
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		if err != nil {
+			res.Err = fmt.Errorf("Forward transform failed: %v", err)
+			return res
+		}
+
+		if i == 0 || elapsed < res.ForwardTime {
+			res.ForwardTime = elapsed
+		}
+
+		res.ForwardAllocs = after.Mallocs - before.Mallocs
+		res.ForwardBytes = after.TotalAlloc - before.TotalAlloc
+		res.OutputSize = int(dstIdx)
+		res.SkipFlags = seq.SkipFlags()
+
+		// The inverse transform gets the skip flags of the forward transform
+		seq, _ = function.NewChainWithCtx(&ctx, transformName)
+		seq.SetSkipFlags(res.SkipFlags)
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start = time.Now()
+		_, n, err := seq.Inverse(output[0:dstIdx], reverse)
+		elapsed = time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		if err != nil {
+			res.Err = fmt.Errorf("Inverse transform failed: %v", err)
+			return res
+		}
+
+		if i == 0 || elapsed < res.InverseTime {
+			res.InverseTime = elapsed
+		}
+
+		res.InverseAllocs = after.Mallocs - before.Mallocs
+		res.InverseBytes = after.TotalAlloc - before.TotalAlloc
+
+		if int(n) != len(corpus) || bytes.Equal(corpus, reverse[0:n]) == false {
+			res.Err = fmt.Errorf("Inverse transform differs from original data")
+			return res
+		}
+	}
+
+	return res
+}
@@ -38,6 +38,60 @@ func BenchmarkBWTS(b *testing.B) {
 	}
 }
 
+// Inverse of an 8 MB block, with the indexes scattered directly or
+// partitioned by symbol group (see transform.BWT)
+func BenchmarkBWTInverse(b *testing.B) {
+	benchmarkBWTInverse(b, false)
+}
+
+func BenchmarkBWTInversePartitioned(b *testing.B) {
+	benchmarkBWTInverse(b, true)
+}
+
+func benchmarkBWTInverse(b *testing.B, partitioned bool) {
+	size := 8 * 1024 * 1024
+	buf1 := make([]byte, size)
+	buf2 := make([]byte, size)
+	buf3 := make([]byte, size)
+	rnd := rand.New(rand.NewSource(12345))
+
+	for i := range buf1 {
+		buf1[i] = byte(rnd.Intn(255) + 1)
+	}
+
+	ctx := map[string]interface{}{"jobs": uint(1)}
+	bwt, _ := transform.NewBWTWithCtx(&ctx)
+
+	if _, _, err := bwt.Forward(buf1, buf2); err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+
+	if partitioned == true {
+		ctx["bwtPartitionMin"] = uint(size)
+	}
+
+	inv, _ := transform.NewBWTWithCtx(&ctx)
+
+	for i := 0; i < bwt.Chunks(size); i++ {
+		inv.SetPrimaryIndex(i, bwt.PrimaryIndex(i))
+	}
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := inv.Inverse(buf2, buf3); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+
+	b.StopTimer()
+
+	if string(buf1) != string(buf3) {
+		b.Errorf("Different inverse")
+	}
+}
+
 func testBWTSpeed(isBWT bool) error {
 	iter := 10
 	size := 256 * 1024
@@ -229,6 +229,74 @@ func BenchmarkHuffman(b *testing.B) {
 	}
 }
 
+func BenchmarkAdaptiveHuffman(b *testing.B) {
+	repeats := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
+
+	for jj := 0; jj < 3; jj++ {
+		iter := b.N
+		size := 50000
+		values1 := make([]byte, size)
+		values2 := make([]byte, size)
+		rand.Seed(int64(jj))
+		var bs util.BufferStream
+
+		for ii := 0; ii < iter; ii++ {
+			idx := jj
+
+			for i := 0; i < size; i++ {
+				i0 := i
+
+				length := repeats[idx]
+				idx = (idx + 1) & 0x0F
+				b := byte(rand.Intn(256))
+
+				if i0+length >= size {
+					length = size - i0 - 1
+				}
+
+				for j := i0; j < i0+length; j++ {
+					values1[j] = b
+					i++
+				}
+			}
+
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, uint(size))
+			ec, _ := entropy.NewAdaptiveHuffmanEncoder(obs)
+
+			// Encode
+			if _, err := ec.Write(values1); err != nil {
+				msg := fmt.Sprintf("An error occurred during encoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ec.Dispose()
+
+			if _, err := obs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, uint(size))
+			ed, _ := entropy.NewAdaptiveHuffmanDecoder(ibs)
+
+			// Decode
+			if _, err := ed.Read(values2); err != nil {
+				msg := fmt.Sprintf("An error occurred during decoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ed.Dispose()
+
+			if _, err := ibs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+		}
+
+		bs.Close()
+	}
+}
+
 func BenchmarkANS0(b *testing.B) {
 	repeats := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
 
@@ -297,6 +365,142 @@ func BenchmarkANS0(b *testing.B) {
 	}
 }
 
+func BenchmarkANS0Interleaved(b *testing.B) {
+	repeats := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
+
+	for jj := 0; jj < 3; jj++ {
+		iter := b.N
+		size := 50000
+		values1 := make([]byte, size)
+		values2 := make([]byte, size)
+		rand.Seed(int64(jj))
+		var bs util.BufferStream
+
+		for ii := 0; ii < iter; ii++ {
+			idx := jj
+
+			for i := 0; i < size; i++ {
+				i0 := i
+
+				length := repeats[idx]
+				idx = (idx + 1) & 0x0F
+				b := byte(rand.Intn(256))
+
+				if i0+length >= size {
+					length = size - i0 - 1
+				}
+
+				for j := i0; j < i0+length; j++ {
+					values1[j] = b
+					i++
+				}
+			}
+
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, uint(size))
+			ec, _ := entropy.NewANSRangeEncoder(obs, 0, 32768, 12, 4)
+
+			// Encode
+			if _, err := ec.Write(values1); err != nil {
+				msg := fmt.Sprintf("An error occurred during encoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ec.Dispose()
+
+			if _, err := obs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, uint(size))
+			ed, _ := entropy.NewANSRangeDecoder(ibs, 0, 32768, 4)
+
+			// Decode
+			if _, err := ed.Read(values2); err != nil {
+				msg := fmt.Sprintf("An error occurred during decoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ed.Dispose()
+
+			if _, err := ibs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+		}
+
+		bs.Close()
+	}
+}
+
+func BenchmarkFSE(b *testing.B) {
+	repeats := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
+
+	for jj := 0; jj < 3; jj++ {
+		iter := b.N
+		size := 50000
+		values1 := make([]byte, size)
+		values2 := make([]byte, size)
+		rand.Seed(int64(jj))
+		var bs util.BufferStream
+
+		for ii := 0; ii < iter; ii++ {
+			idx := jj
+
+			for i := 0; i < size; i++ {
+				i0 := i
+
+				length := repeats[idx]
+				idx = (idx + 1) & 0x0F
+				b := byte(rand.Intn(256))
+
+				if i0+length >= size {
+					length = size - i0 - 1
+				}
+
+				for j := i0; j < i0+length; j++ {
+					values1[j] = b
+					i++
+				}
+			}
+
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, uint(size))
+			ec, _ := entropy.NewFSEEncoder(obs)
+
+			// Encode
+			if _, err := ec.Write(values1); err != nil {
+				msg := fmt.Sprintf("An error occurred during encoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ec.Dispose()
+
+			if _, err := obs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, uint(size))
+			ed, _ := entropy.NewFSEDecoder(ibs)
+
+			// Decode
+			if _, err := ed.Read(values2); err != nil {
+				msg := fmt.Sprintf("An error occurred during decoding: %v\n", err)
+				b.Fatalf(msg)
+			}
+
+			ed.Dispose()
+
+			if _, err := ibs.Close(); err != nil {
+				msg := fmt.Sprintf("Error during close: %v\n", err)
+				b.Fatalf(msg)
+			}
+		}
+
+		bs.Close()
+	}
+}
+
 func BenchmarkANS1(b *testing.B) {
 	repeats := []int{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3}
 
@@ -236,6 +236,33 @@ func BenchmarkRLT(b *testing.B) {
 	}
 }
 
+// Forward transforms on sparse data (mostly long runs of zeros)
+func BenchmarkRunsSparse(b *testing.B) {
+	size := 1000000
+	input := make([]byte, size)
+	rand.Seed(0)
+
+	for i := 0; i < size/1000; i++ {
+		input[rand.Intn(size)] = byte(1 + rand.Intn(255))
+	}
+
+	rlt, _ := function.NewRLT()
+	zrlt, _ := function.NewZRLT()
+	output := make([]byte, rlt.MaxEncodedLen(size)+zrlt.MaxEncodedLen(size))
+	b.SetBytes(int64(2 * size))
+	b.ResetTimer()
+
+	for ii := 0; ii < b.N; ii++ {
+		if _, _, err := rlt.Forward(input, output); err != nil {
+			b.Fatalf("Encoding error : %v\n", err)
+		}
+
+		if _, _, err := zrlt.Forward(input, output); err != nil {
+			b.Fatalf("Encoding error : %v\n", err)
+		}
+	}
+}
+
 func BenchmarkROLZ(b *testing.B) {
 	iter := b.N
 	size := 50000
@@ -0,0 +1,49 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmark
+
+import (
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func BenchmarkSquashStretch(b *testing.B) {
+	iter := 50000000
+	res := 0
+
+	for i := 0; i < iter; i++ {
+		// Walk through the tables in a cache unfriendly order
+		res += kanzi.Squash(kanzi.Stretch((i*2654435761)&4095) - 1024 + (i & 2047))
+	}
+
+	if res == 0 {
+		b.Errorf("Incorrect result for Squash/Stretch")
+	}
+}
+
+func BenchmarkLog2(b *testing.B) {
+	iter := 50000000
+	res := uint32(0)
+
+	for i := 0; i < iter; i++ {
+		res += kanzi.Log2NoCheck(uint32(i*2654435761) | 1)
+	}
+
+	if res == 0 {
+		b.Errorf("Incorrect result for Log2")
+	}
+}
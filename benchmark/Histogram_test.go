@@ -0,0 +1,80 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package benchmark
+
+import (
+	"math/rand"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+const (
+	_HISTO_RANDOM   = 0
+	_HISTO_TEXT     = 1
+	_HISTO_CONSTANT = 2
+)
+
+func BenchmarkHistogramRandom(b *testing.B) {
+	benchmarkHistogram(b, _HISTO_RANDOM)
+}
+
+func BenchmarkHistogramText(b *testing.B) {
+	benchmarkHistogram(b, _HISTO_TEXT)
+}
+
+func BenchmarkHistogramConstant(b *testing.B) {
+	benchmarkHistogram(b, _HISTO_CONSTANT)
+}
+
+func benchmarkHistogram(b *testing.B, kind int) {
+	buffer := make([]byte, 1024*1024)
+	rnd := rand.New(rand.NewSource(12345))
+
+	for i := range buffer {
+		switch kind {
+		case _HISTO_RANDOM:
+			buffer[i] = byte(rnd.Intn(256))
+		case _HISTO_TEXT:
+			// Skewed distribution of printable characters with spaces
+			if rnd.Intn(6) == 0 {
+				buffer[i] = ' '
+			} else {
+				buffer[i] = byte(97 + rnd.Intn(8)*rnd.Intn(4))
+			}
+		default:
+			buffer[i] = 65
+		}
+	}
+
+	freqs := make([]int, 257)
+	b.SetBytes(int64(len(buffer)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		kanzi.ComputeHistogram(buffer, freqs, true, false)
+	}
+
+	sum := 0
+
+	for i := 0; i < 256; i++ {
+		sum += freqs[i]
+	}
+
+	if sum != len(buffer) {
+		b.Errorf("Incorrect histogram")
+	}
+}
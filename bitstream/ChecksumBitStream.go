@@ -0,0 +1,263 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// The checksum bitstreams compute a CRC32-C (Castagnoli) of all the bits
+// written to or read from a bitstream delegate, in bitstream order (most
+// significant bit first). After a multiple of 8 bits, the checksum is the
+// CRC32-C of the bytes of the bitstream. Otherwise, the last bits are padded
+// with zeros and followed by a byte holding their number. Writing and reading
+// the same bits give the same checksum, whatever the calls used.
+
+var _CRC32C_TABLE = crc32.MakeTable(crc32.Castagnoli)
+
+// bitHasher computes the CRC of a sequence of bits, 64 bits at a time
+type bitHasher struct {
+	crc  uint32
+	acc  uint64 // bits not hashed yet
+	size uint   // number of bits in 'acc' (less than 64)
+	buf  [8]byte
+}
+
+func (this *bitHasher) reset() {
+	this.crc = 0
+	this.acc = 0
+	this.size = 0
+}
+
+// count in [1..64]
+func (this *bitHasher) addBits(value uint64, count uint) {
+	value &= (0xFFFFFFFFFFFFFFFF >> (64 - count))
+
+	if this.size+count < 64 {
+		this.acc = (this.acc << count) | value
+		this.size += count
+		return
+	}
+
+	n := 64 - this.size
+	binary.BigEndian.PutUint64(this.buf[:], (this.acc<<n)|(value>>(count-n)))
+	this.crc = crc32.Update(this.crc, _CRC32C_TABLE, this.buf[:])
+	this.size = count - n
+	this.acc = value & ((1 << this.size) - 1)
+}
+
+func (this *bitHasher) addBytes(data []byte) {
+	if this.size&7 == 0 {
+		// Byte aligned: hash the bytes directly
+		if this.size != 0 {
+			binary.BigEndian.PutUint64(this.buf[:], this.acc<<(64-this.size))
+			this.crc = crc32.Update(this.crc, _CRC32C_TABLE, this.buf[0:this.size>>3])
+			this.acc = 0
+			this.size = 0
+		}
+
+		this.crc = crc32.Update(this.crc, _CRC32C_TABLE, data)
+		return
+	}
+
+	for len(data) >= 8 {
+		this.addBits(binary.BigEndian.Uint64(data), 64)
+		data = data[8:]
+	}
+
+	for _, b := range data {
+		this.addBits(uint64(b), 8)
+	}
+}
+
+// Add the first 'count' bits of the slice
+func (this *bitHasher) addArray(bits []byte, count uint) {
+	this.addBytes(bits[0 : count>>3])
+
+	if r := count & 7; r != 0 {
+		this.addBits(uint64(bits[count>>3]>>(8-r)), r)
+	}
+}
+
+func (this *bitHasher) sum() uint32 {
+	binary.BigEndian.PutUint64(this.buf[:], this.acc<<(64-this.size))
+	res := crc32.Update(this.crc, _CRC32C_TABLE, this.buf[0:(this.size+7)>>3])
+
+	if this.size&7 != 0 {
+		res = crc32.Update(res, _CRC32C_TABLE, []byte{byte(this.size & 7)})
+	}
+
+	return res
+}
+
+// ChecksumOutputBitStream is an OutputBitStream computing the checksum of
+// the bits written to the delegate.
+type ChecksumOutputBitStream struct {
+	delegate kanzi.OutputBitStream
+	hasher   bitHasher
+}
+
+// NewChecksumOutputBitStream creates a ChecksumOutputBitStream wrapped around
+// 'obs'. All calls are delegated to the 'obs' OutputBitStream.
+func NewChecksumOutputBitStream(obs kanzi.OutputBitStream) (*ChecksumOutputBitStream, error) {
+	if obs == nil {
+		return nil, errors.New("The delegate cannot be null")
+	}
+
+	this := new(ChecksumOutputBitStream)
+	this.delegate = obs
+	return this, nil
+}
+
+// WriteBit writes the least significant bit of the input integer
+// Panics if closed or an IO error is received.
+func (this *ChecksumOutputBitStream) WriteBit(bit int) {
+	this.delegate.WriteBit(bit)
+	this.hasher.addBits(uint64(bit), 1)
+}
+
+// WriteBits writes the least significant bits of 'bits' to the bitstream.
+// Length is the number of bits to write (in [1..64]).
+// Returns the number of bits written.
+// Panics if closed or an IO error is received.
+func (this *ChecksumOutputBitStream) WriteBits(bits uint64, length uint) uint {
+	res := this.delegate.WriteBits(bits, length)
+	this.hasher.addBits(bits, length)
+	return res
+}
+
+// WriteArray writes bits out of the byte slice. Length is the number of bits.
+// Returns the number of bits written.
+// Panics if closed or an IO error is received.
+func (this *ChecksumOutputBitStream) WriteArray(bits []byte, count uint) uint {
+	res := this.delegate.WriteArray(bits, count)
+
+	if count > 0 {
+		this.hasher.addArray(bits, count)
+	}
+
+	return res
+}
+
+// Close makes the bitstream unavailable for further writes.
+// The checksum is still available.
+func (this *ChecksumOutputBitStream) Close() (bool, error) {
+	return this.delegate.Close()
+}
+
+// Written returns the number of bits written
+func (this *ChecksumOutputBitStream) Written() uint64 {
+	return this.delegate.Written()
+}
+
+// Checksum returns the checksum of the bits written so far (or since the
+// last call to ResetChecksum)
+func (this *ChecksumOutputBitStream) Checksum() uint32 {
+	return this.hasher.sum()
+}
+
+// ResetChecksum restarts the checksum computation (EG. for each section of a
+// container)
+func (this *ChecksumOutputBitStream) ResetChecksum() {
+	this.hasher.reset()
+}
+
+// ChecksumInputBitStream is an InputBitStream computing the checksum of
+// the bits read from the delegate.
+type ChecksumInputBitStream struct {
+	delegate kanzi.InputBitStream
+	hasher   bitHasher
+}
+
+// NewChecksumInputBitStream creates a ChecksumInputBitStream wrapped around
+// 'ibs'. All calls are delegated to the 'ibs' InputBitStream.
+func NewChecksumInputBitStream(ibs kanzi.InputBitStream) (*ChecksumInputBitStream, error) {
+	if ibs == nil {
+		return nil, errors.New("The delegate cannot be null")
+	}
+
+	this := new(ChecksumInputBitStream)
+	this.delegate = ibs
+	return this, nil
+}
+
+// ReadBit returns the next bit in the bitstream. Panics if closed or EOS is reached.
+func (this *ChecksumInputBitStream) ReadBit() int {
+	res := this.delegate.ReadBit()
+	this.hasher.addBits(uint64(res), 1)
+	return res
+}
+
+// ReadBits reads 'length' (in [1..64]) bits from the bitstream .
+// Returns the bits read as an uint64.
+// Panics if closed or EOS is reached.
+func (this *ChecksumInputBitStream) ReadBits(length uint) uint64 {
+	res := this.delegate.ReadBits(length)
+	this.hasher.addBits(res, length)
+	return res
+}
+
+// PeekBits returns the next 'length' (in [1..64]) bits of the bitstream
+// without consuming them (they are not added to the checksum).
+// Panics if closed.
+func (this *ChecksumInputBitStream) PeekBits(length uint) uint64 {
+	return this.delegate.PeekBits(length)
+}
+
+// ReadArray reads 'length' bits from the bitstream and put them in the byte slice.
+// Returns the number of bits read.
+// Panics if closed or EOS is reached.
+func (this *ChecksumInputBitStream) ReadArray(bits []byte, count uint) uint {
+	res := this.delegate.ReadArray(bits, count)
+
+	if count > 0 {
+		this.hasher.addArray(bits, count)
+	}
+
+	return res
+}
+
+// Close makes the bitstream unavailable for further reads.
+// The checksum is still available.
+func (this *ChecksumInputBitStream) Close() (bool, error) {
+	return this.delegate.Close()
+}
+
+// Read returns the number of bits read
+func (this *ChecksumInputBitStream) Read() uint64 {
+	return this.delegate.Read()
+}
+
+// HasMoreToRead returns false when the bitstream is closed or the EOS has been reached
+func (this *ChecksumInputBitStream) HasMoreToRead() (bool, error) {
+	return this.delegate.HasMoreToRead()
+}
+
+// Checksum returns the checksum of the bits read so far (or since the last
+// call to ResetChecksum)
+func (this *ChecksumInputBitStream) Checksum() uint32 {
+	return this.hasher.sum()
+}
+
+// ResetChecksum restarts the checksum computation (EG. for each section of a
+// container)
+func (this *ChecksumInputBitStream) ResetChecksum() {
+	this.hasher.reset()
+}
@@ -143,6 +143,13 @@ func (this *DebugInputBitStream) ReadBits(length uint) uint64 {
 	return res
 }
 
+// PeekBits returns the next 'length' (in [1..64]) bits of the bitstream
+// without consuming them (nothing is logged). Panics if closed.
+// Calls PeekBits() on the underlying bitstream delegate.
+func (this *DebugInputBitStream) PeekBits(length uint) uint64 {
+	return this.delegate.PeekBits(length)
+}
+
 // ReadArray reads 'length' bits from the bitstream and put them in the byte slice.
 // Returns the number of bits read.
 // Panics if closed or EOS is reached.
@@ -218,6 +225,27 @@ func (this *DebugInputBitStream) Read() uint64 {
 	return this.delegate.Read()
 }
 
+// Position returns the bit position of the next bit to read
+// Calls Position() on the underlying bitstream delegate if it is seekable,
+// Read() otherwise.
+func (this *DebugInputBitStream) Position() uint64 {
+	if sbs, isSeekable := this.delegate.(kanzi.SeekableInputBitStream); isSeekable == true {
+		return sbs.Position()
+	}
+
+	return this.delegate.Read()
+}
+
+// Seek moves the bitstream to the provided bit position.
+// Calls Seek() on the underlying bitstream delegate if it is seekable.
+func (this *DebugInputBitStream) Seek(bitPos uint64) error {
+	if sbs, isSeekable := this.delegate.(kanzi.SeekableInputBitStream); isSeekable == true {
+		return sbs.Seek(bitPos)
+	}
+
+	return errors.New("The bitstream delegate cannot seek")
+}
+
 // Mark sets the internal mark state. When true. displays 'r'
 // after each bit  or bit sequence read from the bitstream delegate.
 func (this *DebugInputBitStream) Mark(mark bool) {
@@ -208,6 +208,27 @@ func (this *DebugOutputBitStream) Written() uint64 {
 	return this.delegate.Written()
 }
 
+// Position returns the bit position of the next bit to write
+// Calls Position() on the underlying bitstream delegate if it is seekable,
+// Written() otherwise.
+func (this *DebugOutputBitStream) Position() uint64 {
+	if sbs, isSeekable := this.delegate.(kanzi.SeekableOutputBitStream); isSeekable == true {
+		return sbs.Position()
+	}
+
+	return this.delegate.Written()
+}
+
+// Seek moves the bitstream to the provided bit position.
+// Calls Seek() on the underlying bitstream delegate if it is seekable.
+func (this *DebugOutputBitStream) Seek(bitPos uint64) error {
+	if sbs, isSeekable := this.delegate.(kanzi.SeekableOutputBitStream); isSeekable == true {
+		return sbs.Seek(bitPos)
+	}
+
+	return errors.New("The bitstream delegate cannot seek")
+}
+
 // Mark sets the internal mark state. When true. displays 'w'
 // after each bit  or bit sequence read from the bitstream delegate.
 func (this *DebugOutputBitStream) Mark(mark bool) {
@@ -26,12 +26,14 @@ import (
 type DefaultInputBitStream struct {
 	closed      bool
 	read        uint64
-	position    int  // index of current byte (consumed if bitIndex == -1)
-	availBits   uint // bits not consumed in current
-	is          io.ReadCloser
+	position    int           // index of current byte (consumed if bitIndex == -1)
+	availBits   uint          // bits not consumed in current
+	is          io.ReadCloser // nil if the bitstream is backed by a slice
 	buffer      []byte
 	maxPosition int
-	current     uint64 // cached bits
+	current     uint64    // cached bits
+	seeker      io.Seeker // nil if the underlying stream cannot seek
+	origin      int64     // offset of the bitstream in the underlying stream
 }
 
 // NewDefaultInputBitStream creates a bitstream for reading, using the provided stream as
@@ -58,9 +60,28 @@ func NewDefaultInputBitStream(stream io.ReadCloser, bufferSize uint) (*DefaultIn
 	this.is = stream
 	this.availBits = 0
 	this.maxPosition = -1
+
+	if seeker, isSeeker := stream.(io.Seeker); isSeeker == true {
+		// Pipes and terminals fail to seek: not seekable
+		if origin, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			this.seeker = seeker
+			this.origin = origin
+		}
+	}
+
 	return this, nil
 }
 
+// NewSliceInputBitStream creates a bitstream reading the provided slice
+// directly (no internal buffer, no copy).
+func NewSliceInputBitStream(data []byte) *DefaultInputBitStream {
+	this := new(DefaultInputBitStream)
+	this.buffer = data
+	this.availBits = 0
+	this.maxPosition = len(data) - 1
+	return this
+}
+
 // ReadBit returns the next bit
 func (this *DefaultInputBitStream) ReadBit() int {
 	if this.availBits == 0 {
@@ -89,10 +110,87 @@ func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 	count -= this.availBits
 	res := this.current & (0xFFFFFFFFFFFFFFFF >> (64 - this.availBits))
 	this.pullCurrent()
+
+	for count > this.availBits {
+		// Short read from the underlying stream
+		count -= this.availBits
+		res = (res << this.availBits) | (this.current & (0xFFFFFFFFFFFFFFFF >> (64 - this.availBits)))
+		this.pullCurrent()
+	}
+
 	this.availBits -= count
 	return (res << count) | (this.current >> this.availBits)
 }
 
+// PeekBits returns the next 'count' bits from the stream as an uint64 without
+// consuming them. The bits past the end of the stream are zeros (they cannot
+// be read). It panics if the count is outside of the [1..64] range or the
+// stream is closed.
+func (this *DefaultInputBitStream) PeekBits(count uint) uint64 {
+	if count == 0 || count > 64 {
+		panic(fmt.Errorf("Invalid bit count: %d (must be in [1..64])", count))
+	}
+
+	if count <= this.availBits {
+		return (this.current >> (this.availBits - count)) & (0xFFFFFFFFFFFFFFFF >> (64 - count))
+	}
+
+	if this.Closed() {
+		panic(errors.New("Stream closed"))
+	}
+
+	// Bits left in 'current' followed by the next bytes of the buffer
+	res := this.current & (0xFFFFFFFFFFFFFFFF >> (64 - this.availBits))
+	missing := count - this.availBits
+
+	if this.position+8 > this.maxPosition+1 {
+		this.fillBuffer()
+	}
+
+	next := uint64(0)
+
+	if this.position+8 <= this.maxPosition+1 {
+		next = binary.BigEndian.Uint64(this.buffer[this.position : this.position+8])
+	} else {
+		// End of stream
+		shift := uint(56)
+
+		for _, b := range this.buffer[this.position : this.maxPosition+1] {
+			next |= (uint64(b) << shift)
+			shift -= 8
+		}
+	}
+
+	return (res << missing) | (next >> (64 - missing))
+}
+
+// Move the bytes not consumed to the start of the buffer and read more bytes
+// from the underlying stream (to get at least 8 bytes if possible).
+// Errors are ignored: the next read returns them.
+func (this *DefaultInputBitStream) fillBuffer() {
+	if this.is == nil {
+		return
+	}
+
+	remaining := this.maxPosition + 1 - this.position
+	copy(this.buffer, this.buffer[this.position:this.maxPosition+1])
+	this.read += uint64(this.position) << 3
+	this.position = 0
+	this.maxPosition = remaining - 1
+
+	for this.maxPosition < 7 {
+		size, err := this.is.Read(this.buffer[this.maxPosition+1:])
+
+		if size > 0 {
+			this.maxPosition += size
+		}
+
+		if err != nil || size <= 0 {
+			break
+		}
+	}
+}
+
 // ReadArray reads 'count' bits from the stream and returns them to the 'bits'
 // slice. It panics if the stream is closed or the number of bits to read exceeds
 // the length of the 'bits' slice. Returns the number of bits read.
@@ -127,6 +225,18 @@ func (this *DefaultInputBitStream) ReadArray(bits []byte, count uint) uint {
 			start += (this.maxPosition + 1 - this.position)
 			remaining -= ((this.maxPosition + 1 - this.position) << 3)
 
+			if remaining>>3 >= len(this.buffer) && this.is != nil {
+				// Large aligned block: read it from the underlying stream
+				// directly instead of copying it from the internal buffer
+				n := this.readDirect(bits[start : start+(remaining>>3)])
+				start += n
+				remaining -= (n << 3)
+
+				if remaining < 8 {
+					break
+				}
+			}
+
 			if _, err := this.readFromInputStream(len(this.buffer)); err != nil {
 				panic(err)
 			}
@@ -142,13 +252,21 @@ func (this *DefaultInputBitStream) ReadArray(bits []byte, count uint) uint {
 		}
 	} else {
 		// Not byte aligned
-		r := 64 - this.availBits
-
 		for remaining >= 64 {
-			v := this.current & ((uint64(1) << this.availBits) - 1)
-			this.pullCurrent()
-			this.availBits -= r
-			binary.BigEndian.PutUint64(bits[start:start+8], (v<<uint(r))|(this.current>>uint(this.availBits)))
+			if this.position+8 > this.maxPosition+1 {
+				// End of buffer
+				binary.BigEndian.PutUint64(bits[start:start+8], this.ReadBits(64))
+				start += 8
+				remaining -= 64
+				continue
+			}
+
+			// The 'availBits' bits of 'current' followed by the next bits of
+			// the buffer ('availBits' is the same after the shift)
+			next := binary.BigEndian.Uint64(this.buffer[this.position : this.position+8])
+			binary.BigEndian.PutUint64(bits[start:start+8], (this.current<<(64-this.availBits))|(next>>this.availBits))
+			this.current = next
+			this.position += 8
 			start += 8
 			remaining -= 64
 		}
@@ -168,11 +286,49 @@ func (this *DefaultInputBitStream) ReadArray(bits []byte, count uint) uint {
 	return count
 }
 
+// Read the bytes from the underlying stream to the provided slice once the
+// internal buffer has been consumed. Returns the number of bytes read.
+// Panics if the stream is closed or no byte can be read.
+func (this *DefaultInputBitStream) readDirect(bits []byte) int {
+	if this.Closed() {
+		panic(errors.New("Stream closed"))
+	}
+
+	this.read += uint64((this.maxPosition + 1) << 3)
+	this.position = 0
+	this.maxPosition = -1
+	n := 0
+
+	for n < len(bits) {
+		size, err := this.is.Read(bits[n:])
+
+		if size > 0 {
+			n += size
+			this.read += uint64(size) << 3
+		}
+
+		if err != nil || size <= 0 {
+			break
+		}
+	}
+
+	if n == 0 {
+		panic(errors.New("No more data to read in the bitstream"))
+	}
+
+	return n
+}
+
 func (this *DefaultInputBitStream) readFromInputStream(count int) (int, error) {
 	if this.Closed() {
 		return 0, errors.New("Stream closed")
 	}
 
+	if this.is == nil {
+		// The whole bitstream is in the buffer (the state is kept for Seek)
+		return 0, errors.New("No more data to read in the bitstream")
+	}
+
 	this.read += uint64((this.maxPosition + 1) << 3)
 	size, err := this.is.Read(this.buffer[0:count])
 	this.position = 0
@@ -201,7 +357,7 @@ func (this *DefaultInputBitStream) HasMoreToRead() (bool, error) {
 		return false, errors.New("Stream closed")
 	}
 
-	if this.position < this.maxPosition || this.availBits != 0 {
+	if this.position <= this.maxPosition || this.availBits != 0 {
 		return true, nil
 	}
 
@@ -218,17 +374,18 @@ func (this *DefaultInputBitStream) pullCurrent() {
 	}
 
 	if this.position+7 > this.maxPosition {
-		// End of stream: overshoot max position => adjust bit index
+		// End of buffer (or short read): overshoot max position => adjust bit index
 		shift := uint(this.maxPosition-this.position) << 3
 		this.availBits = shift + 8
 		val := uint64(0)
 
-		for this.position <= this.maxPosition {
-			val |= (uint64(this.buffer[this.position]&0xFF) << shift)
-			this.position++
+		for _, b := range this.buffer[this.position : this.maxPosition+1] {
+			val |= (uint64(b) << shift)
 			shift -= 8
 		}
 
+		this.position = this.maxPosition + 1
+
 		this.current = val
 	} else {
 		// Regular processing, buffer length is multiple of 8
@@ -259,7 +416,65 @@ func (this *DefaultInputBitStream) Read() uint64 {
 	return this.read + uint64(this.position)<<3 - uint64(this.availBits)
 }
 
+// Position returns the bit position of the next bit to read (same as Read())
+func (this *DefaultInputBitStream) Position() uint64 {
+	return this.Read()
+}
+
+// Seek moves the bitstream to the provided bit position. A position within
+// the internal buffer does not require the underlying stream to seek.
+// Returns an error if the stream is closed or the underlying stream cannot
+// seek to the position.
+func (this *DefaultInputBitStream) Seek(bitPos uint64) error {
+	if this.Closed() {
+		return errors.New("Stream closed")
+	}
+
+	bytePos := bitPos >> 3
+	start := this.read >> 3
+	end := start + uint64(this.maxPosition+1)
+
+	if bytePos >= start && bytePos <= end {
+		// Within the internal buffer
+		this.position = int(bytePos - start)
+		this.availBits = 0
+	} else {
+		if this.seeker == nil {
+			return errors.New("The underlying stream cannot seek")
+		}
+
+		if _, err := this.seeker.Seek(this.origin+int64(bytePos), io.SeekStart); err != nil {
+			return err
+		}
+
+		this.read = bytePos << 3
+		this.position = 0
+		this.maxPosition = -1
+		this.availBits = 0
+	}
+
+	if bitPos&7 == 0 {
+		return nil
+	}
+
+	if this.position > this.maxPosition {
+		if _, err := this.readFromInputStream(len(this.buffer)); err != nil {
+			return err
+		}
+	}
+
+	// Skip the bits before the position in the first byte
+	this.pullCurrent()
+	this.availBits -= uint(bitPos & 7)
+	return nil
+}
+
 // Closed says whether this stream can be read from
 func (this *DefaultInputBitStream) Closed() bool {
 	return this.closed
 }
+
+// Number of bits that can be read without reading from the underlying stream
+func (this *DefaultInputBitStream) bufferedBits() uint64 {
+	return uint64(this.availBits) + uint64((this.maxPosition+1-this.position)<<3)
+}
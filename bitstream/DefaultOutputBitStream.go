@@ -31,6 +31,8 @@ type DefaultOutputBitStream struct {
 	current   uint64 // cached bits
 	os        io.WriteCloser
 	buffer    []byte
+	seeker    io.Seeker // nil if the underlying stream cannot seek
+	origin    int64     // offset of the bitstream in the underlying stream
 }
 
 // NewDefaultOutputBitStream creates a bitstream for writing, using the provided stream as
@@ -57,6 +59,14 @@ func NewDefaultOutputBitStream(stream io.WriteCloser, bufferSize uint) (*Default
 	this.os = stream
 	this.availBits = 64
 
+	if seeker, isSeeker := stream.(io.Seeker); isSeeker == true {
+		// Pipes and terminals fail to seek: not seekable
+		if origin, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			this.seeker = seeker
+			this.origin = origin
+		}
+	}
+
 	return this, nil
 }
 
@@ -117,6 +127,24 @@ func (this *DefaultOutputBitStream) WriteArray(bits []byte, count uint) uint {
 			remaining -= 8
 		}
 
+		if remaining>>3 >= len(this.buffer) && this.availBits == 64 {
+			// Large aligned block: write it to the underlying stream directly
+			// (after the internal buffer) instead of copying it to the buffer
+			if err := this.flush(); err != nil {
+				panic(err)
+			}
+
+			n := remaining >> 3
+
+			if _, err := this.os.Write(bits[start : start+n]); err != nil {
+				panic(err)
+			}
+
+			this.written += uint64(n) << 3
+			start += n
+			remaining -= (n << 3)
+		}
+
 		// Copy bits array to internal buffer
 		for remaining>>3 >= len(this.buffer)-this.position {
 			copy(this.buffer[this.position:], bits[start:start+len(this.buffer)-this.position])
@@ -144,14 +172,18 @@ func (this *DefaultOutputBitStream) WriteArray(bits []byte, count uint) uint {
 
 			for remaining >= 64 {
 				value := binary.BigEndian.Uint64(bits[start : start+8])
-				this.current |= (value >> r)
-				this.pushCurrent()
+				binary.BigEndian.PutUint64(this.buffer[this.position:this.position+8], this.current|(value>>r))
 				this.current = (value << (64 - r))
+				this.position += 8
 				start += 8
 				remaining -= 64
-			}
 
-			this.availBits -= r
+				if this.position >= len(this.buffer) {
+					if err := this.flush(); err != nil {
+						panic(err)
+					}
+				}
+			}
 		}
 	}
 
@@ -201,6 +233,35 @@ func (this *DefaultOutputBitStream) flush() error {
 	return nil
 }
 
+// Flush writes the complete bytes written so far to the underlying stream.
+// Up to 7 bits (last incomplete byte) remain buffered.
+func (this *DefaultOutputBitStream) Flush() error {
+	if this.Closed() {
+		return errors.New("Stream closed")
+	}
+
+	savedBitIndex := this.availBits
+	savedPosition := this.position
+	savedCurrent := this.current
+
+	for this.availBits <= 56 {
+		this.buffer[this.position] = byte(this.current >> 56)
+		this.position++
+		this.current <<= 8
+		this.availBits += 8
+	}
+
+	if err := this.flush(); err != nil {
+		// Revert fields to allow subsequent attempts in case of transient failure
+		this.availBits = savedBitIndex
+		this.position = savedPosition
+		this.current = savedCurrent
+		return err
+	}
+
+	return nil
+}
+
 // Close prevents further writes
 func (this *DefaultOutputBitStream) Close() (bool, error) {
 	if this.Closed() {
@@ -245,6 +306,45 @@ func (this *DefaultOutputBitStream) Written() uint64 {
 	return this.written + uint64(this.position<<3) + uint64(64-this.availBits)
 }
 
+// Position returns the bit position of the next bit to write (same as Written())
+func (this *DefaultOutputBitStream) Position() uint64 {
+	return this.Written()
+}
+
+// Seek flushes the bitstream and moves it to the provided bit position, which
+// must be byte aligned. The underlying stream must write at its seek position
+// (EG. a file, not a util.BufferStream which always appends).
+// Returns an error if the stream is closed, the position is not byte aligned,
+// the last byte written is incomplete or the underlying stream cannot seek.
+func (this *DefaultOutputBitStream) Seek(bitPos uint64) error {
+	if this.Closed() {
+		return errors.New("Stream closed")
+	}
+
+	if bitPos&7 != 0 {
+		return fmt.Errorf("Invalid bit position: %d (must be byte aligned)", bitPos)
+	}
+
+	if this.Written()&7 != 0 {
+		return errors.New("Cannot seek after an incomplete byte")
+	}
+
+	if this.seeker == nil {
+		return errors.New("The underlying stream cannot seek")
+	}
+
+	if err := this.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := this.seeker.Seek(this.origin+int64(bitPos>>3), io.SeekStart); err != nil {
+		return err
+	}
+
+	this.written = bitPos
+	return nil
+}
+
 // Closed says whether this stream can be written to
 func (this *DefaultOutputBitStream) Closed() bool {
 	return this.closed
@@ -0,0 +1,274 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LSBInputBitStream is an InputBitStream reading the bits least significant
+// bit first (like DEFLATE or Zstandard), see LSBOutputBitStream.
+type LSBInputBitStream struct {
+	bs *DefaultInputBitStream // buffer and counters ('current' holds the bits from bit 0)
+}
+
+// NewLSBInputBitStream creates a bitstream for reading the bits least
+// significant bit first, using the provided stream as the underlying I/O
+// object.
+func NewLSBInputBitStream(stream io.ReadCloser, bufferSize uint) (*LSBInputBitStream, error) {
+	ibs, err := NewDefaultInputBitStream(stream, bufferSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LSBInputBitStream{bs: ibs}, nil
+}
+
+// ReadBit returns the next bit
+func (this *LSBInputBitStream) ReadBit() int {
+	bs := this.bs
+
+	if bs.availBits == 0 {
+		this.pullCurrent() // Panic if stream is closed
+	}
+
+	res := int(bs.current & 1)
+	bs.current >>= 1
+	bs.availBits--
+	return res
+}
+
+// ReadBits reads 'count' bits from the stream and returns them as an uint64
+// (the first bit read is the least significant bit).
+// It panics if the count is outside of the [1..64] range or the stream is closed.
+func (this *LSBInputBitStream) ReadBits(count uint) uint64 {
+	if count == 0 || count > 64 {
+		panic(fmt.Errorf("Invalid bit count: %d (must be in [1..64])", count))
+	}
+
+	bs := this.bs
+
+	if count <= bs.availBits {
+		// Enough spots available in 'current'
+		res := bs.current & (0xFFFFFFFFFFFFFFFF >> (64 - count))
+		bs.current >>= count
+		bs.availBits -= count
+		return res
+	}
+
+	// Not enough spots available in 'current' (the bits above
+	// 'availBits' are zeros)
+	res := bs.current
+	got := bs.availBits
+
+	for {
+		this.pullCurrent()
+		needed := count - got
+
+		if needed <= bs.availBits {
+			res |= (bs.current & (0xFFFFFFFFFFFFFFFF >> (64 - needed))) << got
+			bs.current >>= needed
+			bs.availBits -= needed
+			return res
+		}
+
+		// Short read from the underlying stream
+		res |= bs.current << got
+		got += bs.availBits
+		bs.availBits = 0
+	}
+}
+
+// PeekBits returns the next 'count' bits from the stream as an uint64 without
+// consuming them. The bits past the end of the stream are zeros (they cannot
+// be read). It panics if the count is outside of the [1..64] range or the
+// stream is closed.
+func (this *LSBInputBitStream) PeekBits(count uint) uint64 {
+	if count == 0 || count > 64 {
+		panic(fmt.Errorf("Invalid bit count: %d (must be in [1..64])", count))
+	}
+
+	bs := this.bs
+	mask := uint64(0xFFFFFFFFFFFFFFFF >> (64 - count))
+
+	if count <= bs.availBits {
+		return bs.current & mask
+	}
+
+	if bs.Closed() {
+		panic(errors.New("Stream closed"))
+	}
+
+	// Bits left in 'current' followed by the next bytes of the buffer
+	if bs.position+8 > bs.maxPosition+1 {
+		bs.fillBuffer()
+	}
+
+	next := uint64(0)
+
+	if bs.position+8 <= bs.maxPosition+1 {
+		next = binary.LittleEndian.Uint64(bs.buffer[bs.position : bs.position+8])
+	} else {
+		// End of stream
+		for i, b := range bs.buffer[bs.position : bs.maxPosition+1] {
+			next |= (uint64(b) << (8 * uint(i)))
+		}
+	}
+
+	return (bs.current | (next << bs.availBits)) & mask
+}
+
+// ReadArray reads 'count' bits from the stream and returns them to the 'bits'
+// slice (the last bits of an incomplete byte are its least significant bits).
+// It panics if the stream is closed or the number of bits to read exceeds
+// the length of the 'bits' slice. Returns the number of bits read.
+func (this *LSBInputBitStream) ReadArray(bits []byte, count uint) uint {
+	bs := this.bs
+
+	if bs.Closed() {
+		panic(errors.New("Stream closed"))
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	remaining := int(count)
+	start := 0
+
+	// Byte aligned cursor ?
+	if bs.availBits&7 == 0 {
+		if bs.availBits == 0 {
+			this.pullCurrent()
+		}
+
+		// Empty bs.current
+		for bs.availBits != 0 && remaining >= 8 {
+			bits[start] = byte(this.ReadBits(8))
+			start++
+			remaining -= 8
+		}
+
+		// Copy internal buffer to bits array
+		for (remaining >> 3) > bs.maxPosition+1-bs.position {
+			copy(bits[start:], bs.buffer[bs.position:bs.maxPosition+1])
+			start += (bs.maxPosition + 1 - bs.position)
+			remaining -= ((bs.maxPosition + 1 - bs.position) << 3)
+
+			if remaining>>3 >= len(bs.buffer) && bs.is != nil {
+				// Large aligned block: read it from the underlying stream directly
+				n := bs.readDirect(bits[start : start+(remaining>>3)])
+				start += n
+				remaining -= (n << 3)
+
+				if remaining < 8 {
+					break
+				}
+			}
+
+			if _, err := bs.readFromInputStream(len(bs.buffer)); err != nil {
+				panic(err)
+			}
+		}
+
+		r := (remaining >> 6) << 3
+
+		if r > 0 {
+			copy(bits[start:start+r], bs.buffer[bs.position:bs.position+r])
+			bs.position += r
+			start += r
+			remaining -= (r << 3)
+		}
+	} else {
+		// Not byte aligned
+		for remaining >= 64 {
+			binary.LittleEndian.PutUint64(bits[start:start+8], this.ReadBits(64))
+			start += 8
+			remaining -= 64
+		}
+	}
+
+	// Last bytes
+	for remaining >= 8 {
+		bits[start] = byte(this.ReadBits(8))
+		start++
+		remaining -= 8
+	}
+
+	if remaining > 0 {
+		bits[start] = byte(this.ReadBits(uint(remaining)))
+	}
+
+	return count
+}
+
+// Pull 64 bits of current value from buffer (bit 0 first).
+func (this *LSBInputBitStream) pullCurrent() {
+	bs := this.bs
+
+	if bs.position > bs.maxPosition {
+		if _, err := bs.readFromInputStream(len(bs.buffer)); err != nil {
+			panic(err)
+		}
+	}
+
+	if bs.position+7 > bs.maxPosition {
+		// End of buffer (or short read)
+		val := uint64(0)
+
+		for i, b := range bs.buffer[bs.position : bs.maxPosition+1] {
+			val |= (uint64(b) << (8 * uint(i)))
+		}
+
+		bs.availBits = uint(bs.maxPosition+1-bs.position) << 3
+		bs.position = bs.maxPosition + 1
+		bs.current = val
+	} else {
+		bs.current = binary.LittleEndian.Uint64(bs.buffer[bs.position : bs.position+8])
+		bs.availBits = 64
+		bs.position += 8
+	}
+}
+
+// HasMoreToRead returns false is the stream is closed or there is no
+// more bit to read.
+func (this *LSBInputBitStream) HasMoreToRead() (bool, error) {
+	return this.bs.HasMoreToRead()
+}
+
+// Close prevents further reads (beyond the available bits)
+func (this *LSBInputBitStream) Close() (bool, error) {
+	return this.bs.Close()
+}
+
+// Read returns the number of bits read so far
+func (this *LSBInputBitStream) Read() uint64 {
+	return this.bs.Read()
+}
+
+// Closed says whether this stream can be read from
+func (this *LSBInputBitStream) Closed() bool {
+	return this.bs.Closed()
+}
+
+// Number of bits that can be read without reading from the underlying stream
+func (this *LSBInputBitStream) bufferedBits() uint64 {
+	return this.bs.bufferedBits()
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LSBOutputBitStream is an OutputBitStream packing the bits least significant
+// bit first (like DEFLATE or Zstandard) instead of most significant bit first.
+// The first bit written is the bit 0 of the first byte and WriteBits writes
+// the least significant bit of the value first. WriteArray writes the bytes
+// unchanged (the last bits of an incomplete byte are its least significant
+// bits).
+type LSBOutputBitStream struct {
+	bs *DefaultOutputBitStream // buffer and counters ('current' holds the bits from bit 0)
+}
+
+// NewLSBOutputBitStream creates a bitstream for writing the bits least
+// significant bit first, using the provided stream as the underlying I/O
+// object.
+func NewLSBOutputBitStream(stream io.WriteCloser, bufferSize uint) (*LSBOutputBitStream, error) {
+	obs, err := NewDefaultOutputBitStream(stream, bufferSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LSBOutputBitStream{bs: obs}, nil
+}
+
+// WriteBit writes the least significant bit of the input integer. Panics if the bitstream is closed
+func (this *LSBOutputBitStream) WriteBit(bit int) {
+	this.WriteBits(uint64(bit), 1)
+}
+
+// WriteBits writes 'count' from 'value' to the bitstream (least significant
+// bit first). Panics if the bitstream is closed or 'count' is outside of [1..64].
+// Returns the number of written bits.
+func (this *LSBOutputBitStream) WriteBits(value uint64, count uint) uint {
+	if count > 64 {
+		panic(fmt.Errorf("Invalid bit count: %d (must be in [1..64])", count))
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	bs := this.bs
+	used := 64 - bs.availBits
+	value &= (0xFFFFFFFFFFFFFFFF >> (64 - count))
+	bs.current |= (value << used)
+
+	if used+count < 64 {
+		bs.availBits -= count
+		return count
+	}
+
+	// Not enough spots available in 'current'
+	this.pushCurrent()
+	bs.current = value >> (64 - used)
+	bs.availBits = 128 - used - count
+	return count
+}
+
+// WriteArray writes 'count' bits from 'bits' to the bitstream.
+// Panics if the bitstream is closed or 'count' bigger than the number of bits
+// in the 'bits' slice. Returns the number of written bits.
+func (this *LSBOutputBitStream) WriteArray(bits []byte, count uint) uint {
+	bs := this.bs
+
+	if bs.Closed() {
+		panic(errors.New("Stream closed"))
+	}
+
+	if count > uint(len(bits)<<3) {
+		panic(fmt.Errorf("Invalid length: %d (must be in [1..%d])", count, len(bits)<<3))
+	}
+
+	remaining := int(count)
+	start := 0
+
+	// Byte aligned cursor ?
+	if bs.availBits&7 == 0 {
+		// Fill up bs.current
+		for (bs.availBits != 64) && (remaining >= 8) {
+			this.WriteBits(uint64(bits[start]), 8)
+			start++
+			remaining -= 8
+		}
+
+		if remaining>>3 >= len(bs.buffer) && bs.availBits == 64 {
+			// Large aligned block: write it to the underlying stream directly
+			if err := bs.flush(); err != nil {
+				panic(err)
+			}
+
+			n := remaining >> 3
+
+			if _, err := bs.os.Write(bits[start : start+n]); err != nil {
+				panic(err)
+			}
+
+			bs.written += uint64(n) << 3
+			start += n
+			remaining -= (n << 3)
+		}
+
+		// Copy bits array to internal buffer
+		for remaining>>3 >= len(bs.buffer)-bs.position {
+			copy(bs.buffer[bs.position:], bits[start:start+len(bs.buffer)-bs.position])
+			start += (len(bs.buffer) - bs.position)
+			remaining -= ((len(bs.buffer) - bs.position) << 3)
+			bs.position = len(bs.buffer)
+
+			if err := bs.flush(); err != nil {
+				panic(err)
+			}
+		}
+
+		r := (remaining >> 6) << 3
+
+		if r > 0 {
+			copy(bs.buffer[bs.position:], bits[start:start+r])
+			start += r
+			bs.position += r
+			remaining -= (r << 3)
+		}
+	} else {
+		// Not byte aligned
+		for remaining >= 64 {
+			this.WriteBits(binary.LittleEndian.Uint64(bits[start:start+8]), 64)
+			start += 8
+			remaining -= 64
+		}
+	}
+
+	// Last bytes
+	for remaining >= 8 {
+		this.WriteBits(uint64(bits[start]), 8)
+		start++
+		remaining -= 8
+	}
+
+	if remaining > 0 {
+		this.WriteBits(uint64(bits[start]), uint(remaining))
+	}
+
+	return count
+}
+
+// Push 64 bits of current value into buffer.
+func (this *LSBOutputBitStream) pushCurrent() {
+	bs := this.bs
+	binary.LittleEndian.PutUint64(bs.buffer[bs.position:bs.position+8], bs.current)
+	bs.availBits = 64
+	bs.current = 0
+	bs.position += 8
+
+	if bs.position >= len(bs.buffer) {
+		if err := bs.flush(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Close prevents further writes (the last byte may be incomplete: the
+// missing bits are zeros)
+func (this *LSBOutputBitStream) Close() (bool, error) {
+	bs := this.bs
+
+	if bs.Closed() {
+		return true, nil
+	}
+
+	savedBitIndex := bs.availBits
+	savedPosition := bs.position
+	savedCurrent := bs.current
+	savedWritten := bs.written
+
+	// Push last bytes (the very last byte may be incomplete)
+	used := 64 - bs.availBits
+	nbBytes := (used + 7) >> 3
+
+	for i := uint(0); i < nbBytes; i++ {
+		bs.buffer[bs.position] = byte(bs.current >> (8 * i))
+		bs.position++
+	}
+
+	// Do not count the padding bits
+	bs.written -= uint64(nbBytes<<3 - used)
+	bs.availBits = 64
+
+	if err := bs.flush(); err != nil {
+		// Revert fields to allow subsequent attempts in case of transient failure
+		bs.availBits = savedBitIndex
+		bs.position = savedPosition
+		bs.current = savedCurrent
+		bs.written = savedWritten
+		return false, err
+	}
+
+	// Reset fields to force a flush() and trigger an error
+	// on WriteBit() or WriteBits()
+	bs.closed = true
+	bs.position = 0
+	bs.availBits = 0
+	bs.written -= 64 // adjust because bs.availBits = 0
+	bs.buffer = make([]byte, 8)
+	return true, nil
+}
+
+// Written returns the number of bits written so far
+func (this *LSBOutputBitStream) Written() uint64 {
+	return this.bs.Written()
+}
+
+// Closed says whether this stream can be written to
+func (this *LSBOutputBitStream) Closed() bool {
+	return this.bs.Closed()
+}
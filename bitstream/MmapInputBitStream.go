@@ -0,0 +1,68 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"errors"
+	"os"
+)
+
+// MmapInputBitStream is an InputBitStream reading a memory mapped file.
+// The bits are read from the mapping directly, instead of being copied to an
+// internal buffer first, which saves a copy of the data for large inputs.
+// Mapping files requires the mmap build tag ('go build -tags mmap') on a Unix
+// system (see MMAP_SUPPORTED).
+type MmapInputBitStream struct {
+	*DefaultInputBitStream
+	data []byte
+}
+
+// NewMmapInputBitStream creates a bitstream reading the whole file (from
+// offset 0, regardless of the current file offset). The file can be closed
+// once the bitstream is created. The bitstream must be closed to unmap the file.
+func NewMmapInputBitStream(file *os.File) (*MmapInputBitStream, error) {
+	if file == nil {
+		return nil, errors.New("Invalid null file parameter")
+	}
+
+	data, err := mmapFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &MmapInputBitStream{data: data}
+	this.DefaultInputBitStream = NewSliceInputBitStream(data)
+	return this, nil
+}
+
+// Close prevents further reads and unmaps the file
+func (this *MmapInputBitStream) Close() (bool, error) {
+	if this.Closed() {
+		return true, nil
+	}
+
+	this.DefaultInputBitStream.Close()
+	this.DefaultInputBitStream.buffer = nil
+	data := this.data
+	this.data = nil
+
+	if err := munmap(data); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,36 @@
+//go:build !mmap || !unix
+// +build !mmap !unix
+
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"errors"
+	"os"
+)
+
+// MMAP_SUPPORTED says whether NewMmapInputBitStream can map files
+const MMAP_SUPPORTED = false
+
+// No mapping without the mmap build tag (or outside of Unix systems)
+func mmapFile(file *os.File) ([]byte, error) {
+	return nil, errors.New("Memory mapped files require the mmap build tag (Unix only)")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
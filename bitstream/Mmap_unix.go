@@ -0,0 +1,53 @@
+//go:build mmap && unix
+// +build mmap,unix
+
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// MMAP_SUPPORTED says whether NewMmapInputBitStream can map files
+const MMAP_SUPPORTED = true
+
+// Map the whole file in memory (read only)
+func mmapFile(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode().IsRegular() == false {
+		return nil, errors.New("Only regular files can be mapped")
+	}
+
+	size := info.Size()
+
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errors.New("Invalid file size for a mapping")
+	}
+
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"errors"
+	"io"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// The adapters let the standard library primitives (binary.Write, io.Copy,
+// ...) interleave with the bit level calls on a bitstream. Each Read or Write
+// call starts on a byte boundary: the writer pads the current byte with zero
+// bits and the reader skips the bits left in the current byte.
+
+// bufferedBitStream is implemented by the input bitstreams that know how many
+// bits can be read without reading from the underlying stream.
+type bufferedBitStream interface {
+	bufferedBits() uint64
+}
+
+// WriterAdapter is an io.Writer writing the bytes to an OutputBitStream
+type WriterAdapter struct {
+	obs kanzi.OutputBitStream
+}
+
+// NewWriterAdapter creates an io.Writer over the provided bitstream
+func NewWriterAdapter(obs kanzi.OutputBitStream) (*WriterAdapter, error) {
+	if obs == nil {
+		return nil, errors.New("Invalid null output bitstream parameter")
+	}
+
+	return &WriterAdapter{obs: obs}, nil
+}
+
+// Write aligns the bitstream on the next byte boundary (padding with zeros)
+// then writes the bytes. Returns the number of bytes written and the error
+// of the bitstream if any (EG. closed stream).
+func (this *WriterAdapter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			n = 0
+			err = r.(error)
+		}
+	}()
+
+	if pad := uint((8 - this.obs.Written()&7) & 7); pad != 0 {
+		this.obs.WriteBits(0, pad)
+	}
+
+	this.obs.WriteArray(p, uint(8*len(p)))
+	return len(p), nil
+}
+
+// ReaderAdapter is an io.Reader reading the bytes from an InputBitStream
+type ReaderAdapter struct {
+	ibs kanzi.InputBitStream
+}
+
+// NewReaderAdapter creates an io.Reader over the provided bitstream
+func NewReaderAdapter(ibs kanzi.InputBitStream) (*ReaderAdapter, error) {
+	if ibs == nil {
+		return nil, errors.New("Invalid null input bitstream parameter")
+	}
+
+	return &ReaderAdapter{ibs: ibs}, nil
+}
+
+// Read skips the bits up to the next byte boundary then reads up to len(p)
+// bytes. Returns the number of bytes read and io.EOF when there is no more
+// bit to read (end of bitstream or closed stream) or the error of the
+// bitstream if any (EG. incomplete last byte).
+func (this *ReaderAdapter) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	if skip := uint((8 - this.ibs.Read()&7) & 7); skip != 0 {
+		this.ibs.ReadBits(skip)
+	}
+
+	for n < len(p) {
+		if more, _ := this.ibs.HasMoreToRead(); more == false {
+			if n > 0 {
+				return n, nil
+			}
+
+			return 0, io.EOF
+		}
+
+		if bbs, ok := this.ibs.(bufferedBitStream); ok == true {
+			// Copy the buffered bytes at once
+			count := int(bbs.bufferedBits() >> 3)
+
+			if count > len(p)-n {
+				count = len(p) - n
+			}
+
+			if count > 0 {
+				this.ibs.ReadArray(p[n:], uint(8*count))
+				n += count
+				continue
+			}
+		}
+
+		p[n] = byte(this.ibs.ReadBits(8))
+		n++
+	}
+
+	return n, nil
+}
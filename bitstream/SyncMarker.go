@@ -0,0 +1,132 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bitstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// A sync marker lets a reader find a known position again after a read error
+// (EG. a corrupted length field). It starts on a byte boundary (the bits of
+// the current byte are padded with zeros) and is made of a magic value (32
+// bits), a counter (32 bits, EG. a block ID) and a check of the counter (16
+// bits) to reject the copies of the magic value found in the data.
+
+const (
+	// SYNC_MARKER_MAGIC is the first 32 bits of a sync marker ("KSYN")
+	SYNC_MARKER_MAGIC = 0x4B53594E
+
+	// SYNC_MARKER_SIZE is the size in bytes of a sync marker (without padding)
+	SYNC_MARKER_SIZE = 10
+)
+
+var syncMarkerTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Check of the counter: 16 bits of the CRC32-C of magic + counter
+func syncMarkerCheck(counter uint32) uint64 {
+	buf := [8]byte{0x4B, 0x53, 0x59, 0x4E, byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)}
+	return uint64(crc32.Checksum(buf[:], syncMarkerTable) >> 16)
+}
+
+// WriteSyncMarker pads the bitstream to the next byte boundary with zeros
+// then writes a sync marker with the provided counter.
+func WriteSyncMarker(obs kanzi.OutputBitStream, counter uint32) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	if pad := uint((8 - obs.Written()&7) & 7); pad != 0 {
+		obs.WriteBits(0, pad)
+	}
+
+	obs.WriteBits(SYNC_MARKER_MAGIC<<32|uint64(counter), 64)
+	obs.WriteBits(syncMarkerCheck(counter), 16)
+	return nil
+}
+
+// ReadSyncMarker skips the bits up to the next byte boundary then reads the
+// sync marker expected at this position. Returns its counter or an error if
+// the bits read are not a valid sync marker.
+func ReadSyncMarker(ibs kanzi.InputBitStream) (counter uint32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	if skip := uint((8 - ibs.Read()&7) & 7); skip != 0 {
+		ibs.ReadBits(skip)
+	}
+
+	val := ibs.ReadBits(64)
+	check := ibs.ReadBits(16)
+
+	if val>>32 != SYNC_MARKER_MAGIC {
+		return 0, fmt.Errorf("Invalid sync marker: %08X", val>>32)
+	}
+
+	if counter = uint32(val); check != syncMarkerCheck(counter) {
+		return 0, fmt.Errorf("Invalid check of sync marker %d", counter)
+	}
+
+	return counter, nil
+}
+
+// ScanSyncMarker skips the bits up to the next byte boundary then the bytes
+// up to the next valid sync marker (EG. after a read error). The marker is
+// consumed and its counter returned. Returns an error if the end of the
+// bitstream is reached before a sync marker.
+func ScanSyncMarker(ibs kanzi.InputBitStream) (counter uint32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	if skip := uint((8 - ibs.Read()&7) & 7); skip != 0 {
+		ibs.ReadBits(skip)
+	}
+
+	window := uint64(0)
+
+	for n := 0; ; n++ {
+		if more, _ := ibs.HasMoreToRead(); more == false {
+			return 0, errors.New("No sync marker found before the end of the bitstream")
+		}
+
+		window = (window << 8) | ibs.ReadBits(8)
+
+		if n < 3 || uint32(window) != SYNC_MARKER_MAGIC {
+			continue
+		}
+
+		// Candidate: the counter and check must follow (the bits past the end
+		// of the bitstream peek as zeros and fail the check)
+		val := ibs.PeekBits(48)
+		counter = uint32(val >> 16)
+
+		if val&0xFFFF == syncMarkerCheck(counter) {
+			ibs.ReadBits(48)
+			return counter, nil
+		}
+	}
+}
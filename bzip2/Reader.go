@@ -0,0 +1,515 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bzip2 implements a decoder for .bz2 files built on the kanzi
+// bitstream and transforms, so that bzip2 archives can be migrated with a
+// single library.
+//
+// A bzip2 block is decoded in reverse order of the encoding stages:
+// Huffman (up to 6 tables selected every 50 symbols), zero run length
+// (RUNA/RUNB), move-to-front (SBRT), Burrows-Wheeler (bzip2 sorts the
+// rotations of the block, without the sentinel used by transform.BWT) and
+// the initial run length encoding of runs of 4 to 255 identical bytes.
+// Concatenated streams (EG. produced by pbzip2) are supported. Randomized
+// blocks (deprecated since bzip2 0.9.5) are not.
+package bzip2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/transform"
+)
+
+const (
+	_BZ2_STREAM_MAGIC  = 0x425A68 // "BZh"
+	_BZ2_BLOCK_MAGIC   = 0x314159265359
+	_BZ2_EOS_MAGIC     = 0x177245385090
+	_BZ2_MAX_GROUPS    = 6
+	_BZ2_GROUP_SIZE    = 50
+	_BZ2_MAX_CODE_LEN  = 20
+	_BZ2_MAX_ALPHABET  = 258
+	_BZ2_MAX_SELECTORS = 18002
+	_BZ2_RUNA          = 0
+	_BZ2_RUNB          = 1
+)
+
+var _BZ2_CRC_TABLE = initCRCTable()
+
+// bzip2 uses the CRC32 polynomial without bit reflection (unlike hash/crc32)
+func initCRCTable() []uint32 {
+	res := make([]uint32, 256)
+
+	for i := range res {
+		c := uint32(i) << 24
+
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = (c << 1) ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+
+		res[i] = c
+	}
+
+	return res
+}
+
+// Reader decompresses a bzip2 stream read from the underlying reader
+type Reader struct {
+	ibs         *bitstream.DefaultInputBitStream
+	mtf         *transform.SBRT
+	blockSize   int
+	combinedCRC uint32
+	data        []byte // decoded block
+	index       int    // next byte to return in data
+	initialized bool
+	eos         bool
+	err         error
+}
+
+// huffmanTable decodes canonical Huffman codes (codes of the same length
+// are consecutive and assigned in increasing symbol order)
+type huffmanTable struct {
+	count   [_BZ2_MAX_CODE_LEN + 1]int
+	start   [_BZ2_MAX_CODE_LEN + 1]int // first code of each length
+	offset  [_BZ2_MAX_CODE_LEN + 1]int // index of the first symbol of each length
+	symbols [_BZ2_MAX_ALPHABET]int
+	maxLen  int
+}
+
+// NewReader creates a new Reader decompressing the data read from 'r'.
+// The stream header is read lazily, on the first call to Read.
+func NewReader(r io.Reader) (*Reader, error) {
+	if r == nil {
+		return nil, errors.New("Invalid null reader parameter")
+	}
+
+	var err error
+	this := &Reader{}
+
+	if this.ibs, err = bitstream.NewDefaultInputBitStream(ioutil.NopCloser(r), 65536); err != nil {
+		return nil, err
+	}
+
+	if this.mtf, err = transform.NewSBRT(transform.SBRT_MODE_MTF); err != nil {
+		return nil, err
+	}
+
+	this.data = make([]byte, 0)
+	return this, nil
+}
+
+// Read reads up to len(block) decompressed bytes into block. It returns
+// io.EOF at the end of the last stream.
+func (this *Reader) Read(block []byte) (int, error) {
+	n := 0
+
+	for n < len(block) && this.err == nil {
+		if this.index < len(this.data) {
+			c := copy(block[n:], this.data[this.index:])
+			this.index += c
+			n += c
+			continue
+		}
+
+		this.err = this.decodeBlock()
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+
+	return 0, this.err
+}
+
+// Close releases the resources of the reader. It does not close the
+// underlying reader.
+func (this *Reader) Close() error {
+	this.data = make([]byte, 0)
+	this.index = 0
+
+	if this.err == nil {
+		this.err = errors.New("Stream closed")
+	}
+
+	return nil
+}
+
+// Decode the next block into this.data. Returns io.EOF at the end of the
+// last stream.
+func (this *Reader) decodeBlock() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// I/O errors and premature ends of stream are reported by the bitstream
+			err = fmt.Errorf("Invalid bzip2 stream: %v", r)
+		}
+	}()
+
+	this.data = this.data[0:0]
+	this.index = 0
+
+	for {
+		if this.initialized == false {
+			if err := this.readStreamHeader(); err != nil {
+				return err
+			}
+		}
+
+		magic := this.ibs.ReadBits(48)
+
+		if magic == _BZ2_BLOCK_MAGIC {
+			return this.readBlock()
+		}
+
+		if magic != _BZ2_EOS_MAGIC {
+			return errors.New("Invalid bzip2 stream: incorrect block magic")
+		}
+
+		crc := uint32(this.ibs.ReadBits(32))
+
+		if crc != this.combinedCRC {
+			return fmt.Errorf("Invalid bzip2 stream: incorrect stream checksum %08x (expected %08x)",
+				this.combinedCRC, crc)
+		}
+
+		// Another stream may follow, starting on a byte boundary
+		if n := this.ibs.Read() & 7; n != 0 {
+			this.ibs.ReadBits(uint(8 - n))
+		}
+
+		this.initialized = false
+		this.eos = true
+	}
+}
+
+func (this *Reader) readStreamHeader() error {
+	if this.eos == true {
+		if more, _ := this.ibs.HasMoreToRead(); more == false {
+			return io.EOF
+		}
+	}
+
+	if this.ibs.ReadBits(24) != _BZ2_STREAM_MAGIC {
+		if this.eos == true {
+			return errors.New("Invalid bzip2 stream: trailing garbage after end of stream")
+		}
+
+		return errors.New("Invalid bzip2 stream: incorrect stream magic")
+	}
+
+	level := int(this.ibs.ReadBits(8)) - '0'
+
+	if level < 1 || level > 9 {
+		return fmt.Errorf("Invalid bzip2 stream: incorrect block size %d", level)
+	}
+
+	this.blockSize = level * 100000
+	this.combinedCRC = 0
+	this.initialized = true
+	return nil
+}
+
+func (this *Reader) readBlock() error {
+	ibs := this.ibs
+	expectedCRC := uint32(ibs.ReadBits(32))
+
+	if ibs.ReadBit() == 1 {
+		return errors.New("Invalid bzip2 stream: randomized blocks are not supported")
+	}
+
+	origPtr := int(ibs.ReadBits(24))
+
+	// Symbol map: 16 ranges of 16 symbols
+	var seqToUnseq [256]byte
+	nInUse := 0
+	inUse16 := ibs.ReadBits(16)
+
+	for i := 0; i < 16; i++ {
+		if inUse16&(1<<uint(15-i)) == 0 {
+			continue
+		}
+
+		bits := ibs.ReadBits(16)
+
+		for j := 0; j < 16; j++ {
+			if bits&(1<<uint(15-j)) != 0 {
+				seqToUnseq[nInUse] = byte(16*i + j)
+				nInUse++
+			}
+		}
+	}
+
+	if nInUse == 0 {
+		return errors.New("Invalid bzip2 stream: empty symbol map")
+	}
+
+	alphaSize := nInUse + 2
+	nGroups := int(ibs.ReadBits(3))
+
+	if nGroups < 2 || nGroups > _BZ2_MAX_GROUPS {
+		return fmt.Errorf("Invalid bzip2 stream: incorrect number of Huffman tables %d", nGroups)
+	}
+
+	nSelectors := int(ibs.ReadBits(15))
+
+	if nSelectors == 0 {
+		return errors.New("Invalid bzip2 stream: no selector")
+	}
+
+	// Selectors are unary coded move-to-front indexes
+	selectors := make([]byte, nSelectors)
+
+	for i := range selectors {
+		j := 0
+
+		for ibs.ReadBit() == 1 {
+			if j++; j >= nGroups {
+				return errors.New("Invalid bzip2 stream: incorrect selector")
+			}
+		}
+
+		selectors[i] = byte(j)
+	}
+
+	// Like bzip2 1.0.8, ignore the selectors beyond the maximum
+	if nSelectors > _BZ2_MAX_SELECTORS {
+		selectors = selectors[0:_BZ2_MAX_SELECTORS]
+	}
+
+	mtfSelectors := make([]byte, len(selectors))
+	this.mtf.Inverse(selectors, mtfSelectors)
+	selectors = mtfSelectors
+
+	// Delta coded code lengths
+	var tables [_BZ2_MAX_GROUPS]huffmanTable
+	var lengths [_BZ2_MAX_ALPHABET]int
+
+	for t := 0; t < nGroups; t++ {
+		curr := int(ibs.ReadBits(5))
+
+		for s := 0; s < alphaSize; s++ {
+			for {
+				if curr < 1 || curr > _BZ2_MAX_CODE_LEN {
+					return fmt.Errorf("Invalid bzip2 stream: incorrect code length %d", curr)
+				}
+
+				if ibs.ReadBit() == 0 {
+					break
+				}
+
+				if ibs.ReadBit() == 0 {
+					curr++
+				} else {
+					curr--
+				}
+			}
+
+			lengths[s] = curr
+		}
+
+		if err := tables[t].init(lengths[0:alphaSize]); err != nil {
+			return err
+		}
+	}
+
+	// Decode the move-to-front indexes. Runs of index 0 are coded with
+	// RUNA and RUNB (bijective base 2), other indexes are shifted by 1.
+	eob := nInUse + 1
+	indexes := make([]byte, 0, this.blockSize)
+	var table *huffmanTable
+	run := 0
+	runWeight := 1
+
+	for groupPos, selector := 0, 0; ; groupPos-- {
+		if groupPos == 0 {
+			if selector >= len(selectors) {
+				return errors.New("Invalid bzip2 stream: not enough selectors")
+			}
+
+			table = &tables[selectors[selector]]
+			selector++
+			groupPos = _BZ2_GROUP_SIZE
+		}
+
+		sym, err := table.decode(ibs)
+
+		if err != nil {
+			return err
+		}
+
+		if sym == _BZ2_RUNA || sym == _BZ2_RUNB {
+			run += (sym + 1) * runWeight
+			runWeight <<= 1
+
+			if run > this.blockSize {
+				return errors.New("Invalid bzip2 stream: block too big")
+			}
+
+			continue
+		}
+
+		if len(indexes)+run > this.blockSize {
+			return errors.New("Invalid bzip2 stream: block too big")
+		}
+
+		for ; run > 0; run-- {
+			indexes = append(indexes, 0)
+		}
+
+		runWeight = 1
+
+		if sym == eob {
+			break
+		}
+
+		if len(indexes) >= this.blockSize {
+			return errors.New("Invalid bzip2 stream: block too big")
+		}
+
+		indexes = append(indexes, byte(sym-1))
+	}
+
+	count := len(indexes)
+
+	if origPtr >= count {
+		return fmt.Errorf("Invalid bzip2 stream: incorrect BWT primary index %d", origPtr)
+	}
+
+	bwt := make([]byte, count)
+	this.mtf.Inverse(indexes, bwt)
+
+	for i := range bwt {
+		bwt[i] = seqToUnseq[bwt[i]]
+	}
+
+	// Inverse BWT: build the permutation vector (index of the next byte).
+	// 8 low bits: byte value, 24 high bits: next index.
+	var buckets [256]int
+	tt := make([]uint32, count)
+
+	for _, b := range bwt {
+		buckets[b]++
+	}
+
+	for i, sum := 0, 0; i < 256; i++ {
+		sum, buckets[i] = sum+buckets[i], sum
+	}
+
+	for i, b := range bwt {
+		tt[buckets[b]] |= uint32(i) << 8
+		tt[i] |= uint32(b)
+		buckets[b]++
+	}
+
+	// Invert the initial run length encoding while following the permutation
+	crc := uint32(0xFFFFFFFF)
+	data := this.data
+	pos := tt[origPtr] >> 8
+	last := -1
+	runLength := 0
+
+	for i := 0; i < count; i++ {
+		entry := tt[pos]
+		b := byte(entry)
+		pos = entry >> 8
+
+		if runLength == 4 {
+			for n := int(b); n > 0; n-- {
+				data = append(data, byte(last))
+				crc = (crc << 8) ^ _BZ2_CRC_TABLE[byte(crc>>24)^byte(last)]
+			}
+
+			runLength = 0
+			last = -1
+			continue
+		}
+
+		if int(b) == last {
+			runLength++
+		} else {
+			runLength = 1
+			last = int(b)
+		}
+
+		data = append(data, b)
+		crc = (crc << 8) ^ _BZ2_CRC_TABLE[byte(crc>>24)^b]
+	}
+
+	crc = ^crc
+
+	if crc != expectedCRC {
+		return fmt.Errorf("Invalid bzip2 stream: incorrect block checksum %08x (expected %08x)", crc, expectedCRC)
+	}
+
+	this.combinedCRC = ((this.combinedCRC << 1) | (this.combinedCRC >> 31)) ^ crc
+	this.data = data
+	return nil
+}
+
+func (this *huffmanTable) init(lengths []int) error {
+	*this = huffmanTable{}
+
+	for _, l := range lengths {
+		this.count[l]++
+
+		if l > this.maxLen {
+			this.maxLen = l
+		}
+	}
+
+	code := 0
+	offset := 0
+
+	for l := 1; l <= this.maxLen; l++ {
+		this.start[l] = code
+		this.offset[l] = offset
+		code = (code + this.count[l]) << 1
+		offset += this.count[l]
+	}
+
+	if code > 2<<uint(this.maxLen) {
+		return errors.New("Invalid bzip2 stream: incorrect Huffman code lengths")
+	}
+
+	var next [_BZ2_MAX_CODE_LEN + 1]int
+	copy(next[:], this.offset[:])
+
+	for s, l := range lengths {
+		this.symbols[next[l]] = s
+		next[l]++
+	}
+
+	return nil
+}
+
+func (this *huffmanTable) decode(ibs *bitstream.DefaultInputBitStream) (int, error) {
+	code := 0
+
+	for l := 1; l <= this.maxLen; l++ {
+		code = (code << 1) | ibs.ReadBit()
+
+		if idx := code - this.start[l]; idx >= 0 && idx < this.count[l] {
+			return this.symbols[this.offset[l]+idx], nil
+		}
+	}
+
+	return 0, errors.New("Invalid bzip2 stream: incorrect Huffman code")
+}
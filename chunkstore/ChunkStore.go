@@ -0,0 +1,277 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chunkstore implements a content addressable chunk store, the core
+// of backup and synchronization tools: the data is split into content defined
+// chunks (see Chunker), each chunk is compressed independently and stored
+// under the SHA-256 of its content, so that identical chunks are only
+// stored once.
+//
+// Stored chunk layout: version (8 bits), pipeline string length (8 bits),
+// pipeline string, pipeline payload (see pipeline.Pipeline). Chunks written
+// with different pipelines can be mixed in a store.
+package chunkstore
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+const (
+	// CHUNK_VERSION is the version of the stored chunk layout
+	CHUNK_VERSION = 1
+)
+
+// WriteStats reports the activity of Write
+type WriteStats struct {
+	Chunks      int   // number of chunks in the data
+	NewChunks   int   // number of chunks not already in the store
+	Bytes       int64 // size of the data
+	StoredBytes int64 // size of the new stored chunks
+}
+
+// ChunkStore splits, compresses and stores data in a Store and restores
+// it from the lists of chunk ids. A ChunkStore is safe for concurrent use.
+type ChunkStore struct {
+	store     Store
+	name      string
+	minSize   int
+	avgSize   int
+	maxSize   int
+	mutex     sync.Mutex
+	pipelines map[string]*sync.Pool // by pipeline string
+}
+
+// NewChunkStore creates a new ChunkStore compressing the chunks with the
+// provided pipeline string and using the default chunk sizes
+func NewChunkStore(store Store, pipelineStr string) (*ChunkStore, error) {
+	return NewChunkStoreWithSizes(store, pipelineStr, DEFAULT_MIN_CHUNK_SIZE, DEFAULT_AVG_CHUNK_SIZE, DEFAULT_MAX_CHUNK_SIZE)
+}
+
+// NewChunkStoreWithSizes creates a new ChunkStore compressing the chunks with
+// the provided pipeline string and using the provided chunk sizes (see NewChunker)
+func NewChunkStoreWithSizes(store Store, pipelineStr string, minSize, avgSize, maxSize int) (*ChunkStore, error) {
+	if store == nil {
+		return nil, errors.New("Invalid null store parameter")
+	}
+
+	if _, err := NewChunker(nil, minSize, avgSize, maxSize); err != nil {
+		return nil, err
+	}
+
+	this := &ChunkStore{store: store, minSize: minSize, avgSize: avgSize, maxSize: maxSize}
+	this.pipelines = make(map[string]*sync.Pool)
+	pool, err := this.getPool(pipelineStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the canonical name in the stored chunks
+	p := pool.Get().(*pipeline.Pipeline)
+	this.name = p.String()
+	pool.Put(p)
+
+	if len(this.name) > 255 {
+		return nil, fmt.Errorf("Invalid pipeline '%v': description too long", pipelineStr)
+	}
+
+	return this, nil
+}
+
+func (this *ChunkStore) getPool(name string) (*sync.Pool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if pool := this.pipelines[name]; pool != nil {
+		return pool, nil
+	}
+
+	desc, err := pipeline.Parse(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = pipeline.NewPipelineWithCtx(desc, nil); err != nil {
+		return nil, err
+	}
+
+	pool := &sync.Pool{New: func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, nil)
+		return p
+	}}
+
+	this.pipelines[name] = pool
+	return pool, nil
+}
+
+// Put compresses and stores the chunk if it is not already in the store.
+// Returns the id of the chunk, the number of bytes stored (0 if the chunk
+// already exists) and possibly an error.
+func (this *ChunkStore) Put(chunk []byte) (ID, int, error) {
+	id := ID(sha256.Sum256(chunk))
+	exists, err := this.store.Has(id)
+
+	if err != nil || exists == true {
+		return id, 0, err
+	}
+
+	pool, _ := this.getPool(this.name)
+	p := pool.Get().(*pipeline.Pipeline)
+	defer pool.Put(p)
+	buf := make([]byte, 2+len(this.name)+p.MaxEncodedLen(len(chunk)))
+	buf[0] = CHUNK_VERSION
+	buf[1] = byte(len(this.name))
+	copy(buf[2:], this.name)
+	_, written, err := p.Forward(chunk, buf[2+len(this.name):])
+
+	if err != nil {
+		return id, 0, err
+	}
+
+	buf = buf[0 : 2+len(this.name)+int(written)]
+
+	if err = this.store.Put(id, buf); err != nil {
+		return id, 0, err
+	}
+
+	return id, len(buf), nil
+}
+
+// Get appends the content of the chunk to dst and returns the result.
+// The content is verified against the id.
+func (this *ChunkStore) Get(dst []byte, id ID) ([]byte, error) {
+	data, err := this.store.Get(id)
+
+	if err != nil {
+		return dst, err
+	}
+
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return dst, fmt.Errorf("Invalid chunk %v: truncated data", id)
+	}
+
+	if data[0] != CHUNK_VERSION {
+		return dst, fmt.Errorf("Invalid chunk %v: unsupported version %d", id, data[0])
+	}
+
+	pool, err := this.getPool(string(data[2 : 2+int(data[1])]))
+
+	if err != nil {
+		return dst, fmt.Errorf("Invalid chunk %v: %v", id, err)
+	}
+
+	payload := data[2+int(data[1]):]
+	size := 0
+
+	if len(payload) > 0 {
+		if size, err = pipeline.DecodedLen(payload); err != nil {
+			return dst, fmt.Errorf("Invalid chunk %v: %v", id, err)
+		}
+	}
+
+	start := len(dst)
+
+	if cap(dst)-start < size {
+		buf := make([]byte, start, start+size)
+		copy(buf, dst)
+		dst = buf
+	}
+
+	dst = dst[0 : start+size]
+	p := pool.Get().(*pipeline.Pipeline)
+	_, _, err = p.Inverse(payload, dst[start:])
+	pool.Put(p)
+
+	if err != nil {
+		return dst[0:start], fmt.Errorf("Invalid chunk %v: %v", id, err)
+	}
+
+	if ID(sha256.Sum256(dst[start:])) != id {
+		return dst[0:start], fmt.Errorf("Invalid chunk %v: corrupted data", id)
+	}
+
+	return dst, nil
+}
+
+// Write splits the data of src into chunks and stores the new chunks.
+// Returns the list of chunk ids (to restore the data with Read), some
+// statistics and possibly an error.
+func (this *ChunkStore) Write(src io.Reader) ([]ID, WriteStats, error) {
+	var stats WriteStats
+	ids := make([]ID, 0)
+	chunker, err := NewChunker(src, this.minSize, this.avgSize, this.maxSize)
+
+	if err != nil {
+		return nil, stats, err
+	}
+
+	for {
+		chunk, err := chunker.Next()
+
+		if err == io.EOF {
+			return ids, stats, nil
+		}
+
+		if err != nil {
+			return ids, stats, err
+		}
+
+		id, stored, err := this.Put(chunk)
+
+		if err != nil {
+			return ids, stats, err
+		}
+
+		ids = append(ids, id)
+		stats.Chunks++
+		stats.Bytes += int64(len(chunk))
+
+		if stored > 0 {
+			stats.NewChunks++
+			stats.StoredBytes += int64(stored)
+		}
+	}
+}
+
+// Read writes the content of the chunks to dst. Returns the number of
+// bytes written and possibly an error.
+func (this *ChunkStore) Read(dst io.Writer, ids []ID) (int64, error) {
+	buf := make([]byte, 0, this.maxSize)
+	written := int64(0)
+
+	for _, id := range ids {
+		var err error
+
+		if buf, err = this.Get(buf[:0], id); err != nil {
+			return written, err
+		}
+
+		n, err := dst.Write(buf)
+		written += int64(n)
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
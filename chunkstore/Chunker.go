@@ -0,0 +1,168 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chunkstore
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/flanglet/kanzi-go/util/hash"
+)
+
+const (
+	// DEFAULT_MIN_CHUNK_SIZE is the default minimum size of a chunk
+	DEFAULT_MIN_CHUNK_SIZE = 16 * 1024
+	// DEFAULT_AVG_CHUNK_SIZE is the default average size of a chunk
+	DEFAULT_AVG_CHUNK_SIZE = 64 * 1024
+	// DEFAULT_MAX_CHUNK_SIZE is the default maximum size of a chunk
+	DEFAULT_MAX_CHUNK_SIZE = 256 * 1024
+
+	_CHUNKER_MIN_SIZE = 64
+	_CHUNKER_MAX_SIZE = 64 * 1024 * 1024
+)
+
+// Gear table of the rolling hash, derived from XXHash64 to be stable
+// across versions
+var _CHUNKER_GEAR = initGear()
+
+func initGear() [256]uint64 {
+	var res [256]uint64
+	h, _ := hash.NewXXHash64(0x4B414E5A)
+
+	for i := range res {
+		res[i] = h.Hash([]byte{byte(i)})
+	}
+
+	return res
+}
+
+// Chunker splits the data of an io.Reader into content defined chunks:
+// the boundaries depend on the content (rolling gear hash, normalized as
+// in FastCDC) so that an insertion or deletion only changes the chunks
+// around the modification.
+type Chunker struct {
+	r       io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // used before avgSize: harder to match
+	maskL   uint64 // used after avgSize: easier to match
+	buf     []byte
+	start   int
+	end     int
+	eof     bool
+}
+
+// NewChunker creates a new Chunker with the provided chunk sizes.
+// The average size is rounded down to a power of 2.
+func NewChunker(r io.Reader, minSize, avgSize, maxSize int) (*Chunker, error) {
+	if minSize < _CHUNKER_MIN_SIZE || minSize > avgSize || avgSize > maxSize || maxSize > _CHUNKER_MAX_SIZE {
+		return nil, fmt.Errorf("Invalid chunk sizes: must be %d <= min (%d) <= avg (%d) <= max (%d) <= %d",
+			_CHUNKER_MIN_SIZE, minSize, avgSize, maxSize, _CHUNKER_MAX_SIZE)
+	}
+
+	bits := uint(0)
+
+	for 1<<(bits+1) <= avgSize {
+		bits++
+	}
+
+	this := &Chunker{r: r, minSize: minSize, avgSize: 1 << bits, maxSize: maxSize}
+	this.maskS = (uint64(1) << (bits + 1)) - 1
+	this.maskL = (uint64(1) << (bits - 1)) - 1
+	this.buf = make([]byte, 2*maxSize)
+	return this, nil
+}
+
+// Next returns the next chunk or io.EOF at the end of the data. The chunk
+// is only valid until the next call.
+func (this *Chunker) Next() ([]byte, error) {
+	if this.end-this.start < this.maxSize && this.eof == false {
+		if err := this.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	if this.start == this.end {
+		return nil, io.EOF
+	}
+
+	n := this.cut(this.buf[this.start:this.end])
+	chunk := this.buf[this.start : this.start+n]
+	this.start += n
+	return chunk, nil
+}
+
+// Move the pending data to the front of the buffer and read until
+// the buffer is full or EOF
+func (this *Chunker) fill() error {
+	copy(this.buf, this.buf[this.start:this.end])
+	this.end -= this.start
+	this.start = 0
+
+	for this.end < len(this.buf) && this.eof == false {
+		n, err := this.r.Read(this.buf[this.end:])
+		this.end += n
+
+		if err == io.EOF {
+			this.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Return the size of the chunk at the start of data
+func (this *Chunker) cut(data []byte) int {
+	if len(data) <= this.minSize {
+		return len(data)
+	}
+
+	end := len(data)
+
+	if end > this.maxSize {
+		end = this.maxSize
+	}
+
+	mid := this.avgSize
+
+	if mid > end {
+		mid = end
+	}
+
+	h := uint64(0)
+	i := this.minSize
+
+	for ; i < mid; i++ {
+		h = (h << 1) + _CHUNKER_GEAR[data[i]]
+
+		if h&this.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < end; i++ {
+		h = (h << 1) + _CHUNKER_GEAR[data[i]]
+
+		if h&this.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return end
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chunkstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned by the stores for missing chunks
+var ErrNotFound = errors.New("Chunk not found")
+
+// ID is the address of a chunk: the SHA-256 of the uncompressed content
+type ID [32]byte
+
+// String returns the hexadecimal form of the ID
+func (this ID) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+// ParseID returns the ID in hexadecimal form
+func ParseID(str string) (ID, error) {
+	var id ID
+
+	if len(str) != 2*len(id) {
+		return id, fmt.Errorf("Invalid chunk id: '%v'", str)
+	}
+
+	if _, err := hex.Decode(id[:], []byte(str)); err != nil {
+		return id, fmt.Errorf("Invalid chunk id: '%v'", str)
+	}
+
+	return id, nil
+}
+
+// Store is the storage backend of the compressed chunks. Put is only
+// called for missing chunks and a chunk is never modified once stored.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Has returns true if the chunk is stored
+	Has(id ID) (bool, error)
+
+	// Get returns the stored data of the chunk or ErrNotFound
+	Get(id ID) ([]byte, error)
+
+	// Put stores the data of the chunk
+	Put(id ID, data []byte) error
+}
+
+// MemoryStore is a Store keeping the chunks in memory
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	chunks map[ID][]byte
+}
+
+// NewMemoryStore creates a new empty instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: make(map[ID][]byte)}
+}
+
+// Has returns true if the chunk is stored
+func (this *MemoryStore) Has(id ID) (bool, error) {
+	this.mutex.RLock()
+	_, exists := this.chunks[id]
+	this.mutex.RUnlock()
+	return exists, nil
+}
+
+// Get returns the stored data of the chunk or ErrNotFound
+func (this *MemoryStore) Get(id ID) ([]byte, error) {
+	this.mutex.RLock()
+	data, exists := this.chunks[id]
+	this.mutex.RUnlock()
+
+	if exists == false {
+		return nil, ErrNotFound
+	}
+
+	return data, nil
+}
+
+// Put stores a copy of the data of the chunk
+func (this *MemoryStore) Put(id ID, data []byte) error {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	this.mutex.Lock()
+	this.chunks[id] = buf
+	this.mutex.Unlock()
+	return nil
+}
+
+// Len returns the number of stored chunks
+func (this *MemoryStore) Len() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return len(this.chunks)
+}
+
+// DirStore is a Store keeping each chunk in a file of a directory tree
+// (root/ab/abcdef...: the first byte of the ID selects a sub-directory)
+type DirStore struct {
+	root string
+}
+
+// NewDirStore creates a new instance of DirStore. The root directory is
+// created if it does not exist.
+func NewDirStore(root string) (*DirStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DirStore{root: root}, nil
+}
+
+func (this *DirStore) path(id ID) string {
+	name := id.String()
+	return filepath.Join(this.root, name[0:2], name)
+}
+
+// Has returns true if the chunk is stored
+func (this *DirStore) Has(id ID) (bool, error) {
+	_, err := os.Stat(this.path(id))
+
+	if err == nil {
+		return true, nil
+	}
+
+	if os.IsNotExist(err) == true {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Get returns the stored data of the chunk or ErrNotFound
+func (this *DirStore) Get(id ID) ([]byte, error) {
+	data, err := ioutil.ReadFile(this.path(id))
+
+	if os.IsNotExist(err) == true {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Put stores the data of the chunk. The file is written under a temporary
+// name then renamed so that readers never see partial chunks.
+func (this *DirStore) Put(id ID, data []byte) error {
+	name := this.path(id)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
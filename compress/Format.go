@@ -0,0 +1,109 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	// EXTENSION is the file name extension of kanzi bitstreams
+	EXTENSION = ".knz"
+)
+
+// MAGIC is the signature at the start of kanzi bitstreams
+var MAGIC = []byte("KANZ")
+
+// Format describes the kanzi bitstream format with the shape expected by
+// the archiver libraries (mholt/archiver style): name, extension, magic
+// detection and stream constructors. It has no dependency on them: these
+// methods satisfy their Compressor and Decompressor interfaces and the
+// format can be registered with a thin adapter for Match, whose result
+// type belongs to each library.
+type Format struct {
+	// Compression level, see NewWriterLevel. As in the archiver formats,
+	// the zero value means DEFAULT_COMPRESSION.
+	CompressionLevel int
+}
+
+// Name returns the name of the format
+func (this Format) Name() string {
+	return "kanzi"
+}
+
+// Extension returns the file name extension of the format
+func (this Format) Extension() string {
+	return EXTENSION
+}
+
+// MagicBytes returns the signature at the start of the streams
+func (this Format) MagicBytes() []byte {
+	return append([]byte{}, MAGIC...)
+}
+
+// Match reports whether the file name has the kanzi extension and whether
+// the stream starts with the kanzi signature. The stream is read (4 bytes),
+// a nil stream matches by name only.
+func (this Format) Match(filename string, stream io.Reader) (byName bool, byStream bool, err error) {
+	byName = strings.HasSuffix(strings.ToLower(filename), EXTENSION)
+
+	if stream == nil {
+		return byName, false, nil
+	}
+
+	buf := make([]byte, len(MAGIC))
+
+	if _, err = io.ReadFull(stream, buf); err != nil {
+		// Short streams do not match
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+
+		return byName, false, err
+	}
+
+	return byName, bytes.Equal(buf, MAGIC), nil
+}
+
+// OpenWriter returns a writer compressing the data (Compressor interface)
+func (this Format) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	level := this.CompressionLevel
+
+	if level == 0 {
+		level = DEFAULT_COMPRESSION
+	}
+
+	res, err := NewWriterLevel(w, level)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// OpenReader returns a reader decompressing the data (Decompressor interface)
+func (this Format) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	res, err := NewReader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"io"
+	"io/ioutil"
+
+	kio "github.com/flanglet/kanzi-go/io"
+)
+
+// Reader decompresses a kanzi bitstream read from the underlying reader
+type Reader struct {
+	cis *kio.CompressedInputStream
+	err error
+}
+
+// NewReader creates a new Reader decompressing the data read from 'r'.
+// The bitstream header is read lazily, on the first call to Read.
+// If 'r' also implements io.ByteReader (EG. bufio.Reader), the data is read
+// byte per byte and nothing is consumed after the end of the bitstream, the
+// same guarantee as compress/flate. Otherwise, the Reader may read more data
+// than necessary from 'r'.
+func NewReader(r io.Reader) (*Reader, error) {
+	this := &Reader{}
+
+	if err := this.Reset(r); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Read reads up to len(data) decompressed bytes into data. It returns io.EOF
+// once the end of stream marker has been reached.
+func (this *Reader) Read(data []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	n, err := this.cis.Read(data)
+
+	if err != nil {
+		this.err = err
+	} else if n == 0 {
+		this.err = io.EOF
+	}
+
+	return n, this.err
+}
+
+// Close releases the resources of the reader. It does not close the
+// underlying reader. Idempotent.
+func (this *Reader) Close() error {
+	if this.cis == nil {
+		return nil
+	}
+
+	return this.cis.Close()
+}
+
+// Reset discards the state of the reader and makes it equivalent to the
+// result of NewReader with 'r' as reader
+func (this *Reader) Reset(r io.Reader) error {
+	ctx := map[string]interface{}{"jobs": uint(1)}
+
+	if br, isByteReader := r.(io.ByteReader); isByteReader == true {
+		r = byteReader{br: br}
+	}
+
+	this.cis, this.err = kio.NewCompressedInputStreamWithCtx(ioutil.NopCloser(r), ctx)
+	return this.err
+}
+
+// byteReader returns one byte per call so that the bitstream never reads
+// beyond the bytes it needs
+type byteReader struct {
+	br io.ByteReader
+}
+
+func (this byteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c, err := this.br.ReadByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	b[0] = c
+	return 1, nil
+}
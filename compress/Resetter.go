@@ -0,0 +1,60 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// Compile time checks of the interfaces used by the code written for the
+// standard library (and klauspost/compress) to reuse readers and writers
+var (
+	_ flate.Resetter = (*flateReader)(nil)
+	_ io.ReadCloser  = (*Reader)(nil)
+	_ interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	} = (*Writer)(nil)
+)
+
+// NewFlateReader returns a ReadCloser decompressing the data read from 'r'
+// with the same signature as flate.NewReader. The result also implements
+// flate.Resetter (hence zlib.Resetter): it can be reused by frameworks
+// pooling decompressors. Errors are returned by Read.
+func NewFlateReader(r io.Reader) io.ReadCloser {
+	this := &flateReader{}
+	this.Reset(r, nil)
+	return this
+}
+
+// flateReader is a Reader with the Reset method of compress/flate
+type flateReader struct {
+	Reader
+}
+
+// Reset discards the state of the reader and starts reading from 'r'.
+// Kanzi bitstreams do not use preset dictionaries: 'dict' must be empty.
+func (this *flateReader) Reset(r io.Reader, dict []byte) error {
+	if len(dict) > 0 {
+		this.err = errors.New("Preset dictionaries are not supported")
+		return this.err
+	}
+
+	return this.Reader.Reset(r)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compress provides a Writer and a Reader with the same shape as
+// the ones of the standard library compression packages (compress/flate,
+// compress/gzip, ...) so that kanzi can be used as a drop-in replacement:
+//
+//	w, err := compress.NewWriterLevel(dst, compress.BEST_COMPRESSION)
+//	w.Write(data)
+//	w.Close()
+//
+//	r, err := compress.NewReader(src)
+//	data, err := ioutil.ReadAll(r)
+//
+// The compressed data is a regular kanzi bitstream.
+package compress
+
+import (
+	"fmt"
+	"io"
+
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+// Compression levels, see pipeline.ForLevel
+const (
+	NO_COMPRESSION      = 0
+	BEST_SPEED          = 1
+	BEST_COMPRESSION    = 8
+	DEFAULT_COMPRESSION = -1
+
+	_DEFAULT_LEVEL      = 3
+	_DEFAULT_BLOCK_SIZE = 1024 * 1024
+)
+
+// Writer compresses the data written to it and writes the kanzi bitstream
+// to the underlying writer
+type Writer struct {
+	cos *kio.CompressedOutputStream
+	ctx map[string]interface{}
+	err error
+}
+
+// writeCloser prevents the compressed stream from closing the underlying writer
+type writeCloser struct {
+	w io.Writer
+}
+
+func (this writeCloser) Write(b []byte) (int, error) {
+	return this.w.Write(b)
+}
+
+func (this writeCloser) Close() error {
+	return nil
+}
+
+// NewWriter creates a new Writer using the default compression level
+func NewWriter(w io.Writer) *Writer {
+	res, _ := NewWriterLevel(w, DEFAULT_COMPRESSION)
+	return res
+}
+
+// NewWriterLevel creates a new Writer using the provided compression level.
+// The level is DEFAULT_COMPRESSION or in [NO_COMPRESSION..BEST_COMPRESSION],
+// see pipeline.ForLevel.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level == DEFAULT_COMPRESSION {
+		level = _DEFAULT_LEVEL
+	}
+
+	if level < NO_COMPRESSION || level > BEST_COMPRESSION {
+		return nil, fmt.Errorf("Invalid compression level: %d (must be in [%d..%d])",
+			level, DEFAULT_COMPRESSION, BEST_COMPRESSION)
+	}
+
+	desc, err := pipeline.ForLevel(level)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Writer{}
+	this.ctx = map[string]interface{}{
+		"transform": desc.TransformName(),
+		"codec":     desc.Entropy,
+		"blockSize": uint(_DEFAULT_BLOCK_SIZE),
+		"jobs":      uint(1),
+		"checksum":  false,
+	}
+
+	this.Reset(w)
+
+	if this.err != nil {
+		return nil, this.err
+	}
+
+	return this, nil
+}
+
+// Write compresses the data. The compressed bytes are written to the
+// underlying writer once a block is full or when Flush or Close is called.
+func (this *Writer) Write(data []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	n, err := this.cos.Write(data)
+
+	if err != nil {
+		this.err = err
+	}
+
+	return n, err
+}
+
+// Flush compresses the pending data and writes it to the underlying writer
+// so that it can be decoded by a reader. Flushing degrades compression.
+func (this *Writer) Flush() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	if err := this.cos.Flush(); err != nil {
+		this.err = err
+	}
+
+	return this.err
+}
+
+// Close compresses the pending data and writes the end of stream marker.
+// It does not close the underlying writer. Idempotent.
+func (this *Writer) Close() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	if err := this.cos.Close(); err != nil {
+		this.err = err
+	}
+
+	return this.err
+}
+
+// Reset discards the state of the writer and makes it equivalent to the
+// result of NewWriterLevel with the same level and 'w' as writer
+func (this *Writer) Reset(w io.Writer) {
+	// The stream adds entries to the context, use a copy
+	ctx := make(map[string]interface{}, len(this.ctx))
+
+	for k, v := range this.ctx {
+		ctx[k] = v
+	}
+
+	this.cos, this.err = kio.NewCompressedOutputStreamWithCtx(writeCloser{w: w}, ctx)
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command cshared exports the kanzi library through a C ABI. Build it with
+//
+//	go build -buildmode=c-shared -o libkanzi.so ./cshared
+//
+// The API is described in kanzi.h, which is the header to use from C
+// (rather than the header generated by cgo).
+package main
+
+/*
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"math"
+	"sync"
+	"unsafe"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+const (
+	_DEFAULT_LEVEL      = 3
+	_DEFAULT_BLOCK_SIZE = 1024 * 1024
+)
+
+var (
+	_VERSION = C.CString(kio.LIBRARY_VERSION)
+
+	handleMutex sync.Mutex
+	handles     = make(map[uintptr]interface{})
+	nextHandle  = uintptr(0)
+)
+
+func main() {}
+
+//export kanzi_version
+func kanzi_version() *C.char {
+	return _VERSION
+}
+
+//export kanzi_free
+func kanzi_free(ptr unsafe.Pointer) {
+	C.free(ptr)
+}
+
+//export kanzi_compress
+func kanzi_compress(pipelineStr *C.char, blockSize, jobs C.uint32_t, checksum C.int,
+	src *C.uint8_t, srcLen C.size_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	if dst == nil || dstLen == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	input, code := toGo(src, srcLen)
+
+	if code != 0 {
+		return code
+	}
+
+	c, code := newCompressor(pipelineStr, blockSize, jobs, checksum)
+
+	if code != 0 {
+		return code
+	}
+
+	output, err := c.Write(input)
+
+	if err != nil {
+		c.Close()
+		return errorCode(err)
+	}
+
+	last, err := c.Close()
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(append(output, last...), dst, dstLen)
+}
+
+//export kanzi_decompress
+func kanzi_decompress(jobs C.uint32_t, src *C.uint8_t, srcLen C.size_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	if dst == nil || dstLen == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	input, code := toGo(src, srcLen)
+
+	if code != 0 {
+		return code
+	}
+
+	d, code := newDecompressor(jobs)
+
+	if code != 0 {
+		return code
+	}
+
+	output, err := d.Write(input)
+
+	if err != nil {
+		d.Close()
+		return errorCode(err)
+	}
+
+	last, err := d.Close()
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(append(output, last...), dst, dstLen)
+}
+
+//export kanzi_compressor_new
+func kanzi_compressor_new(pipelineStr *C.char, blockSize, jobs C.uint32_t, checksum C.int, handle *C.uintptr_t) C.int {
+	if handle == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	c, code := newCompressor(pipelineStr, blockSize, jobs, checksum)
+
+	if code != 0 {
+		return code
+	}
+
+	*handle = registerHandle(c)
+	return 0
+}
+
+//export kanzi_compressor_write
+func kanzi_compressor_write(handle C.uintptr_t, src *C.uint8_t, srcLen C.size_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	c, ok := getHandle(handle, false).(*kio.ChunkCompressor)
+
+	if ok == false || dst == nil || dstLen == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	input, code := toGo(src, srcLen)
+
+	if code != 0 {
+		return code
+	}
+
+	output, err := c.Write(input)
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(output, dst, dstLen)
+}
+
+//export kanzi_compressor_close
+func kanzi_compressor_close(handle C.uintptr_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	c, ok := getHandle(handle, false).(*kio.ChunkCompressor)
+
+	if ok == false {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	// Only a handle of the right kind is released
+	getHandle(handle, true)
+
+	if dst == nil || dstLen == nil {
+		c.Close()
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	output, err := c.Close()
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(output, dst, dstLen)
+}
+
+//export kanzi_decompressor_new
+func kanzi_decompressor_new(jobs C.uint32_t, handle *C.uintptr_t) C.int {
+	if handle == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	d, code := newDecompressor(jobs)
+
+	if code != 0 {
+		return code
+	}
+
+	*handle = registerHandle(d)
+	return 0
+}
+
+//export kanzi_decompressor_write
+func kanzi_decompressor_write(handle C.uintptr_t, src *C.uint8_t, srcLen C.size_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	d, ok := getHandle(handle, false).(*kio.ChunkDecompressor)
+
+	if ok == false || dst == nil || dstLen == nil {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	input, code := toGo(src, srcLen)
+
+	if code != 0 {
+		return code
+	}
+
+	output, err := d.Write(input)
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(output, dst, dstLen)
+}
+
+//export kanzi_decompressor_close
+func kanzi_decompressor_close(handle C.uintptr_t, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	d, ok := getHandle(handle, false).(*kio.ChunkDecompressor)
+
+	if ok == false {
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	// Only a handle of the right kind is released
+	getHandle(handle, true)
+
+	if dst == nil || dstLen == nil {
+		d.Close()
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	output, err := d.Close()
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	return toC(output, dst, dstLen)
+}
+
+func newCompressor(pipelineStr *C.char, blockSize, jobs C.uint32_t, checksum C.int) (*kio.ChunkCompressor, C.int) {
+	var desc *pipeline.Description
+	var err error
+
+	if pipelineStr == nil || *pipelineStr == 0 {
+		desc, err = pipeline.ForLevel(_DEFAULT_LEVEL)
+	} else {
+		desc, err = pipeline.Parse(C.GoString(pipelineStr))
+	}
+
+	if err != nil {
+		return nil, kanzi.ERR_INVALID_CODEC
+	}
+
+	if blockSize == 0 {
+		blockSize = _DEFAULT_BLOCK_SIZE
+	}
+
+	if jobs == 0 {
+		jobs = 1
+	}
+
+	ctx := map[string]interface{}{
+		"transform": desc.TransformName(),
+		"codec":     desc.Entropy,
+		"blockSize": uint(blockSize),
+		"jobs":      uint(jobs),
+		"checksum":  checksum != 0,
+	}
+
+	c, err := kio.NewChunkCompressor(ctx)
+
+	if err != nil {
+		return nil, errorCode(err)
+	}
+
+	return c, 0
+}
+
+func newDecompressor(jobs C.uint32_t) (*kio.ChunkDecompressor, C.int) {
+	if jobs == 0 {
+		jobs = 1
+	}
+
+	d, err := kio.NewChunkDecompressor(map[string]interface{}{"jobs": uint(jobs)})
+
+	if err != nil {
+		return nil, errorCode(err)
+	}
+
+	return d, 0
+}
+
+func registerHandle(v interface{}) C.uintptr_t {
+	handleMutex.Lock()
+	defer handleMutex.Unlock()
+	nextHandle++
+	handles[nextHandle] = v
+	return C.uintptr_t(nextHandle)
+}
+
+// getHandle returns the object registered for the handle or nil.
+// The handle is unregistered if 'release' is true.
+func getHandle(handle C.uintptr_t, release bool) interface{} {
+	handleMutex.Lock()
+	defer handleMutex.Unlock()
+	v := handles[uintptr(handle)]
+
+	if release == true {
+		delete(handles, uintptr(handle))
+	}
+
+	return v
+}
+
+// toGo copies the C buffer to a Go slice
+func toGo(src *C.uint8_t, srcLen C.size_t) ([]byte, C.int) {
+	if srcLen == 0 {
+		return []byte{}, 0
+	}
+
+	if src == nil || srcLen > math.MaxInt32 {
+		return nil, kanzi.ERR_INVALID_PARAM
+	}
+
+	return C.GoBytes(unsafe.Pointer(src), C.int(srcLen)), 0
+}
+
+// toC copies the data to a buffer allocated with malloc
+func toC(data []byte, dst **C.uint8_t, dstLen *C.size_t) C.int {
+	*dst = nil
+	*dstLen = 0
+
+	if len(data) > 0 {
+		*dst = (*C.uint8_t)(C.CBytes(data))
+		*dstLen = C.size_t(len(data))
+	}
+
+	return 0
+}
+
+func errorCode(err error) C.int {
+	if e, isErr := err.(interface{ ErrorCode() int }); isErr == true {
+		return C.int(e.ErrorCode())
+	}
+
+	return kanzi.ERR_UNKNOWN
+}
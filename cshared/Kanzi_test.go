@@ -0,0 +1,225 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+)
+
+func getInput(rnd *rand.Rand, size int) []byte {
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "\n"}
+	var buf bytes.Buffer
+
+	for buf.Len() < size {
+		buf.WriteString(words[rnd.Intn(len(words))])
+
+		if rnd.Intn(8) == 0 {
+			buf.WriteByte(byte(rnd.Intn(256)))
+		}
+	}
+
+	return buf.Bytes()[0:size]
+}
+
+func TestVersion(b *testing.T) {
+	if v := versionString(); v != kio.LIBRARY_VERSION {
+		b.Errorf("Incorrect version: %v, expected %v", v, kio.LIBRARY_VERSION)
+	}
+}
+
+func TestOneShot(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// An empty input gives a stream without header that cannot be decoded
+	// (same as kanzi 1.8): only the compression is checked
+	if _, code := compressBuffer("", 0, 0, false, []byte{}); code != 0 {
+		b.Errorf("Empty input: compression error %d", code)
+	}
+
+	for _, size := range []int{1, 1000, 300000} {
+		input := getInput(rnd, size)
+
+		for _, p := range []string{"", "NONE&NONE", "TEXT+LZ&HUFFMAN", "BWT+MTFT+ZRLT&ANS0"} {
+			fmt.Printf("One shot round trip: %d bytes, pipeline '%v'\n", size, p)
+			compressed, code := compressBuffer(p, 65536, 2, true, input)
+
+			if code != 0 {
+				b.Fatalf("Pipeline '%v', size %d: compression error %d", p, size, code)
+			}
+
+			output, code := decompressBuffer(2, compressed)
+
+			if code != 0 {
+				b.Fatalf("Pipeline '%v', size %d: decompression error %d", p, size, code)
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("Pipeline '%v', size %d: decompressed data differs from input", p, size)
+			}
+		}
+	}
+
+	if _, code := compressBuffer("FOO&NONE", 0, 0, false, []byte("abc")); code != kanzi.ERR_INVALID_CODEC {
+		b.Errorf("Invalid pipeline: got code %d, expected %d", code, kanzi.ERR_INVALID_CODEC)
+	}
+
+	if _, code := decompressBuffer(1, []byte("not a kanzi stream")); code == 0 {
+		b.Errorf("Invalid stream: no error")
+	}
+
+	if n := registeredHandles(); n != 0 {
+		b.Errorf("The one shot functions registered %d handles", n)
+	}
+}
+
+func TestHandles(b *testing.T) {
+	fmt.Println("Handle lifecycle")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := getInput(rnd, 200000)
+
+	// Compress with several writes
+	c, code := newCompressorHandle("LZ&HUFFMAN", 16384, 1, true)
+
+	if code != 0 || c == 0 {
+		b.Fatalf("Cannot create compressor: code %d, handle %d", code, c)
+	}
+
+	d, code := newDecompressorHandle(1)
+
+	if code != 0 || d == 0 || d == c {
+		b.Fatalf("Cannot create decompressor: code %d, handle %d", code, d)
+	}
+
+	if n := registeredHandles(); n != 2 {
+		b.Errorf("%d handles registered, expected 2", n)
+	}
+
+	// A handle of the other kind is rejected and stays registered
+	if _, code = writeHandle(c, false, input[0:100]); code != kanzi.ERR_INVALID_PARAM {
+		b.Errorf("Compressor handle used to decompress: got code %d", code)
+	}
+
+	if _, code = closeHandle(d, true, true); code != kanzi.ERR_INVALID_PARAM {
+		b.Errorf("Decompressor handle closed as a compressor: got code %d", code)
+	}
+
+	if n := registeredHandles(); n != 2 {
+		b.Errorf("%d handles registered after misuse, expected 2", n)
+	}
+
+	var compressed []byte
+
+	for n := 0; n < len(input); {
+		end := n + 1 + rnd.Intn(50000)
+
+		if end > len(input) {
+			end = len(input)
+		}
+
+		out, code := writeHandle(c, true, input[n:end])
+
+		if code != 0 {
+			b.Fatalf("Compressor write error %d", code)
+		}
+
+		compressed = append(compressed, out...)
+		n = end
+	}
+
+	out, code := closeHandle(c, true, true)
+
+	if code != 0 {
+		b.Fatalf("Compressor close error %d", code)
+	}
+
+	compressed = append(compressed, out...)
+
+	// The handle is released by close
+	if _, code = writeHandle(c, true, input[0:100]); code != kanzi.ERR_INVALID_PARAM {
+		b.Errorf("Write after close: got code %d", code)
+	}
+
+	if _, code = closeHandle(c, true, true); code != kanzi.ERR_INVALID_PARAM {
+		b.Errorf("Second close: got code %d", code)
+	}
+
+	// Decompress with several writes
+	var output []byte
+
+	for n := 0; n < len(compressed); {
+		end := n + 1 + rnd.Intn(5000)
+
+		if end > len(compressed) {
+			end = len(compressed)
+		}
+
+		out, code := writeHandle(d, false, compressed[n:end])
+
+		if code != 0 {
+			b.Fatalf("Decompressor write error %d", code)
+		}
+
+		output = append(output, out...)
+		n = end
+	}
+
+	out, code = closeHandle(d, false, true)
+
+	if code != 0 {
+		b.Fatalf("Decompressor close error %d", code)
+	}
+
+	output = append(output, out...)
+
+	if bytes.Equal(input, output) == false {
+		b.Errorf("Decompressed data differs from input")
+	}
+
+	// The streaming output is a regular stream
+	if output, code = decompressBuffer(1, compressed); code != 0 || bytes.Equal(input, output) == false {
+		b.Errorf("One shot decompression of the streaming output failed (code %d)", code)
+	}
+
+	// A close without output pointers releases the handle
+	c, _ = newCompressorHandle("", 0, 0, false)
+
+	if _, code = closeHandle(c, true, false); code != kanzi.ERR_INVALID_PARAM {
+		b.Errorf("Close without output: got code %d", code)
+	}
+
+	if n := registeredHandles(); n != 0 {
+		b.Errorf("%d handles still registered", n)
+	}
+
+	// Truncated stream: the error is reported by close
+	d, _ = newDecompressorHandle(1)
+	writeHandle(d, false, compressed[0:len(compressed)/2])
+
+	if _, code = closeHandle(d, false, true); code == 0 {
+		b.Errorf("Truncated stream: no error")
+	}
+
+	if n := registeredHandles(); n != 0 {
+		b.Errorf("%d handles still registered after error", n)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// Go wrappers of the exported functions. Test files cannot use cgo: the
+// tests call the C API through these functions, with C buffers and handles.
+
+// cBuffer copies the data to a buffer allocated with malloc (nil if empty).
+// The buffer must be released with C.free.
+func cBuffer(data []byte) (*C.uint8_t, C.size_t) {
+	if len(data) == 0 {
+		return nil, 0
+	}
+
+	return (*C.uint8_t)(C.CBytes(data)), C.size_t(len(data))
+}
+
+// goBuffer copies a buffer returned by the API and releases it with kanzi_free
+func goBuffer(ptr *C.uint8_t, length C.size_t) []byte {
+	if ptr == nil {
+		return nil
+	}
+
+	res := C.GoBytes(unsafe.Pointer(ptr), C.int(length))
+	kanzi_free(unsafe.Pointer(ptr))
+	return res
+}
+
+// cString returns nil for an empty string (default pipeline)
+func cString(str string) *C.char {
+	if str == "" {
+		return nil
+	}
+
+	return C.CString(str)
+}
+
+func versionString() string {
+	return C.GoString(kanzi_version())
+}
+
+func compressBuffer(pipelineStr string, blockSize, jobs uint32, checksum bool, src []byte) ([]byte, int) {
+	p := cString(pipelineStr)
+	defer C.free(unsafe.Pointer(p))
+	in, inLen := cBuffer(src)
+	defer C.free(unsafe.Pointer(in))
+	cksum := C.int(0)
+
+	if checksum == true {
+		cksum = 1
+	}
+
+	var out *C.uint8_t
+	var outLen C.size_t
+	code := kanzi_compress(p, C.uint32_t(blockSize), C.uint32_t(jobs), cksum, in, inLen, &out, &outLen)
+	return goBuffer(out, outLen), int(code)
+}
+
+func decompressBuffer(jobs uint32, src []byte) ([]byte, int) {
+	in, inLen := cBuffer(src)
+	defer C.free(unsafe.Pointer(in))
+	var out *C.uint8_t
+	var outLen C.size_t
+	code := kanzi_decompress(C.uint32_t(jobs), in, inLen, &out, &outLen)
+	return goBuffer(out, outLen), int(code)
+}
+
+func newCompressorHandle(pipelineStr string, blockSize, jobs uint32, checksum bool) (uintptr, int) {
+	p := cString(pipelineStr)
+	defer C.free(unsafe.Pointer(p))
+	cksum := C.int(0)
+
+	if checksum == true {
+		cksum = 1
+	}
+
+	var handle C.uintptr_t
+	code := kanzi_compressor_new(p, C.uint32_t(blockSize), C.uint32_t(jobs), cksum, &handle)
+	return uintptr(handle), int(code)
+}
+
+func newDecompressorHandle(jobs uint32) (uintptr, int) {
+	var handle C.uintptr_t
+	code := kanzi_decompressor_new(C.uint32_t(jobs), &handle)
+	return uintptr(handle), int(code)
+}
+
+// writeHandle calls kanzi_compressor_write or kanzi_decompressor_write
+func writeHandle(handle uintptr, compressor bool, src []byte) ([]byte, int) {
+	in, inLen := cBuffer(src)
+	defer C.free(unsafe.Pointer(in))
+	var out *C.uint8_t
+	var outLen C.size_t
+	var code C.int
+
+	if compressor == true {
+		code = kanzi_compressor_write(C.uintptr_t(handle), in, inLen, &out, &outLen)
+	} else {
+		code = kanzi_decompressor_write(C.uintptr_t(handle), in, inLen, &out, &outLen)
+	}
+
+	return goBuffer(out, outLen), int(code)
+}
+
+// closeHandle calls kanzi_compressor_close or kanzi_decompressor_close.
+// A nil output pointer is passed if 'output' is false.
+func closeHandle(handle uintptr, compressor, output bool) ([]byte, int) {
+	var out *C.uint8_t
+	var outLen C.size_t
+	pOut, pOutLen := &out, &outLen
+
+	if output == false {
+		pOut, pOutLen = nil, nil
+	}
+
+	var code C.int
+
+	if compressor == true {
+		code = kanzi_compressor_close(C.uintptr_t(handle), pOut, pOutLen)
+	} else {
+		code = kanzi_decompressor_close(C.uintptr_t(handle), pOut, pOutLen)
+	}
+
+	return goBuffer(out, outLen), int(code)
+}
+
+// registeredHandles returns the number of open handles
+func registeredHandles() int {
+	handleMutex.Lock()
+	defer handleMutex.Unlock()
+	return len(handles)
+}
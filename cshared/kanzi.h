@@ -0,0 +1,99 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+ * C API of the kanzi library. Build the shared library with
+ *
+ *     go build -buildmode=c-shared -o libkanzi.so ./cshared
+ *
+ * The data produced by the compression functions uses the kanzi bitstream
+ * format (same as the files produced by the command line tool).
+ *
+ * All functions return 0 on success or a kanzi error code (see Kanzi.go)
+ * on failure. KANZI_ERR_INVALID_PARAM is returned for invalid arguments
+ * (EG. NULL pointers or unknown handles).
+ *
+ * Output buffers are allocated by the library and must be released with
+ * kanzi_free(). An empty output is returned as a NULL buffer and a length
+ * of 0.
+ *
+ * The handles of the streaming API are not thread safe: a handle must not be
+ * used concurrently by several threads. Distinct handles are independent.
+ * A compressor handle passed to a decompressor function (or the reverse) is
+ * rejected with KANZI_ERR_INVALID_PARAM and stays valid.
+ */
+
+#ifndef KANZI_H
+#define KANZI_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+#define KANZI_ERR_INVALID_PARAM 18
+#define KANZI_ERR_UNKNOWN 127
+
+typedef uintptr_t kanzi_handle;
+
+/* Library version (EG. "1.8"). The string must not be freed. */
+const char* kanzi_version(void);
+
+/* Release a buffer allocated by the library */
+void kanzi_free(void* ptr);
+
+/*
+ * One shot compression of src into a newly allocated buffer.
+ * pipeline: transforms and entropy codec (EG. "TEXT+BWT+RANK+ZRLT&ANS0").
+ *           NULL or "" selects the default pipeline.
+ * blockSize: size of the blocks in bytes (0 selects the default size).
+ * jobs: number of concurrent jobs (0 selects 1).
+ * checksum: non zero to add a block checksum.
+ */
+int kanzi_compress(const char* pipeline, uint32_t blockSize, uint32_t jobs, int checksum,
+    const uint8_t* src, size_t srcLen, uint8_t** dst, size_t* dstLen);
+
+/* One shot decompression of src into a newly allocated buffer */
+int kanzi_decompress(uint32_t jobs, const uint8_t* src, size_t srcLen, uint8_t** dst, size_t* dstLen);
+
+/*
+ * Streaming compression. Create a compressor (same parameters as kanzi_compress),
+ * then write chunks of data. Each call returns the compressed bytes available so
+ * far (possibly none). Closing the compressor returns the last bytes and
+ * releases the handle (even on error).
+ */
+int kanzi_compressor_new(const char* pipeline, uint32_t blockSize, uint32_t jobs, int checksum,
+    kanzi_handle* handle);
+int kanzi_compressor_write(kanzi_handle handle, const uint8_t* src, size_t srcLen,
+    uint8_t** dst, size_t* dstLen);
+int kanzi_compressor_close(kanzi_handle handle, uint8_t** dst, size_t* dstLen);
+
+/*
+ * Streaming decompression. Each write returns the decompressed bytes available
+ * so far (possibly none). Closing the decompressor returns the last bytes and
+ * releases the handle (even on error).
+ */
+int kanzi_decompressor_new(uint32_t jobs, kanzi_handle* handle);
+int kanzi_decompressor_write(kanzi_handle handle, const uint8_t* src, size_t srcLen,
+    uint8_t** dst, size_t* dstLen);
+int kanzi_decompressor_close(kanzi_handle handle, uint8_t** dst, size_t* dstLen);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -0,0 +1,306 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dictionary converts existing dictionaries (EG. produced by
+// 'zstd --train') into dictionaries usable by the LZ codec ("lzDictionary"
+// context entry), the text codec ("textDictionary" context entry) and the
+// CM and TPAQ predictors ("cmDictionary" context entry).
+package dictionary
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+const (
+	// ZSTD_MAGIC is the signature of zstd dictionaries (little endian)
+	ZSTD_MAGIC = uint32(0xEC30A437)
+
+	_ZSTD_MIN_TABLE_LOG   = 5
+	_ZSTD_MAX_TABLE_LOG   = 9
+	_ZSTD_MAX_OFFSET_CODE = 31
+	_ZSTD_MAX_ML_CODE     = 52
+	_ZSTD_MAX_LL_CODE     = 35
+)
+
+// Dictionary is the content of a dictionary. The ID is 0 for raw dictionaries.
+type Dictionary struct {
+	ID      uint32
+	Content []byte
+}
+
+// Parse returns the dictionary in the data. Zstd dictionaries are recognized
+// by their signature: the entropy tables are skipped and only the content
+// is kept. Any other data is used as a raw dictionary.
+func Parse(data []byte) (*Dictionary, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != ZSTD_MAGIC {
+		if len(data) == 0 {
+			return nil, errors.New("Invalid empty dictionary")
+		}
+
+		return &Dictionary{Content: data}, nil
+	}
+
+	return parseZstd(data)
+}
+
+// See RFC 8878, section 5: magic, ID, entropy tables (Huffman table for
+// literals then FSE tables for offsets, match lengths and literal lengths),
+// 3 repeat offsets and content
+func parseZstd(data []byte) (*Dictionary, error) {
+	this := &Dictionary{ID: binary.LittleEndian.Uint32(data[4:])}
+	idx := 8
+
+	// Huffman table: header byte < 128 => size of FSE compressed weights,
+	// else number of weights (+127) stored as 4 bit values
+	if idx >= len(data) {
+		return nil, errors.New("Invalid zstd dictionary: missing Huffman table")
+	}
+
+	header := int(data[idx])
+	idx++
+
+	if header < 128 {
+		idx += header
+	} else {
+		idx += (header - 127 + 1) >> 1
+	}
+
+	for _, maxSymbol := range []int{_ZSTD_MAX_OFFSET_CODE, _ZSTD_MAX_ML_CODE, _ZSTD_MAX_LL_CODE} {
+		if idx >= len(data) {
+			return nil, errors.New("Invalid zstd dictionary: missing FSE table")
+		}
+
+		n, err := fseTableSize(data[idx:], maxSymbol)
+
+		if err != nil {
+			return nil, err
+		}
+
+		idx += n
+	}
+
+	// Skip repeat offsets
+	idx += 12
+
+	if idx >= len(data) {
+		return nil, errors.New("Invalid zstd dictionary: missing content")
+	}
+
+	this.Content = data[idx:]
+	return this, nil
+}
+
+// Return the size in bytes of a FSE table description (normalized counts)
+func fseTableSize(data []byte, maxSymbol int) (int, error) {
+	bitPos := 0
+
+	readBits := func(n int) int {
+		res := 0
+
+		for i := 0; i < n; i++ {
+			if (bitPos+i)>>3 < len(data) {
+				res |= int((data[(bitPos+i)>>3]>>uint((bitPos+i)&7))&1) << uint(i)
+			}
+		}
+
+		return res
+	}
+
+	tableLog := readBits(4) + _ZSTD_MIN_TABLE_LOG
+	bitPos += 4
+
+	if tableLog > _ZSTD_MAX_TABLE_LOG {
+		return 0, fmt.Errorf("Invalid zstd dictionary: incorrect FSE table log %d", tableLog)
+	}
+
+	remaining := (1 << uint(tableLog)) + 1
+	threshold := 1 << uint(tableLog)
+	nbBits := tableLog + 1
+	symbol := 0
+	previous0 := false
+
+	for remaining > 1 && symbol <= maxSymbol {
+		if previous0 == true {
+			// Repeat flags for symbols with a 0 probability
+			for {
+				repeat := readBits(2)
+				bitPos += 2
+				symbol += repeat
+
+				if repeat != 3 {
+					break
+				}
+			}
+
+			if symbol > maxSymbol {
+				break
+			}
+		}
+
+		max := (2*threshold - 1) - remaining
+		count := readBits(nbBits - 1)
+
+		if count < max {
+			bitPos += nbBits - 1
+		} else {
+			count = readBits(nbBits)
+
+			if count >= threshold {
+				count -= max
+			}
+
+			bitPos += nbBits
+		}
+
+		// A value of -1 is a probability of 'less than 1'
+		count--
+
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+
+		previous0 = count == 0
+		symbol++
+
+		for remaining < threshold && threshold > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+
+	if remaining != 1 {
+		return 0, errors.New("Invalid zstd dictionary: incorrect FSE table")
+	}
+
+	size := (bitPos + 7) >> 3
+
+	if size > len(data) {
+		return 0, errors.New("Invalid zstd dictionary: truncated FSE table")
+	}
+
+	return size, nil
+}
+
+// LZDictionary returns a preset dictionary for the LZ codec made of the last
+// 'maxSize' bytes of the content (the most frequent data is at the end of
+// zstd dictionaries). A maxSize of 0 means function.LZ_MAX_DICTIONARY_SIZE.
+func (this *Dictionary) LZDictionary(maxSize int) []byte {
+	if maxSize <= 0 || maxSize > function.LZ_MAX_DICTIONARY_SIZE {
+		maxSize = function.LZ_MAX_DICTIONARY_SIZE
+	}
+
+	if len(this.Content) <= maxSize {
+		return this.Content
+	}
+
+	return this.Content[len(this.Content)-maxSize:]
+}
+
+// CMDictionary returns a priming dictionary for the CM and TPAQ predictors
+// made of the last 'maxSize' bytes of the content. A maxSize of 0 means
+// entropy.CM_MAX_DICTIONARY_SIZE.
+func (this *Dictionary) CMDictionary(maxSize int) []byte {
+	if maxSize <= 0 || maxSize > entropy.CM_MAX_DICTIONARY_SIZE {
+		maxSize = entropy.CM_MAX_DICTIONARY_SIZE
+	}
+
+	if len(this.Content) <= maxSize {
+		return this.Content
+	}
+
+	return this.Content[len(this.Content)-maxSize:]
+}
+
+// TextDictionary returns a static dictionary for the text codec made of the
+// most frequent words of the content (at most 'maxWords' words). A word is
+// a sequence of 2 to 31 letters, only the first one possibly in upper case.
+// A maxWords of 0 means function.TC_MAX_DICTIONARY_WORDS.
+func (this *Dictionary) TextDictionary(maxWords int) []byte {
+	if maxWords <= 0 || maxWords > function.TC_MAX_DICTIONARY_WORDS {
+		maxWords = function.TC_MAX_DICTIONARY_WORDS
+	}
+
+	freqs := make(map[string]int)
+	content := this.Content
+
+	for i := 0; i < len(content); {
+		if isLetter(content[i]) == false {
+			i++
+			continue
+		}
+
+		j := i + 1
+		valid := true
+
+		for j < len(content) && isLetter(content[j]) {
+			if content[j] < 'a' {
+				valid = false
+			}
+
+			j++
+		}
+
+		if valid == true && j-i >= 2 && j-i <= 31 {
+			// Normalize the first letter, the text codec handles the case
+			word := []byte(string(content[i:j]))
+			word[0] &^= 0x20
+			freqs[string(word)]++
+		}
+
+		i = j
+	}
+
+	words := make([]string, 0, len(freqs))
+
+	for w := range freqs {
+		words = append(words, w)
+	}
+
+	// Rank by bytes saved (words with a small index are cheaper to emit)
+	sort.Slice(words, func(i, j int) bool {
+		si := freqs[words[i]] * (len(words[i]) - 1)
+		sj := freqs[words[j]] * (len(words[j]) - 1)
+
+		if si != sj {
+			return si > sj
+		}
+
+		return words[i] < words[j]
+	})
+
+	if len(words) > maxWords {
+		words = words[0:maxWords]
+	}
+
+	res := make([]byte, 0)
+
+	for _, w := range words {
+		res = append(res, w...)
+	}
+
+	return res
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command DictConverter converts a zstd dictionary (output of 'zstd --train')
+// or a raw dictionary into a dictionary for the LZ codec, the text codec or
+// the CM and TPAQ predictors:
+//
+//	DictConverter -type=lz [-size=N] input output
+//	DictConverter -type=text [-words=N] input output
+//	DictConverter -type=cm [-size=N] input output
+//
+// The output is provided to the codecs with the "lzDictionary", the
+// "textDictionary" or the "cmDictionary" context entry.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/flanglet/kanzi-go/dictionary"
+)
+
+func main() {
+	dictType := flag.String("type", "lz", "type of output dictionary: lz, text or cm")
+	size := flag.Int("size", 0, "max size of a LZ or CM dictionary (0 means max)")
+	words := flag.Int("words", 0, "max number of words of a text dictionary (0 means max)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) != 2 {
+		fmt.Println("Usage: DictConverter -type=lz|text|cm [-size=N] [-words=N] input output")
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+
+	if err != nil {
+		fmt.Printf("Cannot read %v: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	dict, err := dictionary.Parse(data)
+
+	if err != nil {
+		fmt.Printf("Cannot parse %v: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	var output []byte
+
+	switch *dictType {
+	case "lz":
+		output = dict.LZDictionary(*size)
+
+	case "text":
+		output = dict.TextDictionary(*words)
+
+	case "cm":
+		output = dict.CMDictionary(*size)
+
+	default:
+		fmt.Printf("Invalid dictionary type: %v\n", *dictType)
+		os.Exit(1)
+	}
+
+	if err = ioutil.WriteFile(args[1], output, 0644); err != nil {
+		fmt.Printf("Cannot write %v: %v\n", args[1], err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Dictionary %x: %d bytes of content => %d bytes\n", dict.ID, len(dict.Content), len(output))
+}
@@ -32,18 +32,21 @@ const (
 	_DEFAULT_ANS0_CHUNK_SIZE = uint(1 << 15) // 32 KB by default
 	_ANS_MAX_CHUNK_SIZE      = 1 << 27       // 8*MAX_CHUNK_SIZE must not overflow
 	_DEFAULT_ANS_LOG_RANGE   = uint(12)
+	_ANS_MAX_INTERLEAVE      = 4    // max number of interleaved ANS states
+	_ANS_MIN_SEGMENT_SIZE    = 1024 // min number of symbols coded by each interleaved state
 )
 
 // ANSRangeEncoder Asymmetric Numeral System Encoder
 type ANSRangeEncoder struct {
-	bitstream kanzi.OutputBitStream
-	alphabet  []int
-	freqs     []int
-	symbols   []encSymbol
-	buffer    []byte
-	chunkSize int
-	order     uint
-	logRange  uint
+	bitstream  kanzi.OutputBitStream
+	alphabet   []int
+	freqs      []int
+	symbols    []encSymbol
+	buffer     []byte
+	chunkSize  int
+	order      uint
+	logRange   uint
+	interleave uint
 }
 
 // NewANSRangeEncoder creates an instance of ANS encoder.
@@ -51,21 +54,24 @@ type ANSRangeEncoder struct {
 // resetting the frequency stats. 0 means that frequencies calculated at the
 // beginning of the block apply to the whole block
 // Since the number of args is variable, this function can be called like this:
-// NewANSRangeEncoder(bs) or NewANSRangeEncoder(bs, 0, 16384, 12)
-// Arguments are order, chunk size and log range.
+// NewANSRangeEncoder(bs) or NewANSRangeEncoder(bs, 0, 16384, 12, 4)
+// Arguments are order, chunk size, log range and interleave factor.
+// The interleave factor (1, 2 or 4) is the number of ANS states used to
+// encode each chunk. The decoder must be created with the same factor.
 // chunkSize = 0 means 'use input buffer length' during decoding
 func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncoder, error) {
 	if bs == nil {
 		return nil, errors.New("ANS codec: Invalid null bitstream parameter")
 	}
 
-	if len(args) > 3 {
-		return nil, errors.New("ANS codec: At most order, chunk size and log range can be provided")
+	if len(args) > 4 {
+		return nil, errors.New("ANS codec: At most order, chunk size, log range and interleave factor can be provided")
 	}
 
 	chkSize := _DEFAULT_ANS0_CHUNK_SIZE
 	logRange := _DEFAULT_ANS_LOG_RANGE
 	order := uint(0)
+	interleave := uint(1)
 
 	if len(args) > 0 {
 		order = args[0]
@@ -79,6 +85,10 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 		logRange = args[2]
 	}
 
+	if len(args) > 3 {
+		interleave = args[3]
+	}
+
 	if order != 0 && order != 1 {
 		return nil, errors.New("ANS codec: The order must be 0 or 1")
 	}
@@ -95,6 +105,10 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 		return nil, fmt.Errorf("ANS codec: Invalid range: %v (must be in [8..16])", logRange)
 	}
 
+	if checkInterleave(interleave) == false {
+		return nil, fmt.Errorf("ANS codec: Invalid interleave factor: %v (must be 1, 2 or 4)", interleave)
+	}
+
 	this := new(ANSRangeEncoder)
 	this.bitstream = bs
 	this.order = order
@@ -104,6 +118,7 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 	this.symbols = make([]encSymbol, dim*256)
 	this.buffer = make([]byte, 0)
 	this.logRange = logRange
+	this.interleave = interleave
 	this.chunkSize = int(chkSize) << (8 * order)
 	return this, nil
 }
@@ -170,6 +185,8 @@ func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []int, freq
 		llr++
 	}
 
+	bs := newBitAccumulator(this.bitstream)
+
 	// Encode all frequencies (but the first one) by chunks
 	for i := 1; i < alphabetSize; i += chkSize {
 		max := frequencies[alphabet[i]] - 1
@@ -191,7 +208,7 @@ func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []int, freq
 			logMax++
 		}
 
-		this.bitstream.WriteBits(uint64(logMax), llr)
+		bs.writeBits(uint64(logMax), llr)
 
 		if logMax == 0 {
 			// all frequencies equal one in this chunk
@@ -200,10 +217,11 @@ func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []int, freq
 
 		// Write frequencies
 		for j := i; j < endj; j++ {
-			this.bitstream.WriteBits(uint64(frequencies[alphabet[j]]-1), logMax)
+			bs.writeBits(uint64(frequencies[alphabet[j]]-1), logMax)
 		}
 	}
 
+	bs.flush()
 	return nil
 }
 
@@ -258,67 +276,108 @@ func (this *ANSRangeEncoder) Write(block []byte) (int, error) {
 	return end, nil
 }
 
+// The chunk is split into one segment per ANS state (the remaining symbols
+// are coded by the first state). The states are interleaved symbol by symbol
+// so that the decoder can update them independently.
 func (this *ANSRangeEncoder) encodeChunk(block []byte) {
-	st := _ANS_TOP
-	n := len(this.buffer) - 1
+	var st [_ANS_MAX_INTERLEAVE]int
+	states := interleavedStates(this.interleave, len(block))
+	seg := len(block) / states
+	end := seg * states
+	buf := this.buffer
+	n := len(buf) - 1
+
+	for k := 0; k < states; k++ {
+		st[k] = _ANS_TOP
+	}
 
 	if this.order == 0 {
 		symb := this.symbols[0:256]
 
-		for i := len(block) - 1; i >= 0; i-- {
-			sym := symb[block[i]]
+		for i := len(block) - 1; i >= end; i-- {
+			st[0], n = encodeSymbol(buf, n, st[0], &symb[block[i]])
+		}
+
+		switch states {
+		case 4:
+			b0, b1, b2, b3 := block[0:seg], block[seg:2*seg], block[2*seg:3*seg], block[3*seg:end]
+			st0, st1, st2, st3 := st[0], st[1], st[2], st[3]
+
+			for i := len(b0) - 1; i >= 0; i-- {
+				st3, n = encodeSymbol(buf, n, st3, &symb[b3[i]])
+				st2, n = encodeSymbol(buf, n, st2, &symb[b2[i]])
+				st1, n = encodeSymbol(buf, n, st1, &symb[b1[i]])
+				st0, n = encodeSymbol(buf, n, st0, &symb[b0[i]])
+			}
+
+			st[0], st[1], st[2], st[3] = st0, st1, st2, st3
+
+		case 2:
+			b0, b1 := block[0:seg], block[seg:end]
+			st0, st1 := st[0], st[1]
+
+			for i := len(b0) - 1; i >= 0; i-- {
+				st1, n = encodeSymbol(buf, n, st1, &symb[b1[i]])
+				st0, n = encodeSymbol(buf, n, st0, &symb[b0[i]])
+			}
+
+			st[0], st[1] = st0, st1
 
-			for st >= sym.xMax {
-				this.buffer[n] = byte(st)
-				st >>= 8
-				this.buffer[n-1] = byte(st)
-				st >>= 8
-				n -= 2
+		default:
+			st0 := st[0]
+
+			for i := len(block) - 1; i >= 0; i-- {
+				st0, n = encodeSymbol(buf, n, st0, &symb[block[i]])
 			}
 
-			// Compute next ANS state
-			// C(s,x) = M floor(x/q_s) + mod(x,q_s) + b_s where b_s = q_0 + ... + q_{s-1}
-			// st = ((st / freq) << lr) + (st % freq) + cumFreq[prv];
-			st = st + sym.bias + int((uint64(st)*sym.invFreq)>>sym.invShift)*sym.cmplFreq
+			st[0] = st0
 		}
 	} else { // order 1
 		symb := this.symbols
-		prv := int(block[len(block)-1])
-
-		for i := len(block) - 2; i >= 0; i-- {
-			cur := int(block[i])
-			sym := symb[(cur<<8)|prv]
-
-			for st >= sym.xMax {
-				this.buffer[n] = byte(st)
-				st >>= 8
-				this.buffer[n-1] = byte(st)
-				st >>= 8
-				n -= 2
-			}
 
-			// Compute next ANS state
-			// C(s,x) = M floor(x/q_s) + mod(x,q_s) + b_s where b_s = q_0 + ... + q_{s-1}
-			// st = ((st / freq) << lr) + (st % freq) + cumFreq[prv];
-			st = st + sym.bias + int((uint64(st)*sym.invFreq)>>sym.invShift)*sym.cmplFreq
-			prv = cur
+		for i := len(block) - 1; i >= end; i-- {
+			st[0], n = encodeSymbol(buf, n, st[0], &symb[(int(block[i-1])<<8)|int(block[i])])
 		}
 
-		// Last symbol
-		sym := symb[prv]
+		// The first symbol of each segment is coded in context 0
+		switch states {
+		case 4:
+			b0, b1, b2, b3 := block[0:seg], block[seg:2*seg], block[2*seg:3*seg], block[3*seg:end]
+			st0, st1, st2, st3 := st[0], st[1], st[2], st[3]
+
+			for i := len(b0) - 1; i > 0; i-- {
+				st3, n = encodeSymbol(buf, n, st3, &symb[(int(b3[i-1])<<8)|int(b3[i])])
+				st2, n = encodeSymbol(buf, n, st2, &symb[(int(b2[i-1])<<8)|int(b2[i])])
+				st1, n = encodeSymbol(buf, n, st1, &symb[(int(b1[i-1])<<8)|int(b1[i])])
+				st0, n = encodeSymbol(buf, n, st0, &symb[(int(b0[i-1])<<8)|int(b0[i])])
+			}
+
+			st[3], n = encodeSymbol(buf, n, st3, &symb[b3[0]])
+			st[2], n = encodeSymbol(buf, n, st2, &symb[b2[0]])
+			st[1], n = encodeSymbol(buf, n, st1, &symb[b1[0]])
+			st[0], n = encodeSymbol(buf, n, st0, &symb[b0[0]])
 
-		for st >= sym.xMax {
-			this.buffer[n] = byte(st)
-			st >>= 8
-			this.buffer[n-1] = byte(st)
-			st >>= 8
-			n -= 2
-		}
+		case 2:
+			b0, b1 := block[0:seg], block[seg:end]
+			st0, st1 := st[0], st[1]
 
-		// Compute next ANS state
-		// C(s,x) = M floor(x/q_s) + mod(x,q_s) + b_s where b_s = q_0 + ... + q_{s-1}
-		// st = ((st / freq) << lr) + (st % freq) + cumFreq[prv];
-		st = st + sym.bias + int((uint64(st)*sym.invFreq)>>sym.invShift)*sym.cmplFreq
+			for i := len(b0) - 1; i > 0; i-- {
+				st1, n = encodeSymbol(buf, n, st1, &symb[(int(b1[i-1])<<8)|int(b1[i])])
+				st0, n = encodeSymbol(buf, n, st0, &symb[(int(b0[i-1])<<8)|int(b0[i])])
+			}
+
+			st[1], n = encodeSymbol(buf, n, st1, &symb[b1[0]])
+			st[0], n = encodeSymbol(buf, n, st0, &symb[b0[0]])
+
+		default:
+			st0 := st[0]
+
+			for i := len(block) - 1; i > 0; i-- {
+				st0, n = encodeSymbol(buf, n, st0, &symb[(int(block[i-1])<<8)|int(block[i])])
+			}
+
+			st[0], n = encodeSymbol(buf, n, st0, &symb[block[0]])
+		}
 	}
 
 	n++
@@ -326,8 +385,10 @@ func (this *ANSRangeEncoder) encodeChunk(block []byte) {
 	// Write chunk size
 	WriteVarInt(this.bitstream, uint32(len(this.buffer)-n))
 
-	// Write final ANS state
-	this.bitstream.WriteBits(uint64(st), 32)
+	// Write final ANS states
+	for k := 0; k < states; k++ {
+		this.bitstream.WriteBits(uint64(st[k]), 32)
+	}
 
 	if len(this.buffer) != n {
 		// Write encoded data to bitstream
@@ -335,12 +396,55 @@ func (this *ANSRangeEncoder) encodeChunk(block []byte) {
 	}
 }
 
+// Encode one symbol: flush the state to the buffer (written backwards from
+// index n) if needed and return the next ANS state and buffer index
+func encodeSymbol(buffer []byte, n int, st int, sym *encSymbol) (int, int) {
+	for st >= sym.xMax {
+		buffer[n] = byte(st)
+		st >>= 8
+		buffer[n-1] = byte(st)
+		st >>= 8
+		n -= 2
+	}
+
+	// Compute next ANS state
+	// C(s,x) = M floor(x/q_s) + mod(x,q_s) + b_s where b_s = q_0 + ... + q_{s-1}
+	// st = ((st / freq) << lr) + (st % freq) + cumFreq[prv];
+	return st + sym.bias + int((uint64(st)*sym.invFreq)>>sym.invShift)*sym.cmplFreq, n
+}
+
 // Compute chunk frequencies, cumulated frequencies and encode chunk header
 func (this *ANSRangeEncoder) rebuildStatistics(block []byte, lr uint) (int, error) {
 	kanzi.ComputeHistogram(block, this.freqs, this.order == 0, true)
+
+	if this.order == 1 {
+		// The first symbol of each interleaved segment is coded in context 0
+		states := interleavedStates(this.interleave, len(block))
+		seg := len(block) / states
+
+		for k := 1; k < states; k++ {
+			this.freqs[block[k*seg]]++
+			this.freqs[256]++
+		}
+	}
+
 	return this.updateFrequencies(this.freqs, lr)
 }
 
+// reset prepares the encoder to encode a new block into the bitstream.
+// The frequency tables are kept (they are computed for each chunk).
+func (this *ANSRangeEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	interleave := getANSInterleave(*ctx)
+
+	if checkInterleave(interleave) == false {
+		return fmt.Errorf("ANS codec: Invalid interleave factor: %v (must be 1, 2 or 4)", interleave)
+	}
+
+	this.bitstream = bs
+	this.interleave = interleave
+	return nil
+}
+
 // Dispose this implementation does nothing
 func (this *ANSRangeEncoder) Dispose() {
 }
@@ -387,35 +491,37 @@ func (this *encSymbol) reset(cumFreq, freq int, logRange uint) {
 
 // ANSRangeDecoder Asymmetric Numeral System Decoder
 type ANSRangeDecoder struct {
-	bitstream kanzi.InputBitStream
-	freqs     []int
-	symbols   []decSymbol
-	f2s       []byte // mapping frequency -> symbol
-	alphabet  []int
-	buffer    []byte
-	chunkSize int
-	logRange  uint
-	order     uint
+	bitstream  kanzi.InputBitStream
+	freqs      []int
+	symbols    []decSymbol
+	f2s        []byte // mapping frequency -> symbol
+	alphabet   []int
+	buffer     []byte
+	chunkSize  int
+	logRange   uint
+	order      uint
+	interleave uint
 }
 
 // NewANSRangeDecoder creates an instance of ANS decoder.
 // The chunk size indicates how many bytes are encoded (per block) before
 // resetting the frequency stats.
 // Since the number of args is variable, this function can be called like this:
-// NewANSRangeDecoder(bs) or NewANSRangeDecoder(bs, 0, 16384, 12)
-// Arguments are order and chunk size
+// NewANSRangeDecoder(bs) or NewANSRangeDecoder(bs, 0, 16384, 4)
+// Arguments are order, chunk size and interleave factor (see encoder)
 // chunkSize = 0 means 'use input buffer length' during decoding
 func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder, error) {
 	if bs == nil {
 		return nil, errors.New("ANS codec: Invalid null bitstream parameter")
 	}
 
-	if len(args) > 2 {
-		return nil, errors.New("ANS codec: At most order and chunk size can be provided")
+	if len(args) > 3 {
+		return nil, errors.New("ANS codec: At most order, chunk size and interleave factor can be provided")
 	}
 
 	chkSize := _DEFAULT_ANS0_CHUNK_SIZE
 	order := uint(0)
+	interleave := uint(1)
 
 	if len(args) > 0 {
 		order = args[0]
@@ -427,6 +533,10 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 		chkSize <<= 8
 	}
 
+	if len(args) > 2 {
+		interleave = args[2]
+	}
+
 	if order != 0 && order != 1 {
 		return nil, errors.New("ANS codec: The order must be 0 or 1")
 	}
@@ -439,10 +549,15 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 		return nil, fmt.Errorf("ANS codec: The chunk size must be at most %d", _ANS_MAX_CHUNK_SIZE)
 	}
 
+	if checkInterleave(interleave) == false {
+		return nil, fmt.Errorf("ANS codec: Invalid interleave factor: %v (must be 1, 2 or 4)", interleave)
+	}
+
 	this := new(ANSRangeDecoder)
 	this.bitstream = bs
 	this.chunkSize = int(chkSize)
 	this.order = order
+	this.interleave = interleave
 	dim := int(255*order + 1)
 	this.alphabet = make([]int, dim*256)
 	this.freqs = make([]int, dim*256)
@@ -452,6 +567,20 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 	return this, nil
 }
 
+// reset prepares the decoder to decode a new block from the bitstream.
+// The frequency tables are kept (they are decoded for each chunk).
+func (this *ANSRangeDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	interleave := getANSInterleave(*ctx)
+
+	if checkInterleave(interleave) == false {
+		return fmt.Errorf("ANS codec: Invalid interleave factor: %v (must be 1, 2 or 4)", interleave)
+	}
+
+	this.bitstream = bs
+	this.interleave = interleave
+	return nil
+}
+
 // Decodes alphabet and frequencies from the bitstream
 func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, error) {
 	this.logRange = uint(8 + this.bitstream.ReadBits(3))
@@ -618,11 +747,18 @@ func (this *ANSRangeDecoder) Read(block []byte) (int, error) {
 }
 
 func (this *ANSRangeDecoder) decodeChunk(block []byte) {
+	var st [_ANS_MAX_INTERLEAVE]int
+	states := interleavedStates(this.interleave, len(block))
+	seg := len(block) / states
+	end := seg * states
+
 	// Read chunk size
 	sz := ReadVarInt(this.bitstream) & (_ANS_MAX_CHUNK_SIZE - 1)
 
-	// Read initial ANS state
-	st := int(this.bitstream.ReadBits(32))
+	// Read initial ANS states
+	for k := 0; k < states; k++ {
+		st[k] = int(this.bitstream.ReadBits(32))
+	}
 
 	// Read encoded data
 	if sz != 0 {
@@ -637,47 +773,141 @@ func (this *ANSRangeDecoder) decodeChunk(block []byte) {
 		freq2sym := this.f2s[0 : mask+1]
 		symb := this.symbols[0:256]
 
-		for i := range block {
-			cur := freq2sym[st&mask]
-			block[i] = cur
-			sym := symb[cur]
+		buf := this.buffer
+
+		switch states {
+		case 4:
+			b0, b1, b2, b3 := block[0:seg], block[seg:2*seg], block[2*seg:3*seg], block[3*seg:end]
+			st0, st1, st2, st3 := st[0], st[1], st[2], st[3]
+
+			for i := range b0 {
+				c0 := freq2sym[st0&mask]
+				c1 := freq2sym[st1&mask]
+				c2 := freq2sym[st2&mask]
+				c3 := freq2sym[st3&mask]
+				b0[i], b1[i], b2[i], b3[i] = c0, c1, c2, c3
+				st0, n = decodeSymbol(buf, n, st0, &symb[c0], lr, mask)
+				st1, n = decodeSymbol(buf, n, st1, &symb[c1], lr, mask)
+				st2, n = decodeSymbol(buf, n, st2, &symb[c2], lr, mask)
+				st3, n = decodeSymbol(buf, n, st3, &symb[c3], lr, mask)
+			}
+
+			st[0] = st0
 
-			// Compute next ANS state
-			// D(x) = (s, q_s (x/M) + mod(x,M) - b_s) where s is such b_s <= x mod M < b_{s+1}
-			st = sym.freq*(st>>lr) + (st & mask) - sym.cumFreq
+		case 2:
+			b0, b1 := block[0:seg], block[seg:end]
+			st0, st1 := st[0], st[1]
 
-			// Normalize
-			for st < _ANS_TOP {
-				st = (st << 8) | int(this.buffer[n])
-				st = (st << 8) | int(this.buffer[n+1])
-				n += 2
+			for i := range b0 {
+				c0 := freq2sym[st0&mask]
+				c1 := freq2sym[st1&mask]
+				b0[i], b1[i] = c0, c1
+				st0, n = decodeSymbol(buf, n, st0, &symb[c0], lr, mask)
+				st1, n = decodeSymbol(buf, n, st1, &symb[c1], lr, mask)
 			}
+
+			st[0] = st0
+
+		default:
+			st0 := st[0]
+
+			for i := range block {
+				cur := freq2sym[st0&mask]
+				block[i] = cur
+				st0, n = decodeSymbol(buf, n, st0, &symb[cur], lr, mask)
+			}
+
+			st[0] = st0
+		}
+
+		for i := end; i < len(block); i++ {
+			cur := freq2sym[st[0]&mask]
+			block[i] = cur
+			st[0], n = decodeSymbol(buf, n, st[0], &symb[cur], lr, mask)
 		}
 	} else {
+		freq2sym := this.f2s
 		symb := this.symbols
-		prv := int(0)
+		buf := this.buffer
+
+		// The first symbol of each segment is decoded in context 0
+		switch states {
+		case 4:
+			b0, b1, b2, b3 := block[0:seg], block[seg:2*seg], block[2*seg:3*seg], block[3*seg:end]
+			st0, st1, st2, st3 := st[0], st[1], st[2], st[3]
+			p0, p1, p2, p3 := 0, 0, 0, 0
+
+			for i := range b0 {
+				c0 := int(freq2sym[(p0<<lr)|(st0&mask)])
+				c1 := int(freq2sym[(p1<<lr)|(st1&mask)])
+				c2 := int(freq2sym[(p2<<lr)|(st2&mask)])
+				c3 := int(freq2sym[(p3<<lr)|(st3&mask)])
+				b0[i], b1[i], b2[i], b3[i] = byte(c0), byte(c1), byte(c2), byte(c3)
+				st0, n = decodeSymbol(buf, n, st0, &symb[(p0<<8)|c0], lr, mask)
+				st1, n = decodeSymbol(buf, n, st1, &symb[(p1<<8)|c1], lr, mask)
+				st2, n = decodeSymbol(buf, n, st2, &symb[(p2<<8)|c2], lr, mask)
+				st3, n = decodeSymbol(buf, n, st3, &symb[(p3<<8)|c3], lr, mask)
+				p0, p1, p2, p3 = c0, c1, c2, c3
+			}
 
-		for i := range block {
-			cur := this.f2s[(prv<<lr)|(st&mask)]
-			block[i] = cur
-			sym := symb[(prv<<8)|int(cur)]
+			st[0] = st0
+
+		case 2:
+			b0, b1 := block[0:seg], block[seg:end]
+			st0, st1 := st[0], st[1]
+			p0, p1 := 0, 0
+
+			for i := range b0 {
+				c0 := int(freq2sym[(p0<<lr)|(st0&mask)])
+				c1 := int(freq2sym[(p1<<lr)|(st1&mask)])
+				b0[i], b1[i] = byte(c0), byte(c1)
+				st0, n = decodeSymbol(buf, n, st0, &symb[(p0<<8)|c0], lr, mask)
+				st1, n = decodeSymbol(buf, n, st1, &symb[(p1<<8)|c1], lr, mask)
+				p0, p1 = c0, c1
+			}
+
+			st[0] = st0
 
-			// Compute next ANS state
-			// D(x) = (s, q_s (x/M) + mod(x,M) - b_s) where s is such b_s <= x mod M < b_{s+1}
-			st = sym.freq*(st>>lr) + (st & mask) - sym.cumFreq
+		default:
+			st0 := st[0]
+			prv := 0
 
-			// Normalize
-			for st < _ANS_TOP {
-				st = (st << 8) | int(this.buffer[n])
-				st = (st << 8) | int(this.buffer[n+1])
-				n += 2
+			for i := range block {
+				cur := int(freq2sym[(prv<<lr)|(st0&mask)])
+				block[i] = byte(cur)
+				st0, n = decodeSymbol(buf, n, st0, &symb[(prv<<8)|cur], lr, mask)
+				prv = cur
 			}
 
-			prv = int(cur)
+			st[0] = st0
+		}
+
+		for i := end; i < len(block); i++ {
+			prv := int(block[i-1])
+			cur := freq2sym[(prv<<lr)|(st[0]&mask)]
+			block[i] = cur
+			st[0], n = decodeSymbol(buf, n, st[0], &symb[(prv<<8)|int(cur)], lr, mask)
 		}
 	}
 }
 
+// Compute the next ANS state after decoding a symbol and refill it from
+// the buffer (read from index n). Return the new state and buffer index.
+func decodeSymbol(buffer []byte, n int, st int, sym *decSymbol, lr uint, mask int) (int, int) {
+	// Compute next ANS state
+	// D(x) = (s, q_s (x/M) + mod(x,M) - b_s) where s is such b_s <= x mod M < b_{s+1}
+	st = sym.freq*(st>>lr) + (st & mask) - sym.cumFreq
+
+	// Normalize
+	for st < _ANS_TOP {
+		st = (st << 8) | int(buffer[n])
+		st = (st << 8) | int(buffer[n+1])
+		n += 2
+	}
+
+	return st, n
+}
+
 // BitStream returns the underlying bitstream
 func (this *ANSRangeDecoder) BitStream() kanzi.InputBitStream {
 	return this.bitstream
@@ -701,3 +931,20 @@ func (this *decSymbol) reset(cumFreq, freq int, logRange uint) {
 	this.cumFreq = cumFreq
 	this.freq = freq
 }
+
+func checkInterleave(interleave uint) bool {
+	return interleave == 1 || interleave == 2 || interleave == 4
+}
+
+// interleavedStates returns the number of ANS states used to code a chunk
+// of 'length' symbols. Fewer states are used for small chunks since each
+// state adds 4 bytes to the chunk.
+func interleavedStates(interleave uint, length int) int {
+	n := int(interleave)
+
+	for n > 1 && length < n*_ANS_MIN_SEGMENT_SIZE {
+		n >>= 1
+	}
+
+	return n
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Implementation of an adaptive Huffman codec (FGK algorithm).
+// See "Dynamic Huffman Coding" by Donald Knuth, Journal of Algorithms 6, 1985.
+// The code tree is updated after each symbol by the encoder and the decoder:
+// only the alphabet of the block is transmitted (no frequencies nor code
+// lengths), which suits very small blocks. The tree starts with all the
+// symbols of the alphabet with a weight of 1.
+
+const (
+	_AHUF_MAX_NODES = 2*256 + 1 // 256 leaves, 255 internal nodes, NYT leaf and its parent
+	_AHUF_NYT       = -1        // symbol of the NYT (not yet transmitted) leaf
+)
+
+// adaptiveHuffmanTree is a code tree with the sibling property: the nodes are
+// stored by non-increasing weight (the root first) and siblings are adjacent.
+type adaptiveHuffmanTree struct {
+	weights  [_AHUF_MAX_NODES]int
+	parents  [_AHUF_MAX_NODES]int
+	children [_AHUF_MAX_NODES]int // index of the left child (right child is next), -1 for leaves
+	symbols  [_AHUF_MAX_NODES]int // symbol of the leaves
+	leaves   [256]int             // index of the leaf of each symbol, -1 if not in the alphabet
+	nyt      int                  // index of the NYT leaf (only while the tree is built)
+	path     [_AHUF_MAX_NODES]byte
+}
+
+// reset builds the initial tree: one leaf of weight 1 per symbol of the
+// alphabet. The symbols are added by splitting the NYT leaf, which is then
+// removed.
+func (this *adaptiveHuffmanTree) reset(alphabet []int) {
+	for i := range this.leaves {
+		this.leaves[i] = -1
+	}
+
+	// Start with the NYT leaf as root
+	this.nyt = 0
+	this.weights[0] = 0
+	this.parents[0] = -1
+	this.children[0] = -1
+	this.symbols[0] = _AHUF_NYT
+
+	for _, s := range alphabet {
+		this.update(byte(s))
+	}
+
+	// Remove the NYT leaf (of weight 0): its sibling replaces the parent.
+	// With a single symbol, the root is a leaf and the codes are empty.
+	if p := this.parents[this.nyt]; p >= 0 {
+		s := this.children[p]
+		this.children[p] = -1
+		this.symbols[p] = this.symbols[s]
+		this.leaves[this.symbols[p]] = p
+	}
+
+	this.nyt = -1
+}
+
+// update adds one occurrence of the symbol to the tree
+func (this *adaptiveHuffmanTree) update(symbol byte) {
+	x := this.leaves[symbol]
+
+	if x < 0 {
+		// New symbol: the NYT leaf spawns the symbol leaf and a new NYT leaf
+		// (leaves of weight 0, incremented below)
+		q := this.nyt
+		x = q + 1
+		this.children[q] = x
+		this.weights[x] = 0
+		this.parents[x] = q
+		this.children[x] = -1
+		this.symbols[x] = int(symbol)
+		this.leaves[symbol] = x
+		this.nyt = q + 2
+		this.weights[q+2] = 0
+		this.parents[q+2] = q
+		this.children[q+2] = -1
+		this.symbols[q+2] = _AHUF_NYT
+	}
+
+	for x >= 0 {
+		// Move the node to the front of its block (nodes with the same weight)
+		// unless the leader is its parent, then increment its weight
+		w := this.weights[x]
+		lo, hi := 0, x
+
+		for lo < hi {
+			mid := (lo + hi) >> 1
+
+			if this.weights[mid] > w {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+
+		if lo != x && lo != this.parents[x] {
+			this.swap(x, lo)
+			x = lo
+		}
+
+		this.weights[x]++
+		x = this.parents[x]
+	}
+}
+
+// swap exchanges the subtrees at positions a and b (of same weight). The
+// parents of both positions are unchanged.
+func (this *adaptiveHuffmanTree) swap(a, b int) {
+	this.children[a], this.children[b] = this.children[b], this.children[a]
+	this.symbols[a], this.symbols[b] = this.symbols[b], this.symbols[a]
+	this.relink(a)
+	this.relink(b)
+}
+
+func (this *adaptiveHuffmanTree) relink(x int) {
+	if c := this.children[x]; c >= 0 {
+		this.parents[c] = x
+		this.parents[c+1] = x
+	} else if this.symbols[x] == _AHUF_NYT {
+		this.nyt = x
+	} else {
+		this.leaves[this.symbols[x]] = x
+	}
+}
+
+// AdaptiveHuffmanEncoder entropy encoder using an adaptive Huffman code
+type AdaptiveHuffmanEncoder struct {
+	bitstream kanzi.OutputBitStream
+	tree      adaptiveHuffmanTree
+}
+
+// NewAdaptiveHuffmanEncoder creates an instance of AdaptiveHuffmanEncoder
+func NewAdaptiveHuffmanEncoder(bs kanzi.OutputBitStream) (*AdaptiveHuffmanEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Adaptive Huffman codec: Invalid null bitstream parameter")
+	}
+
+	this := new(AdaptiveHuffmanEncoder)
+	this.bitstream = bs
+	return this, nil
+}
+
+// Write encodes the data provided into the bitstream. Return the number of byte
+// written to the bitstream. The alphabet of the block is written first and
+// the code tree is rebuilt for each block.
+func (this *AdaptiveHuffmanEncoder) Write(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	var freqs [256]int
+	var alphabet [256]int
+	kanzi.ComputeHistogram(block, freqs[:], true, false)
+	n := 0
+
+	for i := range freqs {
+		if freqs[i] != 0 {
+			alphabet[n] = i
+			n++
+		}
+	}
+
+	if _, err := EncodeAlphabet(this.bitstream, alphabet[0:n:256]); err != nil {
+		return 0, err
+	}
+
+	this.tree.reset(alphabet[0:n])
+
+	for _, b := range block {
+		this.encodeSymbol(b)
+	}
+
+	return len(block), nil
+}
+
+func (this *AdaptiveHuffmanEncoder) encodeSymbol(symbol byte) {
+	t := &this.tree
+	x := t.leaves[symbol]
+
+	// Collect the path from the leaf to the root
+	n := 0
+
+	for p := t.parents[x]; p >= 0; x, p = p, t.parents[p] {
+		t.path[n] = byte(x - t.children[p])
+		n++
+	}
+
+	// Emit the code from the root, at most 56 bits at a time
+	for n > 0 {
+		k := n
+
+		if k > 56 {
+			k = 56
+		}
+
+		code := uint64(0)
+
+		for i := 0; i < k; i++ {
+			n--
+			code = (code << 1) | uint64(t.path[n])
+		}
+
+		this.bitstream.WriteBits(code, uint(k))
+	}
+
+	t.update(symbol)
+}
+
+// BitStream returns the underlying bitstream
+func (this *AdaptiveHuffmanEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The code tree is rebuilt for each block anyway.
+func (this *AdaptiveHuffmanEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// Dispose this implementation does nothing
+func (this *AdaptiveHuffmanEncoder) Dispose() {
+}
+
+// AdaptiveHuffmanDecoder entropy decoder using an adaptive Huffman code
+type AdaptiveHuffmanDecoder struct {
+	bitstream kanzi.InputBitStream
+	tree      adaptiveHuffmanTree
+}
+
+// NewAdaptiveHuffmanDecoder creates an instance of AdaptiveHuffmanDecoder
+func NewAdaptiveHuffmanDecoder(bs kanzi.InputBitStream) (*AdaptiveHuffmanDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Adaptive Huffman codec: Invalid null bitstream parameter")
+	}
+
+	this := new(AdaptiveHuffmanDecoder)
+	this.bitstream = bs
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The code tree is rebuilt for each block anyway.
+func (this *AdaptiveHuffmanDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// Read decodes data from the bitstream and return it in the provided buffer.
+// Return the number of bytes read from the bitstream.
+func (this *AdaptiveHuffmanDecoder) Read(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	var alphabet [256]int
+	n, err := DecodeAlphabet(this.bitstream, alphabet[:])
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 0 {
+		return 0, errors.New("Invalid bitstream: empty alphabet in adaptive Huffman decoder")
+	}
+
+	t := &this.tree
+	t.reset(alphabet[0:n])
+
+	for i := range block {
+		// Walk down the tree from the root
+		x := 0
+
+		for t.children[x] >= 0 {
+			x = t.children[x] + this.bitstream.ReadBit()
+		}
+
+		block[i] = byte(t.symbols[x])
+		t.update(block[i])
+	}
+
+	return len(block), nil
+}
+
+// BitStream returns the underlying bitstream
+func (this *AdaptiveHuffmanDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *AdaptiveHuffmanDecoder) Dispose() {
+}
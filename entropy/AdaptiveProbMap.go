@@ -44,17 +44,22 @@ type FastLogisticAdaptiveProbMap AdaptiveProbMap
 func newLogisticAdaptiveProbMap(n, rate uint) (*LogisticAdaptiveProbMap, error) {
 	this := &LogisticAdaptiveProbMap{}
 	this.data = make([]uint16, n*33)
+	this.reset(rate)
+	return this, nil
+}
+
+// reset restores the initial probabilities, keeping the allocated memory
+func (this *LogisticAdaptiveProbMap) reset(rate uint) {
+	this.index = 0
 	this.rate = rate
 
 	for j := 0; j <= 32; j++ {
 		this.data[j] = uint16(kanzi.Squash((j-16)<<7) << 4)
 	}
 
-	for i := uint(1); i < n; i++ {
-		copy(this.data[i*33:], this.data[0:33])
+	for i := 33; i < len(this.data); i += 33 {
+		copy(this.data[i:], this.data[0:33])
 	}
-
-	return this, nil
 }
 
 // get returns improved prediction given current bit, prediction and context
@@ -63,7 +68,7 @@ func (this *LogisticAdaptiveProbMap) get(bit int, pr int, ctx int) int {
 	g := (-bit & 65528) + (bit << this.rate)
 	this.data[this.index+1] += uint16((g - int(this.data[this.index+1])) >> this.rate)
 	this.data[this.index] += uint16((g - int(this.data[this.index])) >> this.rate)
-	pr = kanzi.STRETCH[pr]
+	pr = kanzi.Stretch(pr)
 
 	// Find index: 33*ctx + quantized prediction in [0..32]
 	this.index = ((pr + 2048) >> 7) + 33*ctx
@@ -94,7 +99,7 @@ func (this *FastLogisticAdaptiveProbMap) get(bit int, pr int, ctx int) int {
 	// Update probability based on error and learning rate
 	g := (-bit & 65528) + (bit << this.rate)
 	this.data[this.index] += uint16((g - int(this.data[this.index])) >> this.rate)
-	this.index = ((kanzi.STRETCH[pr] + 2048) >> 7) + 32*ctx
+	this.index = ((kanzi.Stretch(pr) + 2048) >> 7) + 32*ctx
 	return int(this.data[this.index]) >> 4
 }
 
@@ -0,0 +1,357 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Binary arithmetic coder using 32-bit arithmetic only (no 64-bit
+// multiplication nor shift), fast on 32-bit targets. The interval [low, high]
+// is split according to the 12-bit probability of the predictor and the
+// leading bytes shared by both bounds are shifted out one at a time, so no
+// carry can propagate (at the cost of a small loss when the interval
+// straddles a byte boundary). See the coder of paq8 by Matt Mahoney.
+// The bytes of each chunk are buffered, the chunk is written as its size in
+// bytes (varint) followed by the bytes (the last 4 bytes flush the coder).
+
+// BinaryArithmeticEncoder entropy encoder based on a carry-less binary
+// arithmetic coder and using an external probability predictor.
+type BinaryArithmeticEncoder struct {
+	predictor kanzi.Predictor
+	low       uint32
+	high      uint32
+	bitstream kanzi.OutputBitStream
+	buffer    []byte
+	index     int
+}
+
+// NewBinaryArithmeticEncoder creates an instance of BinaryArithmeticEncoder
+// using the given predictor to predict the probability of the next bit to be
+// one. It outputs to the given OutputBitstream
+func NewBinaryArithmeticEncoder(bs kanzi.OutputBitStream, predictor kanzi.Predictor) (*BinaryArithmeticEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Binary arithmetic codec: Invalid null bitstream parameter")
+	}
+
+	if predictor == nil {
+		return nil, errors.New("Binary arithmetic codec: Invalid null predictor parameter")
+	}
+
+	this := new(BinaryArithmeticEncoder)
+	this.predictor = predictor
+	this.bitstream = bs
+	this.buffer = make([]byte, 0)
+	return this, nil
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The predictor model is re-initialized in place, the output buffer is kept.
+func (this *BinaryArithmeticEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	p, ok := this.predictor.(resettablePredictor)
+
+	if ok == false {
+		return errors.New("Binary arithmetic codec: The predictor cannot be reset")
+	}
+
+	if err := p.reset(ctx); err != nil {
+		return err
+	}
+
+	this.bitstream = bs
+	this.index = 0
+	return nil
+}
+
+// EncodeByte encodes the given value into the bitstream bit by bit
+func (this *BinaryArithmeticEncoder) EncodeByte(val byte) {
+	this.EncodeBit((val>>7)&1, this.predictor.Get())
+	this.EncodeBit((val>>6)&1, this.predictor.Get())
+	this.EncodeBit((val>>5)&1, this.predictor.Get())
+	this.EncodeBit((val>>4)&1, this.predictor.Get())
+	this.EncodeBit((val>>3)&1, this.predictor.Get())
+	this.EncodeBit((val>>2)&1, this.predictor.Get())
+	this.EncodeBit((val>>1)&1, this.predictor.Get())
+	this.EncodeBit(val&1, this.predictor.Get())
+}
+
+// EncodeBit encodes one bit using the probability (in [0..4095]) of the
+// bit being 1.
+func (this *BinaryArithmeticEncoder) EncodeBit(bit byte, pred int) {
+	// Interval split (the range is at most 32 bits: split it in 2 parts)
+	rng := this.high - this.low
+	split := this.low + (rng>>12)*uint32(pred) + (((rng & 0xFFF) * uint32(pred)) >> 12)
+
+	if bit == 0 {
+		this.low = split + 1
+	} else {
+		this.high = split
+	}
+
+	this.predictor.Update(bit)
+
+	// Shift out the leading bytes shared by both bounds
+	for (this.low^this.high)&0xFF000000 == 0 {
+		this.buffer[this.index] = byte(this.high >> 24)
+		this.index++
+		this.low <<= 8
+		this.high = (this.high << 8) | 0xFF
+	}
+}
+
+// Write encodes the data provided into the bitstream. Return the number of byte
+// written to the bitstream. Splits big blocks into chunks and encode the chunks
+// byte by byte sequentially into the bitstream.
+func (this *BinaryArithmeticEncoder) Write(block []byte) (int, error) {
+	count := len(block)
+
+	if count > 1<<30 {
+		return -1, errors.New("Binary arithmetic codec: Invalid block size parameter (max is 1<<30)")
+	}
+
+	startChunk := 0
+	end := count
+	length := count
+
+	if count >= 1<<26 {
+		// If the block is big (>=64MB), split the encoding to avoid allocating
+		// too much memory.
+		if count < 1<<29 {
+			length = count >> 3
+		} else {
+			length = count >> 4
+		}
+	}
+
+	for startChunk < end {
+		chunkSize := length
+
+		if startChunk+length >= end {
+			chunkSize = end - startChunk
+		}
+
+		// Flush bytes and expansion of incompressible data
+		if len(this.buffer) < chunkSize+(chunkSize>>3)+8 {
+			this.buffer = make([]byte, chunkSize+(chunkSize>>3)+8)
+		}
+
+		this.low = 0
+		this.high = 0xFFFFFFFF
+		this.index = 0
+
+		for _, b := range block[startChunk : startChunk+chunkSize] {
+			this.EncodeByte(b)
+		}
+
+		// Flush: any value starting with the 4 bytes of 'low' is in the interval
+		for shift := 24; shift >= 0; shift -= 8 {
+			this.buffer[this.index] = byte(this.low >> uint(shift))
+			this.index++
+		}
+
+		WriteVarInt(this.bitstream, uint32(this.index))
+		this.bitstream.WriteArray(this.buffer, uint(8*this.index))
+		startChunk += chunkSize
+	}
+
+	return count, nil
+}
+
+// BitStream returns the underlying bitstream
+func (this *BinaryArithmeticEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing (each chunk is flushed)
+func (this *BinaryArithmeticEncoder) Dispose() {
+}
+
+// BinaryArithmeticDecoder entropy decoder based on a carry-less binary
+// arithmetic coder and using an external probability predictor.
+type BinaryArithmeticDecoder struct {
+	predictor kanzi.Predictor
+	low       uint32
+	high      uint32
+	current   uint32
+	bitstream kanzi.InputBitStream
+	buffer    []byte
+	index     int
+}
+
+// NewBinaryArithmeticDecoder creates an instance of BinaryArithmeticDecoder
+// using the given predictor to predict the probability of the next bit to be
+// one. It reads from the given InputBitstream
+func NewBinaryArithmeticDecoder(bs kanzi.InputBitStream, predictor kanzi.Predictor) (*BinaryArithmeticDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Binary arithmetic codec: Invalid null bitstream parameter")
+	}
+
+	if predictor == nil {
+		return nil, errors.New("Binary arithmetic codec: Invalid null predictor parameter")
+	}
+
+	this := new(BinaryArithmeticDecoder)
+	this.predictor = predictor
+	this.bitstream = bs
+	this.buffer = make([]byte, 0)
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The predictor model is re-initialized in place, the input buffer is kept.
+func (this *BinaryArithmeticDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	p, ok := this.predictor.(resettablePredictor)
+
+	if ok == false {
+		return errors.New("Binary arithmetic codec: The predictor cannot be reset")
+	}
+
+	if err := p.reset(ctx); err != nil {
+		return err
+	}
+
+	this.bitstream = bs
+	this.index = 0
+	return nil
+}
+
+// DecodeByte decodes the given value from the bitstream bit by bit
+func (this *BinaryArithmeticDecoder) DecodeByte() byte {
+	res := this.DecodeBit(this.predictor.Get()) << 7
+	res |= this.DecodeBit(this.predictor.Get()) << 6
+	res |= this.DecodeBit(this.predictor.Get()) << 5
+	res |= this.DecodeBit(this.predictor.Get()) << 4
+	res |= this.DecodeBit(this.predictor.Get()) << 3
+	res |= this.DecodeBit(this.predictor.Get()) << 2
+	res |= this.DecodeBit(this.predictor.Get()) << 1
+	return res | this.DecodeBit(this.predictor.Get())
+}
+
+// DecodeBit decodes one bit using the probability (in [0..4095]) of the
+// bit being 1.
+func (this *BinaryArithmeticDecoder) DecodeBit(pred int) byte {
+	rng := this.high - this.low
+	split := this.low + (rng>>12)*uint32(pred) + (((rng & 0xFFF) * uint32(pred)) >> 12)
+	var bit byte
+
+	if this.current <= split {
+		bit = 1
+		this.high = split
+	} else {
+		this.low = split + 1
+	}
+
+	this.predictor.Update(bit)
+
+	// Shift in the next bytes (0 past the end of a corrupted chunk)
+	for (this.low^this.high)&0xFF000000 == 0 {
+		this.low <<= 8
+		this.high = (this.high << 8) | 0xFF
+		this.current <<= 8
+
+		if this.index < len(this.buffer) {
+			this.current |= uint32(this.buffer[this.index])
+			this.index++
+		}
+	}
+
+	return bit
+}
+
+func (this *BinaryArithmeticDecoder) nextByte() byte {
+	if this.index >= len(this.buffer) {
+		return 0
+	}
+
+	b := this.buffer[this.index]
+	this.index++
+	return b
+}
+
+// Read decodes data from the bitstream and return it in the provided buffer.
+// Return the number of bytes read from the bitstream.
+// Splits big blocks into chunks and decode the chunks byte by byte sequentially from the bitstream.
+func (this *BinaryArithmeticDecoder) Read(block []byte) (int, error) {
+	count := len(block)
+
+	if count > 1<<30 {
+		return -1, errors.New("Binary arithmetic codec: Invalid block size parameter (max is 1<<30)")
+	}
+
+	startChunk := 0
+	end := count
+	length := count
+
+	if count >= 1<<26 {
+		// If the block is big (>=64MB), split the decoding to avoid allocating
+		// too much memory.
+		if count < 1<<29 {
+			length = count >> 3
+		} else {
+			length = count >> 4
+		}
+	}
+
+	for startChunk < end {
+		chunkSize := length
+
+		if startChunk+length >= end {
+			chunkSize = end - startChunk
+		}
+
+		szBytes := int(ReadVarInt(this.bitstream))
+
+		if szBytes < 4 || szBytes > chunkSize+(chunkSize>>3)+8 {
+			return startChunk, errors.New("Invalid bitstream: incorrect size of binary arithmetic coded chunk")
+		}
+
+		if cap(this.buffer) < szBytes {
+			this.buffer = make([]byte, szBytes)
+		}
+
+		this.buffer = this.buffer[0:szBytes]
+		this.bitstream.ReadArray(this.buffer, uint(8*szBytes))
+		this.low = 0
+		this.high = 0xFFFFFFFF
+		this.current = 0
+		this.index = 0
+
+		for i := 0; i < 4; i++ {
+			this.current = (this.current << 8) | uint32(this.nextByte())
+		}
+
+		buf := block[startChunk : startChunk+chunkSize]
+
+		for i := range buf {
+			buf[i] = this.DecodeByte()
+		}
+
+		startChunk += chunkSize
+	}
+
+	return count, nil
+}
+
+// BitStream returns the underlying bitstream
+func (this *BinaryArithmeticDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *BinaryArithmeticDecoder) Dispose() {
+}
@@ -18,6 +18,7 @@ package entropy
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -27,8 +28,49 @@ const (
 	_MASK_0_56          = uint64(0x00FFFFFFFFFFFFFF)
 	_MASK_0_24          = uint64(0x0000000000FFFFFF)
 	_MASK_0_32          = uint64(0x00000000FFFFFFFF)
+
+	// CM_MAX_DICTIONARY_SIZE is the max number of bytes of the priming
+	// dictionary used by the CM and TPAQ predictors ("cmDictionary")
+	CM_MAX_DICTIONARY_SIZE = 1 << 16
 )
 
+// primePredictor warms up the model of the predictor with the content of the
+// "cmDictionary" context entry (if any), as if the dictionary had been coded
+// right before the block (only the last CM_MAX_DICTIONARY_SIZE bytes are
+// used). The encoder and the decoder must use the same dictionary. Priming
+// costs as much time as coding the dictionary, for each block. Streams of
+// format version 9 or older ("bsVersion" context entry) have no dictionary.
+func primePredictor(p kanzi.Predictor, ctx *map[string]interface{}) error {
+	if ctx == nil {
+		return nil
+	}
+
+	val, containsKey := (*ctx)["cmDictionary"]
+
+	if containsKey == false {
+		return nil
+	}
+
+	if version, containsKey := (*ctx)["bsVersion"]; containsKey && version.(uint) < 10 {
+		return fmt.Errorf("A CM dictionary requires stream format version 10 (got %v)", version)
+	}
+
+	dict := val.([]byte)
+
+	if len(dict) > CM_MAX_DICTIONARY_SIZE {
+		dict = dict[len(dict)-CM_MAX_DICTIONARY_SIZE:]
+	}
+
+	for _, b := range dict {
+		for shift := 7; shift >= 0; shift-- {
+			p.Get()
+			p.Update((b >> uint(shift)) & 1)
+		}
+	}
+
+	return nil
+}
+
 // BinaryEntropyEncoder entropy encoder based on arithmetic coding and
 // using an external probability predictor.
 type BinaryEntropyEncoder struct {
@@ -39,6 +81,7 @@ type BinaryEntropyEncoder struct {
 	disposed  bool
 	buffer    []byte
 	index     int
+	shared    bool // the predictor outlives the encoder (see ModelCache)
 }
 
 // NewBinaryEntropyEncoder creates an instance of BinaryEntropyEncoder using the
@@ -63,6 +106,27 @@ func NewBinaryEntropyEncoder(bs kanzi.OutputBitStream, predictor kanzi.Predictor
 	return this, nil
 }
 
+// reset prepares the encoder to encode a new block into the bitstream.
+// The predictor model is re-initialized in place, the output buffer is kept.
+func (this *BinaryEntropyEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	p, ok := this.predictor.(resettablePredictor)
+
+	if ok == false {
+		return errors.New("Binary entropy codec: The predictor cannot be reset")
+	}
+
+	if err := p.reset(ctx); err != nil {
+		return err
+	}
+
+	this.low = 0
+	this.high = _BINARY_ENTROPY_TOP
+	this.bitstream = bs
+	this.disposed = false
+	this.index = 0
+	return nil
+}
+
 // EncodeByte encodes the given value into the bitstream bit by bit
 func (this *BinaryEntropyEncoder) EncodeByte(val byte) {
 	this.EncodeBit((val>>7)&1, this.predictor.Get())
@@ -177,6 +241,11 @@ func (this *BinaryEntropyEncoder) Dispose() {
 
 	this.disposed = true
 	this.bitstream.WriteBits(this.low|_MASK_0_24, 56)
+
+	// Encoders are not reused: free the model memory right away
+	if p, ok := this.predictor.(releasablePredictor); ok == true && this.shared == false {
+		p.Release()
+	}
 }
 
 // BinaryEntropyDecoder entropy decoder based on arithmetic coding and
@@ -215,6 +284,28 @@ func NewBinaryEntropyDecoder(bs kanzi.InputBitStream, predictor kanzi.Predictor)
 	return this, nil
 }
 
+// reset prepares the decoder to decode a new block from the bitstream.
+// The predictor model is re-initialized in place, the input buffer is kept.
+func (this *BinaryEntropyDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	p, ok := this.predictor.(resettablePredictor)
+
+	if ok == false {
+		return errors.New("Binary entropy codec: The predictor cannot be reset")
+	}
+
+	if err := p.reset(ctx); err != nil {
+		return err
+	}
+
+	this.low = 0
+	this.high = _BINARY_ENTROPY_TOP
+	this.current = 0
+	this.initialized = false
+	this.bitstream = bs
+	this.index = 0
+	return nil
+}
+
 // DecodeByte decodes the given value from the bitstream bit by bit
 func (this *BinaryEntropyDecoder) DecodeByte() byte {
 	return (this.DecodeBit(this.predictor.Get()) << 7) |
@@ -347,3 +438,10 @@ func (this *BinaryEntropyDecoder) BitStream() kanzi.InputBitStream {
 // This implementation does nothing.
 func (this *BinaryEntropyDecoder) Dispose() {
 }
+
+// releasePredictor frees the model memory of the predictor (if it supports it)
+func (this *BinaryEntropyDecoder) releasePredictor() {
+	if p, ok := this.predictor.(releasablePredictor); ok == true {
+		p.Release()
+	}
+}
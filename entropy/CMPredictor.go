@@ -29,6 +29,7 @@ type CMPredictor struct {
 	ctx      int32
 	idx      int
 	runMask  int32
+	arena    []int32 // single allocation backing all the counters
 	counter1 [256][]int32
 	counter2 [512][]int32
 	p        int
@@ -36,16 +37,42 @@ type CMPredictor struct {
 
 // NewCMPredictor creates a new instance of CMPredictor
 func NewCMPredictor() (*CMPredictor, error) {
+	return NewCMPredictorWithCtx(nil)
+}
+
+// NewCMPredictorWithCtx creates a new instance of CMPredictor using the
+// provided map of options (EG. a "cmDictionary" to prime the model)
+func NewCMPredictorWithCtx(ctx *map[string]interface{}) (*CMPredictor, error) {
 	this := new(CMPredictor)
+	err := this.reset(ctx)
+	return this, err
+}
+
+// reset re-initializes the model, allocating the counters on first use only
+func (this *CMPredictor) reset(ctx *map[string]interface{}) error {
+	this.c1 = 0
+	this.c2 = 0
 	this.ctx = 1
 	this.runMask = 0
 	this.idx = 8
 
-	for i := 0; i < 256; i++ {
-		this.counter1[i] = make([]int32, 257)
-		this.counter2[i+i] = make([]int32, 17)
-		this.counter2[i+i+1] = make([]int32, 17)
+	if this.arena == nil {
+		// Sub-slice one arena instead of allocating 768 counter slices
+		this.arena = make([]int32, 256*257+512*17)
+		offset := 0
+
+		for i := range &this.counter1 {
+			this.counter1[i] = this.arena[offset : offset+257 : offset+257]
+			offset += 257
+		}
 
+		for i := range &this.counter2 {
+			this.counter2[i] = this.arena[offset : offset+17 : offset+17]
+			offset += 17
+		}
+	}
+
+	for i := 0; i < 256; i++ {
 		for j := 0; j <= 256; j++ {
 			this.counter1[i][j] = 32768
 		}
@@ -61,7 +88,15 @@ func NewCMPredictor() (*CMPredictor, error) {
 
 	pc1 := this.counter1[this.ctx]
 	this.p = int(13*pc1[256]+14*pc1[this.c1]+5*pc1[this.c2]) >> 5
-	return this, nil
+	return primePredictor(this, ctx)
+}
+
+// Release drops the counters so the memory can be reclaimed. The predictor
+// must be reset before being used again.
+func (this *CMPredictor) Release() {
+	this.arena = nil
+	this.counter1 = [256][]int32{}
+	this.counter2 = [512][]int32{}
 }
 
 // Update updates the probability model based on the internal bit counters
@@ -0,0 +1,139 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// The entropy type is written with 5 bits in the bitstream header. The last
+// types are reserved for experimental codecs provided by other packages, the
+// built-in codecs never use them.
+const (
+	FIRST_EXPERIMENTAL_TYPE = uint32(24)
+	LAST_EXPERIMENTAL_TYPE  = uint32(31)
+)
+
+// CodecFactory creates the encoder and the decoder of an entropy codec
+// registered with RegisterCodec.
+type CodecFactory interface {
+	// Name returns the name of the codec (case insensitive), used on the
+	// command line and in the stream description
+	Name() string
+
+	// NewEncoder returns an encoder writing to the bitstream
+	NewEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{}) (kanzi.EntropyEncoder, error)
+
+	// NewDecoder returns a decoder reading from the bitstream
+	NewDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{}) (kanzi.EntropyDecoder, error)
+
+	// MaxEncodedLen returns the max number of bytes written by the encoder
+	// for a block of 'srcLen' bytes (used to size the stream buffers)
+	MaxEncodedLen(srcLen int) int
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[uint32]CodecFactory)
+)
+
+// RegisterCodec makes the codec available to the factory functions of this
+// package (hence to the compressed streams and the block compressor) under
+// the given type, in the experimental range. Registering a type or a name
+// already in use is an error. Usually called from an init function; the
+// encoder and the decoder of a stream must register the same codecs.
+func RegisterCodec(id uint32, factory CodecFactory) error {
+	if id < FIRST_EXPERIMENTAL_TYPE || id > LAST_EXPERIMENTAL_TYPE {
+		return fmt.Errorf("Invalid entropy codec type: %d (must be in [%d..%d])",
+			id, FIRST_EXPERIMENTAL_TYPE, LAST_EXPERIMENTAL_TYPE)
+	}
+
+	if factory == nil {
+		return errors.New("Invalid null codec factory parameter")
+	}
+
+	name := strings.ToUpper(factory.Name())
+
+	if len(name) == 0 || strings.ContainsAny(name, "&+ ") == true {
+		return fmt.Errorf("Invalid entropy codec name: '%s'", factory.Name())
+	}
+
+	if isBuiltinName(name) == true {
+		return fmt.Errorf("Entropy codec name '%s' already in use", name)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[id]; exists == true {
+		return fmt.Errorf("Entropy codec type %d already registered", id)
+	}
+
+	for _, f := range registry {
+		if strings.ToUpper(f.Name()) == name {
+			return fmt.Errorf("Entropy codec name '%s' already in use", name)
+		}
+	}
+
+	registry[id] = factory
+	return nil
+}
+
+// UnregisterCodec removes the codec registered for the type (if any)
+func UnregisterCodec(id uint32) {
+	registryMutex.Lock()
+	delete(registry, id)
+	registryMutex.Unlock()
+}
+
+// getRegisteredCodec returns the codec registered for the type or nil
+func getRegisteredCodec(id uint32) CodecFactory {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registry[id]
+}
+
+// getRegisteredType returns the type of the codec registered with the name
+// (upper case) and true, or false if there is no such codec
+func getRegisteredType(name string) (uint32, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	for id, f := range registry {
+		if strings.ToUpper(f.Name()) == name {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func isBuiltinName(name string) (res bool) {
+	// GetType panics on names unknown to the built-in codecs and the registry
+	defer func() {
+		if r := recover(); r != nil {
+			res = false
+		}
+	}()
+
+	id := GetType(name)
+	return id < FIRST_EXPERIMENTAL_TYPE
+}
@@ -23,16 +23,27 @@ import (
 )
 
 const (
-	NONE_TYPE    = uint32(0) // No compression
-	HUFFMAN_TYPE = uint32(1) // Huffman
-	FPAQ_TYPE    = uint32(2) // Fast PAQ (order 0)
-	PAQ_TYPE     = uint32(3) // Obsolete
-	RANGE_TYPE   = uint32(4) // Range
-	ANS0_TYPE    = uint32(5) // Asymmetric Numerical System order 0
-	CM_TYPE      = uint32(6) // Context Model
-	TPAQ_TYPE    = uint32(7) // Tangelo PAQ
-	ANS1_TYPE    = uint32(8) // Asymmetric Numerical System order 1
-	TPAQX_TYPE   = uint32(9) // Tangelo PAQ Extra
+	NONE_TYPE    = uint32(0)  // No compression
+	HUFFMAN_TYPE = uint32(1)  // Huffman
+	FPAQ_TYPE    = uint32(2)  // Fast PAQ (order 0)
+	PAQ_TYPE     = uint32(3)  // Obsolete
+	RANGE_TYPE   = uint32(4)  // Range
+	ANS0_TYPE    = uint32(5)  // Asymmetric Numerical System order 0
+	CM_TYPE      = uint32(6)  // Context Model
+	TPAQ_TYPE    = uint32(7)  // Tangelo PAQ
+	ANS1_TYPE    = uint32(8)  // Asymmetric Numerical System order 1
+	TPAQX_TYPE   = uint32(9)  // Tangelo PAQ Extra
+	FSE_TYPE     = uint32(10) // Finite State Entropy (table based ANS)
+	AHUFF_TYPE   = uint32(11) // Adaptive Huffman
+	RICE_TYPE    = uint32(12) // Rice Golomb (signed, adaptive parameter)
+	HUF1_TYPE    = uint32(13) // Huffman order 1
+	FPAQ32_TYPE  = uint32(14) // Fast PAQ (order 0) with 32-bit binary arithmetic coder
+	CM32_TYPE    = uint32(15) // Context Model with 32-bit binary arithmetic coder
+	HUFRL_TYPE   = uint32(16) // Huffman with Rice coded runs of the most frequent symbol
+	LZM_TYPE     = uint32(17) // Models per field of the LZ transform output (literals, lengths, distances)
+
+	_MAX_TABLE_HEADER_SIZE = 512 // max size of an encoded alphabet + frequencies (or code lengths)
+	_MAX_FLUSH_SIZE        = 64  // max size of the coder state flushed at the end of a block
 )
 
 // NewEntropyDecoder creates a new entropy decoder using the provided type and bitstream
@@ -43,38 +54,347 @@ func NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
 	case HUFFMAN_TYPE:
 		return NewHuffmanDecoder(ibs)
 
+	case HUF1_TYPE:
+		return NewHuffmanOrder1Decoder(ibs)
+
+	case HUFRL_TYPE:
+		return NewHuffmanRLDecoder(ibs)
+
 	case ANS0_TYPE:
-		return NewANSRangeDecoder(ibs, 0)
+		return NewANSRangeDecoder(ibs, 0, _DEFAULT_ANS0_CHUNK_SIZE, getANSInterleave(ctx))
 
 	case ANS1_TYPE:
-		return NewANSRangeDecoder(ibs, 1)
+		return NewANSRangeDecoder(ibs, 1, _DEFAULT_ANS0_CHUNK_SIZE<<8, getANSInterleave(ctx))
 
 	case RANGE_TYPE:
 		return NewRangeDecoder(ibs)
 
+	case FSE_TYPE:
+		return NewFSEDecoder(ibs)
+
+	case AHUFF_TYPE:
+		return NewAdaptiveHuffmanDecoder(ibs)
+
+	case RICE_TYPE:
+		return NewRiceGolombDecoder(ibs, true, 0)
+
 	case FPAQ_TYPE:
 		return NewFPAQDecoder(ibs)
 
 	case CM_TYPE:
-		predictor, _ := NewCMPredictor()
+		predictor, err := NewCMPredictorWithCtx(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBinaryEntropyDecoder(ibs, predictor)
+
+	case FPAQ32_TYPE:
+		predictor, _ := NewFPAQPredictor()
+		return NewBinaryArithmeticDecoder(ibs, predictor)
+
+	case CM32_TYPE:
+		predictor, err := NewCMPredictorWithCtx(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBinaryArithmeticDecoder(ibs, predictor)
+
+	case LZM_TYPE:
+		predictor, _ := NewLZModelPredictor()
 		return NewBinaryEntropyDecoder(ibs, predictor)
 
 	case TPAQ_TYPE:
-		predictor, _ := NewTPAQPredictor(&ctx)
+		predictor, err := NewTPAQPredictor(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
 		return NewBinaryEntropyDecoder(ibs, predictor)
 
 	case TPAQX_TYPE:
-		predictor, _ := NewTPAQPredictor(&ctx)
+		predictor, err := NewTPAQPredictor(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
 		return NewBinaryEntropyDecoder(ibs, predictor)
 
 	case NONE_TYPE:
 		return NewNullEntropyDecoder(ibs)
 
 	default:
+		if f := getRegisteredCodec(entropyType); f != nil {
+			return f.NewDecoder(ibs, ctx)
+		}
+
 		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
 	}
 }
 
+// resettableDecoder is implemented by the entropy decoders that can decode
+// another block without reallocating their tables and models.
+type resettableDecoder interface {
+	reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error
+}
+
+// resettablePredictor is implemented by the predictors that can re-initialize
+// their model in place.
+type resettablePredictor interface {
+	reset(ctx *map[string]interface{}) error
+}
+
+// releasablePredictor is implemented by the predictors owning big model
+// tables that can be dropped explicitly.
+type releasablePredictor interface {
+	Release()
+}
+
+// DecoderCache keeps the entropy decoder of the previous block so that the
+// next block decoded with the same entropy type reuses its tables and models
+// (only their content is re-initialized). Not thread safe.
+type DecoderCache struct {
+	decoder     kanzi.EntropyDecoder
+	entropyType uint32
+}
+
+// NewEntropyDecoder returns an entropy decoder of the given type reading from
+// the provided bitstream. The cached decoder is reset and returned if it has
+// the same type, otherwise a new decoder is created and cached.
+func (this *DecoderCache) NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyDecoder, error) {
+	if entropyType == NONE_TYPE {
+		// Nothing to reuse, keep the cached decoder for the next block
+		return NewNullEntropyDecoder(ibs)
+	}
+
+	if this.decoder != nil && this.entropyType == entropyType {
+		if r, ok := this.decoder.(resettableDecoder); ok == true {
+			if err := r.reset(ibs, &ctx); err == nil {
+				return this.decoder, nil
+			}
+		}
+	}
+
+	this.decoder = nil
+	ed, err := NewEntropyDecoder(ibs, ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.decoder = ed
+	this.entropyType = entropyType
+	return ed, nil
+}
+
+// Release drops the cached decoder (and the memory it holds)
+func (this *DecoderCache) Release() {
+	if d, ok := this.decoder.(*BinaryEntropyDecoder); ok == true {
+		d.releasePredictor()
+	}
+
+	this.decoder = nil
+}
+
+// resettableEncoder is implemented by the entropy encoders that can encode
+// another block without reallocating their tables and models.
+type resettableEncoder interface {
+	reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error
+}
+
+// CodecPool keeps one entropy encoder and one entropy decoder per entropy
+// type so that each block coded with a type reuses the tables, buffers and
+// models of the codec used for the previous block of this type (only their
+// content is re-initialized). The codecs without such state are created for
+// each block. A codec must be disposed before the next codec of the same type
+// is requested. Not thread safe: concurrent tasks need a pool each.
+type CodecPool struct {
+	encoders map[uint32]kanzi.EntropyEncoder
+	decoders map[uint32]kanzi.EntropyDecoder
+}
+
+// NewEntropyEncoder returns an entropy encoder of the given type writing to
+// the provided bitstream. The pooled encoder of this type is reset and
+// returned if there is one, otherwise a new encoder is created and pooled.
+func (this *CodecPool) NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyEncoder, error) {
+	if ee, prst := this.encoders[entropyType]; prst == true {
+		if err := ee.(resettableEncoder).reset(obs, &ctx); err == nil {
+			return ee, nil
+		}
+
+		delete(this.encoders, entropyType)
+	}
+
+	ee, err := NewEntropyEncoder(obs, ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ee.(resettableEncoder); ok == true {
+		if this.encoders == nil {
+			this.encoders = make(map[uint32]kanzi.EntropyEncoder)
+		}
+
+		// The pool owns the model: Dispose must not release it
+		if b, ok := ee.(*BinaryEntropyEncoder); ok == true {
+			b.shared = true
+		}
+
+		this.encoders[entropyType] = ee
+	}
+
+	return ee, nil
+}
+
+// NewEntropyDecoder returns an entropy decoder of the given type reading from
+// the provided bitstream. The pooled decoder of this type is reset and
+// returned if there is one, otherwise a new decoder is created and pooled.
+func (this *CodecPool) NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyDecoder, error) {
+	if ed, prst := this.decoders[entropyType]; prst == true {
+		if err := ed.(resettableDecoder).reset(ibs, &ctx); err == nil {
+			return ed, nil
+		}
+
+		delete(this.decoders, entropyType)
+	}
+
+	ed, err := NewEntropyDecoder(ibs, ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ed.(resettableDecoder); ok == true {
+		if this.decoders == nil {
+			this.decoders = make(map[uint32]kanzi.EntropyDecoder)
+		}
+
+		this.decoders[entropyType] = ed
+	}
+
+	return ed, nil
+}
+
+// Release drops the pooled codecs (and the memory they hold)
+func (this *CodecPool) Release() {
+	for _, ee := range this.encoders {
+		if b, ok := ee.(*BinaryEntropyEncoder); ok == true {
+			if p, ok := b.predictor.(releasablePredictor); ok == true {
+				p.Release()
+			}
+		}
+	}
+
+	for _, ed := range this.decoders {
+		if b, ok := ed.(*BinaryEntropyDecoder); ok == true {
+			b.releasePredictor()
+		}
+	}
+
+	this.encoders = nil
+	this.decoders = nil
+}
+
+// ModelCache keeps the predictor of the context model based entropy codecs
+// (CM, TPAQ and TPAQX) from one block to the next: each block starts with
+// the model learned on the previous blocks instead of a cold model. The other
+// codecs transmit their statistics with each block and are created afresh.
+// The encoder and the decoder must process the same sequence of blocks with
+// the same entropy types. Not thread safe.
+type ModelCache struct {
+	predictor   kanzi.Predictor
+	entropyType uint32
+}
+
+// getPredictor returns the cached predictor for the entropy type (created if
+// needed) or nil if the codec does not use a predictor. Blocks of the other
+// types (EG. copy blocks) leave the cached model untouched.
+func (this *ModelCache) getPredictor(ctx map[string]interface{}, entropyType uint32) (kanzi.Predictor, error) {
+	if entropyType != CM_TYPE && entropyType != TPAQ_TYPE && entropyType != TPAQX_TYPE {
+		return nil, nil
+	}
+
+	if this.predictor != nil && this.entropyType == entropyType {
+		return this.predictor, nil
+	}
+
+	this.Release()
+	var err error
+
+	if entropyType == CM_TYPE {
+		this.predictor, err = NewCMPredictorWithCtx(&ctx)
+	} else {
+		this.predictor, err = NewTPAQPredictor(&ctx)
+	}
+
+	if err != nil {
+		this.predictor = nil
+		return nil, err
+	}
+
+	this.entropyType = entropyType
+	return this.predictor, nil
+}
+
+// NewEntropyEncoder returns an entropy encoder of the given type writing to
+// the provided bitstream. Context model based encoders use the cached model.
+func (this *ModelCache) NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyEncoder, error) {
+	predictor, err := this.getPredictor(ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if predictor == nil {
+		return NewEntropyEncoder(obs, ctx, entropyType)
+	}
+
+	ee, err := NewBinaryEntropyEncoder(obs, predictor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ee.shared = true
+	return ee, nil
+}
+
+// NewEntropyDecoder returns an entropy decoder of the given type reading from
+// the provided bitstream. Context model based decoders use the cached model.
+func (this *ModelCache) NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyDecoder, error) {
+	predictor, err := this.getPredictor(ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if predictor == nil {
+		return NewEntropyDecoder(ibs, ctx, entropyType)
+	}
+
+	return NewBinaryEntropyDecoder(ibs, predictor)
+}
+
+// Release drops the cached model (and the memory it holds)
+func (this *ModelCache) Release() {
+	if p, ok := this.predictor.(releasablePredictor); ok == true {
+		p.Release()
+	}
+
+	this.predictor = nil
+}
+
 // NewEntropyEncoder creates a new entropy encoder using the provided type and bitstream
 func NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
 	entropyType uint32) (kanzi.EntropyEncoder, error) {
@@ -83,38 +403,174 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
 	case HUFFMAN_TYPE:
 		return NewHuffmanEncoder(obs)
 
+	case HUF1_TYPE:
+		return NewHuffmanOrder1Encoder(obs)
+
+	case HUFRL_TYPE:
+		return NewHuffmanRLEncoder(obs)
+
 	case ANS0_TYPE:
-		return NewANSRangeEncoder(obs, 0)
+		return NewANSRangeEncoder(obs, 0, _DEFAULT_ANS0_CHUNK_SIZE, _DEFAULT_ANS_LOG_RANGE, getANSInterleave(ctx))
 
 	case ANS1_TYPE:
-		return NewANSRangeEncoder(obs, 1)
+		return NewANSRangeEncoder(obs, 1, _DEFAULT_ANS0_CHUNK_SIZE, _DEFAULT_ANS_LOG_RANGE, getANSInterleave(ctx))
 
 	case RANGE_TYPE:
 		return NewRangeEncoder(obs)
 
+	case FSE_TYPE:
+		return NewFSEEncoder(obs)
+
+	case AHUFF_TYPE:
+		return NewAdaptiveHuffmanEncoder(obs)
+
+	case RICE_TYPE:
+		return NewRiceGolombEncoder(obs, true, 0)
+
 	case FPAQ_TYPE:
 		return NewFPAQEncoder(obs)
 
 	case CM_TYPE:
-		predictor, _ := NewCMPredictor()
+		predictor, err := NewCMPredictorWithCtx(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBinaryEntropyEncoder(obs, predictor)
+
+	case FPAQ32_TYPE:
+		predictor, _ := NewFPAQPredictor()
+		return NewBinaryArithmeticEncoder(obs, predictor)
+
+	case CM32_TYPE:
+		predictor, err := NewCMPredictorWithCtx(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBinaryArithmeticEncoder(obs, predictor)
+
+	case LZM_TYPE:
+		predictor, _ := NewLZModelPredictor()
 		return NewBinaryEntropyEncoder(obs, predictor)
 
 	case TPAQ_TYPE:
-		predictor, _ := NewTPAQPredictor(&ctx)
+		predictor, err := NewTPAQPredictor(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
 		return NewBinaryEntropyEncoder(obs, predictor)
 
 	case TPAQX_TYPE:
-		predictor, _ := NewTPAQPredictor(&ctx)
+		predictor, err := NewTPAQPredictor(&ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
 		return NewBinaryEntropyEncoder(obs, predictor)
 
 	case NONE_TYPE:
 		return NewNullEntropyEncoder(obs)
 
 	default:
+		if f := getRegisteredCodec(entropyType); f != nil {
+			return f.NewEncoder(obs, ctx)
+		}
+
 		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
 	}
 }
 
+// MaxEncodedLen returns the max number of bytes written by the entropy
+// encoder of the given type for a block of 'srcLen' bytes. The bound covers
+// the expansion of incompressible data, the headers of each chunk (alphabet,
+// frequencies or code lengths) and the final flush of the coder.
+func MaxEncodedLen(entropyType uint32, srcLen int) int {
+	if srcLen <= 0 {
+		return _MAX_FLUSH_SIZE
+	}
+
+	// Models and statistics never let a coder expand data by more than 1/64
+	res := srcLen + srcLen>>6 + _MAX_FLUSH_SIZE
+
+	switch entropyType {
+
+	case NONE_TYPE:
+		return srcLen + _MAX_FLUSH_SIZE
+
+	case HUFFMAN_TYPE:
+		return res + _MAX_TABLE_HEADER_SIZE*chunks(srcLen, int(_HUF_MAX_CHUNK_SIZE))
+
+	case HUF1_TYPE:
+		// Context map (alphabet and 5 bits per context) and code tables
+		tables := srcLen
+
+		if tables > _HUF1_MAX_TABLES {
+			tables = _HUF1_MAX_TABLES
+		}
+
+		return res + (_MAX_TABLE_HEADER_SIZE*(tables+1))*chunks(srcLen, int(_HUF1_DEFAULT_CHUNK_SIZE))
+
+	case HUFRL_TYPE:
+		// The run lengths of a chunk cost less than one bit per byte
+		return res + srcLen>>3 + _MAX_TABLE_HEADER_SIZE*chunks(srcLen, int(_HUF_MAX_CHUNK_SIZE)) +
+			8*chunks(srcLen, _HUFRL_CHUNK_SIZE)
+
+	case ANS0_TYPE:
+		return res + _MAX_TABLE_HEADER_SIZE*chunks(srcLen, int(_DEFAULT_ANS0_CHUNK_SIZE))
+
+	case ANS1_TYPE:
+		// One frequency table per order 1 context
+		contexts := srcLen
+
+		if contexts > 256 {
+			contexts = 256
+		}
+
+		return res + _MAX_TABLE_HEADER_SIZE*contexts*chunks(srcLen, int(_DEFAULT_ANS0_CHUNK_SIZE<<8))
+
+	case RANGE_TYPE:
+		return res + _MAX_TABLE_HEADER_SIZE*chunks(srcLen, int(_DEFAULT_RANGE_CHUNK_SIZE))
+
+	case FSE_TYPE:
+		return res + _MAX_TABLE_HEADER_SIZE*chunks(srcLen, int(_DEFAULT_FSE_CHUNK_SIZE))
+
+	case AHUFF_TYPE:
+		// The alphabet is the only header
+		return res + _MAX_TABLE_HEADER_SIZE
+
+	case RICE_TYPE:
+		// The best parameter never costs more than 10 bits per byte (logBase=7)
+		return srcLen + (srcLen+3)>>2 + _MAX_FLUSH_SIZE
+
+	default:
+		if f := getRegisteredCodec(entropyType); f != nil {
+			return f.MaxEncodedLen(srcLen)
+		}
+
+		return res
+	}
+}
+
+func chunks(srcLen, chunkSize int) int {
+	return (srcLen + chunkSize - 1) / chunkSize
+}
+
+// getANSInterleave returns the number of interleaved states of the ANS codecs
+// ("ansInterleave" context entry, 1 by default)
+func getANSInterleave(ctx map[string]interface{}) uint {
+	if val, containsKey := ctx["ansInterleave"]; containsKey {
+		return val.(uint)
+	}
+
+	return 1
+}
+
 // GetName returns the name of the entropy codec given its type
 func GetName(entropyType uint32) string {
 	switch entropyType {
@@ -122,6 +578,15 @@ func GetName(entropyType uint32) string {
 	case HUFFMAN_TYPE:
 		return "HUFFMAN"
 
+	case HUF1_TYPE:
+		return "HUFFMAN1"
+
+	case HUFRL_TYPE:
+		return "HUFFMANRL"
+
+	case LZM_TYPE:
+		return "LZMODEL"
+
 	case ANS0_TYPE:
 		return "ANS0"
 
@@ -131,12 +596,27 @@ func GetName(entropyType uint32) string {
 	case RANGE_TYPE:
 		return "RANGE"
 
+	case FSE_TYPE:
+		return "FSE"
+
+	case AHUFF_TYPE:
+		return "AHUFF"
+
+	case RICE_TYPE:
+		return "RICE"
+
 	case FPAQ_TYPE:
 		return "FPAQ"
 
 	case CM_TYPE:
 		return "CM"
 
+	case FPAQ32_TYPE:
+		return "FPAQ32"
+
+	case CM32_TYPE:
+		return "CM32"
+
 	case TPAQ_TYPE:
 		return "TPAQ"
 
@@ -147,17 +627,32 @@ func GetName(entropyType uint32) string {
 		return "NONE"
 
 	default:
+		if f := getRegisteredCodec(entropyType); f != nil {
+			return strings.ToUpper(f.Name())
+		}
+
 		panic(fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType))
 	}
 }
 
 // GetType returns the type of the entropy codec given its name
 func GetType(entropyName string) uint32 {
-	switch strings.ToUpper(entropyName) {
+	name := strings.ToUpper(entropyName)
+
+	switch name {
 
 	case "HUFFMAN":
 		return HUFFMAN_TYPE
 
+	case "HUFFMAN1":
+		return HUF1_TYPE
+
+	case "HUFFMANRL":
+		return HUFRL_TYPE
+
+	case "LZMODEL":
+		return LZM_TYPE
+
 	case "ANS0":
 		return ANS0_TYPE
 
@@ -167,12 +662,27 @@ func GetType(entropyName string) uint32 {
 	case "RANGE":
 		return RANGE_TYPE
 
+	case "FSE":
+		return FSE_TYPE
+
+	case "AHUFF":
+		return AHUFF_TYPE
+
+	case "RICE":
+		return RICE_TYPE
+
 	case "FPAQ":
 		return FPAQ_TYPE
 
 	case "CM":
 		return CM_TYPE
 
+	case "FPAQ32":
+		return FPAQ32_TYPE
+
+	case "CM32":
+		return CM32_TYPE
+
 	case "TPAQ":
 		return TPAQ_TYPE
 
@@ -183,6 +693,10 @@ func GetType(entropyName string) uint32 {
 		return NONE_TYPE
 
 	default:
+		if id, ok := getRegisteredType(name); ok == true {
+			return id
+		}
+
 		panic(fmt.Errorf("Unsupported entropy codec type: '%s'", entropyName))
 	}
 }
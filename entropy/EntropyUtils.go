@@ -17,19 +17,34 @@ package entropy
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
 
 	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util"
 )
 
 const (
 	// INCOMPRESSIBLE_THRESHOLD Any block with entropy*1024 greater than this threshold is considered incompressible
 	INCOMPRESSIBLE_THRESHOLD = 973
 
+	_ENTROPY_SAMPLE_SIZE     = 65536 // Max number of bytes for the order 2 estimate in IsIncompressible
+	_ENTROPY_MIN_ORDER2_SIZE = 4096  // Min block size for the order 2 estimate in IsIncompressible
+
 	_FULL_ALPHABET    = 0 // Flag for full alphabet encoding
 	_PARTIAL_ALPHABET = 1 // Flag for partial alphabet encoding
 	_ALPHABET_256     = 0 // Flag for alphabet with 256 symbols
 	_ALPHABET_NOT_256 = 1 // Flag for alphabet not with 256 symbols
+
+	// MAX_ALPHABET_SIZE is the max size of an alphabet written by EncodeAlphabet
+	MAX_ALPHABET_SIZE = 65536
+
+	// Encoding of a page of 256 symbols in alphabets larger than 256 symbols
+	_PAGE_EMPTY    = 0  // No symbol in page
+	_PAGE_FULL     = 1  // All symbols in page
+	_PAGE_RUNS     = 2  // Runs of consecutive symbols
+	_PAGE_BITMAP   = 3  // Presence flags
+	_PAGE_MAX_RUNS = 15 // More runs than this cost more than the presence flags
 )
 
 type freqSortData struct {
@@ -81,7 +96,10 @@ func (this *freqSortPriorityQueue) Pop() interface{} {
 // EncodeAlphabet writes the alphabet to the bitstream and return the number
 // of symbols written or an error.
 // alphabet must be sorted in increasing order
-// alphabet size must be a power of 2 up to 256
+// alphabet size (capacity of the slice) must be a power of 2 up to MAX_ALPHABET_SIZE.
+// Alphabets larger than 256 symbols use a different format (see
+// encodeLargeAlphabet): the decoder must be provided an array larger than
+// 256 symbols in this case only.
 func EncodeAlphabet(obs kanzi.OutputBitStream, alphabet []int) (int, error) {
 	alphabetSize := cap(alphabet)
 	count := len(alphabet)
@@ -91,8 +109,16 @@ func EncodeAlphabet(obs kanzi.OutputBitStream, alphabet []int) (int, error) {
 		return 0, fmt.Errorf("The alphabet length must be a power of 2, got %v", alphabetSize)
 	}
 
+	if alphabetSize > MAX_ALPHABET_SIZE {
+		return 0, fmt.Errorf("The max alphabet length is %v, got %v", MAX_ALPHABET_SIZE, alphabetSize)
+	}
+
+	if count > 0 && alphabet[count-1] >= alphabetSize {
+		return 0, fmt.Errorf("Invalid symbol in alphabet: %v (must be less than %v)", alphabet[count-1], alphabetSize)
+	}
+
 	if alphabetSize > 256 {
-		return 0, fmt.Errorf("The max alphabet length is 256, got %v", alphabetSize)
+		return encodeLargeAlphabet(obs, alphabet)
 	}
 
 	if count == 0 || count == alphabetSize {
@@ -133,9 +159,96 @@ func EncodeAlphabet(obs kanzi.OutputBitStream, alphabet []int) (int, error) {
 	return count, nil
 }
 
+// encodeLargeAlphabet writes an alphabet of more than 256 symbols. A full
+// alphabet is written as its size. Otherwise, the symbols are split in pages
+// of 256 symbols and, up to the page of the last symbol, each page is coded
+// as empty, full, a list of runs of consecutive symbols (sparse or clustered
+// symbols) or 256 presence flags, whichever is the shortest.
+func encodeLargeAlphabet(obs kanzi.OutputBitStream, alphabet []int) (int, error) {
+	count := len(alphabet)
+
+	if count == 0 || count == cap(alphabet) {
+		obs.WriteBit(_FULL_ALPHABET)
+		log := uint(1)
+
+		for 1<<log <= count {
+			log++
+		}
+
+		obs.WriteBits(uint64(log-1), 5)
+		obs.WriteBits(uint64(count), log)
+		return count, nil
+	}
+
+	obs.WriteBit(_PARTIAL_ALPHABET)
+	lastPage := alphabet[count-1] >> 8
+	obs.WriteBits(uint64(lastPage), 8)
+	var starts [128]int
+	var ends [128]int
+	idx := 0
+
+	for page := 0; page <= lastPage; page++ {
+		// Collect the runs of the page
+		runs := 0
+		n := 0
+
+		for idx < count && alphabet[idx]>>8 == page {
+			s := alphabet[idx] & 0xFF
+
+			if runs > 0 && ends[runs-1] == s {
+				ends[runs-1]++
+			} else {
+				starts[runs] = s
+				ends[runs] = s + 1
+				runs++
+			}
+
+			idx++
+			n++
+		}
+
+		if n == 0 {
+			obs.WriteBits(_PAGE_EMPTY, 2)
+		} else if n == 256 {
+			obs.WriteBits(_PAGE_FULL, 2)
+		} else if runs <= _PAGE_MAX_RUNS {
+			// Runs: gap from the end of the previous run and length
+			obs.WriteBits(_PAGE_RUNS, 2)
+			obs.WriteBits(uint64(runs-1), 4)
+			prev := 0
+
+			for i := 0; i < runs; i++ {
+				obs.WriteBits(uint64(starts[i]-prev), 8)
+				obs.WriteBits(uint64(ends[i]-starts[i]-1), 8)
+				prev = ends[i]
+			}
+		} else {
+			obs.WriteBits(_PAGE_BITMAP, 2)
+			masks := [4]uint64{}
+
+			for i := 0; i < runs; i++ {
+				for j := starts[i]; j < ends[i]; j++ {
+					masks[j>>6] |= uint64(1) << uint(j&63)
+				}
+			}
+
+			for i := range masks {
+				obs.WriteBits(masks[i], 64)
+			}
+		}
+	}
+
+	return count, nil
+}
+
 // DecodeAlphabet reads the alphabet from the bitstream and return the number of symbols
-// read or an error
+// read or an error. Alphabets larger than 256 symbols are read if the length
+// of the provided array is greater than 256 (see EncodeAlphabet).
 func DecodeAlphabet(ibs kanzi.InputBitStream, alphabet []int) (int, error) {
+	if len(alphabet) > 256 {
+		return decodeLargeAlphabet(ibs, alphabet)
+	}
+
 	// Read encoding mode from bitstream
 	alphabetType := ibs.ReadBit()
 
@@ -180,6 +293,79 @@ func DecodeAlphabet(ibs kanzi.InputBitStream, alphabet []int) (int, error) {
 	return count, nil
 }
 
+func decodeLargeAlphabet(ibs kanzi.InputBitStream, alphabet []int) (int, error) {
+	if ibs.ReadBit() == _FULL_ALPHABET {
+		log := uint(1 + ibs.ReadBits(5))
+		alphabetSize := int(ibs.ReadBits(log))
+
+		if alphabetSize > len(alphabet) {
+			return alphabetSize, fmt.Errorf("Invalid bitstream: incorrect alphabet size: %v", alphabetSize)
+		}
+
+		for i := 0; i < alphabetSize; i++ {
+			alphabet[i] = i
+		}
+
+		return alphabetSize, nil
+	}
+
+	lastPage := int(ibs.ReadBits(8))
+
+	if (lastPage+1)<<8 > len(alphabet) {
+		return 0, fmt.Errorf("Invalid bitstream: incorrect alphabet size: %v", (lastPage+1)<<8)
+	}
+
+	count := 0
+
+	for page := 0; page <= lastPage; page++ {
+		base := page << 8
+
+		switch ibs.ReadBits(2) {
+		case _PAGE_EMPTY:
+
+		case _PAGE_FULL:
+			for j := 0; j < 256; j++ {
+				alphabet[count] = base + j
+				count++
+			}
+
+		case _PAGE_RUNS:
+			runs := int(ibs.ReadBits(4)) + 1
+			prev := 0
+
+			for i := 0; i < runs; i++ {
+				start := prev + int(ibs.ReadBits(8))
+				end := start + int(ibs.ReadBits(8)) + 1
+
+				if end > 256 {
+					return count, errors.New("Invalid bitstream: incorrect run in alphabet")
+				}
+
+				for j := start; j < end; j++ {
+					alphabet[count] = base + j
+					count++
+				}
+
+				prev = end
+			}
+
+		case _PAGE_BITMAP:
+			for i := 0; i < 4; i++ {
+				mask := ibs.ReadBits(64)
+
+				for j := 0; j < 64; j++ {
+					if mask&(uint64(1)<<uint(j)) != 0 {
+						alphabet[count] = base + (i << 6) + j
+						count++
+					}
+				}
+			}
+		}
+	}
+
+	return count, nil
+}
+
 // ComputeFirstOrderEntropy1024 computes the order 0 entropy of the block
 // and scales the result by 1024 (result in the [0..1024] range)
 // Fills in the histogram with order 0 frequencies. Incoming array size must be at least 256
@@ -204,6 +390,147 @@ func ComputeFirstOrderEntropy1024(block []byte, histo []int) int {
 	return int(sum / uint64(len(block)))
 }
 
+// ComputeSecondOrderEntropy1024 computes the order 2 entropy of the block
+// (each byte is predicted from the 2 previous bytes) and scales the result
+// by 1024 (result in the [0..1024] range). See ComputeOrderNEntropy1024.
+func ComputeSecondOrderEntropy1024(block []byte) int {
+	return ComputeOrderNEntropy1024(block, 2)
+}
+
+// ComputeOrderNEntropy1024 estimates the order N entropy of the block (each
+// byte is predicted from the 'order' previous bytes, order in [0..8]) and
+// scales the result by 1024 (result in the [0..1024] range).
+// Unlike an empirical conditional entropy, the estimate includes the cost of
+// learning the statistics: it is the size of the block coded by an adaptive
+// model (PPM like), where unseen symbols in a context escape to an adaptive
+// order 0 model, then to a flat distribution. Hence it does not vanish for
+// high orders on small blocks. Contexts are hashed.
+func ComputeOrderNEntropy1024(block []byte, order uint) int {
+	if len(block) == 0 {
+		return 0
+	}
+
+	if order > 8 {
+		order = 8
+	}
+
+	// Size the tables from the block length
+	logSize := kanzi.Log2NoCheck(uint32(len(block))) + 1
+
+	if logSize < 10 {
+		logSize = 10
+	} else if logSize > 20 {
+		logSize = 20
+	}
+
+	ctxShift := 64 - logSize
+	symShift := 64 - (logSize + 2)
+	totals := make([]int32, 1<<logSize)   // number of symbols seen per context
+	distinct := make([]int32, 1<<logSize) // number of distinct symbols per context
+	counts := make([]int32, 1<<(logSize+2))
+	var freqs0 [256]int32
+	total0, distinct0 := int32(0), int32(0)
+	ctxMask := uint64(0)
+
+	if order > 0 {
+		ctxMask = uint64(0xFFFFFFFFFFFFFFFF) >> (64 - 8*order)
+	}
+
+	ctx := uint64(0)
+	sum := uint64(0)
+
+	for _, b := range block {
+		h := ((ctx + 1) * 0x9E3779B97F4A7C15) >> ctxShift
+		hs := ((ctx<<8 | uint64(b)) + 1) * 0xD6E8FEB86659FD93 >> symShift
+		t := totals[h]
+		d := distinct[h]
+
+		if c := counts[hs]; c > 0 {
+			// Symbol seen in this context: p = c / (t + d)
+			sum += uint64(log2_1024(uint32(t+d)) - log2_1024(uint32(c)))
+		} else {
+			// Escape: p = d / (t + d) (1 if the context is new)
+			if t > 0 {
+				sum += uint64(log2_1024(uint32(t+d)) - log2_1024(uint32(d)))
+			}
+
+			if f := freqs0[b]; f > 0 {
+				sum += uint64(log2_1024(uint32(total0+distinct0)) - log2_1024(uint32(f)))
+			} else {
+				// Escape from order 0, then one of the unseen symbols
+				if total0 > 0 {
+					sum += uint64(log2_1024(uint32(total0+distinct0)) - log2_1024(uint32(distinct0)))
+				}
+
+				sum += uint64(log2_1024(uint32(256 - distinct0)))
+				distinct0++
+			}
+
+			freqs0[b]++
+			total0++
+			distinct[h]++
+		}
+
+		counts[hs]++
+		totals[h]++
+		ctx = ((ctx << 8) | uint64(b)) & ctxMask
+	}
+
+	res := int(sum / uint64(8*len(block)))
+
+	if res > 1024 {
+		res = 1024
+	}
+
+	return res
+}
+
+func log2_1024(x uint32) uint32 {
+	res, _ := kanzi.Log2_1024(x)
+	return res
+}
+
+// IsIncompressible returns true if the block is not worth compressing: either
+// it starts with the signature of a compressed data format (EG. JPG, ZIP, ZSTD)
+// or its order 0 entropy is at least INCOMPRESSIBLE_THRESHOLD and so is its
+// order 2 entropy (estimated on the first _ENTROPY_SAMPLE_SIZE bytes), which
+// keeps structured data with a flat histogram (EG. ramps, tables). This is
+// the decision used by the compressed streams to store blocks raw.
+// If not nil, the histogram is filled with order 0 frequencies (incoming
+// array size must be at least 256). Returns the decision and the entropy
+// of the block scaled by 1024 (-1 if not computed).
+func IsIncompressible(block []byte, histo []int) (bool, int) {
+	if util.IsCompressed(util.GetMagicType(block)) == true {
+		return true, -1
+	}
+
+	if histo == nil {
+		histo = make([]int, 256)
+	}
+
+	entropy1024 := ComputeFirstOrderEntropy1024(block, histo)
+
+	if entropy1024 < INCOMPRESSIBLE_THRESHOLD {
+		return false, entropy1024
+	}
+
+	if len(block) < _ENTROPY_MIN_ORDER2_SIZE {
+		return true, entropy1024
+	}
+
+	sample := block
+
+	if len(sample) > _ENTROPY_SAMPLE_SIZE {
+		sample = sample[0:_ENTROPY_SAMPLE_SIZE]
+	}
+
+	if entropy1024 = ComputeSecondOrderEntropy1024(sample); entropy1024 < INCOMPRESSIBLE_THRESHOLD {
+		return false, entropy1024
+	}
+
+	return true, entropy1024
+}
+
 // NormalizeFrequencies scales the frequencies so that their sum equals 'scale'.
 // Returns the size of the alphabet or an error.
 // The alphabet and freqs parameters are updated.
@@ -372,3 +699,46 @@ func ReadVarInt(bs kanzi.InputBitStream) uint32 {
 
 	return res
 }
+
+// bitAccumulator packs codes into a local 64-bit register and only hands
+// whole words to the bitstream, saving one interface call per code.
+// Call flush() before writing to the bitstream directly.
+type bitAccumulator struct {
+	bs    kanzi.OutputBitStream
+	acc   uint64
+	avail uint // number of free bits in 'acc'
+}
+
+func newBitAccumulator(bs kanzi.OutputBitStream) bitAccumulator {
+	return bitAccumulator{bs: bs, avail: 64}
+}
+
+// writeBits appends the 'count' lower bits of 'value' (the other bits
+// must be 0), with count in [1..64]
+func (this *bitAccumulator) writeBits(value uint64, count uint) {
+	if count < this.avail {
+		this.avail -= count
+		this.acc |= (value << this.avail)
+		return
+	}
+
+	this.push(value, count)
+}
+
+// push fills up the register, writes it and keeps the remaining bits
+func (this *bitAccumulator) push(value uint64, count uint) {
+	r := count - this.avail
+	this.bs.WriteBits(this.acc|(value>>r), 64)
+	this.avail = 64 - r
+	this.acc = value << this.avail
+}
+
+// flush writes the pending bits to the bitstream
+func (this *bitAccumulator) flush() {
+	if this.avail < 64 {
+		this.bs.WriteBits(this.acc>>this.avail, 64-this.avail)
+	}
+
+	this.acc = 0
+	this.avail = 64
+}
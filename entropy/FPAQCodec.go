@@ -61,6 +61,23 @@ func NewFPAQEncoder(bs kanzi.OutputBitStream) (*FPAQEncoder, error) {
 	return this, nil
 }
 
+// reset prepares the encoder to encode a new block into the bitstream.
+// The probabilities are re-initialized, the output buffer is kept.
+func (this *FPAQEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.low = 0
+	this.high = _BINARY_ENTROPY_TOP
+	this.bitstream = bs
+	this.disposed = false
+	this.index = 0
+	this.ctxIdx = 1
+
+	for i := range this.probs {
+		this.probs[i] = _FPAQ_PSCALE >> 1
+	}
+
+	return nil
+}
+
 // EncodeByte encodes the given value into the bitstream bit by bit
 func (this *FPAQEncoder) EncodeByte(val byte) {
 	bits := int(val) + 256
@@ -214,6 +231,24 @@ func NewFPAQDecoder(bs kanzi.InputBitStream) (*FPAQDecoder, error) {
 	return this, nil
 }
 
+// reset prepares the decoder to decode a new block from the bitstream.
+// The probabilities are re-initialized, the input buffer is kept.
+func (this *FPAQDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.low = 0
+	this.high = _BINARY_ENTROPY_TOP
+	this.current = 0
+	this.initialized = false
+	this.bitstream = bs
+	this.index = 0
+	this.ctx = 1
+
+	for i := range this.probs {
+		this.probs[i] = _FPAQ_PSCALE >> 1
+	}
+
+	return nil
+}
+
 // DecodeByte decodes the given value from the bitstream bit by bit
 func (this *FPAQDecoder) DecodeByte() byte {
 	this.ctx = 1
@@ -0,0 +1,62 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+// FPAQPredictor the order 0 model of the FPAQ codec as a Predictor, so that
+// it can be used with any binary entropy coder
+type FPAQPredictor struct {
+	probs  [256]int // probability of bit=1
+	ctxIdx int      // previous bits
+}
+
+// NewFPAQPredictor creates a new instance of FPAQPredictor
+func NewFPAQPredictor() (*FPAQPredictor, error) {
+	this := new(FPAQPredictor)
+	err := this.reset(nil)
+	return this, err
+}
+
+// reset re-initializes the probabilities
+func (this *FPAQPredictor) reset(ctx *map[string]interface{}) error {
+	this.ctxIdx = 1
+
+	for i := range this.probs {
+		this.probs[i] = _FPAQ_PSCALE >> 1
+	}
+
+	return nil
+}
+
+// Update updates the probability model based on the observed bit
+func (this *FPAQPredictor) Update(bit byte) {
+	if bit == 0 {
+		this.probs[this.ctxIdx] -= (this.probs[this.ctxIdx] >> 6)
+		this.ctxIdx += this.ctxIdx
+	} else {
+		this.probs[this.ctxIdx] -= ((this.probs[this.ctxIdx] - _FPAQ_PSCALE + 64) >> 6)
+		this.ctxIdx += this.ctxIdx + 1
+	}
+
+	if this.ctxIdx > 255 {
+		this.ctxIdx = 1
+	}
+}
+
+// Get returns the value representing the probability of the next bit being 1
+// in the [0..4095] range.
+func (this *FPAQPredictor) Get() int {
+	return this.probs[this.ctxIdx] >> 4
+}
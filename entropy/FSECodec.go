@@ -0,0 +1,605 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Implementation of a table based Asymmetric Numeral System codec (tANS, as
+// in the Finite State Entropy codec of Zstandard). The frequencies of each
+// chunk are normalized to the size of the state table. The encoder processes
+// the symbols backwards and the decoder forwards: decoding a symbol is one
+// table lookup and one bit read (no multiplication).
+// See "Asymmetric numeral systems" by Jarek Duda and the FSE blog series by
+// Yann Collet at http://fastcompression.blogspot.com
+
+const (
+	_DEFAULT_FSE_CHUNK_SIZE = uint(1 << 15) // 32 KB by default
+	_DEFAULT_FSE_LOG_TABLE  = uint(11)
+	_FSE_MIN_LOG_TABLE      = uint(8)
+	_FSE_MAX_LOG_TABLE      = uint(15)
+	_FSE_MAX_CHUNK_SIZE     = 1 << 24
+)
+
+// FSEEncoder Finite State Entropy (tANS) encoder
+type FSEEncoder struct {
+	bitstream  kanzi.OutputBitStream
+	freqs      []int
+	alphabet   []int
+	symbols    []fseEncSymbol
+	stateTable []uint16
+	buffer     []byte
+	chunkSize  int
+	logTable   uint
+}
+
+type fseEncSymbol struct {
+	deltaNbBits    uint32 // (nbBits << 16) - minimal state of the symbol
+	deltaFindState int32  // index of the first state of the symbol - freq
+}
+
+// NewFSEEncoder creates an instance of FSE encoder.
+// The chunk size indicates how many bytes are encoded (per block) before
+// resetting the frequency stats.
+// Since the number of args is variable, this function can be called like this:
+// NewFSEEncoder(bs) or NewFSEEncoder(bs, 16384, 11)
+// Arguments are chunk size and log of the state table size
+func NewFSEEncoder(bs kanzi.OutputBitStream, args ...uint) (*FSEEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("FSE codec: Invalid null bitstream parameter")
+	}
+
+	if len(args) > 2 {
+		return nil, errors.New("FSE codec: At most chunk size and log table size can be provided")
+	}
+
+	chkSize := _DEFAULT_FSE_CHUNK_SIZE
+	logTable := _DEFAULT_FSE_LOG_TABLE
+
+	if len(args) > 0 {
+		chkSize = args[0]
+	}
+
+	if len(args) > 1 {
+		logTable = args[1]
+	}
+
+	if chkSize < 1024 {
+		return nil, errors.New("FSE codec: The chunk size must be at least 1024")
+	}
+
+	if chkSize > _FSE_MAX_CHUNK_SIZE {
+		return nil, fmt.Errorf("FSE codec: The chunk size must be at most %d", _FSE_MAX_CHUNK_SIZE)
+	}
+
+	if logTable < _FSE_MIN_LOG_TABLE || logTable > _FSE_MAX_LOG_TABLE {
+		return nil, fmt.Errorf("FSE codec: Invalid log table size: %v (must be in [%d..%d])",
+			logTable, _FSE_MIN_LOG_TABLE, _FSE_MAX_LOG_TABLE)
+	}
+
+	this := new(FSEEncoder)
+	this.bitstream = bs
+	this.freqs = make([]int, 257)
+	this.alphabet = make([]int, 256)
+	this.symbols = make([]fseEncSymbol, 256)
+	this.stateTable = make([]uint16, 1<<_FSE_MAX_LOG_TABLE)
+	this.buffer = make([]byte, 0)
+	this.chunkSize = int(chkSize)
+	this.logTable = logTable
+	return this, nil
+}
+
+// fseSpread assigns the states of the table to the symbols (in table order)
+// so that the states of each symbol are evenly spread. Shared by the encoder
+// and the decoder.
+func fseSpread(frequencies []int, table []byte, logTable uint) {
+	size := 1 << logTable
+	mask := size - 1
+	step := (size >> 1) + (size >> 3) + 3
+	pos := 0
+
+	for s := 0; s < 256; s++ {
+		for i := frequencies[s]; i > 0; i-- {
+			table[pos] = byte(s)
+			pos = (pos + step) & mask
+		}
+	}
+}
+
+// Normalize frequencies, build the encoding tables and encode the header
+func (this *FSEEncoder) updateFrequencies(frequencies []int, lt uint) (int, error) {
+	this.bitstream.WriteBits(uint64(lt-_FSE_MIN_LOG_TABLE), 3) // log table size
+	alphabetSize, err := NormalizeFrequencies(frequencies, this.alphabet, frequencies[256], 1<<lt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = this.encodeHeader(alphabetSize, this.alphabet, frequencies, lt); err != nil {
+		return 0, err
+	}
+
+	size := 1 << lt
+	var spread [1 << _FSE_MAX_LOG_TABLE]byte
+	fseSpread(frequencies, spread[:], lt)
+	var cumFreqs [256]int
+	sum := 0
+
+	for s := 0; s < 256; s++ {
+		f := frequencies[s]
+		cumFreqs[s] = sum
+
+		if f == 0 {
+			continue
+		}
+
+		// Symbol with freq f: nbBits is maxBits or maxBits-1, maxBits = lt - log2(f-1)
+		maxBits := lt
+
+		if f > 1 {
+			maxBits = lt - uint(bits.Len32(uint32(f-1))-1)
+		}
+
+		this.symbols[s].deltaNbBits = uint32(maxBits<<16) - uint32(f<<maxBits)
+		this.symbols[s].deltaFindState = int32(sum - f)
+		sum += f
+	}
+
+	// The states of each symbol, in table order
+	for u := 0; u < size; u++ {
+		s := spread[u]
+		this.stateTable[cumFreqs[s]] = uint16(size + u)
+		cumFreqs[s]++
+	}
+
+	return alphabetSize, nil
+}
+
+// Encodes alphabet and frequencies into the bitstream
+func (this *FSEEncoder) encodeHeader(alphabetSize int, alphabet []int, frequencies []int, lt uint) error {
+	if _, err := EncodeAlphabet(this.bitstream, alphabet[0:alphabetSize:256]); err != nil {
+		return err
+	}
+
+	if alphabetSize == 0 {
+		return nil
+	}
+
+	chkSize := 8
+
+	if alphabetSize < 64 {
+		chkSize = 6
+	}
+
+	llt := uint(3)
+
+	for 1<<llt <= lt {
+		llt++
+	}
+
+	bs := newBitAccumulator(this.bitstream)
+
+	// Encode all frequencies (but the first one) by chunks
+	for i := 1; i < alphabetSize; i += chkSize {
+		max := frequencies[alphabet[i]] - 1
+		logMax := uint(0)
+		endj := i + chkSize
+
+		if endj > alphabetSize {
+			endj = alphabetSize
+		}
+
+		// Search for max frequency log size in next chunk
+		for j := i + 1; j < endj; j++ {
+			if frequencies[alphabet[j]]-1 > max {
+				max = frequencies[alphabet[j]] - 1
+			}
+		}
+
+		for 1<<logMax <= max {
+			logMax++
+		}
+
+		bs.writeBits(uint64(logMax), llt)
+
+		if logMax == 0 {
+			// all frequencies equal one in this chunk
+			continue
+		}
+
+		// Write frequencies
+		for j := i; j < endj; j++ {
+			bs.writeBits(uint64(frequencies[alphabet[j]]-1), logMax)
+		}
+	}
+
+	bs.flush()
+	return nil
+}
+
+// Write  Dynamically compute the frequencies for every chunk of data in the block
+// and encode each chunk of the block sequentially
+func (this *FSEEncoder) Write(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	sizeChunk := this.chunkSize
+
+	// At most _FSE_MAX_LOG_TABLE bits per symbol
+	if len(this.buffer) < 2*sizeChunk+16 {
+		this.buffer = make([]byte, 2*sizeChunk+16)
+	}
+
+	end := len(block)
+	startChunk := 0
+
+	for startChunk < end {
+		endChunk := startChunk + sizeChunk
+
+		if endChunk >= end {
+			endChunk = end
+		}
+
+		lt := this.logTable
+
+		// Lower log table size if the chunk is small
+		for lt > _FSE_MIN_LOG_TABLE && 1<<lt > endChunk-startChunk {
+			lt--
+		}
+
+		kanzi.ComputeHistogram(block[startChunk:endChunk], this.freqs, true, true)
+
+		if _, err := this.updateFrequencies(this.freqs, lt); err != nil {
+			return startChunk, err
+		}
+
+		this.encodeChunk(block[startChunk:endChunk], lt)
+		startChunk = endChunk
+	}
+
+	return end, nil
+}
+
+// The bits are written backwards from the end of the buffer so that the
+// decoder reads them forwards, starting with the last bits written.
+func (this *FSEEncoder) encodeChunk(block []byte, lt uint) {
+	buf := this.buffer
+	symb := this.symbols
+	stateTable := this.stateTable
+	n := len(buf)
+	acc := uint64(0)
+	avail := uint(0)
+	st := uint32(1 << lt)
+
+	for i := len(block) - 1; i >= 0; i-- {
+		sym := symb[block[i]]
+		nbBits := (st + sym.deltaNbBits) >> 16
+		acc |= uint64(st&((1<<nbBits)-1)) << avail
+		avail += uint(nbBits)
+		st = uint32(stateTable[int32(st>>nbBits)+sym.deltaFindState])
+
+		if avail >= 32 {
+			n -= 4
+			binary.BigEndian.PutUint32(buf[n:], uint32(acc))
+			acc >>= 32
+			avail -= 32
+		}
+	}
+
+	// Final state, read first by the decoder
+	acc |= uint64(st-(1<<lt)) << avail
+	avail += lt
+
+	for avail >= 8 {
+		n--
+		buf[n] = byte(acc)
+		acc >>= 8
+		avail -= 8
+	}
+
+	pad := uint(0)
+
+	if avail > 0 {
+		n--
+		buf[n] = byte(acc)
+		pad = 8 - avail
+	}
+
+	// Write chunk size, padding then encoded data
+	WriteVarInt(this.bitstream, uint32(len(buf)-n))
+	this.bitstream.WriteBits(uint64(pad), 3)
+	this.bitstream.WriteArray(buf[n:], 8*uint(len(buf)-n))
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The state table and the buffer are kept (they are rebuilt for each chunk).
+func (this *FSEEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// Dispose this implementation does nothing
+func (this *FSEEncoder) Dispose() {
+}
+
+// BitStream returns the underlying bitstream
+func (this *FSEEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// FSEDecoder Finite State Entropy (tANS) decoder
+type FSEDecoder struct {
+	bitstream kanzi.InputBitStream
+	freqs     []int
+	alphabet  []int
+	table     []fseDecEntry
+	buffer    []byte
+	chunkSize int
+	logTable  uint
+}
+
+type fseDecEntry struct {
+	newState uint16 // base of the next state
+	symbol   byte
+	nbBits   byte
+}
+
+// NewFSEDecoder creates an instance of FSE decoder.
+// The chunk size indicates how many bytes are encoded (per block) before
+// resetting the frequency stats.
+// Since the number of args is variable, this function can be called like this:
+// NewFSEDecoder(bs) or NewFSEDecoder(bs, 16384)
+// The argument is the chunk size (the log table size is read from the bitstream)
+func NewFSEDecoder(bs kanzi.InputBitStream, args ...uint) (*FSEDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("FSE codec: Invalid null bitstream parameter")
+	}
+
+	if len(args) > 1 {
+		return nil, errors.New("FSE codec: At most the chunk size can be provided")
+	}
+
+	chkSize := _DEFAULT_FSE_CHUNK_SIZE
+
+	if len(args) > 0 {
+		chkSize = args[0]
+	}
+
+	if chkSize < 1024 {
+		return nil, errors.New("FSE codec: The chunk size must be at least 1024")
+	}
+
+	if chkSize > _FSE_MAX_CHUNK_SIZE {
+		return nil, fmt.Errorf("FSE codec: The chunk size must be at most %d", _FSE_MAX_CHUNK_SIZE)
+	}
+
+	this := new(FSEDecoder)
+	this.bitstream = bs
+	this.freqs = make([]int, 256)
+	this.alphabet = make([]int, 256)
+	this.table = make([]fseDecEntry, 1<<_FSE_MAX_LOG_TABLE)
+	this.buffer = make([]byte, 0)
+	this.chunkSize = int(chkSize)
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The tables are kept (they are rebuilt for each chunk).
+func (this *FSEDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// Decodes alphabet and frequencies from the bitstream and builds the decoding table
+func (this *FSEDecoder) decodeHeader(frequencies []int) (int, error) {
+	this.logTable = _FSE_MIN_LOG_TABLE + uint(this.bitstream.ReadBits(3))
+	alphabetSize, err := DecodeAlphabet(this.bitstream, this.alphabet)
+
+	if err != nil || alphabetSize == 0 {
+		return alphabetSize, err
+	}
+
+	alphabet := this.alphabet
+	scale := 1 << this.logTable
+
+	for i := range frequencies {
+		frequencies[i] = 0
+	}
+
+	chkSize := 8
+
+	if alphabetSize < 64 {
+		chkSize = 6
+	}
+
+	llt := uint(3)
+
+	for 1<<llt <= this.logTable {
+		llt++
+	}
+
+	sum := 0
+
+	// Decode all frequencies (but the first one) by chunks
+	for i := 1; i < alphabetSize; i += chkSize {
+		// Read frequencies size for current chunk
+		logMax := uint(this.bitstream.ReadBits(llt))
+
+		if 1<<logMax > scale {
+			err := fmt.Errorf("Invalid bitstream: incorrect frequency size %v in FSE decoder", logMax)
+			return alphabetSize, err
+		}
+
+		endj := i + chkSize
+
+		if endj > alphabetSize {
+			endj = alphabetSize
+		}
+
+		// Read frequencies
+		for j := i; j < endj; j++ {
+			freq := 1
+
+			if logMax > 0 {
+				freq = int(1 + this.bitstream.ReadBits(logMax))
+
+				if freq <= 0 || freq >= scale {
+					err := fmt.Errorf("Invalid bitstream: incorrect frequency %v for symbol '%v' in FSE decoder", freq, alphabet[j])
+					return alphabetSize, err
+				}
+			}
+
+			frequencies[alphabet[j]] = freq
+			sum += freq
+		}
+	}
+
+	// Infer first frequency
+	if scale <= sum {
+		err := fmt.Errorf("Invalid bitstream: incorrect frequency %v for symbol '%v' in FSE decoder", scale-sum, alphabet[0])
+		return alphabetSize, err
+	}
+
+	frequencies[alphabet[0]] = scale - sum
+	var spread [1 << _FSE_MAX_LOG_TABLE]byte
+	fseSpread(frequencies, spread[:], this.logTable)
+	var next [256]int
+	copy(next[:], frequencies)
+	table := this.table[0:scale]
+
+	for u := range table {
+		s := spread[u]
+		x := next[s] // in [freq..2*freq[
+		next[s]++
+		nbBits := this.logTable - uint(bits.Len32(uint32(x))-1)
+		table[u] = fseDecEntry{newState: uint16((x << nbBits) - scale), symbol: s, nbBits: byte(nbBits)}
+	}
+
+	return alphabetSize, nil
+}
+
+// Decode data from the bitstream and write them, chunk by chunk,
+// into the block.
+func (this *FSEDecoder) Read(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	sizeChunk := this.chunkSize
+	end := len(block)
+	startChunk := 0
+
+	// Add some padding: the bit reader loads 4 bytes at a time
+	if len(this.buffer) < 2*sizeChunk+16 {
+		this.buffer = make([]byte, 2*sizeChunk+16)
+	}
+
+	for startChunk < end {
+		alphabetSize, err := this.decodeHeader(this.freqs)
+
+		if err != nil || alphabetSize == 0 {
+			return startChunk, err
+		}
+
+		endChunk := startChunk + sizeChunk
+
+		if endChunk >= end {
+			endChunk = end
+		}
+
+		if err = this.decodeChunk(block[startChunk:endChunk]); err != nil {
+			return startChunk, err
+		}
+
+		startChunk = endChunk
+	}
+
+	return len(block), nil
+}
+
+func (this *FSEDecoder) decodeChunk(block []byte) error {
+	// Read chunk size and padding
+	sz := int(ReadVarInt(this.bitstream))
+	pad := uint(this.bitstream.ReadBits(3))
+
+	if sz > len(this.buffer)-16 {
+		return fmt.Errorf("Invalid bitstream: incorrect chunk size %v in FSE decoder", sz)
+	}
+
+	// The buffer can hold 15 bits per symbol: a corrupted chunk cannot make
+	// the reader go past its end (it is detected at the end of the chunk)
+	buf := this.buffer
+	this.bitstream.ReadArray(buf, uint(8*sz))
+
+	for i := sz; i < sz+16; i++ {
+		buf[i] = 0
+	}
+
+	table := this.table[0 : 1<<this.logTable]
+	lt := this.logTable
+	acc := uint64(binary.BigEndian.Uint32(buf[0:]))
+	idx := 4
+	avail := 32 - pad
+
+	// Initial state
+	st := uint32(acc>>(avail-lt)) & ((1 << lt) - 1)
+	avail -= lt
+
+	for i := range block {
+		if avail < 16 {
+			acc = (acc << 32) | uint64(binary.BigEndian.Uint32(buf[idx:]))
+			idx += 4
+			avail += 32
+		}
+
+		e := table[st]
+		block[i] = e.symbol
+		nbBits := uint(e.nbBits)
+		avail -= nbBits
+		st = uint32(e.newState) + uint32(acc>>avail)&((1<<nbBits)-1)
+	}
+
+	// All the bits of the chunk must have been read
+	if 8*idx-int(avail) != 8*sz {
+		return errors.New("Invalid bitstream: incorrect chunk data in FSE decoder")
+	}
+
+	return nil
+}
+
+// BitStream returns the underlying bitstream
+func (this *FSEDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *FSEDecoder) Dispose() {
+}
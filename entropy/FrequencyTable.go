@@ -0,0 +1,151 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+const (
+	_FREQ_TABLE_VERSION = 1
+	_FREQ_TABLE_SIZE    = 2 + 2*256 // version, log range, frequencies
+)
+
+// FrequencyTable a table of order 0 frequencies normalized to 1<<logRange.
+// It can be persisted (MarshalBinary) and used to encode many blocks with
+// the same statistics (see NewRangeEncoderWithTable): the table is not
+// transmitted with each block, the decoder must use the same table.
+type FrequencyTable struct {
+	frequencies [256]int
+	logRange    uint
+}
+
+// NewFrequencyTable returns the frequencies of the symbols in the sample
+// normalized to 1<<logRange (in [8..16]). The symbols missing from the
+// sample get the smallest frequency, so that any block can be encoded with
+// the table (use a log range of 12 or more: with a small range, these symbols
+// take a large share of it). The sample should be representative of the
+// blocks to encode.
+func NewFrequencyTable(sample []byte, logRange uint) (*FrequencyTable, error) {
+	if logRange < 8 || logRange > 16 {
+		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+	}
+
+	var freqs [256]int
+	var alphabet [256]int
+	kanzi.ComputeHistogram(sample, freqs[:], true, false)
+	total := 0
+
+	for i := range freqs {
+		if freqs[i] == 0 {
+			freqs[i] = 1
+		}
+
+		total += freqs[i]
+	}
+
+	if _, err := NormalizeFrequencies(freqs[:], alphabet[:], total, 1<<logRange); err != nil {
+		return nil, err
+	}
+
+	return &FrequencyTable{frequencies: freqs, logRange: logRange}, nil
+}
+
+// NewFrequencyTableWithFrequencies returns a table with the given frequencies
+// (256 values, the sum must be 1<<logRange and at least 2 frequencies must
+// not be null). Symbols with a null frequency cannot be encoded.
+func NewFrequencyTableWithFrequencies(frequencies []int, logRange uint) (*FrequencyTable, error) {
+	if logRange < 8 || logRange > 16 {
+		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+	}
+
+	if len(frequencies) != 256 {
+		return nil, errors.New("Invalid frequencies parameter (must contain 256 values)")
+	}
+
+	this := &FrequencyTable{logRange: logRange}
+	sum := 0
+
+	for i, f := range frequencies {
+		if f < 0 || f >= 1<<logRange {
+			return nil, fmt.Errorf("Invalid frequency %v for symbol '%v'", f, i)
+		}
+
+		this.frequencies[i] = f
+		sum += f
+	}
+
+	if sum != 1<<logRange {
+		return nil, fmt.Errorf("Invalid frequencies: the sum is %v (must be %v)", sum, 1<<logRange)
+	}
+
+	return this, nil
+}
+
+// Frequencies returns a copy of the normalized frequencies
+func (this *FrequencyTable) Frequencies() []int {
+	res := make([]int, 256)
+	copy(res, this.frequencies[:])
+	return res
+}
+
+// LogRange returns the log of the sum of the frequencies
+func (this *FrequencyTable) LogRange() uint {
+	return this.logRange
+}
+
+// MarshalBinary returns the serialized table (514 bytes)
+func (this *FrequencyTable) MarshalBinary() ([]byte, error) {
+	res := make([]byte, _FREQ_TABLE_SIZE)
+	res[0] = _FREQ_TABLE_VERSION
+	res[1] = byte(this.logRange)
+
+	for i, f := range this.frequencies {
+		binary.BigEndian.PutUint16(res[2+2*i:], uint16(f))
+	}
+
+	return res, nil
+}
+
+// UnmarshalBinary restores a table serialized by MarshalBinary
+func (this *FrequencyTable) UnmarshalBinary(data []byte) error {
+	if len(data) != _FREQ_TABLE_SIZE {
+		return fmt.Errorf("Invalid frequency table size: %v (must be %v)", len(data), _FREQ_TABLE_SIZE)
+	}
+
+	if data[0] != _FREQ_TABLE_VERSION {
+		return fmt.Errorf("Unsupported frequency table version: %v", data[0])
+	}
+
+	freqs := make([]int, 256)
+
+	for i := range freqs {
+		freqs[i] = int(binary.BigEndian.Uint16(data[2+2*i:]))
+	}
+
+	t, err := NewFrequencyTableWithFrequencies(freqs, uint(data[1]))
+
+	if err != nil {
+		return err
+	}
+
+	*this = *t
+	return nil
+}
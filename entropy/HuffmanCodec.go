@@ -26,9 +26,11 @@ import (
 const (
 	_HUF_LOG_MAX_CHUNK_SIZE  = 14
 	_HUF_MAX_CHUNK_SIZE      = uint(1 << _HUF_LOG_MAX_CHUNK_SIZE)
-	_HUF_MAX_SYMBOL_SIZE     = _HUF_LOG_MAX_CHUNK_SIZE
-	_HUF_DECODING_BATCH_SIZE = 14 // ensures decoding table fits in L1 cache
-	_HUF_BUFFER_SIZE         = uint(_HUF_MAX_SYMBOL_SIZE<<8) + 256
+	_HUF_MAX_SYMBOL_SIZE     = _HUF_LOG_MAX_CHUNK_SIZE // default max code length
+	_HUF_MIN_CODE_LEN_LIMIT  = 8                       // 256 symbols
+	_HUF_MAX_CODE_LEN        = 24                      // longest code with a custom max code length
+	_HUF_DECODING_BATCH_SIZE = 14                      // ensures decoding table fits in L1 cache
+	_HUF_BUFFER_SIZE         = uint(_HUF_MAX_CODE_LEN<<8) + 256
 	_HUF_DECODING_MASK       = (1 << _HUF_DECODING_BATCH_SIZE) - 1
 )
 
@@ -48,8 +50,8 @@ func generateCanonicalCodes(sizes []byte, codes []uint, symbols []int) (int, err
 			}
 
 			// Max length reached
-			if sizes[s] > _HUF_MAX_SYMBOL_SIZE {
-				return -1, fmt.Errorf("Could not generate Huffman codes: max code length (%d bits) exceeded", _HUF_MAX_SYMBOL_SIZE)
+			if sizes[s] > _HUF_MAX_CODE_LEN {
+				return -1, fmt.Errorf("Could not generate Huffman codes: max code length (%d bits) exceeded", _HUF_MAX_CODE_LEN)
 			}
 
 			buf[(int(sizes[s]-1)<<8)|s] = 1
@@ -95,25 +97,32 @@ type HuffmanEncoder struct {
 	alphabet   [256]int
 	sranks     [256]int
 	chunkSize  int
-	maxCodeLen int
+	maxCodeLen int // longest code of the current chunk
+	lenLimit   int // max code length allowed
 }
 
 // NewHuffmanEncoder creates an instance of HuffmanEncoder.
 // Since the number of args is variable, this function can be called like this:
-// NewHuffmanEncoder(bs) or NewHuffmanEncoder(bs, 16384) (the second argument
-// being the chunk size)
+// NewHuffmanEncoder(bs), NewHuffmanEncoder(bs, 16384) (the second argument
+// being the chunk size) or NewHuffmanEncoder(bs, 16384, 12) (the third
+// argument being the max code length, 14 by default).
+// A max code length of 12 gives smaller decoding tables, lengths above 14
+// (up to 24) improve the compression of skewed distributions but the chunks
+// using such codes are decoded bit by bit. The code lengths are sent with
+// each chunk, so the decoder needs no parameter.
 func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder, error) {
 	if bs == nil {
 		return nil, errors.New("Huffman codec: Invalid null bitstream parameter")
 	}
 
-	if len(args) > 1 {
-		return nil, errors.New("Huffman codec: At most one chunk size can be provided")
+	if len(args) > 2 {
+		return nil, errors.New("Huffman codec: At most one chunk size and one max code length can be provided")
 	}
 
 	chkSize := _HUF_MAX_CHUNK_SIZE
+	lenLimit := uint(_HUF_MAX_SYMBOL_SIZE)
 
-	if len(args) == 1 {
+	if len(args) >= 1 {
 		chkSize = args[0]
 
 		if chkSize < 1024 {
@@ -125,9 +134,19 @@ func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder,
 		}
 	}
 
+	if len(args) == 2 {
+		lenLimit = args[1]
+
+		if lenLimit < _HUF_MIN_CODE_LEN_LIMIT || lenLimit > _HUF_MAX_CODE_LEN {
+			return nil, fmt.Errorf("Huffman codec: Invalid max code length: %v (must be in [%d..%d])",
+				lenLimit, _HUF_MIN_CODE_LEN_LIMIT, _HUF_MAX_CODE_LEN)
+		}
+	}
+
 	this := new(HuffmanEncoder)
 	this.bitstream = bs
 	this.chunkSize = int(chkSize)
+	this.lenLimit = int(lenLimit)
 
 	// Default frequencies, sizes and codes
 	for i := 0; i < 256; i++ {
@@ -161,42 +180,18 @@ func (this *HuffmanEncoder) updateFrequencies(frequencies []int) (int, error) {
 		return count, err
 	}
 
-	retries := uint(0)
-
-	for {
-		if err := this.computeCodeLengths(frequencies, sizes[:], count); err != nil {
-			return count, err
-		}
-
-		if this.maxCodeLen <= _HUF_MAX_SYMBOL_SIZE {
-			// Usual case
-			if _, err := generateCanonicalCodes(sizes[:], this.codes[:], this.sranks[0:count]); err != nil {
-				return count, err
-			}
-
-			break
-		}
-
-		// Rare: some codes exceed the budget for the max code length => normalize
-		// frequencies (it boosts the smallest frequencies) and try once more.
-		if retries > 2 {
-			return count, fmt.Errorf("Could not generate Huffman codes: max code length (%d bits) exceeded, ", _HUF_MAX_SYMBOL_SIZE)
-		}
-
-		totalFreq := 0
-
-		for i := 0; i < count; i++ {
-			totalFreq += frequencies[this.alphabet[i]]
-		}
+	if err := this.computeCodeLengths(frequencies, sizes[:], count); err != nil {
+		return count, err
+	}
 
-		// Copy alphabet (modified by normalizeFrequencies)
-		var alphabet [256]int
-		copy(alphabet[:], this.alphabet[:count])
-		retries++
+	// Rare: some codes exceed the max code length => shorten them
+	if this.maxCodeLen > this.lenLimit {
+		limitCodeLengths(sizes[:], this.sranks[0:count], this.lenLimit)
+		this.maxCodeLen = this.lenLimit
+	}
 
-		if _, err := NormalizeFrequencies(frequencies, alphabet[:count], totalFreq, int(_HUF_MAX_CHUNK_SIZE>>(2*retries))); err != nil {
-			return count, err
-		}
+	if _, err := generateCanonicalCodes(sizes[:], this.codes[:], this.sranks[0:count]); err != nil {
+		return count, err
 	}
 
 	// Transmit code lengths only, frequencies and codes do not matter
@@ -208,7 +203,7 @@ func (this *HuffmanEncoder) updateFrequencies(frequencies []int) (int, error) {
 
 	prevSize := byte(2)
 
-	// Pack size and code (size <= _HUF_MAX_SYMBOL_SIZE bits)
+	// Pack size and code (size <= _HUF_MAX_CODE_LEN bits)
 	// Unary encode the length differences
 	for _, s := range symbols {
 		currSize := sizes[s]
@@ -266,6 +261,54 @@ func (this *HuffmanEncoder) computeCodeLengths(frequencies []int, sizes []byte,
 	return err
 }
 
+// limitCodeLengths caps the code lengths to 'limit' bits. The symbols are
+// sorted by increasing frequency. The lengths of the codes exceeding the limit
+// are set to the limit, then codes are moved one level deeper (starting with
+// the deepest level below the limit) until the Kraft sum is 1 again. The most
+// frequent symbols get the shortest codes.
+func limitCodeLengths(sizes []byte, ranks []int, limit int) {
+	var counts [256]int
+
+	for _, s := range ranks {
+		l := int(sizes[s])
+
+		if l > limit {
+			l = limit
+		}
+
+		counts[l]++
+	}
+
+	total := 0
+
+	for l := 1; l <= limit; l++ {
+		total += counts[l] << uint(limit-l)
+	}
+
+	for total > 1<<uint(limit) {
+		counts[limit]--
+
+		for l := limit - 1; l > 0; l-- {
+			if counts[l] != 0 {
+				counts[l]--
+				counts[l+1] += 2
+				break
+			}
+		}
+
+		total--
+	}
+
+	n := len(ranks) - 1
+
+	for l := 1; l <= limit; l++ {
+		for c := counts[l]; c > 0; c-- {
+			sizes[ranks[n]] = byte(l)
+			n--
+		}
+	}
+}
+
 func computeInPlaceSizesPhase1(data []int) {
 	n := len(data)
 
@@ -352,37 +395,58 @@ func (this *HuffmanEncoder) Write(block []byte) (int, error) {
 		}
 
 		c := this.codes
-		bs := this.bitstream
+		bs := newBitAccumulator(this.bitstream)
 		endChunk4 := ((endChunk - startChunk) & -4) + startChunk
 
+		// 4 codes fit in 64 bits if they are at most 16 bits long
+		if this.maxCodeLen > 16 {
+			endChunk4 = startChunk
+		}
+
 		for i := startChunk; i < endChunk4; i += 4 {
 			// Pack 4 codes into 1 uint64
-			code1 := c[block[i]]
+			b := block[i : i+4]
+			code1 := c[b[0]]
 			codeLen1 := uint(code1 >> 24)
-			code2 := c[block[i+1]]
+			code2 := c[b[1]]
 			codeLen2 := uint(code2 >> 24)
-			code3 := c[block[i+2]]
+			code3 := c[b[2]]
 			codeLen3 := uint(code3 >> 24)
-			code4 := c[block[i+3]]
+			code4 := c[b[3]]
 			codeLen4 := uint(code4 >> 24)
 			st := (uint64(code1&0xFFFF) << (codeLen2 + codeLen3 + codeLen4)) |
 				(uint64(code2&((1<<codeLen2)-1)) << (codeLen3 + codeLen4)) |
 				(uint64(code3&((1<<codeLen3)-1)) << codeLen4) |
 				uint64(code4&((1<<codeLen4)-1))
-			bs.WriteBits(st, codeLen1+codeLen2+codeLen3+codeLen4)
+			bs.writeBits(st, codeLen1+codeLen2+codeLen3+codeLen4)
 		}
 
 		for i := endChunk4; i < endChunk; i++ {
 			code := c[block[i]]
-			bs.WriteBits(uint64(code), code>>24)
+			bs.writeBits(uint64(code&0xFFFFFF), code>>24)
 		}
 
+		// The code lengths of the next chunk go straight to the bitstream
+		bs.flush()
+
 		startChunk = endChunk
 	}
 
 	return len(block), nil
 }
 
+// reset prepares the encoder to encode a new block into the bitstream.
+// The code tables are kept (they are rebuilt for each chunk).
+func (this *HuffmanEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+
+	for i := 0; i < 256; i++ {
+		this.codes[i] = uint(i)
+	}
+
+	return nil
+}
+
 // Dispose this implementation does nothing
 func (this *HuffmanEncoder) Dispose() {
 }
@@ -399,10 +463,11 @@ type HuffmanDecoder struct {
 	codes     [256]uint
 	alphabet  [256]int
 	sizes     [256]byte
-	table     []uint16 // decoding table: code -> size, symbol
-	state     uint64   // holds bits read from bitstream
-	bits      byte     // holds number of unused bits in 'state'
+	table     [_HUF_DECODING_MASK + 1]uint16 // decoding table: code -> size, symbol
 	chunkSize int
+	lenLimit  int                        // max code length accepted
+	maxLen    int                        // longest code of the current chunk
+	lenCounts [_HUF_MAX_CODE_LEN + 1]int // number of codes of each length
 }
 
 // NewHuffmanDecoder creates an instance of HuffmanDecoder.
@@ -434,8 +499,8 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 
 	this := new(HuffmanDecoder)
 	this.bitstream = bs
-	this.table = make([]uint16, 1<<_HUF_DECODING_BATCH_SIZE)
 	this.chunkSize = int(chkSize)
+	this.lenLimit = _HUF_MAX_CODE_LEN
 
 	// Default lengths & canonical codes
 	for i := 0; i < 256; i++ {
@@ -446,6 +511,19 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 	return this, nil
 }
 
+// reset prepares the decoder to decode a new block from the bitstream.
+// The decoding table is kept (it is rebuilt for each chunk).
+func (this *HuffmanDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+
+	for i := 0; i < 256; i++ {
+		this.sizes[i] = 8
+		this.codes[i] = uint(i)
+	}
+
+	return nil
+}
+
 // readLengths decodes the code lengths from the bitstream and generates
 // the Huffman codes for decoding.
 func (this *HuffmanDecoder) readLengths() (int, error) {
@@ -463,6 +541,8 @@ func (this *HuffmanDecoder) readLengths() (int, error) {
 
 	currSize := int8(2)
 	symbols := this.alphabet[0:count]
+	this.lenCounts = [_HUF_MAX_CODE_LEN + 1]int{}
+	this.maxLen = 0
 
 	// Decode lengths
 	for _, s := range symbols {
@@ -473,26 +553,34 @@ func (this *HuffmanDecoder) readLengths() (int, error) {
 		this.codes[s] = 0
 		currSize += int8(egdec.DecodeByte())
 
-		if currSize <= 0 || currSize > _HUF_MAX_SYMBOL_SIZE {
+		if currSize <= 0 || int(currSize) > this.lenLimit {
 			return 0, fmt.Errorf("Invalid bitstream: incorrect size %d for Huffman symbol %d", currSize, s)
 		}
 
 		this.sizes[s] = byte(currSize)
+		this.lenCounts[currSize]++
+
+		if this.maxLen < int(currSize) {
+			this.maxLen = int(currSize)
+		}
 	}
 
+	// Sorts the symbols in canonical order
 	if _, err := generateCanonicalCodes(this.sizes[:], this.codes[:], symbols); err != nil {
 		return count, err
 	}
 
-	this.buildDecodingTable(count)
+	// Codes longer than the decoding table are decoded bit by bit
+	if this.maxLen <= _HUF_DECODING_BATCH_SIZE {
+		this.buildDecodingTable(count)
+	}
+
 	return count, nil
 }
 
 // max(CodeLen) must be <= _HUF_MAX_SYMBOL_SIZE
 func (this *HuffmanDecoder) buildDecodingTable(count int) {
-	for i := range this.table {
-		this.table[i] = 0
-	}
+	this.table = [_HUF_DECODING_MASK + 1]uint16{}
 
 	length := byte(0)
 
@@ -511,21 +599,11 @@ func (this *HuffmanDecoder) buildDecodingTable(count int) {
 		// starting with the same prefix point to symbol s
 		idx := code << (_HUF_DECODING_BATCH_SIZE - length)
 		end := (code + 1) << (_HUF_DECODING_BATCH_SIZE - length)
-		t := this.table[0:end]
+		t := this.table[idx:end]
 
-		for idx+4 < end {
-			t[idx] = val
-			t[idx+1] = val
-			t[idx+2] = val
-			t[idx+3] = val
-			idx += 4
+		for i := range t {
+			t[i] = val
 		}
-
-		for idx < end {
-			t[idx] = val
-			idx++
-		}
-
 	}
 }
 
@@ -551,79 +629,76 @@ func (this *HuffmanDecoder) Read(block []byte) (int, error) {
 			return startChunk, err
 		}
 
-		// Compute minimum number of bits required in bitstream for fast decoding
-		minCodeLen := int(this.sizes[this.alphabet[0]]) // not 0
-		padding := 64 / minCodeLen
-
-		if minCodeLen*padding != 64 {
-			padding++
-		}
-
 		endChunk := startChunk + this.chunkSize
 
 		if endChunk > end {
 			endChunk = end
 		}
 
-		endChunk4 := startChunk
-
-		if endChunk > startChunk+padding {
-			endChunk4 += ((endChunk - startChunk - padding) & -4)
-		}
-
-		for i := startChunk; i < endChunk4; i += 4 {
-			this.fetchBits()
-			block[i] = this.decodeByte()
-			block[i+1] = this.decodeByte()
-			block[i+2] = this.decodeByte()
-			block[i+3] = this.decodeByte()
-		}
+		if this.maxLen > _HUF_DECODING_BATCH_SIZE {
+			for i := startChunk; i < endChunk; i++ {
+				block[i] = this.decodeLongByte()
+			}
 
-		// Fallback to regular decoding
-		for i := endChunk4; i < endChunk; i++ {
-			block[i] = this.slowDecodeByte()
+			startChunk = endChunk
+			continue
 		}
 
+		this.decodeChunk(block[startChunk:endChunk])
 		startChunk = endChunk
 	}
 
 	return len(block), nil
 }
 
-func (this *HuffmanDecoder) slowDecodeByte() byte {
+// decodeLongByte decodes a canonical code bit by bit (the symbols are sorted
+// by code in the alphabet)
+func (this *HuffmanDecoder) decodeLongByte() byte {
 	code := 0
-	codeLen := uint8(0)
+	first := 0 // first code of the current length
+	index := 0 // index of the first symbol of the current length
 
-	for codeLen < _HUF_MAX_SYMBOL_SIZE {
-		codeLen++
+	for codeLen := 1; codeLen <= this.maxLen; codeLen++ {
+		code |= this.bitstream.ReadBit()
+		count := this.lenCounts[codeLen]
 
-		if this.bits == 0 {
-			code = (code << 1) | this.bitstream.ReadBit()
-		} else {
-			this.bits--
-			code = (code << 1) | int((this.state>>this.bits)&1)
+		if code-first < count {
+			return byte(this.alphabet[index+code-first])
 		}
 
-		idx := code << (_HUF_DECODING_BATCH_SIZE - codeLen)
-
-		if uint8(this.table[idx]) == codeLen {
-			return byte(this.table[idx] >> 8)
-		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
 	}
 
 	panic(errors.New("Invalid bitstream: incorrect Huffman code"))
 }
 
-func (this *HuffmanDecoder) fetchBits() {
-	read := this.bitstream.ReadBits(uint(64 - this.bits))
-	this.state = (this.state << (64 - this.bits)) | read
-	this.bits = 64
-}
+// decodeChunk decodes the symbols with the decoding table. The next 64 bits
+// are peeked and the bits of the symbols decoded from them are consumed.
+func (this *HuffmanDecoder) decodeChunk(block []byte) {
+	table := &this.table
+
+	for len(block) > 0 {
+		state := this.bitstream.PeekBits(64)
+		used := uint(0)
+		n := 0
 
-func (this *HuffmanDecoder) decodeByte() byte {
-	val := this.table[int(this.state>>(this.bits-_HUF_DECODING_BATCH_SIZE))&_HUF_DECODING_MASK]
-	this.bits -= byte(val)
-	return byte(val >> 8)
+		// At least _HUF_DECODING_BATCH_SIZE bits left in 'state' for each symbol
+		for used <= 64-_HUF_DECODING_BATCH_SIZE && n < len(block) {
+			val := table[(state>>(64-_HUF_DECODING_BATCH_SIZE-used))&_HUF_DECODING_MASK]
+			used += uint(uint8(val))
+			block[n] = byte(val >> 8)
+			n++
+		}
+
+		if used > 0 {
+			// Zero when the table is empty (invalid bitstream)
+			this.bitstream.ReadBits(used)
+		}
+
+		block = block[n:]
+	}
 }
 
 // BitStream returns the underlying bitstream
@@ -0,0 +1,486 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Order 1 Huffman codec: the code of a symbol depends on the previous byte.
+// For each chunk, the 256 contexts (previous byte values) are clustered into
+// at most _HUF1_MAX_TABLES code tables to bound the size of the header: the
+// contexts are assigned one by one (most frequent first) to the table which
+// minimizes the estimated cost (code lengths plus table header), a new table
+// being created if cheaper.
+// Chunk layout: number of tables - 1 (5 bits), then if there are several
+// tables the alphabet of the contexts of the chunk followed by the table of
+// each context, then the alphabet and code lengths of each table (same
+// format as the order 0 Huffman codec) and the codes.
+// Decoding uses the same tables as the order 0 decoder (one per code table).
+
+const (
+	_HUF1_MAX_TABLES         = 32
+	_HUF1_DEFAULT_CHUNK_SIZE = uint(1 << 16)
+	_HUF1_MAX_CHUNK_SIZE     = uint(1 << 24)
+	_HUF1_TABLE_COST         = 16 << 10 // estimated cost in bits (x1024) of a table header
+	_HUF1_SYMBOL_COST        = 10 << 10 // estimated cost in bits (x1024) of a symbol in a table header
+)
+
+// HuffmanOrder1Encoder  Implementation of a static order 1 Huffman encoder
+type HuffmanOrder1Encoder struct {
+	bitstream  kanzi.OutputBitStream
+	freqs      [256][256]int     // frequencies of each context
+	tables     []*HuffmanEncoder // one order 0 encoder per code table
+	tableFreqs [][256]int
+	ctxTables  [256]int // code table of each context
+	chunkSize  int
+}
+
+// NewHuffmanOrder1Encoder creates an instance of HuffmanOrder1Encoder.
+// Since the number of args is variable, this function can be called like this:
+// NewHuffmanOrder1Encoder(bs) or NewHuffmanOrder1Encoder(bs, 65536) (the second
+// argument being the chunk size)
+func NewHuffmanOrder1Encoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanOrder1Encoder, error) {
+	if bs == nil {
+		return nil, errors.New("Huffman codec: Invalid null bitstream parameter")
+	}
+
+	chkSize, err := getHuffmanOrder1ChunkSize(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(HuffmanOrder1Encoder)
+	this.bitstream = bs
+	this.chunkSize = int(chkSize)
+	this.tables = make([]*HuffmanEncoder, 0, _HUF1_MAX_TABLES)
+	this.tableFreqs = make([][256]int, _HUF1_MAX_TABLES)
+	return this, nil
+}
+
+func getHuffmanOrder1ChunkSize(args []uint) (uint, error) {
+	if len(args) > 1 {
+		return 0, errors.New("Huffman codec: At most one chunk size can be provided")
+	}
+
+	if len(args) == 0 {
+		return _HUF1_DEFAULT_CHUNK_SIZE, nil
+	}
+
+	if args[0] < 1024 {
+		return 0, errors.New("Huffman codec: The chunk size must be at least 1024")
+	}
+
+	if args[0] > _HUF1_MAX_CHUNK_SIZE {
+		return 0, fmt.Errorf("Huffman codec: The chunk size must be at most %d", _HUF1_MAX_CHUNK_SIZE)
+	}
+
+	return args[0], nil
+}
+
+// xlog2 returns 1024 * x * log2(x)
+func xlog2(x int) int64 {
+	if x == 0 {
+		return 0
+	}
+
+	return int64(x) * int64(log2_1024(uint32(x)))
+}
+
+// buildTables clusters the contexts of the chunk into code tables and
+// computes the frequencies of each table. Return the number of tables.
+func (this *HuffmanOrder1Encoder) buildTables(contexts []int, totals []int) int {
+	// Most frequent contexts first (then by increasing value)
+	sort.Slice(contexts, func(i, j int) bool {
+		if totals[contexts[i]] != totals[contexts[j]] {
+			return totals[contexts[i]] > totals[contexts[j]]
+		}
+
+		return contexts[i] < contexts[j]
+	})
+
+	var symbols [256]int
+	var tableTotals [_HUF1_MAX_TABLES]int
+	nbTables := 0
+
+	for _, c := range contexts {
+		freqs := &this.freqs[c]
+		n := 0
+
+		for s := range freqs {
+			if freqs[s] != 0 {
+				symbols[n] = s
+				n++
+			}
+		}
+
+		best := -1
+		bestCost := int64(math.MaxInt64)
+
+		if nbTables < _HUF1_MAX_TABLES {
+			// Cost of a new table
+			bestCost = xlog2(totals[c]) + _HUF1_TABLE_COST + int64(n)*_HUF1_SYMBOL_COST
+
+			for _, s := range symbols[0:n] {
+				bestCost -= xlog2(freqs[s])
+			}
+		}
+
+		for t := 0; t < nbTables; t++ {
+			// Extra cost of the context in the table
+			tf := &this.tableFreqs[t]
+			cost := xlog2(tableTotals[t]+totals[c]) - xlog2(tableTotals[t])
+
+			for _, s := range symbols[0:n] {
+				if tf[s] == 0 {
+					cost += _HUF1_SYMBOL_COST
+				}
+
+				cost -= xlog2(tf[s]+freqs[s]) - xlog2(tf[s])
+			}
+
+			if cost < bestCost {
+				best = t
+				bestCost = cost
+			}
+		}
+
+		if best < 0 {
+			best = nbTables
+			nbTables++
+			this.tableFreqs[best] = [256]int{}
+		}
+
+		tf := &this.tableFreqs[best]
+
+		for _, s := range symbols[0:n] {
+			tf[s] += freqs[s]
+		}
+
+		tableTotals[best] += totals[c]
+		this.ctxTables[c] = best
+	}
+
+	return nbTables
+}
+
+// Write encodes the data provided into the bitstream. Return the number of byte
+// written to the bitstream. Dynamically compute the frequencies and code
+// tables for every chunk of data in the block
+func (this *HuffmanOrder1Encoder) Write(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Huffman codec: Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	end := len(block)
+	startChunk := 0
+	prv := byte(0)
+
+	for startChunk < end {
+		endChunk := startChunk + this.chunkSize
+
+		if endChunk > len(block) {
+			endChunk = len(block)
+		}
+
+		this.freqs = [256][256]int{}
+		var totals [256]int
+		p := prv
+
+		for _, b := range block[startChunk:endChunk] {
+			this.freqs[p][b]++
+			p = b
+		}
+
+		contexts := make([]int, 0, 256)
+
+		for c := range &this.freqs {
+			for _, f := range this.freqs[c] {
+				totals[c] += f
+			}
+
+			if totals[c] != 0 {
+				contexts = append(contexts, c)
+			}
+		}
+
+		nbTables := this.buildTables(contexts, totals[:])
+		this.bitstream.WriteBits(uint64(nbTables-1), 5)
+
+		if nbTables > 1 {
+			// Code table of each context of the chunk
+			sort.Ints(contexts)
+
+			if _, err := EncodeAlphabet(this.bitstream, contexts); err != nil {
+				return 0, err
+			}
+
+			logTables := uint(kanzi.Log2NoCheck(uint32(nbTables-1))) + 1
+
+			for _, c := range contexts {
+				this.bitstream.WriteBits(uint64(this.ctxTables[c]), logTables)
+			}
+		}
+
+		for len(this.tables) < nbTables {
+			he, _ := NewHuffmanEncoder(this.bitstream)
+			this.tables = append(this.tables, he)
+		}
+
+		// Rebuild the Huffman codes of each table
+		for t := 0; t < nbTables; t++ {
+			this.tables[t].bitstream = this.bitstream
+
+			if _, err := this.tables[t].updateFrequencies(this.tableFreqs[t][:]); err != nil {
+				return 0, err
+			}
+		}
+
+		var codes [256]*[256]uint
+
+		for _, c := range contexts {
+			codes[c] = &this.tables[this.ctxTables[c]].codes
+		}
+
+		bs := newBitAccumulator(this.bitstream)
+		endChunk4 := ((endChunk - startChunk) & -4) + startChunk
+
+		for i := startChunk; i < endChunk4; i += 4 {
+			// Pack 4 codes into 1 uint64
+			b := block[i : i+4]
+			code1 := codes[prv][b[0]]
+			codeLen1 := uint(code1 >> 24)
+			code2 := codes[b[0]][b[1]]
+			codeLen2 := uint(code2 >> 24)
+			code3 := codes[b[1]][b[2]]
+			codeLen3 := uint(code3 >> 24)
+			code4 := codes[b[2]][b[3]]
+			codeLen4 := uint(code4 >> 24)
+			st := (uint64(code1&0xFFFF) << (codeLen2 + codeLen3 + codeLen4)) |
+				(uint64(code2&((1<<codeLen2)-1)) << (codeLen3 + codeLen4)) |
+				(uint64(code3&((1<<codeLen3)-1)) << codeLen4) |
+				uint64(code4&((1<<codeLen4)-1))
+			bs.writeBits(st, codeLen1+codeLen2+codeLen3+codeLen4)
+			prv = b[3]
+		}
+
+		for i := endChunk4; i < endChunk; i++ {
+			code := codes[prv][block[i]]
+			bs.writeBits(uint64(code&0xFFFF), code>>24)
+			prv = block[i]
+		}
+
+		// The header of the next chunk goes straight to the bitstream
+		bs.flush()
+
+		startChunk = endChunk
+	}
+
+	return len(block), nil
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The code tables are kept (they are rebuilt for each chunk).
+func (this *HuffmanOrder1Encoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// Dispose this implementation does nothing
+func (this *HuffmanOrder1Encoder) Dispose() {
+}
+
+// BitStream returns the underlying bitstream
+func (this *HuffmanOrder1Encoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// HuffmanOrder1Decoder Implementation of a static order 1 Huffman decoder.
+// Uses one decoding table per code table
+type HuffmanOrder1Decoder struct {
+	bitstream kanzi.InputBitStream
+	tables    []*HuffmanDecoder // one order 0 decoder per code table
+	alphabet  [256]int
+	chunkSize int
+}
+
+// NewHuffmanOrder1Decoder creates an instance of HuffmanOrder1Decoder.
+// Since the number of args is variable, this function can be called like this:
+// NewHuffmanOrder1Decoder(bs) or NewHuffmanOrder1Decoder(bs, 65536) (the second
+// argument being the chunk size)
+func NewHuffmanOrder1Decoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanOrder1Decoder, error) {
+	if bs == nil {
+		return nil, errors.New("Huffman codec: Invalid null bitstream parameter")
+	}
+
+	chkSize, err := getHuffmanOrder1ChunkSize(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(HuffmanOrder1Decoder)
+	this.bitstream = bs
+	this.chunkSize = int(chkSize)
+	this.tables = make([]*HuffmanDecoder, 0, _HUF1_MAX_TABLES)
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The decoding tables are kept (they are rebuilt for each chunk).
+func (this *HuffmanOrder1Decoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
+// readTables decodes the context map and the code lengths of each table
+// from the bitstream and builds the decoding tables.
+func (this *HuffmanOrder1Decoder) readTables(tables *[256]*[_HUF_DECODING_MASK + 1]uint16) error {
+	nbTables := int(this.bitstream.ReadBits(5)) + 1
+	var ctxTables [256]int
+
+	if nbTables > 1 {
+		count, err := DecodeAlphabet(this.bitstream, this.alphabet[:])
+
+		if err != nil {
+			return err
+		}
+
+		logTables := uint(kanzi.Log2NoCheck(uint32(nbTables-1))) + 1
+
+		for _, c := range this.alphabet[0:count] {
+			if c&0xFF != c {
+				return fmt.Errorf("Invalid bitstream: incorrect Huffman context %d", c)
+			}
+
+			t := int(this.bitstream.ReadBits(logTables))
+
+			if t >= nbTables {
+				return fmt.Errorf("Invalid bitstream: incorrect Huffman table %d for context %d", t, c)
+			}
+
+			ctxTables[c] = t
+		}
+	}
+
+	for len(this.tables) < nbTables {
+		hd, _ := NewHuffmanDecoder(this.bitstream)
+		hd.lenLimit = _HUF_MAX_SYMBOL_SIZE // table decoding only
+		this.tables = append(this.tables, hd)
+	}
+
+	for t := 0; t < nbTables; t++ {
+		hd := this.tables[t]
+		hd.bitstream = this.bitstream
+		count, err := hd.readLengths()
+
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return errors.New("Invalid bitstream: empty alphabet in Huffman decoder")
+		}
+	}
+
+	for c := range tables {
+		tables[c] = &this.tables[ctxTables[c]].table
+	}
+
+	return nil
+}
+
+// Read decodes data from the bitstream and return it in the provided buffer.
+// Return the number of bytes read from the bitstream
+func (this *HuffmanOrder1Decoder) Read(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Huffman codec: Invalid null block parameter")
+	}
+
+	if len(block) == 0 {
+		return 0, nil
+	}
+
+	end := len(block)
+	startChunk := 0
+	prv := byte(0)
+	var tables [256]*[_HUF_DECODING_MASK + 1]uint16
+
+	for startChunk < end {
+		// For each chunk, read the tables, rebuild codes, rebuild decoding tables
+		if err := this.readTables(&tables); err != nil {
+			return startChunk, err
+		}
+
+		endChunk := startChunk + this.chunkSize
+
+		if endChunk > end {
+			endChunk = end
+		}
+
+		prv = this.decodeChunk(block[startChunk:endChunk], &tables, prv)
+		startChunk = endChunk
+	}
+
+	return len(block), nil
+}
+
+// decodeChunk decodes the symbols with the decoding table of the previous
+// symbol (like HuffmanDecoder.decodeChunk). Returns the last symbol.
+func (this *HuffmanOrder1Decoder) decodeChunk(block []byte, tables *[256]*[_HUF_DECODING_MASK + 1]uint16, prv byte) byte {
+	for len(block) > 0 {
+		state := this.bitstream.PeekBits(64)
+		used := uint(0)
+		n := 0
+
+		// At least _HUF_DECODING_BATCH_SIZE bits left in 'state' for each symbol
+		for used <= 64-_HUF_DECODING_BATCH_SIZE && n < len(block) {
+			val := tables[prv][(state>>(64-_HUF_DECODING_BATCH_SIZE-used))&_HUF_DECODING_MASK]
+			used += uint(uint8(val))
+			prv = byte(val >> 8)
+			block[n] = prv
+			n++
+		}
+
+		if used > 0 {
+			// Zero when the tables are empty (invalid bitstream)
+			this.bitstream.ReadBits(used)
+		}
+
+		block = block[n:]
+	}
+
+	return prv
+}
+
+// BitStream returns the underlying bitstream
+func (this *HuffmanOrder1Decoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *HuffmanOrder1Decoder) Dispose() {
+}
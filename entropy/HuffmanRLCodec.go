@@ -0,0 +1,346 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Huffman coding of the data where the runs of the most frequent symbol are
+// collapsed: a Huffman code costs at least one bit per symbol, which is far
+// from the entropy of the zero runs left by BWT+MTFT (or RANK). Each chunk
+// starts with a mode bit. In run mode, it contains the run symbol (8 bits),
+// the number of literals (varint) and the Rice parameter (4 bits), then the
+// Huffman coded literals (each run is replaced with one run symbol) and the
+// length-1 of each run, Rice coded (a quotient of _HUFRL_ESCAPE or more is
+// escaped and the value written with _HUFRL_CHUNK_LOG_SIZE bits). Chunks
+// without enough runs are Huffman coded as is.
+
+const (
+	_HUFRL_CHUNK_LOG_SIZE = 16
+	_HUFRL_CHUNK_SIZE     = 1 << _HUFRL_CHUNK_LOG_SIZE
+	_HUFRL_ESCAPE         = 16
+	_HUFRL_MAX_LOG_BASE   = 15
+)
+
+// HuffmanRLEncoder Huffman encoder coding the runs of the most frequent
+// symbol of each chunk separately (Rice coded lengths)
+type HuffmanRLEncoder struct {
+	bitstream kanzi.OutputBitStream
+	huffman   *HuffmanEncoder
+	literals  []byte
+	runs      []int
+}
+
+// NewHuffmanRLEncoder creates an instance of HuffmanRLEncoder
+func NewHuffmanRLEncoder(bs kanzi.OutputBitStream) (*HuffmanRLEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Huffman RL codec: Invalid null bitstream parameter")
+	}
+
+	huffman, err := NewHuffmanEncoder(bs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(HuffmanRLEncoder)
+	this.bitstream = bs
+	this.huffman = huffman
+	this.literals = make([]byte, 0)
+	this.runs = make([]int, 0)
+	return this, nil
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The buffers and the Huffman tables are kept.
+func (this *HuffmanRLEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return this.huffman.reset(bs, ctx)
+}
+
+// Write encodes the data provided into the bitstream. Return the number of byte
+// written to the bitstream.
+func (this *HuffmanRLEncoder) Write(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Huffman RL codec: Invalid null block parameter")
+	}
+
+	for startChunk := 0; startChunk < len(block); startChunk += _HUFRL_CHUNK_SIZE {
+		endChunk := startChunk + _HUFRL_CHUNK_SIZE
+
+		if endChunk > len(block) {
+			endChunk = len(block)
+		}
+
+		if err := this.encodeChunk(block[startChunk:endChunk]); err != nil {
+			return startChunk, err
+		}
+	}
+
+	return len(block), nil
+}
+
+func (this *HuffmanRLEncoder) encodeChunk(block []byte) error {
+	var freqs [256]int
+	kanzi.ComputeHistogram(block, freqs[:], true, false)
+	sym := 0
+
+	for i := range freqs {
+		if freqs[i] > freqs[sym] {
+			sym = i
+		}
+	}
+
+	// Collapse the runs of the symbol
+	if cap(this.literals) < len(block) {
+		this.literals = make([]byte, len(block))
+	}
+
+	literals := this.literals[0:0]
+	runs := this.runs[0:0]
+	run := 0
+
+	for _, b := range block {
+		if int(b) == sym {
+			run++
+			continue
+		}
+
+		if run > 0 {
+			literals = append(literals, byte(sym))
+			runs = append(runs, run-1)
+			run = 0
+		}
+
+		literals = append(literals, b)
+	}
+
+	if run > 0 {
+		literals = append(literals, byte(sym))
+		runs = append(runs, run-1)
+	}
+
+	this.runs = runs
+	logBase, runBits := computeRunLogBase(runs)
+
+	// Each symbol removed from the literals saves at least one bit
+	if runBits >= len(block)-len(literals) {
+		this.bitstream.WriteBit(0)
+		_, err := this.huffman.Write(block)
+		return err
+	}
+
+	this.bitstream.WriteBit(1)
+	this.bitstream.WriteBits(uint64(sym), 8)
+	WriteVarInt(this.bitstream, uint32(len(literals)))
+	this.bitstream.WriteBits(uint64(logBase), 4)
+
+	if _, err := this.huffman.Write(literals); err != nil {
+		return err
+	}
+
+	base := uint64(1) << logBase
+
+	for _, v := range runs {
+		q := uint(v >> logBase)
+
+		if q >= _HUFRL_ESCAPE {
+			this.bitstream.WriteBits(0, _HUFRL_ESCAPE)
+			this.bitstream.WriteBits(uint64(v), _HUFRL_CHUNK_LOG_SIZE)
+			continue
+		}
+
+		// q zeros, then 1 and the remainder
+		this.bitstream.WriteBits(base|uint64(v)&(base-1), q+logBase+1)
+	}
+
+	return nil
+}
+
+// computeRunLogBase returns the Rice parameter minimizing the size of the
+// run lengths and this size in bits
+func computeRunLogBase(runs []int) (uint, int) {
+	bestLogBase := uint(0)
+	bestBits := -1
+
+	for logBase := uint(0); logBase <= _HUFRL_MAX_LOG_BASE; logBase++ {
+		bits := 0
+
+		for _, v := range runs {
+			if q := v >> logBase; q >= _HUFRL_ESCAPE {
+				bits += _HUFRL_ESCAPE + _HUFRL_CHUNK_LOG_SIZE
+			} else {
+				bits += q + int(logBase) + 1
+			}
+		}
+
+		if bestBits < 0 || bits < bestBits {
+			bestBits = bits
+			bestLogBase = logBase
+		}
+	}
+
+	return bestLogBase, bestBits
+}
+
+// BitStream returns the underlying bitstream
+func (this *HuffmanRLEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *HuffmanRLEncoder) Dispose() {
+}
+
+// HuffmanRLDecoder decoder of the data coded by HuffmanRLEncoder
+type HuffmanRLDecoder struct {
+	bitstream kanzi.InputBitStream
+	huffman   *HuffmanDecoder
+	literals  []byte
+}
+
+// NewHuffmanRLDecoder creates an instance of HuffmanRLDecoder
+func NewHuffmanRLDecoder(bs kanzi.InputBitStream) (*HuffmanRLDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Huffman RL codec: Invalid null bitstream parameter")
+	}
+
+	huffman, err := NewHuffmanDecoder(bs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(HuffmanRLDecoder)
+	this.bitstream = bs
+	this.huffman = huffman
+	this.literals = make([]byte, 0)
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The buffers and the Huffman tables are kept.
+func (this *HuffmanRLDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return this.huffman.reset(bs, ctx)
+}
+
+// Read decodes data from the bitstream and return it in the provided buffer.
+// Return the number of bytes read from the bitstream.
+func (this *HuffmanRLDecoder) Read(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Huffman RL codec: Invalid null block parameter")
+	}
+
+	for startChunk := 0; startChunk < len(block); startChunk += _HUFRL_CHUNK_SIZE {
+		endChunk := startChunk + _HUFRL_CHUNK_SIZE
+
+		if endChunk > len(block) {
+			endChunk = len(block)
+		}
+
+		if err := this.decodeChunk(block[startChunk:endChunk]); err != nil {
+			return startChunk, err
+		}
+	}
+
+	return len(block), nil
+}
+
+func (this *HuffmanRLDecoder) decodeChunk(block []byte) error {
+	if this.bitstream.ReadBit() == 0 {
+		_, err := this.huffman.Read(block)
+		return err
+	}
+
+	sym := byte(this.bitstream.ReadBits(8))
+	count := int(ReadVarInt(this.bitstream))
+	logBase := uint(this.bitstream.ReadBits(4))
+
+	if count == 0 || count > len(block) {
+		return fmt.Errorf("Invalid bitstream: incorrect number of literals %v in Huffman RL decoder", count)
+	}
+
+	if cap(this.literals) < count {
+		this.literals = make([]byte, count)
+	}
+
+	literals := this.literals[0:count]
+
+	if _, err := this.huffman.Read(literals); err != nil {
+		return err
+	}
+
+	// Expand the runs
+	n := 0
+
+	for _, b := range literals {
+		if b != sym {
+			if n >= len(block) {
+				return errors.New("Invalid bitstream: too many symbols in Huffman RL decoder")
+			}
+
+			block[n] = b
+			n++
+			continue
+		}
+
+		q := uint(0)
+
+		for q < _HUFRL_ESCAPE && this.bitstream.ReadBit() == 0 {
+			q++
+		}
+
+		var run int
+
+		if q == _HUFRL_ESCAPE {
+			run = int(this.bitstream.ReadBits(_HUFRL_CHUNK_LOG_SIZE)) + 1
+		} else {
+			run = int(q<<logBase) + 1
+
+			if logBase > 0 {
+				run += int(this.bitstream.ReadBits(logBase))
+			}
+		}
+
+		if run > len(block)-n {
+			return fmt.Errorf("Invalid bitstream: incorrect run length %v in Huffman RL decoder", run)
+		}
+
+		for end := n + run; n < end; n++ {
+			block[n] = sym
+		}
+	}
+
+	if n != len(block) {
+		return fmt.Errorf("Invalid bitstream: %v symbols decoded instead of %v in Huffman RL decoder", n, len(block))
+	}
+
+	return nil
+}
+
+// BitStream returns the underlying bitstream
+func (this *HuffmanRLDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *HuffmanRLDecoder) Dispose() {
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+// The LZ transform (see function.LZXCodec) interleaves the tokens, the
+// literals, the length extensions and the match distances in one byte stream.
+// LZModelPredictor parses this stream as the bytes are coded (the decoder
+// parses the bytes already decoded the same way) and predicts each byte with
+// the model of its field: literals, tokens, lengths and distance bytes no
+// longer share their statistics. Data from other transforms is coded
+// correctly, with a worse ratio.
+
+// Fields of the LZ stream
+const (
+	_LZM_MODE    = 0 // first byte of the block: window
+	_LZM_TOKEN   = 1
+	_LZM_LIT_LEN = 2 // literal length extension
+	_LZM_LITERAL = 3
+	_LZM_MAT_LEN = 4 // match length extension
+	_LZM_DIST    = 5
+)
+
+// Models (256 bit contexts each)
+const (
+	_LZM_LITERAL_MODELS = 0   // by previous literal
+	_LZM_TOKEN_MODELS   = 256 // by previous token
+	_LZM_LIT_LEN_MODEL  = 512
+	_LZM_MAT_LEN_MODEL  = 513
+	_LZM_DIST_MODELS    = 514 // by index of the distance byte (4 bytes max)
+	_LZM_MODE_MODEL     = 518
+	_LZM_MODELS         = 519
+)
+
+// LZModelPredictor order 0/1 predictor using a model per field of the stream
+// written by the LZ transform
+type LZModelPredictor struct {
+	fast      []uint16 // fast adapting probabilities of bit=1
+	slow      []uint16 // slow adapting probabilities of bit=1
+	ctx       int      // previous bits of the current byte (with a leading 1)
+	base      int      // first context of the model of the current byte
+	field     int      // field of the current byte
+	token     int      // last token
+	litLeft   int      // literals left in the current run
+	distLeft  int      // distance bytes left
+	distBytes int      // extra distance bytes of long distances (0, 1 or 2)
+	prevLit   int
+	pr        int
+}
+
+// NewLZModelPredictor creates a new instance of LZModelPredictor
+func NewLZModelPredictor() (*LZModelPredictor, error) {
+	this := new(LZModelPredictor)
+	err := this.reset(nil)
+	return this, err
+}
+
+// reset re-initializes the models for a new block
+func (this *LZModelPredictor) reset(ctx *map[string]interface{}) error {
+	if this.fast == nil {
+		this.fast = make([]uint16, _LZM_MODELS*256)
+		this.slow = make([]uint16, _LZM_MODELS*256)
+	}
+
+	for i := range this.fast {
+		this.fast[i] = 1 << 15
+		this.slow[i] = 1 << 15
+	}
+
+	this.ctx = 1
+	this.field = _LZM_MODE
+	this.base = _LZM_MODE_MODEL << 8
+	this.token = 0
+	this.litLeft = 0
+	this.distLeft = 0
+	this.distBytes = 0
+	this.prevLit = 0
+	this.pr = 2048
+	return nil
+}
+
+// Update updates the probability model based on the observed bit
+func (this *LZModelPredictor) Update(bit byte) {
+	idx := this.base + this.ctx
+
+	if bit == 0 {
+		this.fast[idx] -= this.fast[idx] >> 4
+		this.slow[idx] -= this.slow[idx] >> 7
+	} else {
+		this.fast[idx] += (0xFFFF - this.fast[idx]) >> 4
+		this.slow[idx] += (0xFFFF - this.slow[idx]) >> 7
+	}
+
+	this.ctx += this.ctx + int(bit)
+
+	if this.ctx > 255 {
+		this.next(this.ctx & 0xFF)
+		this.ctx = 1
+	}
+
+	idx = this.base + this.ctx
+	this.pr = (int(this.fast[idx]) + int(this.slow[idx])) >> 5
+}
+
+// next finds the field of the byte following 'b' (same parsing as
+// function.LZXCodec.Inverse)
+func (this *LZModelPredictor) next(b int) {
+	switch this.field {
+	case _LZM_MODE:
+		// See function.LZXCodec for the window encoding
+		if b == 0 {
+			this.distBytes = 0
+		} else if b&0x80 != 0 && b&0x7F > 24 {
+			this.distBytes = 2
+		} else {
+			this.distBytes = 1
+		}
+
+		this.field = _LZM_TOKEN
+
+	case _LZM_TOKEN:
+		this.token = b
+
+		if litLen := b >> 5; litLen == 7 {
+			this.litLeft = 7
+			this.field = _LZM_LIT_LEN
+		} else if litLen > 0 {
+			this.litLeft = litLen
+			this.field = _LZM_LITERAL
+		} else {
+			this.startMatch()
+		}
+
+	case _LZM_LIT_LEN:
+		this.litLeft += b
+
+		if b != 0xFF {
+			this.field = _LZM_LITERAL
+		}
+
+	case _LZM_LITERAL:
+		this.prevLit = b
+		this.litLeft--
+
+		if this.litLeft == 0 {
+			this.startMatch()
+		}
+
+	case _LZM_MAT_LEN:
+		if b != 0xFF {
+			this.startDistance()
+		}
+
+	case _LZM_DIST:
+		this.distLeft--
+
+		if this.distLeft == 0 {
+			this.field = _LZM_TOKEN
+		}
+	}
+
+	// Select the model of the next byte
+	switch this.field {
+	case _LZM_TOKEN:
+		this.base = (_LZM_TOKEN_MODELS + this.token) << 8
+
+	case _LZM_LIT_LEN:
+		this.base = _LZM_LIT_LEN_MODEL << 8
+
+	case _LZM_LITERAL:
+		this.base = (_LZM_LITERAL_MODELS + this.prevLit) << 8
+
+	case _LZM_MAT_LEN:
+		this.base = _LZM_MAT_LEN_MODEL << 8
+
+	case _LZM_DIST:
+		this.base = (_LZM_DIST_MODELS + this.distLeft - 1) << 8
+	}
+}
+
+func (this *LZModelPredictor) startMatch() {
+	if this.token&0x0F == 0x0F {
+		this.field = _LZM_MAT_LEN
+	} else {
+		this.startDistance()
+	}
+}
+
+func (this *LZModelPredictor) startDistance() {
+	this.field = _LZM_DIST
+	this.distLeft = 2
+
+	if this.token&0x10 != 0 {
+		this.distLeft += this.distBytes
+	}
+}
+
+// Get returns the value representing the probability of the next bit being 1
+// in the [0..4095] range.
+func (this *LZModelPredictor) Get() int {
+	return this.pr
+}
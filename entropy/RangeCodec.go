@@ -46,6 +46,7 @@ type RangeEncoder struct {
 	chunkSize uint
 	logRange  uint
 	shift     uint
+	fixed     bool // frequencies of a FrequencyTable, not transmitted
 }
 
 // NewRangeEncoder creates a new instance of RangeEncoder
@@ -92,6 +93,50 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 	return this, nil
 }
 
+// NewRangeEncoderWithTable creates a new instance of RangeEncoder encoding
+// all the chunks with the frequencies of the table. The frequencies are not
+// written to the bitstream: the decoder must be created with the same table
+// (see NewRangeDecoderWithTable). The optional argument is the chunk size.
+func NewRangeEncoderWithTable(bs kanzi.OutputBitStream, table *FrequencyTable, args ...uint) (*RangeEncoder, error) {
+	if table == nil {
+		return nil, errors.New("Range codec: Invalid null frequency table parameter")
+	}
+
+	if len(args) > 1 {
+		return nil, errors.New("Range codec: At most one chunk size can be provided")
+	}
+
+	chkSize := _DEFAULT_RANGE_CHUNK_SIZE
+
+	if len(args) == 1 {
+		chkSize = args[0]
+	}
+
+	this, err := NewRangeEncoder(bs, chkSize, table.logRange)
+
+	if err != nil {
+		return nil, err
+	}
+
+	copy(this.freqs[:], table.frequencies[:])
+	this.cumFreqs[0] = 0
+
+	for i := range this.freqs {
+		this.cumFreqs[i+1] = this.cumFreqs[i] + uint64(this.freqs[i])
+	}
+
+	this.shift = table.logRange
+	this.fixed = true
+	return this, nil
+}
+
+// reset prepares the encoder to encode a new block into the bitstream.
+// The frequency tables are kept (they are computed for each chunk).
+func (this *RangeEncoder) reset(bs kanzi.OutputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
 func (this *RangeEncoder) updateFrequencies(frequencies []int, size int, lr uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
 		return 0, errors.New("Range codec: Invalid frequencies parameter")
@@ -202,6 +247,15 @@ func (this *RangeEncoder) Write(block []byte) (int, error) {
 			endChunk = end
 		}
 
+		if this.fixed == true {
+			if err := this.encodeChunkWithTable(block[startChunk:endChunk]); err != nil {
+				return startChunk, err
+			}
+
+			startChunk = endChunk
+			continue
+		}
+
 		// Lower log range if the size of the data block is small
 		for lr > 8 && 1<<lr > endChunk-startChunk {
 			lr--
@@ -226,6 +280,21 @@ func (this *RangeEncoder) Write(block []byte) (int, error) {
 	return len(block), nil
 }
 
+// Encode the chunk with the fixed frequencies (no header)
+func (this *RangeEncoder) encodeChunkWithTable(block []byte) error {
+	for i := range block {
+		if this.freqs[block[i]] == 0 {
+			return fmt.Errorf("Range codec: Symbol '%v' has a null frequency in the table", block[i])
+		}
+
+		this.encodeByte(block[i])
+	}
+
+	// Flush 'low'
+	this.bitstream.WriteBits(this.low, 60)
+	return nil
+}
+
 // Compute chunk frequencies, cumulated frequencies and encode chunk header
 func (this *RangeEncoder) rebuildStatistics(block []byte, lr uint) error {
 	kanzi.ComputeHistogram(block, this.freqs[:], true, false)
@@ -280,6 +349,7 @@ type RangeDecoder struct {
 	bitstream kanzi.InputBitStream
 	chunkSize uint
 	shift     uint
+	fixed     bool // frequencies of a FrequencyTable, not transmitted
 }
 
 // NewRangeDecoder creates a new instance of RangeDecoder
@@ -319,6 +389,34 @@ func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, erro
 	return this, nil
 }
 
+// NewRangeDecoderWithTable creates a new instance of RangeDecoder decoding
+// all the chunks with the frequencies of the table (the table used by the
+// encoder). The optional argument is the chunk size.
+func NewRangeDecoderWithTable(bs kanzi.InputBitStream, table *FrequencyTable, args ...uint) (*RangeDecoder, error) {
+	if table == nil {
+		return nil, errors.New("Range codec: Invalid null frequency table parameter")
+	}
+
+	this, err := NewRangeDecoder(bs, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	copy(this.freqs[:], table.frequencies[:])
+	this.buildTables(this.freqs[:], 1<<table.logRange)
+	this.shift = table.logRange
+	this.fixed = true
+	return this, nil
+}
+
+// reset prepares the decoder to decode a new block from the bitstream.
+// The frequency tables are kept (they are decoded for each chunk).
+func (this *RangeDecoder) reset(bs kanzi.InputBitStream, ctx *map[string]interface{}) error {
+	this.bitstream = bs
+	return nil
+}
+
 func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 	alphabetSize, err := DecodeAlphabet(this.bitstream, this.alphabet[:])
 
@@ -389,6 +487,12 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 	}
 
 	frequencies[this.alphabet[0]] = scale - sum
+	this.buildTables(frequencies, scale)
+	return alphabetSize, nil
+}
+
+// buildTables computes the cumulated frequencies and the reverse mapping
+func (this *RangeDecoder) buildTables(frequencies []int, scale int) {
 	this.cumFreqs[0] = 0
 
 	if len(this.f2s) < scale {
@@ -404,8 +508,6 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 			this.f2s[base+j] = uint16(i)
 		}
 	}
-
-	return alphabetSize, nil
 }
 
 // Read decodes data from the bitstream and return it in the provided buffer.
@@ -421,10 +523,12 @@ func (this *RangeDecoder) Read(block []byte) (int, error) {
 	sizeChunk := int(this.chunkSize)
 
 	for startChunk < end {
-		alphabetSize, err := this.decodeHeader(this.freqs[:])
+		if this.fixed == false {
+			alphabetSize, err := this.decodeHeader(this.freqs[:])
 
-		if err != nil || alphabetSize == 0 {
-			return startChunk, err
+			if err != nil || alphabetSize == 0 {
+				return startChunk, err
+			}
 		}
 
 		this.rng = _TOP_RANGE
@@ -22,9 +22,15 @@ import (
 	kanzi "github.com/flanglet/kanzi-go"
 )
 
+const (
+	_RICE_MAX_LOG_BASE  = 12
+	_RICE_LOG_BASE_BITS = 4 // size of the block header in adaptive mode
+)
+
 // RiceGolombEncoder a Rice Golomb Entropy Encoder
 type RiceGolombEncoder struct {
 	signed    bool
+	adaptive  bool
 	logBase   uint
 	base      uint64
 	bitstream kanzi.OutputBitStream
@@ -34,17 +40,20 @@ type RiceGolombEncoder struct {
 // If sgn is true, values will be encoded as signed (int8) in the bitstream.
 // Using a sign improves compression ratio for distributions centered on 0 (E.G. Gaussian)
 // Example: -1 is better compressed as -1 (1 followed by '-') than as 255
+// If logBase is 0, the encoder is adaptive: the best value of logBase (in
+// [0..12]) is computed for each block and written before the block.
 func NewRiceGolombEncoder(bs kanzi.OutputBitStream, sgn bool, logBase uint) (*RiceGolombEncoder, error) {
 	if bs == nil {
 		return nil, errors.New("RiceGolomb codec: Invalid null bitstream parameter")
 	}
 
-	if logBase < 1 || logBase > 12 {
-		return nil, fmt.Errorf("RiceGolomb codec: Invalid logBase '%v' value (must be in [1..12])", logBase)
+	if logBase > _RICE_MAX_LOG_BASE {
+		return nil, fmt.Errorf("RiceGolomb codec: Invalid logBase '%v' value (must be in [0..12])", logBase)
 	}
 
 	this := new(RiceGolombEncoder)
 	this.signed = sgn
+	this.adaptive = logBase == 0
 	this.bitstream = bs
 	this.logBase = logBase
 	this.base = uint64(1 << logBase)
@@ -76,7 +85,15 @@ func (this *RiceGolombEncoder) EncodeByte(val byte) {
 	}
 
 	// quotient is unary encoded, remainder is binary encoded
-	n := uint(emit>>this.logBase) + this.logBase + 1
+	q := uint(emit >> this.logBase)
+
+	// Long quotients (small logBase) do not fit in one write
+	for q >= 32 {
+		this.bitstream.WriteBits(0, 32)
+		q -= 32
+	}
+
+	n := q + this.logBase + 1
 	emit = this.base | (emit & (this.base - 1))
 
 	if this.signed == true {
@@ -97,6 +114,12 @@ func (this *RiceGolombEncoder) BitStream() kanzi.OutputBitStream {
 // Write encodes the data provided into the bitstream. Return the number of byte
 // written to the bitstream
 func (this *RiceGolombEncoder) Write(block []byte) (int, error) {
+	if this.adaptive == true && len(block) > 0 {
+		this.logBase = computeRiceLogBase(block, this.signed)
+		this.base = uint64(1 << this.logBase)
+		this.bitstream.WriteBits(uint64(this.logBase), _RICE_LOG_BASE_BITS)
+	}
+
 	for i := range block {
 		this.EncodeByte(block[i])
 	}
@@ -104,26 +127,68 @@ func (this *RiceGolombEncoder) Write(block []byte) (int, error) {
 	return len(block), nil
 }
 
-// RiceGolombDecoder Exponential Golomb Entropy Decoder
+// computeRiceLogBase returns the value of logBase minimizing the size of
+// the encoded block
+func computeRiceLogBase(block []byte, sgn bool) uint {
+	var freqs [256]int
+	kanzi.ComputeHistogram(block, freqs[:], true, false)
+	var magnitudes [256]int
+	zeros := freqs[0]
+
+	for i := range freqs {
+		if sgn == true && i&0x80 != 0 {
+			magnitudes[-byte(i)] += freqs[i]
+		} else {
+			magnitudes[i] += freqs[i]
+		}
+	}
+
+	best := uint(0)
+	bestCost := -1
+
+	for k := uint(0); k <= _RICE_MAX_LOG_BASE; k++ {
+		cost := 0
+
+		for m, f := range magnitudes {
+			cost += f * ((m >> k) + int(k) + 1)
+		}
+
+		if sgn == true {
+			cost += len(block) - zeros
+		}
+
+		if bestCost < 0 || cost < bestCost {
+			best = k
+			bestCost = cost
+		}
+	}
+
+	return best
+}
+
+// RiceGolombDecoder Rice Golomb Entropy Decoder
 type RiceGolombDecoder struct {
 	signed    bool
+	adaptive  bool
 	logBase   uint
 	bitstream kanzi.InputBitStream
 }
 
-// NewRiceGolombDecoder creates a new instance of ExpGolombDecoder
+// NewRiceGolombDecoder creates a new instance of RiceGolombDecoder
 // If sgn is true, values from the bitstream will be decoded as signed (int8)
+// If logBase is 0, the decoder is adaptive: logBase is read before each block.
 func NewRiceGolombDecoder(bs kanzi.InputBitStream, sgn bool, logBase uint) (*RiceGolombDecoder, error) {
 	if bs == nil {
 		return nil, errors.New("RiceGolomb codec: Invalid null bitstream parameter")
 	}
 
-	if logBase < 1 || logBase > 12 {
-		return nil, errors.New("RiceGolomb codec: Invalid logBase value (must be in [1..12])")
+	if logBase > _RICE_MAX_LOG_BASE {
+		return nil, errors.New("RiceGolomb codec: Invalid logBase value (must be in [0..12])")
 	}
 
 	this := new(RiceGolombDecoder)
 	this.signed = sgn
+	this.adaptive = logBase == 0
 	this.bitstream = bs
 	this.logBase = logBase
 	return this, nil
@@ -149,7 +214,11 @@ func (this *RiceGolombDecoder) DecodeByte() byte {
 	}
 
 	// remainder is binary encoded
-	res := byte((q << this.logBase) | int(this.bitstream.ReadBits(this.logBase)))
+	res := byte(q << this.logBase)
+
+	if this.logBase > 0 {
+		res |= byte(this.bitstream.ReadBits(this.logBase))
+	}
 
 	if this.signed == true && res != 0 {
 		// If res != 0, Get the 'sign', encoded as 1 for negative values
@@ -169,6 +238,14 @@ func (this *RiceGolombDecoder) BitStream() kanzi.InputBitStream {
 // Read decodes data from the bitstream and return it in the provided buffer.
 // Return the number of bytes read from the bitstream
 func (this *RiceGolombDecoder) Read(block []byte) (int, error) {
+	if this.adaptive == true && len(block) > 0 {
+		this.logBase = uint(this.bitstream.ReadBits(_RICE_LOG_BASE_BITS))
+
+		if this.logBase > _RICE_MAX_LOG_BASE {
+			return 0, fmt.Errorf("Invalid bitstream: incorrect Rice Golomb logBase: %v", this.logBase)
+		}
+	}
+
 	for i := range block {
 		block[i] = this.DecodeByte()
 	}
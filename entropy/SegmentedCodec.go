@@ -0,0 +1,387 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// Segmented entropy coding: a block is split into segments of the same size
+// (the last one takes the remainder), each coded by its own entropy codec, so
+// that the segments of one block are encoded and decoded concurrently (up to
+// "jobs" goroutines). Each segment starts with fresh statistics.
+// Layout: number of segments - 1 (6 bits), then the size in bytes of each
+// segment (varints) and the segments (each padded to a byte boundary).
+// A block with a single segment is coded in place, without sizes.
+
+const (
+	// MAX_ENTROPY_SEGMENTS is the max number of segments of a block
+	MAX_ENTROPY_SEGMENTS = 64
+
+	// MIN_ENTROPY_SEGMENT_SIZE is the min size of a segment: smaller blocks
+	// are split in fewer segments
+	MIN_ENTROPY_SEGMENT_SIZE = 64 * 1024
+)
+
+// SegmentedEncoder entropy encoder splitting the blocks into segments coded
+// concurrently with the entropy codec of the given type
+type SegmentedEncoder struct {
+	bitstream   kanzi.OutputBitStream
+	ctx         map[string]interface{}
+	entropyType uint32
+	segments    int
+	jobs        int
+}
+
+// NewSegmentedEncoder creates an instance of SegmentedEncoder splitting each
+// block into at most 'segments' segments (in [1..MAX_ENTROPY_SEGMENTS]).
+// The number of concurrent jobs is read from the context ("jobs").
+func NewSegmentedEncoder(bs kanzi.OutputBitStream, ctx map[string]interface{},
+	entropyType uint32, segments uint) (*SegmentedEncoder, error) {
+	if bs == nil {
+		return nil, errors.New("Segmented codec: Invalid null bitstream parameter")
+	}
+
+	if segments < 1 || segments > MAX_ENTROPY_SEGMENTS {
+		return nil, fmt.Errorf("Segmented codec: Invalid number of segments: %v (must be in [1..%d])",
+			segments, MAX_ENTROPY_SEGMENTS)
+	}
+
+	this := new(SegmentedEncoder)
+	this.bitstream = bs
+	this.ctx = ctx
+	this.entropyType = entropyType
+	this.segments = int(segments)
+	this.jobs = getJobs(ctx)
+	return this, nil
+}
+
+// Write encodes the data provided into the bitstream. Return the number of byte
+// written to the bitstream.
+func (this *SegmentedEncoder) Write(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	n := countSegments(len(block), this.segments)
+	this.bitstream.WriteBits(uint64(n-1), 6)
+
+	if n == 1 {
+		return encodeSegment(this.bitstream, this.ctx, this.entropyType, block)
+	}
+
+	outputs := make([][]byte, n)
+	errs := make([]error, n)
+
+	runSegments(n, this.jobs, func(i int) {
+		start, end := segmentBounds(len(block), n, i)
+		outputs[i], errs[i] = encodeSegmentToBuffer(this.ctx, this.entropyType, block[start:end])
+	})
+
+	for i := range errs {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+	}
+
+	for i := range outputs {
+		WriteVarInt(this.bitstream, uint32(len(outputs[i])))
+	}
+
+	for i := range outputs {
+		this.bitstream.WriteArray(outputs[i], uint(8*len(outputs[i])))
+	}
+
+	return len(block), nil
+}
+
+// encodeSegmentToBuffer encodes a segment with its own codec and bitstream.
+// Return the encoded bytes.
+func encodeSegmentToBuffer(ctx map[string]interface{}, entropyType uint32, block []byte) (res []byte, err error) {
+	// A failing codec must not crash the other goroutines
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("Segmented codec: cannot encode segment: %v", r)
+		}
+	}()
+
+	// The bitstream writes to buf directly (up to its capacity)
+	buf := make([]byte, MaxEncodedLen(entropyType, len(block)))
+	obs, err := bitstream.NewDefaultOutputBitStream(util.NewBufferStream(buf[0:0:len(buf)]), 16384)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = encodeSegment(obs, copyCtx(ctx, len(block)), entropyType, block); err != nil {
+		return nil, err
+	}
+
+	obs.Close()
+	written := int((obs.Written() + 7) >> 3)
+
+	if written > len(buf) {
+		return nil, fmt.Errorf("Segmented codec: segment too large - size: %d, max: %d", written, len(buf))
+	}
+
+	return buf[0:written], nil
+}
+
+func encodeSegment(obs kanzi.OutputBitStream, ctx map[string]interface{}, entropyType uint32, block []byte) (int, error) {
+	ee, err := NewEntropyEncoder(obs, ctx, entropyType)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := ee.Write(block)
+
+	// Dispose may write to the bitstream
+	ee.Dispose()
+	return n, err
+}
+
+// BitStream returns the underlying bitstream
+func (this *SegmentedEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *SegmentedEncoder) Dispose() {
+}
+
+// SegmentedDecoder entropy decoder of the blocks written by a SegmentedEncoder
+type SegmentedDecoder struct {
+	bitstream   kanzi.InputBitStream
+	ctx         map[string]interface{}
+	entropyType uint32
+	jobs        int
+}
+
+// NewSegmentedDecoder creates an instance of SegmentedDecoder. The number of
+// segments is read from the bitstream and the number of concurrent jobs from
+// the context ("jobs").
+func NewSegmentedDecoder(bs kanzi.InputBitStream, ctx map[string]interface{},
+	entropyType uint32) (*SegmentedDecoder, error) {
+	if bs == nil {
+		return nil, errors.New("Segmented codec: Invalid null bitstream parameter")
+	}
+
+	this := new(SegmentedDecoder)
+	this.bitstream = bs
+	this.ctx = ctx
+	this.entropyType = entropyType
+	this.jobs = getJobs(ctx)
+	return this, nil
+}
+
+// Read decodes data from the bitstream and return it in the provided buffer.
+// Return the number of bytes read from the bitstream.
+func (this *SegmentedDecoder) Read(block []byte) (int, error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	n := int(this.bitstream.ReadBits(6)) + 1
+
+	if n == 1 {
+		return decodeSegment(this.bitstream, this.ctx, this.entropyType, block)
+	}
+
+	if n > len(block) {
+		return 0, fmt.Errorf("Invalid bitstream: incorrect number of segments: %d", n)
+	}
+
+	inputs := make([][]byte, n)
+
+	for i := range inputs {
+		start, end := segmentBounds(len(block), n, i)
+
+		// Bound the allocation on corrupted sizes
+		size := int(ReadVarInt(this.bitstream))
+
+		if size > MaxEncodedLen(this.entropyType, end-start) {
+			return 0, fmt.Errorf("Invalid bitstream: incorrect segment size: %d", size)
+		}
+
+		inputs[i] = make([]byte, size)
+	}
+
+	for i := range inputs {
+		this.bitstream.ReadArray(inputs[i], uint(8*len(inputs[i])))
+	}
+
+	errs := make([]error, n)
+
+	runSegments(n, this.jobs, func(i int) {
+		start, end := segmentBounds(len(block), n, i)
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(inputs[i]), 16384)
+		_, errs[i] = decodeSegment(ibs, copyCtx(this.ctx, end-start), this.entropyType, block[start:end])
+		ibs.Close()
+	})
+
+	for i := range errs {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+	}
+
+	return len(block), nil
+}
+
+func decodeSegment(ibs kanzi.InputBitStream, ctx map[string]interface{}, entropyType uint32, block []byte) (n int, err error) {
+	// A corrupted segment must not crash the other goroutines
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = 0, fmt.Errorf("Invalid bitstream: cannot decode segment: %v", r)
+		}
+	}()
+
+	ed, err := NewEntropyDecoder(ibs, ctx, entropyType)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer ed.Dispose()
+	return ed.Read(block)
+}
+
+// BitStream returns the underlying bitstream
+func (this *SegmentedDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+// Dispose this implementation does nothing
+func (this *SegmentedDecoder) Dispose() {
+}
+
+// MaxSegmentedEncodedLen returns the max number of bytes written by a
+// SegmentedEncoder using the given entropy type and max number of segments
+// for a block of 'srcLen' bytes.
+func MaxSegmentedEncodedLen(entropyType uint32, srcLen int, segments int) int {
+	n := countSegments(srcLen, segments)
+
+	if n == 1 {
+		return 1 + MaxEncodedLen(entropyType, srcLen)
+	}
+
+	// Number of segments and sizes (5 bytes per varint at most)
+	res := 1 + 5*n
+
+	for i := 0; i < n; i++ {
+		start, end := segmentBounds(srcLen, n, i)
+		res += MaxEncodedLen(entropyType, end-start)
+	}
+
+	return res
+}
+
+// countSegments returns the number of segments of a block
+func countSegments(length, segments int) int {
+	n := length / MIN_ENTROPY_SEGMENT_SIZE
+
+	if n > segments {
+		n = segments
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// segmentBounds returns the start and end offsets of the i-th of n segments
+// in a block
+func segmentBounds(length, n, i int) (int, int) {
+	size := length / n
+	start := i * size
+
+	if i == n-1 {
+		return start, length
+	}
+
+	return start, start + size
+}
+
+// runSegments calls fn for each segment using at most 'jobs' goroutines
+func runSegments(n, jobs int, fn func(i int)) {
+	if jobs > n {
+		jobs = n
+	}
+
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+
+		return
+	}
+
+	var wg sync.WaitGroup
+	next := make(chan int, n)
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+
+	close(next)
+
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// copyCtx returns a copy of the context for the codec of a segment (the
+// codecs may update their context concurrently). The codec tables are sized
+// for the segment.
+func copyCtx(ctx map[string]interface{}, size int) map[string]interface{} {
+	res := make(map[string]interface{}, len(ctx)+2)
+
+	for k, v := range ctx {
+		res[k] = v
+	}
+
+	res["size"] = uint(size)
+	res["blockSize"] = uint(size)
+	res["jobs"] = uint(1)
+	return res
+}
+
+func getJobs(ctx map[string]interface{}) int {
+	if val, containsKey := ctx["jobs"]; containsKey {
+		return int(val.(uint))
+	}
+
+	return 1
+}
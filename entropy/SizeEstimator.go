@@ -0,0 +1,149 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// countingBitStream is an OutputBitStream that only counts the bits written
+type countingBitStream struct {
+	written uint64
+	closed  bool
+}
+
+// WriteBit counts one bit
+func (this *countingBitStream) WriteBit(bit int) {
+	this.WriteBits(uint64(bit), 1)
+}
+
+// WriteBits counts 'length' bits. Returns the number of bits counted.
+func (this *countingBitStream) WriteBits(bits uint64, length uint) uint {
+	if this.closed == true {
+		panic(errors.New("Stream closed"))
+	}
+
+	if length == 0 || length > 64 {
+		panic(fmt.Errorf("Invalid bit count: %d (must be in [1..64])", length))
+	}
+
+	this.written += uint64(length)
+	return length
+}
+
+// WriteArray counts 'length' bits. Returns the number of bits counted.
+func (this *countingBitStream) WriteArray(bits []byte, length uint) uint {
+	if this.closed == true {
+		panic(errors.New("Stream closed"))
+	}
+
+	this.written += uint64(length)
+	return length
+}
+
+// Close makes the bitstream unavailable for further writes.
+func (this *countingBitStream) Close() (bool, error) {
+	this.closed = true
+	return true, nil
+}
+
+// Written returns the number of bits counted
+func (this *countingBitStream) Written() uint64 {
+	return this.written
+}
+
+// EstimateCompressedSize returns the number of bytes written by the entropy
+// encoder of the given type for the block, without producing the output.
+// The size is exact: for Huffman, the code tables are built and the size of
+// the codes is computed from the histogram of each chunk; the other encoders
+// run on a bitstream that only counts the bits. It can be used to pick the
+// best entropy codec for a block (or to decide to store it).
+func EstimateCompressedSize(block []byte, codecType uint32) (res int, err error) {
+	if block == nil {
+		return 0, errors.New("Invalid null block parameter")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = 0, fmt.Errorf("Cannot estimate compressed size: %v", r)
+		}
+	}()
+
+	var bs countingBitStream
+
+	if codecType == HUFFMAN_TYPE {
+		if err := estimateHuffmanBits(&bs, block); err != nil {
+			return 0, err
+		}
+
+		return int((bs.Written() + 7) >> 3), nil
+	}
+
+	ctx := make(map[string]interface{})
+	ctx["size"] = uint(len(block))
+	ctx["blockSize"] = uint(len(block))
+	ee, err := NewEntropyEncoder(&bs, ctx, codecType)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = ee.Write(block); err != nil {
+		return 0, err
+	}
+
+	// Dispose may write to the bitstream
+	ee.Dispose()
+	return int((bs.Written() + 7) >> 3), nil
+}
+
+// estimateHuffmanBits counts the bits written by the Huffman encoder: the
+// code lengths of each chunk (written to the bitstream) and the codes.
+func estimateHuffmanBits(bs *countingBitStream, block []byte) error {
+	he, err := NewHuffmanEncoder(bs)
+
+	if err != nil {
+		return err
+	}
+
+	for startChunk := 0; startChunk < len(block); startChunk += he.chunkSize {
+		endChunk := startChunk + he.chunkSize
+
+		if endChunk > len(block) {
+			endChunk = len(block)
+		}
+
+		var frequencies [256]int
+		var counts [256]int
+		kanzi.ComputeHistogram(block[startChunk:endChunk], frequencies[:], true, false)
+
+		// The frequencies may be normalized by updateFrequencies
+		copy(counts[:], frequencies[:])
+
+		if _, err := he.updateFrequencies(frequencies[:]); err != nil {
+			return err
+		}
+
+		for i := range counts {
+			bs.written += uint64(counts[i]) * uint64(he.codes[i]>>24)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/flanglet/kanzi-go/bitstream"
+)
+
+// Streaming entropy coding: the data written to a StreamEncoder is split into
+// chunks entropy coded one at a time with the codec of the given type, without
+// the transforms and the block framing of the compressed streams (see package
+// io). Each chunk starts with fresh statistics.
+// Layout: for each chunk, its size in bytes (varint) then the entropy coded
+// chunk, padded to a byte boundary. A null size ends the stream.
+
+const (
+	// DEFAULT_STREAM_CHUNK_SIZE is the default size of the chunks of a StreamEncoder
+	DEFAULT_STREAM_CHUNK_SIZE = 1024 * 1024
+
+	// MAX_STREAM_CHUNK_SIZE is the max size of the chunks of a StreamEncoder
+	MAX_STREAM_CHUNK_SIZE = 64 * 1024 * 1024
+
+	_STREAM_MIN_CHUNK_SIZE   = 1024
+	_STREAM_BITSTREAM_BUFFER = 65536
+)
+
+// StreamEncoder an io.WriteCloser entropy coding the data written to it with
+// the codec of the given type and writing the result to an io.Writer
+type StreamEncoder struct {
+	obs         *bitstream.DefaultOutputBitStream
+	pool        CodecPool
+	entropyType uint32
+	buffer      []byte
+	size        int // number of bytes pending in buffer
+	err         error
+	closed      bool
+}
+
+// nopWriteCloser prevents the bitstream from closing the underlying writer
+type nopWriteCloser struct {
+	w io.Writer
+}
+
+func (this nopWriteCloser) Write(b []byte) (int, error) {
+	return this.w.Write(b)
+}
+
+func (this nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewStreamEncoder creates an instance of StreamEncoder writing to w with the
+// entropy codec of the given type (EG. HUFFMAN_TYPE or GetType("ANS0")).
+// Since the number of args is variable, this function can be called like this:
+// NewStreamEncoder(w, codec) or NewStreamEncoder(w, codec, 65536) (the third
+// argument being the chunk size, in [1024..MAX_STREAM_CHUNK_SIZE]).
+func NewStreamEncoder(w io.Writer, codec uint32, args ...uint) (*StreamEncoder, error) {
+	if w == nil {
+		return nil, errors.New("Stream codec: Invalid null writer parameter")
+	}
+
+	if err := checkEntropyType(codec); err != nil {
+		return nil, err
+	}
+
+	if len(args) > 1 {
+		return nil, errors.New("Stream codec: At most one chunk size can be provided")
+	}
+
+	chkSize := uint(DEFAULT_STREAM_CHUNK_SIZE)
+
+	if len(args) == 1 {
+		chkSize = args[0]
+
+		if chkSize < _STREAM_MIN_CHUNK_SIZE || chkSize > MAX_STREAM_CHUNK_SIZE {
+			return nil, fmt.Errorf("Stream codec: Invalid chunk size: %v (must be in [%d..%d])",
+				chkSize, _STREAM_MIN_CHUNK_SIZE, MAX_STREAM_CHUNK_SIZE)
+		}
+	}
+
+	obs, err := bitstream.NewDefaultOutputBitStream(nopWriteCloser{w: w}, _STREAM_BITSTREAM_BUFFER)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(StreamEncoder)
+	this.obs = obs
+	this.entropyType = codec
+	this.buffer = make([]byte, chkSize)
+	return this, nil
+}
+
+// Write buffers the data and encodes each full chunk. Return the number of
+// bytes consumed.
+func (this *StreamEncoder) Write(data []byte) (int, error) {
+	if this.closed == true {
+		return 0, errors.New("Stream codec: Stream closed")
+	}
+
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	written := 0
+
+	for len(data) > 0 {
+		n := copy(this.buffer[this.size:], data)
+		this.size += n
+		written += n
+		data = data[n:]
+
+		if this.size == len(this.buffer) {
+			if this.err = this.encodeChunk(); this.err != nil {
+				return written, this.err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Flush encodes the pending data and writes all the encoded bytes to the
+// underlying writer, so that a decoder can read them. Flushing small chunks
+// degrades compression.
+func (this *StreamEncoder) Flush() error {
+	if this.closed == true {
+		return errors.New("Stream codec: Stream closed")
+	}
+
+	if this.err != nil {
+		return this.err
+	}
+
+	if this.size > 0 {
+		if this.err = this.encodeChunk(); this.err != nil {
+			return this.err
+		}
+	}
+
+	this.err = this.obs.Flush()
+	return this.err
+}
+
+// Close encodes the pending data and writes the end of the stream. The
+// underlying writer is not closed.
+func (this *StreamEncoder) Close() error {
+	if this.closed == true {
+		return this.err
+	}
+
+	this.closed = true
+	defer this.pool.Release()
+
+	if this.err != nil {
+		return this.err
+	}
+
+	if this.size > 0 {
+		if this.err = this.encodeChunk(); this.err != nil {
+			return this.err
+		}
+	}
+
+	if this.err = this.writeEnd(); this.err != nil {
+		return this.err
+	}
+
+	_, this.err = this.obs.Close()
+	return this.err
+}
+
+func (this *StreamEncoder) writeEnd() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Stream codec: cannot write end of stream: %v", r)
+		}
+	}()
+
+	WriteVarInt(this.obs, 0)
+	return nil
+}
+
+func (this *StreamEncoder) encodeChunk() (err error) {
+	// The bitstream panics on write errors
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Stream codec: cannot encode chunk: %v", r)
+		}
+	}()
+
+	block := this.buffer[0:this.size]
+	this.size = 0
+	WriteVarInt(this.obs, uint32(len(block)))
+	ee, err := this.pool.NewEntropyEncoder(this.obs, streamCtx(this.entropyType, len(block)), this.entropyType)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = ee.Write(block)
+
+	// Dispose may write to the bitstream
+	ee.Dispose()
+
+	// Each chunk ends on a byte boundary
+	if pad := uint(this.obs.Written() & 7); pad != 0 {
+		this.obs.WriteBits(0, 8-pad)
+	}
+
+	return err
+}
+
+// StreamDecoder an io.Reader decoding the data written by a StreamEncoder
+type StreamDecoder struct {
+	ibs         *bitstream.DefaultInputBitStream
+	pool        CodecPool
+	entropyType uint32
+	buffer      []byte
+	start       int // index of the first decoded byte not returned yet
+	end         int // number of decoded bytes in buffer
+	err         error
+}
+
+// NewStreamDecoder creates an instance of StreamDecoder reading from r the data
+// encoded with the entropy codec of the given type. The decoder buffers its
+// input and may read past the end of the encoded data.
+func NewStreamDecoder(r io.Reader, codec uint32) (*StreamDecoder, error) {
+	if r == nil {
+		return nil, errors.New("Stream codec: Invalid null reader parameter")
+	}
+
+	if err := checkEntropyType(codec); err != nil {
+		return nil, err
+	}
+
+	ibs, err := bitstream.NewDefaultInputBitStream(ioutil.NopCloser(r), _STREAM_BITSTREAM_BUFFER)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(StreamDecoder)
+	this.ibs = ibs
+	this.entropyType = codec
+	this.buffer = make([]byte, 0)
+	return this, nil
+}
+
+// Read decodes data into the provided buffer. Return the number of bytes
+// decoded and io.EOF at the end of the stream.
+func (this *StreamDecoder) Read(data []byte) (int, error) {
+	read := 0
+
+	for read < len(data) {
+		if this.start == this.end {
+			if this.err != nil {
+				break
+			}
+
+			if this.err = this.decodeChunk(); this.err != nil {
+				this.pool.Release()
+				break
+			}
+
+			continue
+		}
+
+		n := copy(data[read:], this.buffer[this.start:this.end])
+		this.start += n
+		read += n
+	}
+
+	if read > 0 || len(data) == 0 {
+		return read, nil
+	}
+
+	return 0, this.err
+}
+
+func (this *StreamDecoder) decodeChunk() (err error) {
+	// The bitstream panics when the input is truncated
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Stream codec: cannot decode chunk: %v", r)
+		}
+	}()
+
+	size := int(ReadVarInt(this.ibs))
+
+	if size == 0 {
+		return io.EOF
+	}
+
+	if size > MAX_STREAM_CHUNK_SIZE {
+		return fmt.Errorf("Invalid bitstream: incorrect chunk size %v in stream codec", size)
+	}
+
+	if cap(this.buffer) < size {
+		this.buffer = make([]byte, size)
+	}
+
+	this.buffer = this.buffer[0:size]
+	this.start = 0
+	this.end = 0
+	ed, err := this.pool.NewEntropyDecoder(this.ibs, streamCtx(this.entropyType, size), this.entropyType)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = ed.Read(this.buffer)
+	ed.Dispose()
+
+	if err != nil {
+		return err
+	}
+
+	// Each chunk ends on a byte boundary
+	if pad := uint(this.ibs.Read() & 7); pad != 0 {
+		this.ibs.ReadBits(8 - pad)
+	}
+
+	this.end = size
+	return nil
+}
+
+// streamCtx returns the context of the codec of a chunk. The codec tables
+// are sized for the chunk.
+func streamCtx(entropyType uint32, size int) map[string]interface{} {
+	return map[string]interface{}{
+		"codec":     GetName(entropyType),
+		"extra":     entropyType == TPAQX_TYPE,
+		"size":      uint(size),
+		"blockSize": uint(size),
+		"jobs":      uint(1),
+	}
+}
+
+// checkEntropyType returns an error if there is no codec for the type
+func checkEntropyType(entropyType uint32) (err error) {
+	// GetName panics on unknown types
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Stream codec: %v", r)
+		}
+	}()
+
+	GetName(entropyType)
+	return nil
+}
@@ -16,16 +16,23 @@ limitations under the License.
 package entropy
 
 import (
+	"fmt"
 	"math/bits"
 
 	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util"
 )
 
 const (
 	_TPAQ_MAX_LENGTH       = 88
 	_TPAQ_BUFFER_SIZE      = 64 * 1024 * 1024
 	_TPAQ_HASH_SIZE        = 16 * 1024 * 1024
-	_TPAQ_MASK_BUFFER      = _TPAQ_BUFFER_SIZE - 1
+	_TPAQ_SMALL_MAP_SIZE   = 1 << 24
+
+	// TPAQ_MIN_MEMORY min memory budget of the TPAQ model in MB
+	TPAQ_MIN_MEMORY = 16
+	// TPAQ_MAX_MEMORY max memory budget of the TPAQ model in MB
+	TPAQ_MAX_MEMORY = 1024
 	_TPAQ_MASK_80808080    = int32(-2139062144) // 0x80808080
 	_TPAQ_MASK_F0F0F000    = int32(-252645376)  // 0xF0F0F000
 	_TPAQ_MASK_4F4FFFFF    = int32(1330642943)  // 0x4F4FFFFF
@@ -168,11 +175,15 @@ type TPAQPredictor struct {
 	statesMask      int32
 	mixersMask      int32
 	hashMask        int32
+	bufferMask      int32
+	smallMask       int32 // mask of smallStatesMap1
 	sse0            *LogisticAdaptiveProbMap
 	sse1            *LogisticAdaptiveProbMap
 	mixers          []TPAQMixer
 	mixer           *TPAQMixer // current mixer
-	buffer          []int8
+	arena           []uint8    // single allocation backing the tables below
+	alloc           *util.Allocator
+	buffer          []uint8
 	hashes          []int32 // hash table(context, buffer position)
 	bigStatesMap    []uint8 // hash table(context, prediction)
 	smallStatesMap0 []uint8 // hash table(context, prediction)
@@ -196,8 +207,20 @@ type TPAQPredictor struct {
 
 // NewTPAQPredictor creates a new instance of TPAQPredictor using the provided
 // map of options to select the sizes of internal structures.
+// By default, the tables grow with the block size (up to about 400 MB, twice
+// as much for TPAQX). The "tpaqMemory" option (in MB, a power of 2 in
+// [16..1024]) sets the memory used by the model instead: smaller budgets fit
+// small devices at the cost of compression. The decoder must use the same
+// budget as the encoder (the compressed streams store it in the header).
 func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	this := new(TPAQPredictor)
+	err := this.reset(ctx)
+	return this, err
+}
+
+// reset re-initializes the model for a new block. The tables are only
+// reallocated when their size changes, otherwise they are cleared.
+func (this *TPAQPredictor) reset(ctx *map[string]interface{}) error {
 	statesSize := 1 << 28
 	mixersSize := 1 << 12
 	hashSize := _TPAQ_HASH_SIZE
@@ -253,25 +276,93 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	mixersSize <<= extraMem
 	statesSize <<= extraMem
 	hashSize <<= (2 * extraMem)
+	bufferSize := _TPAQ_BUFFER_SIZE
+	smallSize := _TPAQ_SMALL_MAP_SIZE
+
+	budget, err := getTPAQMemory(ctx)
 
-	this.mixers = make([]TPAQMixer, mixersSize)
+	if err != nil {
+		return err
+	}
+
+	if budget != 0 {
+		// Half of the budget for the states, 1/8 for the buffer, the hash
+		// table and the order 2 states each, at most 1/16 for the mixers
+		statesSize = budget >> 1
+		bufferSize = budget >> 3
+		hashSize = budget >> 5 // int32 entries
+
+		if smallSize > budget>>3 {
+			smallSize = budget >> 3
+		}
+
+		for mixersSize > 2 && mixersSize*128 > budget>>4 {
+			mixersSize >>= 1
+		}
+	}
+
+	if len(this.mixers) != mixersSize {
+		this.mixers = make([]TPAQMixer, mixersSize)
+	}
 
 	for i := range this.mixers {
+		this.mixers[i] = TPAQMixer{}
 		this.mixers[i].init()
 	}
 
 	this.mixer = &this.mixers[0]
 	this.pr = 2048
 	this.c0 = 1
+	this.c4 = 0
+	this.c8 = 0
 	this.bpos = 8
-	this.bigStatesMap = make([]uint8, statesSize)
-	this.smallStatesMap0 = make([]uint8, 1<<16)
-	this.smallStatesMap1 = make([]uint8, 1<<24)
-	this.hashes = make([]int32, hashSize)
-	this.buffer = make([]int8, _TPAQ_BUFFER_SIZE)
+	this.pos = 0
+	this.binCount = 0
+	this.matchLen = 0
+	this.matchPos = 0
+	this.hash = 0
+	this.ctx0, this.ctx1, this.ctx2, this.ctx3 = 0, 0, 0, 0
+	this.ctx4, this.ctx5, this.ctx6 = 0, 0, 0
+
+	// The states maps and the byte buffer are carved out of one arena:
+	// a few big allocations instead of many keep the GC work and the
+	// memory footprint predictable for multi-hundred-MB models.
+	arenaSize := statesSize + (1 << 16) + smallSize + bufferSize
+
+	this.alloc = util.NewAllocatorWithCtx(ctx)
+
+	if len(this.arena) != arenaSize {
+		this.alloc.ReleaseBytes(this.arena)
+		this.arena = nil // let the old arena go before allocating the new one
+		this.arena = this.alloc.MakeBytes(arenaSize)
+	} else {
+		for i := range this.arena {
+			this.arena[i] = 0
+		}
+	}
+
+	offset := 0
+	this.bigStatesMap = this.arena[offset : offset+statesSize : offset+statesSize]
+	offset += statesSize
+	this.smallStatesMap0 = this.arena[offset : offset+(1<<16) : offset+(1<<16)]
+	offset += 1 << 16
+	this.smallStatesMap1 = this.arena[offset : offset+smallSize : offset+smallSize]
+	offset += smallSize
+	this.buffer = this.arena[offset : offset+bufferSize : offset+bufferSize]
+
+	if len(this.hashes) != hashSize {
+		this.hashes = make([]int32, hashSize)
+	} else {
+		for i := range this.hashes {
+			this.hashes[i] = 0
+		}
+	}
+
 	this.statesMask = int32(statesSize - 1)
 	this.mixersMask = int32(mixersSize-1) & ^1
 	this.hashMask = int32(hashSize - 1)
+	this.bufferMask = int32(bufferSize - 1)
+	this.smallMask = int32(smallSize - 1)
 	this.cp0 = &this.smallStatesMap0[0]
 	this.cp1 = &this.smallStatesMap1[0]
 	this.cp2 = &this.bigStatesMap[0]
@@ -280,19 +371,72 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	this.cp5 = &this.bigStatesMap[0]
 	this.cp6 = &this.bigStatesMap[0]
 
-	var err error
+	rate := uint(7)
 
 	if this.extra == true {
-		this.sse0, err = newLogisticAdaptiveProbMap(256, 6)
+		rate = 6
 
-		if err == nil {
+		if this.sse1 != nil {
+			this.sse1.reset(7)
+		} else {
 			this.sse1, err = newLogisticAdaptiveProbMap(65536, 7)
 		}
 	} else {
-		this.sse0, err = newLogisticAdaptiveProbMap(256, 7)
+		this.sse1 = nil
 	}
 
-	return this, err
+	if this.sse0 != nil {
+		this.sse0.reset(rate)
+	} else if err == nil {
+		this.sse0, err = newLogisticAdaptiveProbMap(256, rate)
+	}
+
+	if err == nil {
+		err = primePredictor(this, ctx)
+	}
+
+	return err
+}
+
+// getTPAQMemory returns the memory budget of the TPAQ model in bytes
+// ("tpaqMemory" context entry in MB) or 0 if the tables are sized after the
+// block size
+func getTPAQMemory(ctx *map[string]interface{}) (int, error) {
+	if ctx == nil {
+		return 0, nil
+	}
+
+	val, containsKey := (*ctx)["tpaqMemory"]
+
+	if containsKey == false {
+		return 0, nil
+	}
+
+	mb := val.(uint)
+
+	if mb < TPAQ_MIN_MEMORY || mb > TPAQ_MAX_MEMORY || mb&(mb-1) != 0 {
+		return 0, fmt.Errorf("Invalid TPAQ memory budget: %v MB (must be a power of 2 in [%d..%d])",
+			mb, TPAQ_MIN_MEMORY, TPAQ_MAX_MEMORY)
+	}
+
+	return int(mb) << 20, nil
+}
+
+// Release drops the model tables so the memory can be reclaimed without
+// waiting for the predictor itself to become unreachable. The predictor
+// must be reset before being used again.
+func (this *TPAQPredictor) Release() {
+	this.alloc.ReleaseBytes(this.arena)
+	this.arena = nil
+	this.bigStatesMap = nil
+	this.smallStatesMap0 = nil
+	this.smallStatesMap1 = nil
+	this.buffer = nil
+	this.hashes = nil
+	this.mixers = nil
+	this.mixer = nil
+	this.cp0, this.cp1, this.cp2, this.cp3 = nil, nil, nil, nil
+	this.cp4, this.cp5, this.cp6 = nil, nil, nil
 }
 
 // Update updates the internal probability model based on the observed bit
@@ -303,7 +447,7 @@ func (this *TPAQPredictor) Update(bit byte) {
 	this.c0 = (this.c0 << 1) | int32(bit)
 
 	if this.c0 > 255 {
-		this.buffer[this.pos&_TPAQ_MASK_BUFFER] = int8(this.c0)
+		this.buffer[this.pos&this.bufferMask] = uint8(this.c0)
 		this.pos++
 		this.c8 = (this.c8 << 8) | ((this.c4 >> 24) & 0xFF)
 		this.c4 = (this.c4 << 8) | (this.c0 & 0xFF)
@@ -380,7 +524,7 @@ func (this *TPAQPredictor) Update(bit byte) {
 	*this.cp5 = table[*this.cp5]
 	this.cp0 = &this.smallStatesMap0[this.ctx0+c]
 	p0 := _TPAQ_STATE_MAP[*this.cp0]
-	this.cp1 = &this.smallStatesMap1[this.ctx1+c]
+	this.cp1 = &this.smallStatesMap1[(this.ctx1+c)&this.smallMask]
 	p1 := _TPAQ_STATE_MAP[*this.cp1]
 	this.cp2 = &this.bigStatesMap[(this.ctx2+c)&this.statesMask]
 	p2 := _TPAQ_STATE_MAP[*this.cp2]
@@ -450,17 +594,17 @@ func (this *TPAQPredictor) findMatch() {
 		this.matchPos = this.hashes[this.hash]
 
 		// Detect match
-		if this.matchPos != 0 && this.pos-this.matchPos <= _TPAQ_MASK_BUFFER {
+		if this.matchPos != 0 && this.pos-this.matchPos <= this.bufferMask {
 			r := this.matchLen + 2
 			s := this.pos - r
 			t := this.matchPos - r
 
 			for r <= _TPAQ_MAX_LENGTH {
-				if this.buffer[s&_TPAQ_MASK_BUFFER] != this.buffer[t&_TPAQ_MASK_BUFFER] {
+				if this.buffer[s&this.bufferMask] != this.buffer[t&this.bufferMask] {
 					break
 				}
 
-				if this.buffer[(s-1)&_TPAQ_MASK_BUFFER] != this.buffer[(t-1)&_TPAQ_MASK_BUFFER] {
+				if this.buffer[(s-1)&this.bufferMask] != this.buffer[(t-1)&this.bufferMask] {
 					break
 				}
 
@@ -476,7 +620,7 @@ func (this *TPAQPredictor) findMatch() {
 
 // Get a squashed prediction (in [-2047..2048]) from the match model
 func (this *TPAQPredictor) getMatchContextPred() int32 {
-	if this.c0 == ((int32(this.buffer[this.matchPos&_TPAQ_MASK_BUFFER])&0xFF)|256)>>this.bpos {
+	if this.c0 == ((int32(this.buffer[this.matchPos&this.bufferMask])&0xFF)|256)>>this.bpos {
 		var p int32
 
 		if this.matchLen <= 24 {
@@ -485,7 +629,7 @@ func (this *TPAQPredictor) getMatchContextPred() int32 {
 			p = (24 + ((this.matchLen - 24) >> 3))
 		}
 
-		if ((this.buffer[this.matchPos&_TPAQ_MASK_BUFFER] >> (this.bpos - 1)) & 1) == 0 {
+		if ((this.buffer[this.matchPos&this.bufferMask] >> (this.bpos - 1)) & 1) == 0 {
 			return -p << 6
 		}
 
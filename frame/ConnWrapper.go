@@ -0,0 +1,186 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	// Writes larger than this are sent as several frames
+	_CONN_MAX_CHUNK = 1024 * 1024
+	// Max size of a frame on the wire (header plus incompressible chunk)
+	_CONN_MAX_FRAME = _CONN_MAX_CHUNK + 64
+)
+
+// ConnWrapper is a net.Conn compressing the data written and decompressing
+// the data read. Each Write is sent immediately as one frame (or several for
+// large writes) prefixed by its size (varint), so the peer can decode all
+// the data as soon as Write returns: no Flush is required and the framing
+// of the protocol above is unchanged.
+// Each direction has its own Encoder or Decoder, hence its own dictionary.
+// Read and Write can be called concurrently (but not Read with Read or
+// Write with Write).
+type ConnWrapper struct {
+	net.Conn
+	encoder *Encoder
+	decoder *Decoder
+	reader  *bufio.Reader
+	rMutex  sync.Mutex
+	wMutex  sync.Mutex
+	rBuffer []byte // decoded data
+	pending []byte // decoded data not read yet
+	fBuffer []byte // frame read from the connection
+	wBuffer []byte
+	rErr    error
+}
+
+// NewConnWrapper wraps the connection. The encoder compresses the data
+// written (and must use a dictionary registered in the peer decoder), the
+// decoder decompresses the data read. A nil decoder means NewDecoder().
+func NewConnWrapper(conn net.Conn, encoder *Encoder, decoder *Decoder) (*ConnWrapper, error) {
+	if conn == nil {
+		return nil, errors.New("Invalid null connection parameter")
+	}
+
+	if encoder == nil {
+		return nil, errors.New("Invalid null encoder parameter")
+	}
+
+	if decoder == nil {
+		decoder = NewDecoder()
+	}
+
+	this := &ConnWrapper{Conn: conn, encoder: encoder, decoder: decoder}
+	this.reader = bufio.NewReader(conn)
+	this.rBuffer = make([]byte, 0)
+	this.fBuffer = make([]byte, 0)
+	this.wBuffer = make([]byte, binary.MaxVarintLen32)
+	return this, nil
+}
+
+// Read reads decompressed data (see net.Conn)
+func (this *ConnWrapper) Read(b []byte) (int, error) {
+	this.rMutex.Lock()
+	defer this.rMutex.Unlock()
+
+	for len(this.pending) == 0 {
+		if this.rErr != nil {
+			return 0, this.rErr
+		}
+
+		if len(b) == 0 {
+			return 0, nil
+		}
+
+		// An error before the first byte of a frame (EG. a read deadline)
+		// leaves the stream in a consistent state
+		if _, err := this.reader.Peek(1); err != nil {
+			return 0, err
+		}
+
+		this.rErr = this.readFrame()
+	}
+
+	n := copy(b, this.pending)
+	this.pending = this.pending[n:]
+	return n, nil
+}
+
+// Read and decode the next frame. Errors in the middle of a frame are sticky.
+func (this *ConnWrapper) readFrame() error {
+	size, err := binary.ReadUvarint(this.reader)
+
+	if err != nil {
+		return err
+	}
+
+	if size > _CONN_MAX_FRAME {
+		return fmt.Errorf("Invalid frame size: %d", size)
+	}
+
+	if uint64(cap(this.fBuffer)) < size {
+		this.fBuffer = make([]byte, size)
+	}
+
+	this.fBuffer = this.fBuffer[0:size]
+
+	if _, err = io.ReadFull(this.reader, this.fBuffer); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	if n, err := DecodedLen(this.fBuffer); err != nil || n > _CONN_MAX_CHUNK {
+		if err == nil {
+			err = fmt.Errorf("Invalid frame: message too large (%d bytes)", n)
+		}
+
+		return err
+	}
+
+	if this.rBuffer, err = this.decoder.Decode(this.rBuffer[:0], this.fBuffer); err != nil {
+		return err
+	}
+
+	this.pending = this.rBuffer
+	return nil
+}
+
+// Write compresses and sends the data (see net.Conn)
+func (this *ConnWrapper) Write(b []byte) (int, error) {
+	this.wMutex.Lock()
+	defer this.wMutex.Unlock()
+	written := 0
+
+	for len(b) > 0 {
+		chunk := b
+
+		if len(chunk) > _CONN_MAX_CHUNK {
+			chunk = chunk[0:_CONN_MAX_CHUNK]
+		}
+
+		// Reserve the space of the largest size prefix, then move the frame
+		var err error
+		this.wBuffer, err = this.encoder.Encode(this.wBuffer[:binary.MaxVarintLen32], chunk)
+
+		if err != nil {
+			return written, err
+		}
+
+		var prefix [binary.MaxVarintLen32]byte
+		n := binary.PutUvarint(prefix[:], uint64(len(this.wBuffer)-binary.MaxVarintLen32))
+		start := binary.MaxVarintLen32 - n
+		copy(this.wBuffer[start:], prefix[0:n])
+
+		if _, err = this.Conn.Write(this.wBuffer[start:]); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+// Decoder decompresses frames produced by any Encoder whose dictionary
+// (if any) has been registered with AddDictionary
+type Decoder struct {
+	mutex        sync.RWMutex
+	dictionaries map[byte]map[string]interface{}
+	pipelines    map[int]*sync.Pool // key: level | dictionary id << 8 | has dictionary << 16
+}
+
+// NewDecoder creates a new instance of Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{
+		dictionaries: make(map[byte]map[string]interface{}),
+		pipelines:    make(map[int]*sync.Pool),
+	}
+}
+
+// AddDictionary registers the dictionary used by the encoders for the id.
+// Registering another dictionary for an existing id is an error.
+func (this *Decoder) AddDictionary(id byte, dict []byte) error {
+	ctx, err := dictionaryContext(dict, true)
+
+	if err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, exists := this.dictionaries[id]; exists == true {
+		return fmt.Errorf("Dictionary %d already registered", id)
+	}
+
+	this.dictionaries[id] = ctx
+	return nil
+}
+
+// Decode appends the message in the frame to dst and returns the result
+func (this *Decoder) Decode(dst, frame []byte) ([]byte, error) {
+	h, err := readHeader(frame)
+
+	if err != nil {
+		return dst, err
+	}
+
+	if h.level == 0 {
+		return append(dst, frame[h.offset:]...), nil
+	}
+
+	pool, err := this.getPool(h)
+
+	if err != nil {
+		return dst, err
+	}
+
+	pp := pool.Get().(*pooledPipeline)
+	defer pool.Put(pp)
+
+	// Rebuild the pipeline header in front of the entropy coded data
+	size := _PIPELINE_HEADER_SIZE + len(frame) - h.offset
+
+	if len(pp.buf) < size {
+		pp.buf = make([]byte, size)
+	}
+
+	payload := pp.buf[0:size]
+	payload[0] = 0
+	payload[1] = h.skipFlags
+	binary.BigEndian.PutUint32(payload[2:6], uint32(h.msgLen))
+	binary.BigEndian.PutUint32(payload[6:10], uint32(h.length))
+	copy(payload[_PIPELINE_HEADER_SIZE:], frame[h.offset:])
+
+	start := len(dst)
+
+	if cap(dst)-start < h.msgLen {
+		buf := make([]byte, start, start+h.msgLen)
+		copy(buf, dst)
+		dst = buf
+	}
+
+	dst = dst[0 : start+h.msgLen]
+
+	if _, _, err = pp.p.Inverse(payload, dst[start:]); err != nil {
+		return dst[0:start], err
+	}
+
+	return dst, nil
+}
+
+func (this *Decoder) getPool(h *frameHeader) (*sync.Pool, error) {
+	key := h.level
+
+	if h.hasDict == true {
+		key |= int(h.dictID)<<8 | 1<<16
+	}
+
+	this.mutex.RLock()
+	pool := this.pipelines[key]
+	this.mutex.RUnlock()
+
+	if pool != nil {
+		return pool, nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if pool = this.pipelines[key]; pool != nil {
+		return pool, nil
+	}
+
+	var ctx map[string]interface{}
+
+	if h.hasDict == true {
+		if ctx = this.dictionaries[h.dictID]; ctx == nil {
+			return nil, fmt.Errorf("Invalid frame: unknown dictionary %d", h.dictID)
+		}
+	}
+
+	desc, _ := pipeline.ForLevel(h.level)
+
+	if _, err := pipeline.NewPipelineWithCtx(desc, ctx); err != nil {
+		return nil, err
+	}
+
+	pool = &sync.Pool{New: func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, ctx)
+		return &pooledPipeline{p: p, buf: make([]byte, 0)}
+	}}
+
+	this.pipelines[key] = pool
+	return pool, nil
+}
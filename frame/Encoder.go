@@ -0,0 +1,265 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package frame implements a lightweight frame format for small messages
+// (typically under 64KB, EG. Kafka or NATS payloads) where the stream
+// container header would cost more than compression saves.
+//
+// A frame is one message, compressed in one shot:
+//
+//	byte 0:     bit 7 = dictionary id present, bits 4-6 = 0 (reserved),
+//	            bits 0-3 = compression level (0 means stored)
+//	[byte 1]:   dictionary id
+//	stored:     the message bytes
+//	compressed: transform skip flags (1 byte), message length (varint),
+//	            transformed length (varint), entropy coded data
+//
+// There is no block index nor checksum: the message broker provides the
+// frame boundaries and integrity. Encoders and decoders are safe for
+// concurrent use (the pipelines are pooled).
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/dictionary"
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+const (
+	// MAX_MESSAGE_SIZE is the maximum size of a message
+	MAX_MESSAGE_SIZE = 1 << 24
+
+	_FRAME_DICT_MASK       = 0x80
+	_FRAME_RESERVED_MASK   = 0x70
+	_FRAME_LEVEL_MASK      = 0x0F
+	_FRAME_MAX_LEVEL       = 8
+	_PIPELINE_HEADER_SIZE  = 10 // see pipeline.Pipeline
+	_FRAME_MIN_COMPRESSION = 32 // smaller messages are always stored
+)
+
+// Encoder compresses messages into frames using a compression level and
+// possibly a dictionary shared with the decoders
+type Encoder struct {
+	level     int
+	hasDict   bool
+	dictID    byte
+	pipelines sync.Pool
+}
+
+type pooledPipeline struct {
+	p   *pipeline.Pipeline
+	buf []byte
+}
+
+// NewEncoder creates a new Encoder using the provided compression level
+// in [0..8] (see pipeline.ForLevel). Level 0 stores the messages.
+func NewEncoder(level int) (*Encoder, error) {
+	return newEncoder(level, false, 0, nil)
+}
+
+// NewEncoderWithDictionary creates a new Encoder using the provided level
+// and dictionary (raw or trained with 'zstd --train', see the dictionary
+// package). The decoders must register the same dictionary with the same id.
+func NewEncoderWithDictionary(level int, id byte, dict []byte) (*Encoder, error) {
+	return newEncoder(level, true, id, dict)
+}
+
+func newEncoder(level int, hasDict bool, id byte, dict []byte) (*Encoder, error) {
+	if level < 0 || level > _FRAME_MAX_LEVEL {
+		return nil, fmt.Errorf("Invalid compression level: %d (must be in [0..%d])", level, _FRAME_MAX_LEVEL)
+	}
+
+	ctx, err := dictionaryContext(dict, hasDict)
+
+	if err != nil {
+		return nil, err
+	}
+
+	desc, _ := pipeline.ForLevel(level)
+	this := &Encoder{level: level, hasDict: hasDict, dictID: id}
+
+	// Fail now rather than in the pool
+	if _, err = pipeline.NewPipelineWithCtx(desc, ctx); err != nil {
+		return nil, err
+	}
+
+	this.pipelines.New = func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, ctx)
+		return &pooledPipeline{p: p, buf: make([]byte, 0)}
+	}
+
+	return this, nil
+}
+
+// Return the codec parameters for the dictionary
+func dictionaryContext(dict []byte, hasDict bool) (map[string]interface{}, error) {
+	if hasDict == false {
+		return nil, nil
+	}
+
+	d, err := dictionary.Parse(dict)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := map[string]interface{}{"lzDictionary": d.LZDictionary(0)}
+
+	// The text codec keeps its default dictionary if there is no word
+	if words := d.TextDictionary(0); len(words) > 0 {
+		ctx["textDictionary"] = words
+	}
+
+	return ctx, nil
+}
+
+// Encode appends the frame of the message to dst and returns the result
+func (this *Encoder) Encode(dst, msg []byte) ([]byte, error) {
+	if len(msg) > MAX_MESSAGE_SIZE {
+		return dst, fmt.Errorf("Message too large - size: %d, max %d", len(msg), MAX_MESSAGE_SIZE)
+	}
+
+	start := len(dst)
+
+	if this.level > 0 && len(msg) >= _FRAME_MIN_COMPRESSION {
+		pp := this.pipelines.Get().(*pooledPipeline)
+		defer this.pipelines.Put(pp)
+		size := pp.p.MaxEncodedLen(len(msg))
+
+		if len(pp.buf) < size {
+			pp.buf = make([]byte, size)
+		}
+
+		_, written, err := pp.p.Forward(msg, pp.buf)
+
+		if err != nil {
+			return dst, err
+		}
+
+		dst = this.appendHeader(dst, this.level)
+
+		// Replace the fixed size pipeline header (mode, skip flags, lengths)
+		// with variable size lengths
+		payload := pp.buf[0:written]
+		dst = append(dst, payload[1])
+		dst = appendUvarint(dst, uint64(binary.BigEndian.Uint32(payload[2:6])))
+		dst = appendUvarint(dst, uint64(binary.BigEndian.Uint32(payload[6:10])))
+		dst = append(dst, payload[_PIPELINE_HEADER_SIZE:]...)
+
+		if len(dst)-start < len(msg)+1 && payload[0] == 0 {
+			return dst, nil
+		}
+
+		// Incompressible message: store it
+		dst = dst[0:start]
+	}
+
+	dst = this.appendHeader(dst, 0)
+	return append(dst, msg...), nil
+}
+
+func (this *Encoder) appendHeader(dst []byte, level int) []byte {
+	if this.hasDict == true && level > 0 {
+		return append(dst, byte(_FRAME_DICT_MASK|level), this.dictID)
+	}
+
+	return append(dst, byte(level))
+}
+
+func appendUvarint(dst []byte, val uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], val)
+	return append(dst, buf[0:n]...)
+}
+
+// DecodedLen returns the size of the message in the frame
+func DecodedLen(frame []byte) (int, error) {
+	h, err := readHeader(frame)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return h.msgLen, nil
+}
+
+type frameHeader struct {
+	level     int
+	hasDict   bool
+	dictID    byte
+	skipFlags byte
+	msgLen    int
+	length    int // transformed length
+	offset    int // start of the stored message or entropy coded data
+}
+
+func readHeader(frame []byte) (*frameHeader, error) {
+	if len(frame) == 0 {
+		return nil, errors.New("Invalid frame: missing header")
+	}
+
+	if frame[0]&_FRAME_RESERVED_MASK != 0 {
+		return nil, fmt.Errorf("Invalid frame: unknown header %#x", frame[0])
+	}
+
+	h := &frameHeader{level: int(frame[0] & _FRAME_LEVEL_MASK), offset: 1}
+
+	if h.level > _FRAME_MAX_LEVEL {
+		return nil, fmt.Errorf("Invalid frame: incorrect compression level %d", h.level)
+	}
+
+	if h.level == 0 {
+		h.msgLen = len(frame) - 1
+		return h, nil
+	}
+
+	if frame[0]&_FRAME_DICT_MASK != 0 {
+		if len(frame) < 2 {
+			return nil, errors.New("Invalid frame: missing dictionary id")
+		}
+
+		h.hasDict = true
+		h.dictID = frame[1]
+		h.offset++
+	}
+
+	if h.offset >= len(frame) {
+		return nil, errors.New("Invalid frame: missing skip flags")
+	}
+
+	h.skipFlags = frame[h.offset]
+	h.offset++
+
+	for _, val := range []*int{&h.msgLen, &h.length} {
+		v, n := binary.Uvarint(frame[h.offset:])
+
+		if n <= 0 || v > MAX_MESSAGE_SIZE*2 {
+			return nil, errors.New("Invalid frame: incorrect length")
+		}
+
+		*val = int(v)
+		h.offset += n
+	}
+
+	if h.msgLen > MAX_MESSAGE_SIZE {
+		return nil, fmt.Errorf("Invalid frame: message too large (%d bytes)", h.msgLen)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// AudioCodec is a codec that replaces the PCM samples (8 bit unsigned or 16
+// bit little endian, 1 to 8 interleaved channels) of a block with the
+// residuals of a fixed linear predictor of order 1 or 2 per channel
+// (x[n-1] or 2*x[n-1]-x[n-2]). The residuals are mapped to small unsigned
+// values (zigzag) and the high bytes of 16 bit residuals are grouped before
+// the low bytes.
+// The sample format is read from the header of a WAV file at the start of
+// the block. Otherwise (EG. next blocks of the file), it is guessed by
+// estimating the size of the residuals of each supported format.
+
+// Audio block format: Header (2 bytes) Bytes Residuals Tail
+// Header: mode (8 bits: order-1 (2 bits), unused (2 bits), sample size-1
+// (1 bit), channels-1 (3 bits)) + number of bytes before the first frame
+// Bytes: the bytes before the first frame (EG. WAV header), unchanged
+// Residuals: one per sample, 16 bit residuals as all the high bytes
+// followed by all the low bytes
+// Tail: the bytes after the last frame, unchanged
+
+const (
+	_AUDIO_HEADER_SIZE    = 2
+	_AUDIO_MIN_BLOCK_SIZE = 1024
+	_AUDIO_MAX_CHANNELS   = 8
+	_AUDIO_SAMPLE_FRAMES  = 1 << 14 // max number of frames used to guess the format
+)
+
+// audioFormat PCM format of a block
+type audioFormat struct {
+	channels int
+	width    int // bytes per sample
+	order    int
+	offset   int // index of the first frame
+}
+
+// AudioCodec a codec for PCM audio
+type AudioCodec struct {
+}
+
+// NewAudioCodec creates a new instance of AudioCodec
+func NewAudioCodec() (*AudioCodec, error) {
+	this := &AudioCodec{}
+	return this, nil
+}
+
+// NewAudioCodecWithCtx creates a new instance of AudioCodec using a
+// configuration map as parameter.
+func NewAudioCodecWithCtx(ctx *map[string]interface{}) (*AudioCodec, error) {
+	this := &AudioCodec{}
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the source data does not look like
+// PCM samples, an error is returned.
+func (this *AudioCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	if count < _AUDIO_MIN_BLOCK_SIZE {
+		return 0, 0, errors.New("Block too small, skip")
+	}
+
+	format, ok := readWAVHeader(src)
+
+	if ok == false {
+		if format, ok = guessAudioFormat(src); ok == false {
+			return 0, 0, errors.New("Not PCM audio or not predictable enough")
+		}
+	}
+
+	dst[0] = byte(((format.order - 1) << 6) | ((format.width - 1) << 3) | (format.channels - 1))
+	dst[1] = byte(format.offset)
+	copy(dst[_AUDIO_HEADER_SIZE:], src[0:format.offset])
+	frameSize := format.channels * format.width
+	end := format.offset + (count-format.offset)/frameSize*frameSize
+	samples := src[format.offset:end]
+	residuals := dst[_AUDIO_HEADER_SIZE+format.offset : _AUDIO_HEADER_SIZE+end]
+
+	if format.width == 2 {
+		predictAudio16(samples, residuals, format, true)
+	} else {
+		predictAudio8(samples, residuals, format, true)
+	}
+
+	copy(dst[_AUDIO_HEADER_SIZE+end:], src[end:])
+	return uint(count), uint(count + _AUDIO_HEADER_SIZE), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *AudioCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	if len(src) < _AUDIO_HEADER_SIZE {
+		return 0, 0, errors.New("Invalid audio block: too small")
+	}
+
+	count := len(src) - _AUDIO_HEADER_SIZE
+	format := audioFormat{}
+	format.order = int(src[0]>>6) + 1
+	format.width = int((src[0]>>3)&1) + 1
+	format.channels = int(src[0]&7) + 1
+	format.offset = int(src[1])
+
+	if format.order > 2 || src[0]&0x30 != 0 || format.offset > count {
+		return 0, 0, errors.New("Invalid audio block: incorrect header")
+	}
+
+	if len(dst) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	src = src[_AUDIO_HEADER_SIZE:]
+	copy(dst, src[0:format.offset])
+	frameSize := format.channels * format.width
+	end := format.offset + (count-format.offset)/frameSize*frameSize
+
+	if format.width == 2 {
+		predictAudio16(src[format.offset:end], dst[format.offset:end], format, false)
+	} else {
+		predictAudio8(src[format.offset:end], dst[format.offset:end], format, false)
+	}
+
+	copy(dst[end:], src[end:count])
+	return uint(count + _AUDIO_HEADER_SIZE), uint(count), nil
+}
+
+// predictAudio16 writes the residuals of the 16 bit samples of 'src' to dst
+// (forward) or the samples of the residuals of 'src' (inverse)
+func predictAudio16(src, dst []byte, format audioFormat, forward bool) {
+	channels := format.channels
+	samples := len(src) >> 1
+	var prev1, prev2 [_AUDIO_MAX_CHANNELS]uint16
+
+	for i := 0; i < samples; i++ {
+		c := i % channels
+		pred := prev1[c]
+
+		if format.order == 2 {
+			pred = 2*prev1[c] - prev2[c]
+		}
+
+		var x uint16
+
+		if forward == true {
+			x = binary.LittleEndian.Uint16(src[2*i:])
+			r := int16(x - pred)
+			z := uint16((r << 1) ^ (r >> 15))
+			dst[i] = byte(z >> 8)
+			dst[samples+i] = byte(z)
+		} else {
+			z := (uint16(src[i]) << 8) | uint16(src[samples+i])
+			x = pred + ((z >> 1) ^ -(z & 1))
+			binary.LittleEndian.PutUint16(dst[2*i:], x)
+		}
+
+		prev2[c] = prev1[c]
+		prev1[c] = x
+	}
+}
+
+// predictAudio8 writes the residuals of the 8 bit samples of 'src' to dst
+// (forward) or the samples of the residuals of 'src' (inverse)
+func predictAudio8(src, dst []byte, format audioFormat, forward bool) {
+	channels := format.channels
+	var prev1, prev2 [_AUDIO_MAX_CHANNELS]byte
+
+	for i := range src {
+		c := i % channels
+		pred := prev1[c]
+
+		if format.order == 2 {
+			pred = 2*prev1[c] - prev2[c]
+		}
+
+		var x byte
+
+		if forward == true {
+			x = src[i]
+			r := int8(x - pred)
+			dst[i] = byte((r << 1) ^ (r >> 7))
+		} else {
+			z := src[i]
+			x = pred + ((z >> 1) ^ -(z & 1))
+			dst[i] = x
+		}
+
+		prev2[c] = prev1[c]
+		prev1[c] = x
+	}
+}
+
+// readWAVHeader returns the format of the samples of a block starting with
+// the header of a PCM WAV file
+func readWAVHeader(src []byte) (audioFormat, bool) {
+	format := audioFormat{order: 2}
+
+	if len(src) < 12 || string(src[0:4]) != "RIFF" || string(src[8:12]) != "WAVE" {
+		return format, false
+	}
+
+	// Find the 'fmt ' and 'data' chunks
+	idx := 12
+
+	for idx+8 <= len(src) && idx < 256 {
+		id := string(src[idx : idx+4])
+		size := int(binary.LittleEndian.Uint32(src[idx+4:]))
+		idx += 8
+
+		if id == "data" {
+			format.offset = idx
+			break
+		}
+
+		if id == "fmt " && size >= 16 && idx+16 <= len(src) {
+			if fmtTag := binary.LittleEndian.Uint16(src[idx:]); fmtTag != 1 && fmtTag != 0xFFFE {
+				return format, false
+			}
+
+			format.channels = int(binary.LittleEndian.Uint16(src[idx+2:]))
+			format.width = int(binary.LittleEndian.Uint16(src[idx+14:])+7) >> 3
+		}
+
+		if size < 0 || size > 256 {
+			return format, false
+		}
+
+		idx += (size + 1) &^ 1
+	}
+
+	if format.offset == 0 || format.offset > 255 || format.channels < 1 ||
+		format.channels > _AUDIO_MAX_CHANNELS || format.width < 1 || format.width > 2 {
+		return format, false
+	}
+
+	return format, true
+}
+
+// guessAudioFormat returns the format (mono or stereo, 8 or 16 bit samples,
+// alignment and order of the predictor) giving the smallest residuals on
+// the first frames of the block. It fails if the residuals are not smaller
+// than the block itself (order 0 entropy).
+func guessAudioFormat(src []byte) (audioFormat, bool) {
+	length := len(src)
+
+	if length > 4*_AUDIO_SAMPLE_FRAMES {
+		length = 4 * _AUDIO_SAMPLE_FRAMES
+	}
+
+	block := src[0:length]
+	best := audioFormat{}
+	bestCost := math.MaxFloat64
+	buf := make([]byte, length)
+
+	for channels := 1; channels <= 2; channels++ {
+		for width := 1; width <= 2; width++ {
+			for order := 1; order <= 2; order++ {
+				for offset := 0; offset < channels*width; offset++ {
+					format := audioFormat{channels: channels, width: width, order: order, offset: offset}
+					frameSize := channels * width
+					end := offset + (length-offset)/frameSize*frameSize
+
+					if width == 2 {
+						predictAudio16(block[offset:end], buf, format, true)
+					} else {
+						predictAudio8(block[offset:end], buf, format, true)
+					}
+
+					// Estimate the bits of each residual
+					cost := 0.0
+					samples := (end - offset) / width
+
+					for i := 0; i < samples; i++ {
+						z := uint(buf[i])
+
+						if width == 2 {
+							z = (z << 8) | uint(buf[samples+i])
+						}
+
+						cost += float64(bits.Len(z) + 1)
+					}
+
+					if cost = cost / float64(end-offset); cost < bestCost {
+						bestCost = cost
+						best = format
+					}
+				}
+			}
+		}
+	}
+
+	return best, bestCost < 0.8*order0Entropy(block)
+}
+
+// order0Entropy returns the order 0 entropy of a block (bits per byte)
+func order0Entropy(block []byte) float64 {
+	var freqs [256]int
+
+	for _, b := range block {
+		freqs[b]++
+	}
+
+	entropy := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			p := float64(f) / float64(len(block))
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return entropy
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this AudioCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + _AUDIO_HEADER_SIZE
+}
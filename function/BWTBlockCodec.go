@@ -23,7 +23,7 @@ import (
 )
 
 const (
-	BWT_MAX_HEADER_SIZE = 8 * 4
+	BWT_MAX_HEADER_SIZE = 32 * 4
 )
 
 // Utility class to en/de-code a BWT data block and its associated primary index(es)
@@ -61,6 +61,11 @@ func NewBWTBlockCodecWithCtx(ctx *map[string]interface{}) (*BWTBlockCodec, error
 	return this, err
 }
 
+// Release releases the buffers of the BWT
+func (this *BWTBlockCodec) Release() {
+	this.bwt.Release()
+}
+
 // Forward applies the function to the src and writes the result
 // to the destination. Returns number of bytes read, number of bytes
 // written and possibly an error.
@@ -80,7 +85,7 @@ func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 			len(dst), this.MaxEncodedLen(blockSize))
 	}
 
-	chunks := transform.GetBWTChunks(blockSize)
+	chunks := this.bwt.Chunks(blockSize)
 	log := uint(1)
 
 	for 1<<log <= len(src) {
@@ -160,9 +165,45 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
+	srcIdx, blockSize, err := this.readHeader(src)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Apply inverse Transform
+	return this.bwt.Inverse(src[srcIdx:srcIdx+blockSize], dst)
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *BWTBlockCodec) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length == 0 {
+		return 0, nil
+	}
+
+	if length > uint(len(block)) {
+		return 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(block), length)
+	}
+
+	srcIdx, blockSize, err := this.readHeader(block[0:length])
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Drop the header, the BWT output has the same size as its input
+	copy(block, block[srcIdx:srcIdx+blockSize])
+	return this.bwt.InverseInPlace(block, blockSize)
+}
+
+// readHeader sets the primary indexes of the BWT and returns the size of
+// the header and the size of the BWT data
+func (this *BWTBlockCodec) readHeader(src []byte) (uint, uint, error) {
 	srcIdx := uint(0)
 	blockSize := uint(len(src))
-	chunks := transform.GetBWTChunks(len(src))
+	chunks := this.bwt.Chunks(len(src))
 
 	for i := 0; i < chunks; i++ {
 		// Read block header (mode + primary index). See top of file for format
@@ -190,8 +231,7 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		}
 	}
 
-	// Apply inverse Transform
-	return this.bwt.Inverse(src[srcIdx:srcIdx+blockSize], dst)
+	return srcIdx, blockSize, nil
 }
 
 // MaxEncodedLen returns the max size required for the encoding output buffer
@@ -28,6 +28,9 @@ const (
 	_BFF_MAX_SHIFT = (8 - 1) * _BFF_ONE_SHIFT // 8 transforms
 	_BFF_MASK      = (1 << _BFF_ONE_SHIFT) - 1
 
+	// MAX_TRANSFORMS is the max number of transforms of a chain
+	MAX_TRANSFORMS = 8
+
 	// Up to 64 transforms can be declared (6 bit index)
 	NONE_TYPE   = uint64(0)  // copy
 	BWT_TYPE    = uint64(1)  // Burrows Wheeler
@@ -44,6 +47,21 @@ const (
 	ROLZX_TYPE  = uint64(12) // ROLZ Extra codec
 	SRT_TYPE    = uint64(13) // Sorted Rank
 	LZP_TYPE    = uint64(14) // Lempel Ziv Predict
+	ST3_TYPE    = uint64(15) // Schindler order 3
+	ST4_TYPE    = uint64(16) // Schindler order 4
+	ST5_TYPE    = uint64(17) // Schindler order 5
+	ST6_TYPE    = uint64(18) // Schindler order 6
+	SHUF_TYPE   = uint64(19) // Byte shuffle of numeric values
+	DNA_TYPE    = uint64(20) // DNA packing
+	JSON_TYPE   = uint64(21) // JSON splitter
+	ARM64_TYPE  = uint64(22) // ARM64 codec
+	RISCV_TYPE  = uint64(23) // RISC-V codec
+	AUDIO_TYPE  = uint64(24) // PCM audio prediction
+	IMAGE_TYPE  = uint64(25) // Image filters
+	DELTA_TYPE  = uint64(26) // Delta coding
+	MTF1_TYPE   = uint64(27) // Move To Front-1
+	MTF2_TYPE   = uint64(28) // Move To Front-2
+	MRLT_TYPE   = uint64(29) // Most frequent symbol Run Length
 )
 
 // NewByteFunction creates a new instance of ByteTransformSequence based on the provided
@@ -113,6 +131,10 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 	case BWTS_TYPE:
 		return transform.NewBWTSWithCtx(ctx)
 
+	case ST3_TYPE, ST4_TYPE, ST5_TYPE, ST6_TYPE:
+		(*ctx)["st"] = uint(functionType-ST3_TYPE) + 3
+		return NewSTBlockCodecWithCtx(ctx)
+
 	case SRT_TYPE:
 		return NewSRTWithCtx(ctx)
 
@@ -124,9 +146,20 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 		(*ctx)["sbrt"] = transform.SBRT_MODE_MTF
 		return transform.NewSBRTWithCtx(ctx)
 
+	case MTF1_TYPE:
+		(*ctx)["sbrt"] = transform.SBRT_MODE_MTF1
+		return transform.NewSBRTWithCtx(ctx)
+
+	case MTF2_TYPE:
+		(*ctx)["sbrt"] = transform.SBRT_MODE_MTF2
+		return transform.NewSBRTWithCtx(ctx)
+
 	case ZRLT_TYPE:
 		return NewZRLTWithCtx(ctx)
 
+	case MRLT_TYPE:
+		return NewMRLTWithCtx(ctx)
+
 	case RLT_TYPE:
 		return NewRLTWithCtx(ctx)
 
@@ -141,14 +174,58 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 	case X86_TYPE:
 		return NewX86CodecWithCtx(ctx)
 
+	case ARM64_TYPE, RISCV_TYPE:
+		(*ctx)["exe"] = functionType
+		return NewEXECodecWithCtx(ctx)
+
+	case DNA_TYPE:
+		return NewDNACodecWithCtx(ctx)
+
+	case AUDIO_TYPE:
+		return NewAudioCodecWithCtx(ctx)
+
+	case IMAGE_TYPE:
+		return NewImageCodecWithCtx(ctx)
+
+	case DELTA_TYPE:
+		return NewDeltaCodecWithCtx(ctx)
+
+	case JSON_TYPE:
+		(*ctx)["textcodec"] = 3
+		return NewTextCodecWithCtx(ctx)
+
+	case SHUF_TYPE:
+		return NewShuffleCodecWithCtx(ctx)
+
 	case NONE_TYPE:
 		return NewNullFunctionWithCtx(ctx)
 
 	default:
+		if t, exists := getRegisteredTransform(functionType); exists == true {
+			return t.factory(*ctx)
+		}
+
 		return nil, fmt.Errorf("Unknown transform type: '%v'", functionType)
 	}
 }
 
+// CanInverseInPlace returns true if every transform of the function type
+// has an inverse that can run in place (see ByteTransformSequence.InverseInPlace)
+func CanInverseInPlace(functionType uint64) bool {
+	for i := uint(0); i < 8; i++ {
+		switch (functionType >> (_BFF_MAX_SHIFT - _BFF_ONE_SHIFT*i)) & _BFF_MASK {
+		case NONE_TYPE, BWT_TYPE, RANK_TYPE, MTFT_TYPE, MTF1_TYPE, MTF2_TYPE,
+			ZRLT_TYPE, MRLT_TYPE, RLT_TYPE:
+			continue
+
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 // GetName transforms the function type into a function name
 func GetName(functionType uint64) string {
 	var s string
@@ -194,9 +271,24 @@ func getByteFunctionNameToken(functionType uint64) string {
 	case BWTS_TYPE:
 		return "BWTS"
 
+	case ST3_TYPE:
+		return "ST3"
+
+	case ST4_TYPE:
+		return "ST4"
+
+	case ST5_TYPE:
+		return "ST5"
+
+	case ST6_TYPE:
+		return "ST6"
+
 	case ZRLT_TYPE:
 		return "ZRLT"
 
+	case MRLT_TYPE:
+		return "MRLT"
+
 	case RLT_TYPE:
 		return "RLT"
 
@@ -209,6 +301,12 @@ func getByteFunctionNameToken(functionType uint64) string {
 	case MTFT_TYPE:
 		return "MTFT"
 
+	case MTF1_TYPE:
+		return "MTF1"
+
+	case MTF2_TYPE:
+		return "MTF2"
+
 	case LZ_TYPE:
 		return "LZ"
 
@@ -218,36 +316,81 @@ func getByteFunctionNameToken(functionType uint64) string {
 	case X86_TYPE:
 		return "X86"
 
+	case ARM64_TYPE:
+		return "ARM64"
+
+	case RISCV_TYPE:
+		return "RISCV"
+
+	case DNA_TYPE:
+		return "DNA"
+
+	case AUDIO_TYPE:
+		return "AUDIO"
+
+	case IMAGE_TYPE:
+		return "IMAGE"
+
+	case DELTA_TYPE:
+		return "DELTA"
+
+	case JSON_TYPE:
+		return "JSON"
+
+	case SHUF_TYPE:
+		return "SHUFFLE"
+
 	case NONE_TYPE:
 		return "NONE"
 
 	default:
+		if t, exists := getRegisteredTransform(functionType); exists == true {
+			return t.name
+		}
+
 		panic(fmt.Errorf("Unknown transform type: '%v'", functionType))
 	}
 }
 
 // GetType transforms the function name into a function type.
 // The returned type contains 8 transform type values (masks).
+// Panics if the name is invalid (see ParseType).
 func GetType(name string) uint64 {
-	if strings.IndexByte(name, byte('+')) < 0 {
-		return getByteFunctionTypeToken(name) << _BFF_MAX_SHIFT
+	res, err := ParseType(name)
+
+	if err != nil {
+		panic(err)
 	}
 
-	tokens := strings.Split(name, "+")
+	return res
+}
 
-	if len(tokens) == 0 {
-		panic(fmt.Errorf("Unknown transform type: '%v'", name))
-	}
+// ParseType transforms the name of a chain of transforms separated by '+'
+// (EG. "RLT+TEXT+BWT+MTFT+ZRLT", case insensitive) into a function type.
+// NONE stages are skipped. Returns an error for an empty or unknown stage
+// and for more than MAX_TRANSFORMS stages.
+func ParseType(name string) (uint64, error) {
+	tokens := strings.Split(name, "+")
 
-	if len(tokens) > 8 {
-		panic(fmt.Errorf("Only 8 transforms allowed: '%v'", name))
+	if len(tokens) > MAX_TRANSFORMS {
+		return 0, fmt.Errorf("Invalid transform chain '%v': %d stages, only %d allowed", name, len(tokens), MAX_TRANSFORMS)
 	}
 
 	res := uint64(0)
 	shift := _BFF_MAX_SHIFT
 
-	for _, token := range tokens {
-		tkType := getByteFunctionTypeToken(token)
+	for i, token := range tokens {
+		token = strings.TrimSpace(token)
+
+		if len(token) == 0 {
+			return 0, fmt.Errorf("Invalid transform chain '%v': stage %d is empty", name, i+1)
+		}
+
+		tkType, err := getByteFunctionTypeToken(token)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid transform chain '%v': unknown transform '%v' (stage %d)", name, token, i+1)
+		}
 
 		// Skip null transform
 		if tkType != NONE_TYPE {
@@ -256,57 +399,125 @@ func GetType(name string) uint64 {
 		}
 	}
 
-	return res
+	return res, nil
 }
 
-func getByteFunctionTypeToken(name string) uint64 {
+// NewChain creates the sequence of the transforms of a chain (EG.
+// "RLT+TEXT+BWT+MTFT+ZRLT", see ParseType) with the default parameters.
+func NewChain(name string) (*ByteTransformSequence, error) {
+	ctx := make(map[string]interface{})
+	return NewChainWithCtx(&ctx, name)
+}
+
+// NewChainWithCtx creates the sequence of the transforms of a chain using
+// a configuration map (EG. "lzWindow") as parameter.
+func NewChainWithCtx(ctx *map[string]interface{}, name string) (*ByteTransformSequence, error) {
+	functionType, err := ParseType(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewByteFunction(ctx, functionType)
+}
+
+func getByteFunctionTypeToken(name string) (uint64, error) {
 	name = strings.ToUpper(name)
 
 	switch name {
 
 	case "TEXT":
-		return DICT_TYPE
+		return DICT_TYPE, nil
 
 	case "BWT":
-		return BWT_TYPE
+		return BWT_TYPE, nil
 
 	case "BWTS":
-		return BWTS_TYPE
+		return BWTS_TYPE, nil
+
+	case "ST3":
+		return ST3_TYPE, nil
+
+	case "ST4":
+		return ST4_TYPE, nil
+
+	case "ST5":
+		return ST5_TYPE, nil
+
+	case "ST6":
+		return ST6_TYPE, nil
 
 	case "ROLZ":
-		return ROLZ_TYPE
+		return ROLZ_TYPE, nil
 
 	case "ROLZX":
-		return ROLZX_TYPE
+		return ROLZX_TYPE, nil
 
 	case "SRT":
-		return SRT_TYPE
+		return SRT_TYPE, nil
 
 	case "RANK":
-		return RANK_TYPE
+		return RANK_TYPE, nil
 
 	case "MTFT":
-		return MTFT_TYPE
+		return MTFT_TYPE, nil
+
+	case "MTF1":
+		return MTF1_TYPE, nil
+
+	case "MTF2":
+		return MTF2_TYPE, nil
 
 	case "ZRLT":
-		return ZRLT_TYPE
+		return ZRLT_TYPE, nil
+
+	case "MRLT":
+		return MRLT_TYPE, nil
 
 	case "RLT":
-		return RLT_TYPE
+		return RLT_TYPE, nil
 
 	case "X86":
-		return X86_TYPE
+		return X86_TYPE, nil
+
+	case "ARM64":
+		return ARM64_TYPE, nil
+
+	case "RISCV":
+		return RISCV_TYPE, nil
+
+	case "DNA":
+		return DNA_TYPE, nil
+
+	case "AUDIO":
+		return AUDIO_TYPE, nil
+
+	case "IMAGE":
+		return IMAGE_TYPE, nil
+
+	case "DELTA":
+		return DELTA_TYPE, nil
+
+	case "JSON":
+		return JSON_TYPE, nil
 
 	case "LZ":
-		return LZ_TYPE
+		return LZ_TYPE, nil
 
 	case "LZP":
-		return LZP_TYPE
+		return LZP_TYPE, nil
+
+	case "SHUFFLE":
+		return SHUF_TYPE, nil
 
 	case "NONE":
-		return NONE_TYPE
+		return NONE_TYPE, nil
 
 	default:
-		panic(fmt.Errorf("Unknown transform type: '%v'", name))
+		if functionType, exists := getRegisteredTransformType(name); exists == true {
+			return functionType, nil
+		}
+
+		return 0, fmt.Errorf("Unknown transform type: '%v'", name)
 	}
 }
@@ -49,6 +49,16 @@ func NewByteTransformSequence(transforms []kanzi.ByteTransform) (*ByteTransformS
 	return this, nil
 }
 
+// Release releases the memory of the transforms that support it (EG. BWT).
+// The sequence must not be used after this call.
+func (this *ByteTransformSequence) Release() {
+	for _, t := range this.transforms {
+		if r, ok := t.(interface{ Release() }); ok == true {
+			r.Release()
+		}
+	}
+}
+
 // Forward applies the function to the src and writes the result
 // to the destination. Runs Forward on each transform in the sequence.
 // Returns number of bytes read, number of bytes
@@ -169,6 +179,53 @@ func (this *ByteTransformSequence) Inverse(src, dst []byte) (uint, uint, error)
 	return blockSize, length, err
 }
 
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Every transform not
+// skipped must implement kanzi.InPlaceByteTransform (see CanInverseInPlace).
+// The block must hold the largest intermediate result plus some slack: RLT,
+// ZRLT and MRLT move their input towards the end of the block so that the
+// output never overwrites unread input. The decoding tasks of the compressed
+// streams use blocks of at least the block size + 1024 bytes and 'length' +
+// 256 bytes. If the input does not fit at the required offset, the transform
+// decodes a copy of its input instead (an allocation of the input size).
+// Returns the number of bytes written and possibly an error.
+func (this *ByteTransformSequence) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length == 0 {
+		return 0, nil
+	}
+
+	if length > uint(len(block)) {
+		return 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(block), length)
+	}
+
+	if this.skipFlags == _TRANSFORM_SKIP_MASK {
+		return length, nil
+	}
+
+	var err error
+
+	// Process transforms sequentially in reverse order
+	for i := this.Len() - 1; i >= 0; i-- {
+		if this.skipFlags&(1<<(7-uint(i))) != 0 {
+			continue
+		}
+
+		t, ok := this.transforms[i].(kanzi.InPlaceByteTransform)
+
+		if ok == false {
+			return 0, errors.New("The transform cannot be inverted in place")
+		}
+
+		// Apply inverse transform
+		if length, err = t.InverseInPlace(block, length); err != nil {
+			// All inverse transforms must succeed
+			break
+		}
+	}
+
+	return length, err
+}
+
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this ByteTransformSequence) MaxEncodedLen(srcLen int) int {
 	requiredSize := srcLen
@@ -0,0 +1,78 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+)
+
+// copyMatch copies 'length' bytes from buf[ref:] to buf[dstIdx:] (ref < dstIdx)
+// with the LZ semantics: when the regions overlap, the bytes just written
+// are read again and the match repeats the last dstIdx-ref bytes. Nothing
+// is written after dstIdx+length.
+// Short distances are first expanded (the pattern is doubled until it
+// spans a word), then the bytes are moved 16 and 8 at a time. Matches
+// without overlap are a single memmove.
+func copyMatch(buf []byte, dstIdx, ref, length int) {
+	end := dstIdx + length
+
+	if ref >= dstIdx {
+		// Not a valid match (corrupted data), the result does not matter
+		for i := 0; i < length; i++ {
+			buf[dstIdx+i] = buf[ref+i]
+		}
+
+		return
+	}
+
+	if dstIdx-ref >= length {
+		copy(buf[dstIdx:end], buf[ref:ref+length])
+		return
+	}
+
+	// buf[ref:dstIdx] is a whole number of periods: appending it extends the
+	// pattern. Keep 'ref' so that the distance remains a multiple of the period.
+	for dstIdx-ref < 8 && dstIdx < end {
+		n := dstIdx - ref
+
+		if n > end-dstIdx {
+			n = end - dstIdx
+		}
+
+		copy(buf[dstIdx:dstIdx+n], buf[ref:ref+n])
+		dstIdx += n
+	}
+
+	// Distance of 8 or more: each word is read after it has been written
+	for end-dstIdx >= 16 {
+		binary.LittleEndian.PutUint64(buf[dstIdx:], binary.LittleEndian.Uint64(buf[ref:]))
+		binary.LittleEndian.PutUint64(buf[dstIdx+8:], binary.LittleEndian.Uint64(buf[ref+8:]))
+		dstIdx += 16
+		ref += 16
+	}
+
+	if end-dstIdx >= 8 {
+		binary.LittleEndian.PutUint64(buf[dstIdx:], binary.LittleEndian.Uint64(buf[ref:]))
+		dstIdx += 8
+		ref += 8
+	}
+
+	for dstIdx < end {
+		buf[dstIdx] = buf[ref]
+		dstIdx++
+		ref++
+	}
+}
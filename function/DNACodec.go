@@ -0,0 +1,372 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DNACodec is a codec that packs the nucleotides (A, C, G and T, upper or
+// lower case) of DNA sequences (raw or FASTA) on 2 bits. The other bytes
+// (line feeds, FASTA headers, runs of N, ...) are escaped and the case of
+// the nucleotides is stored as a list of runs, so that any block made mostly
+// of nucleotides can be packed.
+
+// DNA block format: Header (12 bytes) Nucleotides Escapes Cases
+// Header: number of nucleotides, size of the escapes and size of the cases
+// (32 bits each)
+// Nucleotides: 4 per byte, the first one in the high bits (A=0 C=1 G=2 T=3)
+// Escapes: list of runs of other bytes. Each run is made of the number of
+// nucleotides before the run (since the previous run), then the run length
+// shifted left by 1 with the low bit set for a repeated byte, then the byte
+// (repeated run) or the bytes of the run (all varints except the bytes)
+// Cases: alternate lengths (varints) of the runs of upper and lower case
+// nucleotides, starting with upper case. The last run extends to the end.
+
+const (
+	_DNA_HEADER_SIZE    = 12
+	_DNA_MIN_BLOCK_SIZE = 64
+	_DNA_MIN_REPEAT     = 4    // min length of a run of a repeated escaped byte
+	_DNA_ESCAPE         = 0xFF // code of the bytes that are not nucleotides
+	_DNA_LOWER_CASE     = 4    // bit of the code of lower case nucleotides
+)
+
+var (
+	_DNA_SYMBOLS = []byte("ACGTacgt")
+	_DNA_CODES   = initDNACodes()
+)
+
+func initDNACodes() [256]byte {
+	var codes [256]byte
+
+	for i := range codes {
+		codes[i] = _DNA_ESCAPE
+	}
+
+	for i, c := range _DNA_SYMBOLS {
+		codes[c] = byte(i)
+	}
+
+	return codes
+}
+
+// DNACodec a codec packing DNA sequences
+type DNACodec struct {
+	escapes []byte
+	cases   []byte
+}
+
+// NewDNACodec creates a new instance of DNACodec
+func NewDNACodec() (*DNACodec, error) {
+	this := &DNACodec{}
+	this.escapes = make([]byte, 0)
+	this.cases = make([]byte, 0)
+	return this, nil
+}
+
+// NewDNACodecWithCtx creates a new instance of DNACodec using a
+// configuration map as parameter.
+func NewDNACodecWithCtx(ctx *map[string]interface{}) (*DNACodec, error) {
+	return NewDNACodec()
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the source data is not made mostly
+// of nucleotides, an error is returned.
+func (this *DNACodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	if count < _DNA_MIN_BLOCK_SIZE {
+		return 0, 0, errors.New("Block too small, skip")
+	}
+
+	codes := &_DNA_CODES
+	nucleotides := 0
+
+	for _, c := range src {
+		if codes[c] != _DNA_ESCAPE {
+			nucleotides++
+		}
+	}
+
+	if nucleotides < count/2 {
+		return 0, 0, errors.New("Not a DNA sequence")
+	}
+
+	packed := dst[_DNA_HEADER_SIZE:]
+	escapes := this.escapes[:0]
+	cases := this.cases[:0]
+	nb := 0   // nucleotides packed
+	prev := 0 // nucleotides packed before the previous escaped run
+	lower := byte(0)
+	caseRun := 0
+	val := byte(0)
+	i := 0
+
+	for i < count {
+		code := codes[src[i]]
+
+		if code == _DNA_ESCAPE {
+			j := i + 1
+
+			for j < count && codes[src[j]] == _DNA_ESCAPE {
+				j++
+			}
+
+			escapes = appendDNAEscapes(escapes, src[i:j], nb-prev)
+			prev = nb
+			i = j
+			continue
+		}
+
+		if code&_DNA_LOWER_CASE != lower {
+			cases = appendUvarint(cases, uint64(caseRun))
+			lower ^= _DNA_LOWER_CASE
+			caseRun = 0
+		}
+
+		caseRun++
+		val = (val << 2) | (code & 3)
+		nb++
+
+		if nb&3 == 0 {
+			packed[(nb>>2)-1] = val
+			val = 0
+		}
+
+		i++
+	}
+
+	if nb&3 != 0 {
+		packed[nb>>2] = val << (8 - 2*uint(nb&3))
+	}
+
+	this.escapes = escapes
+	this.cases = cases
+	dstIdx := _DNA_HEADER_SIZE + (nb+3)>>2
+
+	if dstIdx+len(escapes)+len(cases) >= count/2 {
+		return 0, 0, errors.New("Not a DNA sequence or not enough nucleotides")
+	}
+
+	binary.BigEndian.PutUint32(dst[0:], uint32(nb))
+	binary.BigEndian.PutUint32(dst[4:], uint32(len(escapes)))
+	binary.BigEndian.PutUint32(dst[8:], uint32(len(cases)))
+	dstIdx += copy(dst[dstIdx:], escapes)
+	dstIdx += copy(dst[dstIdx:], cases)
+	return uint(count), uint(dstIdx), nil
+}
+
+// appendDNAEscapes appends the runs of escaped bytes of 'run' (bytes that are
+// not nucleotides) following 'gap' nucleotides. Long runs of a repeated
+// byte (EG. N) are coded with the byte only.
+func appendDNAEscapes(escapes, run []byte, gap int) []byte {
+	start := 0
+
+	for start < len(run) {
+		// Bytes before the next run of a repeated byte
+		end := start
+
+		for end < len(run) {
+			next := end
+
+			for next < len(run) && run[next] == run[end] {
+				next++
+			}
+
+			if next-end >= _DNA_MIN_REPEAT {
+				break
+			}
+
+			end = next
+		}
+
+		if end > start {
+			escapes = appendUvarint(escapes, uint64(gap))
+			escapes = appendUvarint(escapes, uint64(end-start)<<1)
+			escapes = append(escapes, run[start:end]...)
+			gap = 0
+		}
+
+		if end < len(run) {
+			next := end
+
+			for next < len(run) && run[next] == run[end] {
+				next++
+			}
+
+			escapes = appendUvarint(escapes, uint64(gap))
+			escapes = appendUvarint(escapes, (uint64(next-end)<<1)|1)
+			escapes = append(escapes, run[end])
+			gap = 0
+			end = next
+		}
+
+		start = end
+	}
+
+	return escapes
+}
+
+func appendUvarint(buf []byte, val uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], val)
+	return append(buf, tmp[0:n]...)
+}
+
+// dnaReader unpacks the nucleotides of a block
+type dnaReader struct {
+	packed   []byte
+	cases    []byte
+	idx      int // index of the next nucleotide
+	lower    byte
+	caseLeft uint64 // nucleotides left in the current case run
+	err      error
+}
+
+func (this *dnaReader) read(dst []byte) {
+	for i := range dst {
+		for this.caseLeft == 0 {
+			this.lower ^= _DNA_LOWER_CASE
+			this.caseLeft = math.MaxUint64
+
+			if len(this.cases) > 0 {
+				val, n := binary.Uvarint(this.cases)
+
+				if n <= 0 {
+					this.err = errors.New("Invalid DNA block: incorrect case runs")
+					return
+				}
+
+				this.caseLeft = val
+				this.cases = this.cases[n:]
+			}
+		}
+
+		this.caseLeft--
+		code := (this.packed[this.idx>>2] >> (6 - 2*uint(this.idx&3))) & 3
+		dst[i] = _DNA_SYMBOLS[code|this.lower]
+		this.idx++
+	}
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *DNACodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	if len(src) < _DNA_HEADER_SIZE {
+		return 0, 0, errors.New("Invalid DNA block: too small")
+	}
+
+	nb := uint64(binary.BigEndian.Uint32(src[0:]))
+	escLen := uint64(binary.BigEndian.Uint32(src[4:]))
+	caseLen := uint64(binary.BigEndian.Uint32(src[8:]))
+	packedLen := (nb + 3) >> 2
+
+	if _DNA_HEADER_SIZE+packedLen+escLen+caseLen != uint64(len(src)) || nb > uint64(len(dst)) {
+		return 0, 0, errors.New("Invalid DNA block: incorrect sizes")
+	}
+
+	srcIdx := _DNA_HEADER_SIZE + int(packedLen)
+	escapes := src[srcIdx : srcIdx+int(escLen)]
+	srcIdx += int(escLen)
+	reader := &dnaReader{packed: src[_DNA_HEADER_SIZE:srcIdx], cases: src[srcIdx:], lower: _DNA_LOWER_CASE}
+	left := int(nb) // nucleotides left
+	dstIdx := 0
+
+	for len(escapes) > 0 {
+		gap, n1 := binary.Uvarint(escapes)
+
+		if n1 <= 0 {
+			return 0, 0, errors.New("Invalid DNA block: incorrect escaped run")
+		}
+
+		val, n2 := binary.Uvarint(escapes[n1:])
+
+		if n2 <= 0 {
+			return 0, 0, errors.New("Invalid DNA block: incorrect escaped run")
+		}
+
+		escapes = escapes[n1+n2:]
+		runLen := val >> 1
+		size := runLen // bytes of the run in the escapes
+
+		if val&1 == 1 {
+			size = 1
+		}
+
+		if gap > uint64(left) || runLen > uint64(len(dst)-dstIdx)-gap || size > uint64(len(escapes)) {
+			return 0, 0, errors.New("Invalid DNA block: incorrect escaped run")
+		}
+
+		reader.read(dst[dstIdx : dstIdx+int(gap)])
+		dstIdx += int(gap)
+		left -= int(gap)
+
+		if val&1 == 1 {
+			for i := dstIdx; i < dstIdx+int(runLen); i++ {
+				dst[i] = escapes[0]
+			}
+		} else {
+			copy(dst[dstIdx:], escapes[0:runLen])
+		}
+
+		escapes = escapes[size:]
+		dstIdx += int(runLen)
+	}
+
+	if left > len(dst)-dstIdx {
+		return 0, 0, errors.New("Invalid DNA block: incorrect sizes")
+	}
+
+	reader.read(dst[dstIdx : dstIdx+left])
+	dstIdx += left
+
+	if reader.err != nil {
+		return 0, 0, reader.err
+	}
+
+	return uint(len(src)), uint(dstIdx), nil
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this DNACodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + _DNA_HEADER_SIZE
+}
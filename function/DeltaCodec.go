@@ -0,0 +1,272 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// DeltaCodec is a codec that replaces the values of a block (unsigned
+// integers of 1, 2, 4 or 8 bytes, little endian) with their difference with a
+// prediction: the previous value (order 1) or the linear extrapolation of
+// the 2 previous values (order 2). The differences are mapped to small
+// unsigned values (zigzag). Alternatively, the values can be xored with the
+// previous value (EG. for floating point values or bit fields).
+// Useful for time series and sensor data (counters, timestamps, samples)
+// before a BWT or an entropy coder.
+// The parameters are given by the "deltaStride" (1, 2, 4 or 8), "deltaOrder"
+// (1 or 2) and "deltaXor" (order 1 only) context entries. Otherwise, they are
+// guessed by estimating the entropy of the output of every mode.
+
+// Delta block format: Mode (1 byte) Residuals Tail
+// Mode: xor (1 bit), order-1 (1 bit), log2(stride) (2 bits) in the 4 low bits
+// Residuals: one per value, same size as the value
+// Tail: the bytes after the last value, unchanged
+
+const (
+	_DELTA_HEADER_SIZE    = 1
+	_DELTA_MIN_BLOCK_SIZE = 256
+	_DELTA_SAMPLE_SIZE    = 1 << 16 // max number of bytes used to guess the mode
+)
+
+// deltaMode parameters of the prediction
+type deltaMode struct {
+	stride int // bytes per value
+	order  int
+	xor    bool
+}
+
+// DeltaCodec a codec for time series
+type DeltaCodec struct {
+	mode deltaMode // stride 0 => guess the mode of each block
+}
+
+// NewDeltaCodec creates a new instance of DeltaCodec
+func NewDeltaCodec() (*DeltaCodec, error) {
+	this := &DeltaCodec{}
+	return this, nil
+}
+
+// NewDeltaCodecWithCtx creates a new instance of DeltaCodec using a
+// configuration map as parameter.
+func NewDeltaCodecWithCtx(ctx *map[string]interface{}) (*DeltaCodec, error) {
+	this := &DeltaCodec{}
+	mode := deltaMode{stride: 1, order: 1}
+	fixed := false
+
+	if val, containsKey := (*ctx)["deltaStride"]; containsKey {
+		mode.stride = int(val.(uint))
+		fixed = true
+	}
+
+	if val, containsKey := (*ctx)["deltaOrder"]; containsKey {
+		mode.order = int(val.(uint))
+		fixed = true
+	}
+
+	if val, containsKey := (*ctx)["deltaXor"]; containsKey {
+		mode.xor = val.(bool)
+		fixed = true
+	}
+
+	if fixed == false {
+		return this, nil
+	}
+
+	if mode.stride != 1 && mode.stride != 2 && mode.stride != 4 && mode.stride != 8 {
+		return nil, fmt.Errorf("Invalid delta stride parameter: %v (must be 1, 2, 4 or 8)", mode.stride)
+	}
+
+	if mode.order < 1 || mode.order > 2 || (mode.xor == true && mode.order != 1) {
+		return nil, fmt.Errorf("Invalid delta order parameter: %v (must be 1 or 2, 1 with xor)", mode.order)
+	}
+
+	this.mode = mode
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the mode is not given and the source
+// data is not predictable enough, an error is returned.
+func (this *DeltaCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	mode := this.mode
+
+	if mode.stride == 0 {
+		if count < _DELTA_MIN_BLOCK_SIZE {
+			return 0, 0, errors.New("Block too small, skip")
+		}
+
+		var ok bool
+
+		if mode, ok = guessDeltaMode(src); ok == false {
+			return 0, 0, errors.New("Not predictable enough")
+		}
+	}
+
+	dst[0] = getDeltaModeByte(mode)
+	applyDelta(src, dst[_DELTA_HEADER_SIZE:], mode, true)
+	return uint(count), uint(count + _DELTA_HEADER_SIZE), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *DeltaCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src) - _DELTA_HEADER_SIZE
+	m := src[0]
+
+	if m&0xF0 != 0 || m&0x0C == 0x0C {
+		return 0, 0, errors.New("Invalid delta block: incorrect header")
+	}
+
+	if len(dst) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	mode := deltaMode{stride: 1 << (m & 3), order: int((m>>2)&1) + 1, xor: m&8 != 0}
+	applyDelta(src[_DELTA_HEADER_SIZE:], dst[0:count], mode, false)
+	return uint(len(src)), uint(count), nil
+}
+
+func getDeltaModeByte(mode deltaMode) byte {
+	m := byte(((mode.order - 1) << 2) | (bits.Len(uint(mode.stride)) - 1))
+
+	if mode.xor == true {
+		m |= 8
+	}
+
+	return m
+}
+
+// applyDelta writes the residuals of the values of 'src' to dst (forward)
+// or the values of the residuals of 'src' (inverse)
+func applyDelta(src, dst []byte, mode deltaMode, forward bool) {
+	stride := mode.stride
+	end := len(src) / stride * stride
+	shift := uint(64 - 8*stride)
+	mask := uint64(0xFFFFFFFFFFFFFFFF) >> shift
+	prev1, prev2 := uint64(0), uint64(0)
+
+	for i := 0; i < end; i += stride {
+		// Read the value (or residual)
+		v := uint64(0)
+
+		for j := stride - 1; j >= 0; j-- {
+			v = (v << 8) | uint64(src[i+j])
+		}
+
+		var r, x uint64
+
+		if mode.xor == true {
+			if forward == true {
+				x = v
+				r = x ^ prev1
+			} else {
+				r = v
+				x = r ^ prev1
+			}
+		} else {
+			pred := prev1
+
+			if mode.order == 2 {
+				pred = 2*prev1 - prev2
+			}
+
+			if forward == true {
+				// Sign extended difference, then zigzag
+				x = v
+				d := int64((x-pred)<<shift) >> shift
+				r = uint64((d<<1)^(d>>63)) & mask
+			} else {
+				r = v
+				x = (pred + ((r >> 1) ^ -(r & 1))) & mask
+			}
+		}
+
+		prev2 = prev1
+		prev1 = x
+
+		if forward == false {
+			r = x
+		}
+
+		for j := 0; j < stride; j++ {
+			dst[i+j] = byte(r >> uint(8*j))
+		}
+	}
+
+	copy(dst[end:], src[end:])
+}
+
+// guessDeltaMode returns the mode giving the smallest order 0 entropy of
+// the residuals of the first bytes of the block. It fails if the entropy is
+// not clearly smaller than the entropy of the block itself.
+func guessDeltaMode(src []byte) (deltaMode, bool) {
+	length := len(src)
+
+	if length > _DELTA_SAMPLE_SIZE {
+		length = _DELTA_SAMPLE_SIZE
+	}
+
+	block := src[0:length]
+	best := deltaMode{}
+	bestCost := math.MaxFloat64
+	buf := make([]byte, length)
+
+	for stride := 1; stride <= 8; stride <<= 1 {
+		for _, mode := range []deltaMode{{stride, 1, false}, {stride, 2, false}, {stride, 1, true}} {
+			applyDelta(block, buf, mode, true)
+
+			if cost := order0Entropy(buf); cost < bestCost {
+				bestCost = cost
+				best = mode
+			}
+		}
+	}
+
+	return best, bestCost < 0.75*order0Entropy(block)
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this DeltaCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + _DELTA_HEADER_SIZE
+}
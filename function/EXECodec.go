@@ -0,0 +1,309 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EXECodec is a codec that replaces relative branch targets with absolute
+// ones in ARM64 or RISC-V code (same idea as the X86Codec): all the calls
+// to a function then share the same bytes. The instruction set is given by
+// the "exe" context entry (ARM64_TYPE or RISCV_TYPE).
+// The bits identifying the rewritten instructions are not changed, so that
+// the decoder finds the same instructions. The size of the block does not
+// change.
+// ARM64: BL (26 bit word offset) and ADRP (21 bit page offset, only when
+// within +/-512 MB) at 4 byte aligned positions. Adapted from the XZ BCJ
+// filters: https://tukaani.org/xz/
+// RISC-V: JAL with rd=ra and AUIPC followed by JALR, ADDI or a load using
+// the register set by AUIPC, at 2 byte aligned positions (compressed
+// instructions).
+
+const (
+	_EXE_ARM64_BL        = 0x25       // opcode of BL (6 high bits)
+	_EXE_ARM64_ADRP      = 0x90000000 // opcode of ADRP
+	_EXE_ARM64_ADRP_MASK = 0x9F000000
+	_EXE_RISCV_JAL_RA    = 0xEF // opcode of JAL with rd=x1 (low byte)
+	_EXE_RISCV_AUIPC     = 0x17
+	_EXE_RISCV_JALR      = 0x67
+	_EXE_RISCV_ADDI      = 0x13
+	_EXE_RISCV_LOAD      = 0x03
+	_EXE_NEAR_CALL       = 1 << 24 // max distance of a call counted by the detection
+)
+
+// EXECodec a codec for ARM64 and RISC-V code
+type EXECodec struct {
+	isa uint64
+}
+
+// NewEXECodec creates a new instance of EXECodec for ARM64 code
+func NewEXECodec() (*EXECodec, error) {
+	this := &EXECodec{}
+	this.isa = ARM64_TYPE
+	return this, nil
+}
+
+// NewEXECodecWithCtx creates a new instance of EXECodec using a
+// configuration map as parameter.
+func NewEXECodecWithCtx(ctx *map[string]interface{}) (*EXECodec, error) {
+	this := &EXECodec{}
+	this.isa = ARM64_TYPE
+
+	if val, containsKey := (*ctx)["exe"]; containsKey {
+		this.isa = val.(uint64)
+	}
+
+	if this.isa != ARM64_TYPE && this.isa != RISCV_TYPE {
+		return nil, fmt.Errorf("Invalid instruction set: %v", this.isa)
+	}
+
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the source data does not represent
+// code of the instruction set, an error is returned.
+func (this *EXECodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if len(dst) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	if this.isa == RISCV_TYPE {
+		if countRISCVCalls(src) < count>>8 {
+			// Number of calls too small => either not a binary or not worth
+			// the change => skip.
+			return 0, 0, errors.New("Not a RISC-V binary or not enough calls")
+		}
+
+		convertRISCV(src, dst, true)
+	} else {
+		if countARM64Calls(src) < count>>7 {
+			return 0, 0, errors.New("Not an ARM64 binary or not enough calls")
+		}
+
+		convertARM64(src, dst, true)
+	}
+
+	return uint(count), uint(count), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *EXECodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if len(dst) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	if this.isa == RISCV_TYPE {
+		convertRISCV(src, dst, false)
+	} else {
+		convertARM64(src, dst, false)
+	}
+
+	return uint(count), uint(count), nil
+}
+
+// countARM64Calls returns the number of BL instructions to a target
+// close enough to be a call of the binary
+func countARM64Calls(src []byte) int {
+	calls := 0
+
+	for i := 0; i+4 <= len(src); i += 4 {
+		instr := binary.LittleEndian.Uint32(src[i:])
+
+		if instr>>26 == _EXE_ARM64_BL {
+			// Sign extended word offset
+			if offset := int32(instr<<6) >> 6; offset > -_EXE_NEAR_CALL>>2 && offset < _EXE_NEAR_CALL>>2 {
+				calls++
+			}
+		}
+	}
+
+	return calls
+}
+
+func convertARM64(src, dst []byte, forward bool) {
+	copy(dst, src)
+	sign := uint32(1)
+
+	if forward == false {
+		sign = 0xFFFFFFFF // -1
+	}
+
+	for i := 0; i+4 <= len(src); i += 4 {
+		instr := binary.LittleEndian.Uint32(src[i:])
+
+		if instr>>26 == _EXE_ARM64_BL {
+			instr = (_EXE_ARM64_BL << 26) | ((instr + sign*uint32(i>>2)) & 0x03FFFFFF)
+		} else if instr&_EXE_ARM64_ADRP_MASK == _EXE_ARM64_ADRP {
+			// immhi (bits 5 to 23) and immlo (bits 29 and 30)
+			page := ((instr >> 29) & 3) | ((instr >> 3) & 0x001FFFFC)
+
+			// Only convert pages within +/-512 MB (sign extended on 18 bits)
+			// so that the decoder recognizes the converted instructions
+			if (page+0x00020000)&0x001C0000 != 0 {
+				continue
+			}
+
+			page += sign * uint32(i>>12)
+			instr &= 0x9000001F
+			instr |= (page & 3) << 29
+			instr |= (page & 0x0003FFFC) << 3
+			instr |= -(page & 0x00020000) & 0x00E00000
+		} else {
+			continue
+		}
+
+		binary.LittleEndian.PutUint32(dst[i:], instr)
+	}
+}
+
+// countRISCVCalls returns the number of JAL ra instructions to a target
+// close enough to be a call of the binary and of AUIPC+JALR pairs
+func countRISCVCalls(src []byte) int {
+	calls := 0
+
+	for i := 0; i+8 <= len(src); i += 2 {
+		if isRISCVJAL(src[i:]) == true {
+			if offset := riscvJALOffset(binary.LittleEndian.Uint32(src[i:])); offset > -_EXE_NEAR_CALL && offset < _EXE_NEAR_CALL {
+				calls++
+			}
+
+			i += 2
+		} else if isRISCVPair(src[i:]) == true {
+			if src[i+4]&0x7F == _EXE_RISCV_JALR {
+				calls++
+			}
+
+			i += 6
+		}
+	}
+
+	return calls
+}
+
+func isRISCVJAL(buf []byte) bool {
+	// rd=x1: bit 7 (in the low byte) set and bits 8 to 11 cleared
+	return buf[0] == _EXE_RISCV_JAL_RA && buf[1]&0x0F == 0
+}
+
+// isRISCVPair returns true for AUIPC rd followed by JALR, ADDI or a load
+// from rd
+func isRISCVPair(buf []byte) bool {
+	if buf[0]&0x7F != _EXE_RISCV_AUIPC {
+		return false
+	}
+
+	auipc := binary.LittleEndian.Uint32(buf)
+	next := binary.LittleEndian.Uint32(buf[4:])
+	rd := (auipc >> 7) & 0x1F
+
+	if rd == 0 || (next>>15)&0x1F != rd {
+		return false
+	}
+
+	opcode := next & 0x7F
+	funct3 := (next >> 12) & 7
+	return opcode == _EXE_RISCV_LOAD || (funct3 == 0 && (opcode == _EXE_RISCV_JALR || opcode == _EXE_RISCV_ADDI))
+}
+
+// riscvJALOffset returns the sign extended offset of a JAL instruction:
+// imm[20|10:1|11|19:12] in bits 31 to 12
+func riscvJALOffset(instr uint32) int32 {
+	imm := ((instr >> 11) & 0x00100000) | ((instr >> 20) & 0x000007FE) |
+		((instr >> 9) & 0x00000800) | (instr & 0x000FF000)
+	return int32(imm<<11) >> 11
+}
+
+func riscvJALBits(imm uint32) uint32 {
+	return ((imm & 0x00100000) << 11) | ((imm & 0x000007FE) << 20) |
+		((imm & 0x00000800) << 9) | (imm & 0x000FF000)
+}
+
+func convertRISCV(src, dst []byte, forward bool) {
+	copy(dst, src)
+
+	for i := 0; i+8 <= len(src); i += 2 {
+		pc := uint32(i)
+
+		if forward == false {
+			pc = -pc
+		}
+
+		if isRISCVJAL(src[i:]) == true {
+			instr := binary.LittleEndian.Uint32(src[i:])
+			target := uint32(riscvJALOffset(instr)) + pc
+			binary.LittleEndian.PutUint32(dst[i:], (instr&0xFFF)|riscvJALBits(target))
+			i += 2
+			continue
+		}
+
+		if isRISCVPair(src[i:]) == false {
+			continue
+		}
+
+		// The pair adds a 32 bit offset to pc: upper 20 bits in AUIPC and
+		// sign extended lower 12 bits in the next instruction. The encoder
+		// stores the target as is (upper and lower bits).
+		auipc := binary.LittleEndian.Uint32(src[i:])
+		next := binary.LittleEndian.Uint32(src[i+4:])
+
+		if forward == true {
+			target := (auipc & 0xFFFFF000) + uint32(int32(next)>>20) + pc
+			auipc = (auipc & 0xFFF) | (target & 0xFFFFF000)
+			next = (next & 0xFFFFF) | (target << 20)
+		} else {
+			offset := ((auipc & 0xFFFFF000) | (next >> 20)) + pc
+			low := uint32(int32(offset<<20) >> 20)
+			auipc = (auipc & 0xFFF) | ((offset - low) & 0xFFFFF000)
+			next = (next & 0xFFFFF) | (offset << 20)
+		}
+
+		binary.LittleEndian.PutUint32(dst[i:], auipc)
+		binary.LittleEndian.PutUint32(dst[i+4:], next)
+		i += 6
+	}
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this EXECodec) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
@@ -0,0 +1,627 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ImageCodec is a codec that applies the PNG filters to the rows of
+// uncompressed raster images: each byte is replaced with the difference with
+// the byte of the previous pixel (Sub), of the previous row (Up), with
+// their average (Average) or with the Paeth predictor, the filter being
+// selected for each row.
+// The size of the pixels and of the rows are read from the header of a BMP,
+// PPM/PGM or TGA file at the start of the block. Otherwise (EG. next blocks
+// of the file), the sizes are guessed by filtering a sample of the block with
+// the distances giving the most similar bytes.
+
+// Image block format: Header (9 bytes) Bytes Filters Rows
+// Header: pixel size (8 bits), row size and index of the first row (32 bits
+// each)
+// Bytes: the bytes before the first row (EG. file header), unchanged
+// Filters: filter of each row (8 bits, 0=None 1=Sub 2=Up 3=Average 4=Paeth)
+// Rows: the filtered rows (the last one may be incomplete)
+
+const (
+	_IMAGE_HEADER_SIZE      = 9
+	_IMAGE_MIN_BLOCK_SIZE   = 1024
+	_IMAGE_MIN_ROW_SIZE     = 16
+	_IMAGE_MAX_ROW_SIZE     = 1 << 15 // max row size found without a header
+	_IMAGE_MAX_PIXEL_SIZE   = 4
+	_IMAGE_GUESS_WINDOW     = 256 // bytes compared by window to find the row size
+	_IMAGE_GUESS_WINDOWS    = 4
+	_IMAGE_GUESS_POSITIONS  = 64      // positions of the windows tested
+	_IMAGE_GUESS_CANDIDATES = 8       // row sizes tested on a sample of the block
+	_IMAGE_GUESS_SAMPLE     = 1 << 16 // min size of the sample
+	_IMAGE_NONE             = 0
+	_IMAGE_SUB              = 1
+	_IMAGE_UP               = 2
+	_IMAGE_AVERAGE          = 3
+	_IMAGE_PAETH            = 4
+)
+
+// imageFormat raster format of a block
+type imageFormat struct {
+	pixelSize int
+	rowSize   int
+	offset    int // index of the first row
+}
+
+// ImageCodec a codec for uncompressed images
+type ImageCodec struct {
+}
+
+// NewImageCodec creates a new instance of ImageCodec
+func NewImageCodec() (*ImageCodec, error) {
+	this := &ImageCodec{}
+	return this, nil
+}
+
+// NewImageCodecWithCtx creates a new instance of ImageCodec using a
+// configuration map as parameter.
+func NewImageCodecWithCtx(ctx *map[string]interface{}) (*ImageCodec, error) {
+	this := &ImageCodec{}
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the source data does not look like
+// an image, an error is returned.
+func (this *ImageCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	if count < _IMAGE_MIN_BLOCK_SIZE {
+		return 0, 0, errors.New("Block too small, skip")
+	}
+
+	format, ok := readImageHeader(src)
+
+	if ok == false {
+		if format, ok = guessImageFormat(src); ok == false {
+			return 0, 0, errors.New("Not an image or not predictable enough")
+		}
+	}
+
+	rows := (count - format.offset + format.rowSize - 1) / format.rowSize
+	dst[0] = byte(format.pixelSize)
+	binary.BigEndian.PutUint32(dst[1:], uint32(format.rowSize))
+	binary.BigEndian.PutUint32(dst[5:], uint32(format.offset))
+	dstIdx := _IMAGE_HEADER_SIZE
+	dstIdx += copy(dst[dstIdx:], src[0:format.offset])
+	filters := dst[dstIdx : dstIdx+rows]
+	dstIdx += rows
+	filterImage(src[format.offset:], dst[dstIdx:dstIdx+count-format.offset], filters, format)
+	return uint(count), uint(dstIdx + count - format.offset), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *ImageCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	if len(src) < _IMAGE_HEADER_SIZE {
+		return 0, 0, errors.New("Invalid image block: too small")
+	}
+
+	format := imageFormat{}
+	format.pixelSize = int(src[0])
+	rowSize := uint64(binary.BigEndian.Uint32(src[1:]))
+	offset := uint64(binary.BigEndian.Uint32(src[5:]))
+	srcLen := uint64(len(src) - _IMAGE_HEADER_SIZE)
+
+	if format.pixelSize < 1 || format.pixelSize > _IMAGE_MAX_PIXEL_SIZE ||
+		rowSize < _IMAGE_MIN_ROW_SIZE || offset > srcLen {
+		return 0, 0, errors.New("Invalid image block: incorrect header")
+	}
+
+	// Each row of n bytes takes n+1 bytes (filter)
+	rows := (srcLen - offset + rowSize) / (rowSize + 1)
+	count := srcLen - rows
+
+	if rows > 0 && (count-offset+rowSize-1)/rowSize != rows {
+		return 0, 0, errors.New("Invalid image block: incorrect size")
+	}
+
+	if uint64(len(dst)) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	format.rowSize = int(rowSize)
+	format.offset = int(offset)
+	src = src[_IMAGE_HEADER_SIZE:]
+	copy(dst, src[0:offset])
+	filters := src[offset : offset+rows]
+
+	if err := unfilterImage(src[offset+rows:], dst[offset:count], filters, format); err != nil {
+		return 0, 0, err
+	}
+
+	return uint(len(src) + _IMAGE_HEADER_SIZE), uint(count), nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := p - int(a)
+	pb := p - int(b)
+	pc := p - int(c)
+
+	if pa < 0 {
+		pa = -pa
+	}
+
+	if pb < 0 {
+		pb = -pb
+	}
+
+	if pc < 0 {
+		pc = -pc
+	}
+
+	if pa <= pb && pa <= pc {
+		return a
+	}
+
+	if pb <= pc {
+		return b
+	}
+
+	return c
+}
+
+// imagePrediction returns the prediction of a byte by a filter from the
+// bytes on the left (a), above (b) and above left (c)
+func imagePrediction(filter, a, b, c byte) byte {
+	switch filter {
+	case _IMAGE_SUB:
+		return a
+
+	case _IMAGE_UP:
+		return b
+
+	case _IMAGE_AVERAGE:
+		return byte((int(a) + int(b)) >> 1)
+
+	case _IMAGE_PAETH:
+		return paeth(a, b, c)
+	}
+
+	return 0
+}
+
+// filterImage writes the rows of 'src' filtered to dst and the filter of
+// each row to 'filters'. The filter of a row is the one giving the smallest
+// differences.
+func filterImage(src, dst, filters []byte, format imageFormat) {
+	pixelSize := format.pixelSize
+	rowSize := format.rowSize
+
+	for r := range filters {
+		start := r * rowSize
+		end := start + rowSize
+
+		if end > len(src) {
+			end = len(src)
+		}
+
+		var sums [5]int
+
+		for i := start; i < end; i++ {
+			var a, b, c byte
+
+			if i-pixelSize >= start {
+				a = src[i-pixelSize]
+			}
+
+			if start > 0 {
+				b = src[i-rowSize]
+
+				if i-pixelSize >= start {
+					c = src[i-rowSize-pixelSize]
+				}
+			}
+
+			for f := range sums {
+				d := int(int8(src[i] - imagePrediction(byte(f), a, b, c)))
+
+				if d < 0 {
+					d = -d
+				}
+
+				sums[f] += d
+			}
+		}
+
+		best := byte(_IMAGE_NONE)
+
+		for f := range sums {
+			if sums[f] < sums[best] {
+				best = byte(f)
+			}
+		}
+
+		filters[r] = best
+
+		for i := start; i < end; i++ {
+			var a, b, c byte
+
+			if i-pixelSize >= start {
+				a = src[i-pixelSize]
+			}
+
+			if start > 0 {
+				b = src[i-rowSize]
+
+				if i-pixelSize >= start {
+					c = src[i-rowSize-pixelSize]
+				}
+			}
+
+			dst[i] = src[i] - imagePrediction(best, a, b, c)
+		}
+	}
+}
+
+// unfilterImage writes the rows of 'src' unfiltered to dst
+func unfilterImage(src, dst, filters []byte, format imageFormat) error {
+	pixelSize := format.pixelSize
+	rowSize := format.rowSize
+
+	for r, filter := range filters {
+		if filter > _IMAGE_PAETH {
+			return errors.New("Invalid image block: incorrect filter")
+		}
+
+		start := r * rowSize
+		end := start + rowSize
+
+		if end > len(src) {
+			end = len(src)
+		}
+
+		for i := start; i < end; i++ {
+			var a, b, c byte
+
+			if i-pixelSize >= start {
+				a = dst[i-pixelSize]
+			}
+
+			if start > 0 {
+				b = dst[i-rowSize]
+
+				if i-pixelSize >= start {
+					c = dst[i-rowSize-pixelSize]
+				}
+			}
+
+			dst[i] = src[i] + imagePrediction(filter, a, b, c)
+		}
+	}
+
+	return nil
+}
+
+// readImageHeader returns the format of the pixels of a block starting with
+// the header of an uncompressed BMP, PPM/PGM or TGA file
+func readImageHeader(src []byte) (imageFormat, bool) {
+	format, ok := readBMPHeader(src)
+
+	if ok == false {
+		format, ok = readPPMHeader(src)
+	}
+
+	if ok == false {
+		format, ok = readTGAHeader(src)
+	}
+
+	if ok == false || format.rowSize < _IMAGE_MIN_ROW_SIZE || format.offset >= len(src) {
+		return format, false
+	}
+
+	return format, true
+}
+
+func readBMPHeader(src []byte) (imageFormat, bool) {
+	format := imageFormat{}
+
+	if len(src) < 54 || src[0] != 'B' || src[1] != 'M' {
+		return format, false
+	}
+
+	dibSize := binary.LittleEndian.Uint32(src[14:])
+	width := int64(int32(binary.LittleEndian.Uint32(src[18:])))
+	planes := binary.LittleEndian.Uint16(src[26:])
+	bpp := int64(binary.LittleEndian.Uint16(src[28:]))
+	compression := binary.LittleEndian.Uint32(src[30:])
+
+	// Only uncompressed pixels (or 32 bit bit fields) of 1 to 4 bytes
+	if dibSize < 40 || width <= 0 || width > 1<<20 || planes != 1 ||
+		(bpp != 8 && bpp != 24 && bpp != 32) || (compression != 0 && (compression != 3 || bpp != 32)) {
+		return format, false
+	}
+
+	format.pixelSize = int(bpp >> 3)
+	format.rowSize = int((width*bpp + 31) / 32 * 4)
+	format.offset = int(binary.LittleEndian.Uint32(src[10:]) & 0x7FFFFFFF)
+	return format, true
+}
+
+func readPPMHeader(src []byte) (imageFormat, bool) {
+	format := imageFormat{}
+
+	if len(src) < 16 || src[0] != 'P' || (src[1] != '5' && src[1] != '6') {
+		return format, false
+	}
+
+	// Width, height and max value: decimal numbers separated by white spaces
+	// or comments
+	var vals [3]int
+	idx := 2
+
+	for n := range vals {
+		for idx < len(src) && (isImageSpace(src[idx]) == true || src[idx] == '#') {
+			if src[idx] == '#' {
+				for idx < len(src) && src[idx] != '\n' {
+					idx++
+				}
+			} else {
+				idx++
+			}
+		}
+
+		start := idx
+
+		for idx < len(src) && idx-start < 8 && src[idx] >= '0' && src[idx] <= '9' {
+			vals[n] = 10*vals[n] + int(src[idx]-'0')
+			idx++
+		}
+
+		if idx == start || idx >= len(src) || isImageSpace(src[idx]) == false {
+			return format, false
+		}
+	}
+
+	// Only 8 bit values
+	if vals[0] == 0 || vals[1] == 0 || vals[2] == 0 || vals[2] > 255 {
+		return format, false
+	}
+
+	format.pixelSize = 1
+
+	if src[1] == '6' {
+		format.pixelSize = 3
+	}
+
+	format.rowSize = vals[0] * format.pixelSize
+	format.offset = idx + 1 // single white space after the max value
+	return format, true
+}
+
+func isImageSpace(val byte) bool {
+	return val == ' ' || val == '\t' || val == '\n' || val == '\r'
+}
+
+func readTGAHeader(src []byte) (imageFormat, bool) {
+	format := imageFormat{}
+
+	if len(src) < 18 {
+		return format, false
+	}
+
+	// No magic number: check that the fields of the header are consistent.
+	// Only uncompressed true color (type 2) and gray (type 3) images,
+	// without color map.
+	imageType := src[2]
+	depth := src[16]
+	alphaBits := src[17] & 0x0F
+
+	if src[1] != 0 || (imageType != 2 && imageType != 3) || src[17]&0xC0 != 0 {
+		return format, false
+	}
+
+	for i := 3; i < 8; i++ {
+		if src[i] != 0 {
+			return format, false
+		}
+	}
+
+	if imageType == 3 && (depth != 8 || alphaBits != 0) {
+		return format, false
+	}
+
+	if imageType == 2 && !(depth == 24 && alphaBits == 0) && !(depth == 32 && (alphaBits == 0 || alphaBits == 8)) {
+		return format, false
+	}
+
+	width := int(binary.LittleEndian.Uint16(src[12:]))
+	height := int(binary.LittleEndian.Uint16(src[14:]))
+
+	if width == 0 || height == 0 {
+		return format, false
+	}
+
+	format.pixelSize = int(depth >> 3)
+	format.rowSize = width * format.pixelSize
+	format.offset = 18 + int(src[0]) // after the image id
+	return format, true
+}
+
+// guessImageFormat returns the format (pixel and row sizes) giving the
+// smallest filtered bytes in the middle of the block. The candidate row sizes
+// are the distances with the most similar bytes. It fails if the filtered
+// bytes are not smaller than the block itself (order 0 entropy).
+func guessImageFormat(src []byte) (imageFormat, bool) {
+	count := len(src)
+	maxRowSize := _IMAGE_MAX_ROW_SIZE
+
+	if maxRowSize > count/_IMAGE_GUESS_WINDOWS-_IMAGE_GUESS_WINDOW {
+		maxRowSize = count/_IMAGE_GUESS_WINDOWS - _IMAGE_GUESS_WINDOW
+	}
+
+	if maxRowSize < _IMAGE_MIN_ROW_SIZE {
+		return imageFormat{}, false
+	}
+
+	// Select the windows with the most differences between consecutive bytes
+	// (flat areas are similar at any distance)
+	var windows, activities [_IMAGE_GUESS_WINDOWS]int
+	step := (count - maxRowSize) / _IMAGE_GUESS_POSITIONS
+
+	for i := range activities {
+		activities[i] = -1
+	}
+
+	for pos := 0; pos < _IMAGE_GUESS_POSITIONS; pos++ {
+		start := maxRowSize + pos*step
+
+		if start+_IMAGE_GUESS_WINDOW > count {
+			break
+		}
+
+		activity := 0
+
+		for i := start; i < start+_IMAGE_GUESS_WINDOW; i++ {
+			if src[i] != src[i-1] {
+				activity++
+			}
+		}
+
+		for w := range windows {
+			if activity > activities[w] {
+				copy(activities[w+1:], activities[w:])
+				copy(windows[w+1:], windows[w:])
+				activities[w] = activity
+				windows[w] = start
+				break
+			}
+		}
+	}
+
+	// Keep the distances with the smallest differences (sorted)
+	var candidates, sums [_IMAGE_GUESS_CANDIDATES]int
+
+	for i := range sums {
+		sums[i] = -1
+	}
+
+	for dist := _IMAGE_MIN_ROW_SIZE; dist <= maxRowSize; dist++ {
+		sum := 0
+
+		for _, start := range windows {
+			for i := start; i < start+_IMAGE_GUESS_WINDOW; i++ {
+				d := int(src[i]) - int(src[i-dist])
+
+				if d < 0 {
+					d = -d
+				}
+
+				sum += d
+			}
+		}
+
+		n := len(sums)
+
+		for n > 0 && (sums[n-1] < 0 || sum < sums[n-1]) {
+			n--
+		}
+
+		if n < len(sums) {
+			copy(sums[n+1:], sums[n:])
+			copy(candidates[n+1:], candidates[n:])
+			sums[n] = sum
+			candidates[n] = dist
+		}
+	}
+
+	// Filter a sample in the middle of the block with each candidate
+	sampleSize := 4 * candidates[0]
+
+	for _, rowSize := range candidates {
+		if sampleSize < 4*rowSize {
+			sampleSize = 4 * rowSize
+		}
+	}
+
+	if sampleSize < _IMAGE_GUESS_SAMPLE {
+		sampleSize = _IMAGE_GUESS_SAMPLE
+	}
+
+	if sampleSize > count {
+		sampleSize = count
+	}
+
+	sample := src[(count-sampleSize)/2 : (count+sampleSize)/2]
+	buf := make([]byte, len(sample))
+	filters := make([]byte, len(sample)/_IMAGE_MIN_ROW_SIZE+1)
+	best := imageFormat{}
+	bestCost := 8.0
+
+	test := func(format imageFormat) {
+		rows := (len(sample) + format.rowSize - 1) / format.rowSize
+		filterImage(sample, buf, filters[0:rows], format)
+
+		// The first row is not predicted from a previous row
+		if cost := order0Entropy(buf[format.rowSize:]); cost < bestCost {
+			bestCost = cost
+			best = format
+		}
+	}
+
+	// Find the row size with RGB pixels, then the pixel size
+	for _, rowSize := range candidates {
+		if rowSize != 0 {
+			test(imageFormat{pixelSize: 3, rowSize: rowSize})
+		}
+	}
+
+	rowSize := best.rowSize
+
+	for pixelSize := 1; rowSize != 0 && pixelSize <= _IMAGE_MAX_PIXEL_SIZE; pixelSize++ {
+		if pixelSize != 3 {
+			test(imageFormat{pixelSize: pixelSize, rowSize: rowSize})
+		}
+	}
+
+	return best, best.rowSize != 0 && bestCost < 0.8*order0Entropy(sample)
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this ImageCodec) MaxEncodedLen(srcLen int) int {
+	// One filter byte per row
+	return srcLen + (srcLen+_IMAGE_MIN_ROW_SIZE-1)/_IMAGE_MIN_ROW_SIZE + _IMAGE_HEADER_SIZE
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// JSON mode of the TextCodec ("textcodec" context entry set to 3, JSON
+// transform): the block is split into 4 streams, the structure (punctuation,
+// white spaces, true/false/null and a token in place of each key, string
+// and number), the keys, the string values and the numbers. Each stream is
+// then made of similar symbols: the entropy coder (or the next transforms)
+// sees the keys of the records one after the other, the numbers together...
+// Any block can be coded: a block cut in the middle of a string is only
+// compressed a little worse.
+
+// JSON block format: Header (16 bytes) Structure Keys Strings Numbers
+// Header: size of each stream (32 bits each)
+// Structure: bytes of the block outside of the strings and numbers, with a
+// token for each key, string and number. Bytes with the value of a token are
+// escaped.
+// Keys, Strings: content of each string (escape sequences unchanged) followed
+// by the closing quote
+// Numbers: text of each number (of 3 bytes or more, shorter ones stay in the
+// structure) followed by a line feed
+
+const (
+	_JSON_HEADER_SIZE   = 16
+	_JSON_ESCAPE_TOKEN  = byte(0x00)
+	_JSON_KEY_TOKEN     = byte(0x01)
+	_JSON_STRING_TOKEN  = byte(0x02)
+	_JSON_NUMBER_TOKEN  = byte(0x03)
+	_JSON_BYTES_PER_KEY = 128 // max number of bytes per key of a JSON block
+	_JSON_MIN_NUMBER    = 3   // min length of a number moved to the numbers
+)
+
+type jsonCodec struct {
+	structure []byte
+	keys      []byte
+	strings   []byte
+	numbers   []byte
+}
+
+func newJSONCodec() (*jsonCodec, error) {
+	this := &jsonCodec{}
+	this.structure = make([]byte, 0)
+	this.keys = make([]byte, 0)
+	this.strings = make([]byte, 0)
+	this.numbers = make([]byte, 0)
+	return this, nil
+}
+
+func isJSONNumber(val byte) bool {
+	return (val >= '0' && val <= '9') || val == '-' || val == '+' || val == '.' || val == 'e' || val == 'E'
+}
+
+func isJSONSpace(val byte) bool {
+	return val == ' ' || val == '\t' || val == LF || val == CR
+}
+
+// jsonStringEnd returns the index of the quote closing the string starting
+// at 'start' (after the opening quote) or -1
+func jsonStringEnd(buf []byte, start int) int {
+	for i := start; i < len(buf); i++ {
+		if buf[i] == '\\' {
+			i++
+		} else if buf[i] == '"' {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (this *jsonCodec) Forward(src, dst []byte) (uint, uint, error) {
+	count := len(src)
+	structure := this.structure[:0]
+	keys := this.keys[:0]
+	strs := this.strings[:0]
+	numbers := this.numbers[:0]
+	nbKeys := 0
+	i := 0
+
+	for i < count {
+		c := src[i]
+
+		if c == '"' {
+			end := jsonStringEnd(src, i+1)
+
+			if end >= 0 {
+				// A key is followed by a colon
+				j := end + 1
+
+				for j < count && isJSONSpace(src[j]) {
+					j++
+				}
+
+				if j < count && src[j] == ':' {
+					structure = append(structure, _JSON_KEY_TOKEN)
+					keys = append(keys, src[i+1:end+1]...)
+					nbKeys++
+				} else {
+					structure = append(structure, _JSON_STRING_TOKEN)
+					strs = append(strs, src[i+1:end+1]...)
+				}
+
+				i = end + 1
+				continue
+			}
+		} else if (c >= '0' && c <= '9') || c == '-' {
+			j := i + 1
+
+			for j < count && isJSONNumber(src[j]) {
+				j++
+			}
+
+			if j-i < _JSON_MIN_NUMBER {
+				// Short numbers are cheaper in the structure
+				structure = append(structure, src[i:j]...)
+				i = j
+				continue
+			}
+
+			structure = append(structure, _JSON_NUMBER_TOKEN)
+			numbers = append(numbers, src[i:j]...)
+			numbers = append(numbers, LF)
+			i = j
+			continue
+		}
+
+		if c <= _JSON_NUMBER_TOKEN {
+			structure = append(structure, _JSON_ESCAPE_TOKEN)
+		}
+
+		structure = append(structure, c)
+		i++
+	}
+
+	this.structure = structure
+	this.keys = keys
+	this.strings = strs
+	this.numbers = numbers
+
+	if nbKeys*_JSON_BYTES_PER_KEY < count {
+		return 0, 0, errors.New("JSON transform: not a JSON block")
+	}
+
+	dstIdx := _JSON_HEADER_SIZE + len(structure) + len(keys) + len(strs) + len(numbers)
+
+	if dstIdx > len(dst) {
+		return 0, 0, fmt.Errorf("JSON transform: Output buffer is too small - size: %d, required %d", len(dst), dstIdx)
+	}
+
+	binary.BigEndian.PutUint32(dst[0:], uint32(len(structure)))
+	binary.BigEndian.PutUint32(dst[4:], uint32(len(keys)))
+	binary.BigEndian.PutUint32(dst[8:], uint32(len(strs)))
+	binary.BigEndian.PutUint32(dst[12:], uint32(len(numbers)))
+	dstIdx = _JSON_HEADER_SIZE
+	dstIdx += copy(dst[dstIdx:], structure)
+	dstIdx += copy(dst[dstIdx:], keys)
+	dstIdx += copy(dst[dstIdx:], strs)
+	dstIdx += copy(dst[dstIdx:], numbers)
+	return uint(count), uint(dstIdx), nil
+}
+
+func (this *jsonCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) < _JSON_HEADER_SIZE {
+		return 0, 0, errors.New("JSON transform: Invalid input data")
+	}
+
+	var sizes [4]uint64
+	total := uint64(_JSON_HEADER_SIZE)
+
+	for i := range sizes {
+		sizes[i] = uint64(binary.BigEndian.Uint32(src[4*i:]))
+		total += sizes[i]
+	}
+
+	if total != uint64(len(src)) {
+		return 0, 0, errors.New("JSON transform: Invalid input data")
+	}
+
+	srcIdx := _JSON_HEADER_SIZE
+	var streams [4][]byte
+
+	for i := range streams {
+		streams[i] = src[srcIdx : srcIdx+int(sizes[i])]
+		srcIdx += int(sizes[i])
+	}
+
+	structure := streams[0]
+	dstIdx := 0
+	var err error
+
+	for i := 0; i < len(structure); i++ {
+		c := structure[i]
+
+		switch c {
+		case _JSON_ESCAPE_TOKEN:
+			i++
+
+			if i == len(structure) {
+				err = errors.New("JSON transform: Invalid input data")
+				break
+			}
+
+			c = structure[i]
+
+		case _JSON_KEY_TOKEN, _JSON_STRING_TOKEN:
+			s := &streams[c]
+			end := jsonStringEnd(*s, 0)
+
+			if end < 0 || dstIdx+end+2 > len(dst) {
+				err = errors.New("JSON transform: Invalid input data")
+				break
+			}
+
+			dst[dstIdx] = '"'
+			dstIdx++
+			dstIdx += copy(dst[dstIdx:], (*s)[0:end+1])
+			*s = (*s)[end+1:]
+			continue
+
+		case _JSON_NUMBER_TOKEN:
+			s := &streams[3]
+			end := 0
+
+			for end < len(*s) && (*s)[end] != LF {
+				end++
+			}
+
+			if end == len(*s) || dstIdx+end > len(dst) {
+				err = errors.New("JSON transform: Invalid input data")
+				break
+			}
+
+			dstIdx += copy(dst[dstIdx:], (*s)[0:end])
+			*s = (*s)[end+1:]
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+
+		if dstIdx >= len(dst) {
+			err = errors.New("JSON transform: Invalid input data")
+			break
+		}
+
+		dst[dstIdx] = c
+		dstIdx++
+	}
+
+	if err == nil && (len(streams[1]) != 0 || len(streams[2]) != 0 || len(streams[3]) != 0) {
+		err = errors.New("JSON transform: Invalid input data")
+	}
+
+	return uint(len(src)), uint(dstIdx), err
+}
+
+func (this jsonCodec) MaxEncodedLen(srcLen int) int {
+	// Limit to 1 x srcLength + 1/8 (the numbers and escaped bytes grow) and
+	// let the caller deal with a failure when the output is too small
+	return srcLen + srcLen/8 + _JSON_HEADER_SIZE
+}
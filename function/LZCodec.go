@@ -19,6 +19,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/bits"
 
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -38,6 +39,36 @@ const (
 	_LZP_MIN_MATCH          = 64
 	_LZP_MIN_LENGTH         = 128
 	_LZP_MATCH_FLAG         = 0xFC
+
+	// LZ_MAX_DICTIONARY_SIZE is the max size of a preset dictionary provided
+	// with the "lzDictionary" context entry (only the last bytes are used)
+	LZ_MAX_DICTIONARY_SIZE = _LZX_MAX_DISTANCE1
+
+	// LZ_MIN_WINDOW_SIZE is the min window size of the LZ codec ("lzWindow")
+	LZ_MIN_WINDOW_SIZE = 1 << 16
+
+	// LZ_MAX_WINDOW_SIZE is the max window size of the LZ codec ("lzWindow")
+	LZ_MAX_WINDOW_SIZE = 1 << 28
+
+	// LZ_MAX_CHAIN_LENGTH is the max number of match candidates checked per
+	// position by the LZ codec ("lzChainLength")
+	LZ_MAX_CHAIN_LENGTH = 4096
+
+	// LZ_MAX_LAZY_DEPTH is the max number of positions after a match checked
+	// for a longer match by the LZ codec ("lzLazyDepth")
+	LZ_MAX_LAZY_DEPTH = 4
+
+	// Header byte of a block with a window set by "lzWindow"
+	_LZX_WINDOW_FLAG = 0x80
+
+	// Header bit of a block with repeat codes (see LZXCodec)
+	_LZX_REPEAT_FLAG = 0x40
+
+	// First distance bytes of the repeat codes (last 3 distances)
+	_LZX_REPEAT_CODE = 0xFD
+
+	// Distances from this value (0xFD00) use the long form with repeat codes
+	_LZX_REPEAT_LONG = _LZX_REPEAT_CODE << 8
 )
 
 type LZCodec struct {
@@ -115,14 +146,41 @@ func (this *LZCodec) Inverse(src, dst []byte) (uint, uint, error) {
 // LZXCodec Simple byte oriented LZ77 implementation.
 // It is a modified LZ4 with a bigger window, a bigger hash map, 3+n*8 bit
 // literal lengths and 17 or 24 bit match lengths.
+// A preset dictionary can be provided with the "lzDictionary" context entry
+// ([]byte). Matches can then refer to the dictionary as if it preceded
+// the block. The same dictionary must be provided to the decoder. Streams
+// of format version 9 or older have no preset dictionary.
+// The search can be tuned with the "lzWindow" (max distance, a power of 2 in
+// [LZ_MIN_WINDOW_SIZE..LZ_MAX_WINDOW_SIZE], by default 128 KB or 16 MB based on
+// the block size), "lzChainLength" (match candidates per position, default 1)
+// and "lzLazyDepth" (positions checked for a longer match after a match,
+// default 0) context entries (uint). The window is recorded in the first byte
+// of the block: 0 (128 KB), 1 (16 MB) or 0x80 + log2(window) when set.
+// The codec keeps the last 3 match distances: a match at one of them is coded
+// with one distance byte (0xFD to 0xFF) instead of 2 to 4 (the long form, flag
+// set in the token, is then used from distance 0xFD00). The header bit 0x40
+// marks these blocks. Repeat codes are enabled by default, except in streams
+// of format version 9 or older ("bsVersion" context entry) which cannot
+// contain them. The "lzRepeat" context entry (bool) overrides the default.
 type LZXCodec struct {
-	hashes []int32
+	hashes      []int32
+	chain       []int32 // previous position with the same hash (if chainLength > 1)
+	chainMask   int
+	dict        []byte
+	buffer      []byte
+	window      int // 0 means based on the block size
+	chainLength int
+	lazyDepth   int
+	repeat      bool
+	legacy      bool // stream format version 9 or older: no repeat codes
 }
 
 // NewLZXCodec creates a new instance of LZXCodec
 func NewLZXCodec() (*LZXCodec, error) {
 	this := &LZXCodec{}
 	this.hashes = make([]int32, 0)
+	this.chainLength = 1
+	this.repeat = true
 	return this, nil
 }
 
@@ -131,9 +189,83 @@ func NewLZXCodec() (*LZXCodec, error) {
 func NewLZXCodecWithCtx(ctx *map[string]interface{}) (*LZXCodec, error) {
 	this := &LZXCodec{}
 	this.hashes = make([]int32, 0)
+	this.buffer = make([]byte, 0)
+	this.chainLength = 1
+
+	if val, containsKey := (*ctx)["bsVersion"]; containsKey {
+		this.legacy = val.(uint) < 10
+	}
+
+	this.repeat = this.legacy == false
+
+	if val, containsKey := (*ctx)["lzRepeat"]; containsKey {
+		this.repeat = val.(bool)
+
+		if this.repeat == true && this.legacy == true {
+			return nil, fmt.Errorf("LZCodec: Repeat codes require stream format version 10 (got %v)", (*ctx)["bsVersion"])
+		}
+	}
+
+	if val, containsKey := (*ctx)["lzWindow"]; containsKey {
+		window := val.(uint)
+
+		if window < LZ_MIN_WINDOW_SIZE || window > LZ_MAX_WINDOW_SIZE || window&(window-1) != 0 {
+			return nil, fmt.Errorf("LZCodec: Invalid window size: %v (must be a power of 2 in [%d..%d])",
+				window, LZ_MIN_WINDOW_SIZE, LZ_MAX_WINDOW_SIZE)
+		}
+
+		this.window = int(window)
+	}
+
+	if val, containsKey := (*ctx)["lzChainLength"]; containsKey {
+		chainLength := val.(uint)
+
+		if chainLength < 1 || chainLength > LZ_MAX_CHAIN_LENGTH {
+			return nil, fmt.Errorf("LZCodec: Invalid chain length: %v (must be in [1..%d])",
+				chainLength, LZ_MAX_CHAIN_LENGTH)
+		}
+
+		this.chainLength = int(chainLength)
+	}
+
+	if val, containsKey := (*ctx)["lzLazyDepth"]; containsKey {
+		lazyDepth := val.(uint)
+
+		if lazyDepth > LZ_MAX_LAZY_DEPTH {
+			return nil, fmt.Errorf("LZCodec: Invalid lazy matching depth: %v (must be in [0..%d])",
+				lazyDepth, LZ_MAX_LAZY_DEPTH)
+		}
+
+		this.lazyDepth = int(lazyDepth)
+	}
+
+	if val, containsKey := (*ctx)["lzDictionary"]; containsKey {
+		if this.legacy == true {
+			return nil, fmt.Errorf("LZCodec: A preset dictionary requires stream format version 10 (got %v)", (*ctx)["bsVersion"])
+		}
+
+		dict := val.([]byte)
+
+		if len(dict) > LZ_MAX_DICTIONARY_SIZE {
+			dict = dict[len(dict)-LZ_MAX_DICTIONARY_SIZE:]
+		}
+
+		this.dict = dict
+	}
+
 	return this, nil
 }
 
+// Return a buffer with the dictionary followed by room for 'count' bytes
+func (this *LZXCodec) withDictionary(count int) []byte {
+	if len(this.buffer) < len(this.dict)+count {
+		this.buffer = make([]byte, len(this.dict)+count)
+	}
+
+	copy(this.buffer, this.dict)
+	return this.buffer[0 : len(this.dict)+count]
+}
+
 func emitLength(buf []byte, length int) int {
 	idx := 0
 
@@ -174,6 +306,165 @@ func lzhash(p []byte) uint32 {
 	return uint32((binary.LittleEndian.Uint64(p)*_LZ_HASH_SEED)>>_LZX_HASH_SHIFT) & _LZX_HASH_MASK
 }
 
+// Return the number of distance bytes after the first 2 ones (for distances
+// above 0xFFFF) and the max distance for the header byte of a block
+func lzDistanceBytes(mode byte) (int, int, error) {
+	if mode&_LZX_REPEAT_FLAG != 0 {
+		distBytes, maxDist, err := lzDistanceBytes(mode &^ _LZX_REPEAT_FLAG)
+
+		// The long form codes the distance minus _LZX_REPEAT_LONG
+		if distBytes == 0 && err == nil {
+			maxDist = _LZX_REPEAT_LONG + 0xFFFF
+		}
+
+		return distBytes, maxDist, err
+	}
+
+	switch {
+	case mode == 0:
+		// The token flag is the 17th bit of the distance
+		return 0, _LZX_MAX_DISTANCE1, nil
+
+	case mode == 1:
+		return 1, _LZX_MAX_DISTANCE2, nil
+
+	case mode&_LZX_WINDOW_FLAG != 0:
+		logWindow := uint(mode &^ _LZX_WINDOW_FLAG)
+
+		if 1<<logWindow < LZ_MIN_WINDOW_SIZE || 1<<logWindow > LZ_MAX_WINDOW_SIZE {
+			break
+		}
+
+		if logWindow <= 24 {
+			return 1, (1 << logWindow) - 1, nil
+		}
+
+		return 2, (1 << logWindow) - 1, nil
+	}
+
+	return 0, 0, fmt.Errorf("LZCodec: Invalid block header: %v", mode)
+}
+
+// Add position 'pos' (with hash 'h') to the hash map and chains
+func (this *LZXCodec) insert(h uint32, pos int) {
+	if this.chain != nil {
+		this.chain[pos&this.chainMask] = this.hashes[h]
+	}
+
+	this.hashes[h] = int32(pos)
+}
+
+// Return the reference and length of the longest match at position 'pos'
+// (with hash 'h') among up to chainLength candidates
+func (this *LZXCodec) findMatch(buf []byte, h uint32, pos, end, maxDist int) (int, int) {
+	var minRef int
+
+	if pos < maxDist {
+		minRef = 0
+	} else {
+		minRef = pos - maxDist
+	}
+
+	ref := int(this.hashes[h])
+	maxMatch := end - pos
+	bestRef := 0
+	bestLen := 0
+
+	for n := this.chainLength; n > 0 && ref > minRef; n-- {
+		if (bestLen == 0 || buf[ref+bestLen] == buf[pos+bestLen]) &&
+			binary.LittleEndian.Uint32(buf[pos:]) == binary.LittleEndian.Uint32(buf[ref:]) {
+			length := 4
+
+			for length+4 < maxMatch && binary.LittleEndian.Uint32(buf[pos+length:]) == binary.LittleEndian.Uint32(buf[ref+length:]) {
+				length += 4
+			}
+
+			for length < maxMatch && buf[ref+length] == buf[pos+length] {
+				length++
+			}
+
+			if length > bestLen {
+				bestRef = ref
+				bestLen = length
+
+				if length == maxMatch {
+					break
+				}
+			}
+		}
+
+		if this.chain == nil {
+			break
+		}
+
+		next := int(this.chain[ref&this.chainMask])
+
+		if next >= ref {
+			break
+		}
+
+		ref = next
+	}
+
+	return bestRef, bestLen
+}
+
+// Return the index and length of the longest match at position 'pos' at one
+// of the repeat distances (the first one if several)
+func findRepeat(buf []byte, reps *[3]int, pos, end int) (int, int) {
+	maxMatch := end - pos
+	bestIdx := 0
+	bestLen := 0
+	val := binary.LittleEndian.Uint32(buf[pos:])
+
+	for i := range reps {
+		ref := pos - reps[i]
+
+		if ref < 0 || binary.LittleEndian.Uint32(buf[ref:]) != val {
+			continue
+		}
+
+		length := 4
+
+		for length+8 <= maxMatch {
+			if diff := binary.LittleEndian.Uint64(buf[pos+length:]) ^ binary.LittleEndian.Uint64(buf[ref+length:]); diff != 0 {
+				length += bits.TrailingZeros64(diff) >> 3
+				break
+			}
+
+			length += 8
+		}
+
+		for length < maxMatch && buf[ref+length] == buf[pos+length] {
+			length++
+		}
+
+		if length > bestLen {
+			bestIdx = i
+			bestLen = length
+		}
+	}
+
+	return bestIdx, bestLen
+}
+
+// Move the distance of repeat code 'idx' (a new distance if idx < 0) to the
+// front of the repeat distances
+func updateRepeats(reps *[3]int, idx, dist int) {
+	switch idx {
+	case 0:
+
+	case 1:
+		reps[1] = reps[0]
+		reps[0] = dist
+
+	default:
+		reps[2] = reps[1]
+		reps[1] = reps[0]
+		reps[0] = dist
+	}
+}
+
 // Forward applies the function to the src and writes the result
 // to the destination. Returns number of bytes read, number of bytes
 // written and possibly an error.
@@ -197,7 +488,17 @@ func (this *LZXCodec) Forward(src, dst []byte) (uint, uint, error) {
 		return 0, 0, fmt.Errorf("Block too small, skip")
 	}
 
-	srcEnd := count - 16
+	// With a dictionary, the block is encoded after the dictionary in 'buf'
+	buf := src
+	start := 0
+
+	if len(this.dict) > 0 {
+		buf = this.withDictionary(count)
+		start = len(this.dict)
+		copy(buf[start:], src)
+	}
+
+	srcEnd := len(buf) - 16
 
 	if len(this.hashes) == 0 {
 		this.hashes = make([]int32, 1<<_LZX_HASH_LOG)
@@ -207,61 +508,108 @@ func (this *LZXCodec) Forward(src, dst []byte) (uint, uint, error) {
 		}
 	}
 
-	maxDist := _LZX_MAX_DISTANCE2
-	dst[0] = 1
+	if this.window == 0 {
+		dst[0] = 1
 
-	if srcEnd < 4*_LZX_MAX_DISTANCE1 {
-		maxDist = _LZX_MAX_DISTANCE1
-		dst[0] = 0
+		if srcEnd < 4*_LZX_MAX_DISTANCE1 {
+			dst[0] = 0
+		}
+	} else {
+		logWindow := byte(16)
+
+		for 1<<logWindow < this.window {
+			logWindow++
+		}
+
+		dst[0] = _LZX_WINDOW_FLAG | logWindow
 	}
 
-	srcIdx := 0
-	dstIdx := 1
-	anchor := 0
+	if this.repeat == true {
+		dst[0] |= _LZX_REPEAT_FLAG
+	}
 
-	for srcIdx < srcEnd {
-		var minRef int
+	distBytes, maxDist, _ := lzDistanceBytes(dst[0])
+	reps := [3]int{1, 4, 8}
 
-		if srcIdx < maxDist {
-			minRef = 0
-		} else {
-			minRef = srcIdx - maxDist
+	if this.chainLength > 1 {
+		// Chains as long as the window (or the data), positions in the window
+		// do not collide.
+		chainSize := 1 << 16
+
+		for chainSize < len(buf) && chainSize <= maxDist {
+			chainSize <<= 1
 		}
 
-		h := lzhash(src[srcIdx:])
-		ref := int(this.hashes[h])
-		bestLen := 0
+		if len(this.chain) < chainSize {
+			this.chain = make([]int32, chainSize)
+		}
 
-		// Find a match
-		if ref > minRef && binary.LittleEndian.Uint32(src[srcIdx:]) == binary.LittleEndian.Uint32(src[ref:]) {
-			maxMatch := srcEnd - srcIdx
-			bestLen = 4
+		this.chainMask = chainSize - 1
+	}
 
-			for bestLen+4 < maxMatch && binary.LittleEndian.Uint32(src[srcIdx+bestLen:]) == binary.LittleEndian.Uint32(src[ref+bestLen:]) {
-				bestLen += 4
-			}
+	for i := 0; i < start; i++ {
+		this.insert(lzhash(buf[i:]), i)
+	}
 
-			for bestLen < maxMatch && src[ref+bestLen] == src[srcIdx+bestLen] {
-				bestLen++
+	srcIdx := start
+	dstIdx := 1
+	anchor := start
+
+	for srcIdx < srcEnd {
+		// Find a match
+		h := lzhash(buf[srcIdx:])
+		ref, bestLen := this.findMatch(buf, h, srcIdx, srcEnd, maxDist)
+		repIdx := -1
+
+		// A repeat match saves at least one distance byte
+		if this.repeat == true {
+			if idx, repLen := findRepeat(buf, &reps, srcIdx, srcEnd); repLen >= _LZX_MIN_MATCH && repLen+1 >= bestLen {
+				repIdx, ref, bestLen = idx, srcIdx-reps[idx], repLen
 			}
 		}
 
 		// No good match ?
-		if bestLen < _LZX_MIN_MATCH || (bestLen == _LZX_MIN_MATCH && srcIdx-ref >= _LZX_MIN_MATCH_MIN_DIST) {
-			this.hashes[h] = int32(srcIdx)
+		if repIdx < 0 && (bestLen < _LZX_MIN_MATCH || (bestLen == _LZX_MIN_MATCH && srcIdx-ref >= _LZX_MIN_MATCH_MIN_DIST)) {
+			this.insert(h, srcIdx)
 			srcIdx++
 			continue
 		}
 
+		// Lazy matching: if a longer match starts at the next position, emit
+		// the current byte as a literal
+		for n := 0; repIdx < 0 && n < this.lazyDepth && srcIdx+1 < srcEnd; n++ {
+			h2 := lzhash(buf[srcIdx+1:])
+			ref2, bestLen2 := this.findMatch(buf, h2, srcIdx+1, srcEnd, maxDist)
+
+			if bestLen2 <= bestLen {
+				break
+			}
+
+			this.insert(h, srcIdx)
+			srcIdx++
+			h, ref, bestLen = h2, ref2, bestLen2
+		}
+
 		// Emit token
 		// Token: 3 bits litLen + 1 bit flag + 4 bits mLen (LLLFMMMM)
 		// flag = if maxDist = (1<<17)-1, then highest bit of distance
-		//        else 1 if dist needs 3 bytes (> 0xFFFF) and 0 otherwise
+		//        else 1 if dist needs 3 or 4 bytes (> 0xFFFF) and 0 otherwise
 		mLen := bestLen - _LZX_MIN_MATCH
 		dist := srcIdx - ref
+		long := dist > 0xFFFF
 		var token int
 
-		if dist > 0xFFFF {
+		if this.repeat == true {
+			for i := 0; i < len(reps) && repIdx < 0; i++ {
+				if reps[i] == dist {
+					repIdx = i
+				}
+			}
+
+			long = repIdx < 0 && dist >= _LZX_REPEAT_LONG
+		}
+
+		if long == true {
 			token = 0x10
 		} else {
 			token = 0
@@ -292,7 +640,7 @@ func (this *LZXCodec) Forward(src, dst []byte) (uint, uint, error) {
 			}
 
 			// Emit literals
-			emitLiterals(src[anchor:anchor+litLen], dst[dstIdx:])
+			emitLiterals(buf[anchor:anchor+litLen], dst[dstIdx:])
 			dstIdx += litLen
 		}
 
@@ -302,30 +650,45 @@ func (this *LZXCodec) Forward(src, dst []byte) (uint, uint, error) {
 		}
 
 		// Emit distance
-		if maxDist == _LZX_MAX_DISTANCE2 && dist > 0xFFFF {
-			dst[dstIdx] = byte(dist >> 16)
-			dstIdx++
+		if this.repeat == true {
+			updateRepeats(&reps, repIdx, dist)
+
+			if long == true {
+				dist -= _LZX_REPEAT_LONG
+			}
 		}
 
-		dst[dstIdx] = byte(dist >> 8)
-		dstIdx++
-		dst[dstIdx] = byte(dist)
-		dstIdx++
+		if repIdx >= 0 {
+			dst[dstIdx] = byte(_LZX_REPEAT_CODE + repIdx)
+			dstIdx++
+		} else {
+			if long == true {
+				for k := distBytes; k > 0; k-- {
+					dst[dstIdx] = byte(dist >> uint(8+8*k))
+					dstIdx++
+				}
+			}
+
+			dst[dstIdx] = byte(dist >> 8)
+			dstIdx++
+			dst[dstIdx] = byte(dist)
+			dstIdx++
+		}
 
 		// Fill _hashes and update positions
 		anchor = srcIdx + bestLen
-		this.hashes[h] = int32(srcIdx)
+		this.insert(h, srcIdx)
 		srcIdx++
 
 		for srcIdx < anchor {
-			this.hashes[lzhash(src[srcIdx:])] = int32(srcIdx)
+			this.insert(lzhash(buf[srcIdx:]), srcIdx)
 			srcIdx++
 		}
 	}
 
 	// Emit last literals
-	dstIdx += emitLastLiterals(src[anchor:srcEnd+16], dst[dstIdx:])
-	return uint(srcEnd + 16), uint(dstIdx), nil
+	dstIdx += emitLastLiterals(buf[anchor:srcEnd+16], dst[dstIdx:])
+	return uint(srcEnd + 16 - start), uint(dstIdx), nil
 }
 
 // Inverse applies the reverse function to the src and writes the result
@@ -340,16 +703,31 @@ func (this *LZXCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
+	// With a dictionary, the block is decoded after the dictionary in 'buf'
+	buf := dst
+	begin := 0
+
+	if len(this.dict) > 0 {
+		buf = this.withDictionary(len(dst))
+		begin = len(this.dict)
+	}
+
 	count := len(src)
 	srcEnd := count - 16
-	dstEnd := len(dst) - 16
-	dstIdx := 0
-	maxDist := _LZX_MAX_DISTANCE2
+	dstEnd := len(buf) - 16
+	dstIdx := begin
+	distBytes, maxDist, err := lzDistanceBytes(src[0])
 
-	if src[0] == 0 {
-		maxDist = _LZX_MAX_DISTANCE1
+	if err != nil {
+		return 0, 0, err
 	}
 
+	repeat := src[0]&_LZX_REPEAT_FLAG != 0
+
+	if repeat == true && this.legacy == true {
+		return 0, 0, errors.New("LZCodec: Invalid block header: repeat codes in a stream of format version 9 or older")
+	}
+	reps := [3]int{1, 4, 8}
 	srcIdx := 1
 
 	for {
@@ -372,13 +750,13 @@ func (this *LZXCodec) Inverse(src, dst []byte) (uint, uint, error) {
 
 			// Emit literals
 			if dstIdx+litLen > dstEnd || srcIdx+litLen > srcEnd {
-				copy(dst[dstIdx:], src[srcIdx:srcIdx+litLen])
+				copy(buf[dstIdx:], src[srcIdx:srcIdx+litLen])
 				srcIdx += litLen
 				dstIdx += litLen
 				break
 			}
 
-			emitLiterals(src[srcIdx:srcIdx+litLen], dst[dstIdx:])
+			emitLiterals(src[srcIdx:srcIdx+litLen], buf[dstIdx:])
 			srcIdx += litLen
 			dstIdx += litLen
 		}
@@ -403,51 +781,56 @@ func (this *LZXCodec) Inverse(src, dst []byte) (uint, uint, error) {
 
 		// Sanity check
 		if mEnd > dstEnd+16 {
-			return uint(srcIdx), uint(dstIdx), fmt.Errorf("LZCodec: Invalid match length decoded: %d", mLen)
+			return uint(srcIdx), uint(dstIdx - begin), fmt.Errorf("LZCodec: Invalid match length decoded: %d", mLen)
 		}
 
 		// Get distance
-		dist := (int(src[srcIdx]) << 8) | int(src[srcIdx+1])
-		srcIdx += 2
+		var dist int
 
-		if (token & 0x10) != 0 {
-			if maxDist == _LZX_MAX_DISTANCE1 {
-				dist += 65536
-			} else {
-				dist = (dist << 8) | int(src[srcIdx])
-				srcIdx++
+		if repeat == true && token&0x10 == 0 && src[srcIdx] >= _LZX_REPEAT_CODE {
+			idx := int(src[srcIdx]) - _LZX_REPEAT_CODE
+			srcIdx++
+			dist = reps[idx]
+			updateRepeats(&reps, idx, dist)
+		} else {
+			dist = (int(src[srcIdx]) << 8) | int(src[srcIdx+1])
+			srcIdx += 2
+
+			if (token & 0x10) != 0 {
+				for k := 0; k < distBytes; k++ {
+					dist = (dist << 8) | int(src[srcIdx])
+					srcIdx++
+				}
+
+				if repeat == true {
+					dist += _LZX_REPEAT_LONG
+				} else if distBytes == 0 {
+					dist += 65536
+				}
+			}
+
+			if repeat == true {
+				updateRepeats(&reps, -1, dist)
 			}
 		}
 
 		// Sanity check
 		if dstIdx < dist || dist > maxDist {
-			return uint(srcIdx), uint(dstIdx), fmt.Errorf("LZCodec: Invalid distance decoded: %d", dist)
+			return uint(srcIdx), uint(dstIdx - begin), fmt.Errorf("LZCodec: Invalid distance decoded: %d", dist)
 		}
 
 		ref := dstIdx - dist
 
 		// Copy match
-		if dist >= 16 {
-			for {
-				// No overlap
-				copy(dst[dstIdx:], dst[ref:ref+16])
-				ref += 16
-				dstIdx += 16
-
-				if dstIdx >= mEnd {
-					break
-				}
-			}
-		} else {
-			for i := 0; i < mLen; i++ {
-				dst[dstIdx+i] = dst[ref+i]
-			}
-		}
-
+		copyMatch(buf, dstIdx, ref, mLen)
 		dstIdx = mEnd
 	}
 
-	return uint(srcIdx), uint(dstIdx), nil
+	if begin > 0 {
+		copy(dst, buf[begin:dstIdx])
+	}
+
+	return uint(srcIdx), uint(dstIdx - begin), nil
 }
 
 // MaxEncodedLen returns the max size required for the encoding output buffer
@@ -681,10 +1064,7 @@ func (this *LZPCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		mLen += int(src[srcIdx])
 		srcIdx++
 
-		for i := 0; i < mLen; i++ {
-			dst[dstIdx+i] = dst[ref+i]
-		}
-
+		copyMatch(dst, dstIdx, ref, mLen)
 		dstIdx += mLen
 		ctx = binary.LittleEndian.Uint32(dst[dstIdx-4:])
 	}
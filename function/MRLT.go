@@ -0,0 +1,139 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// MRLT Most frequent symbol Run Length Transform
+// Same as ZRLT for the runs of the most frequent byte of the block instead
+// of the runs of 0. Post MTFT data is dominated by zeros but the input of
+// the pre-transforms often has another dominant byte (EG. 0xFF padding in
+// executables and images, spaces in text).
+// The symbol is given by the "mrltSymbol" context entry (0 to 255) or
+// selected for each block.
+
+// MRLT block format: Symbol (1 byte) then ZRLT data of the bytes minus the
+// symbol (modulo 256)
+
+// MRLT the most frequent symbol run length transform
+type MRLT struct {
+	symbol int // -1 => most frequent byte of each block
+}
+
+// NewMRLT creates a new instance of MRLT
+func NewMRLT() (*MRLT, error) {
+	this := &MRLT{symbol: -1}
+	return this, nil
+}
+
+// NewMRLTWithCtx creates a new instance of MRLT using a
+// configuration map as parameter.
+func NewMRLTWithCtx(ctx *map[string]interface{}) (*MRLT, error) {
+	this := &MRLT{symbol: -1}
+
+	if val, containsKey := (*ctx)["mrltSymbol"]; containsKey {
+		symbol := val.(uint)
+
+		if symbol > 255 {
+			return nil, fmt.Errorf("Invalid MRLT symbol parameter: %v (must be in [0..255])", symbol)
+		}
+
+		this.symbol = int(symbol)
+	}
+
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. An error is returned if the output is
+// not smaller than the input.
+func (this *MRLT) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	symbol := byte(this.symbol)
+
+	if this.symbol < 0 {
+		var freqs [256]int
+		kanzi.ComputeHistogram(src, freqs[:], true, false)
+
+		for i := range freqs {
+			if freqs[i] > freqs[symbol] {
+				symbol = byte(i)
+			}
+		}
+	}
+
+	dst[0] = symbol
+	srcIdx, dstIdx, err := encodeZeroRuns(src, dst[1:len(src)], symbol)
+	return srcIdx, dstIdx + 1, err
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *MRLT) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	srcIdx, dstIdx, err := decodeZeroRuns(src[1:], dst, src[0])
+	return srcIdx + 1, dstIdx, err
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *MRLT) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length == 0 {
+		return 0, nil
+	}
+
+	if length > uint(len(block)) {
+		return 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(block), length)
+	}
+
+	symbol := block[0]
+	src := block[1:length]
+	gap, ok := zeroRunsLayout(src, uint(len(block)))
+	return inverseInPlace(block, src, gap, ok, func(src, dst []byte) (uint, uint, error) {
+		return decodeZeroRuns(src, dst, symbol)
+	})
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this MRLT) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
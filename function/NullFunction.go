@@ -66,6 +66,16 @@ func (this *NullFunction) Inverse(src, dst []byte) (uint, uint, error) {
 	return doCopy(src, dst)
 }
 
+// InverseInPlace leaves the first 'length' bytes of the block unchanged.
+// Returns the number of bytes written and possibly an error.
+func (this *NullFunction) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length > uint(len(block)) {
+		return 0, errors.New("Destination buffer too small")
+	}
+
+	return length, nil
+}
+
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this NullFunction) MaxEncodedLen(srcLen int) int {
 	return srcLen
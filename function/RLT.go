@@ -21,10 +21,18 @@ package function
 // 4    <= runLen < 224+4      -> 1 byte
 // 228  <= runLen < 6944+228   -> 2 bytes
 // 7172 <= runLen < 65535+7172 -> 3 bytes
+// The escape can also be a pair of bytes (the least frequent byte followed by
+// its least frequent successor) so that binary data using all the byte values
+// does not expand, and the run lengths can be coded with 16 bits. The block
+// then starts with the escape byte twice (impossible in the original format)
+// followed by a mode byte and the second byte of the escape:
+// 4 <= runLen < 65535+4 -> 2 bytes
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/bits"
 
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -35,15 +43,25 @@ const (
 	_RLT_RUN_THRESHOLD   = 3
 	_RLT_MAX_RUN         = 0xFFFF + _RLT_RUN_LEN_ENCODE2 + _RLT_RUN_THRESHOLD - 1
 	_RLT_MAX_RUN4        = _RLT_MAX_RUN - 4
+	_RLT_MAX_RUN16       = 0xFFFF + _RLT_RUN_THRESHOLD
+	_RLT_MODE_ESCAPE2    = 1 // two byte escape
+	_RLT_MODE_RUN16      = 2 // 16 bit run lengths
 )
 
 // RLT a Run Length Transform with escape symbol
+// The two byte escape and the 16 bit runs are only used in streams of format
+// version 10 or newer ("bsVersion" context entry): older streams use a one
+// byte escape and reject the other "rltEscape" and "rltRunWidth" values.
 type RLT struct {
+	escapeSize int // 0 => one or two bytes based on the block
+	runWidth   int
+	legacy     bool // stream format version 9 or older: original format
 }
 
 // NewRLT creates a new instance of RLT
 func NewRLT() (*RLT, error) {
 	this := &RLT{}
+	this.runWidth = 1
 	return this, nil
 }
 
@@ -51,6 +69,37 @@ func NewRLT() (*RLT, error) {
 // configuration map as parameter.
 func NewRLTWithCtx(ctx *map[string]interface{}) (*RLT, error) {
 	this := &RLT{}
+	this.runWidth = 1
+
+	if val, containsKey := (*ctx)["bsVersion"]; containsKey {
+		this.legacy = val.(uint) < 10
+	}
+
+	if this.legacy == true {
+		this.escapeSize = 1
+	}
+
+	if val, containsKey := (*ctx)["rltEscape"]; containsKey {
+		this.escapeSize = int(val.(uint))
+
+		if this.escapeSize > 2 {
+			return nil, fmt.Errorf("Invalid RLT escape parameter: %v (must be 0, 1 or 2)", this.escapeSize)
+		}
+	}
+
+	if val, containsKey := (*ctx)["rltRunWidth"]; containsKey {
+		this.runWidth = int(val.(uint))
+
+		if this.runWidth != 1 && this.runWidth != 2 {
+			return nil, fmt.Errorf("Invalid RLT run width parameter: %v (must be 1 or 2)", this.runWidth)
+		}
+	}
+
+	if this.legacy == true && (this.escapeSize != 1 || this.runWidth != 1) {
+		return nil, fmt.Errorf("RLT: Escape size %v and run width %v require stream format version 10 (got %v)",
+			this.escapeSize, this.runWidth, (*ctx)["bsVersion"])
+	}
+
 	return this, nil
 }
 
@@ -94,6 +143,28 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	escape := byte(minIdx)
+	escape2 := escape
+	mode := 0
+
+	if this.runWidth == 2 {
+		mode |= _RLT_MODE_RUN16
+	}
+
+	if this.escapeSize == 2 || (this.escapeSize == 0 && freqs[minIdx] > 0) {
+		// Use a two byte escape if forced or if the escaped pairs and the
+		// second byte of each run cost less than the escaped literals
+		var pairs, runs int
+		escape2, pairs, runs = selectRLTEscape2(src, escape)
+
+		if this.escapeSize == 2 || pairs+runs+2 < freqs[minIdx] {
+			mode |= _RLT_MODE_ESCAPE2
+		}
+	}
+
+	if mode != 0 {
+		return forwardRLT2(src, dst, mode, escape, escape2)
+	}
+
 	run := 0
 	var err error
 	prev := src[srcIdx]
@@ -110,30 +181,50 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 
 	// Main loop
 	for srcIdx < srcEnd4 {
-		if prev == src[srcIdx] {
-			srcIdx++
-			run++
+		k := 0
+
+		if srcIdx+4 < srcEnd4 {
+			// Compare 8 bytes at once (same result as 2 steps of 4 bytes): the
+			// number of bytes equal to prev is given by the trailing zeros of
+			// the XOR of the data with the repeated symbol
+			diff := binary.LittleEndian.Uint64(src[srcIdx:]) ^ (uint64(prev) * 0x0101010101010101)
+
+			if diff == 0 && run+8 < _RLT_MAX_RUN4 {
+				srcIdx += 8
+				run += 8
+				continue
+			}
 
-			if prev == src[srcIdx] {
-				srcIdx++
-				run++
+			if k = bits.TrailingZeros64(diff) >> 3; k > 4 {
+				k = 4
+			}
+		} else {
+			s := src[srcIdx : srcIdx+4]
 
-				if prev == src[srcIdx] {
-					srcIdx++
-					run++
+			if prev == s[0] {
+				k++
 
-					if prev == src[srcIdx] {
-						srcIdx++
-						run++
+				if prev == s[1] {
+					k++
 
-						if run < _RLT_MAX_RUN4 {
-							continue
+					if prev == s[2] {
+						k++
+
+						if prev == s[3] {
+							k++
 						}
 					}
 				}
 			}
 		}
 
+		srcIdx += k
+		run += k
+
+		if k == 4 && run < _RLT_MAX_RUN4 {
+			continue
+		}
+
 		if run > _RLT_RUN_THRESHOLD {
 			dIdx, err2 := emitRunLength(dst[dstIdx:dstEnd], run, escape, prev)
 
@@ -149,29 +240,25 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 				break
 			}
 
-			if run > 0 {
-				dst[dstIdx] = prev
-				dstIdx++
-				run--
-			}
+			out := dst[dstIdx : dstIdx+run]
 
-			for run > 0 {
-				dst[dstIdx] = prev
-				dstIdx++
-				run--
+			for i := range out {
+				out[i] = prev
 			}
+
+			dstIdx += run
 		} else { // escape literal
 			if dstIdx+2*run >= dstEnd {
 				err = errors.New("Output buffer is too small")
 				break
 			}
 
-			for run > 0 {
-				dst[dstIdx] = escape
-				dst[dstIdx+1] = 0
-				dstIdx += 2
-				run--
+			for out := dst[dstIdx : dstIdx+2*run]; len(out) >= 2; out = out[2:] {
+				out[0] = escape
+				out[1] = 0
 			}
+
+			dstIdx += 2 * run
 		}
 
 		prev = src[srcIdx]
@@ -191,33 +278,48 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 			}
 		} else if prev != escape {
 			if dstIdx+run < dstEnd {
-				for run > 0 {
-					dst[dstIdx] = prev
-					dstIdx++
-					run--
+				out := dst[dstIdx : dstIdx+run]
+
+				for i := range out {
+					out[i] = prev
 				}
+
+				dstIdx += run
+			} else {
+				err = errors.New("Output buffer is too small")
 			}
 		} else { // escape literal
 			if dstIdx+2*run < dstEnd {
-				for run > 0 {
-					dst[dstIdx] = escape
-					dst[dstIdx+1] = 0
-					dstIdx += 2
-					run--
+				for out := dst[dstIdx : dstIdx+2*run]; len(out) >= 2; out = out[2:] {
+					out[0] = escape
+					out[1] = 0
 				}
+
+				dstIdx += 2 * run
+			} else {
+				err = errors.New("Output buffer is too small")
 			}
 		}
 
-		// Copy the last few bytes
-		for srcIdx < srcEnd && dstIdx < dstEnd {
+		// Copy the last few bytes (the escape symbol is emitted as a literal)
+		for err == nil && srcIdx < srcEnd {
+			if dstIdx+2 >= dstEnd {
+				err = errors.New("Output buffer is too small")
+				break
+			}
+
 			dst[dstIdx] = src[srcIdx]
-			srcIdx++
 			dstIdx++
+
+			if src[srcIdx] == escape {
+				dst[dstIdx] = 0
+				dstIdx++
+			}
+
+			srcIdx++
 		}
 
-		if srcIdx != srcEnd {
-			err = errors.New("Output buffer is too small")
-		} else if dstIdx >= srcIdx {
+		if err == nil && dstIdx >= srcIdx {
 			err = errors.New("Input not compressed")
 		}
 	}
@@ -276,37 +378,177 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
-	srcIdx := 0
-	dstIdx := 0
-	srcEnd := len(src)
-	dstEnd := len(dst)
+	if err := this.checkMode(src); err != nil {
+		return 0, 0, err
+	}
+
+	return inverseRLT(src, dst)
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *RLT) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length == 0 {
+		return 0, nil
+	}
+
+	if length > uint(len(block)) {
+		return 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(block), length)
+	}
+
+	src := block[0:length]
+
+	if err := this.checkMode(src); err != nil {
+		return 0, err
+	}
+
+	gap, ok := rltLayout(src, uint(len(block)))
+	return inverseInPlace(block, src, gap, ok, inverseRLT)
+}
+
+// checkMode rejects a block with a mode byte (two byte escape or 16 bit runs)
+// in a stream of format version 9 or older
+func (this *RLT) checkMode(src []byte) error {
+	if this.legacy == true && len(src) > 2 && src[1] == src[0] && src[2] != 0 {
+		return errors.New("RLT: Invalid block header: two byte escape or 16 bit runs in a stream of format version 9 or older")
+	}
+
+	return nil
+}
+
+// rltLayout returns the offset of src in the block that keeps the output of
+// inverseRLT behind the unread input. Returns false if the output is larger
+// than 'max' or the input is invalid.
+func rltLayout(src []byte, max uint) (uint, bool) {
+	escape := src[0]
+	srcIdx, dstIdx, gap := uint(1), uint(0), uint(0)
+	twoBytes := false
+	run16 := false
+
+	if len(src) > 2 && src[1] == escape && src[2] != 0 {
+		mode := src[2]
+		twoBytes = mode&_RLT_MODE_ESCAPE2 != 0
+		run16 = mode&_RLT_MODE_RUN16 != 0
+		srcIdx = 3
+
+		if twoBytes == true {
+			srcIdx++
+		}
+	} else if len(src) > 1 && src[1] == escape {
+		// Leading escape literal
+		srcIdx = 3
+		dstIdx = 1
+	}
+
+	for srcIdx < uint(len(src)) {
+		val := src[srcIdx]
+		srcIdx++
+
+		if val != escape || (twoBytes == true && (srcIdx >= uint(len(src)) || src[srcIdx] != src[3])) {
+			dstIdx++
+		} else {
+			if twoBytes == true {
+				srcIdx++
+			}
+
+			if srcIdx >= uint(len(src)) {
+				return 0, false
+			}
+
+			run := uint(src[srcIdx])
+			srcIdx++
+			extra := uint(0)
+
+			if run16 == true || (run >= _RLT_RUN_LEN_ENCODE1 && run != 0xFF) {
+				extra = 1
+			} else if run == 0xFF {
+				extra = 2
+			}
+
+			if srcIdx+extra > uint(len(src)) {
+				return 0, false
+			}
+
+			if run16 == true {
+				run = (run << 8) | uint(src[srcIdx])
+				srcIdx++
+			} else if run == 0xFF {
+				run = ((uint(src[srcIdx]) << 8) | uint(src[srcIdx+1])) + _RLT_RUN_LEN_ENCODE2
+				srcIdx += 2
+			} else if run >= _RLT_RUN_LEN_ENCODE1 {
+				run = (((run - _RLT_RUN_LEN_ENCODE1) << 8) | uint(src[srcIdx])) + _RLT_RUN_LEN_ENCODE1
+				srcIdx++
+			}
+
+			if run == 0 {
+				// Just the escape symbol(s), not a run
+				dstIdx++
+
+				if twoBytes == true {
+					dstIdx++
+				}
+			} else {
+				dstIdx += run + _RLT_RUN_THRESHOLD - 1
+			}
+		}
+
+		if dstIdx > max {
+			return 0, false
+		}
+
+		if dstIdx > srcIdx+gap {
+			gap = dstIdx - srcIdx
+		}
+	}
+
+	return gap, true
+}
+
+// inverseRLT decodes a block encoded by Forward
+func inverseRLT(src, dst []byte) (uint, uint, error) {
+	// Unsigned indexes compared to the slice lengths let the compiler drop
+	// the bound checks
+	srcIdx := uint(0)
+	dstIdx := uint(0)
 	escape := src[srcIdx]
 	srcIdx++
 	var err error
 
-	if src[srcIdx] == escape {
+	if len(src) > 2 && src[1] == escape && src[2] != 0 {
+		return inverseRLT2(src, dst)
+	}
+
+	if srcIdx < uint(len(src)) && src[srcIdx] == escape {
 		srcIdx++
 
 		// The data cannot start with a run but may start with an escape literal
-		if srcIdx < srcEnd && src[srcIdx] != 0 {
-			return uint(srcIdx), uint(dstIdx), errors.New("Invalid input data: input starts with a run")
+		if srcIdx < uint(len(src)) && src[srcIdx] != 0 {
+			return srcIdx, dstIdx, errors.New("Invalid input data: input starts with a run")
 		}
 
 		srcIdx++
+
+		if len(dst) == 0 {
+			return srcIdx, dstIdx, errors.New("Invalid input data")
+		}
+
 		dst[dstIdx] = escape
 		dstIdx++
 	}
 
 	// Main loop
-	for srcIdx < srcEnd {
-		if src[srcIdx] != escape {
+	for srcIdx < uint(len(src)) {
+		val := src[srcIdx]
+
+		if val != escape {
 			// Literal
-			if dstIdx >= dstEnd {
+			if dstIdx >= uint(len(dst)) {
 				err = errors.New("Invalid input data")
 				break
 			}
 
-			dst[dstIdx] = src[srcIdx]
+			dst[dstIdx] = val
 			srcIdx++
 			dstIdx++
 			continue
@@ -314,18 +556,18 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 
 		srcIdx++
 
-		if srcIdx >= srcEnd {
+		if srcIdx >= uint(len(src)) || dstIdx == 0 {
 			err = errors.New("Invalid input data")
 			break
 		}
 
-		val := dst[dstIdx-1]
-		run := int(src[srcIdx])
+		val = dst[dstIdx-1]
+		run := uint(src[srcIdx])
 		srcIdx++
 
 		if run == 0 {
 			// Just an escape symbol, not a run
-			if dstIdx >= dstEnd {
+			if dstIdx >= uint(len(dst)) {
 				err = errors.New("Invalid input data")
 				break
 			}
@@ -337,21 +579,21 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 
 		// Decode the length
 		if run == 0xFF {
-			if srcIdx+1 >= srcEnd {
+			if srcIdx+1 >= uint(len(src)) {
 				err = errors.New("Invalid input data")
 				break
 			}
 
-			run = (int(src[srcIdx]) << 8) | int(src[srcIdx+1])
+			run = (uint(src[srcIdx]) << 8) | uint(src[srcIdx+1])
 			srcIdx += 2
 			run += _RLT_RUN_LEN_ENCODE2
 		} else if run >= _RLT_RUN_LEN_ENCODE1 {
-			if srcIdx >= srcEnd {
+			if srcIdx >= uint(len(src)) {
 				err = errors.New("Invalid input data")
 				break
 			}
 
-			run = ((run - _RLT_RUN_LEN_ENCODE1) << 8) | int(src[srcIdx])
+			run = ((run - _RLT_RUN_LEN_ENCODE1) << 8) | uint(src[srcIdx])
 			run += _RLT_RUN_LEN_ENCODE1
 			srcIdx++
 		}
@@ -359,30 +601,291 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 		run += (_RLT_RUN_THRESHOLD - 1)
 
 		// Sanity check
-		if dstIdx+run >= dstEnd || run > _RLT_MAX_RUN {
+		if dstIdx+run >= uint(len(dst)) || run > _RLT_MAX_RUN {
 			err = errors.New("Invalid run length")
 			break
 		}
 
 		// Emit 'run' times the previous byte
-		for run >= 4 {
-			dst[dstIdx] = val
-			dst[dstIdx+1] = val
-			dst[dstIdx+2] = val
-			dst[dstIdx+3] = val
-			dstIdx += 4
-			run -= 4
+		out := dst[dstIdx : dstIdx+run]
+
+		for i := range out {
+			out[i] = val
 		}
 
-		for run > 0 {
-			dst[dstIdx] = val
+		dstIdx += run
+	}
+
+	if srcIdx != uint(len(src)) && err == nil {
+		err = errors.New("Invalid input data")
+	}
+
+	return srcIdx, dstIdx, err
+}
+
+// selectRLTEscape2 returns the least frequent successor of 'escape' (other
+// than 'escape'), its number of occurrences after 'escape' and the number of
+// runs of the block
+func selectRLTEscape2(src []byte, escape byte) (byte, int, int) {
+	freqs := [256]int{}
+	runs := 0
+	run := 0
+
+	for i := 0; i+1 < len(src); i++ {
+		if src[i] == escape {
+			freqs[src[i+1]]++
+		}
+
+		if src[i] != src[i+1] {
+			run = 0
+		} else if run++; run == _RLT_RUN_THRESHOLD {
+			runs++
+		}
+	}
+
+	freqs[escape] = len(src) + 1
+	minIdx := 0
+
+	for i, f := range freqs {
+		if f < freqs[minIdx] {
+			minIdx = i
+		}
+	}
+
+	return byte(minIdx), freqs[minIdx], runs
+}
+
+// forwardRLT2 encodes the runs with a one or two byte escape and run lengths
+// of variable or 16 bits based on the mode
+func forwardRLT2(src, dst []byte, mode int, escape, escape2 byte) (uint, uint, error) {
+	srcIdx := 0
+	dstIdx := 3
+	srcEnd := len(src)
+	dstEnd := len(dst)
+	dst[0] = escape
+	dst[1] = escape
+	dst[2] = byte(mode)
+	twoBytes := mode&_RLT_MODE_ESCAPE2 != 0
+	lenSize := 1
+	maxRun := _RLT_MAX_RUN
+
+	if twoBytes == true {
+		dst[dstIdx] = escape2
+		dstIdx++
+	}
+
+	if mode&_RLT_MODE_RUN16 != 0 {
+		lenSize = 2
+		maxRun = _RLT_MAX_RUN16
+	}
+
+	// Set when the last byte written is a literal escape (first byte of the
+	// two byte escape): a following literal escape2 must be escaped
+	lastEscape := false
+
+	emitLiteral := func(c byte) {
+		dst[dstIdx] = c
+		dstIdx++
+		escaped := c == escape
+
+		if twoBytes == true {
+			// Literal escape pair: escape, escape2 and a null length
+			escaped = lastEscape == true && c == escape2
+			lastEscape = c == escape && escaped == false
+		}
+
+		if escaped == true {
+			for i := 0; i < lenSize; i++ {
+				dst[dstIdx] = 0
+				dstIdx++
+			}
+		}
+	}
+
+	for srcIdx < srcEnd {
+		// Literal and run: at most 3 literals of 3 bytes or 1 literal and a
+		// run of 6 bytes
+		if dstIdx+10 > dstEnd {
+			return uint(srcIdx), uint(dstIdx), errors.New("Output buffer is too small")
+		}
+
+		c := src[srcIdx]
+		run := 1
+
+		for srcIdx+run < srcEnd && src[srcIdx+run] == c && run < maxRun {
+			run++
+		}
+
+		srcIdx += run
+
+		if run <= _RLT_RUN_THRESHOLD {
+			for ; run > 0; run-- {
+				emitLiteral(c)
+			}
+
+			continue
+		}
+
+		emitLiteral(c)
+		dst[dstIdx] = escape
+		dstIdx++
+
+		if twoBytes == true {
+			dst[dstIdx] = escape2
+			dstIdx++
+			lastEscape = false
+		}
+
+		run -= _RLT_RUN_THRESHOLD
+
+		if lenSize == 2 {
+			binary.BigEndian.PutUint16(dst[dstIdx:], uint16(run))
+			dstIdx += 2
+		} else if run >= _RLT_RUN_LEN_ENCODE2 {
+			run -= _RLT_RUN_LEN_ENCODE2
+			dst[dstIdx] = byte(0xFF)
+			dst[dstIdx+1] = byte(run >> 8)
+			dst[dstIdx+2] = byte(run)
+			dstIdx += 3
+		} else if run >= _RLT_RUN_LEN_ENCODE1 {
+			run -= _RLT_RUN_LEN_ENCODE1
+			dst[dstIdx] = byte(_RLT_RUN_LEN_ENCODE1 + (run >> 8))
+			dst[dstIdx+1] = byte(run)
+			dstIdx += 2
+		} else {
+			dst[dstIdx] = byte(run)
 			dstIdx++
-			run--
 		}
 	}
 
-	if srcIdx != srcEnd && err == nil {
-		err = errors.New("Invalid input data")
+	var err error
+
+	if dstIdx >= srcIdx {
+		err = errors.New("Input not compressed")
+	}
+
+	return uint(srcIdx), uint(dstIdx), err
+}
+
+// inverseRLT2 decodes a block encoded by forwardRLT2
+func inverseRLT2(src, dst []byte) (uint, uint, error) {
+	escape := src[0]
+	mode := int(src[2])
+	srcIdx := 3
+	dstIdx := 0
+	srcEnd := len(src)
+	twoBytes := mode&_RLT_MODE_ESCAPE2 != 0
+	escape2 := escape
+
+	if mode&^(_RLT_MODE_ESCAPE2|_RLT_MODE_RUN16) != 0 {
+		return 0, 0, errors.New("Invalid input data: unknown mode")
+	}
+
+	if twoBytes == true {
+		if srcIdx >= srcEnd || src[srcIdx] == escape {
+			return 0, 0, errors.New("Invalid input data: incorrect escape")
+		}
+
+		escape2 = src[srcIdx]
+		srcIdx++
+	}
+
+	var err error
+
+	for srcIdx < srcEnd {
+		val := src[srcIdx]
+		srcIdx++
+
+		if val != escape || (twoBytes == true && (srcIdx >= srcEnd || src[srcIdx] != escape2)) {
+			// Literal
+			if dstIdx >= len(dst) {
+				err = errors.New("Invalid input data")
+				break
+			}
+
+			dst[dstIdx] = val
+			dstIdx++
+			continue
+		}
+
+		if twoBytes == true {
+			srcIdx++
+		}
+
+		// Decode the length
+		run := 0
+
+		if mode&_RLT_MODE_RUN16 != 0 {
+			if srcIdx+1 >= srcEnd {
+				err = errors.New("Invalid input data")
+				break
+			}
+
+			run = int(binary.BigEndian.Uint16(src[srcIdx:]))
+			srcIdx += 2
+		} else {
+			if srcIdx >= srcEnd {
+				err = errors.New("Invalid input data")
+				break
+			}
+
+			run = int(src[srcIdx])
+			srcIdx++
+
+			if run == 0xFF {
+				if srcIdx+1 >= srcEnd {
+					err = errors.New("Invalid input data")
+					break
+				}
+
+				run = int(binary.BigEndian.Uint16(src[srcIdx:])) + _RLT_RUN_LEN_ENCODE2
+				srcIdx += 2
+			} else if run >= _RLT_RUN_LEN_ENCODE1 {
+				if srcIdx >= srcEnd {
+					err = errors.New("Invalid input data")
+					break
+				}
+
+				run = (((run - _RLT_RUN_LEN_ENCODE1) << 8) | int(src[srcIdx])) + _RLT_RUN_LEN_ENCODE1
+				srcIdx++
+			}
+		}
+
+		if run == 0 {
+			// Just the escape symbol(s), not a run
+			if dstIdx+1 > len(dst) || (twoBytes == true && dstIdx+2 > len(dst)) {
+				err = errors.New("Invalid input data")
+				break
+			}
+
+			dst[dstIdx] = escape
+			dstIdx++
+
+			if twoBytes == true {
+				dst[dstIdx] = escape2
+				dstIdx++
+			}
+
+			continue
+		}
+
+		run += (_RLT_RUN_THRESHOLD - 1)
+
+		// Sanity check
+		if dstIdx == 0 || dstIdx+run > len(dst) {
+			err = errors.New("Invalid run length")
+			break
+		}
+
+		// Emit 'run' times the previous byte
+		val = dst[dstIdx-1]
+		out := dst[dstIdx : dstIdx+run]
+
+		for i := range out {
+			out[i] = val
+		}
+
+		dstIdx += run
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
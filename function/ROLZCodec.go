@@ -50,6 +50,15 @@ const (
 	_MASK_0_32            = uint64(0x00000000FFFFFFFF)
 )
 
+const (
+	// ROLZ_MAX_DICTIONARY_SIZE is the max number of bytes of the previous
+	// blocks used to prime the match tables (see "rolzDictionary")
+	ROLZ_MAX_DICTIONARY_SIZE = 1 << 18
+
+	// Smaller blocks are coded without dictionary
+	_ROLZ_MIN_PRIMED_BLOCK = 16
+)
+
 func getKey(p []byte) uint32 {
 	return uint32(binary.LittleEndian.Uint16(p))
 }
@@ -58,47 +67,40 @@ func rolzhash(p []byte) uint32 {
 	return ((binary.LittleEndian.Uint32(p) << 8) * _ROLZ_HASH_SEED) & _ROLZ_HASH_MASK
 }
 
+// Copy the match (minimum match length of 3 plus matchLen bytes)
 func emitCopy(buf []byte, dstIdx, ref, matchLen int) int {
-	buf[dstIdx] = buf[ref]
-	buf[dstIdx+1] = buf[ref+1]
-	buf[dstIdx+2] = buf[ref+2]
-	dstIdx += 3
-	ref += 3
-
-	for matchLen >= 8 {
-		buf[dstIdx] = buf[ref]
-		buf[dstIdx+1] = buf[ref+1]
-		buf[dstIdx+2] = buf[ref+2]
-		buf[dstIdx+3] = buf[ref+3]
-		buf[dstIdx+4] = buf[ref+4]
-		buf[dstIdx+5] = buf[ref+5]
-		buf[dstIdx+6] = buf[ref+6]
-		buf[dstIdx+7] = buf[ref+7]
-		dstIdx += 8
-		ref += 8
-		matchLen -= 8
-	}
-
-	for matchLen != 0 {
-		buf[dstIdx] = buf[ref]
-		dstIdx++
-		ref++
-		matchLen--
-	}
-
-	return dstIdx
+	copyMatch(buf, dstIdx, ref, matchLen+3)
+	return dstIdx + matchLen + 3
 }
 
 // ROLZCodec Reduced Offset Lempel Ziv codec
+// If the "rolzDictionary" context entry is set (even to an empty slice), the
+// match tables are primed with the last bytes of this dictionary (the
+// previous blocks of the stream) before the block is coded, so that a block
+// can refer to the data of the previous blocks. Each block then starts with
+// the number of dictionary bytes used (4 bytes), 0 after a reset of the
+// dictionary. The same dictionary must be provided to decode the block.
+// Streams of format version 9 or older have no dictionary.
 type ROLZCodec struct {
-	delegate kanzi.ByteFunction
+	delegate   rolzDelegate
+	dictionary []byte // nil unless the "rolzDictionary" context entry is set
+}
+
+// rolzDelegate codes the bytes of a block following 'start' bytes of
+// dictionary (the first bytes of the buffer)
+type rolzDelegate interface {
+	forward(src, dst []byte, start int) (uint, uint, error)
+
+	inverse(src, dst []byte, start int) (uint, uint, error)
+
+	MaxEncodedLen(srcLen int) int
 }
 
 // NewROLZCodec creates a new instance of ROLZCodec providing
 // he log of the number of matches to check for during encoding.
 func NewROLZCodec(logPosChecks uint) (*ROLZCodec, error) {
 	this := &ROLZCodec{}
-	d, err := newROLZCodec1(logPosChecks)
+	d, err := newROLZCodec1(logPosChecks, nil)
 	this.delegate = d
 	return this, err
 }
@@ -110,12 +112,12 @@ func NewROLZCodec(logPosChecks uint) (*ROLZCodec, error) {
 func NewROLZCodecWithFlag(extra bool) (*ROLZCodec, error) {
 	this := &ROLZCodec{}
 	var err error
-	var d kanzi.ByteFunction
+	var d rolzDelegate
 
 	if extra {
-		d, err = newROLZCodec2(_ROLZ_LOG_POS_CHECKS2)
+		d, err = newROLZCodec2(_ROLZ_LOG_POS_CHECKS2, nil)
 	} else {
-		d, err = newROLZCodec1(_ROLZ_LOG_POS_CHECKS1)
+		d, err = newROLZCodec1(_ROLZ_LOG_POS_CHECKS1, nil)
 	}
 
 	this.delegate = d
@@ -129,22 +131,35 @@ func NewROLZCodecWithFlag(extra bool) (*ROLZCodec, error) {
 func NewROLZCodecWithCtx(ctx *map[string]interface{}) (*ROLZCodec, error) {
 	this := &ROLZCodec{}
 	var err error
-	var d kanzi.ByteFunction
+	var d rolzDelegate
+	alloc := util.NewAllocatorWithCtx(ctx)
 
 	if val, containsKey := (*ctx)["transform"]; containsKey {
 		transform := val.(string)
 
 		if strings.Contains(transform, "ROLZX") {
-			d, err = newROLZCodec2(_ROLZ_LOG_POS_CHECKS2)
+			d, err = newROLZCodec2(_ROLZ_LOG_POS_CHECKS2, alloc)
 			this.delegate = d
 		}
 	}
 
 	if this.delegate == nil && err == nil {
-		d, err = newROLZCodec1(_ROLZ_LOG_POS_CHECKS1)
+		d, err = newROLZCodec1(_ROLZ_LOG_POS_CHECKS1, alloc)
 		this.delegate = d
 	}
 
+	if val, containsKey := (*ctx)["rolzDictionary"]; containsKey {
+		if version, containsKey := (*ctx)["bsVersion"]; containsKey && version.(uint) < 10 {
+			return nil, fmt.Errorf("ROLZCodec: A dictionary requires stream format version 10 (got %v)", version)
+		}
+
+		this.dictionary = val.([]byte)
+
+		if this.dictionary == nil {
+			this.dictionary = make([]byte, 0)
+		}
+	}
+
 	return this, err
 }
 
@@ -166,7 +181,42 @@ func (this *ROLZCodec) Forward(src, dst []byte) (uint, uint, error) {
 		panic(fmt.Errorf("The max ROLZ codec block size is %v, got %v", _ROLZ_MAX_BLOCK_SIZE, len(src)))
 	}
 
-	return this.delegate.Forward(src, dst)
+	if this.dictionary == nil {
+		return this.delegate.forward(src, dst, 0)
+	}
+
+	if len(dst) < this.MaxEncodedLen(len(src)) {
+		return 0, 0, fmt.Errorf("ROLZ codec: Output buffer is too small - size: %d, required %d", len(dst), this.MaxEncodedLen(len(src)))
+	}
+
+	dict := this.dictionary
+
+	if len(dict) > ROLZ_MAX_DICTIONARY_SIZE {
+		dict = dict[len(dict)-ROLZ_MAX_DICTIONARY_SIZE:]
+	}
+
+	// The positions of the dictionary and the block must fit in one chunk
+	if len(dict) < 4 || len(src) < _ROLZ_MIN_PRIMED_BLOCK || len(dict)+len(src) > _ROLZ_CHUNK_SIZE {
+		dict = dict[0:0]
+	}
+
+	binary.BigEndian.PutUint32(dst[0:], uint32(len(dict)))
+
+	if len(dict) == 0 {
+		srcIdx, dstIdx, err := this.delegate.forward(src, dst[4:], 0)
+		return srcIdx, dstIdx + 4, err
+	}
+
+	buf := make([]byte, len(dict)+len(src))
+	copy(buf, dict)
+	copy(buf[len(dict):], src)
+	srcIdx, dstIdx, err := this.delegate.forward(buf, dst[4:], len(dict))
+
+	if err == nil && dstIdx+4 >= uint(len(src)) {
+		err = errors.New("ROLZ codec: No compression")
+	}
+
+	return srcIdx - uint(len(dict)), dstIdx + 4, err
 }
 
 // Inverse applies the reverse function to the src and writes the result
@@ -187,14 +237,71 @@ func (this *ROLZCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		panic(fmt.Errorf("The max ROLZ codec block size is %v, got %v", _ROLZ_MAX_BLOCK_SIZE, len(src)))
 	}
 
-	return this.delegate.Inverse(src, dst)
+	if this.dictionary == nil {
+		return this.delegate.inverse(src, dst, 0)
+	}
+
+	if len(src) < 4 {
+		return 0, 0, errors.New("ROLZ codec: Invalid input data")
+	}
+
+	n := int(binary.BigEndian.Uint32(src[0:]))
+
+	if n > len(this.dictionary) || n > ROLZ_MAX_DICTIONARY_SIZE {
+		return 0, 0, fmt.Errorf("ROLZ codec: Invalid dictionary size in bitstream: %d (%d bytes available)", n, len(this.dictionary))
+	}
+
+	if n == 0 {
+		srcIdx, dstIdx, err := this.delegate.inverse(src[4:], dst, 0)
+		return srcIdx + 4, dstIdx, err
+	}
+
+	buf := make([]byte, n+len(dst))
+	copy(buf, this.dictionary[len(this.dictionary)-n:])
+	srcIdx, dstIdx, err := this.delegate.inverse(src[4:], buf, n)
+
+	if err != nil {
+		return srcIdx + 4, 0, err
+	}
+
+	copy(dst, buf[n:dstIdx])
+	return srcIdx + 4, dstIdx - uint(n), nil
+}
+
+// Release hands the match tables back to the shared pool in manual memory
+// mode (see util.Allocator). The codec must not be used after this call.
+func (this *ROLZCodec) Release() {
+	if r, ok := this.delegate.(interface{ Release() }); ok == true {
+		r.Release()
+	}
 }
 
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this *ROLZCodec) MaxEncodedLen(srcLen int) int {
+	if this.dictionary != nil {
+		return this.delegate.MaxEncodedLen(srcLen) + 4
+	}
+
 	return this.delegate.MaxEncodedLen(srcLen)
 }
 
+// rolzPrime registers the positions of the dictionary (buf[0:end]) in the
+// match tables, in the order of a block made of literals only. The encoder
+// stores the hash of the next bytes with each position (see findMatch).
+func rolzPrime(matches []uint32, counters []int32, logPosChecks uint, maskChecks int32, buf []byte, end int, hashed bool) {
+	for pos := 2; pos < end; pos++ {
+		key := getKey(buf[pos-2:])
+		counters[key]++
+		val := uint32(pos)
+
+		if hashed == true {
+			val |= rolzhash(buf[pos : pos+4])
+		}
+
+		matches[(key<<logPosChecks)+uint32(counters[key]&maskChecks)] = val
+	}
+}
+
 // Use ANS to encode/decode literals and matches
 type rolzCodec1 struct {
 	matches      []uint32
@@ -202,9 +309,10 @@ type rolzCodec1 struct {
 	logPosChecks uint
 	maskChecks   int32
 	posChecks    int32
+	alloc        *util.Allocator
 }
 
-func newROLZCodec1(logPosChecks uint) (*rolzCodec1, error) {
+func newROLZCodec1(logPosChecks uint, alloc *util.Allocator) (*rolzCodec1, error) {
 	this := &rolzCodec1{}
 
 	if (logPosChecks < 2) || (logPosChecks > 8) {
@@ -214,8 +322,9 @@ func newROLZCodec1(logPosChecks uint) (*rolzCodec1, error) {
 	this.logPosChecks = logPosChecks
 	this.posChecks = 1 << logPosChecks
 	this.maskChecks = this.posChecks - 1
+	this.alloc = alloc
 	this.counters = make([]int32, 1<<16)
-	this.matches = make([]uint32, _ROLZ_HASH_SIZE<<logPosChecks)
+	this.matches = alloc.MakeUint32(_ROLZ_HASH_SIZE << logPosChecks)
 	return this, nil
 }
 
@@ -293,26 +402,27 @@ func (this *rolzCodec1) findMatch(buf []byte, pos int) (int, int) {
 	return bestIdx, bestLen - _ROLZ_MIN_MATCH
 }
 
-// Forward applies the function to the src and writes the result
-// to the destination. Returns number of bytes read, number of bytes
-// written and possibly an error.
-func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
-	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
+// forward encodes the bytes of src following 'start' bytes of dictionary.
+// Returns the position in src after the last byte encoded, the number of
+// bytes written and possibly an error.
+func (this *rolzCodec1) forward(src, dst []byte, start int) (uint, uint, error) {
+	if n := this.MaxEncodedLen(len(src) - start); len(dst) < n {
 		return 0, 0, fmt.Errorf("ROLZ codec: Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
 	srcIdx := 0
 	dstIdx := 0
 	srcEnd := len(src) - 4
-	binary.BigEndian.PutUint32(dst[dstIdx:], uint32(len(src)))
+	binary.BigEndian.PutUint32(dst[dstIdx:], uint32(len(src)-start))
 	dstIdx += 4
-	sizeChunk := len(src)
+	sizeChunk := len(src) - start
 
 	if sizeChunk > _ROLZ_CHUNK_SIZE {
 		sizeChunk = _ROLZ_CHUNK_SIZE
 	}
 
-	startChunk := 0
+	startChunk := start
+	bufStart := start
 	litBuf := make([]byte, this.MaxEncodedLen(sizeChunk))
 	lenBuf := make([]byte, sizeChunk/4)
 	mIdxBuf := make([]byte, sizeChunk/4)
@@ -324,7 +434,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 
 	litOrder := uint(1)
 
-	if len(src) < 1<<17 {
+	if len(src)-start < 1<<17 {
 		litOrder = 0
 	}
 
@@ -348,22 +458,34 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 			sizeChunk = endChunk - startChunk
 		}
 
-		buf := src[startChunk:endChunk]
-		srcIdx = 0
-		litBuf[litIdx] = buf[srcIdx]
-		litIdx++
-		srcIdx++
+		// The first chunk starts after the dictionary
+		bufStart = startChunk
 
-		if startChunk+1 < srcEnd {
+		if startChunk == start {
+			bufStart = 0
+		}
+
+		buf := src[bufStart:endChunk]
+		srcIdx = startChunk - bufStart
+
+		if srcIdx == 0 {
 			litBuf[litIdx] = buf[srcIdx]
 			litIdx++
 			srcIdx++
+
+			if startChunk+1 < srcEnd {
+				litBuf[litIdx] = buf[srcIdx]
+				litIdx++
+				srcIdx++
+			}
+		} else {
+			rolzPrime(this.matches, this.counters, this.logPosChecks, this.maskChecks, buf, srcIdx, true)
 		}
 
 		firstLitIdx := srcIdx
 
 		// Next chunk
-		for srcIdx < sizeChunk {
+		for srcIdx < len(buf) {
 			matchIdx, matchLen := this.findMatch(buf, srcIdx)
 
 			if matchIdx < 0 {
@@ -463,7 +585,7 @@ End:
 			err = errors.New("ROLZ codec: Destination buffer too small")
 		} else {
 			// Emit last literals
-			srcIdx += (startChunk - sizeChunk)
+			srcIdx += bufStart
 			dst[dstIdx] = src[srcIdx]
 			dst[dstIdx+1] = src[srcIdx+1]
 			dst[dstIdx+2] = src[srcIdx+2]
@@ -473,7 +595,7 @@ End:
 
 			if srcIdx != len(src) {
 				err = errors.New("ROLZ codec: Destination buffer too small")
-			} else if dstIdx >= len(src) {
+			} else if dstIdx >= len(src)-start {
 				err = errors.New("ROLZ codec: No compression")
 			}
 		}
@@ -482,19 +604,20 @@ End:
 	return uint(srcIdx), uint(dstIdx), err
 }
 
-// Inverse applies the reverse function to the src and writes the result
-// to the destination. Returns number of bytes read, number of bytes
-// written and possibly an error.
-func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
-	sizeChunk := len(dst)
+// inverse decodes src into dst after 'start' bytes of dictionary (already in
+// dst). Returns the number of bytes read, the position in dst after the last
+// byte decoded and possibly an error.
+func (this *rolzCodec1) inverse(src, dst []byte, start int) (uint, uint, error) {
+	sizeChunk := len(dst) - start
 
 	if sizeChunk > _ROLZ_CHUNK_SIZE {
 		sizeChunk = _ROLZ_CHUNK_SIZE
 	}
 
-	startChunk := 0
+	startChunk := start
+	bufStart := start
 	var is util.BufferStream
-	dstEnd := int(binary.BigEndian.Uint32(src[0:])) - 4
+	dstEnd := start + int(binary.BigEndian.Uint32(src[0:])) - 4
 
 	if _, err := is.Write(src[4:]); err != nil {
 		return 0, 0, err
@@ -531,7 +654,15 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 		}
 
 		sizeChunk = endChunk - startChunk
-		buf := dst[startChunk:endChunk]
+
+		// The first chunk starts after the dictionary
+		bufStart = startChunk
+
+		if startChunk == start {
+			bufStart = 0
+		}
+
+		buf := dst[bufStart:endChunk]
 
 		// Scope to deallocate resources early
 		{
@@ -595,19 +726,24 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 			ibs.Close()
 		}
 
-		dstIdx = 0
-		buf[dstIdx] = litBuf[litIdx]
-		dstIdx++
-		litIdx++
+		dstIdx = startChunk - bufStart
 
-		if startChunk+1 < dstEnd {
+		if dstIdx == 0 {
 			buf[dstIdx] = litBuf[litIdx]
 			dstIdx++
 			litIdx++
+
+			if startChunk+1 < dstEnd {
+				buf[dstIdx] = litBuf[litIdx]
+				dstIdx++
+				litIdx++
+			}
+		} else {
+			rolzPrime(this.matches, this.counters, this.logPosChecks, this.maskChecks, buf, dstIdx, false)
 		}
 
 		// Next chunk
-		for dstIdx < sizeChunk {
+		for dstIdx < len(buf) {
 			litLen, matchLen, deltaIdx := this.readLengths(lenBuf[lenIdx:])
 			lenIdx += deltaIdx
 
@@ -616,9 +752,9 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 				litIdx += litLen
 				dstIdx += litLen
 
-				if dstIdx >= sizeChunk {
+				if dstIdx >= len(buf) {
 					// Last chunk literals not followed by match
-					if dstIdx == sizeChunk {
+					if dstIdx == len(buf) {
 						break
 					}
 
@@ -628,7 +764,7 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 			}
 
 			// Sanity check
-			if dstIdx+matchLen+_ROLZ_MIN_MATCH > dstEnd {
+			if bufStart+dstIdx+matchLen+_ROLZ_MIN_MATCH > dstEnd {
 				err = errors.New("ROLZ codec: Invalid input data")
 				goto End
 			}
@@ -650,7 +786,7 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 End:
 	if err == nil {
 		// Emit last literals
-		dstIdx += (startChunk - sizeChunk)
+		dstIdx += bufStart
 		dst[dstIdx] = src[srcIdx]
 		dst[dstIdx+1] = src[srcIdx+1]
 		dst[dstIdx+2] = src[srcIdx+2]
@@ -666,6 +802,12 @@ End:
 	return uint(srcIdx), uint(dstIdx), err
 }
 
+// Release returns the match table to the pool in manual memory mode
+func (this *rolzCodec1) Release() {
+	this.alloc.ReleaseUint32(this.matches)
+	this.matches = nil
+}
+
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this rolzCodec1) MaxEncodedLen(srcLen int) int {
 	if srcLen <= 512 {
@@ -782,9 +924,10 @@ type rolzCodec2 struct {
 	logPosChecks uint
 	maskChecks   int32
 	posChecks    int32
+	alloc        *util.Allocator
 }
 
-func newROLZCodec2(logPosChecks uint) (*rolzCodec2, error) {
+func newROLZCodec2(logPosChecks uint, alloc *util.Allocator) (*rolzCodec2, error) {
 	this := &rolzCodec2{}
 
 	if (logPosChecks < 2) || (logPosChecks > 8) {
@@ -794,8 +937,9 @@ func newROLZCodec2(logPosChecks uint) (*rolzCodec2, error) {
 	this.logPosChecks = logPosChecks
 	this.posChecks = 1 << logPosChecks
 	this.maskChecks = this.posChecks - 1
+	this.alloc = alloc
 	this.counters = make([]int32, 1<<16)
-	this.matches = make([]uint32, _ROLZ_HASH_SIZE<<logPosChecks)
+	this.matches = alloc.MakeUint32(_ROLZ_HASH_SIZE << logPosChecks)
 	return this, nil
 }
 
@@ -873,25 +1017,26 @@ func (this *rolzCodec2) findMatch(buf []byte, pos int) (int, int) {
 	return bestIdx, bestLen - _ROLZ_MIN_MATCH
 }
 
-// Forward applies the function to the src and writes the result
-// to the destination. Returns number of bytes read, number of bytes
-// written and possibly an error.
-func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
-	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
+// forward encodes the bytes of src following 'start' bytes of dictionary.
+// Returns the position in src after the last byte encoded, the number of
+// bytes written and possibly an error.
+func (this *rolzCodec2) forward(src, dst []byte, start int) (uint, uint, error) {
+	if n := this.MaxEncodedLen(len(src) - start); len(dst) < n {
 		return 0, 0, fmt.Errorf("ROLZX codec: Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
 	srcIdx := 0
 	dstIdx := 0
 	srcEnd := len(src) - 4
-	sizeChunk := len(src)
+	sizeChunk := len(src) - start
 
 	if sizeChunk > _ROLZ_CHUNK_SIZE {
 		sizeChunk = _ROLZ_CHUNK_SIZE
 	}
 
-	startChunk := 0
-	binary.BigEndian.PutUint32(dst[dstIdx:], uint32(len(src)))
+	startChunk := start
+	bufStart := start
+	binary.BigEndian.PutUint32(dst[dstIdx:], uint32(len(src)-start))
 	dstIdx += 4
 	re, _ := newRolzEncoder(9, this.logPosChecks, dst, &dstIdx)
 
@@ -913,22 +1058,34 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 
 		sizeChunk = endChunk - startChunk
 		re.reset()
-		buf := src[startChunk:endChunk]
-		srcIdx = 0
 
-		// First literals
-		re.setMode(_ROLZ_LITERAL_FLAG)
-		re.setContext(0)
-		re.encodeBits((_ROLZ_LITERAL_FLAG<<8)|int(buf[srcIdx]), 9)
-		srcIdx++
+		// The first chunk starts after the dictionary
+		bufStart = startChunk
+
+		if startChunk == start {
+			bufStart = 0
+		}
+
+		buf := src[bufStart:endChunk]
+		srcIdx = startChunk - bufStart
 
-		if startChunk+1 < srcEnd {
+		if srcIdx == 0 {
+			// First literals
+			re.setMode(_ROLZ_LITERAL_FLAG)
+			re.setContext(0)
 			re.encodeBits((_ROLZ_LITERAL_FLAG<<8)|int(buf[srcIdx]), 9)
 			srcIdx++
+
+			if startChunk+1 < srcEnd {
+				re.encodeBits((_ROLZ_LITERAL_FLAG<<8)|int(buf[srcIdx]), 9)
+				srcIdx++
+			}
+		} else {
+			rolzPrime(this.matches, this.counters, this.logPosChecks, this.maskChecks, buf, srcIdx, true)
 		}
 
 		// Next chunk
-		for srcIdx < sizeChunk {
+		for srcIdx < len(buf) {
 			re.setMode(_ROLZ_LITERAL_FLAG)
 			re.setContext(buf[srcIdx-1])
 			matchIdx, matchLen := this.findMatch(buf, srcIdx)
@@ -952,7 +1109,7 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	// Emit last literals
-	srcIdx += (startChunk - sizeChunk)
+	srcIdx += bufStart
 	re.setMode(_ROLZ_LITERAL_FLAG)
 
 	for i := 0; i < 4; i++ {
@@ -966,29 +1123,30 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 
 	if srcIdx != len(src) {
 		err = errors.New("ROLZX codec: Destination buffer too small")
-	} else if dstIdx >= len(src) {
+	} else if dstIdx >= len(src)-start {
 		err = errors.New("ROLZX codec: No compression")
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
 }
 
-// Inverse applies the reverse function to the src and writes the result
-// to the destination. Returns number of bytes read, number of bytes
-// written and possibly an error.
-func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
+// inverse decodes src into dst after 'start' bytes of dictionary (already in
+// dst). Returns the number of bytes read, the position in dst after the last
+// byte decoded and possibly an error.
+func (this *rolzCodec2) inverse(src, dst []byte, start int) (uint, uint, error) {
 	srcIdx := 0
 	dstIdx := 0
-	dstEnd := int(binary.BigEndian.Uint32(src[srcIdx:]))
+	dstEnd := start + int(binary.BigEndian.Uint32(src[srcIdx:]))
 
 	srcIdx += 4
-	sizeChunk := len(dst)
+	sizeChunk := len(dst) - start
 
 	if sizeChunk > _ROLZ_CHUNK_SIZE {
 		sizeChunk = _ROLZ_CHUNK_SIZE
 	}
 
-	startChunk := 0
+	startChunk := start
+	bufStart := start
 	rd, _ := newRolzDecoder(9, this.logPosChecks, src, &srcIdx)
 
 	for i := range this.counters {
@@ -1008,26 +1166,22 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 			sizeChunk = endChunk - startChunk
 		}
 
-		buf := dst[startChunk:endChunk]
-		rd.reset()
-		dstIdx = 0
-
-		// First literals
-		rd.setMode(_ROLZ_LITERAL_FLAG)
-		rd.setContext(0)
-		val := rd.decodeBits(9)
+		// The first chunk starts after the dictionary
+		bufStart = startChunk
 
-		// Sanity check
-		if val>>8 == _ROLZ_MATCH_FLAG {
-			dstIdx += startChunk
-			break
+		if startChunk == start {
+			bufStart = 0
 		}
 
-		buf[dstIdx] = byte(val)
-		dstIdx++
+		buf := dst[bufStart:endChunk]
+		rd.reset()
+		dstIdx = startChunk - bufStart
 
-		if startChunk+1 < dstEnd {
-			val = rd.decodeBits(9)
+		if dstIdx == 0 {
+			// First literals
+			rd.setMode(_ROLZ_LITERAL_FLAG)
+			rd.setContext(0)
+			val := rd.decodeBits(9)
 
 			// Sanity check
 			if val>>8 == _ROLZ_MATCH_FLAG {
@@ -1037,10 +1191,26 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 
 			buf[dstIdx] = byte(val)
 			dstIdx++
+
+			if startChunk+1 < dstEnd {
+				val = rd.decodeBits(9)
+
+				// Sanity check
+				if val>>8 == _ROLZ_MATCH_FLAG {
+					dstIdx += startChunk
+					break
+				}
+
+				buf[dstIdx] = byte(val)
+				dstIdx++
+			}
+		} else {
+			rd.setMode(_ROLZ_LITERAL_FLAG)
+			rolzPrime(this.matches, this.counters, this.logPosChecks, this.maskChecks, buf, dstIdx, false)
 		}
 
 		// Next chunk
-		for dstIdx < sizeChunk {
+		for dstIdx < len(buf) {
 			savedIdx := dstIdx
 			key := getKey(buf[dstIdx-2:])
 			m := this.matches[key<<this.logPosChecks:]
@@ -1078,7 +1248,7 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 
 	rd.dispose()
 	var err error
-	dstIdx += (startChunk - sizeChunk)
+	dstIdx += bufStart
 
 	if srcIdx != len(src) {
 		err = errors.New("ROLZX codec: Invalid input data")
@@ -1087,6 +1257,12 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 	return uint(srcIdx), uint(dstIdx), err
 }
 
+// Release returns the match table to the pool in manual memory mode
+func (this *rolzCodec2) Release() {
+	this.alloc.ReleaseUint32(this.matches)
+	this.matches = nil
+}
+
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this rolzCodec2) MaxEncodedLen(srcLen int) int {
 	// Since we do not check the dst index for each byte (for speed purpose)
@@ -21,15 +21,31 @@ import (
 )
 
 const (
-	_SRT_MAX_HEADER_SIZE = 4 * 256
+	_SRT_MAX_HEADER_SIZE  = 4 * 256
+	_SRT_MIN_CHUNK_SIZE   = 1 << 12
+	_SRT_CHUNK_MARKER_LEN = 2
 )
 
 // SRT Sorted Ranks Transform
 // Sorted Ranks Transform is typically used after a BWT to reduce the variance
 // of the data prior to entropy coding.
+// If the "srtChunkSize" context entry is set, the ranks are reset at the
+// start of each chunk of the block (each chunk is transformed independently,
+// with its own symbol frequencies), which adapts faster to blocks with
+// rapidly changing statistics (EG. mixed text and binary data) at the cost
+// of a header per chunk. The chunks are only used in streams of format
+// version 10 or newer ("bsVersion" context entry).
 type SRT struct {
+	chunkSize int  // 0 => no reset
+	legacy    bool // stream format version 9 or older: single chunk
 }
 
+// Block format: Chunk or Marker Chunk+
+// Marker: 0x80 0x00 (a frequency of 0 with a redundant continuation byte,
+// never written by the single chunk format)
+// Chunk: symbol frequencies (256 varints) then ranks. The size of the chunk
+// is the sum of the frequencies.
+
 // NewSRT creates a new instance of SRT
 func NewSRT() (*SRT, error) {
 	this := &SRT{}
@@ -40,6 +56,27 @@ func NewSRT() (*SRT, error) {
 // configuration map as parameter.
 func NewSRTWithCtx(ctx *map[string]interface{}) (*SRT, error) {
 	this := &SRT{}
+
+	if val, containsKey := (*ctx)["bsVersion"]; containsKey {
+		this.legacy = val.(uint) < 10
+	}
+
+	if val, containsKey := (*ctx)["srtChunkSize"]; containsKey {
+		chunkSize := val.(uint)
+
+		if chunkSize != 0 && (chunkSize < _SRT_MIN_CHUNK_SIZE || chunkSize > 1<<30) {
+			return nil, fmt.Errorf("Invalid SRT chunk size parameter: %v (must be 0 or in [%d..%d])",
+				chunkSize, _SRT_MIN_CHUNK_SIZE, 1<<30)
+		}
+
+		this.chunkSize = int(chunkSize)
+	}
+
+	if this.chunkSize != 0 && this.legacy == true {
+		return nil, fmt.Errorf("SRT: Chunk size %v requires stream format version 10 (got %v)",
+			this.chunkSize, (*ctx)["bsVersion"])
+	}
+
 	return this, nil
 }
 
@@ -59,6 +96,32 @@ func (this *SRT) Forward(src, dst []byte) (uint, uint, error) {
 		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
+	count := len(src)
+
+	if this.chunkSize == 0 || count <= this.chunkSize {
+		return uint(count), uint(this.forwardChunk(src, dst)), nil
+	}
+
+	dst[0] = 0x80
+	dst[1] = 0
+	dstIdx := _SRT_CHUNK_MARKER_LEN
+
+	for i := 0; i < count; i += this.chunkSize {
+		end := i + this.chunkSize
+
+		if end > count {
+			end = count
+		}
+
+		dstIdx += this.forwardChunk(src[i:end], dst[dstIdx:])
+	}
+
+	return uint(count), uint(dstIdx), nil
+}
+
+// forwardChunk writes the frequencies and the ranks of src to dst and
+// returns the number of bytes written
+func (this *SRT) forwardChunk(src, dst []byte) int {
 	count := len(src)
 	s2r := [256]byte{}
 	r2s := [256]byte{}
@@ -132,7 +195,7 @@ func (this *SRT) Forward(src, dst []byte) (uint, uint, error) {
 		i = j
 	}
 
-	return uint(count), uint(count + headerSize), nil
+	return count + headerSize
 }
 
 func (this SRT) preprocess(freqs []int32, symbols []byte) int {
@@ -187,12 +250,66 @@ func (this *SRT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
-	// init arrays
-	freqs := [256]int32{}
-	headerSize := this.decodeHeader(src, freqs[:])
-	src = src[headerSize:]
+	if len(src) < _SRT_CHUNK_MARKER_LEN || src[0] != 0x80 || src[1] != 0 {
+		freqs := [256]int32{}
+		headerSize := this.decodeHeader(src, freqs[:])
+		count := len(src) - headerSize
+
+		if len(dst) < count {
+			return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+		}
+
+		this.inverseChunk(src[headerSize:], dst[0:count], freqs[:])
+		return uint(len(src)), uint(count), nil
+	}
+
+	if this.legacy == true {
+		return 0, 0, errors.New("Invalid SRT block: chunks in a stream of format version 9 or older")
+	}
+
+	srcIdx := _SRT_CHUNK_MARKER_LEN
+	dstIdx := 0
+
+	for srcIdx < len(src) {
+		header := src[srcIdx:]
+
+		if len(header) < _SRT_MAX_HEADER_SIZE {
+			// Do not read past the end of the block
+			header = make([]byte, _SRT_MAX_HEADER_SIZE)
+			copy(header, src[srcIdx:])
+		}
+
+		freqs := [256]int32{}
+		headerSize := this.decodeHeader(header, freqs[:])
+
+		if headerSize > len(src)-srcIdx {
+			return 0, 0, errors.New("Invalid SRT block: truncated chunk header")
+		}
+
+		srcIdx += headerSize
+		count := 0
+
+		for _, f := range freqs {
+			count += int(f)
+		}
+
+		if count == 0 || count > len(src)-srcIdx || count > len(dst)-dstIdx {
+			return 0, 0, errors.New("Invalid SRT block: incorrect chunk size")
+		}
+
+		this.inverseChunk(src[srcIdx:srcIdx+count], dst[dstIdx:dstIdx+count], freqs[:])
+		srcIdx += count
+		dstIdx += count
+	}
+
+	return uint(srcIdx), uint(dstIdx), nil
+}
+
+// inverseChunk writes the symbols of the ranks of src to dst given the
+// frequencies of the symbols
+func (this *SRT) inverseChunk(src, dst []byte, freqs []int32) {
 	symbols := [256]byte{}
-	nbSymbols := this.preprocess(freqs[:], symbols[:])
+	nbSymbols := this.preprocess(freqs, symbols[:])
 	buckets := [256]int{}
 	bucketEnds := [256]int{}
 	r2s := [256]byte{}
@@ -241,8 +358,6 @@ func (this *SRT) Inverse(src, dst []byte) (uint, uint, error) {
 			c = r2s[0]
 		}
 	}
-
-	return uint(len(src) + headerSize), uint(len(src)), nil
 }
 
 func (this SRT) encodeHeader(freqs []int32, dst []byte) int {
@@ -299,5 +414,10 @@ func (this SRT) decodeHeader(src []byte, freqs []int32) int {
 
 // MaxEncodedLen returns the max size required for the encoding output buffer
 func (this SRT) MaxEncodedLen(srcLen int) int {
-	return srcLen + _SRT_MAX_HEADER_SIZE
+	if this.chunkSize == 0 || srcLen <= this.chunkSize {
+		return srcLen + _SRT_MAX_HEADER_SIZE
+	}
+
+	chunks := (srcLen + this.chunkSize - 1) / this.chunkSize
+	return srcLen + _SRT_CHUNK_MARKER_LEN + chunks*_SRT_MAX_HEADER_SIZE
 }
@@ -0,0 +1,154 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flanglet/kanzi-go/transform"
+)
+
+const (
+	ST_MAX_HEADER_SIZE = 4
+)
+
+// Utility class to en/de-code a ST data block and its primary index
+
+// ST stream format: Header (m bytes) Data (n bytes)
+// Header: mode (8 bits) + primary index (8,16 or 24 bits), same as the
+// header of a BWT block with one primary index (see BWTBlockCodec)
+
+// STBlockCodec a codec that encapsulates a Schindler Transform and takes care
+// of encoding/decoding the primary index in a header.
+type STBlockCodec struct {
+	st *transform.ST
+}
+
+// NewSTBlockCodec creates a new instance of STBlockCodec sorting on 'order'
+// bytes
+func NewSTBlockCodec(order uint) (*STBlockCodec, error) {
+	this := &STBlockCodec{}
+	var err error
+	this.st, err = transform.NewST(order)
+	return this, err
+}
+
+// NewSTBlockCodecWithCtx creates a new instance of STBlockCodec
+func NewSTBlockCodecWithCtx(ctx *map[string]interface{}) (*STBlockCodec, error) {
+	this := &STBlockCodec{}
+	var err error
+	this.st, err = transform.NewSTWithCtx(ctx)
+	return this, err
+}
+
+// Release releases the buffers of the ST
+func (this *STBlockCodec) Release() {
+	this.st.Release()
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *STBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	blockSize := len(src)
+
+	if len(dst) < this.MaxEncodedLen(blockSize) {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d",
+			len(dst), this.MaxEncodedLen(blockSize))
+	}
+
+	// The primary index is smaller than the block size
+	pIndexSizeBits := uint(6)
+
+	for 1<<pIndexSizeBits < blockSize {
+		pIndexSizeBits++
+	}
+
+	pIndexSizeBytes := (2 + pIndexSizeBits + 7) >> 3
+
+	// Apply forward Transform
+	iIdx, oIdx, err := this.st.Forward(src, dst[pIndexSizeBytes:])
+
+	if err != nil {
+		return iIdx, oIdx, err
+	}
+
+	// Write block header (mode + primary index). See top of file for format
+	primaryIndex := this.st.PrimaryIndex()
+	shift := (pIndexSizeBytes - 1) << 3
+	blockMode := (pIndexSizeBits + 1) >> 3
+	blockMode = (blockMode << 6) | ((primaryIndex >> shift) & 0x3F)
+	dst[0] = byte(blockMode)
+	idx := 1
+
+	for shift >= 8 {
+		shift -= 8
+		dst[idx] = byte(primaryIndex >> shift)
+		idx++
+	}
+
+	return iIdx, oIdx + pIndexSizeBytes, nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *STBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	// Read block header (mode + primary index). See top of file for format
+	blockMode := uint(src[0])
+	pIndexSizeBytes := 1 + ((blockMode >> 6) & 0x03)
+
+	if uint(len(src)) < pIndexSizeBytes {
+		return 0, 0, errors.New("Invalid compressed length in bitstream")
+	}
+
+	shift := (pIndexSizeBytes - 1) << 3
+	primaryIndex := (blockMode & 0x3F) << shift
+
+	// Extract ST primary index
+	for i := uint(1); i < pIndexSizeBytes; i++ {
+		shift -= 8
+		primaryIndex |= uint(src[i]) << shift
+	}
+
+	this.st.SetPrimaryIndex(primaryIndex)
+
+	// Apply inverse Transform
+	iIdx, oIdx, err := this.st.Inverse(src[pIndexSizeBytes:], dst)
+	return iIdx + pIndexSizeBytes, oIdx, err
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this STBlockCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + ST_MAX_HEADER_SIZE
+}
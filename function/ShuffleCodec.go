@@ -0,0 +1,233 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/flanglet/kanzi-go/entropy"
+)
+
+// ShuffleCodec is a codec for arrays of fixed width numeric values (2, 4 or
+// 8 bytes) such as float32/float64 dumps: the bytes of the values are
+// transposed (byte j of every value goes to plane j), like the Blosc shuffle
+// filter. The sign and exponent bytes of floating point values and the high
+// bytes of integers become long runs of similar bytes and the noisy low
+// bytes are kept apart.
+// The width is given by the "shuffleWidth" context entry (2, 4 or 8).
+// Otherwise, it is guessed by estimating the entropy of the planes.
+
+// Shuffle block format: Mode (1 byte) Planes Tail
+// Mode: log2(width) in the 2 low bits
+// Planes: one per byte of the values, one byte per value in each plane
+// Tail: the bytes after the last value, unchanged
+
+const (
+	_SHUFFLE_HEADER_SIZE    = 1
+	_SHUFFLE_MIN_BLOCK_SIZE = 4096    // smaller planes look less random
+	_SHUFFLE_SAMPLE_SIZE    = 1 << 16 // max number of bytes used to guess the width
+)
+
+// ShuffleCodec a byte transposition codec for numeric arrays
+type ShuffleCodec struct {
+	width int // 0 => guess the width of each block
+}
+
+// NewShuffleCodec creates a new instance of ShuffleCodec
+func NewShuffleCodec() (*ShuffleCodec, error) {
+	this := &ShuffleCodec{}
+	return this, nil
+}
+
+// NewShuffleCodecWithCtx creates a new instance of ShuffleCodec using a
+// configuration map as parameter.
+func NewShuffleCodecWithCtx(ctx *map[string]interface{}) (*ShuffleCodec, error) {
+	this := &ShuffleCodec{}
+
+	if val, containsKey := (*ctx)["shuffleWidth"]; containsKey {
+		width := int(val.(uint))
+
+		if width != 2 && width != 4 && width != 8 {
+			return nil, fmt.Errorf("Invalid shuffle width parameter: %v (must be 2, 4 or 8)", width)
+		}
+
+		this.width = width
+	}
+
+	return this, nil
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error. If the width is not given and the
+// transposition does not reduce the entropy of the block, an error is
+// returned.
+func (this *ShuffleCodec) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if n := this.MaxEncodedLen(count); len(dst) < n {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+	}
+
+	width := this.width
+
+	if width == 0 {
+		if count < _SHUFFLE_MIN_BLOCK_SIZE {
+			return 0, 0, errors.New("Block too small, skip")
+		}
+
+		if width = guessShuffleWidth(src); width == 0 {
+			return 0, 0, errors.New("No gain from byte transposition")
+		}
+	}
+
+	dst[0] = byte(bits.Len(uint(width)) - 1)
+	shuffle(src, dst[_SHUFFLE_HEADER_SIZE:_SHUFFLE_HEADER_SIZE+count], width)
+	return uint(count), uint(count + _SHUFFLE_HEADER_SIZE), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *ShuffleCodec) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src) - _SHUFFLE_HEADER_SIZE
+
+	if src[0] == 0 || src[0] > 3 {
+		return 0, 0, errors.New("Invalid shuffle block: incorrect header")
+	}
+
+	if len(dst) < count {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), count)
+	}
+
+	unshuffle(src[_SHUFFLE_HEADER_SIZE:], dst[0:count], 1<<src[0])
+	return uint(len(src)), uint(count), nil
+}
+
+// shuffle groups byte j of all values: dst[j*count+i] = src[i*width+j]
+func shuffle(src, dst []byte, width int) {
+	count := len(src) / width
+
+	for j := 0; j < width; j++ {
+		d := dst[j*count : (j+1)*count]
+
+		for i, k := 0, j; i < count; i, k = i+1, k+width {
+			d[i] = src[k]
+		}
+	}
+
+	copy(dst[count*width:], src[count*width:])
+}
+
+func unshuffle(src, dst []byte, width int) {
+	count := len(src) / width
+
+	for j := 0; j < width; j++ {
+		s := src[j*count : (j+1)*count]
+
+		for i, k := 0, j; i < count; i, k = i+1, k+width {
+			dst[k] = s[i]
+		}
+	}
+
+	copy(dst[count*width:], src[count*width:])
+}
+
+// guessShuffleWidth returns the width giving the smallest entropy of the
+// planes of the first bytes of the block or 0 if the entropy is not clearly
+// smaller than the entropy of the block itself.
+func guessShuffleWidth(src []byte) int {
+	length := len(src)
+
+	if length > _SHUFFLE_SAMPLE_SIZE {
+		length = _SHUFFLE_SAMPLE_SIZE
+	}
+
+	block := src[0:length]
+	histo := make([]int, 256)
+	buf1 := make([]byte, length)
+	buf2 := make([]byte, length)
+	best := 0
+	bestCost := 0
+
+	for width := 2; width <= 8; width <<= 1 {
+		shuffle(block, buf1, width)
+		count := length / width
+		cost := 0
+
+		for j := 0; j < width; j++ {
+			cost += planeEntropy(buf1[j*count:(j+1)*count], buf2, histo) * count
+		}
+
+		if best == 0 || cost < bestCost {
+			bestCost = cost
+			best = width
+		}
+	}
+
+	if 10*bestCost >= 9*planeEntropy(block, buf2, histo)*(length/best*best) {
+		return 0
+	}
+
+	return best
+}
+
+// planeEntropy returns the smallest order 0 entropy of the plane and of the
+// differences of its consecutive bytes (EG. smooth high bytes of values)
+func planeEntropy(plane, buf []byte, histo []int) int {
+	if len(plane) == 0 {
+		return 0
+	}
+
+	buf = buf[0:len(plane)]
+	buf[0] = plane[0]
+
+	for i := 1; i < len(plane); i++ {
+		buf[i] = plane[i] - plane[i-1]
+	}
+
+	e1 := entropy.ComputeFirstOrderEntropy1024(plane, histo)
+	e2 := entropy.ComputeFirstOrderEntropy1024(buf, histo)
+
+	if e2 < e1 {
+		return e2
+	}
+
+	return e1
+}
+
+// MaxEncodedLen returns the max size required for the encoding output buffer
+func (this ShuffleCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen + _SHUFFLE_HEADER_SIZE
+}
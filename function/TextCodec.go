@@ -27,6 +27,9 @@ const (
 	LF = byte(0x0A)
 	// CR Carriage Return symbol
 	CR = byte(0x0D)
+	// TC_MAX_DICTIONARY_WORDS is the max number of words in a static dictionary
+	// provided with the "textDictionary" context entry
+	TC_MAX_DICTIONARY_WORDS = 4096
 
 	_TC_THRESHOLD1             = 128
 	_TC_THRESHOLD2             = _TC_THRESHOLD1 * _TC_THRESHOLD1
@@ -56,6 +59,9 @@ type dictEntry struct {
 
 // TextCodec is a simple one-pass text codec that replaces words with indexes.
 // Uses a default (small) static dictionary. Generates a dynamic dictionary.
+// Another static dictionary can be provided with the "textDictionary" context
+// entry: a []byte of concatenated words starting with an upper case letter
+// (EG. "TheBeAndOf"). The same dictionary must be provided to the decoder.
 type TextCodec struct {
 	delegate kanzi.ByteFunction
 }
@@ -63,6 +69,7 @@ type TextCodec struct {
 type textCodec1 struct {
 	dictMap        []*dictEntry
 	dictList       []dictEntry
+	staticDict     []dictEntry
 	staticDictSize int
 	dictSize       int
 	logHashSize    uint
@@ -73,6 +80,7 @@ type textCodec1 struct {
 type textCodec2 struct {
 	dictMap        []*dictEntry
 	dictList       []dictEntry
+	staticDict     []dictEntry
 	staticDictSize int
 	dictSize       int
 	logHashSize    uint
@@ -348,6 +356,28 @@ func initDelimiterChars() []bool {
 	return res[:]
 }
 
+// Return the static dictionary provided in the context or the default one
+func getStaticDictionary(ctx *map[string]interface{}) ([]dictEntry, error) {
+	val, containsKey := (*ctx)["textDictionary"]
+
+	if containsKey == false {
+		return _TC_STATIC_DICTIONARY[0:_TC_STATIC_DICT_WORDS], nil
+	}
+
+	// createDictionary modifies the words
+	words := append([]byte{}, val.([]byte)...)
+	dict := make([]dictEntry, TC_MAX_DICTIONARY_WORDS)
+	nbWords := createDictionary(words, dict, len(dict), 0)
+
+	for i := 0; i < nbWords; i++ {
+		if length := dict[i].data >> 24; length < 2 || length > _TC_MAX_WORD_LENGTH {
+			return nil, fmt.Errorf("Invalid text dictionary: incorrect length for word %d", i)
+		}
+	}
+
+	return dict[0:nbWords], nil
+}
+
 // Create dictionary from array of words
 func createDictionary(words []byte, dict []dictEntry, maxWords, startWord int) int {
 	anchor := 0
@@ -428,6 +458,9 @@ func NewTextCodecWithCtx(ctx *map[string]interface{}) (*TextCodec, error) {
 		if encodingType == 2 {
 			d, err = newTextCodec2WithCtx(ctx)
 			this.delegate = d
+		} else if encodingType == 3 {
+			d, err = newJSONCodec()
+			this.delegate = d
 		}
 	}
 
@@ -493,7 +526,8 @@ func newTextCodec1() (*textCodec1, error) {
 	this.dictMap = make([]*dictEntry, 0)
 	this.dictList = make([]dictEntry, 0)
 	this.hashMask = int32(1<<this.logHashSize) - 1
-	this.staticDictSize = _TC_STATIC_DICT_WORDS
+	this.staticDict = _TC_STATIC_DICTIONARY[0:_TC_STATIC_DICT_WORDS]
+	this.staticDictSize = len(this.staticDict)
 	return this, nil
 }
 
@@ -526,7 +560,13 @@ func newTextCodec1WithCtx(ctx *map[string]interface{}) (*textCodec1, error) {
 	this.dictMap = make([]*dictEntry, 0)
 	this.dictList = make([]dictEntry, 0)
 	this.hashMask = int32(1<<this.logHashSize) - 1
-	this.staticDictSize = _TC_STATIC_DICT_WORDS
+	var err error
+
+	if this.staticDict, err = getStaticDictionary(ctx); err != nil {
+		return nil, err
+	}
+
+	this.staticDictSize = len(this.staticDict)
 	return this, nil
 }
 
@@ -555,18 +595,18 @@ func (this *textCodec1) reset(count int) {
 
 	if len(this.dictList) == 0 {
 		this.dictList = make([]dictEntry, this.dictSize)
-		size := len(_TC_STATIC_DICTIONARY)
+		size := len(this.staticDict)
 
 		if size >= this.dictSize {
 			size = this.dictSize
 		}
 
-		copy(this.dictList, _TC_STATIC_DICTIONARY[0:size])
+		copy(this.dictList, this.staticDict[0:size])
 
 		// Add special entries at end of static dictionary
-		this.dictList[_TC_STATIC_DICT_WORDS] = dictEntry{ptr: []byte{_TC_ESCAPE_TOKEN2}, hash: 0, data: int32((1 << 24) | (_TC_STATIC_DICT_WORDS))}
-		this.dictList[_TC_STATIC_DICT_WORDS+1] = dictEntry{ptr: []byte{_TC_ESCAPE_TOKEN1}, hash: 0, data: int32((1 << 24) | (_TC_STATIC_DICT_WORDS + 1))}
-		this.staticDictSize = _TC_STATIC_DICT_WORDS + 2
+		this.dictList[size] = dictEntry{ptr: []byte{_TC_ESCAPE_TOKEN2}, hash: 0, data: int32((1 << 24) | size)}
+		this.dictList[size+1] = dictEntry{ptr: []byte{_TC_ESCAPE_TOKEN1}, hash: 0, data: int32((1 << 24) | (size + 1))}
+		this.staticDictSize = size + 2
 	}
 
 	// Update map
@@ -1013,7 +1053,8 @@ func newTextCodec2() (*textCodec2, error) {
 	this.dictMap = make([]*dictEntry, 0)
 	this.dictList = make([]dictEntry, 0)
 	this.hashMask = int32(1<<this.logHashSize) - 1
-	this.staticDictSize = _TC_STATIC_DICT_WORDS
+	this.staticDict = _TC_STATIC_DICTIONARY[0:_TC_STATIC_DICT_WORDS]
+	this.staticDictSize = len(this.staticDict)
 	return this, nil
 }
 
@@ -1046,7 +1087,13 @@ func newTextCodec2WithCtx(ctx *map[string]interface{}) (*textCodec2, error) {
 	this.dictMap = make([]*dictEntry, 0)
 	this.dictList = make([]dictEntry, 0)
 	this.hashMask = int32(1<<this.logHashSize) - 1
-	this.staticDictSize = _TC_STATIC_DICT_WORDS
+	var err error
+
+	if this.staticDict, err = getStaticDictionary(ctx); err != nil {
+		return nil, err
+	}
+
+	this.staticDictSize = len(this.staticDict)
 	return this, nil
 }
 
@@ -1075,13 +1122,13 @@ func (this *textCodec2) reset(count int) {
 
 	if len(this.dictList) == 0 {
 		this.dictList = make([]dictEntry, this.dictSize)
-		size := len(_TC_STATIC_DICTIONARY)
+		size := len(this.staticDict)
 
 		if size >= this.dictSize {
 			size = this.dictSize
 		}
 
-		copy(this.dictList, _TC_STATIC_DICTIONARY[0:size])
+		copy(this.dictList, this.staticDict[0:size])
 	}
 
 	// Update map
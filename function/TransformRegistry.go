@@ -0,0 +1,122 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Each transform type is written with 6 bits in the function type of the
+// bitstream header. The last types are reserved for experimental transforms
+// provided by other packages, the built-in transforms never use them.
+const (
+	FIRST_EXPERIMENTAL_TYPE = uint64(56)
+	LAST_EXPERIMENTAL_TYPE  = uint64(63)
+)
+
+// TransformFactory creates a transform registered with RegisterTransform.
+// The context holds the parameters of the stream (EG. "blockSize").
+type TransformFactory func(ctx map[string]interface{}) (kanzi.ByteTransform, error)
+
+type registeredTransform struct {
+	name    string // upper case
+	factory TransformFactory
+}
+
+var (
+	transformMutex    sync.RWMutex
+	transformRegistry = make(map[uint64]registeredTransform)
+)
+
+// RegisterTransform makes the transform available to the factory functions
+// of this package (hence to the transform chains, the compressed streams and
+// the block compressor) under the given type, in the experimental range, and
+// the given name (case insensitive). Registering a type or a name already in
+// use is an error. Usually called from an init function; the encoder and the
+// decoder of a stream must register the same transforms.
+func RegisterTransform(id uint64, name string, factory TransformFactory) error {
+	if id < FIRST_EXPERIMENTAL_TYPE || id > LAST_EXPERIMENTAL_TYPE {
+		return fmt.Errorf("Invalid transform type: %d (must be in [%d..%d])",
+			id, FIRST_EXPERIMENTAL_TYPE, LAST_EXPERIMENTAL_TYPE)
+	}
+
+	if factory == nil {
+		return errors.New("Invalid null transform factory parameter")
+	}
+
+	upperName := strings.ToUpper(name)
+
+	if len(upperName) == 0 || strings.ContainsAny(upperName, "&+ ") == true {
+		return fmt.Errorf("Invalid transform name: '%s'", name)
+	}
+
+	// Built-in or registered name
+	if _, err := getByteFunctionTypeToken(upperName); err == nil {
+		return fmt.Errorf("Transform name '%s' already in use", upperName)
+	}
+
+	transformMutex.Lock()
+	defer transformMutex.Unlock()
+
+	if _, exists := transformRegistry[id]; exists == true {
+		return fmt.Errorf("Transform type %d already registered", id)
+	}
+
+	for _, t := range transformRegistry {
+		if t.name == upperName {
+			return fmt.Errorf("Transform name '%s' already in use", upperName)
+		}
+	}
+
+	transformRegistry[id] = registeredTransform{name: upperName, factory: factory}
+	return nil
+}
+
+// UnregisterTransform removes the transform registered for the type (if any)
+func UnregisterTransform(id uint64) {
+	transformMutex.Lock()
+	delete(transformRegistry, id)
+	transformMutex.Unlock()
+}
+
+// getRegisteredTransform returns the transform registered for the type and
+// true, or false if there is no such transform
+func getRegisteredTransform(id uint64) (registeredTransform, bool) {
+	transformMutex.RLock()
+	defer transformMutex.RUnlock()
+	t, exists := transformRegistry[id]
+	return t, exists
+}
+
+// getRegisteredTransformType returns the type of the transform registered
+// with the name (upper case) and true, or false if there is no such transform
+func getRegisteredTransformType(name string) (uint64, bool) {
+	transformMutex.RLock()
+	defer transformMutex.RUnlock()
+
+	for id, t := range transformRegistry {
+		if t.name == name {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
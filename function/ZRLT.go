@@ -16,8 +16,10 @@ limitations under the License.
 package function
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/bits"
 
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -60,54 +62,61 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
-	srcEnd, dstEnd := uint(len(src)), uint(len(dst))
+	return encodeZeroRuns(src, dst, 0)
+}
+
+// encodeZeroRuns encodes the runs of 'symbol' of src (the zero runs of the
+// bytes minus 'symbol') to dst
+func encodeZeroRuns(src, dst []byte, symbol byte) (uint, uint, error) {
+	// The loops index src and dst with their length (not a copy of it) so that
+	// the compiler can prove the accesses safe and drop the bound checks.
+	srcEnd := uint(len(src))
 	runLength := uint(0)
 	srcIdx, dstIdx := uint(0), uint(0)
 	var err error
 
-	for srcIdx < srcEnd {
-		if src[srcIdx] == 0 {
-			runLength = 1
-
-			for srcIdx+runLength < srcEnd && src[srcIdx+runLength] == src[srcIdx] {
-				runLength++
-			}
+	for srcIdx < uint(len(src)) {
+		val := src[srcIdx] - symbol
 
+		if val == 0 {
+			runLength = countRun(src[srcIdx:], symbol)
 			srcIdx += runLength
 
 			// Encode length
 			runLength++
-			log2 := kanzi.Log2NoCheck(uint32(runLength))
+			log2 := uint(kanzi.Log2NoCheck(uint32(runLength)))
 
-			if dstIdx >= dstEnd-uint(log2) {
+			if dstIdx+log2 >= uint(len(dst)) {
 				break
 			}
 
 			// Write every bit as a byte except the most significant one
-			for log2 > 0 {
+			bits := dst[dstIdx : dstIdx+log2]
+
+			for i := range bits {
 				log2--
-				dst[dstIdx] = byte((runLength >> log2) & 1)
-				dstIdx++
+				bits[i] = byte((runLength >> log2) & 1)
 			}
 
+			dstIdx += uint(len(bits))
 			runLength = 0
 			continue
 		}
 
-		if src[srcIdx] >= 0xFE {
-			if dstIdx >= dstEnd-1 {
+		if val >= 0xFE {
+			if dstIdx+1 >= uint(len(dst)) {
 				break
 			}
 
 			dst[dstIdx] = 0xFF
+			dst[dstIdx+1] = val - 0xFE
 			dstIdx++
-			dst[dstIdx] = src[srcIdx] - 0xFE
 		} else {
-			if dstIdx >= dstEnd {
+			if dstIdx >= uint(len(dst)) {
 				break
 			}
 
-			dst[dstIdx] = src[srcIdx] + 1
+			dst[dstIdx] = val + 1
 		}
 
 		srcIdx++
@@ -121,6 +130,28 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	return srcIdx, dstIdx, err
 }
 
+// countRun returns the number of leading bytes of buf equal to val.
+// 8 bytes are compared per step: the first byte that differs is given
+// by the trailing zeros of the XOR of the data with the repeated value.
+func countRun(buf []byte, val byte) uint {
+	pattern := uint64(val) * 0x0101010101010101
+	n := uint(0)
+
+	for n+8 <= uint(len(buf)) {
+		if diff := binary.LittleEndian.Uint64(buf[n:]) ^ pattern; diff != 0 {
+			return n + uint(bits.TrailingZeros64(diff)>>3)
+		}
+
+		n += 8
+	}
+
+	for n < uint(len(buf)) && buf[n] == val {
+		n++
+	}
+
+	return n
+}
+
 // Inverse applies the reverse function to the src and writes the result
 // to the destination. Returns number of bytes read, number of bytes
 // written and possibly an error.
@@ -133,74 +164,177 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
-	srcEnd, dstEnd := len(src), len(dst)
-	runLength := 1
-	srcIdx, dstIdx := 0, 0
+	return decodeZeroRuns(src, dst, 0)
+}
+
+// decodeZeroRuns decodes the runs of 'symbol' of src to dst (inverse of
+// encodeZeroRuns)
+func decodeZeroRuns(src, dst []byte, symbol byte) (uint, uint, error) {
+	// Unsigned indexes compared to the slice lengths let the compiler drop
+	// the bound checks
+	runLength := uint(1)
+	srcIdx, dstIdx := uint(0), uint(0)
 	var err error
 
-	for dstIdx < dstEnd {
+	for dstIdx < uint(len(dst)) && srcIdx < uint(len(src)) {
 		if runLength > 1 {
-			runLength--
-			dst[dstIdx] = 0
-			dstIdx++
+			// Emit the pending zeros at once
+			zeros := dst[dstIdx:]
+
+			if uint(len(zeros)) > runLength-1 {
+				zeros = zeros[0 : runLength-1]
+			}
+
+			for i := range zeros {
+				zeros[i] = symbol
+			}
+
+			dstIdx += uint(len(zeros))
+			runLength -= uint(len(zeros))
 			continue
 		}
 
-		if src[srcIdx] <= 1 {
+		val := src[srcIdx]
+
+		if val <= 1 {
 			// Generate the run length bit by bit (but force MSB)
 			runLength = 1
 
-			for src[srcIdx] <= 1 {
-				runLength += (runLength + int(src[srcIdx]))
+			for val <= 1 {
+				runLength += (runLength + uint(val))
 				srcIdx++
 
-				if srcIdx >= srcEnd {
+				if srcIdx >= uint(len(src)) {
 					goto End
 				}
+
+				val = src[srcIdx]
 			}
 
 			continue
 		}
 
 		// Regular data processing
-		if src[srcIdx] == 0xFF {
+		if val == 0xFF {
 			srcIdx++
 
-			if srcIdx >= srcEnd {
+			if srcIdx >= uint(len(src)) {
 				break
 			}
 
-			dst[dstIdx] = 0xFE + src[srcIdx]
+			dst[dstIdx] = 0xFE + src[srcIdx] + symbol
 		} else {
-			dst[dstIdx] = src[srcIdx] - 1
+			dst[dstIdx] = val - 1 + symbol
 		}
 
 		srcIdx++
 		dstIdx++
-
-		if srcIdx >= srcEnd {
-			break
-		}
 	}
 
 End:
 	// If runLength is not 1, add trailing 0s
 	end := dstIdx + runLength - 1
 
-	if end > dstEnd {
+	if end > uint(len(dst)) {
 		err = errors.New("Output buffer is too small")
 	} else {
-		for dstIdx < end {
-			dst[dstIdx] = 0
-			dstIdx++
+		zeros := dst[dstIdx:end]
+
+		for i := range zeros {
+			zeros[i] = symbol
 		}
 
-		if srcIdx < srcEnd {
+		dstIdx = end
+
+		if srcIdx < uint(len(src)) {
 			err = errors.New("Output buffer is too small")
 		}
 	}
 
-	return uint(srcIdx), uint(dstIdx), err
+	return srcIdx, dstIdx, err
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *ZRLT) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length == 0 {
+		return 0, nil
+	}
+
+	if length > uint(len(block)) {
+		return 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(block), length)
+	}
+
+	src := block[0:length]
+	gap, ok := zeroRunsLayout(src, uint(len(block)))
+	return inverseInPlace(block, src, gap, ok, func(src, dst []byte) (uint, uint, error) {
+		return decodeZeroRuns(src, dst, 0)
+	})
+}
+
+// zeroRunsLayout returns the offset of src in the block that keeps the output
+// of decodeZeroRuns behind the unread input. Returns false if the output is
+// larger than 'max' or the input is invalid.
+func zeroRunsLayout(src []byte, max uint) (uint, bool) {
+	srcIdx, dstIdx, gap := uint(0), uint(0), uint(0)
+
+	for srcIdx < uint(len(src)) {
+		if val := src[srcIdx]; val <= 1 {
+			runLength := uint(1)
+
+			for srcIdx < uint(len(src)) && src[srcIdx] <= 1 {
+				runLength += (runLength + uint(src[srcIdx]))
+				srcIdx++
+
+				if runLength > max {
+					return 0, false
+				}
+			}
+
+			dstIdx += runLength - 1
+		} else if val == 0xFF {
+			srcIdx += 2
+			dstIdx++
+
+			if srcIdx > uint(len(src)) {
+				return 0, false
+			}
+		} else {
+			srcIdx++
+			dstIdx++
+		}
+
+		if dstIdx > max {
+			return 0, false
+		}
+
+		if dstIdx > srcIdx+gap {
+			gap = dstIdx - srcIdx
+		}
+	}
+
+	return gap, true
+}
+
+// inverseInPlace moves src (a slice of the block) to offset 'gap' of the
+// block and decodes it to the start of the block. The gap keeps the output
+// behind the unread input. If src does not fit at this offset ('ok' is
+// false EG.), a copy of src is decoded instead.
+func inverseInPlace(block, src []byte, gap uint, ok bool, inverse func(src, dst []byte) (uint, uint, error)) (uint, error) {
+	length := uint(len(src))
+
+	if ok == false || gap+length > uint(len(block)) {
+		buf := make([]byte, length)
+		copy(buf, src)
+		src = buf
+	} else {
+		copy(block[gap:], src)
+		src = block[gap : gap+length]
+	}
+
+	_, dstIdx, err := inverse(src, block)
+	return dstIdx, err
 }
 
 // MaxEncodedLen returns the max size required for the encoding output buffer
@@ -0,0 +1,220 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grpc registers kanzi as a gRPC compressor named "kanzi".
+// Importing the package is enough to make the compressor available:
+//
+//	import _ "github.com/flanglet/kanzi-go/grpc"
+//
+// Clients select it with grpc.UseCompressor(grpc.Name).
+package grpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// Name is the name under which the default compressor is registered
+	Name = "kanzi"
+
+	// DEFAULT_PIPELINE is the pipeline used by the default compressor
+	DEFAULT_PIPELINE = "LZ&HUFFMAN"
+
+	// SMALL_MESSAGE_SIZE is the size under which messages are sent uncompressed
+	SMALL_MESSAGE_SIZE = 64
+
+	_MODE_RAW        = 0
+	_MODE_COMPRESSED = 1
+)
+
+func init() {
+	c, err := NewCompressor(Name, DEFAULT_PIPELINE)
+
+	if err != nil {
+		panic(err)
+	}
+
+	encoding.RegisterCompressor(c)
+}
+
+// Compressor implements the grpc encoding.Compressor interface.
+// Each message is compressed in one shot by a pipeline (see the pipeline
+// package) taken from a pool. A message is sent as a mode byte followed
+// by the raw bytes (small or incompressible messages) or by the pipeline
+// payload.
+type Compressor struct {
+	name      string
+	pipelines sync.Pool
+	writers   sync.Pool
+}
+
+// NewCompressor creates a new instance of Compressor with the provided name
+// and pipeline string (EG. "TEXT+BWT+RANK+ZRLT&ANS0"). The compressor must be
+// registered with encoding.RegisterCompressor to be used by gRPC.
+func NewCompressor(name, pipelineStr string) (*Compressor, error) {
+	desc, err := pipeline.Parse(pipelineStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Compressor{name: name}
+
+	this.pipelines.New = func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, nil)
+		return p
+	}
+
+	this.writers.New = func() interface{} {
+		return &writer{}
+	}
+
+	return this, nil
+}
+
+// Name returns the name of the compressor
+func (this *Compressor) Name() string {
+	return this.name
+}
+
+// Compress returns a WriteCloser that compresses the message written to it
+// when closed
+func (this *Compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	wr := this.writers.Get().(*writer)
+	wr.compressor = this
+	wr.w = w
+	wr.buf.Reset()
+	return wr, nil
+}
+
+// Decompress returns a Reader that decompresses the message read from r
+func (this *Compressor) Decompress(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("Invalid kanzi message: missing mode")
+	}
+
+	if data[0] == _MODE_RAW {
+		return bytes.NewReader(data[1:]), nil
+	}
+
+	if data[0] != _MODE_COMPRESSED {
+		return nil, fmt.Errorf("Invalid kanzi message: unknown mode %d", data[0])
+	}
+
+	size := this.DecompressedSize(data)
+
+	if size < 0 {
+		return nil, errors.New("Invalid kanzi message: truncated header")
+	}
+
+	p := this.pipelines.Get().(*pipeline.Pipeline)
+	defer this.pipelines.Put(p)
+	output := make([]byte, size)
+
+	if _, _, err = p.Inverse(data[1:], output); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(output), nil
+}
+
+// DecompressedSize returns the size of the decompressed message or -1 if
+// it cannot be determined. Used by gRPC to enforce max message sizes.
+func (this *Compressor) DecompressedSize(compressed []byte) int {
+	if len(compressed) == 0 {
+		return -1
+	}
+
+	if compressed[0] == _MODE_RAW {
+		return len(compressed) - 1
+	}
+
+	size, err := pipeline.DecodedLen(compressed[1:])
+
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+type writer struct {
+	compressor *Compressor
+	w          io.Writer
+	buf        bytes.Buffer
+	out        []byte
+}
+
+func (this *writer) Write(p []byte) (int, error) {
+	return this.buf.Write(p)
+}
+
+func (this *writer) Close() error {
+	defer this.compressor.writers.Put(this)
+	data := this.buf.Bytes()
+	w := this.w
+	this.w = nil
+
+	if len(data) < SMALL_MESSAGE_SIZE {
+		if _, err := w.Write([]byte{_MODE_RAW}); err != nil {
+			return err
+		}
+
+		_, err := w.Write(data)
+		return err
+	}
+
+	p := this.compressor.pipelines.Get().(*pipeline.Pipeline)
+	defer this.compressor.pipelines.Put(p)
+	maxLen := 1 + p.MaxEncodedLen(len(data))
+
+	if len(this.out) < maxLen {
+		this.out = make([]byte, maxLen)
+	}
+
+	this.out[0] = _MODE_COMPRESSED
+	_, n, err := p.Forward(data, this.out[1:])
+
+	if err != nil {
+		return err
+	}
+
+	// Send incompressible messages raw
+	if int(n) >= len(data) {
+		if _, err = w.Write([]byte{_MODE_RAW}); err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+		return err
+	}
+
+	_, err = w.Write(this.out[0 : n+1])
+	return err
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/encoding"
+)
+
+func compress(b *testing.T, c encoding.Compressor, msg []byte) []byte {
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	w.Write(msg)
+
+	if err = w.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func decompress(c encoding.Compressor, data []byte) ([]byte, error) {
+	r, err := c.Decompress(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+func TestCompressor(b *testing.T) {
+	c := encoding.GetCompressor(Name)
+
+	if c == nil {
+		b.Fatalf("Compressor %v not registered", Name)
+	}
+
+	sizer, ok := c.(interface{ DecompressedSize([]byte) int })
+
+	if ok == false {
+		b.Fatalf("Compressor %v does not implement DecompressedSize", Name)
+	}
+
+	small := []byte("kanzi")
+	large := []byte(strings.Repeat("gRPC messages with repeated fields compress well. ", 100))
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(random)
+
+	for _, test := range []struct {
+		name string
+		msg  []byte
+		mode byte
+	}{
+		{"empty", []byte{}, _MODE_RAW},
+		{"small", small, _MODE_RAW},
+		{"small max", large[0 : SMALL_MESSAGE_SIZE-1], _MODE_RAW},
+		{"large", large, _MODE_COMPRESSED},
+	} {
+		compressed := compress(b, c, test.msg)
+		fmt.Printf("%-9v %5d => %5d bytes\n", test.name, len(test.msg), len(compressed))
+
+		if len(compressed) == 0 || compressed[0] != test.mode {
+			b.Errorf("%v: incorrect mode", test.name)
+			continue
+		}
+
+		if test.mode == _MODE_RAW && bytes.Equal(compressed[1:], test.msg) == false {
+			b.Errorf("%v: incorrect raw message", test.name)
+		}
+
+		if test.mode == _MODE_COMPRESSED && len(compressed) >= len(test.msg) {
+			b.Errorf("%v: message not compressed", test.name)
+		}
+
+		if size := sizer.DecompressedSize(compressed); size != len(test.msg) {
+			b.Errorf("%v: incorrect decompressed size: %d", test.name, size)
+		}
+
+		if res, err := decompress(c, compressed); err != nil {
+			b.Errorf("%v: %v", test.name, err)
+		} else if bytes.Equal(res, test.msg) == false {
+			b.Errorf("%v: incorrect decompressed message", test.name)
+		}
+	}
+
+	// Incompressible messages are sent raw
+	if compressed := compress(b, c, random); compressed[0] != _MODE_RAW || len(compressed) != len(random)+1 {
+		b.Errorf("Incompressible message not sent raw: %d bytes", len(compressed))
+	}
+
+	// Missing mode, unknown mode, truncated payload
+	compressed := compress(b, c, large)
+
+	for i, data := range [][]byte{{}, append([]byte{2}, compressed[1:]...), compressed[0:2]} {
+		if _, err := decompress(c, data); err == nil {
+			b.Errorf("No error for corrupted message %d", i)
+		}
+	}
+
+	if size := sizer.DecompressedSize([]byte{}); size != -1 {
+		b.Errorf("Incorrect decompressed size of an empty message: %d", size)
+	}
+
+	if _, err := NewCompressor("kanzi-invalid", "FOO&HUFFMAN"); err == nil {
+		b.Errorf("No error for an invalid pipeline")
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/flanglet/kanzi-go/grpc
+
+go 1.19
+
+require (
+	github.com/flanglet/kanzi-go v0.0.0
+	google.golang.org/grpc v1.60.1
+)
+
+replace github.com/flanglet/kanzi-go => ../
@@ -0,0 +1,164 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"io"
+	"sync"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// ChunkCompressor is a push based compressor: the caller provides chunks of
+// data and gets back the compressed bytes produced so far. It suits
+// environments where the caller owns the I/O, such as JavaScript via js/wasm.
+type ChunkCompressor struct {
+	cos    *CompressedOutputStream
+	output *chunkBuffer
+}
+
+// ChunkDecompressor is a push based decompressor: the caller provides chunks
+// of compressed data and gets back the decompressed bytes produced so far.
+type ChunkDecompressor struct {
+	pw     *io.PipeWriter
+	output *chunkBuffer
+	err    error
+	done   chan bool
+}
+
+type chunkBuffer struct {
+	mutex sync.Mutex
+	buf   []byte
+}
+
+func (this *chunkBuffer) Write(b []byte) (int, error) {
+	this.mutex.Lock()
+	this.buf = append(this.buf, b...)
+	this.mutex.Unlock()
+	return len(b), nil
+}
+
+func (this *chunkBuffer) Close() error {
+	return nil
+}
+
+// drain returns the buffered bytes and empties the buffer
+func (this *chunkBuffer) drain() []byte {
+	this.mutex.Lock()
+	res := this.buf
+	this.buf = make([]byte, 0, len(res))
+	this.mutex.Unlock()
+	return res
+}
+
+// NewChunkCompressor creates a new instance of ChunkCompressor using a map
+// of parameters (see NewCompressedOutputStreamWithCtx)
+func NewChunkCompressor(ctx map[string]interface{}) (*ChunkCompressor, error) {
+	this := &ChunkCompressor{output: &chunkBuffer{buf: make([]byte, 0)}}
+	var err error
+
+	if this.cos, err = NewCompressedOutputStreamWithCtx(this.output, ctx); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Write compresses the chunk and returns the compressed bytes available.
+// The returned slice may be empty since data is buffered until a block is full.
+func (this *ChunkCompressor) Write(chunk []byte) ([]byte, error) {
+	if _, err := this.cos.Write(chunk); err != nil {
+		return nil, err
+	}
+
+	return this.output.drain(), nil
+}
+
+// Close flushes the pending data and returns the last compressed bytes
+func (this *ChunkCompressor) Close() ([]byte, error) {
+	if err := this.cos.Close(); err != nil {
+		return nil, err
+	}
+
+	return this.output.drain(), nil
+}
+
+// NewChunkDecompressor creates a new instance of ChunkDecompressor using a map
+// of parameters (see NewCompressedInputStreamWithCtx)
+func NewChunkDecompressor(ctx map[string]interface{}) (*ChunkDecompressor, error) {
+	pr, pw := io.Pipe()
+	cis, err := NewCompressedInputStreamWithCtx(pr, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &ChunkDecompressor{pw: pw, output: &chunkBuffer{buf: make([]byte, 0)}}
+	this.done = make(chan bool)
+
+	// The decoding happens in a goroutine reading the chunks from the pipe
+	go func() {
+		defer close(this.done)
+		buf := make([]byte, 65536)
+
+		for {
+			n, err := cis.Read(buf)
+
+			if n > 0 {
+				this.output.Write(buf[0:n])
+			}
+
+			if err != nil || n == 0 {
+				this.err = err
+
+				if err == nil {
+					err = io.EOF
+				}
+
+				// Unblock the writer
+				pr.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return this, nil
+}
+
+// Write decompresses the chunk and returns the decompressed bytes available.
+// The returned slice may be empty since blocks are decoded once complete.
+func (this *ChunkDecompressor) Write(chunk []byte) ([]byte, error) {
+	if _, err := this.pw.Write(chunk); err != nil {
+		// The decoder stopped: report the decoding error if any
+		<-this.done
+
+		if this.err != nil {
+			return this.output.drain(), this.err
+		}
+
+		return this.output.drain(), &IOError{msg: "Extra data after end of stream", code: kanzi.ERR_READ_FILE}
+	}
+
+	return this.output.drain(), nil
+}
+
+// Close waits for the end of the decoding and returns the last
+// decompressed bytes
+func (this *ChunkDecompressor) Close() ([]byte, error) {
+	this.pw.Close()
+	<-this.done
+	return this.output.drain(), this.err
+}
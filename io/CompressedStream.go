@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,6 +28,7 @@ import (
 	"github.com/flanglet/kanzi-go/bitstream"
 	"github.com/flanglet/kanzi-go/entropy"
 	"github.com/flanglet/kanzi-go/function"
+	"github.com/flanglet/kanzi-go/pipeline"
 	"github.com/flanglet/kanzi-go/util"
 	"github.com/flanglet/kanzi-go/util/hash"
 )
@@ -39,14 +41,17 @@ import (
 
 const (
 	_BITSTREAM_TYPE             = 0x4B414E5A // "KANZ"
-	_BITSTREAM_FORMAT_VERSION   = 9
+	_BITSTREAM_FORMAT_VERSION   = 10
 	_STREAM_DEFAULT_BUFFER_SIZE = 256 * 1024
 	_EXTRA_BUFFER_SIZE          = 256
 	_COPY_BLOCK_MASK            = 0x80
 	_TRANSFORMS_MASK            = 0x10
+	_SYNC_MARKERS_MASK          = 0x80 // header flags
+	_END_SYNC_MARKER            = 0x80000000
 	_MIN_BITSTREAM_BLOCK_SIZE   = 1024
 	_MAX_BITSTREAM_BLOCK_SIZE   = 1024 * 1024 * 1024
 	_SMALL_BLOCK_SIZE           = 15
+	_MAX_BLOCK_HEADER_SIZE      = 10 // mode, skip flags, block length and checksum
 	_MAX_CONCURRENCY            = 64
 	_CANCEL_TASKS_ID            = -1
 )
@@ -81,22 +86,88 @@ type blockBuffer struct {
 
 // CompressedOutputStream a Writer that writes compressed data
 // to an OutputBitStream.
+// The compressed bytes only depend on the input data and on the transform,
+// entropy codec, block size, checksum, skipBlocks, warmStart, warmROLZ,
+// ansInterleave, entropySegments, tpaqMemory, textDictionary, autoTune,
+// tuneCandidates and fileSize parameters.
+// They do not depend on the number of jobs, on the size of the writes or
+// on the scheduling of the tasks: all heuristics only look at the data of
+// the block being encoded.
+// If the "pipeline" parameter is true, the blocks are encoded in the
+// background: a batch of blocks is transformed while the previous batch is
+// still being entropy coded (at most two batches in flight, using twice the
+// buffer memory). Encoding errors may then be returned by a later call.
+// If the "alignedAlloc" parameter is true, the block buffers and the BWT
+// suffix arrays larger than "alignThreshold" bytes (64 MB by default) are
+// page aligned and backed by huge pages when available (see util.Allocator).
+// If the "manualMemory" parameter is true, the large buffers of the stream
+// and of the codecs are taken from util.SharedPool and handed back to it as
+// soon as they are not needed (after each block for the transforms, at Close
+// or Release for the stream and its entropy codecs) instead of waiting for
+// the GC. Each task keeps its entropy codecs from one block to the next (see
+// entropy.CodecPool).
+// If the "autoTune" parameter is true, the transform and entropy codec are
+// selected before the first block is encoded, by compressing sub-blocks
+// sampled in the first block with each candidate of "tuneCandidates" (comma
+// separated pipelines, pipeline.TUNE_CANDIDATES by default). The winner is
+// used for the rest of the stream.
+// If the "warmStart" parameter is true (flag in the stream header), each
+// block starts with the statistics of the previous blocks instead of a cold
+// start: the CM and TPAQ models are kept and the last bytes of the previous
+// blocks are a preset dictionary for the LZ transform. It helps streams of
+// small blocks, which are then encoded and decoded one at a time.
+// If the "warmROLZ" parameter is also true (flag in the stream header), the
+// last bytes of the previous blocks prime the match tables of the ROLZ
+// transforms as well ("rolzDictionary" context entry), until the next call
+// to Flush: the blocks following a flush do not refer to the data before it.
+// The "ansInterleave" parameter (1, 2 or 4, stored in the stream header) is
+// the number of interleaved states of the ANS codecs. More states decode
+// faster at the cost of 4 bytes per state and chunk.
+// The "entropySegments" parameter (1 to 64, stored in the stream header) is
+// the max number of segments of a block entropy coded independently (see
+// entropy.SegmentedEncoder): the jobs of a task encode and decode the
+// segments of its block concurrently, which helps when there are fewer
+// blocks than jobs. Not compatible with warmStart.
+// The "tpaqMemory" parameter (in MB, a power of 2 in [16..1024], stored in
+// the stream header) is the memory budget of the TPAQ and TPAQX models. By
+// default, their tables are sized after the block size.
+// The "textDictionary" parameter (or the "textDictionaryFile" parameter, see
+// loadTextDictionary) replaces the static dictionary of the text codec. Its
+// checksum is stored in the stream header and the decoder must be provided
+// with the same dictionary.
 type CompressedOutputStream struct {
 	blockSize     uint
 	nbInputBlocks uint8
 	hasher        *hash.XXHash32
 	data          []byte
 	buffers       []blockBuffer
+	codecs        []entropy.CodecPool // entropy encoders of each task
 	entropyType   uint32
 	transformType uint64
 	obs           kanzi.OutputBitStream
 	initialized   int32
 	closed        int32
 	blockID       int32
+	lastBlockID   int32 // ID of the last block handed to an encoding task
 	curIdx        int
 	jobs          int
+	pipelined     bool
+	bufferSet     int
+	pending       *encodingBatch
 	listeners     []kanzi.Listener
 	ctx           map[string]interface{}
+	alloc         *util.Allocator // block buffers (nil unless "alignedAlloc" is true)
+	autoTune      bool
+	boundSize     int        // block size of the last computed bound
+	boundLength   int        // max size of an encoded block of boundSize bytes
+	warm          *warmState // nil unless "warmStart" is true
+	syncMarkers   bool       // a sync marker precedes each block
+}
+
+// encodingBatch tracks the encoding tasks started by one call to processBlock
+type encodingBatch struct {
+	wg   sync.WaitGroup
+	errs []error
 }
 
 type encodingTask struct {
@@ -112,6 +183,11 @@ type encodingTask struct {
 	listeners          []kanzi.Listener
 	obs                kanzi.OutputBitStream
 	ctx                map[string]interface{}
+	align              bool // pad the block to end the stream on a byte boundary
+	syncMarkers        bool // write a sync marker before the block
+	alloc              *util.Allocator
+	warm               *warmState
+	codecs             *entropy.CodecPool
 }
 
 // NewCompressedOutputStream creates a new instance of CompressedOutputStream
@@ -161,6 +237,42 @@ func NewCompressedOutputStreamWithCtx(os io.WriteCloser, ctx map[string]interfac
 		return nil, &IOError{msg: "The block size must be a multiple of 16", code: kanzi.ERR_CREATE_STREAM}
 	}
 
+	if val, containsKey := ctx["ansInterleave"]; containsKey {
+		if n := val.(uint); n != 1 && n != 2 && n != 4 {
+			return nil, &IOError{msg: "The ANS interleave factor must be 1, 2 or 4", code: kanzi.ERR_CREATE_STREAM}
+		}
+	}
+
+	if val, containsKey := ctx["tpaqMemory"]; containsKey {
+		if n := val.(uint); n < entropy.TPAQ_MIN_MEMORY || n > entropy.TPAQ_MAX_MEMORY || n&(n-1) != 0 {
+			errMsg := fmt.Sprintf("The TPAQ memory budget must be a power of 2 in [%d..%d] MB", entropy.TPAQ_MIN_MEMORY, entropy.TPAQ_MAX_MEMORY)
+			return nil, &IOError{msg: errMsg, code: kanzi.ERR_CREATE_STREAM}
+		}
+	}
+
+	if val, containsKey := ctx["entropySegments"]; containsKey {
+		if n := val.(uint); n < 1 || n > entropy.MAX_ENTROPY_SEGMENTS {
+			errMsg := fmt.Sprintf("The number of entropy segments must be in [1..%d]", entropy.MAX_ENTROPY_SEGMENTS)
+			return nil, &IOError{msg: errMsg, code: kanzi.ERR_CREATE_STREAM}
+		}
+
+		if val.(uint) > 1 {
+			if warm, containsKey := ctx["warmStart"]; containsKey && warm.(bool) == true {
+				return nil, &IOError{msg: "Entropy segments are not compatible with warm start", code: kanzi.ERR_CREATE_STREAM}
+			}
+		}
+	}
+
+	if val, containsKey := ctx["warmROLZ"]; containsKey && val.(bool) == true {
+		if warm, containsKey := ctx["warmStart"]; containsKey == false || warm.(bool) == false {
+			return nil, &IOError{msg: "ROLZ dictionary persistence requires warm start", code: kanzi.ERR_CREATE_STREAM}
+		}
+	}
+
+	if err := loadTextDictionary(ctx); err != nil {
+		return nil, err
+	}
+
 	if uint64(bSize)*uint64(tasks) >= uint64(1<<31) {
 		tasks = (1 << 31) / bSize
 	}
@@ -175,6 +287,9 @@ func NewCompressedOutputStreamWithCtx(os io.WriteCloser, ctx map[string]interfac
 	// Check entropy type validity (panic on error)
 	this.entropyType = entropy.GetType(entropyCodec)
 
+	// Same as the decoder, which derives the flag from the entropy type
+	ctx["extra"] = this.entropyType == entropy.TPAQX_TYPE
+
 	// Check transform type validity (panic on error)
 	this.transformType = function.GetType(transform)
 
@@ -209,15 +324,48 @@ func NewCompressedOutputStreamWithCtx(os io.WriteCloser, ctx map[string]interfac
 
 	this.jobs = int(tasks)
 	this.data = make([]byte, 0)
-	this.buffers = make([]blockBuffer, 2*this.jobs)
+
+	if val, containsKey := ctx["pipeline"]; containsKey {
+		this.pipelined = val.(bool)
+	}
+
+	if val, containsKey := ctx["syncMarkers"]; containsKey {
+		this.syncMarkers = val.(bool)
+	}
+
+	// Warm start: each block depends on the previous one
+	if val, containsKey := ctx["warmStart"]; containsKey && val.(bool) == true {
+		this.warm = newWarmState(ctx)
+		this.jobs = 1
+		this.pipelined = false
+
+		if val, containsKey := ctx["warmROLZ"]; containsKey {
+			this.warm.rolz = val.(bool)
+		}
+	}
+
+	// Two sets of task buffers in pipelined mode, one per batch in flight
+	if this.pipelined == true {
+		this.buffers = make([]blockBuffer, 4*this.jobs)
+	} else {
+		this.buffers = make([]blockBuffer, 2*this.jobs)
+	}
 
 	for i := range this.buffers {
 		this.buffers[i] = blockBuffer{Buf: make([]byte, 0)}
 	}
 
+	this.codecs = make([]entropy.CodecPool, len(this.buffers)/2)
 	this.blockID = 0
+	this.lastBlockID = 0
 	this.listeners = make([]kanzi.Listener, 0)
 	this.ctx = ctx
+	this.alloc = util.NewAllocatorWithCtx(&ctx)
+
+	if val, containsKey := ctx["autoTune"]; containsKey {
+		this.autoTune = val.(bool)
+	}
+
 	return this, nil
 }
 
@@ -249,6 +397,50 @@ func (this *CompressedOutputStream) RemoveListener(bl kanzi.Listener) bool {
 	return false
 }
 
+// formatVersion returns the stream format version to write: 9 unless a field
+// of the extended header or a block layout of version 10 (see streamFormat)
+// is required
+func (this *CompressedOutputStream) formatVersion() int {
+	if getEntropySegments(this.ctx) > 1 || this.syncMarkers == true {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	if this.warm != nil {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	if val, containsKey := this.ctx["ansInterleave"]; containsKey && val.(uint) > 1 {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	for _, key := range []string{"tpaqMemory", "textDictionary", "lzDictionary", "rolzDictionary", "cmDictionary"} {
+		if _, containsKey := this.ctx[key]; containsKey {
+			return _BITSTREAM_FORMAT_VERSION
+		}
+	}
+
+	// LZ repeat codes (off by default in version 9 streams)
+	if val, containsKey := this.ctx["lzRepeat"]; containsKey && val.(bool) == true {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	// RLT two byte escape (automatic) and 16 bit runs
+	if val, containsKey := this.ctx["rltEscape"]; containsKey && val.(uint) != 1 {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	if val, containsKey := this.ctx["rltRunWidth"]; containsKey && val.(uint) == 2 {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	// SRT chunks
+	if val, containsKey := this.ctx["srtChunkSize"]; containsKey && val.(uint) != 0 {
+		return _BITSTREAM_FORMAT_VERSION
+	}
+
+	return 9
+}
+
 func (this *CompressedOutputStream) writeHeader() *IOError {
 	cksum := 0
 
@@ -260,7 +452,10 @@ func (this *CompressedOutputStream) writeHeader() *IOError {
 		return &IOError{msg: "Cannot write bitstream type to header", code: kanzi.ERR_WRITE_FILE}
 	}
 
-	if this.obs.WriteBits(_BITSTREAM_FORMAT_VERSION, 5) != 5 {
+	version := this.formatVersion()
+	this.ctx["bsVersion"] = uint(version)
+
+	if this.obs.WriteBits(uint64(version), 5) != 5 {
 		return &IOError{msg: "Cannot write bitstream version to header", code: kanzi.ERR_WRITE_FILE}
 	}
 
@@ -284,13 +479,123 @@ func (this *CompressedOutputStream) writeHeader() *IOError {
 		return &IOError{msg: "Cannot write number of blocks to header", code: kanzi.ERR_WRITE_FILE}
 	}
 
-	if this.obs.WriteBits(0, 3) != 3 {
-		return &IOError{msg: "Cannot write reserved bits to header", code: kanzi.ERR_WRITE_FILE}
+	// Warm start flag (reserved before version 10)
+	warm := 0
+
+	if this.warm != nil {
+		warm = 1
+	}
+
+	if this.obs.WriteBits(uint64(warm), 1) != 1 {
+		return &IOError{msg: "Cannot write warm start flag to header", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	// Log2 of the number of interleaved ANS states (1, 2 or 4), reserved
+	// before version 10
+	logInterleave := 0
+
+	if val, containsKey := this.ctx["ansInterleave"]; containsKey {
+		for 1<<uint(logInterleave) < val.(uint) {
+			logInterleave++
+		}
+	}
+
+	if this.obs.WriteBits(uint64(logInterleave), 2) != 2 {
+		return &IOError{msg: "Cannot write ANS interleave factor to header", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	if version < 10 {
+		return nil
+	}
+
+	// Text dictionary flag (0x80), ROLZ dictionary persistence flag (0x40),
+	// max number of entropy segments - 1
+	segments := uint64(getEntropySegments(this.ctx) - 1)
+	dictChecksum, hasDict := textDictionaryChecksum(this.ctx)
+
+	if hasDict == true {
+		segments |= 0x80
+	}
+
+	if this.warm != nil && this.warm.rolz == true {
+		segments |= 0x40
+	}
+
+	if this.obs.WriteBits(segments, 8) != 8 {
+		return &IOError{msg: "Cannot write number of entropy segments to header", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	// Log2 of the TPAQ memory budget in MB (0 if not set)
+	logMemory := 0
+
+	if val, containsKey := this.ctx["tpaqMemory"]; containsKey {
+		for 1<<uint(logMemory) < val.(uint) {
+			logMemory++
+		}
+	}
+
+	if this.obs.WriteBits(uint64(logMemory), 8) != 8 {
+		return &IOError{msg: "Cannot write TPAQ memory budget to header", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	// Flags: sync markers (0x80), 7 reserved bits
+	flags := uint64(0)
+
+	if this.syncMarkers == true {
+		flags |= _SYNC_MARKERS_MASK
+	}
+
+	if this.obs.WriteBits(flags, 8) != 8 {
+		return &IOError{msg: "Cannot write flags to header", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	if hasDict == true {
+		if this.obs.WriteBits(uint64(dictChecksum), 32) != 32 {
+			return &IOError{msg: "Cannot write text dictionary checksum to header", code: kanzi.ERR_WRITE_FILE}
+		}
 	}
 
 	return nil
 }
 
+// Select the pipeline on the first block. The buffered data spans one block
+// per job, so sampling all of it would make the choice depend on the number
+// of jobs. The configured pipeline is kept if no candidate succeeds.
+func (this *CompressedOutputStream) tune() {
+	cfg := pipeline.TuneConfig{}
+	end := this.curIdx
+
+	if end > int(this.blockSize) {
+		end = int(this.blockSize)
+	}
+
+	if val, containsKey := this.ctx["tuneCandidates"]; containsKey {
+		for _, c := range strings.Split(val.(string), ",") {
+			if c = strings.TrimSpace(c); len(c) > 0 {
+				cfg.Candidates = append(cfg.Candidates, c)
+			}
+		}
+	}
+
+	desc, _, err := pipeline.Tune(this.data[0:end], cfg)
+
+	if err != nil {
+		return
+	}
+
+	this.transformType = desc.TransformType()
+	this.entropyType = desc.EntropyType()
+	this.ctx["transform"] = desc.TransformName()
+	this.ctx["codec"] = desc.Entropy
+	this.ctx["extra"] = this.entropyType == entropy.TPAQX_TYPE
+}
+
+// Pipeline returns the pipeline used to encode the blocks (EG. "BWT+RANK+ZRLT&ANS0").
+// With auto-tuning, it is only final once the first block has been encoded.
+func (this *CompressedOutputStream) Pipeline() string {
+	return function.GetName(this.transformType) + "&" + entropy.GetName(this.entropyType)
+}
+
 // Write writes len(block) bytes from block to the underlying data stream.
 // It returns the number of bytes written from block (0 <= n <= len(block)) and
 // any error encountered that caused the write to stop early.
@@ -324,7 +629,7 @@ func (this *CompressedOutputStream) Write(block []byte) (int, error) {
 
 		if this.curIdx >= len(this.data) {
 			// Buffer full, time to encode
-			if err := this.processBlock(false); err != nil {
+			if err := this.processBlock(false, false); err != nil {
 				return len(block) - remaining, err
 			}
 		}
@@ -342,13 +647,24 @@ func (this *CompressedOutputStream) Close() error {
 	}
 
 	if this.curIdx > 0 {
-		if err := this.processBlock(true); err != nil {
+		if err := this.processBlock(true, false); err != nil {
 			return err
 		}
 
 		this.curIdx = 0
 	}
 
+	if err := this.waitPending(); err != nil {
+		return err
+	}
+
+	// The end block has a flagged sync marker (unless the stream is empty)
+	if this.syncMarkers == true && atomic.LoadInt32(&this.initialized) == 1 {
+		if err := bitstream.WriteSyncMarker(this.obs, uint32(this.lastBlockID+1)|_END_SYNC_MARKER); err != nil {
+			return &IOError{msg: err.Error(), code: kanzi.ERR_WRITE_FILE}
+		}
+	}
+
 	// Write end block of size 0
 	lw := uint(32)
 
@@ -362,17 +678,82 @@ func (this *CompressedOutputStream) Close() error {
 		return err
 	}
 
-	// Release resources
+	this.releaseBuffers()
+	return nil
+}
+
+// Release closes the stream (if needed) and releases its buffers right away,
+// even if closing fails. If the "manualMemory" parameter is true, the buffers
+// go back to util.SharedPool to be reused by the next streams instead of
+// waiting for the GC.
+func (this *CompressedOutputStream) Release() error {
+	err := this.Close()
+
+	// Tasks may still be running if Close failed
+	this.waitPending()
+	this.releaseBuffers()
+	return err
+}
+
+func (this *CompressedOutputStream) releaseBuffers() {
+	this.alloc.ReleaseBytes(this.data)
 	this.data = make([]byte, 0, 0)
 
 	for i := range this.buffers {
+		this.alloc.ReleaseBytes(this.buffers[i].Buf)
 		this.buffers[i] = blockBuffer{Buf: make([]byte, 0, 0)}
 	}
 
+	for i := range this.codecs {
+		this.codecs[i].Release()
+	}
+
+	if this.warm != nil {
+		this.warm.release()
+	}
+}
+
+// Flush encodes the buffered data into a (possibly short) block and writes
+// all the compressed bytes to the underlying stream, so that the data written
+// so far can be decoded. The block is padded to end on a byte boundary.
+// Frequent flushes degrade compression.
+func (this *CompressedOutputStream) Flush() error {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return &IOError{msg: "Stream closed", code: kanzi.ERR_WRITE_FILE}
+	}
+
+	// The stream is left on a byte boundary by the previous flush unless
+	// some data has been buffered since
+	if this.curIdx == 0 {
+		return this.waitPending()
+	}
+
+	if err := this.processBlock(true, true); err != nil {
+		return err
+	}
+
+	this.curIdx = 0
+
+	if err := this.waitPending(); err != nil {
+		return err
+	}
+
+	// The ROLZ dictionary restarts after a flush
+	if this.warm != nil {
+		this.warm.flush()
+	}
+
+	if f, ok := this.obs.(interface{ Flush() error }); ok == true {
+		if err := f.Flush(); err != nil {
+			return &IOError{msg: err.Error(), code: kanzi.ERR_WRITE_FILE}
+		}
+	}
+
 	return nil
 }
 
-func (this *CompressedOutputStream) processBlock(force bool) error {
+// If 'align' is true, the last block is padded to end on a byte boundary
+func (this *CompressedOutputStream) processBlock(force, align bool) error {
 	if force == false {
 		bufSize := this.jobs * int(this.blockSize)
 
@@ -383,8 +764,7 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 		}
 
 		if len(this.data) < bufSize {
-			extraBuf := make([]byte, bufSize-len(this.data))
-			this.data = append(this.data, extraBuf...)
+			this.data = this.alloc.GrowBytes(this.data, bufSize)
 			return nil
 		}
 	}
@@ -394,6 +774,10 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 	}
 
 	if atomic.SwapInt32(&this.initialized, 1) == 0 {
+		if this.autoTune == true {
+			this.tune()
+		}
+
 		if err := this.writeHeader(); err != nil {
 			return err
 		}
@@ -425,9 +809,16 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 		jobsPerTask = []uint{uint(this.jobs)}
 	}
 
-	var err error
-	tasks := 0
-	wg := sync.WaitGroup{}
+	batch := &encodingBatch{errs: make([]error, nbTasks)}
+	buffers := this.buffers
+	codecs := this.codecs
+
+	if this.pipelined == true {
+		// Use the buffers and codecs not owned by the batch still in flight
+		buffers = this.buffers[this.bufferSet*2*this.jobs : (this.bufferSet+1)*2*this.jobs]
+		codecs = this.codecs[this.bufferSet*this.jobs : (this.bufferSet+1)*this.jobs]
+		this.bufferSet = 1 - this.bufferSet
+	}
 
 	// Invoke as many go routines as required
 	for taskID := 0; taskID < nbTasks; taskID++ {
@@ -441,20 +832,15 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 			sz = int(this.blockSize)
 		}
 
-		// Add padding for incompressible data
-		length := sz
-
-		if length >= 1024<<6 {
-			length += (length >> 6)
-		} else {
-			length += 1024
-		}
+		// Size the buffer for the encoded block so that the task does not grow it
+		length := this.maxBlockLen(sz)
 
-		if len(this.buffers[2*taskID].Buf) < length {
-			this.buffers[2*taskID].Buf = make([]byte, length)
+		if len(buffers[2*taskID].Buf) < length {
+			this.alloc.ReleaseBytes(buffers[2*taskID].Buf)
+			buffers[2*taskID].Buf = this.alloc.MakeBytes(length)
 		}
 
-		copy(this.buffers[2*taskID].Buf, this.data[offset:offset+sz])
+		copy(buffers[2*taskID].Buf, this.data[offset:offset+sz])
 		copyCtx := make(map[string]interface{})
 
 		for k, v := range this.ctx {
@@ -462,33 +848,80 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 		}
 
 		copyCtx["jobs"] = jobsPerTask[taskID]
-		wg.Add(1)
-		tasks++
+		batch.wg.Add(1)
 		offset += sz
 		this.curIdx -= sz
+		this.lastBlockID++
 
 		task := encodingTask{
-			iBuffer:            &this.buffers[2*taskID],
-			oBuffer:            &this.buffers[2*taskID+1],
+			iBuffer:            &buffers[2*taskID],
+			oBuffer:            &buffers[2*taskID+1],
 			hasher:             this.hasher,
 			blockLength:        uint(sz),
 			blockTransformType: this.transformType,
 			blockEntropyType:   this.entropyType,
-			currentBlockID:     this.blockID + int32(taskID) + 1,
+			currentBlockID:     this.lastBlockID,
 			processedBlockID:   &this.blockID,
-			wg:                 &wg,
+			wg:                 &batch.wg,
 			obs:                this.obs,
 			listeners:          listeners,
-			ctx:                copyCtx}
+			ctx:                copyCtx,
+			align:              align && this.curIdx == 0,
+			syncMarkers:        this.syncMarkers,
+			alloc:              this.alloc,
+			warm:               this.warm,
+			codecs:             &codecs[taskID]}
 
 		// Invoke the tasks concurrently
-		go task.encode(err)
+		go task.encode(&batch.errs[taskID])
+	}
+
+	// In pipelined mode, the new batch keeps running in the background while
+	// the caller fills the next one: only the previous batch is waited for.
+	prev := this.pending
+	this.pending = batch
+
+	if this.pipelined == true {
+		if prev == nil {
+			return nil
+		}
+
+		batch = prev
+	} else {
+		this.pending = nil
 	}
 
 	// Wait for completion of all tasks
-	wg.Wait()
+	batch.wg.Wait()
 
-	return err
+	for _, err := range batch.errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitPending waits for the completion of the batch of blocks still being
+// encoded (pipelined mode) and returns the first error of this batch.
+func (this *CompressedOutputStream) waitPending() error {
+	batch := this.pending
+
+	if batch == nil {
+		return nil
+	}
+
+	this.pending = nil
+	batch.wg.Wait()
+
+	for _, err := range batch.errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // GetWritten returns the number of bytes written so far
@@ -496,6 +929,63 @@ func (this *CompressedOutputStream) GetWritten() uint64 {
 	return (this.obs.Written() + 7) >> 3
 }
 
+// maxBlockLen returns the max size of an encoded block of 'sz' bytes: the
+// bound of the transform sequence followed by the bound of the entropy codec.
+// Only the last block of the stream may be shorter, so the bound is computed
+// at most twice.
+func (this *CompressedOutputStream) maxBlockLen(sz int) int {
+	if sz == this.boundSize {
+		return this.boundLength
+	}
+
+	ctx := make(map[string]interface{})
+
+	for k, v := range this.ctx {
+		ctx[k] = v
+	}
+
+	ctx["size"] = uint(sz)
+	length := sz
+
+	if t, err := function.NewByteFunction(&ctx, this.transformType); err == nil {
+		length = t.MaxEncodedLen(sz)
+
+		if this.alloc.Pooled() == true {
+			t.Release()
+		}
+	}
+
+	this.boundSize = sz
+	this.boundLength = maxEncodedBlockLen(this.entropyType, length, getEntropySegments(this.ctx))
+	return this.boundLength
+}
+
+// maxEncodedBlockLen returns the max size of an encoded block (header included)
+// given the size of the transform output
+func maxEncodedBlockLen(entropyType uint32, length int, segments int) int {
+	if isSegmented(entropyType, segments) == true {
+		return _MAX_BLOCK_HEADER_SIZE + entropy.MaxSegmentedEncodedLen(entropyType, length, segments)
+	}
+
+	return _MAX_BLOCK_HEADER_SIZE + entropy.MaxEncodedLen(entropyType, length)
+}
+
+// isSegmented returns true if the blocks coded with the entropy type are
+// split into segments (copy blocks are never split)
+func isSegmented(entropyType uint32, segments int) bool {
+	return segments > 1 && entropyType != entropy.NONE_TYPE
+}
+
+// getEntropySegments returns the max number of entropy segments of a block
+// ("entropySegments" context entry, 1 by default)
+func getEntropySegments(ctx map[string]interface{}) int {
+	if val, containsKey := ctx["entropySegments"]; containsKey {
+		return int(val.(uint))
+	}
+
+	return 1
+}
+
 // Encode mode + transformed entropy coded data
 // mode | 0b10000000 => copy block
 //      | 0b0yy00000 => size(size(block))-1
@@ -505,7 +995,7 @@ func (this *CompressedOutputStream) GetWritten() uint64 {
 //  case more than 4 transforms
 //      | 0b00000000
 //      then 0byyyyyyyy => transform sequence skip flags (1 means skip)
-func (this *encodingTask) encode(res error) {
+func (this *encodingTask) encode(res *error) {
 	data := this.iBuffer.Buf
 	buffer := this.oBuffer.Buf
 	mode := byte(0)
@@ -513,11 +1003,11 @@ func (this *encodingTask) encode(res error) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			res = IOError{msg: r.(error).Error(), code: kanzi.ERR_PROCESS_BLOCK}
+			*res = &IOError{msg: r.(error).Error(), code: kanzi.ERR_PROCESS_BLOCK}
 		}
 
 		// Unblock other tasks
-		if res != nil {
+		if *res != nil {
 			atomic.StoreInt32(this.processedBlockID, _CANCEL_TASKS_ID)
 		} else if atomic.LoadInt32(this.processedBlockID) == this.currentBlockID-1 {
 			atomic.StoreInt32(this.processedBlockID, this.currentBlockID)
@@ -546,10 +1036,8 @@ func (this *encodingTask) encode(res error) {
 		if skip, prst := this.ctx["skipBlocks"]; prst == true {
 			if skip.(bool) == true {
 				histo := [256]int{}
-				entropy1024 := entropy.ComputeFirstOrderEntropy1024(data[0:this.blockLength], histo[:])
-				//this.ctx["histo0"] = histo
 
-				if entropy1024 >= entropy.INCOMPRESSIBLE_THRESHOLD {
+				if skip, _ := entropy.IsIncompressible(data[0:this.blockLength], histo[:]); skip == true {
 					this.blockTransformType = function.NONE_TYPE
 					this.blockEntropyType = entropy.NONE_TYPE
 					mode |= _COPY_BLOCK_MASK
@@ -559,29 +1047,63 @@ func (this *encodingTask) encode(res error) {
 	}
 
 	this.ctx["size"] = this.blockLength
+
+	if this.warm != nil {
+		this.warm.prepare(this.ctx)
+	}
+
 	t, err := function.NewByteFunction(&this.ctx, this.blockTransformType)
 
 	if err != nil {
-		res = IOError{msg: err.Error(), code: kanzi.ERR_CREATE_CODEC}
+		*res = &IOError{msg: err.Error(), code: kanzi.ERR_CREATE_CODEC}
 		return
 	}
 
 	requiredSize := t.MaxEncodedLen(int(this.blockLength))
 
-	if len(this.iBuffer.Buf) < requiredSize {
-		extraBuf := make([]byte, requiredSize-len(this.iBuffer.Buf))
-		data = append(data, extraBuf...)
+	// The encoded block is written back to 'data': allocate it once for the
+	// worst case of the entropy encoder on the transform output
+	segments := getEntropySegments(this.ctx)
+
+	if encodedSize := maxEncodedBlockLen(this.blockEntropyType, requiredSize, segments); len(this.iBuffer.Buf) < encodedSize {
+		data = this.alloc.GrowBytes(data, encodedSize)
 		this.iBuffer.Buf = data
 	}
 
 	if len(this.oBuffer.Buf) < requiredSize {
-		extraBuf := make([]byte, requiredSize-len(this.oBuffer.Buf))
-		buffer = append(buffer, extraBuf...)
+		buffer = this.alloc.GrowBytes(buffer, requiredSize)
 		this.oBuffer.Buf = buffer
 	}
 
+	metrics := getMetrics(this.ctx)
+	profiling := isProfilingStages(this.ctx)
+	var startTime time.Time
+	var postTransformLength uint
+
+	if metrics != nil {
+		startTime = time.Now()
+	}
+
+	// A sequence of transforms and the entropy coded block overwrite 'data':
+	// save the window first (the transforms keep the previous windows)
+	if this.warm != nil {
+		this.warm.update(data[0:this.blockLength])
+	}
+
 	// Forward transform (ignore error, encode skipFlags)
-	_, postTransformLength, _ := t.Forward(data[0:this.blockLength], buffer)
+	runStage(profiling, STAGE_FORWARD_TRANSFORM, function.GetName(this.blockTransformType), func() {
+		_, postTransformLength, _ = t.Forward(data[0:this.blockLength], buffer)
+	})
+
+	// Manual memory mode: the work buffers of the transforms are reused by the next blocks
+	if this.alloc.Pooled() == true {
+		t.Release()
+	}
+
+	if metrics != nil {
+		metrics.AddStageTime(STAGE_FORWARD_TRANSFORM, function.GetName(this.blockTransformType), time.Since(startTime))
+	}
+
 	this.ctx["size"] = postTransformLength
 	dataSize := uint(0)
 
@@ -590,7 +1112,7 @@ func (this *encodingTask) encode(res error) {
 	}
 
 	if dataSize > 3 {
-		res = IOError{msg: "Invalid block data length", code: kanzi.ERR_WRITE_FILE}
+		*res = &IOError{msg: "Invalid block data length", code: kanzi.ERR_WRITE_FILE}
 		return
 	}
 
@@ -606,7 +1128,7 @@ func (this *encodingTask) encode(res error) {
 	}
 
 	// Create a bitstream local to the task
-	bufStream := util.NewBufferStream(data[0:0:cap(data)])
+	bufStream := util.NewBufferStream(data[0:0:len(data)])
 	obs, _ := bitstream.NewDefaultOutputBitStream(bufStream, 16384)
 
 	// Write block 'header' (mode + compressed length)
@@ -633,20 +1155,34 @@ func (this *encodingTask) encode(res error) {
 		notifyListeners(this.listeners, evt)
 	}
 
+	if metrics != nil {
+		startTime = time.Now()
+	}
+
 	// Each block is encoded separately
-	// Rebuild the entropy encoder to reset block statistics
-	ee, err := entropy.NewEntropyEncoder(obs, this.ctx, this.blockEntropyType)
+	// Reset the entropy encoder of the task to reset block statistics (unless warm start)
+	var ee kanzi.EntropyEncoder
+
+	if this.warm != nil {
+		ee, err = this.warm.models.NewEntropyEncoder(obs, this.ctx, this.blockEntropyType)
+	} else if isSegmented(this.blockEntropyType, segments) == true {
+		ee, err = entropy.NewSegmentedEncoder(obs, this.ctx, this.blockEntropyType, uint(segments))
+	} else {
+		ee, err = this.codecs.NewEntropyEncoder(obs, this.ctx, this.blockEntropyType)
+	}
 
 	if err != nil {
-		res = IOError{msg: err.Error(), code: kanzi.ERR_CREATE_CODEC}
+		*res = &IOError{msg: err.Error(), code: kanzi.ERR_CREATE_CODEC}
 		return
 	}
 
 	// Entropy encode block
-	_, err = ee.Write(buffer[0:postTransformLength])
+	runStage(profiling, STAGE_ENTROPY_ENCODE, entropy.GetName(this.blockEntropyType), func() {
+		_, err = ee.Write(buffer[0:postTransformLength])
+	})
 
 	if err != nil {
-		res = IOError{msg: err.Error(), code: kanzi.ERR_PROCESS_BLOCK}
+		*res = &IOError{msg: err.Error(), code: kanzi.ERR_PROCESS_BLOCK}
 		return
 	}
 
@@ -655,6 +1191,17 @@ func (this *encodingTask) encode(res error) {
 	obs.Close()
 	written := obs.Written()
 
+	// Safety net: the block cannot expand beyond the length of 'data'
+	if bufStream.Len() > len(data) {
+		data = make([]byte, bufStream.Len())
+		bufStream.Read(data)
+		this.iBuffer.Buf = data
+	}
+
+	if metrics != nil {
+		metrics.AddStageTime(STAGE_ENTROPY_ENCODE, entropy.GetName(this.blockEntropyType), time.Since(startTime))
+	}
+
 	// Lock free synchronization
 	for n := 0; ; n++ {
 		taskID := atomic.LoadInt32(this.processedBlockID)
@@ -677,6 +1224,13 @@ func (this *encodingTask) encode(res error) {
 		notifyListeners(this.listeners, evt)
 	}
 
+	if this.syncMarkers == true {
+		if err := bitstream.WriteSyncMarker(this.obs, uint32(this.currentBlockID)); err != nil {
+			*res = &IOError{msg: err.Error(), code: kanzi.ERR_WRITE_FILE}
+			return
+		}
+	}
+
 	// Emit block size in bits (max size pre-entropy is 1 GB = 1 << 30 bytes)
 	lw := uint(32)
 
@@ -684,7 +1238,18 @@ func (this *encodingTask) encode(res error) {
 		lw = 40
 	}
 
-	this.obs.WriteBits(written, lw)
+	pad := uint64(0)
+
+	if this.align == true {
+		// Zero bits after the block data are ignored by the decoder
+		pad = (8 - ((this.obs.Written() + uint64(lw) + written) & 7)) & 7
+	}
+
+	this.obs.WriteBits(written+pad, lw)
+
+	if metrics != nil {
+		metrics.AddBlock(int64(this.blockLength), int64((written+7)>>3), mode&_COPY_BLOCK_MASK != 0)
+	}
 
 	// Emit data to shared bitstream
 	for n := uint(0); written > 0; {
@@ -698,6 +1263,10 @@ func (this *encodingTask) encode(res error) {
 		n += ((chkSize + 7) >> 3)
 		written -= uint64(chkSize)
 	}
+
+	if pad > 0 {
+		this.obs.WriteBits(0, uint(pad))
+	}
 }
 
 func notifyListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
@@ -725,12 +1294,24 @@ type decodingTaskResult struct {
 
 // CompressedInputStream a Reader that reads compressed data
 // from an InputBitStream.
+// If the "pipeline" parameter is true, the blocks are decoded ahead of the
+// reader (up to one block per job) and delivered in order. The "maxBuffered"
+// parameter (in bytes, at least 2 blocks) caps the decoded data held in
+// memory, including the block being read: the number of blocks decoded ahead
+// is reduced accordingly.
+// The "alignedAlloc", "alignThreshold" and "manualMemory" parameters have
+// the same meaning as for CompressedOutputStream. Warm start streams are
+// decoded one block at a time (with one job) and require the "lzDictionary"
+// provided to the encoder, if any. The ROLZ blocks of streams written with
+// "warmROLZ" store the number of bytes of the previous blocks they refer to.
 type CompressedInputStream struct {
 	blockSize     uint
 	nbInputBlocks uint8
+	version       int
 	hasher        *hash.XXHash32
 	data          []byte
 	buffers       []blockBuffer
+	codecs        []entropy.CodecPool
 	entropyType   uint32
 	transformType uint64
 	ibs           kanzi.InputBitStream
@@ -742,6 +1323,24 @@ type CompressedInputStream struct {
 	jobs          int
 	listeners     []kanzi.Listener
 	ctx           map[string]interface{}
+	pipelined     bool
+	maxBuffered   uint
+	jobsPerTask   []uint          // pipelined mode: jobs of each task in flight
+	pending       []*pendingBlock // pipelined mode: blocks in flight, in order
+	lastBlockID   int32           // pipelined mode: ID of the last block scheduled
+	alloc         *util.Allocator // block buffers (nil unless "alignedAlloc" is true)
+	warm          *warmState      // nil unless the warm start flag is set in the header
+	syncMarkers   bool            // a sync marker precedes each block (header flag)
+	recovery      bool            // drop the corrupted blocks (needs sync markers)
+	markerDelta   int32           // counter of the next sync marker - ID of the block
+	lostBlocks    int32           // number of blocks dropped by the recovery
+	maxBlockBits  uint64          // recovery: max size of a valid block in bits
+}
+
+// pendingBlock is a block decoded ahead of the reader in pipelined mode
+type pendingBlock struct {
+	wg     sync.WaitGroup
+	result decodingTaskResult
 }
 
 type decodingTask struct {
@@ -756,7 +1355,15 @@ type decodingTask struct {
 	wg                 *sync.WaitGroup
 	listeners          []kanzi.Listener
 	ibs                kanzi.InputBitStream
+	codecs             *entropy.CodecPool
 	ctx                map[string]interface{}
+	alloc              *util.Allocator
+	warm               *warmState
+	syncMarkers        bool
+	recovery           bool
+	markerDelta        *int32
+	lostBlocks         *int32
+	maxBlockBits       uint64
 }
 
 // NewCompressedInputStream creates a new instance of CompressedInputStream
@@ -773,6 +1380,24 @@ func NewCompressedInputStreamWithCtx(is io.ReadCloser, ctx map[string]interface{
 		return nil, &IOError{msg: "Invalid null reader parameter", code: kanzi.ERR_CREATE_STREAM}
 	}
 
+	ibs, err := bitstream.NewDefaultInputBitStream(is, _STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		errMsg := fmt.Sprintf("Cannot create input bit stream: %v", err)
+		return nil, &IOError{msg: errMsg, code: kanzi.ERR_CREATE_BITSTREAM}
+	}
+
+	return NewCompressedInputStreamWithBitStream(ibs, ctx)
+}
+
+// NewCompressedInputStreamWithBitStream creates a new instance of
+// CompressedInputStream reading the provided bitstream (EG. a
+// bitstream.MmapInputBitStream) using a map of parameters
+func NewCompressedInputStreamWithBitStream(ibs kanzi.InputBitStream, ctx map[string]interface{}) (*CompressedInputStream, error) {
+	if ibs == nil {
+		return nil, &IOError{msg: "Invalid null bitstream parameter", code: kanzi.ERR_CREATE_STREAM}
+	}
+
 	if ctx == nil {
 		return nil, &IOError{msg: "Invalid null context parameter", code: kanzi.ERR_CREATE_STREAM}
 	}
@@ -784,6 +1409,10 @@ func NewCompressedInputStreamWithCtx(is io.ReadCloser, ctx map[string]interface{
 		return nil, &IOError{msg: errMsg, code: kanzi.ERR_CREATE_STREAM}
 	}
 
+	if err := loadTextDictionary(ctx); err != nil {
+		return nil, err
+	}
+
 	this := new(CompressedInputStream)
 
 	this.jobs = int(tasks)
@@ -795,15 +1424,24 @@ func NewCompressedInputStreamWithCtx(is io.ReadCloser, ctx map[string]interface{
 		this.buffers[i] = blockBuffer{Buf: make([]byte, 0)}
 	}
 
-	var err error
+	this.codecs = make([]entropy.CodecPool, this.jobs)
 
-	if this.ibs, err = bitstream.NewDefaultInputBitStream(is, _STREAM_DEFAULT_BUFFER_SIZE); err != nil {
-		errMsg := fmt.Sprintf("Cannot create input bit stream: %v", err)
-		return nil, &IOError{msg: errMsg, code: kanzi.ERR_CREATE_BITSTREAM}
+	if val, containsKey := ctx["pipeline"]; containsKey {
+		this.pipelined = val.(bool)
+	}
+
+	if val, containsKey := ctx["maxBuffered"]; containsKey {
+		this.maxBuffered = val.(uint)
 	}
 
+	if val, containsKey := ctx["recover"]; containsKey {
+		this.recovery = val.(bool)
+	}
+
+	this.ibs = ibs
 	this.listeners = make([]kanzi.Listener, 0)
 	this.ctx = ctx
+	this.alloc = util.NewAllocatorWithCtx(&ctx)
 	this.blockSize = 0
 	this.entropyType = entropy.NONE_TYPE
 	this.transformType = function.NONE_TYPE
@@ -850,17 +1488,28 @@ func (this *CompressedInputStream) readHeader() error {
 
 	// Sanity check
 	if fileType != _BITSTREAM_TYPE {
+		// Users often provide a file in another format, say which
+		sig := []byte{byte(fileType >> 24), byte(fileType >> 16), byte(fileType >> 8), byte(fileType)}
+
+		if name := util.GetMagicName(util.GetMagicType(sig)); name != "" {
+			errMsg := fmt.Sprintf("Invalid stream type: %v file detected (not a kanzi bitstream)", name)
+			return &IOError{msg: errMsg, code: kanzi.ERR_INVALID_FILE}
+		}
+
 		return &IOError{msg: "Invalid stream type", code: kanzi.ERR_INVALID_FILE}
 	}
 
-	version := this.ibs.ReadBits(5)
+	version := int(this.ibs.ReadBits(5))
 
 	// Sanity check
-	if version != _BITSTREAM_FORMAT_VERSION {
-		errMsg := fmt.Sprintf("Invalid bitstream, cannot read this version of the stream: %d", version)
-		return &IOError{msg: errMsg, code: kanzi.ERR_STREAM_VERSION}
+	if CanDecode(version) == false {
+		return versionError(version)
 	}
 
+	format := _STREAM_FORMATS[version]
+	this.version = version
+	this.ctx["bsVersion"] = uint(version)
+
 	// Read block checksum
 	if this.ibs.ReadBit() == 1 {
 		var err error
@@ -871,6 +1520,8 @@ func (this *CompressedInputStream) readHeader() error {
 		}
 	}
 
+	this.ctx["checksum"] = this.hasher != nil
+
 	// Read entropy codec
 	this.entropyType = uint32(this.ibs.ReadBits(5))
 	this.ctx["codec"] = entropy.GetName(this.entropyType)
@@ -893,14 +1544,127 @@ func (this *CompressedInputStream) readHeader() error {
 		this.jobs = int(uint(1<<31) / this.blockSize)
 	}
 
-	// Read number of blocks in input. 0 means 'unknown' and 63 means 63 or more.
-	this.nbInputBlocks = uint8(this.ibs.ReadBits(6))
+	if format.hasBlockCount == true {
+		// Read number of blocks in input. 0 means 'unknown' and 63 means 63 or more.
+		this.nbInputBlocks = uint8(this.ibs.ReadBits(6))
+
+		// Warm start: each block depends on the previous one (reserved bit
+		// before version 10)
+		if this.ibs.ReadBit() == 1 {
+			if format.hasExtendedHeader == false {
+				errMsg := fmt.Sprintf("Invalid bitstream, warm start flag set in a stream of format version %d", version)
+				return &IOError{msg: errMsg, code: kanzi.ERR_INVALID_FILE}
+			}
+
+			this.warm = newWarmState(this.ctx)
+			this.jobs = 1
+			this.pipelined = false
+		}
+
+		// Read number of interleaved ANS states (log2), reserved bits before
+		// version 10
+		logInterleave := uint(this.ibs.ReadBits(2))
+
+		if logInterleave != 0 && format.hasExtendedHeader == false {
+			errMsg := fmt.Sprintf("Invalid bitstream, ANS interleave factor set in a stream of format version %d", version)
+			return &IOError{msg: errMsg, code: kanzi.ERR_INVALID_FILE}
+		}
+
+		if logInterleave == 3 {
+			return &IOError{msg: "Invalid bitstream, incorrect ANS interleave factor", code: kanzi.ERR_INVALID_FILE}
+		}
+
+		this.ctx["ansInterleave"] = uint(1) << logInterleave
+	} else {
+		// Read reserved bits, the number of blocks is unknown
+		this.nbInputBlocks = 0
+		this.ctx["ansInterleave"] = uint(1)
+		this.ibs.ReadBits(9)
+	}
+
+	this.ctx["entropySegments"] = uint(1)
+	hasDict := false
 
-	// Read reserved bits
-	this.ibs.ReadBits(3)
+	if format.hasExtendedHeader == true {
+		// Read text dictionary flag, ROLZ dictionary persistence flag and max
+		// number of entropy segments - 1
+		val := uint(this.ibs.ReadBits(8))
+		segments := val&0x3F + 1
+
+		if segments > 1 && this.warm != nil {
+			return &IOError{msg: "Invalid bitstream, entropy segments with warm start", code: kanzi.ERR_INVALID_FILE}
+		}
+
+		if val&0x40 != 0 {
+			if this.warm == nil {
+				return &IOError{msg: "Invalid bitstream, ROLZ dictionary persistence without warm start", code: kanzi.ERR_INVALID_FILE}
+			}
+
+			this.warm.rolz = true
+		}
+
+		hasDict = val&0x80 != 0
+		this.ctx["entropySegments"] = segments
+	}
+
+	delete(this.ctx, "tpaqMemory")
+
+	if format.hasExtendedHeader == true {
+		// Read log2 of the TPAQ memory budget in MB (0 if not set)
+		logMemory := uint(this.ibs.ReadBits(8))
+
+		if logMemory != 0 {
+			if 1<<logMemory < entropy.TPAQ_MIN_MEMORY || 1<<logMemory > entropy.TPAQ_MAX_MEMORY {
+				return &IOError{msg: "Invalid bitstream, incorrect TPAQ memory budget", code: kanzi.ERR_INVALID_FILE}
+			}
+
+			this.ctx["tpaqMemory"] = uint(1) << logMemory
+		}
+	}
+
+	this.syncMarkers = false
+
+	if format.hasExtendedHeader == true {
+		// Read flags: sync markers (0x80), 7 reserved bits
+		flags := this.ibs.ReadBits(8)
+		this.syncMarkers = flags&_SYNC_MARKERS_MASK != 0
+	}
+
+	this.ctx["syncMarkers"] = this.syncMarkers
+
+	// The blocks of a warm start stream depend on the previous ones
+	if this.syncMarkers == false || this.warm != nil {
+		this.recovery = false
+	}
+
+	this.maxBlockBits = 0
+
+	if this.recovery == true {
+		this.maxBlockBits = this.maxBlockLen() << 3
+	}
+
+	if hasDict == true {
+		// Read checksum of the text dictionary
+		checksum := uint32(this.ibs.ReadBits(32))
+		provided, found := textDictionaryChecksum(this.ctx)
+
+		if found == false {
+			errMsg := fmt.Sprintf("The stream requires a text dictionary (checksum %08X)", checksum)
+			return &IOError{msg: errMsg, code: kanzi.ERR_MISSING_PARAM}
+		}
+
+		if provided != checksum {
+			errMsg := fmt.Sprintf("Incorrect text dictionary: checksum %08X, the stream requires %08X", provided, checksum)
+			return &IOError{msg: errMsg, code: kanzi.ERR_INVALID_PARAM}
+		}
+	} else {
+		// The stream uses the default dictionary
+		delete(this.ctx, "textDictionary")
+	}
 
 	if len(this.listeners) > 0 {
 		msg := ""
+		msg += fmt.Sprintf("Bitstream version: %d\n", version)
 		msg += fmt.Sprintf("Checksum set to %v\n", this.hasher != nil)
 		msg += fmt.Sprintf("Block size set to %d bytes\n", this.blockSize)
 		w1 := entropy.GetName(this.entropyType)
@@ -917,6 +1681,11 @@ func (this *CompressedInputStream) readHeader() error {
 		}
 
 		msg += fmt.Sprintf("Using %v transform (stage 2)\n", w2)
+
+		if hasDict == true {
+			msg += "Using the provided text dictionary\n"
+		}
+
 		evt := kanzi.NewEventFromString(kanzi.EVT_AFTER_HEADER_DECODING, 0, msg, time.Now())
 		notifyListeners(this.listeners, evt)
 	}
@@ -931,19 +1700,50 @@ func (this *CompressedInputStream) Close() error {
 		return nil
 	}
 
+	// The tasks decoding ahead share the bitstream
+	this.cancelPending()
+
 	if _, err := this.ibs.Close(); err != nil {
 		return err
 	}
 
-	// Release resources
+	this.releaseBuffers()
+	return nil
+}
+
+// Release closes the stream (if needed) and releases its buffers and entropy
+// decoders right away, even if closing fails. If the "manualMemory" parameter
+// is true, the buffers go back to util.SharedPool to be reused by the next
+// streams instead of waiting for the GC.
+func (this *CompressedInputStream) Release() error {
+	err := this.Close()
+	this.cancelPending()
+	this.releaseBuffers()
+	return err
+}
+
+func (this *CompressedInputStream) releaseBuffers() {
 	this.maxIdx = 0
+
+	// In pipelined mode, the data being read is one of the task buffers
+	if this.pipelined == false {
+		this.alloc.ReleaseBytes(this.data)
+	}
+
 	this.data = make([]byte, 0)
 
 	for i := range this.buffers {
+		this.alloc.ReleaseBytes(this.buffers[i].Buf)
 		this.buffers[i] = blockBuffer{Buf: make([]byte, 0)}
 	}
 
-	return nil
+	for i := range this.codecs {
+		this.codecs[i].Release()
+	}
+
+	if this.warm != nil {
+		this.warm.release()
+	}
 }
 
 // Read reads up to len(block) bytes into block.
@@ -1004,20 +1804,23 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 		if err := this.readHeader(); err != nil {
 			return 0, err
 		}
+
+		if this.pipelined == true {
+			if err := this.initPipeline(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if this.pipelined == true {
+		return this.processBlockPipelined()
 	}
 
 	if atomic.LoadInt32(&this.blockID) == _CANCEL_TASKS_ID {
 		return 0, nil
 	}
 
-	blkSize := int(this.blockSize)
-
-	// Add a padding area to manage any block with header or temporarily expanded
-	if _EXTRA_BUFFER_SIZE >= (blkSize >> 4) {
-		blkSize += _EXTRA_BUFFER_SIZE
-	} else {
-		blkSize += (blkSize >> 4)
-	}
+	blkSize := this.taskBufferSize()
 
 	// Protect against future concurrent modification of the list of block listeners
 	listeners := make([]kanzi.Listener, len(this.listeners))
@@ -1055,7 +1858,8 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 			// Output buffers this.buffers[2*taskID+1] are lazily instantiated
 			// by the decoding tasks.
 			if len(this.buffers[2*taskID].Buf) < blkSize+1024 {
-				this.buffers[2*taskID].Buf = make([]byte, blkSize+1024)
+				this.alloc.ReleaseBytes(this.buffers[2*taskID].Buf)
+				this.buffers[2*taskID].Buf = this.alloc.MakeBytes(blkSize + 1024)
 			}
 
 			copyCtx := make(map[string]interface{})
@@ -1080,7 +1884,15 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 				wg:                 &wg,
 				listeners:          listeners,
 				ibs:                this.ibs,
-				ctx:                copyCtx}
+				codecs:             &this.codecs[taskID],
+				ctx:                copyCtx,
+				alloc:              this.alloc,
+				warm:               this.warm,
+				syncMarkers:        this.syncMarkers,
+				recovery:           this.recovery,
+				markerDelta:        &this.markerDelta,
+				lostBlocks:         &this.lostBlocks,
+				maxBlockBits:       this.maxBlockBits}
 
 			// Invoke the tasks concurrently
 			go task.decode(&results[taskID])
@@ -1108,8 +1920,7 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 		}
 
 		if len(this.data) < decoded {
-			extraBuf := make([]byte, decoded-len(this.data))
-			this.data = append(this.data, extraBuf...)
+			this.data = this.alloc.GrowBytes(this.data, decoded)
 		}
 
 		offset := 0
@@ -1136,11 +1947,230 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 	return decoded, nil
 }
 
+// Size of the task buffers: add a padding area to manage any block with
+// header or temporarily expanded
+func (this *CompressedInputStream) taskBufferSize() int {
+	blkSize := int(this.blockSize)
+
+	if _EXTRA_BUFFER_SIZE >= (blkSize >> 4) {
+		return blkSize + _EXTRA_BUFFER_SIZE
+	}
+
+	return blkSize + (blkSize >> 4)
+}
+
+// Allocate one slot (task buffers and entropy decoder) per block in flight
+// plus one for the block being read.
+func (this *CompressedInputStream) initPipeline() error {
+	nbSlots := this.jobs + 1
+
+	// No need to decode more blocks ahead than there are blocks
+	if this.nbInputBlocks != 0 && nbSlots > int(this.nbInputBlocks)+1 {
+		nbSlots = int(this.nbInputBlocks) + 1
+	}
+
+	if this.maxBuffered != 0 {
+		if this.maxBuffered < 2*this.blockSize {
+			errMsg := fmt.Sprintf("The maximum buffered size must be at least 2 blocks (%d bytes)", 2*this.blockSize)
+			return &IOError{msg: errMsg, code: kanzi.ERR_INVALID_PARAM}
+		}
+
+		if maxSlots := int(this.maxBuffered / this.blockSize); nbSlots > maxSlots {
+			nbSlots = maxSlots
+		}
+	}
+
+	this.buffers = make([]blockBuffer, 2*nbSlots)
+
+	for i := range this.buffers {
+		this.buffers[i] = blockBuffer{Buf: make([]byte, 0)}
+	}
+
+	this.codecs = make([]entropy.CodecPool, nbSlots)
+	this.jobsPerTask = kanzi.ComputeJobsPerTask(make([]uint, nbSlots-1), uint(this.jobs), uint(nbSlots-1))
+	this.pending = make([]*pendingBlock, 0, nbSlots-1)
+	return nil
+}
+
+// Start decoding tasks until the maximum number of blocks in flight is
+// reached. Consecutive blocks use consecutive slots so that the slot of
+// the block being read is never reused before the next call.
+func (this *CompressedInputStream) scheduleBlocks() {
+	nbSlots := len(this.codecs)
+	blkSize := this.taskBufferSize()
+	var listeners []kanzi.Listener
+
+	for len(this.pending) < nbSlots-1 {
+		// End of stream reached or error: no more blocks to decode
+		if atomic.LoadInt32(&this.blockID) == _CANCEL_TASKS_ID {
+			return
+		}
+
+		if listeners == nil {
+			// Protect against future concurrent modification of the list of block listeners
+			listeners = make([]kanzi.Listener, len(this.listeners))
+			copy(listeners, this.listeners)
+		}
+
+		slot := int(this.lastBlockID) % nbSlots
+
+		if len(this.buffers[2*slot].Buf) < blkSize+1024 {
+			this.alloc.ReleaseBytes(this.buffers[2*slot].Buf)
+			this.buffers[2*slot].Buf = this.alloc.MakeBytes(blkSize + 1024)
+		}
+
+		copyCtx := make(map[string]interface{})
+
+		for k, v := range this.ctx {
+			copyCtx[k] = v
+		}
+
+		copyCtx["jobs"] = this.jobsPerTask[int(this.lastBlockID)%len(this.jobsPerTask)]
+		this.lastBlockID++
+		pb := &pendingBlock{}
+		pb.wg.Add(1)
+
+		task := decodingTask{
+			iBuffer:            &this.buffers[2*slot],
+			oBuffer:            &this.buffers[2*slot+1],
+			hasher:             this.hasher,
+			blockLength:        uint(blkSize),
+			blockTransformType: this.transformType,
+			blockEntropyType:   this.entropyType,
+			currentBlockID:     this.lastBlockID,
+			processedBlockID:   &this.blockID,
+			wg:                 &pb.wg,
+			listeners:          listeners,
+			ibs:                this.ibs,
+			codecs:             &this.codecs[slot],
+			ctx:                copyCtx,
+			alloc:              this.alloc,
+			warm:               this.warm,
+			syncMarkers:        this.syncMarkers,
+			recovery:           this.recovery,
+			markerDelta:        &this.markerDelta,
+			lostBlocks:         &this.lostBlocks,
+			maxBlockBits:       this.maxBlockBits}
+
+		this.pending = append(this.pending, pb)
+		go task.decode(&pb.result)
+	}
+}
+
+// Deliver the next decoded block, keeping the following ones decoding in
+// the background. The data is read directly from the slot buffer.
+func (this *CompressedInputStream) processBlockPipelined() (int, error) {
+	for {
+		this.scheduleBlocks()
+
+		if len(this.pending) == 0 {
+			return 0, nil
+		}
+
+		head := this.pending[0]
+		head.wg.Wait()
+		copy(this.pending, this.pending[1:])
+		this.pending[len(this.pending)-1] = nil
+		this.pending = this.pending[0 : len(this.pending)-1]
+		r := &head.result
+
+		if r.err != nil {
+			this.cancelPending()
+			return 0, r.err
+		}
+
+		if r.skipped == true {
+			continue
+		}
+
+		if r.decoded == 0 {
+			// End of stream: the tasks of the next blocks have been cancelled
+			this.cancelPending()
+			return 0, nil
+		}
+
+		if r.decoded > int(this.blockSize) {
+			this.cancelPending()
+			return 0, &IOError{msg: "Invalid data", code: kanzi.ERR_PROCESS_BLOCK}
+		}
+
+		// Keep the tasks busy while this block is being read
+		this.scheduleBlocks()
+		this.data = r.data
+		this.curIdx = 0
+
+		if len(this.listeners) > 0 {
+			// Notify after transform ... in block order !
+			evt := kanzi.NewEvent(kanzi.EVT_AFTER_TRANSFORM, int(r.blockID),
+				int64(r.decoded), r.checksum, this.hasher != nil, r.completionTime)
+			notifyListeners(this.listeners, evt)
+		}
+
+		return r.decoded, nil
+	}
+}
+
+// Stop the tasks of the blocks decoded ahead and wait for them
+func (this *CompressedInputStream) cancelPending() {
+	if len(this.pending) == 0 {
+		return
+	}
+
+	atomic.StoreInt32(&this.blockID, _CANCEL_TASKS_ID)
+
+	for _, pb := range this.pending {
+		pb.wg.Wait()
+	}
+
+	for i := range this.pending {
+		this.pending[i] = nil
+	}
+
+	this.pending = this.pending[:0]
+}
+
 // GetRead returns the number of bytes read so far
 func (this *CompressedInputStream) GetRead() uint64 {
 	return (this.ibs.Read() + 7) >> 3
 }
 
+// Max size in bytes of an encoded block: same bound as the encoder, plus the
+// padding of an aligned block.
+func (this *CompressedInputStream) maxBlockLen() uint64 {
+	ctx := make(map[string]interface{})
+
+	for k, v := range this.ctx {
+		ctx[k] = v
+	}
+
+	ctx["size"] = this.blockSize
+	length := int(this.blockSize)
+
+	if t, err := function.NewByteFunction(&ctx, this.transformType); err == nil {
+		length = t.MaxEncodedLen(length)
+
+		if this.alloc.Pooled() == true {
+			t.Release()
+		}
+	}
+
+	segments := int(this.ctx["entropySegments"].(uint))
+	return uint64(maxEncodedBlockLen(this.entropyType, length, segments)) + 1
+}
+
+// GetVersion returns the format version of the stream (0 until the header
+// has been read by the first call to Read)
+func (this *CompressedInputStream) GetVersion() int {
+	return this.version
+}
+
+// GetLostBlocks returns the number of blocks dropped so far by the recovery
+// mode ("recover" parameter, streams with sync markers only): corrupted
+// blocks and blocks skipped to find the next sync marker.
+func (this *CompressedInputStream) GetLostBlocks() int {
+	return int(atomic.LoadInt32(&this.lostBlocks))
+}
+
 // Decode mode + transformed entropy coded data
 // mode | 0b10000000 => copy block
 //      | 0b0yy00000 => size(size(block))-1
@@ -1160,6 +2190,11 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 	defer func() {
 		res.data = this.iBuffer.Buf
 		res.decoded = decoded
+
+		// Warm start: a skipped block is decoded but not delivered
+		if skipped == true {
+			res.decoded = 0
+		}
 		res.blockID = int(this.currentBlockID)
 		res.completionTime = time.Now()
 		res.checksum = checksum1
@@ -1169,6 +2204,15 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 			res.err = &IOError{msg: r.(error).Error(), code: kanzi.ERR_PROCESS_BLOCK}
 		}
 
+		// Recovery: drop the corrupted block, the next task looks for the
+		// next sync marker if the bitstream is out of sync
+		if res.err != nil && this.recovery == true {
+			res.err = nil
+			res.decoded = 0
+			res.skipped = true
+			atomic.AddInt32(this.lostBlocks, 1)
+		}
+
 		// Unblock other tasks
 		if res.err != nil || (res.decoded == 0 && res.skipped == false) {
 			atomic.StoreInt32(this.processedBlockID, _CANCEL_TASKS_ID)
@@ -1194,6 +2238,20 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 		runtime.Gosched()
 	}
 
+	endMarker := false
+
+	if this.syncMarkers == true {
+		found, end, err := this.readSyncMarker()
+
+		if found == false {
+			// Recovery: no sync marker before the end of the stream (no error)
+			res.err = err
+			return
+		}
+
+		endMarker = end
+	}
+
 	// Read shared bitstream sequentially
 	lw := uint(32)
 
@@ -1203,6 +2261,13 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 
 	read := this.ibs.ReadBits(lw)
 
+	// Only the end block has a flagged sync marker (EG. not a zeroed area)
+	if this.syncMarkers == true && (read == 0) != endMarker {
+		errMsg := fmt.Sprintf("Invalid block size: %d (end sync marker: %v)", read, endMarker)
+		res.err = &IOError{msg: errMsg, code: kanzi.ERR_BLOCK_SIZE}
+		return
+	}
+
 	if read == 0 {
 		return
 	}
@@ -1212,17 +2277,40 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 		return
 	}
 
+	// Recovery: a corrupted block size must not swallow the next blocks
+	if this.maxBlockBits != 0 && read > this.maxBlockBits {
+		res.err = &IOError{msg: "Invalid block size", code: kanzi.ERR_BLOCK_SIZE}
+		return
+	}
+
 	r := int((read + 7) >> 3)
-	maxL := r
 
-	if int(this.blockLength) > r {
-		maxL = int(this.blockLength)
-	}
+	// In place decoding: the compressed data goes to the second buffer (sized
+	// for it only), the entropy decoder and the inverse transform both write
+	// to the first one. Otherwise the transform needs two full size buffers.
+	inPlace := function.CanInverseInPlace(this.blockTransformType)
+	var input []byte
 
-	if len(data) < maxL {
-		extraBuf := make([]byte, maxL-len(data))
-		buffer = append(data, extraBuf...)
-		this.iBuffer.Buf = data
+	if inPlace == true {
+		if len(buffer) < r {
+			buffer = this.alloc.GrowBytes(buffer, r)
+			this.oBuffer.Buf = buffer
+		}
+
+		input = buffer
+	} else {
+		maxL := r
+
+		if int(this.blockLength) > r {
+			maxL = int(this.blockLength)
+		}
+
+		if len(data) < maxL {
+			data = this.alloc.GrowBytes(data, maxL)
+			this.iBuffer.Buf = data
+		}
+
+		input = data
 	}
 
 	// Read data from shared bitstream
@@ -1233,7 +2321,7 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 			chkSize = 1 << 31
 		}
 
-		this.ibs.ReadArray(data[n:], chkSize)
+		this.ibs.ReadArray(input[n:], chkSize)
 		n += ((chkSize + 7) >> 3)
 		read -= uint64(chkSize)
 	}
@@ -1248,7 +2336,6 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 
 		if int(this.currentBlockID) < from {
 			skipped = true
-			return
 		}
 	}
 
@@ -1257,14 +2344,17 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 
 		if int(this.currentBlockID) >= to {
 			skipped = true
-			return
 		}
 	}
 
+	// Warm start: the skipped blocks are decoded to update the statistics
+	if skipped == true && this.warm == nil {
+		return
+	}
+
 	// All the code below is concurrent
-	// Create a bitstream local to the task
-	bufStream := util.NewBufferStream(data[0:r])
-	ibs, _ := bitstream.NewDefaultInputBitStream(bufStream, 16384)
+	// Create a bitstream local to the task (reading the block directly)
+	ibs := bitstream.NewSliceInputBitStream(input[0:r])
 
 	mode := byte(ibs.ReadBits(8))
 	skipFlags := byte(0)
@@ -1315,17 +2405,42 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 		bufferSize = preTransformLength + _EXTRA_BUFFER_SIZE
 	}
 
-	if len(buffer) < int(bufferSize) {
-		extraBuf := make([]byte, int(bufferSize)-len(buffer))
-		buffer = append(buffer, extraBuf...)
+	output := buffer
+
+	if inPlace == true {
+		if len(data) < int(bufferSize) {
+			data = this.alloc.GrowBytes(data, int(bufferSize))
+			this.iBuffer.Buf = data
+		}
+
+		output = data
+	} else if len(buffer) < int(bufferSize) {
+		buffer = this.alloc.GrowBytes(buffer, int(bufferSize))
 		this.oBuffer.Buf = buffer
+		output = buffer
 	}
 
 	this.ctx["size"] = preTransformLength
+	metrics := getMetrics(this.ctx)
+	profiling := isProfilingStages(this.ctx)
+	var startTime time.Time
+
+	if metrics != nil {
+		startTime = time.Now()
+	}
 
 	// Each block is decoded separately
-	// Rebuild the entropy decoder to reset block statistics
-	ed, err := entropy.NewEntropyDecoder(ibs, this.ctx, this.blockEntropyType)
+	// Reset the entropy decoder of the task to reset block statistics (unless warm start)
+	var ed kanzi.EntropyDecoder
+	var err error
+
+	if this.warm != nil {
+		ed, err = this.warm.models.NewEntropyDecoder(ibs, this.ctx, this.blockEntropyType)
+	} else if isSegmented(this.blockEntropyType, getEntropySegments(this.ctx)) == true {
+		ed, err = entropy.NewSegmentedDecoder(ibs, this.ctx, this.blockEntropyType)
+	} else {
+		ed, err = this.codecs.NewEntropyDecoder(ibs, this.ctx, this.blockEntropyType)
+	}
 
 	if err != nil {
 		// Error => cancel concurrent decoding tasks
@@ -1336,12 +2451,20 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 	defer ed.Dispose()
 
 	// Block entropy decode
-	if _, err = ed.Read(buffer[0:preTransformLength]); err != nil {
+	runStage(profiling, STAGE_ENTROPY_DECODE, entropy.GetName(this.blockEntropyType), func() {
+		_, err = ed.Read(output[0:preTransformLength])
+	})
+
+	if err != nil {
 		// Error => cancel concurrent decoding tasks
 		res.err = &IOError{msg: err.Error(), code: kanzi.ERR_PROCESS_BLOCK}
 		return
 	}
 
+	if metrics != nil {
+		metrics.AddStageTime(STAGE_ENTROPY_DECODE, entropy.GetName(this.blockEntropyType), time.Since(startTime))
+	}
+
 	if len(this.listeners) > 0 {
 		// Notify after entropy
 		evt := kanzi.NewEvent(kanzi.EVT_AFTER_ENTROPY, int(this.currentBlockID),
@@ -1357,6 +2480,11 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 	}
 
 	this.ctx["size"] = preTransformLength
+
+	if this.warm != nil {
+		this.warm.prepare(this.ctx)
+	}
+
 	transform, err := function.NewByteFunction(&this.ctx, this.blockTransformType)
 
 	if err != nil {
@@ -1368,8 +2496,25 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 	transform.SetSkipFlags(skipFlags)
 	var oIdx uint
 
+	if metrics != nil {
+		startTime = time.Now()
+	}
+
 	// Inverse transform
-	if _, oIdx, err = transform.Inverse(buffer[0:preTransformLength], data); err != nil {
+	runStage(profiling, STAGE_INVERSE_TRANSFORM, function.GetName(this.blockTransformType), func() {
+		if inPlace == true {
+			oIdx, err = transform.InverseInPlace(data, preTransformLength)
+		} else {
+			_, oIdx, err = transform.Inverse(buffer[0:preTransformLength], data)
+		}
+	})
+
+	// Manual memory mode: the work buffers of the transforms are reused by the next blocks
+	if this.alloc.Pooled() == true {
+		transform.Release()
+	}
+
+	if err != nil {
 		// Error => return
 		res.err = &IOError{msg: err.Error(), code: kanzi.ERR_PROCESS_BLOCK}
 		return
@@ -1377,6 +2522,14 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 
 	decoded = int(oIdx)
 
+	if this.warm != nil {
+		this.warm.update(data[0:decoded])
+	}
+
+	if metrics != nil {
+		metrics.AddStageTime(STAGE_INVERSE_TRANSFORM, function.GetName(this.blockTransformType), time.Since(startTime))
+	}
+
 	// Verify checksum
 	if this.hasher != nil {
 		checksum2 := this.hasher.Hash(data[0:decoded])
@@ -1387,4 +2540,42 @@ func (this *decodingTask) decode(res *decodingTaskResult) {
 			return
 		}
 	}
+
+	if metrics != nil {
+		metrics.AddBlock(int64(r), int64(decoded), mode&_COPY_BLOCK_MASK != 0)
+	}
+}
+
+// Read the sync marker of the block. The counter of the marker is the block
+// ID unless blocks have been lost (flagged for the end block). In recovery
+// mode, a missing marker means that the bitstream is out of sync (EG.
+// corrupted block length): the bits up to the next sync marker are skipped.
+// Returns false if no marker is found, then whether it is the end marker.
+func (this *decodingTask) readSyncMarker() (bool, bool, *IOError) {
+	expected := uint32(this.currentBlockID + *this.markerDelta)
+	counter, err := bitstream.ReadSyncMarker(this.ibs)
+
+	if err == nil && counter&^_END_SYNC_MARKER != expected {
+		err = fmt.Errorf("Invalid sync marker counter: %d, expected %d", counter&^_END_SYNC_MARKER, expected)
+	}
+
+	if err == nil {
+		return true, counter&_END_SYNC_MARKER != 0, nil
+	}
+
+	if this.recovery == false {
+		return false, false, &IOError{msg: err.Error(), code: kanzi.ERR_PROCESS_BLOCK}
+	}
+
+	if counter, err = bitstream.ScanSyncMarker(this.ibs); err != nil {
+		return false, false, nil
+	}
+
+	// The blocks of the markers skipped are lost
+	if id := counter &^ _END_SYNC_MARKER; id > expected {
+		atomic.AddInt32(this.lostBlocks, int32(id-expected))
+	}
+
+	*this.markerDelta = int32(counter&^_END_SYNC_MARKER) - this.currentBlockID
+	return true, counter&_END_SYNC_MARKER != 0, nil
 }
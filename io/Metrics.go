@@ -0,0 +1,86 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"expvar"
+	"time"
+)
+
+const (
+	STAGE_FORWARD_TRANSFORM = "transform.forward" // Forward transform of a block
+	STAGE_INVERSE_TRANSFORM = "transform.inverse" // Inverse transform of a block
+	STAGE_ENTROPY_ENCODE    = "entropy.encode"    // Entropy encoding of a block
+	STAGE_ENTROPY_DECODE    = "entropy.decode"    // Entropy decoding of a block
+)
+
+// Metrics collects statistics from the compressed streams. It is provided
+// to the streams with the "metrics" key of the context map.
+// Implementations must be safe for concurrent use since blocks are
+// processed by several tasks at once.
+type Metrics interface {
+	// AddBlock records one processed block: the number of bytes consumed,
+	// the number of bytes produced and whether the block was stored raw
+	// (copy block) instead of being transformed and entropy coded.
+	AddBlock(bytesIn, bytesOut int64, raw bool)
+
+	// AddStageTime records the time spent by the codec in a pipeline stage
+	// (one of the STAGE_xxx constants) for one block.
+	AddStageTime(stage, codec string, elapsed time.Duration)
+}
+
+// ExpvarMetrics is a Metrics implementation publishing counters with expvar
+type ExpvarMetrics struct {
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics creates a new instance of ExpvarMetrics publishing the counters
+// in an expvar map with the provided name. Panics if the name is already
+// registered (see expvar.Publish).
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+// AddBlock updates the 'bytes_in', 'bytes_out', 'blocks' and 'raw_blocks' counters
+func (this *ExpvarMetrics) AddBlock(bytesIn, bytesOut int64, raw bool) {
+	this.vars.Add("bytes_in", bytesIn)
+	this.vars.Add("bytes_out", bytesOut)
+	this.vars.Add("blocks", 1)
+
+	if raw == true {
+		this.vars.Add("raw_blocks", 1)
+	}
+}
+
+// AddStageTime updates the 'time_ns.<stage>.<codec>' counter
+func (this *ExpvarMetrics) AddStageTime(stage, codec string, elapsed time.Duration) {
+	this.vars.Add("time_ns."+stage+"."+codec, int64(elapsed))
+}
+
+// Map returns the underlying expvar map
+func (this *ExpvarMetrics) Map() *expvar.Map {
+	return this.vars
+}
+
+func getMetrics(ctx map[string]interface{}) Metrics {
+	if val, containsKey := ctx["metrics"]; containsKey {
+		if m, ok := val.(Metrics); ok == true {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"context"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Stages are labelled in CPU profiles ('kanzi.stage' and 'kanzi.codec' labels)
+// and wrapped in execution trace regions when the "profileStages" key of
+// the context map is set to true. Labelling has a small cost per block so it
+// is disabled by default.
+
+func isProfilingStages(ctx map[string]interface{}) bool {
+	if val, containsKey := ctx["profileStages"]; containsKey {
+		if b, ok := val.(bool); ok == true {
+			return b
+		}
+	}
+
+	return false
+}
+
+// runStage executes f, labelled with the stage and codec names if enabled
+func runStage(enabled bool, stage, codec string, f func()) {
+	if enabled == false {
+		f()
+		return
+	}
+
+	labels := pprof.Labels("kanzi.stage", stage, "kanzi.codec", codec)
+
+	pprof.Do(context.Background(), labels, func(c context.Context) {
+		trace.WithRegion(c, stage, f)
+	})
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util/hash"
+)
+
+// The text codec uses the static dictionary of the "textDictionary" context
+// entry (capitalized words, EG. "TheOfAnd") instead of the default one. The
+// dictionary can also be read from the file of the "textDictionaryFile"
+// context entry (EG. produced by 'DictConverter -type=text').
+// The stream header records the checksum of the dictionary: the decoder
+// fails if the same dictionary is not provided.
+
+// loadTextDictionary reads the file of the "textDictionaryFile" context
+// entry (if any) into the "textDictionary" context entry
+func loadTextDictionary(ctx map[string]interface{}) *IOError {
+	val, containsKey := ctx["textDictionaryFile"]
+
+	if containsKey == false {
+		return nil
+	}
+
+	name := val.(string)
+	words, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		errMsg := fmt.Sprintf("Cannot read text dictionary file '%v': %v", name, err)
+		return &IOError{msg: errMsg, code: kanzi.ERR_OPEN_FILE}
+	}
+
+	ctx["textDictionary"] = words
+	return nil
+}
+
+// textDictionaryChecksum returns the checksum of the dictionary of the
+// "textDictionary" context entry and true, or false if there is none
+func textDictionaryChecksum(ctx map[string]interface{}) (uint32, bool) {
+	val, containsKey := ctx["textDictionary"]
+
+	if containsKey == false {
+		return 0, false
+	}
+
+	hasher, _ := hash.NewXXHash32(_BITSTREAM_TYPE)
+	return hasher.Hash(val.([]byte)), true
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+const (
+	// BITSTREAM_FORMAT_VERSION is the newest version of the stream format
+	// written by this library (see streamFormat)
+	BITSTREAM_FORMAT_VERSION = _BITSTREAM_FORMAT_VERSION
+
+	// MIN_BITSTREAM_FORMAT_VERSION is the oldest version of the stream format
+	// that this library can decode
+	MIN_BITSTREAM_FORMAT_VERSION = 8
+
+	// LIBRARY_VERSION is the version of this library
+	LIBRARY_VERSION = "1.8"
+)
+
+// Kanzi release that introduced each stream format version
+var _LIBRARY_VERSIONS = map[int]string{
+	6:  "1.5",
+	7:  "1.6",
+	8:  "1.7",
+	9:  "1.8",
+	10: "1.9", // not released yet
+}
+
+// Differences between the stream format versions that can be decoded.
+// The block layout (mode, skip flags, sizes, checksum) is shared.
+type streamFormat struct {
+	// Version 9 stores the number of blocks (0 means unknown) in the header,
+	// followed by 3 reserved bits (zero). Before, the 9 trailing bits are
+	// reserved.
+	hasBlockCount bool
+
+	// Version 10 stores the warm start flag and log2 of the ANS interleave
+	// factor in the reserved bits of version 9 and extends the header with 3
+	// bytes:
+	// - text dictionary flag (0x80), ROLZ dictionary persistence flag (0x40,
+	//   warm start only) and max number of entropy segments of a block - 1
+	// - log2 of the memory budget of the TPAQ model in MB (0 means sized
+	//   after the block size)
+	// - flags: 0x80 means that a sync marker precedes each block (see
+	//   bitstream.WriteSyncMarker), the other bits are reserved
+	// If the text dictionary flag is set, the checksum of the dictionary (32
+	// bits) follows. Version 10 BWT blocks store a primary index per MB
+	// instead of per 4 MB (see transform.BWT), LZ blocks may use repeat
+	// codes (see function.LZXCodec), RLT blocks a two byte escape or 16 bit
+	// runs (see function.RLT) and SRT blocks chunks (see function.SRT). The
+	// preset LZ dictionary ("lzDictionary"), the ROLZ dictionary
+	// ("rolzDictionary") and the dictionary of the CM and TPAQ models
+	// ("cmDictionary") also require version 10.
+	// A version 9 stream is written when none of these fields is used, so
+	// that older releases can decode it.
+	hasExtendedHeader bool
+}
+
+var _STREAM_FORMATS = map[int]streamFormat{
+	8:  {hasBlockCount: false},
+	9:  {hasBlockCount: true},
+	10: {hasBlockCount: true, hasExtendedHeader: true},
+}
+
+// CanDecode returns true if this library can decode a stream written
+// with the provided format version
+func CanDecode(version int) bool {
+	_, found := _STREAM_FORMATS[version]
+	return found
+}
+
+// MinLibraryVersion returns the oldest kanzi release able to decode a stream
+// written with the provided format version or an empty string if unknown
+func MinLibraryVersion(version int) string {
+	return _LIBRARY_VERSIONS[version]
+}
+
+// versionError builds the error returned when a stream format version
+// cannot be decoded by this library
+func versionError(version int) *IOError {
+	var errMsg string
+
+	if version > BITSTREAM_FORMAT_VERSION {
+		if lib := MinLibraryVersion(version); lib != "" {
+			errMsg = fmt.Sprintf("Cannot read stream format version %d: requires kanzi %v or newer (this is kanzi %v)",
+				version, lib, LIBRARY_VERSION)
+		} else {
+			errMsg = fmt.Sprintf("Cannot read stream format version %d: requires a kanzi version newer than %v",
+				version, LIBRARY_VERSION)
+		}
+	} else {
+		errMsg = fmt.Sprintf("Cannot read stream format version %d: kanzi %v reads versions %d to %d",
+			version, LIBRARY_VERSION, MIN_BITSTREAM_FORMAT_VERSION, BITSTREAM_FORMAT_VERSION)
+
+		if lib := MinLibraryVersion(version); lib != "" {
+			errMsg += fmt.Sprintf(" (stream written by kanzi %v)", lib)
+		}
+	}
+
+	return &IOError{msg: errMsg, code: kanzi.ERR_STREAM_VERSION}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+// warmState holds the statistics carried over from one block to the next
+// in warm start mode ("warmStart" context entry, flag in the stream header):
+// the models of the context model based entropy codecs and a rolling window
+// with the last bytes of the previous blocks, used as a preset dictionary by
+// the LZ transform (matches can cross block boundaries). Blocks depend on
+// the previous ones, so they are encoded and decoded one at a time.
+// With ROLZ dictionary persistence ("warmROLZ" context entry, flag in the
+// stream header), a second window primes the match tables of the ROLZ
+// transform. The encoder empties it on flush and each ROLZ block stores how
+// many bytes it uses, so the decoder does not need to track the flushes.
+type warmState struct {
+	models     entropy.ModelCache
+	window     []byte
+	rolz       bool
+	rolzWindow []byte
+}
+
+// newWarmState creates a warm state. The window starts with the preset LZ
+// dictionary of the context (if any).
+func newWarmState(ctx map[string]interface{}) *warmState {
+	this := &warmState{window: make([]byte, 0), rolzWindow: make([]byte, 0)}
+
+	if val, containsKey := ctx["lzDictionary"]; containsKey {
+		this.update(val.([]byte))
+	}
+
+	return this
+}
+
+// prepare provides the rolling window to the transforms of the next block
+func (this *warmState) prepare(ctx map[string]interface{}) {
+	if len(this.window) > 0 {
+		ctx["lzDictionary"] = this.window
+	}
+
+	if this.rolz == true {
+		ctx["rolzDictionary"] = this.rolzWindow
+	}
+}
+
+// update appends the original bytes of a block to the rolling windows
+func (this *warmState) update(block []byte) {
+	this.window = slideWindow(this.window, block, function.LZ_MAX_DICTIONARY_SIZE)
+
+	if this.rolz == true {
+		this.rolzWindow = slideWindow(this.rolzWindow, block, function.ROLZ_MAX_DICTIONARY_SIZE)
+	}
+}
+
+// flush empties the ROLZ window: the next blocks do not refer to the
+// previous ones (the LZ window and the models are kept)
+func (this *warmState) flush() {
+	this.rolzWindow = make([]byte, 0)
+}
+
+// slideWindow returns a window with the last 'size' bytes of the window
+// followed by the block
+func slideWindow(window, block []byte, size int) []byte {
+	if len(block) >= size {
+		block = block[len(block)-size:]
+	}
+
+	// The previous window may still be referenced by a transform: build a new one
+	keep := len(window)
+
+	if keep+len(block) > size {
+		keep = size - len(block)
+	}
+
+	res := make([]byte, keep+len(block))
+	copy(res, window[len(window)-keep:])
+	copy(res[keep:], block)
+	return res
+}
+
+// release drops the models and the windows
+func (this *warmState) release() {
+	this.models.Release()
+	this.window = make([]byte, 0)
+	this.rolzWindow = make([]byte, 0)
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package npy compresses NumPy .npy files (and the arrays in .npz files)
+// using the dtype in the header to select a filter (byte transposition,
+// delta) and a pipeline suited to the element type. Generic byte oriented
+// pipelines do poorly on multi-byte numeric elements.
+//
+// Compressed layout: magic (32 bits), version (8 bits), element size (8
+// bits), block size (varint), size of the .npy header (varint), .npy header,
+// size of the array data (varint), pipeline string length (8 bits),
+// pipeline string (filter transforms included), then for each block:
+// payload size (varint) and pipeline payload (see pipeline.Pipeline).
+// Decoding yields the original .npy file byte for byte.
+package npy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+const (
+	// KNPY_MAGIC is the signature of compressed .npy files
+	KNPY_MAGIC = uint32(0x4B4E5059) // "KNPY"
+	// KNPY_VERSION is the version of the compressed layout
+	KNPY_VERSION = 2
+
+	_NPY_BLOCK_SIZE = 4 * 1024 * 1024
+	_NPY_MAX_BLOCK  = 256 * 1024 * 1024
+)
+
+// Choose returns the filter (FILTER_xxx) and the pipeline string used for
+// the arrays described by the header. A sample of the array data is used
+// to decide whether integer elements benefit from delta coding.
+func Choose(h *Header, data []byte) (int, string) {
+	filtered := h.ElemSize == 2 || h.ElemSize == 4 || h.ElemSize == 8
+
+	switch h.Kind {
+	case 'f', 'c':
+		if filtered == true {
+			// Sign and exponent bytes are very redundant once grouped,
+			// the low mantissa bytes are mostly noise.
+			return FILTER_SHUFFLE, "BWT&CM"
+		}
+
+	case 'i', 'u', 'M', 'm':
+		if filtered == true {
+			// Delta turns sorted or smooth series into small values but
+			// makes random values larger (little endian elements only)
+			if h.BigEndian == false &&
+				sampleEntropy(data, h.ElemSize, FILTER_DELTA_SHUFFLE) < sampleEntropy(data, h.ElemSize, FILTER_SHUFFLE) {
+				return FILTER_DELTA_SHUFFLE, "BWT&CM"
+			}
+
+			return FILTER_SHUFFLE, "BWT&CM"
+		}
+
+	case 'S', 'U':
+		return FILTER_NONE, "TEXT+BWT+RANK+ZRLT&ANS0"
+	}
+
+	return FILTER_NONE, "BWT+RANK+ZRLT&ANS0"
+}
+
+// Encode appends the compressed .npy file to dst and returns the result.
+// The filter and pipeline are selected from the dtype (see Choose).
+func Encode(dst, npy []byte) ([]byte, error) {
+	h, err := ParseHeader(npy)
+
+	if err != nil {
+		return dst, err
+	}
+
+	filter, pipelineStr := Choose(h, npy[h.DataOffset:])
+	return EncodeWith(dst, npy, filter, pipelineStr)
+}
+
+// EncodeWith appends the compressed .npy file to dst using the provided
+// filter and pipeline string and returns the result
+func EncodeWith(dst, npy []byte, filter int, pipelineStr string) ([]byte, error) {
+	h, err := ParseHeader(npy)
+
+	if err != nil {
+		return dst, err
+	}
+
+	size := h.ElemSize
+	transforms, ctx, err := filterTransforms(filter, size, h.BigEndian)
+
+	if err != nil {
+		return dst, fmt.Errorf("%v (dtype '%v')", err, h.Descr)
+	}
+
+	if size <= 0 || size > 255 {
+		size = 1
+	}
+
+	desc, err := pipeline.Parse(transforms + pipelineStr)
+
+	if err != nil {
+		return dst, err
+	}
+
+	p, err := pipeline.NewPipelineWithCtx(desc, ctx)
+
+	if err != nil {
+		return dst, err
+	}
+
+	name := p.String()
+	blockSize := (_NPY_BLOCK_SIZE / size) * size
+	data := npy[h.DataOffset:]
+
+	dst = appendUint32(dst, KNPY_MAGIC)
+	dst = append(dst, KNPY_VERSION, byte(size))
+	dst = appendUvarint(dst, uint64(blockSize))
+	dst = appendUvarint(dst, uint64(h.DataOffset))
+	dst = append(dst, npy[0:h.DataOffset]...)
+	dst = appendUvarint(dst, uint64(len(data)))
+	dst = append(dst, byte(len(name)))
+	dst = append(dst, name...)
+
+	output := make([]byte, p.MaxEncodedLen(blockSize))
+
+	for len(data) > 0 {
+		n := blockSize
+
+		if n > len(data) {
+			n = len(data)
+		}
+
+		block := data[0:n]
+		data = data[n:]
+		_, written, err := p.Forward(block, output)
+
+		if err != nil {
+			return dst, err
+		}
+
+		dst = appendUvarint(dst, uint64(written))
+		dst = append(dst, output[0:written]...)
+	}
+
+	return dst, nil
+}
+
+// Decode appends the original .npy file to dst and returns the result
+func Decode(dst, src []byte) ([]byte, error) {
+	if len(src) < 6 || binary.BigEndian.Uint32(src) != KNPY_MAGIC {
+		return dst, errors.New("Invalid compressed npy data: missing signature")
+	}
+
+	if src[4] != KNPY_VERSION {
+		return dst, fmt.Errorf("Invalid compressed npy data: unsupported version %d", src[4])
+	}
+
+	size := int(src[5])
+
+	if size == 0 {
+		return dst, errors.New("Invalid compressed npy data: incorrect element size")
+	}
+
+	idx := 6
+	var err error
+
+	readUvarint := func(max uint64) int {
+		v, n := binary.Uvarint(src[idx:])
+
+		if n <= 0 || v > max {
+			err = errors.New("Invalid compressed npy data: truncated data")
+			return 0
+		}
+
+		idx += n
+		return int(v)
+	}
+
+	blockSize := readUvarint(_NPY_MAX_BLOCK)
+	headerSize := readUvarint(uint64(len(src)))
+
+	if err != nil || blockSize == 0 || blockSize%size != 0 || headerSize > len(src)-idx {
+		return dst, errors.New("Invalid compressed npy data: incorrect header")
+	}
+
+	if _, err = ParseHeader(src[idx : idx+headerSize]); err != nil {
+		return dst, err
+	}
+
+	dst = append(dst, src[idx:idx+headerSize]...)
+	idx += headerSize
+	dataSize := readUvarint(1 << 62)
+
+	if err != nil || idx >= len(src) || int(src[idx]) > len(src)-idx-1 {
+		return dst, errors.New("Invalid compressed npy data: truncated data")
+	}
+
+	p, err := pipeline.NewPipeline(string(src[idx+1 : idx+1+int(src[idx])]))
+
+	if err != nil {
+		return dst, fmt.Errorf("Invalid compressed npy data: %v", err)
+	}
+
+	idx += 1 + int(src[idx])
+
+	for dataSize > 0 {
+		n := blockSize
+
+		if n > dataSize {
+			n = dataSize
+		}
+
+		payloadSize := readUvarint(uint64(len(src) - idx))
+
+		if err != nil {
+			return dst, err
+		}
+
+		start := len(dst)
+		dst = append(dst, make([]byte, n)...)
+		_, written, err := p.Inverse(src[idx:idx+payloadSize], dst[start:])
+
+		if err != nil {
+			return dst[0:start], fmt.Errorf("Invalid compressed npy data: %v", err)
+		}
+
+		if int(written) != n {
+			return dst[0:start], errors.New("Invalid compressed npy data: incorrect block size")
+		}
+
+		idx += payloadSize
+		dataSize -= n
+	}
+
+	return dst, nil
+}
+
+func appendUint32(dst []byte, val uint32) []byte {
+	return append(dst, byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+}
+
+func appendUvarint(dst []byte, val uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], val)
+	return append(dst, buf[0:n]...)
+}
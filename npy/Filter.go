@@ -0,0 +1,109 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npy
+
+import (
+	"fmt"
+
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+// Filters applied to the array data before the pipeline, as the first
+// transforms of the pipeline (see function.ShuffleCodec and
+// function.DeltaCodec). Trailing bytes (less than one element) are left
+// untouched.
+const (
+	FILTER_NONE          = 0
+	FILTER_SHUFFLE       = 1 // byte transposition
+	FILTER_DELTA_SHUFFLE = 2 // delta of the elements then byte transposition
+)
+
+// Number of elements sampled to choose the filter
+const _NPY_SAMPLE_SIZE = 65536
+
+// Return the transforms prepended to the pipeline for the filter and the
+// context setting their parameters. Both filters need elements of 2, 4 or 8
+// bytes and the delta needs little endian elements.
+func filterTransforms(filter int, size int, bigEndian bool) (string, map[string]interface{}, error) {
+	if filter == FILTER_NONE {
+		return "", nil, nil
+	}
+
+	if filter != FILTER_SHUFFLE && filter != FILTER_DELTA_SHUFFLE {
+		return "", nil, fmt.Errorf("Invalid filter: %d", filter)
+	}
+
+	if size != 2 && size != 4 && size != 8 {
+		return "", nil, fmt.Errorf("Invalid filter %d for elements of %d bytes", filter, size)
+	}
+
+	ctx := map[string]interface{}{"shuffleWidth": uint(size)}
+
+	if filter == FILTER_SHUFFLE {
+		return "SHUFFLE+", ctx, nil
+	}
+
+	if bigEndian == true {
+		return "", nil, fmt.Errorf("Invalid filter %d for big endian elements", filter)
+	}
+
+	ctx["deltaStride"] = uint(size)
+	ctx["deltaOrder"] = uint(1)
+	return "DELTA+SHUFFLE+", ctx, nil
+}
+
+// Return the order 0 entropy (sum over the byte planes, scaled by 1024)
+// of a sample of the elements after the filter
+func sampleEntropy(buf []byte, size int, filter int) int {
+	count := len(buf) / size
+
+	if count > _NPY_SAMPLE_SIZE {
+		count = _NPY_SAMPLE_SIZE
+	}
+
+	transforms, ctx, err := filterTransforms(filter, size, false)
+
+	if err != nil {
+		return 0
+	}
+
+	seq, err := function.NewChainWithCtx(&ctx, transforms[0:len(transforms)-1])
+
+	if err != nil {
+		return 0
+	}
+
+	// The sequence uses its input as a work buffer
+	sample := make([]byte, count*size)
+	copy(sample, buf)
+	planes := make([]byte, seq.MaxEncodedLen(len(sample)))
+
+	if _, _, err = seq.Forward(sample, planes); err != nil {
+		return 0
+	}
+
+	// The planes follow the header byte of the transposition
+	planes = planes[1 : 1+count*size]
+	histo := make([]int, 256)
+	res := 0
+
+	for j := 0; j < size; j++ {
+		res += entropy.ComputeFirstOrderEntropy1024(planes[j*count:(j+1)*count], histo)
+	}
+
+	return res
+}
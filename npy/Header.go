@@ -0,0 +1,220 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NPY_MAGIC is the signature of .npy files
+const NPY_MAGIC = "\x93NUMPY"
+
+// Header is the description of the array in a .npy file
+type Header struct {
+	Major        int    // format version
+	Minor        int    // format version
+	Descr        string // dtype description (EG. "<f8"), empty for structured types
+	Kind         byte   // 'b', 'i', 'u', 'f', 'c', 'S', 'U', ... (0 if unknown)
+	ElemSize     int    // size of the elements in bytes (0 if unknown)
+	BigEndian    bool
+	FortranOrder bool
+	Shape        []int
+	DataOffset   int // size of the magic and header: start of the array data
+}
+
+// Count returns the number of elements in the array
+func (this *Header) Count() int {
+	res := 1
+
+	for _, dim := range this.Shape {
+		res *= dim
+	}
+
+	return res
+}
+
+// ParseHeader parses the header at the start of a .npy file
+func ParseHeader(data []byte) (*Header, error) {
+	if len(data) < 10 || string(data[0:6]) != NPY_MAGIC {
+		return nil, errors.New("Invalid npy data: missing signature")
+	}
+
+	this := &Header{Major: int(data[6]), Minor: int(data[7])}
+	var dictLen, dictStart int
+
+	switch this.Major {
+	case 1:
+		dictLen = int(binary.LittleEndian.Uint16(data[8:10]))
+		dictStart = 10
+
+	case 2, 3:
+		if len(data) < 12 {
+			return nil, errors.New("Invalid npy data: truncated header")
+		}
+
+		dictLen = int(binary.LittleEndian.Uint32(data[8:12]))
+		dictStart = 12
+
+	default:
+		return nil, fmt.Errorf("Invalid npy data: unsupported version %d.%d", this.Major, this.Minor)
+	}
+
+	if dictLen > len(data)-dictStart {
+		return nil, errors.New("Invalid npy data: truncated header")
+	}
+
+	this.DataOffset = dictStart + dictLen
+
+	if err := this.parseDict(string(data[dictStart:this.DataOffset])); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Parse the Python dict literal, EG. "{'descr': '<f8', 'fortran_order': False, 'shape': (3, 4), }"
+func (this *Header) parseDict(dict string) error {
+	descr, found := dictValue(dict, "descr")
+
+	if found == false {
+		return errors.New("Invalid npy header: missing 'descr'")
+	}
+
+	if len(descr) >= 2 && (descr[0] == '\'' || descr[0] == '"') {
+		this.Descr = descr[1 : len(descr)-1]
+		this.parseDescr()
+	}
+
+	order, found := dictValue(dict, "fortran_order")
+
+	if found == false || (order != "True" && order != "False") {
+		return errors.New("Invalid npy header: missing 'fortran_order'")
+	}
+
+	this.FortranOrder = order == "True"
+	shape, found := dictValue(dict, "shape")
+
+	if found == false || len(shape) < 2 || shape[0] != '(' || shape[len(shape)-1] != ')' {
+		return errors.New("Invalid npy header: missing 'shape'")
+	}
+
+	this.Shape = make([]int, 0)
+
+	for _, dim := range strings.Split(shape[1:len(shape)-1], ",") {
+		if dim = strings.TrimSpace(dim); len(dim) == 0 {
+			continue
+		}
+
+		val, err := strconv.Atoi(dim)
+
+		if err != nil || val < 0 {
+			return fmt.Errorf("Invalid npy header: incorrect shape %v", shape)
+		}
+
+		this.Shape = append(this.Shape, val)
+	}
+
+	return nil
+}
+
+// Decode a simple dtype such as "<f8", "|u1" or ">i4". Structured
+// and unusual types leave Kind and ElemSize to 0.
+func (this *Header) parseDescr() {
+	descr := this.Descr
+
+	if len(descr) < 3 {
+		return
+	}
+
+	size, err := strconv.Atoi(descr[2:])
+
+	if err != nil || size <= 0 {
+		return
+	}
+
+	switch descr[0] {
+	case '<', '|', '=':
+	case '>':
+		this.BigEndian = true
+	default:
+		return
+	}
+
+	// Unicode strings are made of 4 byte characters
+	if descr[1] == 'U' {
+		this.ElemSize = 4 * size
+	} else {
+		this.ElemSize = size
+	}
+
+	this.Kind = descr[1]
+}
+
+// Return the raw value associated with the key in the dict literal
+func dictValue(dict, key string) (string, bool) {
+	idx := strings.Index(dict, "'"+key+"'")
+
+	if idx < 0 {
+		return "", false
+	}
+
+	rest := strings.TrimSpace(dict[idx+len(key)+2:])
+
+	if len(rest) == 0 || rest[0] != ':' {
+		return "", false
+	}
+
+	rest = strings.TrimSpace(rest[1:])
+
+	if len(rest) == 0 {
+		return "", false
+	}
+
+	end := 0
+
+	switch rest[0] {
+	case '\'', '"':
+		end = strings.IndexByte(rest[1:], rest[0]) + 2
+
+	case '(', '[':
+		// Nested brackets (EG. structured dtypes)
+		depth := 0
+
+		for end = 0; end < len(rest); end++ {
+			if rest[end] == '(' || rest[end] == '[' {
+				depth++
+			} else if rest[end] == ')' || rest[end] == ']' {
+				if depth--; depth == 0 {
+					end++
+					break
+				}
+			}
+		}
+
+	default:
+		end = strings.IndexAny(rest, ",}")
+	}
+
+	if end <= 0 || end > len(rest) {
+		return "", false
+	}
+
+	return strings.TrimSpace(rest[0:end]), true
+}
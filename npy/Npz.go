@@ -0,0 +1,131 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npy
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// KNPY_EXTENSION is appended to the names of the compressed arrays in
+// the zip archives produced by EncodeNpz
+const KNPY_EXTENSION = ".knpy"
+
+// NpzHeaders returns the headers of the arrays in a .npz file, by member name
+func NpzHeaders(r io.ReaderAt, size int64) (map[string]*Header, error) {
+	zr, err := zip.NewReader(r, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]*Header)
+
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".npy") == false {
+			continue
+		}
+
+		data, err := readMember(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		h, err := ParseHeader(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", f.Name, err)
+		}
+
+		res[f.Name] = h
+	}
+
+	return res, nil
+}
+
+// EncodeNpz writes a zip archive where each array of the .npz file is
+// compressed with Encode (and renamed with the KNPY_EXTENSION suffix).
+// Other members are copied unchanged. The members are stored (no zip
+// compression).
+func EncodeNpz(w io.Writer, r io.ReaderAt, size int64) error {
+	return convertNpz(w, r, size, ".npy", func(name string, data []byte) (string, []byte, error) {
+		res, err := Encode(nil, data)
+		return name + KNPY_EXTENSION, res, err
+	})
+}
+
+// DecodeNpz writes the .npz file (members stored) from the output of EncodeNpz
+func DecodeNpz(w io.Writer, r io.ReaderAt, size int64) error {
+	return convertNpz(w, r, size, KNPY_EXTENSION, func(name string, data []byte) (string, []byte, error) {
+		res, err := Decode(nil, data)
+		return strings.TrimSuffix(name, KNPY_EXTENSION), res, err
+	})
+}
+
+// Apply the conversion to the members with the suffix, copy the others
+func convertNpz(w io.Writer, r io.ReaderAt, size int64, suffix string,
+	convert func(string, []byte) (string, []byte, error)) error {
+	zr, err := zip.NewReader(r, size)
+
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, f := range zr.File {
+		data, err := readMember(f)
+
+		if err != nil {
+			return err
+		}
+
+		name := f.Name
+
+		if strings.HasSuffix(name, suffix) == true {
+			if name, data, err = convert(name, data); err != nil {
+				return fmt.Errorf("%v: %v", f.Name, err)
+			}
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: f.Modified})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = fw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func readMember(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
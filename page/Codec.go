@@ -0,0 +1,176 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package page adapts the kanzi pipelines to the page codec interface used
+// by columnar file writers (Parquet/Arrow style): each page is compressed
+// independently into a self-contained payload (see pipeline.Pipeline).
+package page
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+// ColumnHint describes the content of the column to select a pipeline
+type ColumnHint int
+
+const (
+	// COLUMN_ANY is used for columns of unknown or mixed content
+	COLUMN_ANY = ColumnHint(0)
+	// COLUMN_NUMERIC is used for fixed width values (integers, floats, dates)
+	COLUMN_NUMERIC = ColumnHint(1)
+	// COLUMN_STRING is used for variable length values (strings, JSON, ...)
+	COLUMN_STRING = ColumnHint(2)
+)
+
+// PageCodec compresses and decompresses independent pages
+type PageCodec interface {
+	// Compress appends the compressed page to dst and returns the result
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed page to dst and returns the result
+	Decompress(dst, src []byte) ([]byte, error)
+
+	// CompressBound returns the max size of a compressed page
+	CompressBound(srcLen int) int
+}
+
+// Codec is a PageCodec backed by a pool of pipelines. It is safe for
+// concurrent use.
+type Codec struct {
+	desc      *pipeline.Description
+	pipelines sync.Pool
+	bound     *pipeline.Pipeline // never used to compress, safe for concurrent use
+}
+
+// PipelineForHint returns the pipeline string used for a column hint
+func PipelineForHint(hint ColumnHint) (string, error) {
+	switch hint {
+	case COLUMN_ANY:
+		return "TEXT+ROLZ&NONE", nil
+
+	case COLUMN_NUMERIC:
+		// Fixed width values repeat at short distances (sorted or low
+		// cardinality columns): LZ matches beat BWT on such pages.
+		return "LZ&ANS0", nil
+
+	case COLUMN_STRING:
+		return "TEXT+BWT+RANK+ZRLT&ANS0", nil
+
+	default:
+		return "", fmt.Errorf("Invalid column hint: %d", hint)
+	}
+}
+
+// NewCodec creates a new Codec using the pipeline selected by the column hint
+func NewCodec(hint ColumnHint) (*Codec, error) {
+	str, err := PipelineForHint(hint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCodecWithPipeline(str)
+}
+
+// NewCodecWithPipeline creates a new Codec using the provided pipeline string
+// (EG. "BWT+RANK+ZRLT&ANS0"). The decompressing side must use the same pipeline.
+func NewCodecWithPipeline(str string) (*Codec, error) {
+	desc, err := pipeline.Parse(str)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := pipeline.NewPipelineWithCtx(desc, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Codec{desc: desc, bound: p}
+
+	this.pipelines.New = func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, nil)
+		return p
+	}
+
+	return this, nil
+}
+
+// String returns the canonical pipeline string of the codec
+func (this *Codec) String() string {
+	return this.desc.String()
+}
+
+// CompressBound returns the max size of a compressed page
+func (this *Codec) CompressBound(srcLen int) int {
+	return this.bound.MaxEncodedLen(srcLen)
+}
+
+// Compress appends the compressed page to dst and returns the result
+func (this *Codec) Compress(dst, src []byte) ([]byte, error) {
+	p := this.pipelines.Get().(*pipeline.Pipeline)
+	defer this.pipelines.Put(p)
+	start := len(dst)
+	dst = grow(dst, p.MaxEncodedLen(len(src)))
+	_, written, err := p.Forward(src, dst[start:])
+
+	if err != nil {
+		return dst[0:start], err
+	}
+
+	return dst[0 : start+int(written)], nil
+}
+
+// Decompress appends the decompressed page to dst and returns the result
+func (this *Codec) Decompress(dst, src []byte) ([]byte, error) {
+	// Empty pages compress to empty payloads
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	size, err := pipeline.DecodedLen(src)
+
+	if err != nil {
+		return dst, err
+	}
+
+	p := this.pipelines.Get().(*pipeline.Pipeline)
+	defer this.pipelines.Put(p)
+	start := len(dst)
+	dst = grow(dst, size)
+
+	if _, _, err = p.Inverse(src, dst[start:]); err != nil {
+		return dst[0:start], err
+	}
+
+	return dst, nil
+}
+
+// Return dst extended by n bytes, reallocated if the capacity is too small
+func grow(dst []byte, n int) []byte {
+	start := len(dst)
+
+	if cap(dst)-start < n {
+		buf := make([]byte, start, start+n)
+		copy(buf, dst)
+		dst = buf
+	}
+
+	return dst[0 : start+n]
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/flanglet/kanzi-go/function"
+)
+
+const (
+	_CHUNKER_MIN_BLOCK_SIZE = 1024
+	_CHUNKER_MAX_BLOCK_SIZE = 1024 * 1024 * 1024
+	_CHUNKER_MAX_OVERLAP    = function.LZ_MAX_DICTIONARY_SIZE
+)
+
+// Context entries of the pipeline replaced by the window
+var _CHUNKER_DICTIONARIES = []string{"lzDictionary", "rolzDictionary"}
+
+// Chunker feeds the data of an io.Reader through a Pipeline one block at a time
+// and emits the results to an io.Writer.
+// All blocks have the same size except the last one, regardless of the
+// size of the reads performed on the io.Reader (partial reads are
+// accumulated until a full block is available).
+// Each compressed block is prefixed by its length (varint) and a length
+// of 0 marks the end of the data.
+// With an overlap, the last 'overlap' bytes before a block are a preset
+// dictionary for the LZ and ROLZ transforms of this block, so that matches
+// can cross the block boundaries. These bytes are not encoded again. The
+// decoder must use the same pipeline, block size and overlap.
+// The dictionaries provided in the context of the pipeline ("lzDictionary",
+// "rolzDictionary") apply to the blocks without window: all the blocks
+// without overlap, the first block otherwise.
+type Chunker struct {
+	pipeline  *Pipeline
+	blockSize int
+	overlap   int
+	window    []byte
+	dicts     map[string]interface{} // dictionaries of the pipeline context
+	iBuffer   []byte
+	oBuffer   []byte
+}
+
+// NewChunker creates a new instance of Chunker using the provided pipeline
+// and block size (in bytes)
+func NewChunker(p *Pipeline, blockSize uint) (*Chunker, error) {
+	return NewChunkerWithOverlap(p, blockSize, 0)
+}
+
+// NewChunkerWithOverlap creates a new instance of Chunker using the provided
+// pipeline, block size and overlap (in bytes)
+func NewChunkerWithOverlap(p *Pipeline, blockSize, overlap uint) (*Chunker, error) {
+	if p == nil {
+		return nil, errors.New("Invalid null pipeline parameter")
+	}
+
+	if blockSize < _CHUNKER_MIN_BLOCK_SIZE || blockSize > _CHUNKER_MAX_BLOCK_SIZE {
+		return nil, fmt.Errorf("The block size must be in [%d..%d]", _CHUNKER_MIN_BLOCK_SIZE, _CHUNKER_MAX_BLOCK_SIZE)
+	}
+
+	if overlap > _CHUNKER_MAX_OVERLAP {
+		return nil, fmt.Errorf("The overlap must be in [0..%d]", _CHUNKER_MAX_OVERLAP)
+	}
+
+	this := new(Chunker)
+	this.pipeline = p
+	this.blockSize = int(blockSize)
+	this.overlap = int(overlap)
+	this.window = make([]byte, 0)
+	this.dicts = make(map[string]interface{})
+
+	for _, key := range _CHUNKER_DICTIONARIES {
+		if val, containsKey := p.ctx[key]; containsKey {
+			this.dicts[key] = val
+		}
+	}
+
+	this.iBuffer = make([]byte, 0)
+	this.oBuffer = make([]byte, 0)
+	return this, nil
+}
+
+// BlockSize returns the size of the blocks
+func (this *Chunker) BlockSize() uint {
+	return uint(this.blockSize)
+}
+
+// Overlap returns the size of the preset dictionary of each block
+func (this *Chunker) Overlap() uint {
+	return uint(this.overlap)
+}
+
+// Forward reads src until EOF, applies the pipeline to each block and
+// writes the framed results to dst. Returns the number of bytes read,
+// the number of bytes written and possibly an error.
+func (this *Chunker) Forward(dst io.Writer, src io.Reader) (int64, int64, error) {
+	if len(this.iBuffer) < this.blockSize {
+		this.iBuffer = make([]byte, this.blockSize)
+	}
+
+	maxLen := this.pipeline.MaxEncodedLen(this.blockSize)
+
+	if len(this.oBuffer) < maxLen {
+		this.oBuffer = make([]byte, maxLen)
+	}
+
+	this.reset()
+	defer this.reset()
+	read := int64(0)
+	written := int64(0)
+	var header [binary.MaxVarintLen64]byte
+
+	for {
+		n, err := io.ReadFull(src, this.iBuffer[0:this.blockSize])
+		read += int64(n)
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return read, written, err
+		}
+
+		if n > 0 {
+			this.prepare()
+			_, length, err2 := this.pipeline.Forward(this.iBuffer[0:n], this.oBuffer)
+
+			if err2 != nil {
+				return read, written, err2
+			}
+
+			this.update(this.iBuffer[0:n])
+
+			if err2 = writeFull(dst, header[0:binary.PutUvarint(header[:], uint64(length))], &written); err2 != nil {
+				return read, written, err2
+			}
+
+			if err2 = writeFull(dst, this.oBuffer[0:length], &written); err2 != nil {
+				return read, written, err2
+			}
+		}
+
+		// A short read means that the end of the data has been reached
+		if err != nil {
+			break
+		}
+	}
+
+	// End marker
+	err := writeFull(dst, header[0:binary.PutUvarint(header[:], 0)], &written)
+	return read, written, err
+}
+
+// Inverse reads framed blocks from src until the end marker, applies
+// the inverse pipeline to each block and writes the results to dst.
+// No byte past the end marker is read from src.
+// Returns the number of bytes read, the number of bytes written and
+// possibly an error.
+func (this *Chunker) Inverse(dst io.Writer, src io.Reader) (int64, int64, error) {
+	if len(this.oBuffer) < this.blockSize {
+		this.oBuffer = make([]byte, this.blockSize)
+	}
+
+	this.reset()
+	defer this.reset()
+	maxLen := this.pipeline.MaxEncodedLen(this.blockSize)
+	br := &countingByteReader{r: src}
+	written := int64(0)
+
+	for {
+		val, err := binary.ReadUvarint(br)
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.New("Invalid data: missing end of data marker")
+			}
+
+			return br.read, written, err
+		}
+
+		if val == 0 {
+			break
+		}
+
+		if val > uint64(maxLen) {
+			return br.read, written, fmt.Errorf("Invalid data: incorrect block length %d", val)
+		}
+
+		length := int(val)
+
+		if len(this.iBuffer) < length {
+			this.iBuffer = make([]byte, length)
+		}
+
+		n, err := io.ReadFull(src, this.iBuffer[0:length])
+		br.read += int64(n)
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.New("Invalid data: truncated block")
+			}
+
+			return br.read, written, err
+		}
+
+		// Check the size before decoding to the block buffer
+		decLen, err := DecodedLen(this.iBuffer[0:length])
+
+		if err != nil {
+			return br.read, written, err
+		}
+
+		if decLen > this.blockSize {
+			return br.read, written, fmt.Errorf("Invalid data: block larger than block size %d", this.blockSize)
+		}
+
+		this.prepare()
+		_, decoded, err := this.pipeline.Inverse(this.iBuffer[0:length], this.oBuffer)
+
+		if err != nil {
+			return br.read, written, err
+		}
+
+		this.update(this.oBuffer[0:decoded])
+
+		if err = writeFull(dst, this.oBuffer[0:decoded], &written); err != nil {
+			return br.read, written, err
+		}
+	}
+
+	return br.read, written, nil
+}
+
+// Drop the window and restore the dictionaries of the pipeline
+func (this *Chunker) reset() {
+	this.window = this.window[0:0]
+
+	for _, key := range _CHUNKER_DICTIONARIES {
+		if val, containsKey := this.dicts[key]; containsKey {
+			this.pipeline.ctx[key] = val
+		} else {
+			delete(this.pipeline.ctx, key)
+		}
+	}
+}
+
+// Provide the window to the transforms of the next block
+func (this *Chunker) prepare() {
+	if len(this.window) > 0 {
+		for _, key := range _CHUNKER_DICTIONARIES {
+			this.pipeline.ctx[key] = this.window
+		}
+	}
+}
+
+// Append the original bytes of a block to the window
+func (this *Chunker) update(block []byte) {
+	if this.overlap == 0 {
+		return
+	}
+
+	if len(block) >= this.overlap {
+		block = block[len(block)-this.overlap:]
+	}
+
+	keep := len(this.window)
+
+	if keep+len(block) > this.overlap {
+		keep = this.overlap - len(block)
+	}
+
+	// The previous window may still be referenced by a transform: build a new one
+	res := make([]byte, keep+len(block))
+	copy(res, this.window[len(this.window)-keep:])
+	copy(res[keep:], block)
+	this.window = res
+}
+
+func writeFull(w io.Writer, buf []byte, written *int64) error {
+	n, err := w.Write(buf)
+	*written += int64(n)
+
+	if err == nil && n != len(buf) {
+		err = io.ErrShortWrite
+	}
+
+	return err
+}
+
+// countingByteReader reads the block lengths one byte at a time, so that
+// nothing is read past the end marker
+type countingByteReader struct {
+	r    io.Reader
+	read int64
+	buf  [1]byte
+}
+
+func (this *countingByteReader) ReadByte() (byte, error) {
+	n, err := io.ReadFull(this.r, this.buf[:])
+	this.read += int64(n)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return this.buf[0], nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+const (
+	// MAX_TRANSFORMS is the maximum number of transforms in a pipeline
+	MAX_TRANSFORMS = function.MAX_TRANSFORMS
+
+	_ENTROPY_SEPARATOR   = "&"
+	_TRANSFORM_SEPARATOR = "+"
+)
+
+// Description is the typed form of a pipeline string such as
+// "TEXT+BWT+RANK+ZRLT&ANS0": a sequence of transforms followed by
+// an entropy codec. Names are upper case and NONE transforms are removed.
+type Description struct {
+	Transforms []string
+	Entropy    string
+}
+
+// Parse turns a pipeline string into a Description. The string has the form
+// "transform[+transform]*[&entropy]". A missing entropy part defaults to NONE.
+// Transform and entropy names are validated against the known codecs.
+func Parse(str string) (*Description, error) {
+	str = strings.TrimSpace(str)
+
+	if len(str) == 0 {
+		return nil, errors.New("Invalid empty pipeline")
+	}
+
+	tokens := strings.Split(str, _ENTROPY_SEPARATOR)
+
+	if len(tokens) > 2 {
+		return nil, fmt.Errorf("Invalid pipeline '%v': only one entropy codec allowed", str)
+	}
+
+	this := &Description{Transforms: make([]string, 0), Entropy: "NONE"}
+
+	if len(tokens) == 2 {
+		name := strings.ToUpper(strings.TrimSpace(tokens[1]))
+
+		if len(name) > 0 {
+			if err := checkEntropy(name); err != nil {
+				return nil, err
+			}
+
+			this.Entropy = name
+		}
+	}
+
+	for _, t := range strings.Split(tokens[0], _TRANSFORM_SEPARATOR) {
+		name := strings.ToUpper(strings.TrimSpace(t))
+
+		// Skip empty tokens (EG. leading or trailing '+') and null transforms
+		if len(name) == 0 || name == "NONE" {
+			continue
+		}
+
+		if err := checkTransform(name); err != nil {
+			return nil, err
+		}
+
+		this.Transforms = append(this.Transforms, name)
+	}
+
+	if len(this.Transforms) > MAX_TRANSFORMS {
+		return nil, fmt.Errorf("Invalid pipeline '%v': only %d transforms allowed", str, MAX_TRANSFORMS)
+	}
+
+	return this, nil
+}
+
+// ForLevel returns the Description of the pipeline associated with
+// a compression level in [0..8]
+func ForLevel(level int) (*Description, error) {
+	switch level {
+	case 0:
+		return Parse("NONE&NONE")
+
+	case 1:
+		return Parse("TEXT+LZ&HUFFMAN")
+
+	case 2:
+		return Parse("TEXT+ROLZ&NONE")
+
+	case 3:
+		return Parse("TEXT+ROLZX&NONE")
+
+	case 4:
+		return Parse("TEXT+BWT+RANK+ZRLT&ANS0")
+
+	case 5:
+		return Parse("TEXT+BWT+SRT+ZRLT&FPAQ")
+
+	case 6:
+		return Parse("LZP+TEXT+BWT&CM")
+
+	case 7:
+		return Parse("X86+RLT+TEXT&TPAQ")
+
+	case 8:
+		return Parse("X86+RLT+TEXT&TPAQX")
+
+	default:
+		return nil, fmt.Errorf("Invalid compression level: %d (must be in [0..8])", level)
+	}
+}
+
+// TransformName returns the canonical transform part of the pipeline (EG. "BWT+RANK+ZRLT")
+func (this *Description) TransformName() string {
+	if len(this.Transforms) == 0 {
+		return "NONE"
+	}
+
+	return strings.Join(this.Transforms, _TRANSFORM_SEPARATOR)
+}
+
+// TransformType returns the transform type as written to the bitstream header
+func (this *Description) TransformType() uint64 {
+	return function.GetType(this.TransformName())
+}
+
+// EntropyType returns the entropy codec type as written to the bitstream header
+func (this *Description) EntropyType() uint32 {
+	return entropy.GetType(this.Entropy)
+}
+
+// String returns the canonical pipeline string. Parsing the result yields
+// an identical Description.
+func (this *Description) String() string {
+	return this.TransformName() + _ENTROPY_SEPARATOR + this.Entropy
+}
+
+func checkTransform(name string) error {
+	if _, err := function.ParseType(name); err != nil {
+		return fmt.Errorf("Unknown transform: '%v'", name)
+	}
+
+	return nil
+}
+
+func checkEntropy(name string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Unknown entropy codec: '%v'", name)
+		}
+	}()
+
+	entropy.GetType(name)
+	return nil
+}
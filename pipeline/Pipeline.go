@@ -0,0 +1,357 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+const (
+	_PIPELINE_HEADER_SIZE = 10 // mode + skip flags + original length + transformed length
+	_PIPELINE_MAX_LENGTH  = 1024 * 1024 * 1024
+	_PIPELINE_SMALL_BLOCK = 15
+	_PIPELINE_COPY_MASK   = 0x80
+	_PIPELINE_EXTRA_SIZE  = 256
+)
+
+// Pipeline applies a sequence of transforms followed by an entropy codec
+// to byte slices, independently of the stream container format.
+// The output of Forward is a self-contained payload: mode (8 bits),
+// skip flags (8 bits), original length (32 bits), transformed length (32 bits)
+// then the entropy coded data. As in the stream format, very small inputs
+// are copied (mode 0x80) instead of being transformed and entropy coded.
+// A Pipeline can be reused for many Forward/Inverse calls but is not safe
+// for concurrent use.
+type Pipeline struct {
+	desc          *Description
+	transformType uint64
+	entropyType   uint32
+	ctx           map[string]interface{}
+	buffer        []byte
+	output        []byte
+	codecs        entropy.CodecPool
+}
+
+// NewPipeline creates a new instance of Pipeline from a pipeline string
+// such as "TEXT+BWT+RANK+ZRLT&ANS0"
+func NewPipeline(str string) (*Pipeline, error) {
+	desc, err := Parse(str)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPipelineWithCtx(desc, nil)
+}
+
+// NewPipelineWithCtx creates a new instance of Pipeline from a Description
+// and a map of parameters passed to the transforms and entropy codecs
+// (EG. "jobs"). The map is copied.
+func NewPipelineWithCtx(desc *Description, ctx map[string]interface{}) (*Pipeline, error) {
+	if desc == nil {
+		return nil, errors.New("Invalid null pipeline description parameter")
+	}
+
+	if len(desc.Transforms) > MAX_TRANSFORMS {
+		return nil, fmt.Errorf("Only %d transforms allowed", MAX_TRANSFORMS)
+	}
+
+	// Validate names
+	if _, err := Parse(desc.String()); err != nil {
+		return nil, err
+	}
+
+	this := new(Pipeline)
+	this.desc = desc
+	this.transformType = desc.TransformType()
+	this.entropyType = desc.EntropyType()
+	this.ctx = make(map[string]interface{})
+
+	for k, v := range ctx {
+		this.ctx[k] = v
+	}
+
+	if _, containsKey := this.ctx["jobs"]; containsKey == false {
+		this.ctx["jobs"] = uint(1)
+	}
+
+	this.ctx["codec"] = desc.Entropy
+	this.ctx["transform"] = desc.TransformName()
+	this.ctx["extra"] = desc.Entropy == "TPAQX"
+	this.buffer = make([]byte, 0)
+	this.output = make([]byte, 0)
+	return this, nil
+}
+
+// Description returns the description of this pipeline
+func (this *Pipeline) Description() *Description {
+	return this.desc
+}
+
+// String returns the canonical pipeline string
+func (this *Pipeline) String() string {
+	return this.desc.String()
+}
+
+// Forward applies the transforms then the entropy codec to src and writes
+// the result to dst. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *Pipeline) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if len(src) > _PIPELINE_MAX_LENGTH {
+		return 0, 0, fmt.Errorf("Input is too large - size: %d, max %d", len(src), _PIPELINE_MAX_LENGTH)
+	}
+
+	transformType := this.transformType
+	entropyType := this.entropyType
+	mode := byte(0)
+
+	if len(src) <= _PIPELINE_SMALL_BLOCK {
+		transformType = function.NONE_TYPE
+		entropyType = entropy.NONE_TYPE
+		mode |= _PIPELINE_COPY_MASK
+	}
+
+	this.ctx["blockSize"] = uint(len(src))
+	this.ctx["size"] = uint(len(src))
+	t, err := function.NewByteFunction(&this.ctx, transformType)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	requiredSize := t.MaxEncodedLen(len(src))
+
+	if len(this.buffer) < requiredSize {
+		this.buffer = make([]byte, requiredSize)
+	}
+
+	// The transform sequence uses its input as a work buffer: never pass src
+	if len(this.output) < requiredSize {
+		this.output = make([]byte, requiredSize)
+	}
+
+	copy(this.output, src)
+
+	// Transforms that fail are skipped (see skip flags)
+	_, length, _ := t.Forward(this.output[0:len(src)], this.buffer)
+	this.ctx["size"] = length
+
+	// The bitstream writes to dst directly (up to its capacity)
+	bs := util.NewBufferStream(dst[0:0:len(dst)])
+	obs, err := bitstream.NewDefaultOutputBitStream(bs, 16384)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = this.encode(obs, mode, t, entropyType, uint(len(src)), length); err != nil {
+		return 0, 0, err
+	}
+
+	written := uint((obs.Written() + 7) >> 3)
+
+	if written > uint(len(dst)) {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), written)
+	}
+
+	return uint(len(src)), written, nil
+}
+
+func (this *Pipeline) encode(obs *bitstream.DefaultOutputBitStream, mode byte, t *function.ByteTransformSequence,
+	entropyType uint32, srcLen, length uint) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Entropy encoding failed: %v", r)
+		}
+	}()
+
+	obs.WriteBits(uint64(mode), 8)
+	obs.WriteBits(uint64(t.SkipFlags()), 8)
+	obs.WriteBits(uint64(srcLen), 32)
+	obs.WriteBits(uint64(length), 32)
+	ee, err := this.codecs.NewEntropyEncoder(obs, this.ctx, entropyType)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = ee.Write(this.buffer[0:length]); err != nil {
+		return err
+	}
+
+	// Dispose may write to the bitstream
+	ee.Dispose()
+	_, err = obs.Close()
+	return err
+}
+
+// Inverse applies the inverse entropy codec then the inverse transforms to src
+// and writes the result to dst. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *Pipeline) Inverse(src, dst []byte) (read uint, written uint, err error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if len(src) < _PIPELINE_HEADER_SIZE {
+		return 0, 0, errors.New("Invalid pipeline data: truncated header")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			read, written, err = 0, 0, fmt.Errorf("Invalid pipeline data: %v", r)
+		}
+	}()
+
+	ibs, err := bitstream.NewDefaultInputBitStream(util.NewBufferStream(src), 16384)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	mode := byte(ibs.ReadBits(8))
+	skipFlags := byte(ibs.ReadBits(8))
+	srcLen := uint(ibs.ReadBits(32))
+	length := uint(ibs.ReadBits(32))
+
+	if srcLen > _PIPELINE_MAX_LENGTH || length > _PIPELINE_MAX_LENGTH {
+		return 0, 0, fmt.Errorf("Invalid pipeline data: incorrect block length %d", length)
+	}
+
+	if uint(len(dst)) < srcLen {
+		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), srcLen)
+	}
+
+	transformType := this.transformType
+	entropyType := this.entropyType
+
+	if mode&_PIPELINE_COPY_MASK != 0 {
+		transformType = function.NONE_TYPE
+		entropyType = entropy.NONE_TYPE
+	}
+
+	if uint(len(this.buffer)) < length {
+		this.buffer = make([]byte, length)
+	}
+
+	this.ctx["blockSize"] = srcLen
+	this.ctx["size"] = length
+	ed, err := this.codecs.NewEntropyDecoder(ibs, this.ctx, entropyType)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer ed.Dispose()
+
+	if _, err = ed.Read(this.buffer[0:length]); err != nil {
+		return 0, 0, err
+	}
+
+	read = uint((ibs.Read() + 7) >> 3)
+	t, err := function.NewByteFunction(&this.ctx, transformType)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	t.SetSkipFlags(skipFlags)
+
+	// Intermediate results of the inverse transforms can be larger
+	// than the original data (up to the size required by Forward, EG.
+	// SRT adds a header). Decode to a padded buffer.
+	bufferSize := uint(t.MaxEncodedLen(int(srcLen)))
+
+	if bufferSize < length {
+		bufferSize = length
+	}
+
+	if bufferSize>>4 > _PIPELINE_EXTRA_SIZE {
+		bufferSize += bufferSize >> 4
+	} else {
+		bufferSize += _PIPELINE_EXTRA_SIZE
+	}
+
+	if uint(len(this.output)) < bufferSize {
+		this.output = make([]byte, bufferSize)
+	}
+
+	if _, written, err = t.Inverse(this.buffer[0:length], this.output); err != nil {
+		return 0, 0, err
+	}
+
+	if written != srcLen {
+		return 0, 0, fmt.Errorf("Invalid pipeline data: expected %d bytes, got %d", srcLen, written)
+	}
+
+	copy(dst, this.output[0:written])
+	return read, written, nil
+}
+
+// DecodedLen returns the size of the data that Inverse produces from the
+// provided payload (read from the payload header)
+func DecodedLen(src []byte) (int, error) {
+	if len(src) < _PIPELINE_HEADER_SIZE {
+		return 0, errors.New("Invalid pipeline data: truncated header")
+	}
+
+	// Header: mode (8 bits), skip flags (8 bits) then original length (32 bits)
+	srcLen := int(binary.BigEndian.Uint32(src[2:6]))
+
+	if srcLen > _PIPELINE_MAX_LENGTH {
+		return 0, fmt.Errorf("Invalid pipeline data: incorrect block length %d", srcLen)
+	}
+
+	return srcLen, nil
+}
+
+// MaxEncodedLen returns the max size required for the Forward output buffer
+func (this *Pipeline) MaxEncodedLen(srcLen int) int {
+	ctx := map[string]interface{}{"size": uint(srcLen), "blockSize": uint(srcLen)}
+
+	for k, v := range this.ctx {
+		if _, containsKey := ctx[k]; containsKey == false {
+			ctx[k] = v
+		}
+	}
+
+	t, err := function.NewByteFunction(&ctx, this.transformType)
+	length := srcLen
+
+	if err == nil {
+		length = t.MaxEncodedLen(srcLen)
+	}
+
+	// Add padding for incompressible data
+	if length >= 1024<<6 {
+		length += (length >> 6)
+	} else {
+		length += 1024
+	}
+
+	return length + _PIPELINE_HEADER_SIZE
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_TUNE_SAMPLES is the default number of sampled sub-blocks
+	DEFAULT_TUNE_SAMPLES = 4
+
+	// DEFAULT_TUNE_SAMPLE_SIZE is the default size in bytes of a sampled sub-block
+	DEFAULT_TUNE_SAMPLE_SIZE = 64 * 1024
+)
+
+// TUNE_CANDIDATES lists the default candidates of the auto-tuner: the LZ
+// variants (from the fastest to the strongest parse), then the BWT based
+// pipelines with each post-BWT stage and entropy codec.
+var TUNE_CANDIDATES = []string{
+	"TEXT+LZ&HUFFMAN",
+	"TEXT+ROLZ&NONE",
+	"TEXT+ROLZX&NONE",
+	"TEXT+BWT+RANK+ZRLT&ANS0",
+	"TEXT+BWT+MTFT+ZRLT&ANS0",
+	"TEXT+BWT+SRT+ZRLT&ANS0",
+	"TEXT+BWT+RANK+ZRLT&FPAQ",
+	"TEXT+BWT+SRT+ZRLT&FPAQ",
+	"LZP+TEXT+BWT&CM",
+}
+
+// TuneConfig holds the parameters of the auto-tuner
+type TuneConfig struct {
+	Candidates []string // pipeline strings, defaults to TUNE_CANDIDATES
+	Samples    int      // number of sampled sub-blocks, defaults to DEFAULT_TUNE_SAMPLES
+	SampleSize int      // size of a sampled sub-block, defaults to DEFAULT_TUNE_SAMPLE_SIZE
+	// SpeedWeight trades ratio for speed: the cost of a candidate is
+	// ratio * (time / fastest time) ^ SpeedWeight. 0 selects on ratio only.
+	SpeedWeight float64
+}
+
+// TuneResult holds the measurements of one candidate on the samples
+type TuneResult struct {
+	Pipeline   string // canonical pipeline string
+	InputSize  int
+	OutputSize int
+	Time       time.Duration
+	Cost       float64
+	Err        error
+}
+
+// Tune compresses a few sub-blocks sampled evenly in data with each candidate
+// and returns the Description of the candidate with the lowest cost, along
+// with the measurements of all candidates. Candidates that fail are skipped.
+func Tune(data []byte, cfg TuneConfig) (*Description, []TuneResult, error) {
+	if len(data) == 0 {
+		return nil, nil, errors.New("Cannot tune pipeline on empty data")
+	}
+
+	candidates := cfg.Candidates
+
+	if len(candidates) == 0 {
+		candidates = TUNE_CANDIDATES
+	}
+
+	samples := sampleBlocks(data, cfg.Samples, cfg.SampleSize)
+	results := make([]TuneResult, len(candidates))
+	descs := make([]*Description, len(candidates))
+	fastest := time.Duration(0)
+
+	for i, c := range candidates {
+		results[i] = TuneResult{Pipeline: strings.TrimSpace(c)}
+
+		if descs[i], results[i].Err = Parse(c); results[i].Err != nil {
+			continue
+		}
+
+		results[i].Pipeline = descs[i].String()
+		results[i].InputSize, results[i].OutputSize, results[i].Time, results[i].Err = measure(descs[i], samples)
+
+		if results[i].Err == nil && (fastest == 0 || results[i].Time < fastest) {
+			fastest = results[i].Time
+		}
+	}
+
+	best := -1
+
+	for i := range results {
+		r := &results[i]
+
+		if r.Err != nil {
+			continue
+		}
+
+		r.Cost = float64(r.OutputSize) / float64(r.InputSize)
+
+		if cfg.SpeedWeight > 0 && fastest > 0 {
+			r.Cost *= math.Pow(float64(r.Time)/float64(fastest), cfg.SpeedWeight)
+		}
+
+		if best < 0 || r.Cost < results[best].Cost {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return nil, results, errors.New("Cannot tune pipeline: all candidates failed")
+	}
+
+	return descs[best], results, nil
+}
+
+// sampleBlocks returns 'n' sub-blocks of 'size' bytes evenly spread in data
+// (or data itself if it is too small)
+func sampleBlocks(data []byte, n, size int) [][]byte {
+	if n <= 0 {
+		n = DEFAULT_TUNE_SAMPLES
+	}
+
+	if size <= 0 {
+		size = DEFAULT_TUNE_SAMPLE_SIZE
+	}
+
+	if n*size >= len(data) {
+		return [][]byte{data}
+	}
+
+	res := make([][]byte, n)
+	step := 0
+
+	if n > 1 {
+		step = (len(data) - size) / (n - 1)
+	}
+
+	for i := range res {
+		offset := i * step
+		res[i] = data[offset : offset+size]
+	}
+
+	return res
+}
+
+func measure(desc *Description, samples [][]byte) (input, output int, elapsed time.Duration, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Pipeline %v failed: %v", desc, r)
+		}
+	}()
+
+	p, err := NewPipelineWithCtx(desc, nil)
+
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var dst []byte
+	start := time.Now()
+
+	for _, s := range samples {
+		if maxLen := p.MaxEncodedLen(len(s)); len(dst) < maxLen {
+			dst = make([]byte, maxLen)
+		}
+
+		_, written, err := p.Forward(s, dst)
+
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		input += len(s)
+		output += int(written)
+	}
+
+	return input, output, time.Now().Sub(start), nil
+}
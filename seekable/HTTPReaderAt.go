@@ -0,0 +1,158 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seekable
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPReaderAt is an io.ReaderAt over a remote file (EG. in an object
+// store) reading the data with HTTP Range requests
+type HTTPReaderAt struct {
+	client *http.Client
+	url    string
+	size   int64
+}
+
+// NewHTTPReaderAt creates a new HTTPReaderAt for the URL. The server must
+// support Range requests. The size of the file is read from the first
+// response. A nil client means http.DefaultClient.
+func NewHTTPReaderAt(client *http.Client, url string) (*HTTPReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	this := &HTTPReaderAt{client: client, url: url, size: -1}
+	resp, err := this.get(0, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if this.size, err = parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// OpenURL returns a Reader over the seekable archive at the URL using
+// http.DefaultClient and keeping up to 'cacheBlocks' decoded blocks in memory
+func OpenURL(url string, cacheBlocks int) (*Reader, error) {
+	r, err := NewHTTPReaderAt(nil, url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReaderWithCache(r, r.Size(), cacheBlocks)
+}
+
+// Size returns the size of the remote file
+func (this *HTTPReaderAt) Size() int64 {
+	return this.size
+}
+
+// ReadAt reads len(p) bytes starting at offset 'off' with one Range request
+// (see io.ReaderAt)
+func (this *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Invalid negative offset: %d", off)
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if off >= this.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	var eof error
+
+	if end > this.size {
+		end = this.size
+		eof = io.EOF
+	}
+
+	resp, err := this.get(off, end-1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p[0:end-off])
+
+	if err != nil {
+		return n, fmt.Errorf("Failed to read %v: %v", this.url, err)
+	}
+
+	return n, eof
+}
+
+// Send a request for the bytes in [from..to] and check for a partial content response
+func (this *HTTPReaderAt) get(from, to int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, this.url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	resp, err := this.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("Failed to read %v: the server does not support Range requests", this.url)
+		}
+
+		return nil, fmt.Errorf("Failed to read %v: %v", this.url, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// Return the total size in a Content-Range header (EG. "bytes 0-0/1234")
+func parseContentRange(header string) (int64, error) {
+	idx := strings.LastIndexByte(header, '/')
+
+	if strings.HasPrefix(header, "bytes ") == false || idx < 0 {
+		return 0, fmt.Errorf("Invalid Content-Range header: '%v'", header)
+	}
+
+	size, err := strconv.ParseInt(header[idx+1:], 10, 64)
+
+	if err != nil || size < 0 {
+		return 0, errors.New("Invalid Content-Range header: unknown size")
+	}
+
+	return size, nil
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seekable
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+	"github.com/flanglet/kanzi-go/util/hash"
+)
+
+const (
+	// DEFAULT_CACHE_BLOCKS is the number of decoded blocks kept in memory
+	DEFAULT_CACHE_BLOCKS = 16
+
+	// Size of the first read at the end of the data: small indexes are
+	// fetched with the footer in one read (one request for remote data)
+	_SEEKABLE_TAIL_SIZE = 64 * 1024
+)
+
+// Reader provides random access to the data of a seekable archive.
+// Only the blocks overlapping the requested ranges are read and decoded
+// and the most recently used decoded blocks are cached.
+// A Reader is safe for concurrent use.
+type Reader struct {
+	r         io.ReaderAt
+	desc      *pipeline.Description
+	blockSize int
+	blocks    []blockEntry
+	size      int64
+	pipelines sync.Pool
+	cache     *blockCache
+}
+
+// NewReader creates a new Reader over a seekable archive of 'size' bytes
+// using DEFAULT_CACHE_BLOCKS cached blocks
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	return NewReaderWithCache(r, size, DEFAULT_CACHE_BLOCKS)
+}
+
+// NewReaderWithCache creates a new Reader over a seekable archive of 'size'
+// bytes keeping up to 'cacheBlocks' decoded blocks in memory (0 disables
+// the cache). The index is read and validated.
+func NewReaderWithCache(r io.ReaderAt, size int64, cacheBlocks int) (*Reader, error) {
+	if r == nil {
+		return nil, errors.New("Invalid null reader parameter")
+	}
+
+	if cacheBlocks < 0 {
+		return nil, fmt.Errorf("Invalid number of cached blocks: %d", cacheBlocks)
+	}
+
+	if size < _SEEKABLE_HEADER_SIZE+_SEEKABLE_FOOTER_SIZE {
+		return nil, errors.New("Invalid seekable archive: truncated data")
+	}
+
+	// Read the header and the tail (footer plus, hopefully, index)
+	var header [_SEEKABLE_HEADER_SIZE]byte
+
+	if err := readAt(r, header[:], 0); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) != SEEKABLE_MAGIC {
+		return nil, errors.New("Invalid seekable archive: incorrect header")
+	}
+
+	if header[4] != SEEKABLE_VERSION {
+		return nil, fmt.Errorf("Invalid seekable archive: unsupported version %d", header[4])
+	}
+
+	tailSize := size - _SEEKABLE_HEADER_SIZE
+
+	if tailSize > _SEEKABLE_TAIL_SIZE {
+		tailSize = _SEEKABLE_TAIL_SIZE
+	}
+
+	tail := make([]byte, tailSize)
+
+	if err := readAt(r, tail, size-tailSize); err != nil {
+		return nil, err
+	}
+
+	footer := tail[len(tail)-_SEEKABLE_FOOTER_SIZE:]
+
+	if binary.BigEndian.Uint32(footer[12:16]) != SEEKABLE_MAGIC {
+		return nil, errors.New("Invalid seekable archive: missing footer")
+	}
+
+	indexSize := int64(binary.BigEndian.Uint32(footer[0:4]))
+
+	if indexSize > size-_SEEKABLE_HEADER_SIZE-_SEEKABLE_FOOTER_SIZE {
+		return nil, fmt.Errorf("Invalid seekable archive: incorrect index size %d", indexSize)
+	}
+
+	var index []byte
+
+	if indexSize <= tailSize-_SEEKABLE_FOOTER_SIZE {
+		index = tail[tailSize-_SEEKABLE_FOOTER_SIZE-indexSize : tailSize-_SEEKABLE_FOOTER_SIZE]
+	} else {
+		index = make([]byte, indexSize)
+
+		if err := readAt(r, index, size-_SEEKABLE_FOOTER_SIZE-indexSize); err != nil {
+			return nil, err
+		}
+	}
+
+	hasher, _ := hash.NewXXHash32(_SEEKABLE_HASH_SEED)
+
+	if hasher.Hash(index) != binary.BigEndian.Uint32(footer[4:8]) {
+		return nil, errors.New("Invalid seekable archive: corrupted index")
+	}
+
+	this := &Reader{r: r}
+
+	if err := this.readIndex(index, size-_SEEKABLE_FOOTER_SIZE-indexSize); err != nil {
+		return nil, err
+	}
+
+	desc := this.desc
+
+	this.pipelines.New = func() interface{} {
+		p, _ := pipeline.NewPipelineWithCtx(desc, nil)
+		return p
+	}
+
+	if cacheBlocks > 0 {
+		this.cache = newBlockCache(cacheBlocks)
+	}
+
+	return this, nil
+}
+
+// Parse the index and check that the blocks fill the data up to 'end'
+func (this *Reader) readIndex(index []byte, end int64) error {
+	if len(index) < 1 || len(index) < 9+int(index[0]) {
+		return errors.New("Invalid seekable archive: truncated index")
+	}
+
+	nameLen := int(index[0])
+	desc, err := pipeline.Parse(string(index[1 : 1+nameLen]))
+
+	if err != nil {
+		return fmt.Errorf("Invalid seekable archive: %v", err)
+	}
+
+	if _, err = pipeline.NewPipelineWithCtx(desc, nil); err != nil {
+		return fmt.Errorf("Invalid seekable archive: %v", err)
+	}
+
+	idx := 1 + nameLen
+	blockSize := int(binary.BigEndian.Uint32(index[idx:]))
+	count := int(binary.BigEndian.Uint32(index[idx+4:]))
+	idx += 8
+
+	if blockSize < _SEEKABLE_MIN_BLOCK_SIZE || blockSize > _SEEKABLE_MAX_BLOCK_SIZE {
+		return fmt.Errorf("Invalid seekable archive: incorrect block size %d", blockSize)
+	}
+
+	if count != (len(index)-idx)/_SEEKABLE_ENTRY_SIZE || (len(index)-idx)%_SEEKABLE_ENTRY_SIZE != 0 {
+		return fmt.Errorf("Invalid seekable archive: incorrect block count %d", count)
+	}
+
+	this.desc = desc
+	this.blockSize = blockSize
+	this.blocks = make([]blockEntry, count)
+	offset := int64(_SEEKABLE_HEADER_SIZE)
+	start := int64(0)
+
+	for i := range this.blocks {
+		b := &this.blocks[i]
+		b.offset = offset
+		b.start = start
+		b.csize = int(binary.BigEndian.Uint32(index[idx:]))
+		b.dsize = int(binary.BigEndian.Uint32(index[idx+4:]))
+		b.checksum = binary.BigEndian.Uint32(index[idx+8:])
+		idx += _SEEKABLE_ENTRY_SIZE
+
+		// All blocks but the last one are full
+		if b.dsize > blockSize || (b.dsize != blockSize && i != count-1) || b.dsize == 0 {
+			return fmt.Errorf("Invalid seekable archive: incorrect size for block %d", i)
+		}
+
+		offset += int64(b.csize)
+		start += int64(b.dsize)
+	}
+
+	if offset != end {
+		return errors.New("Invalid seekable archive: index does not match the data")
+	}
+
+	this.size = start
+	return nil
+}
+
+// Size returns the size of the decoded data
+func (this *Reader) Size() int64 {
+	return this.size
+}
+
+// BlockSize returns the size of the blocks
+func (this *Reader) BlockSize() int {
+	return this.blockSize
+}
+
+// BlockCount returns the number of blocks
+func (this *Reader) BlockCount() int {
+	return len(this.blocks)
+}
+
+// Pipeline returns the canonical pipeline string used to compress the blocks
+func (this *Reader) Pipeline() string {
+	return this.desc.String()
+}
+
+// ReadAt reads len(p) bytes of decoded data starting at offset 'off'
+// (see io.ReaderAt)
+func (this *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Invalid negative offset: %d", off)
+	}
+
+	if off >= this.size {
+		if len(p) == 0 {
+			return 0, nil
+		}
+
+		return 0, io.EOF
+	}
+
+	length := int64(len(p))
+	var err error
+
+	if off+length > this.size {
+		length = this.size - off
+		err = io.EOF
+	}
+
+	res, err2 := this.DecodeRange(p[0:0], off, length)
+
+	if err2 != nil {
+		return len(res), err2
+	}
+
+	return len(res), err
+}
+
+// DecodeRange appends 'length' bytes of decoded data starting at offset 'off'
+// to dst and returns the result. The range must be within the decoded data.
+func (this *Reader) DecodeRange(dst []byte, off, length int64) ([]byte, error) {
+	if off < 0 || length < 0 || off+length > this.size {
+		return dst, fmt.Errorf("Invalid range [%d..%d) (size %d)", off, off+length, this.size)
+	}
+
+	end := off + length
+
+	for off < end {
+		idx := int(off / int64(this.blockSize))
+		data, err := this.block(idx)
+
+		if err != nil {
+			return dst, err
+		}
+
+		b := &this.blocks[idx]
+		from := off - b.start
+		to := int64(b.dsize)
+
+		if b.start+to > end {
+			to = end - b.start
+		}
+
+		dst = append(dst, data[from:to]...)
+		off = b.start + to
+	}
+
+	return dst, nil
+}
+
+// Return the decoded block (possibly from the cache)
+func (this *Reader) block(idx int) ([]byte, error) {
+	if this.cache != nil {
+		if data := this.cache.get(idx); data != nil {
+			return data, nil
+		}
+	}
+
+	b := &this.blocks[idx]
+	src := make([]byte, b.csize)
+
+	if err := readAt(this.r, src, b.offset); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, b.dsize)
+	p := this.pipelines.Get().(*pipeline.Pipeline)
+	_, written, err := p.Inverse(src, data)
+	this.pipelines.Put(p)
+
+	if err != nil {
+		return nil, fmt.Errorf("Block %d: %v", idx, err)
+	}
+
+	hasher, _ := hash.NewXXHash32(_SEEKABLE_HASH_SEED)
+
+	if int(written) != b.dsize || hasher.Hash(data) != b.checksum {
+		return nil, fmt.Errorf("Block %d: corrupted data", idx)
+	}
+
+	if this.cache != nil {
+		this.cache.put(idx, data)
+	}
+
+	return data, nil
+}
+
+// Fill buf from r at offset 'off'. A ReaderAt may return io.EOF with a full
+// read at the end of the data.
+func readAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// blockCache is a LRU cache of decoded blocks
+type blockCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[int]*list.Element
+	lru      *list.List // front is most recently used
+}
+
+type cacheEntry struct {
+	idx  int
+	data []byte
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{capacity: capacity, entries: make(map[int]*list.Element), lru: list.New()}
+}
+
+func (this *blockCache) get(idx int) []byte {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e := this.entries[idx]; e != nil {
+		this.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).data
+	}
+
+	return nil
+}
+
+func (this *blockCache) put(idx int, data []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e := this.entries[idx]; e != nil {
+		this.lru.MoveToFront(e)
+		return
+	}
+
+	this.entries[idx] = this.lru.PushFront(&cacheEntry{idx: idx, data: data})
+
+	if this.lru.Len() > this.capacity {
+		e := this.lru.Back()
+		this.lru.Remove(e)
+		delete(this.entries, e.Value.(*cacheEntry).idx)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package seekable implements a random access format: the data is split
+// into blocks compressed independently (see pipeline.Pipeline) and an index
+// of the blocks is appended at the end. Any range of the original data can
+// be decoded by reading the index, then only the blocks overlapping the range.
+//
+// Layout (all integers are big endian):
+//
+//	header: magic (32 bits), version (8 bits), reserved (24 bits)
+//	blocks: pipeline payloads
+//	index:  pipeline string length (8 bits), pipeline string,
+//	        block size (32 bits), block count (32 bits), then for each block:
+//	        compressed size (32 bits), decoded size (32 bits),
+//	        XXHash32 of the decoded data (32 bits)
+//	footer: index size (32 bits), XXHash32 of the index (32 bits),
+//	        version (8 bits), reserved (24 bits), magic (32 bits)
+package seekable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+	"github.com/flanglet/kanzi-go/util/hash"
+)
+
+const (
+	// SEEKABLE_MAGIC is the signature at the start and at the end of the data
+	SEEKABLE_MAGIC = uint32(0x4B4E5A53) // "KNZS"
+	// SEEKABLE_VERSION is the version of the format
+	SEEKABLE_VERSION = 1
+	// DEFAULT_BLOCK_SIZE is a good trade-off between ratio and access cost
+	DEFAULT_BLOCK_SIZE = 1024 * 1024
+
+	_SEEKABLE_MIN_BLOCK_SIZE = 1024
+	_SEEKABLE_MAX_BLOCK_SIZE = 256 * 1024 * 1024
+	_SEEKABLE_HEADER_SIZE    = 8
+	_SEEKABLE_FOOTER_SIZE    = 16
+	_SEEKABLE_ENTRY_SIZE     = 12
+	_SEEKABLE_HASH_SEED      = 0x4B4E5A53
+)
+
+type blockEntry struct {
+	offset   int64 // position of the compressed block
+	start    int64 // position of the block in the decoded data
+	csize    int
+	dsize    int
+	checksum uint32
+}
+
+// Writer compresses the data written into a seekable archive
+type Writer struct {
+	w         io.Writer
+	desc      *pipeline.Description
+	pipeline  *pipeline.Pipeline
+	hasher    *hash.XXHash32
+	blockSize int
+	iBuffer   []byte
+	oBuffer   []byte
+	blocks    []blockEntry
+	written   int64
+	err       error
+	closed    bool
+}
+
+// NewWriter creates a new Writer using the provided pipeline string
+// (EG. "TEXT+BWT+RANK+ZRLT&ANS0") and block size. Smaller blocks make
+// random access cheaper at the expense of the compression ratio.
+func NewWriter(w io.Writer, pipelineStr string, blockSize uint) (*Writer, error) {
+	if w == nil {
+		return nil, errors.New("Invalid null writer parameter")
+	}
+
+	if blockSize < _SEEKABLE_MIN_BLOCK_SIZE || blockSize > _SEEKABLE_MAX_BLOCK_SIZE {
+		return nil, fmt.Errorf("The block size must be in [%d..%d]", _SEEKABLE_MIN_BLOCK_SIZE, _SEEKABLE_MAX_BLOCK_SIZE)
+	}
+
+	desc, err := pipeline.Parse(pipelineStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(desc.String()) > 255 {
+		return nil, fmt.Errorf("Invalid pipeline '%v': description too long", pipelineStr)
+	}
+
+	p, err := pipeline.NewPipelineWithCtx(desc, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &Writer{w: w, desc: desc, pipeline: p, blockSize: int(blockSize)}
+	this.hasher, _ = hash.NewXXHash32(_SEEKABLE_HASH_SEED)
+	this.iBuffer = make([]byte, 0, blockSize)
+	this.oBuffer = make([]byte, p.MaxEncodedLen(int(blockSize)))
+	this.blocks = make([]blockEntry, 0)
+
+	var header [_SEEKABLE_HEADER_SIZE]byte
+	binary.BigEndian.PutUint32(header[0:4], SEEKABLE_MAGIC)
+	header[4] = SEEKABLE_VERSION
+	this.write(header[:])
+	return this, this.err
+}
+
+// Write compresses the data. Full blocks are written to the underlying
+// writer as soon as they are available.
+func (this *Writer) Write(data []byte) (int, error) {
+	if this.closed == true {
+		return 0, errors.New("Writer already closed")
+	}
+
+	count := 0
+
+	for len(data) > 0 && this.err == nil {
+		n := this.blockSize - len(this.iBuffer)
+
+		if n > len(data) {
+			n = len(data)
+		}
+
+		this.iBuffer = append(this.iBuffer, data[0:n]...)
+		data = data[n:]
+		count += n
+
+		if len(this.iBuffer) == this.blockSize {
+			this.writeBlock()
+		}
+	}
+
+	return count, this.err
+}
+
+// Close writes the last block, the index and the footer. The underlying
+// writer is not closed.
+func (this *Writer) Close() error {
+	if this.closed == true {
+		return this.err
+	}
+
+	this.closed = true
+
+	if len(this.iBuffer) > 0 {
+		this.writeBlock()
+	}
+
+	if this.err != nil {
+		return this.err
+	}
+
+	name := this.desc.String()
+	index := make([]byte, 0, 9+len(name)+_SEEKABLE_ENTRY_SIZE*len(this.blocks))
+	index = append(index, byte(len(name)))
+	index = append(index, name...)
+	index = appendUint32(index, uint32(this.blockSize))
+	index = appendUint32(index, uint32(len(this.blocks)))
+
+	for _, b := range this.blocks {
+		index = appendUint32(index, uint32(b.csize))
+		index = appendUint32(index, uint32(b.dsize))
+		index = appendUint32(index, b.checksum)
+	}
+
+	footer := make([]byte, 0, _SEEKABLE_FOOTER_SIZE)
+	footer = appendUint32(footer, uint32(len(index)))
+	footer = appendUint32(footer, this.hasher.Hash(index))
+	footer = append(footer, SEEKABLE_VERSION, 0, 0, 0)
+	footer = appendUint32(footer, SEEKABLE_MAGIC)
+	this.write(index)
+	this.write(footer)
+	return this.err
+}
+
+// Written returns the number of bytes written to the underlying writer
+func (this *Writer) Written() int64 {
+	return this.written
+}
+
+func (this *Writer) writeBlock() {
+	_, written, err := this.pipeline.Forward(this.iBuffer, this.oBuffer)
+
+	if err != nil {
+		this.err = err
+		return
+	}
+
+	this.blocks = append(this.blocks, blockEntry{
+		offset:   this.written,
+		csize:    int(written),
+		dsize:    len(this.iBuffer),
+		checksum: this.hasher.Hash(this.iBuffer),
+	})
+
+	this.write(this.oBuffer[0:written])
+	this.iBuffer = this.iBuffer[0:0]
+}
+
+func (this *Writer) write(buf []byte) {
+	if this.err != nil {
+		return
+	}
+
+	n, err := this.w.Write(buf)
+	this.written += int64(n)
+
+	if err == nil && n != len(buf) {
+		err = io.ErrShortWrite
+	}
+
+	this.err = err
+}
+
+func appendUint32(buf []byte, val uint32) []byte {
+	return append(buf, byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+}
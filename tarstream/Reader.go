@@ -0,0 +1,125 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tarstream
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flanglet/kanzi-go/compress"
+	"github.com/flanglet/kanzi-go/seekable"
+)
+
+// Reader is a tar.Reader over a .tar.knz stream
+type Reader struct {
+	*tar.Reader
+	cr io.Closer
+}
+
+// NewReader creates a new Reader decompressing the .tar.knz stream
+func NewReader(r io.Reader) (*Reader, error) {
+	cr, err := compress.NewReader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{Reader: tar.NewReader(cr), cr: cr}, nil
+}
+
+// Close releases the decompressor. The underlying reader is not closed.
+func (this *Reader) Close() error {
+	return this.cr.Close()
+}
+
+// Index provides random access to the entries of a seekable tarball.
+// Building the index only decodes the blocks containing tar headers
+// (the content of the entries is skipped). An Index is safe for
+// concurrent use.
+type Index struct {
+	r       *seekable.Reader
+	headers []*tar.Header
+	offsets []int64 // start of the content of each entry
+	names   map[string]int
+}
+
+// NewIndex creates a new Index over a seekable tarball of 'size' bytes
+func NewIndex(r io.ReaderAt, size int64) (*Index, error) {
+	sr, err := seekable.NewReader(r, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIndexWithReader(sr)
+}
+
+// NewIndexWithReader creates a new Index over a seekable tarball opened
+// with the seekable package (EG. with seekable.OpenURL)
+func NewIndexWithReader(r *seekable.Reader) (*Index, error) {
+	this := &Index{r: r, headers: make([]*tar.Header, 0), offsets: make([]int64, 0)}
+	this.names = make(map[string]int)
+
+	// tar.Reader seeks over the content of the entries if possible
+	section := io.NewSectionReader(r, 0, r.Size())
+	tr := tar.NewReader(section)
+
+	for {
+		h, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset, _ := section.Seek(0, io.SeekCurrent)
+		this.names[h.Name] = len(this.headers)
+		this.headers = append(this.headers, h)
+		this.offsets = append(this.offsets, offset)
+	}
+
+	return this, nil
+}
+
+// Headers returns the headers of the entries in tar order
+func (this *Index) Headers() []*tar.Header {
+	res := make([]*tar.Header, len(this.headers))
+	copy(res, this.headers)
+	return res
+}
+
+// Open returns a reader over the content of the named entry (the last one
+// if the name appears several times). Only regular files can be opened.
+func (this *Index) Open(name string) (*io.SectionReader, error) {
+	idx, exists := this.names[name]
+
+	if exists == false {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	h := this.headers[idx]
+
+	if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+		return nil, fmt.Errorf("Cannot open '%v': not a regular file (type %c)", name, h.Typeflag)
+	}
+
+	return io.NewSectionReader(this.r, this.offsets[idx], h.Size), nil
+}
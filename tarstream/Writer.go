@@ -0,0 +1,71 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tarstream streams archive/tar data through the compressor
+// (.tar.knz files). Tarballs written with NewSeekableWriter use the
+// seekable format (see the seekable package) and can be indexed to read
+// any entry without decoding the others (see Index).
+package tarstream
+
+import (
+	"archive/tar"
+	"io"
+
+	"github.com/flanglet/kanzi-go/compress"
+	"github.com/flanglet/kanzi-go/seekable"
+)
+
+// Writer is a tar.Writer compressing its output. Close must be called
+// to write the end of the tar and compressed data.
+type Writer struct {
+	*tar.Writer
+	cw io.Closer
+}
+
+// NewWriter creates a new Writer producing a .tar.knz stream at the
+// provided compression level (see compress.NewWriterLevel)
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	cw, err := compress.NewWriterLevel(w, level)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{Writer: tar.NewWriter(cw), cw: cw}, nil
+}
+
+// NewSeekableWriter creates a new Writer producing a seekable tarball
+// with the provided pipeline string and block size (see seekable.NewWriter)
+func NewSeekableWriter(w io.Writer, pipelineStr string, blockSize uint) (*Writer, error) {
+	sw, err := seekable.NewWriter(w, pipelineStr, blockSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{Writer: tar.NewWriter(sw), cw: sw}, nil
+}
+
+// Close writes the tar footer and closes the compressor. The underlying
+// writer is not closed.
+func (this *Writer) Close() error {
+	err := this.Writer.Close()
+
+	if err2 := this.cw.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/flanglet/kanzi-go/archive"
+)
+
+func TestArchiveFS(b *testing.T) {
+	files := map[string]string{
+		"index.html":             "<html>{{.Title}}</html>",
+		"templates/page.tmpl":    strings.Repeat("{{range .Items}}<li>{{.}}</li>{{end}}\n", 500),
+		"templates/partials/a.t": "partial a",
+		"static/empty.css":       "",
+		"static/app.js":          strings.Repeat("console.log('kanzi');\n", 3000),
+	}
+
+	var buf bytes.Buffer
+	w, err := archive.NewWriter(&buf, "TEXT+BWT+RANK+ZRLT&ANS0", 4096)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	modTime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, name := range []string{"index.html", "templates/page.tmpl", "templates/partials/a.t", "static/empty.css", "static/app.js"} {
+		fw, err := w.CreateHeader(&archive.FileHeader{Name: name, Mode: 0640, ModTime: modTime})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if _, err = fw.Write([]byte(files[name])); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	for _, name := range []string{"index.html", "/abs", "../up", "templates", "index.html/x"} {
+		if _, err = w.Create(name); err == nil {
+			b.Fatalf("Invalid name '%v' not detected", name)
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	fmt.Printf("Archive size: %d bytes\n", buf.Len())
+	fsys, err := archive.NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Standard conformance checks (Open, ReadDir, Stat, ReadFile, Seek, ...)
+	if err = fstest.TestFS(fsys, "index.html", "templates/page.tmpl", "templates/partials/a.t", "static/empty.css", "static/app.js"); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for name, content := range files {
+		data, err := fs.ReadFile(fsys, name)
+
+		if err != nil || string(data) != content {
+			b.Fatalf("Incorrect content for '%v': %v", name, err)
+		}
+
+		info, _ := fs.Stat(fsys, name)
+
+		if info.Mode() != 0640 || info.ModTime().Equal(modTime) == false {
+			b.Fatalf("Incorrect file info for '%v': %v %v", name, info.Mode(), info.ModTime())
+		}
+	}
+
+	entries, err := fs.ReadDir(fsys, "templates")
+
+	if err != nil || len(entries) != 2 || entries[0].Name() != "page.tmpl" || entries[1].IsDir() == false {
+		b.Fatalf("Incorrect directory entries: %v", err)
+	}
+
+	if _, err = fsys.Open("missing.txt"); err == nil {
+		b.Fatalf("Missing file not detected")
+	}
+
+	fmt.Printf("Error: %v\n", err)
+
+	if _, err = archive.NewFS(bytes.NewReader(buf.Bytes()[0:100]), 100); err == nil {
+		b.Fatalf("Truncated archive not detected")
+	}
+}
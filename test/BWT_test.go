@@ -17,11 +17,14 @@ package main
 
 import (
 	"fmt"
+	"math/bits"
 	"math/rand"
+	"sort"
 	"testing"
 	"time"
 
 	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/function"
 	"github.com/flanglet/kanzi-go/transform"
 )
 
@@ -157,3 +160,413 @@ func testCorrectnessBWT(isBWT bool) error {
 
 	return error(nil)
 }
+
+func TestBWTWithJobs(b *testing.T) {
+	fmt.Println("Test BWT with jobs")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	if _, err := transform.NewBWTWithJobs(0); err == nil {
+		b.Errorf("0 job: expected an error")
+	}
+
+	for ii := 1; ii <= 3; ii++ {
+		// Random text with repeats, between 1 MB and 4 MB
+		buf1 := make([]byte, (1<<20)+rnd.Intn(3<<20))
+		n := 0
+
+		for n < len(buf1) {
+			if n > 256 && rnd.Intn(4) == 0 {
+				start := rnd.Intn(n - 256)
+				n += copy(buf1[n:], buf1[start:start+16+rnd.Intn(240)])
+			} else {
+				buf1[n] = byte(97 + rnd.Intn(4*ii))
+				n++
+			}
+		}
+
+		buf2 := make([]byte, len(buf1))
+		buf3 := make([]byte, len(buf1))
+		buf4 := make([]byte, len(buf1))
+		serial, _ := transform.NewBWT()
+
+		if _, _, err := serial.Forward(buf1, buf2); err != nil {
+			b.Errorf("Error: %v", err)
+			return
+		}
+
+		for _, jobs := range []int{2, 4, 8} {
+			fmt.Printf("Test %v, size=%v, jobs=%v\n", ii, len(buf1), jobs)
+			bwt, _ := transform.NewBWTWithJobs(jobs)
+
+			if _, _, err := bwt.Forward(buf1, buf3); err != nil {
+				b.Errorf("Error: %v", err)
+				return
+			}
+
+			if string(buf2) != string(buf3) {
+				b.Errorf("Different output with %v jobs", jobs)
+				return
+			}
+
+			for i := 0; i < transform.GetBWTChunks(len(buf1)); i++ {
+				if serial.PrimaryIndex(i) != bwt.PrimaryIndex(i) {
+					b.Errorf("Different primary index %v with %v jobs", i, jobs)
+					return
+				}
+			}
+
+			if _, _, err := bwt.Inverse(buf3, buf4); err != nil {
+				b.Errorf("Error: %v", err)
+				return
+			}
+
+			if string(buf1) != string(buf4) {
+				b.Errorf("Different inverse with %v jobs", jobs)
+				return
+			}
+		}
+	}
+
+	fmt.Println("Identical")
+}
+
+func TestBWTRepeats(b *testing.T) {
+	fmt.Println("Test BWT of repetitive blocks")
+
+	// Fibonacci and Thue-Morse words: highly repetitive without period
+	fib1, fib2 := []byte("a"), []byte("ab")
+
+	for len(fib2) < 3<<20 {
+		fib1, fib2 = fib2, append(append([]byte{}, fib2...), fib1...)
+	}
+
+	thue := make([]byte, 2<<20)
+
+	for i := range thue {
+		thue[i] = byte('a' + bits.OnesCount(uint(i))&1)
+	}
+
+	for _, buf1 := range [][]byte{fib2[0 : 3<<20], thue} {
+		fmt.Printf("Size=%v\n", len(buf1))
+		buf2 := make([]byte, len(buf1))
+		buf3 := make([]byte, len(buf1))
+		bwt, _ := transform.NewBWT()
+
+		if _, _, err := bwt.Forward(buf1, buf2); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		// Same output as the suffix array built by DivSufSort
+		sa := make([]int32, len(buf1))
+		ds, _ := transform.NewDivSufSort()
+		ds.ComputeSuffixArray(buf1, sa)
+		buf3[0] = buf1[len(buf1)-1]
+		n := 1
+
+		for _, idx := range sa {
+			if idx != 0 {
+				buf3[n] = buf1[idx-1]
+				n++
+			}
+		}
+
+		if string(buf2) != string(buf3) {
+			b.Fatalf("Incorrect BWT output")
+		}
+
+		if _, _, err := bwt.Inverse(buf2, buf3); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		if string(buf1) != string(buf3) {
+			b.Fatalf("Different inverse")
+		}
+	}
+
+	fmt.Println("Identical")
+}
+
+func TestBWTChunks(b *testing.T) {
+	fmt.Println("Test BWT chunks")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	buf1 := make([]byte, 12*1024*1024+rnd.Intn(1024))
+
+	for i := range buf1 {
+		buf1[i] = byte(65 + rnd.Intn(8))
+	}
+
+	// Stream format version 10 (no version in the context) and version 9
+	for _, version := range []uint{0, 9} {
+		ctx := map[string]interface{}{"jobs": uint(1)}
+		expected := len(buf1) >> 20
+
+		if version != 0 {
+			ctx["bsVersion"] = version
+			expected = len(buf1) >> 22
+		}
+
+		enc, _ := function.NewBWTBlockCodecWithCtx(&ctx)
+		buf2 := make([]byte, enc.MaxEncodedLen(len(buf1)))
+		_, dstIdx, err := enc.Forward(buf1, buf2)
+
+		if err != nil {
+			b.Errorf("Error: %v", err)
+			return
+		}
+
+		bwt, _ := transform.NewBWTWithCtx(&ctx)
+
+		if chunks := bwt.Chunks(len(buf1)); chunks != expected {
+			b.Errorf("Version %v: expected %v chunks, got %v", version, expected, chunks)
+		}
+
+		for _, jobs := range []uint{1, 4, 32} {
+			fmt.Printf("Version %v, jobs=%v\n", version, jobs)
+			ctx["jobs"] = jobs
+			dec, _ := function.NewBWTBlockCodecWithCtx(&ctx)
+			buf3 := make([]byte, len(buf1))
+
+			if _, _, err = dec.Inverse(buf2[0:dstIdx], buf3); err != nil {
+				b.Errorf("Error: %v", err)
+				return
+			}
+
+			if string(buf1) != string(buf3) {
+				b.Errorf("Version %v, %v jobs: different output", version, jobs)
+				return
+			}
+		}
+	}
+
+	fmt.Println("Identical")
+}
+
+func TestBWTPartitioned(b *testing.T) {
+	fmt.Println("Test BWT partitioned inverse")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Blocks of 1 and 3 segments, few symbols (one group) and all symbols
+	// (several groups)
+	for _, test := range []struct{ size, symbols int }{{4 << 20, 4}, {4 << 20, 256}, {(9 << 20) + 12345, 256}} {
+		buf1 := make([]byte, test.size+rnd.Intn(1024))
+
+		for i := range buf1 {
+			buf1[i] = byte(rnd.Intn(test.symbols))
+		}
+
+		buf2 := make([]byte, len(buf1))
+		ctx := map[string]interface{}{"jobs": uint(1)}
+		bwt, _ := transform.NewBWTWithCtx(&ctx)
+
+		if _, _, err := bwt.Forward(buf1, buf2); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+
+		for _, jobs := range []uint{1, 4} {
+			fmt.Printf("Size=%v, symbols=%v, jobs=%v\n", len(buf1), test.symbols, jobs)
+
+			// Partitioned from 1 MB: always used for these blocks
+			ctx := map[string]interface{}{"jobs": jobs, "bwtPartitionMin": uint(1 << 20)}
+			inv, _ := transform.NewBWTWithCtx(&ctx)
+
+			for i := 0; i < bwt.Chunks(len(buf1)); i++ {
+				inv.SetPrimaryIndex(i, bwt.PrimaryIndex(i))
+			}
+
+			buf3 := make([]byte, len(buf1))
+
+			if _, _, err := inv.Inverse(buf2, buf3); err != nil {
+				b.Fatalf("Error: %v", err)
+			}
+
+			if string(buf1) != string(buf3) {
+				b.Fatalf("Size %v, %v symbols, %v jobs: different inverse", len(buf1), test.symbols, jobs)
+			}
+		}
+	}
+
+	fmt.Println("Identical")
+}
+
+func TestLyndonFactors(b *testing.T) {
+	fmt.Println("Test Lyndon factors")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// A Lyndon word is strictly smaller than all its proper suffixes
+	isLyndon := func(w string) bool {
+		for i := 1; i < len(w); i++ {
+			if w[i:] <= w {
+				return false
+			}
+		}
+
+		return len(w) > 0
+	}
+
+	for ii := 0; ii < 1000; ii++ {
+		data := make([]byte, rnd.Intn(100))
+
+		for i := range data {
+			data[i] = byte('a' + rnd.Intn(1+ii%4))
+		}
+
+		factors := transform.LyndonFactors(data)
+		longest := 0
+
+		for i, start := range factors {
+			end := len(data)
+
+			if i+1 < len(factors) {
+				end = factors[i+1]
+			}
+
+			w := string(data[start:end])
+
+			if isLyndon(w) == false {
+				b.Fatalf("%q: factor %q is not a Lyndon word", data, w)
+			}
+
+			if i > 0 && w > string(data[factors[i-1]:start]) {
+				b.Fatalf("%q: factors not in non increasing order", data)
+			}
+
+			if end-start > longest {
+				longest = end - start
+			}
+		}
+
+		if (len(data) > 0 && (len(factors) == 0 || factors[0] != 0)) || transform.LongestLyndonFactor(data) != longest {
+			b.Fatalf("%q: incorrect factorization %v", data, factors)
+		}
+	}
+
+	// Bounded factors: the forward transform fails for a longer factor
+	data := []byte("abcabdabcabdabcabd0123")
+	ctx := map[string]interface{}{"bwtsMaxFactor": uint(6)}
+	bwts, _ := transform.NewBWTSWithCtx(&ctx)
+	output := make([]byte, len(data))
+
+	if _, _, err := bwts.Forward(data, output); err != nil {
+		b.Errorf("Error: %v", err)
+	}
+
+	ctx["bwtsMaxFactor"] = uint(5)
+	bwts, _ = transform.NewBWTSWithCtx(&ctx)
+
+	if _, _, err := bwts.Forward(data, output); err == nil {
+		b.Errorf("No error for a factor longer than the limit")
+	} else {
+		fmt.Printf("Max factor: %v\n", err)
+	}
+}
+
+func TestST(b *testing.T) {
+	fmt.Println("Test ST")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	if _, err := transform.NewST(2); err == nil {
+		b.Errorf("Order 2: expected an error")
+	}
+
+	if _, err := transform.NewST(7); err == nil {
+		b.Errorf("Order 7: expected an error")
+	}
+
+	for ii := 1; ii <= 7; ii++ {
+		var buf1 []byte
+
+		if ii == 1 {
+			buf1 = []byte("mississippi")
+		} else if ii == 2 {
+			buf1 = []byte("aa")
+		} else if ii == 3 {
+			buf1 = []byte("SIX.MIXED.PIXIES.SIFT.SIXTY.PIXIE.DUST.BOXES")
+		} else if ii < 7 {
+			buf1 = make([]byte, 1<<uint(4*ii-4))
+
+			for i := range buf1 {
+				buf1[i] = byte(65 + rnd.Intn(2*ii))
+			}
+		} else {
+			buf1 = make([]byte, 4*1024*1024)
+
+			for i := range buf1 {
+				buf1[i] = byte(i >> 5)
+			}
+		}
+
+		for order := uint(transform.ST_MIN_ORDER); order <= transform.ST_MAX_ORDER; order++ {
+			fmt.Printf("Test %v, size=%v, order=%v\n", ii, len(buf1), order)
+			st, _ := transform.NewST(order)
+			buf2 := make([]byte, len(buf1))
+			buf3 := make([]byte, len(buf1))
+
+			if _, _, err := st.Forward(buf1, buf2); err != nil {
+				b.Errorf("Error: %v", err)
+				return
+			}
+
+			if len(buf1) <= 4096 {
+				// Reference: rotations sorted on 'order' bytes, ties by position
+				n := len(buf1)
+				rows := make([]int, n)
+
+				for i := range rows {
+					rows[i] = i
+				}
+
+				sort.SliceStable(rows, func(x, y int) bool {
+					for k := 0; k < int(order); k++ {
+						c1, c2 := buf1[(rows[x]+k)%n], buf1[(rows[y]+k)%n]
+
+						if c1 != c2 {
+							return c1 < c2
+						}
+					}
+
+					return false
+				})
+
+				for i, p := range rows {
+					if buf2[i] != buf1[(p+n-1)%n] || (p == 0 && st.PrimaryIndex() != uint(i)) {
+						b.Errorf("Order %v: incorrect forward transform at index %v", order, i)
+						return
+					}
+				}
+			}
+
+			inv, _ := transform.NewST(order)
+			inv.SetPrimaryIndex(st.PrimaryIndex())
+
+			if _, _, err := inv.Inverse(buf2, buf3); err != nil {
+				b.Errorf("Error: %v", err)
+				return
+			}
+
+			if string(buf1) != string(buf3) {
+				b.Errorf("Order %v: different output after inverse", order)
+				return
+			}
+		}
+	}
+
+	// Block codec (header with the primary index)
+	buf1 := []byte("SIX.MIXED.PIXIES.SIFT.SIXTY.PIXIE.DUST.BOXES")
+	enc, _ := function.NewSTBlockCodec(5)
+	buf2 := make([]byte, enc.MaxEncodedLen(len(buf1)))
+	buf3 := make([]byte, len(buf1))
+	_, dstIdx, err := enc.Forward(buf1, buf2)
+
+	if err == nil {
+		dec, _ := function.NewSTBlockCodec(5)
+		_, _, err = dec.Inverse(buf2[0:dstIdx], buf3)
+	}
+
+	if err != nil {
+		b.Errorf("Error: %v", err)
+	} else if string(buf1) != string(buf3) {
+		b.Errorf("ST block codec: different output after inverse")
+	}
+
+	fmt.Println("Identical")
+}
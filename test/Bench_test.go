@@ -0,0 +1,190 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/bench"
+)
+
+func TestBenchRegression(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 200000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	pipelines, err := bench.LevelPipelines(0, 6)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	cfg := bench.Config{Pipelines: pipelines, BlockSize: 65536, Jobs: 2}
+	results := bench.Run("random", input, cfg)
+	var report bytes.Buffer
+	bench.WriteReport(&report, results)
+	fmt.Print(report.String())
+
+	for _, r := range results {
+		if r.Err != nil {
+			b.Errorf("%v: %v", r.Pipeline, r.Err)
+			continue
+		}
+
+		// Only the store level may not compress this data
+		if r.Pipeline != "NONE&NONE" && r.Ratio() >= 0.8 {
+			b.Errorf("%v: compression ratio regression: %.3f", r.Pipeline, r.Ratio())
+		}
+	}
+
+	// Optional run on a standard corpus (downloaded on first use)
+	if name := os.Getenv("KANZI_BENCH_CORPUS"); len(name) > 0 {
+		c, err := bench.GetCorpus(name)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		dir, err := bench.CacheDir()
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		files, err := c.Fetch(dir)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		results, err = bench.RunFiles(files, bench.Config{Pipelines: pipelines[1:5]})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		report.Reset()
+		bench.WriteReport(&report, bench.Total(results))
+		fmt.Print(report.String())
+
+		for _, r := range results {
+			if r.Err != nil {
+				b.Errorf("%v %v: %v", r.Name, r.Pipeline, r.Err)
+			}
+		}
+	}
+}
+
+func TestBenchCorpusCache(b *testing.T) {
+	// Serve a small zip archive instead of downloading a real corpus
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	contents := map[string]string{"corpus/a.txt": strings.Repeat("abc", 1000), "corpus/b/c.txt": "xyz"}
+
+	for name, data := range contents {
+		w, _ := zw.Create(name)
+		w.Write([]byte(data))
+	}
+
+	zw.Close()
+	downloads := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(archive.Bytes())
+	}))
+
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "kanzi_corpus")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	c := bench.Corpus{Name: "test", URL: server.URL + "/test.zip"}
+
+	for i := 0; i < 2; i++ {
+		files, err := c.Fetch(dir)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if len(files) != len(contents) {
+			b.Fatalf("Incorrect number of files: %d instead of %d", len(files), len(contents))
+		}
+
+		for _, f := range files {
+			data, _ := ioutil.ReadFile(f)
+			rel, _ := filepath.Rel(filepath.Join(dir, c.Name), f)
+
+			if string(data) != contents[filepath.ToSlash(rel)] {
+				b.Errorf("Incorrect content for %v", rel)
+			}
+		}
+	}
+
+	// The second fetch must use the cache
+	if downloads != 1 {
+		b.Errorf("Corpus downloaded %d times", downloads)
+	}
+
+	if _, err := bench.GetCorpus("unknown"); err == nil {
+		b.Errorf("No error for unknown corpus")
+	}
+}
+
+func TestBenchTransform(b *testing.T) {
+	input := []byte(strings.Repeat("The transforms are measured without the entropy codec. ", 2000))
+
+	for _, name := range []string{"BWT+MTFT+ZRLT", "LZ", "TEXT+BWT+SRT+ZRLT", "BWTS+RANK+ZRLT", "NONE"} {
+		r := bench.RunTransform(name, input, 3)
+
+		if r.Err != nil {
+			b.Fatalf("%v: %v", name, r.Err)
+		}
+
+		fmt.Printf("%-20s %7d => %7d (%.3f) %8.2f MB/s %8.2f MB/s %d allocs\n", r.Transform, r.InputSize,
+			r.OutputSize, r.Ratio(), r.ForwardSpeed(), r.InverseSpeed(), r.ForwardAllocs+r.InverseAllocs)
+
+		if r.Iterations != 3 || r.ForwardTime <= 0 || r.InverseTime <= 0 {
+			b.Errorf("%v: incorrect report: %+v", name, r)
+		}
+
+		if name != "NONE" && r.Ratio() >= 0.5 {
+			b.Errorf("%v: incorrect ratio: %.3f", name, r.Ratio())
+		}
+	}
+
+	if r := bench.RunTransform("BWT+FOO", input, 1); r.Err == nil {
+		b.Errorf("No error for an invalid transform")
+	}
+}
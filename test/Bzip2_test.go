@@ -0,0 +1,82 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/flanglet/kanzi-go/bzip2"
+)
+
+// Produced by 'bzip2 -9'
+const _BZ2_TEST_FILE = "" +
+	"\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x0f\x5a\x06\x44\x00\x00\x30\x19\x80\x40\x00\x10\x14\x3f" +
+	"\x2d\x58\x10\x20\x00\x54\x40\x46\x9a\x62\x0c\x12\x52\x7e\xa1\xa8\x3d\x13\xd4\x5d\x0e\xed\x0c\x51" +
+	"\xde\x5e\xb7\x4d\x82\xb2\x89\x9e\x91\x81\x5a\x41\x6f\x22\x51\x81\x55\x62\xa8\x8f\xda\x01\x01\x23" +
+	"\x96\x7b\x17\x72\x45\x38\x50\x90\x0f\x5a\x06\x44"
+
+func TestBzip2(b *testing.T) {
+	expected := "kanzi can read bzip2 files: " + strings.Repeat("aaaaaaaa", 8) + strings.Repeat(" banana bandana ", 6)
+
+	// Single stream then concatenated streams (EG. pbzip2)
+	for i, input := range []string{_BZ2_TEST_FILE, _BZ2_TEST_FILE + _BZ2_TEST_FILE} {
+		fmt.Printf("Decode %d bytes\n", len(input))
+		output, err := decodeBzip2([]byte(input))
+
+		if err != nil {
+			b.Fatalf("Decoding failed: %v", err)
+		}
+
+		if string(output) != strings.Repeat(expected, i+1) {
+			b.Fatalf("Incorrect decoded data: %q", output)
+		}
+	}
+
+	// Corrupted and truncated streams must fail without panicking
+	corrupted := []byte(_BZ2_TEST_FILE)
+	corrupted[40] ^= 0x10
+
+	if _, err := decodeBzip2(corrupted); err == nil {
+		b.Errorf("No error for corrupted stream")
+	} else {
+		fmt.Printf("Corrupted stream: %v\n", err)
+	}
+
+	for _, n := range []int{0, 3, 10, 50, len(_BZ2_TEST_FILE) - 1} {
+		if _, err := decodeBzip2([]byte(_BZ2_TEST_FILE[0:n])); err == nil {
+			b.Errorf("No error for stream truncated to %d bytes", n)
+		}
+	}
+
+	if _, err := decodeBzip2([]byte(_BZ2_TEST_FILE + "garbage")); err == nil {
+		b.Errorf("No error for trailing garbage")
+	}
+}
+
+func decodeBzip2(input []byte) ([]byte, error) {
+	r, err := bzip2.NewReader(bytes.NewReader(input))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/chunkstore"
+)
+
+func TestChunkStore(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := make([]byte, 1000000)
+
+	for i := range data {
+		data[i] = byte(97 + rnd.Intn(1+(i>>14)&7))
+	}
+
+	dir, err := ioutil.TempDir("", "chunkstore")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	dirStore, err := chunkstore.NewDirStore(dir)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for _, store := range []chunkstore.Store{chunkstore.NewMemoryStore(), dirStore} {
+		cs, err := chunkstore.NewChunkStoreWithSizes(store, "TEXT+BWT+RANK+ZRLT&ANS0", 4096, 16384, 65536)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		ids, stats, err := cs.Write(bytes.NewReader(data))
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		fmt.Printf("%T: %d chunks, %d bytes => %d bytes\n", store, stats.Chunks, stats.Bytes, stats.StoredBytes)
+
+		// Insert bytes in the middle: only the chunks around the insertion are new
+		modified := append(append(append([]byte{}, data[0:500000]...), []byte("INSERTED")...), data[500000:]...)
+		ids2, stats2, err := cs.Write(bytes.NewReader(modified))
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		fmt.Printf("After insertion: %d chunks, %d new\n", stats2.Chunks, stats2.NewChunks)
+
+		if stats2.NewChunks > 3 {
+			b.Fatalf("Too many new chunks after a small insertion: %d", stats2.NewChunks)
+		}
+
+		for i, list := range [][]chunkstore.ID{ids, ids2} {
+			var buf bytes.Buffer
+
+			if _, err = cs.Read(&buf, list); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			if expected := [][]byte{data, modified}[i]; bytes.Equal(buf.Bytes(), expected) == false {
+				b.Fatalf("Incorrect restored data")
+			}
+		}
+
+		// Identical data: nothing new
+		if _, stats3, _ := cs.Write(bytes.NewReader(data)); stats3.NewChunks != 0 {
+			b.Fatalf("Unexpected new chunks: %d", stats3.NewChunks)
+		}
+
+		if id, err := chunkstore.ParseID(ids[0].String()); err != nil || id != ids[0] {
+			b.Fatalf("Incorrect id round trip: %v", err)
+		}
+
+		// Corrupted chunk
+		stored, _ := store.Get(ids[1])
+		corrupted := append([]byte{}, stored...)
+		corrupted[len(corrupted)/2] ^= 0x21
+		store.Put(ids[1], corrupted)
+
+		if _, err = cs.Get(nil, ids[1]); err == nil {
+			b.Fatalf("Corrupted chunk not detected")
+		}
+
+		if _, err = cs.Get(nil, chunkstore.ID{}); err != chunkstore.ErrNotFound {
+			b.Fatalf("Missing chunk not detected: %v", err)
+		}
+	}
+
+	if _, err = chunkstore.NewChunkStoreWithSizes(chunkstore.NewMemoryStore(), "NONE", 1000, 500, 2000); err == nil {
+		b.Fatalf("Invalid chunk sizes not detected")
+	}
+}
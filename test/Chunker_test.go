@@ -0,0 +1,298 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+// Returns short reads of random sizes to exercise the block boundaries
+type jitterReader struct {
+	r   io.Reader
+	rnd *rand.Rand
+}
+
+func (this *jitterReader) Read(buf []byte) (int, error) {
+	if len(buf) > 1 {
+		buf = buf[0 : 1+this.rnd.Intn(len(buf))]
+	}
+
+	return this.r.Read(buf)
+}
+
+func TestChunker(b *testing.T) {
+	names := []string{"LZ&HUFFMAN", "ROLZ&NONE", "TEXT+BWT+RANK+ZRLT&ANS0"}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, name := range names {
+		for _, blockSize := range []uint{1024, 4096, 65536} {
+			for _, overlap := range []uint{0, 4096} {
+				if err := testChunkerCorrectness(rnd, name, blockSize, overlap); err != nil {
+					b.Errorf("%v (block size %d, overlap %d): %v", name, blockSize, overlap, err)
+				}
+			}
+		}
+	}
+}
+
+func testChunkerCorrectness(rnd *rand.Rand, name string, blockSize, overlap uint) error {
+	fmt.Printf("Chunker test for %v (block size %d, overlap %d)\n", name, blockSize, overlap)
+	p, err := pipeline.NewPipeline(name)
+
+	if err != nil {
+		return err
+	}
+
+	c, err := pipeline.NewChunkerWithOverlap(p, blockSize, overlap)
+
+	if err != nil {
+		return err
+	}
+
+	bs := int(blockSize)
+
+	// Empty, smaller than a block, exactly one block, one byte more, and several
+	// blocks with a partial last one
+	for _, size := range []int{0, 100, bs, bs + 1, 5*bs + 77} {
+		input := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(65 + rnd.Intn(4*(1+i&7)))
+		}
+
+		var compressed bytes.Buffer
+		read, written, err := c.Forward(&compressed, &jitterReader{r: bytes.NewReader(input), rnd: rnd})
+
+		if err != nil {
+			return fmt.Errorf("size %d: %v", size, err)
+		}
+
+		if read != int64(size) || written != int64(compressed.Len()) {
+			return fmt.Errorf("size %d: incorrect forward counts: read %d, written %d", size, read, written)
+		}
+
+		// A trailer after the end marker must not be consumed
+		compressed.WriteString("trailer")
+		encLen := int64(compressed.Len() - 7)
+		var decompressed bytes.Buffer
+		read, written, err = c.Inverse(&decompressed, &compressed)
+
+		if err != nil {
+			return fmt.Errorf("size %d: %v", size, err)
+		}
+
+		if read != encLen || written != int64(size) {
+			return fmt.Errorf("size %d: incorrect inverse counts: read %d, written %d", size, read, written)
+		}
+
+		if compressed.String() != "trailer" {
+			return fmt.Errorf("size %d: data after the end marker was consumed", size)
+		}
+
+		if bytes.Equal(input, decompressed.Bytes()) == false {
+			return fmt.Errorf("size %d: decompressed data differs from input", size)
+		}
+	}
+
+	return nil
+}
+
+func TestChunkerOverlap(b *testing.T) {
+	fmt.Println("Chunker overlap test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// A random pattern repeated in every block: only matches crossing the
+	// block boundaries can find it in the first bytes of each block
+	pattern := make([]byte, 1024)
+	rnd.Read(pattern)
+	input := make([]byte, 0, 64*1024)
+
+	for len(input) < cap(input) {
+		input = append(input, pattern...)
+	}
+
+	sizes := make([]int, 2)
+
+	for i, overlap := range []uint{0, 2048} {
+		p, _ := pipeline.NewPipeline("LZ&NONE")
+		c, err := pipeline.NewChunkerWithOverlap(p, 1024, overlap)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var compressed, decompressed bytes.Buffer
+
+		if _, _, err = c.Forward(&compressed, bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+
+		sizes[i] = compressed.Len()
+
+		if _, _, err = c.Inverse(&decompressed, &compressed); err != nil {
+			b.Fatal(err)
+		}
+
+		if bytes.Equal(input, decompressed.Bytes()) == false {
+			b.Errorf("Overlap %d: decompressed data differs from input", overlap)
+		}
+	}
+
+	fmt.Printf("Compressed size without overlap: %d, with overlap: %d\n", sizes[0], sizes[1])
+
+	if sizes[1] >= sizes[0]/4 {
+		b.Errorf("The overlap did not improve compression: %d vs %d bytes", sizes[1], sizes[0])
+	}
+}
+
+func TestChunkerDictionary(b *testing.T) {
+	fmt.Println("Chunker dictionary test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Blocks made of a random pattern found in the dictionary
+	pattern := make([]byte, 1024)
+	rnd.Read(pattern)
+	input := make([]byte, 0, 16*1024)
+
+	for len(input) < cap(input) {
+		input = append(input, pattern...)
+	}
+
+	desc, _ := pipeline.Parse("LZ&NONE")
+	cold := 0
+
+	for _, overlap := range []uint{0, 2048} {
+		sizes := make([]int, 2)
+
+		for i, dict := range [][]byte{nil, pattern} {
+			ctx := map[string]interface{}{}
+
+			if dict != nil {
+				ctx["lzDictionary"] = dict
+			}
+
+			p, _ := pipeline.NewPipelineWithCtx(desc, ctx)
+			c, err := pipeline.NewChunkerWithOverlap(p, 1024, overlap)
+
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// The dictionary survives the first call
+			var compressed bytes.Buffer
+
+			for n := 0; n < 2; n++ {
+				compressed.Reset()
+
+				if _, _, err = c.Forward(&compressed, bytes.NewReader(input)); err != nil {
+					b.Fatal(err)
+				}
+
+				if n > 0 && compressed.Len() != sizes[i] {
+					b.Errorf("Overlap %d, dictionary %v: %d bytes after the first call, expected %d",
+						overlap, dict != nil, compressed.Len(), sizes[i])
+				}
+
+				sizes[i] = compressed.Len()
+			}
+
+			var decompressed bytes.Buffer
+
+			if _, _, err = c.Inverse(&decompressed, &compressed); err != nil {
+				b.Fatal(err)
+			}
+
+			if bytes.Equal(input, decompressed.Bytes()) == false {
+				b.Errorf("Overlap %d, dictionary %v: decompressed data differs from input", overlap, dict != nil)
+			}
+		}
+
+		fmt.Printf("Overlap %d: compressed size without dictionary: %d, with dictionary: %d\n",
+			overlap, sizes[0], sizes[1])
+
+		// Without overlap, every block uses the dictionary
+		if overlap == 0 {
+			cold = sizes[0]
+
+			if sizes[1] >= sizes[0]/4 {
+				b.Errorf("The dictionary did not improve compression: %d vs %d bytes", sizes[1], sizes[0])
+			}
+		} else if sizes[1] >= sizes[0] || sizes[1] >= cold {
+			// With overlap, the first block uses the dictionary
+			b.Errorf("Overlap %d: the dictionary did not improve compression: %d vs %d bytes",
+				overlap, sizes[1], sizes[0])
+		}
+	}
+}
+
+func TestChunkerErrors(b *testing.T) {
+	fmt.Println("Chunker errors test")
+	p, _ := pipeline.NewPipeline("LZ&HUFFMAN")
+
+	if _, err := pipeline.NewChunker(nil, 4096); err == nil {
+		b.Error("Expected an error for a null pipeline")
+	}
+
+	if _, err := pipeline.NewChunker(p, 100); err == nil {
+		b.Error("Expected an error for a small block size")
+	}
+
+	if _, err := pipeline.NewChunkerWithOverlap(p, 4096, 1<<20); err == nil {
+		b.Error("Expected an error for a large overlap")
+	}
+
+	c, _ := pipeline.NewChunker(p, 4096)
+	input := make([]byte, 10000)
+	var compressed bytes.Buffer
+
+	if _, _, err := c.Forward(&compressed, bytes.NewReader(input)); err != nil {
+		b.Fatal(err)
+	}
+
+	data := compressed.Bytes()
+	var sink bytes.Buffer
+
+	// Missing end marker, truncated block
+	for _, n := range []int{len(data) - 1, len(data) - 5, 1, 0} {
+		if _, _, err := c.Inverse(&sink, bytes.NewReader(data[0:n])); err == nil {
+			b.Errorf("Expected an error for data truncated to %d bytes", n)
+		}
+	}
+
+	// Block length larger than the max encoded length of a block
+	if _, _, err := c.Inverse(&sink, bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0x7F})); err == nil {
+		b.Error("Expected an error for an incorrect block length")
+	}
+
+	// Blocks written with a larger block size
+	big, _ := pipeline.NewChunker(p, 8192)
+	compressed.Reset()
+
+	if _, _, err := big.Forward(&compressed, bytes.NewReader(input)); err != nil {
+		b.Fatal(err)
+	}
+
+	if _, _, err := c.Inverse(&sink, &compressed); err == nil {
+		b.Error("Expected an error for a block larger than the block size")
+	}
+}
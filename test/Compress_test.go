@@ -0,0 +1,263 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/compress"
+)
+
+func TestCompressLevels(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 50000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	for level := compress.DEFAULT_COMPRESSION; level <= compress.BEST_COMPRESSION; level++ {
+		fmt.Printf("Level %d\n", level)
+		var buf bytes.Buffer
+		w, err := compress.NewWriterLevel(&buf, level)
+
+		if err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		if _, err = w.Write(input); err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		if err = w.Close(); err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		compressed := append([]byte{}, buf.Bytes()...)
+		r, err := compress.NewReader(&buf)
+
+		if err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		output, err := ioutil.ReadAll(r)
+
+		if err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		if bytes.Equal(input, output) == false {
+			b.Fatalf("Level %d: incorrect decompressed data", level)
+		}
+
+		// Reset must produce the same bitstream
+		buf.Reset()
+		w.Reset(&buf)
+		w.Write(input)
+		w.Close()
+
+		if bytes.Equal(compressed, buf.Bytes()) == false {
+			b.Fatalf("Level %d: different output after Reset", level)
+		}
+
+		if err = r.Reset(&buf); err != nil {
+			b.Fatalf("Level %d: %v", level, err)
+		}
+
+		if output, err = ioutil.ReadAll(r); err != nil || bytes.Equal(input, output) == false {
+			b.Fatalf("Level %d: incorrect decompressed data after Reset", level)
+		}
+	}
+
+	if _, err := compress.NewWriterLevel(ioutil.Discard, 9); err == nil {
+		b.Errorf("Invalid level accepted")
+	}
+}
+
+func TestCompressFlush(b *testing.T) {
+	pr, pw := io.Pipe()
+	w := compress.NewWriter(pw)
+	r, err := compress.NewReader(pr)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// After each flush, the reader must get all the data written so far
+	// while the writer is blocked (the pipe is not buffered)
+	for i := 0; i < 20; i++ {
+		chunk := make([]byte, 1+rnd.Intn(5000))
+
+		for j := range chunk {
+			chunk[j] = byte(65 + rnd.Intn(1+j&7))
+		}
+
+		errs := make(chan error, 1)
+
+		go func() {
+			if _, err := w.Write(chunk); err != nil {
+				errs <- err
+				return
+			}
+
+			errs <- w.Flush()
+		}()
+
+		output := make([]byte, len(chunk))
+
+		if _, err := io.ReadFull(r, output); err != nil {
+			b.Fatalf("Flush %d: %v", i, err)
+		}
+
+		if bytes.Equal(chunk, output) == false {
+			b.Fatalf("Flush %d: incorrect decompressed data", i)
+		}
+
+		if err := <-errs; err != nil {
+			b.Fatalf("Flush %d: %v", i, err)
+		}
+	}
+
+	go func() {
+		w.Close()
+		pw.Close()
+	}()
+
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		b.Errorf("Expected EOF, got %d bytes and %v", n, err)
+	}
+}
+
+func TestCompressConcatenated(b *testing.T) {
+	inputs := [][]byte{
+		[]byte(strings.Repeat("First stream, ", 5000)),
+		[]byte(strings.Repeat("Second stream. ", 3000)),
+	}
+
+	var buf bytes.Buffer
+
+	for _, input := range inputs {
+		w := compress.NewWriter(&buf)
+		w.Write(input)
+
+		if err := w.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	buf.WriteString("trailer")
+	fmt.Println("Concatenated streams read from an io.ByteReader")
+
+	// The bufio.Reader is an io.ByteReader: nothing is read past each stream
+	src := bufio.NewReader(&buf)
+	r := compress.NewFlateReader(src)
+
+	for i, input := range inputs {
+		if i > 0 {
+			if err := r.(flate.Resetter).Reset(src, nil); err != nil {
+				b.Fatalf("%v", err)
+			}
+		}
+
+		output, err := ioutil.ReadAll(r)
+
+		if err != nil {
+			b.Fatalf("Stream %d: %v", i, err)
+		}
+
+		if bytes.Equal(input, output) == false {
+			b.Fatalf("Stream %d: incorrect decompressed data", i)
+		}
+	}
+
+	if trailer, _ := ioutil.ReadAll(src); string(trailer) != "trailer" {
+		b.Errorf("Incorrect data after the streams: %q", trailer)
+	}
+
+	if err := r.(flate.Resetter).Reset(src, []byte("dict")); err == nil {
+		b.Errorf("No error for preset dictionary")
+	}
+
+	r.Close()
+}
+
+func TestCompressFormat(b *testing.T) {
+	// Interfaces of the archiver libraries
+	var format interface {
+		Name() string
+		Extension() string
+		OpenWriter(io.Writer) (io.WriteCloser, error)
+		OpenReader(io.Reader) (io.ReadCloser, error)
+	} = compress.Format{CompressionLevel: compress.BEST_SPEED}
+
+	fmt.Printf("Format %v (%v)\n", format.Name(), format.Extension())
+	input := []byte(strings.Repeat("Registered as an archiver format. ", 1000))
+	var buf bytes.Buffer
+	w, err := format.OpenWriter(&buf)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	w.Write(input)
+
+	if err = w.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	byName, byStream, err := compress.Format{}.Match("backup.tar.KNZ", bytes.NewReader(buf.Bytes()))
+
+	if byName == false || byStream == false || err != nil {
+		b.Errorf("No match: %v %v %v", byName, byStream, err)
+	}
+
+	byName, byStream, err = compress.Format{}.Match("backup.tar.gz", strings.NewReader("KA"))
+
+	if byName == true || byStream == true || err != nil {
+		b.Errorf("Incorrect match: %v %v %v", byName, byStream, err)
+	}
+
+	r, err := format.OpenReader(&buf)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	output, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if bytes.Equal(input, output) == false {
+		b.Errorf("Incorrect decompressed data")
+	}
+
+	if _, err = (compress.Format{CompressionLevel: 12}).OpenWriter(&buf); err == nil {
+		b.Errorf("No error for invalid level")
+	}
+}
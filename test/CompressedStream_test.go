@@ -0,0 +1,2588 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+func TestDeterministicOutput(b *testing.T) {
+	pipelines := [][2]string{
+		{"TEXT+BWT+RANK+ZRLT", "ANS0"},
+		{"LZ", "HUFFMAN"},
+		{"ROLZ", "NONE"},
+		{"RLT+TEXT", "TPAQ"},
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&31))
+	}
+
+	for _, p := range pipelines {
+		if err := testDeterministicOutput(input, p[0], p[1], false); err != nil {
+			b.Errorf("%v&%v: %v", p[0], p[1], err)
+		}
+	}
+
+	// Incompressible first block followed by text: the auto-tuner must pick
+	// the same pipeline whatever the number of blocks buffered before it runs
+	for i := 32768; i < len(input); i++ {
+		input[i] = "the quick brown fox jumps over the lazy dog "[i%44]
+	}
+
+	for i := 0; i < 32768; i++ {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	if err := testDeterministicOutput(input, "NONE", "NONE", true); err != nil {
+		b.Errorf("autoTune: %v", err)
+	}
+}
+
+// testDeterministicOutput checks that the compressed bytes do not depend
+// on the number of jobs, on the size of the writes nor on pipelining
+func testDeterministicOutput(input []byte, transform, codec string, autoTune bool) error {
+	if autoTune == true {
+		fmt.Println("Determinism test with auto-tuning")
+	} else {
+		fmt.Printf("Determinism test for %v&%v\n", transform, codec)
+	}
+
+	var reference []byte
+
+	for n, jobs := range []uint{1, 2, 3, 4, 8, 1, 3} {
+		var bs util.BufferStream
+		pipelined := n >= 5
+		ctx := map[string]interface{}{
+			"codec":      codec,
+			"transform":  transform,
+			"blockSize":  uint(32768),
+			"jobs":       jobs,
+			"checksum":   true,
+			"skipBlocks": true,
+			"pipeline":   pipelined,
+			"autoTune":   autoTune,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			return err
+		}
+
+		// Vary the size of the writes with the number of jobs
+		chunk := 1000 * int(jobs)
+
+		for i := 0; i < len(input); i += chunk {
+			end := i + chunk
+
+			if end > len(input) {
+				end = len(input)
+			}
+
+			if _, err = cos.Write(input[i:end]); err != nil {
+				return err
+			}
+		}
+
+		if err = cos.Close(); err != nil {
+			return err
+		}
+
+		output := make([]byte, bs.Len())
+		bs.Read(output)
+
+		if reference == nil {
+			reference = output
+		} else if bytes.Equal(reference, output) == false {
+			return fmt.Errorf("Output with %d jobs (pipelined=%v) differs from output with 1 job", jobs, pipelined)
+		}
+	}
+
+	return nil
+}
+
+func TestPipelinedFlush(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 250000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	var outputs [2][]byte
+
+	for n, pipelined := range []bool{false, true} {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"codec":     "HUFFMAN",
+			"transform": "LZ",
+			"blockSize": uint(16384),
+			"jobs":      uint(2),
+			"checksum":  true,
+			"pipeline":  pipelined,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		for i := 0; i < len(input); i += 50000 {
+			cos.Write(input[i : i+50000])
+
+			// All the data written so far must be in the output after a flush
+			if err = cos.Flush(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			if uint64(bs.Len()) != cos.GetWritten() {
+				b.Fatalf("Pipelined=%v: %d bytes written after flush, expected %d",
+					pipelined, bs.Len(), cos.GetWritten())
+			}
+		}
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		outputs[n] = make([]byte, bs.Len())
+		bs.Read(outputs[n])
+	}
+
+	if bytes.Equal(outputs[0], outputs[1]) == false {
+		b.Fatalf("Pipelined output differs from regular output")
+	}
+
+	var bs util.BufferStream
+	bs.Write(outputs[1])
+	cis, err := kio.NewCompressedInputStream(&bs, 1)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	output := make([]byte, 0, len(input))
+	buf := make([]byte, 65536)
+
+	for {
+		r, err := cis.Read(buf)
+		output = append(output, buf[0:r]...)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if r == 0 {
+			break
+		}
+	}
+
+	if bytes.Equal(input, output) == false {
+		b.Errorf("Decompressed data differs from input")
+	}
+}
+
+func TestPipelinedDecode(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 500000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	blockSize := uint(16384)
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"codec":     "ANS0",
+		"transform": "LZ",
+		"blockSize": blockSize,
+		"jobs":      uint(4),
+		"checksum":  true,
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	cos.Write(input)
+
+	if err = cos.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	compressed := make([]byte, bs.Len())
+	bs.Read(compressed)
+
+	// The number of jobs, the memory cap and the size of the reads must not
+	// change the decompressed data
+	for _, jobs := range []uint{1, 2, 3, 8} {
+		for _, maxBuffered := range []uint{0, 2 * blockSize, 5 * blockSize} {
+			fmt.Printf("Pipelined decoding with %d jobs, %d max buffered bytes\n", jobs, maxBuffered)
+			var ibs util.BufferStream
+			ibs.Write(compressed)
+			dctx := map[string]interface{}{
+				"jobs":        jobs,
+				"pipeline":    true,
+				"maxBuffered": maxBuffered,
+			}
+
+			cis, err := kio.NewCompressedInputStreamWithCtx(&ibs, dctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 1000+int(jobs)*7000)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("Jobs=%d, maxBuffered=%d: decompressed data differs from input", jobs, maxBuffered)
+			}
+
+			cis.Close()
+		}
+	}
+
+	// A cap below 2 blocks is rejected
+	var ibs util.BufferStream
+	ibs.Write(compressed)
+	dctx := map[string]interface{}{
+		"jobs":        uint(2),
+		"pipeline":    true,
+		"maxBuffered": blockSize,
+	}
+
+	cis, err := kio.NewCompressedInputStreamWithCtx(&ibs, dctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 100)); err == nil {
+		b.Errorf("No error for a maximum buffered size of 1 block")
+	}
+
+	// Closing the stream with blocks in flight
+	var ibs2 util.BufferStream
+	ibs2.Write(compressed)
+	dctx["maxBuffered"] = uint(0)
+	cis, err = kio.NewCompressedInputStreamWithCtx(&ibs2, dctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 100)); err != nil {
+		b.Errorf("%v", err)
+	}
+
+	if err = cis.Close(); err != nil {
+		b.Errorf("%v", err)
+	}
+}
+
+func TestChunkedStreams(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 200000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	// Small chunks trigger short reads in the decoder
+	for _, chunk := range []int{1, 7, 1000, 300000} {
+		if err := testChunkedStreams(input, chunk); err != nil {
+			b.Errorf("Chunk size %d: %v", chunk, err)
+		}
+	}
+}
+
+func testChunkedStreams(input []byte, chunk int) error {
+	fmt.Printf("Chunked streams test with chunks of %d bytes\n", chunk)
+	ctx := map[string]interface{}{
+		"codec":     "ANS0",
+		"transform": "TEXT+BWT+RANK+ZRLT",
+		"blockSize": uint(65536),
+		"jobs":      uint(1),
+		"checksum":  true,
+	}
+
+	c, err := kio.NewChunkCompressor(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	compressed := make([]byte, 0)
+
+	for i := 0; i < len(input); i += chunk {
+		end := i + chunk
+
+		if end > len(input) {
+			end = len(input)
+		}
+
+		buf, err := c.Write(input[i:end])
+
+		if err != nil {
+			return err
+		}
+
+		compressed = append(compressed, buf...)
+	}
+
+	buf, err := c.Close()
+
+	if err != nil {
+		return err
+	}
+
+	compressed = append(compressed, buf...)
+	d, err := kio.NewChunkDecompressor(map[string]interface{}{"jobs": uint(1)})
+
+	if err != nil {
+		return err
+	}
+
+	decompressed := make([]byte, 0)
+
+	for i := 0; i < len(compressed); i += chunk {
+		end := i + chunk
+
+		if end > len(compressed) {
+			end = len(compressed)
+		}
+
+		buf, err := d.Write(compressed[i:end])
+		decompressed = append(decompressed, buf...)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	buf, err = d.Close()
+
+	if err != nil {
+		return err
+	}
+
+	decompressed = append(decompressed, buf...)
+
+	if bytes.Equal(input, decompressed) == false {
+		return fmt.Errorf("Decompressed data differs from input")
+	}
+
+	return nil
+}
+
+func TestForeignFormats(b *testing.T) {
+	inputs := map[string][]byte{
+		"zstd":  {0x28, 0xB5, 0x2F, 0xFD, 0x24, 0x05, 0x29, 0x00, 0x00, 0x68},
+		"xz":    {0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00, 0x00, 0x04, 0xE6, 0xD6},
+		"gzip":  {0x1F, 0x8B, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03},
+		"bzip2": []byte("BZh91AY&SY"),
+		"":      []byte("plain text, not compressed"),
+	}
+
+	for name, input := range inputs {
+		var bs util.BufferStream
+		bs.Write(input)
+		cis, err := kio.NewCompressedInputStream(&bs, 1)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		_, err = cis.Read(make([]byte, 100))
+		fmt.Printf("%v: %v\n", name, err)
+
+		if err == nil {
+			b.Errorf("%v: no error for foreign format", name)
+		} else if name != "" && strings.Contains(err.Error(), name+" file detected") == false {
+			b.Errorf("%v: incorrect error: %v", name, err)
+		}
+	}
+}
+
+func TestFormatVersions(b *testing.T) {
+	input := []byte(strings.Repeat("Stream format versions are selected by the header. ", 2000))
+
+	compress := func(syncMarkers bool) []byte {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"codec":       "HUFFMAN",
+			"transform":   "LZ",
+			"blockSize":   uint(16384),
+			"jobs":        uint(1),
+			"checksum":    true,
+			"syncMarkers": syncMarkers,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		res := make([]byte, bs.Len())
+		bs.Read(res)
+		return res
+	}
+
+	decompress := func(buf []byte) ([]byte, int, error) {
+		var ibs util.BufferStream
+		ibs.Write(buf)
+		cis, err := kio.NewCompressedInputStream(&ibs, 4)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		defer cis.Close()
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, len(input))
+		buf = make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		return output, cis.GetVersion(), err
+	}
+
+	// Version 9 is written unless a field of the version 10 header is used
+	compressed := compress(false)
+
+	if version := int(compressed[4] >> 3); version != 9 {
+		b.Errorf("Incorrect version written: %d, expected 9", version)
+	}
+
+	if version := int(compress(true)[4] >> 3); version != kio.BITSTREAM_FORMAT_VERSION {
+		b.Errorf("Incorrect version written with sync markers: %d, expected %d",
+			version, kio.BITSTREAM_FORMAT_VERSION)
+	}
+
+	// The other versions are synthesized from the version 9 stream: only the
+	// header differs (the blocks have the same layout). No stream written by
+	// kanzi 1.7 (version 8) is available: the real decoding of a version 8
+	// stream is not covered.
+	for _, version := range []int{7, 8, 9, 10, 11} {
+		fmt.Printf("Decoding stream format version %d\n", version)
+		buf := append([]byte{}, compressed...)
+
+		if version >= 10 {
+			// Insert the bytes of the entropy segments, TPAQ memory budget
+			// and flags (added in version 10) after the 16 byte header
+			buf = append(buf[0:16], append([]byte{0, 0, 0}, buf[16:]...)...)
+		}
+
+		// Version (5 bits after the stream type)
+		buf[4] = (buf[4] & 0x07) | byte(version<<3)
+
+		if version < 9 {
+			// Clear the 6 bits of the block count (reserved before version 9)
+			buf[14] &= 0xFE
+			buf[15] &= 0x07
+		}
+
+		output, decoded, err := decompress(buf)
+
+		if kio.CanDecode(version) == false {
+			if err == nil || strings.Contains(err.Error(), "stream format version") == false {
+				b.Errorf("Version %d: expected version error, got %v", version, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			b.Errorf("Version %d: %v", version, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("Version %d: decompressed data differs from input", version)
+		} else if decoded != version {
+			b.Errorf("Version %d: incorrect version reported: %d", version, decoded)
+		}
+	}
+}
+
+// Streams of format version 9 must be decodable by kanzi 1.8: LZ blocks do
+// not use the repeat codes unless lzRepeat forces version 10. The decoder
+// rejects the repeat flag in a version 9 stream.
+func TestLZFormatVersion(b *testing.T) {
+	input, err := ioutil.ReadFile("testdata/mixed.bin")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Records with the same distance between matches (best case of the
+	// repeat codes)
+	record := make([]byte, 0, 4<<20)
+	rnd := rand.New(rand.NewSource(12345))
+
+	for i := 0; i < cap(record); i++ {
+		if i < 200 || rnd.Intn(24) == 0 {
+			record = append(record, byte(rnd.Intn(256)))
+		} else {
+			record = append(record, record[i-200])
+		}
+	}
+
+	compress := func(data []byte, ctx map[string]interface{}) []byte {
+		var bs util.BufferStream
+		ctx["jobs"] = uint(1)
+		ctx["checksum"] = true
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(data)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		res := make([]byte, bs.Len())
+		bs.Read(res)
+		return res
+	}
+
+	decompress := func(buf []byte, size int) ([]byte, error) {
+		cis, err := kio.NewCompressedInputStream(util.NewBufferStream(buf), 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer cis.Close()
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, size)
+		buf = make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		return output, err
+	}
+
+	for _, data := range [][]byte{input, record} {
+		for _, transform := range []string{"LZ", "TEXT+LZ", "LZP+LZ"} {
+			for _, codec := range []string{"NONE", "HUFFMAN", "ANS1"} {
+				for _, blockSize := range []uint{512 * 1024, 8 * 1024 * 1024} {
+					for _, repeat := range []int{-1, 0, 1} {
+						ctx := map[string]interface{}{
+							"transform": transform,
+							"codec":     codec,
+							"blockSize": blockSize,
+						}
+
+						// -1: default
+						expected := 9
+
+						if repeat >= 0 {
+							ctx["lzRepeat"] = repeat == 1
+
+							if repeat == 1 {
+								expected = kio.BITSTREAM_FORMAT_VERSION
+							}
+						}
+
+						compressed := compress(data, ctx)
+
+						if version := int(compressed[4] >> 3); version != expected {
+							b.Errorf("%v&%v, block %v, repeat %v: incorrect version written: %d, expected %d",
+								transform, codec, blockSize, repeat, version, expected)
+							continue
+						}
+
+						output, err := decompress(compressed, len(data))
+
+						if err != nil {
+							b.Errorf("%v&%v, block %v, repeat %v: %v", transform, codec, blockSize, repeat, err)
+						} else if bytes.Equal(data, output) == false {
+							b.Errorf("%v&%v, block %v, repeat %v: decompressed data differs from input",
+								transform, codec, blockSize, repeat)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// A block with repeat codes in a version 9 stream
+	ctx := map[string]interface{}{"lzRepeat": true}
+	f, _ := function.NewLZCodecWithCtx(&ctx)
+	output := make([]byte, f.MaxEncodedLen(len(record)))
+	_, dstIdx, err := f.Forward(record, output)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	ctx = map[string]interface{}{"bsVersion": uint(9)}
+	f, _ = function.NewLZCodecWithCtx(&ctx)
+
+	if _, _, err = f.Inverse(output[0:dstIdx], make([]byte, len(record))); err == nil {
+		b.Errorf("Repeat codes in a version 9 stream: expected error")
+	} else {
+		fmt.Printf("Repeat codes in a version 9 stream: %v\n", err)
+	}
+
+	ctx = map[string]interface{}{"bsVersion": uint(9), "lzRepeat": true}
+
+	if _, err = function.NewLZCodecWithCtx(&ctx); err == nil {
+		b.Errorf("Repeat codes enabled in a version 9 stream: expected error")
+	}
+}
+
+// RLT blocks of a version 9 stream use the original format (one byte escape
+// and 1 to 3 byte run lengths), decodable by kanzi 1.8.
+func TestRLTFormatVersion(b *testing.T) {
+	// All the byte values occur: the two byte escape is cheaper
+	rnd := rand.New(rand.NewSource(12345))
+	input := make([]byte, 0, 1<<20)
+
+	for len(input) < cap(input) {
+		if rnd.Intn(1024) == 0 {
+			input = append(input, bytes.Repeat([]byte{byte(rnd.Intn(256))}, 4+rnd.Intn(100))...)
+		} else {
+			input = append(input, byte(rnd.Intn(256)))
+		}
+	}
+
+	input = input[0:cap(input)]
+
+	compress := func(params map[string]interface{}) []byte {
+		var bs util.BufferStream
+		ctx := make(map[string]interface{})
+
+		for k, v := range params {
+			ctx[k] = v
+		}
+
+		ctx["transform"] = "RLT"
+		ctx["codec"] = "NONE"
+		ctx["blockSize"] = uint(256 * 1024)
+		ctx["jobs"] = uint(1)
+		ctx["checksum"] = true
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		res := make([]byte, bs.Len())
+		bs.Read(res)
+		return res
+	}
+
+	decompress := func(buf []byte) ([]byte, error) {
+		cis, err := kio.NewCompressedInputStream(util.NewBufferStream(buf), 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer cis.Close()
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, len(input))
+		buf = make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		return output, err
+	}
+
+	tests := []struct {
+		params   map[string]interface{}
+		expected int
+	}{
+		{map[string]interface{}{}, 9},
+		{map[string]interface{}{"rltEscape": uint(1)}, 9},
+		{map[string]interface{}{"rltRunWidth": uint(1)}, 9},
+		{map[string]interface{}{"rltEscape": uint(0)}, kio.BITSTREAM_FORMAT_VERSION},
+		{map[string]interface{}{"rltEscape": uint(2)}, kio.BITSTREAM_FORMAT_VERSION},
+		{map[string]interface{}{"rltRunWidth": uint(2)}, kio.BITSTREAM_FORMAT_VERSION},
+		{map[string]interface{}{"syncMarkers": true}, kio.BITSTREAM_FORMAT_VERSION},
+	}
+
+	sizes := make([]int, len(tests))
+
+	for i, test := range tests {
+		compressed := compress(test.params)
+		sizes[i] = len(compressed)
+		fmt.Printf("Parameters %v: %v => %v\n", test.params, len(input), len(compressed))
+
+		if version := int(compressed[4] >> 3); version != test.expected {
+			b.Errorf("Parameters %v: incorrect version written: %d, expected %d", test.params, version, test.expected)
+			continue
+		}
+
+		output, err := decompress(compressed)
+
+		if err != nil {
+			b.Errorf("Parameters %v: %v", test.params, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("Parameters %v: decompressed data differs from input", test.params)
+		}
+	}
+
+	// The automatic escape of version 10 streams picks the two byte escape
+	if sizes[len(tests)-1] >= sizes[0] {
+		b.Errorf("The two byte escape should compress better: %v >= %v", sizes[len(tests)-1], sizes[0])
+	}
+
+	// A block with a two byte escape in a version 9 stream
+	ctx := map[string]interface{}{"rltEscape": uint(2)}
+	f, _ := function.NewRLTWithCtx(&ctx)
+	output := make([]byte, f.MaxEncodedLen(len(input)))
+	_, dstIdx, err := f.Forward(input, output)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	ctx = map[string]interface{}{"bsVersion": uint(9)}
+	f, _ = function.NewRLTWithCtx(&ctx)
+
+	if _, _, err = f.Inverse(output[0:dstIdx], make([]byte, len(input))); err == nil {
+		b.Errorf("Two byte escape in a version 9 stream: expected error")
+	} else {
+		fmt.Printf("Two byte escape in a version 9 stream: %v\n", err)
+	}
+
+	if _, err = f.InverseInPlace(output, dstIdx); err == nil {
+		b.Errorf("Two byte escape in a version 9 stream (in place): expected error")
+	}
+
+	for _, key := range []string{"rltEscape", "rltRunWidth"} {
+		ctx = map[string]interface{}{"bsVersion": uint(9), key: uint(2)}
+
+		if _, err = function.NewRLTWithCtx(&ctx); err == nil {
+			b.Errorf("%v 2 in a version 9 stream: expected error", key)
+		}
+	}
+}
+
+// SRT chunks force version 10: kanzi 1.8 decodes each block as one chunk
+func TestSRTFormatVersion(b *testing.T) {
+	input, err := ioutil.ReadFile("testdata/mixed.bin")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for _, chunkSize := range []uint{0, 1 << 12} {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform":    "BWT+SRT",
+			"codec":        "NONE",
+			"blockSize":    uint(1 << 20),
+			"jobs":         uint(1),
+			"checksum":     true,
+			"srtChunkSize": chunkSize,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		compressed := make([]byte, bs.Len())
+		bs.Read(compressed)
+		fmt.Printf("Chunk size %d: %v => %v\n", chunkSize, len(input), len(compressed))
+		expected := 9
+
+		if chunkSize != 0 {
+			expected = kio.BITSTREAM_FORMAT_VERSION
+		}
+
+		if version := int(compressed[4] >> 3); version != expected {
+			b.Errorf("Chunk size %d: incorrect version written: %d, expected %d", chunkSize, version, expected)
+		}
+
+		cis, err := kio.NewCompressedInputStream(util.NewBufferStream(compressed), 1)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		cis.Close()
+
+		if err != nil {
+			b.Errorf("Chunk size %d: %v", chunkSize, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("Chunk size %d: decompressed data differs from input", chunkSize)
+		}
+	}
+}
+
+// mixed_l7.knz and mixed_l8.knz were compressed by kanzi 1.8 (format version
+// 9) at levels 7 (TPAQ) and 8 (TPAQX) with checksums: the decoding depends on
+// the exact squash and stretch tables of the models (see also TestVerifyReferences).
+func TestReferenceStreams(b *testing.T) {
+	input, err := ioutil.ReadFile("testdata/mixed.bin")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for _, name := range []string{"mixed_l7.knz", "mixed_l8.knz"} {
+		fmt.Printf("Decoding %v\n", name)
+		compressed, err := ioutil.ReadFile("testdata/" + name)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cis, err := kio.NewCompressedInputStream(util.NewBufferStream(compressed), 1)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		if err != nil {
+			b.Errorf("%v: %v", name, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("%v: decompressed data differs from original", name)
+		} else if cis.GetVersion() != 9 {
+			b.Errorf("%v: incorrect version: %d", name, cis.GetVersion())
+		}
+
+		cis.Close()
+	}
+}
+
+func TestAlignedAlloc(b *testing.T) {
+	// Disabled by default: plain slices
+	var alloc *util.Allocator
+
+	if alloc = util.NewAllocatorWithCtx(&map[string]interface{}{}); alloc != nil {
+		b.Errorf("Allocator created without the alignedAlloc parameter")
+	}
+
+	if buf := alloc.MakeInt32(100); len(buf) != 100 {
+		b.Errorf("Incorrect size: %d", len(buf))
+	}
+
+	ctx := map[string]interface{}{"alignedAlloc": true, "alignThreshold": uint(4096)}
+	alloc = util.NewAllocatorWithCtx(&ctx)
+
+	for _, n := range []int{1024, 4096, 100000} {
+		fmt.Printf("Aligned allocation of %d bytes\n", n)
+		buf := alloc.MakeBytes(n)
+		sa := alloc.MakeInt32(n)
+
+		if len(buf) != n || len(sa) != n {
+			b.Errorf("Incorrect size: %d, %d", len(buf), len(sa))
+		}
+
+		if n >= 4096 {
+			if uintptr(unsafe.Pointer(&buf[0]))&4095 != 0 || uintptr(unsafe.Pointer(&sa[0]))&4095 != 0 {
+				b.Errorf("Buffer of %d bytes not page aligned", n)
+			}
+		}
+
+		for i := range buf {
+			buf[i] = byte(i)
+		}
+
+		grown := alloc.GrowBytes(buf, 2*n)
+
+		if len(grown) != 2*n || bytes.Equal(grown[0:n], buf) == false {
+			b.Errorf("Incorrect content after growing a buffer of %d bytes", n)
+		}
+	}
+
+	// Round trip with aligned block buffers and suffix arrays
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	for _, transform := range []string{"BWT", "BWTS"} {
+		fmt.Printf("Aligned allocation with %v\n", transform)
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"codec":          "HUFFMAN",
+			"transform":      transform,
+			"blockSize":      uint(131072),
+			"jobs":           uint(2),
+			"checksum":       true,
+			"alignedAlloc":   true,
+			"alignThreshold": uint(65536),
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		dctx := map[string]interface{}{
+			"jobs":           uint(2),
+			"alignedAlloc":   true,
+			"alignThreshold": uint(65536),
+		}
+
+		cis, err := kio.NewCompressedInputStreamWithCtx(&bs, dctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, len(input)+1)
+		n := 0
+
+		for n < len(output) {
+			r, err := cis.Read(output[n:])
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			if r == 0 {
+				break
+			}
+
+			n += r
+		}
+
+		if bytes.Equal(input, output[0:n]) == false {
+			b.Errorf("%v: decompressed data differs from input", transform)
+		}
+
+		cis.Close()
+	}
+}
+
+func TestAutoTune(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "\n"}
+	var text bytes.Buffer
+
+	for text.Len() < 300000 {
+		text.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	input := text.Bytes()
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"codec":          "NONE",
+		"transform":      "NONE",
+		"blockSize":      uint(65536),
+		"jobs":           uint(2),
+		"checksum":       true,
+		"autoTune":       true,
+		"tuneCandidates": "NONE&NONE, LZ&HUFFMAN, BWT+RANK+ZRLT&ANS0",
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	cos.Write(input)
+
+	if err = cos.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	fmt.Printf("Tuned pipeline: %v, %d => %d bytes\n", cos.Pipeline(), len(input), bs.Len())
+
+	if cos.Pipeline() != "BWT+RANK+ZRLT&ANS0" {
+		b.Errorf("Unexpected tuned pipeline: %v", cos.Pipeline())
+	}
+
+	// The decoder reads the tuned pipeline from the header
+	cis, err := kio.NewCompressedInputStreamWithCtx(&bs, map[string]interface{}{"jobs": uint(2)})
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	output := make([]byte, 0, len(input))
+	buf := make([]byte, 50000)
+
+	for {
+		r, err := cis.Read(buf)
+		output = append(output, buf[0:r]...)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if r == 0 {
+			break
+		}
+	}
+
+	if bytes.Equal(input, output) == false {
+		b.Errorf("Decompressed data differs from input")
+	}
+
+	cis.Close()
+}
+
+func TestManualMemory(b *testing.T) {
+	ctx := map[string]interface{}{"manualMemory": true}
+	alloc := util.NewAllocatorWithCtx(&ctx)
+	pool := util.SharedPool
+	pool.Trim()
+	buf := alloc.MakeBytes(1 << 20)
+
+	for i := range buf {
+		buf[i] = 0xAA
+	}
+
+	alloc.ReleaseBytes(buf)
+
+	if pool.Retained() != 1<<20 {
+		b.Errorf("Incorrect retained size: %d", pool.Retained())
+	}
+
+	// The released buffer is reused, cleared
+	buf2 := alloc.MakeBytes(1<<20 - 100)
+
+	if &buf2[0] != &buf[0] || buf2[1000] != 0 || pool.Retained() != 0 {
+		b.Errorf("Released buffer not reused")
+	}
+
+	alloc.ReleaseInt32(alloc.MakeInt32(1 << 18))
+	pool.SetMaxRetained(1 << 19)
+
+	if pool.Retained() != 0 {
+		b.Errorf("Incorrect retained size after eviction: %d", pool.Retained())
+	}
+
+	pool.SetMaxRetained(util.DEFAULT_POOL_SIZE)
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 600000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	for _, transform := range []string{"BWT+RANK+ZRLT", "ROLZ", "BWTS", "ST4+RANK+ZRLT"} {
+		for n := 0; n < 2; n++ {
+			fmt.Printf("Manual memory mode with %v (pool: %d bytes)\n", transform, pool.Retained())
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"codec":        "ANS0",
+				"transform":    transform,
+				"blockSize":    uint(262144),
+				"jobs":         uint(2),
+				"checksum":     true,
+				"manualMemory": true,
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			cos.Write(input)
+
+			if err = cos.Release(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			dctx := map[string]interface{}{
+				"jobs":         uint(2),
+				"pipeline":     n == 1,
+				"manualMemory": true,
+			}
+
+			cis, err := kio.NewCompressedInputStreamWithCtx(&bs, dctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 65536)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if err = cis.Release(); err != nil {
+				b.Errorf("%v", err)
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v: decompressed data differs from input", transform)
+			}
+
+			if pool.Retained() == 0 {
+				b.Errorf("%v: no buffer returned to the pool", transform)
+			}
+		}
+	}
+
+	pool.Trim()
+}
+
+func TestWarmStart(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "zeta ", "eta ", "theta ", "\n"}
+	var text bytes.Buffer
+
+	for text.Len() < 100000 {
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteByte(byte(48 + rnd.Intn(10)))
+	}
+
+	input := text.Bytes()
+	var cold []byte
+
+	for _, p := range [][2]string{{"LZ", "NONE"}, {"LZ", "HUFFMAN"}, {"NONE", "CM"}, {"TEXT", "TPAQ"}} {
+		sizes := [2]int{}
+
+		for i, warm := range []bool{false, true} {
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"transform": p[0],
+				"codec":     p[1],
+				"blockSize": uint(1024),
+				"jobs":      uint(4),
+				"checksum":  true,
+				"warmStart": warm,
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			// Flush in the middle: the statistics survive
+			cos.Write(input[0 : len(input)/2])
+			cos.Flush()
+			cos.Write(input[len(input)/2:])
+
+			if err = cos.Close(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			compressed := make([]byte, bs.Len())
+			bs.Read(compressed)
+			sizes[i] = len(compressed)
+
+			// The warm start flag requires version 10
+			expected := 9
+
+			if warm == true {
+				expected = kio.BITSTREAM_FORMAT_VERSION
+			} else {
+				cold = compressed
+			}
+
+			if version := int(compressed[4] >> 3); version != expected {
+				b.Errorf("%v&%v warm=%v: incorrect version written: %d, expected %d", p[0], p[1], warm, version, expected)
+			}
+
+			cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed),
+				map[string]interface{}{"jobs": uint(4), "pipeline": true})
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 5000)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v&%v warm=%v: %v", p[0], p[1], warm, err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v&%v warm=%v: decompressed data differs from input", p[0], p[1], warm)
+			}
+
+			cis.Close()
+		}
+
+		fmt.Printf("%v&%v: cold start %d bytes, warm start %d bytes\n", p[0], p[1], sizes[0], sizes[1])
+
+		if sizes[1] >= sizes[0] {
+			b.Errorf("%v&%v: no gain with warm start (%d >= %d bytes)", p[0], p[1], sizes[1], sizes[0])
+		}
+	}
+
+	// Set the warm start flag (bit 125 of the header) of a version 9 stream
+	cold[15] |= 0x04
+	cis, err := kio.NewCompressedInputStream(util.NewBufferStream(cold), 1)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	_, err = cis.Read(make([]byte, 1024))
+
+	if err == nil || strings.Contains(err.Error(), "warm start flag") == false {
+		b.Errorf("Warm start flag in a version 9 stream: expected error, got %v", err)
+	} else {
+		fmt.Printf("Warm start flag in a version 9 stream: %v\n", err)
+	}
+}
+
+func TestWarmROLZ(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "zeta ", "eta ", "theta ", "\n"}
+	var text bytes.Buffer
+
+	for text.Len() < 200000 {
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteString(words[rnd.Intn(len(words))])
+		text.WriteByte(byte(48 + rnd.Intn(10)))
+	}
+
+	input := text.Bytes()
+
+	for _, transform := range []string{"ROLZ", "ROLZX", "TEXT+ROLZ"} {
+		sizes := [2]int{}
+
+		for i, rolz := range []bool{false, true} {
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"transform": transform,
+				"codec":     "NONE",
+				"blockSize": uint(4096),
+				"jobs":      uint(1),
+				"checksum":  true,
+				"warmStart": true,
+				"warmROLZ":  rolz,
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			// The ROLZ dictionary restarts after each flush
+			for n := 0; n < len(input); n += 50000 {
+				end := n + 50000
+
+				if end > len(input) {
+					end = len(input)
+				}
+
+				cos.Write(input[n:end])
+				cos.Flush()
+			}
+
+			if err = cos.Close(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			sizes[i] = bs.Len()
+			cis, err := kio.NewCompressedInputStream(&bs, 1)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 5000)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v warmROLZ=%v: %v", transform, rolz, err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v warmROLZ=%v: decompressed data differs from input", transform, rolz)
+			}
+
+			cis.Close()
+		}
+
+		fmt.Printf("%v: warm start %d bytes, with ROLZ dictionary %d bytes\n", transform, sizes[0], sizes[1])
+
+		if sizes[1] >= sizes[0] {
+			b.Errorf("%v: no gain with the ROLZ dictionary (%d >= %d bytes)", transform, sizes[1], sizes[0])
+		}
+	}
+
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform": "ROLZ",
+		"codec":     "NONE",
+		"blockSize": uint(4096),
+		"jobs":      uint(1),
+		"warmROLZ":  true,
+	}
+
+	if _, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx); err == nil {
+		b.Errorf("No error for ROLZ dictionary persistence without warm start")
+	}
+}
+
+func TestANSInterleaveStream(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&31))
+	}
+
+	var single []byte
+
+	for _, codec := range []string{"ANS0", "ANS1"} {
+		for _, interleave := range []uint{1, 2, 4} {
+			fmt.Printf("Stream test for %v with %d interleaved states\n", codec, interleave)
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"transform":     "NONE",
+				"codec":         codec,
+				"blockSize":     uint(65536),
+				"jobs":          uint(2),
+				"checksum":      true,
+				"ansInterleave": interleave,
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			cos.Write(input)
+
+			if err = cos.Close(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			compressed := make([]byte, bs.Len())
+			bs.Read(compressed)
+
+			// Several interleaved states require version 10
+			expected := 9
+
+			if interleave > 1 {
+				expected = kio.BITSTREAM_FORMAT_VERSION
+			} else {
+				single = compressed
+			}
+
+			if version := int(compressed[4] >> 3); version != expected {
+				b.Errorf("%v interleave=%d: incorrect version written: %d, expected %d", codec, interleave, version, expected)
+			}
+
+			// The decoder reads the number of states from the header
+			cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed),
+				map[string]interface{}{"jobs": uint(2)})
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 65536)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v interleave=%d: %v", codec, interleave, err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v interleave=%d: decompressed data differs from input", codec, interleave)
+			}
+
+			cis.Close()
+		}
+	}
+
+	// Set the interleave factor (bits 126 and 127 of the header) of a
+	// version 9 stream
+	single[15] |= 0x01
+	cis, err := kio.NewCompressedInputStream(util.NewBufferStream(single), 1)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 1024)); err == nil || strings.Contains(err.Error(), "interleave") == false {
+		b.Errorf("Interleave factor in a version 9 stream: expected error, got %v", err)
+	} else {
+		fmt.Printf("Interleave factor in a version 9 stream: %v\n", err)
+	}
+
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform":     "NONE",
+		"codec":         "ANS0",
+		"blockSize":     uint(65536),
+		"jobs":          uint(1),
+		"checksum":      false,
+		"ansInterleave": uint(3),
+	}
+
+	if _, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx); err == nil {
+		b.Errorf("No error for an invalid interleave factor")
+	}
+}
+
+func TestTPAQMemory(b *testing.T) {
+	input := []byte(strings.Repeat("The memory budget of the TPAQ model is stored in the header. ", 5000))
+
+	for _, codec := range []string{"TPAQ", "TPAQX"} {
+		for _, memory := range []uint{0, 16, 64} {
+			fmt.Printf("Stream test for %v with a memory budget of %d MB\n", codec, memory)
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"transform": "NONE",
+				"codec":     codec,
+				"blockSize": uint(1 << 20),
+				"jobs":      uint(1),
+				"checksum":  true,
+			}
+
+			if memory != 0 {
+				ctx["tpaqMemory"] = memory
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			cos.Write(input)
+
+			if err = cos.Close(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			// The decoder reads the memory budget from the header
+			cis, err := kio.NewCompressedInputStreamWithCtx(&bs, map[string]interface{}{"jobs": uint(1)})
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 65536)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v memory=%d: %v", codec, memory, err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v memory=%d: decompressed data differs from input", codec, memory)
+			}
+
+			cis.Close()
+		}
+	}
+
+	for _, memory := range []uint{8, 48, 2048} {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform":  "NONE",
+			"codec":      "TPAQ",
+			"blockSize":  uint(65536),
+			"jobs":       uint(1),
+			"checksum":   false,
+			"tpaqMemory": memory,
+		}
+
+		if _, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx); err == nil {
+			b.Errorf("No error for an invalid TPAQ memory budget: %d MB", memory)
+		}
+	}
+}
+
+func TestEntropySegments(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 1500000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&31))
+	}
+
+	for _, codec := range []string{"HUFFMAN", "ANS0", "TPAQ"} {
+		var reference []byte
+
+		for _, jobs := range []uint{1, 4} {
+			fmt.Printf("Stream test for %v with 8 entropy segments and %d jobs\n", codec, jobs)
+			var bs util.BufferStream
+			ctx := map[string]interface{}{
+				"transform":       "NONE",
+				"codec":           codec,
+				"blockSize":       uint(1 << 20),
+				"jobs":            jobs,
+				"checksum":        true,
+				"entropySegments": uint(8),
+			}
+
+			cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			cos.Write(input)
+
+			if err = cos.Close(); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			compressed := make([]byte, bs.Len())
+			bs.Read(compressed)
+
+			// The output does not depend on the number of jobs
+			if jobs == 1 {
+				reference = compressed
+			} else if bytes.Equal(reference, compressed) == false {
+				b.Errorf("%v: the output depends on the number of jobs", codec)
+			}
+
+			// The decoder reads the number of segments from the header
+			cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed), map[string]interface{}{"jobs": jobs})
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			output := make([]byte, 0, len(input))
+			buf := make([]byte, 65536)
+
+			for {
+				r, err := cis.Read(buf)
+				output = append(output, buf[0:r]...)
+
+				if err != nil {
+					b.Fatalf("%v jobs=%d: %v", codec, jobs, err)
+				}
+
+				if r == 0 {
+					break
+				}
+			}
+
+			if bytes.Equal(input, output) == false {
+				b.Errorf("%v jobs=%d: decompressed data differs from input", codec, jobs)
+			}
+
+			cis.Close()
+		}
+	}
+
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform":       "NONE",
+		"codec":           "HUFFMAN",
+		"blockSize":       uint(65536),
+		"jobs":            uint(1),
+		"checksum":        false,
+		"warmStart":       true,
+		"entropySegments": uint(4),
+	}
+
+	if _, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx); err == nil {
+		b.Errorf("No error for entropy segments with warm start")
+	}
+}
+
+// xorCodec a trivial external entropy codec (bytes xored with a constant)
+type xorCodec struct{}
+
+type xorEncoder struct {
+	obs kanzi.OutputBitStream
+}
+
+type xorDecoder struct {
+	ibs kanzi.InputBitStream
+}
+
+func (this xorCodec) Name() string {
+	return "Xor"
+}
+
+func (this xorCodec) NewEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{}) (kanzi.EntropyEncoder, error) {
+	return &xorEncoder{obs: obs}, nil
+}
+
+func (this xorCodec) NewDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{}) (kanzi.EntropyDecoder, error) {
+	return &xorDecoder{ibs: ibs}, nil
+}
+
+func (this xorCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
+
+func (this *xorEncoder) Write(block []byte) (int, error) {
+	buf := make([]byte, len(block))
+
+	for i := range block {
+		buf[i] = block[i] ^ 0x5A
+	}
+
+	this.obs.WriteArray(buf, uint(8*len(buf)))
+	return len(block), nil
+}
+
+func (this *xorEncoder) BitStream() kanzi.OutputBitStream {
+	return this.obs
+}
+
+func (this *xorEncoder) Dispose() {
+}
+
+func (this *xorDecoder) Read(block []byte) (int, error) {
+	this.ibs.ReadArray(block, uint(8*len(block)))
+
+	for i := range block {
+		block[i] ^= 0x5A
+	}
+
+	return len(block), nil
+}
+
+func (this *xorDecoder) BitStream() kanzi.InputBitStream {
+	return this.ibs
+}
+
+func (this *xorDecoder) Dispose() {
+}
+
+func TestCodecRegistry(b *testing.T) {
+	id := entropy.FIRST_EXPERIMENTAL_TYPE
+
+	if err := entropy.RegisterCodec(entropy.HUFFMAN_TYPE, xorCodec{}); err == nil {
+		b.Errorf("No error when registering a built-in entropy type")
+	}
+
+	if err := entropy.RegisterCodec(id, xorCodec{}); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer entropy.UnregisterCodec(id)
+
+	if err := entropy.RegisterCodec(id+1, xorCodec{}); err == nil {
+		b.Errorf("No error when registering a name twice")
+	}
+
+	if entropy.GetType("xor") != id || entropy.GetName(id) != "XOR" {
+		b.Errorf("Incorrect type or name of the registered codec")
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	for _, transform := range []string{"NONE", "LZ"} {
+		fmt.Printf("Stream test for %v&XOR (registered codec)\n", transform)
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform": transform,
+			"codec":     "XOR",
+			"blockSize": uint(65536),
+			"jobs":      uint(2),
+			"checksum":  true,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		compressed := make([]byte, bs.Len())
+		bs.Read(compressed)
+		cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed), map[string]interface{}{"jobs": uint(2)})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err != nil {
+				b.Fatalf("%v: %v", transform, err)
+			}
+
+			if r == 0 {
+				break
+			}
+		}
+
+		if bytes.Equal(input, output) == false {
+			b.Errorf("%v: decompressed data differs from input", transform)
+		}
+
+		cis.Close()
+	}
+}
+
+// deltaTransform a trivial external transform (difference with the previous byte)
+type deltaTransform struct{}
+
+func (this deltaTransform) Forward(src, dst []byte) (uint, uint, error) {
+	prev := byte(0)
+
+	for i := range src {
+		dst[i] = src[i] - prev
+		prev = src[i]
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this deltaTransform) Inverse(src, dst []byte) (uint, uint, error) {
+	prev := byte(0)
+
+	for i := range src {
+		prev += src[i]
+		dst[i] = prev
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func TestTransformRegistry(b *testing.T) {
+	id := function.FIRST_EXPERIMENTAL_TYPE
+	factory := func(ctx map[string]interface{}) (kanzi.ByteTransform, error) {
+		return deltaTransform{}, nil
+	}
+
+	if err := function.RegisterTransform(function.LZ_TYPE, "MYDELTA", factory); err == nil {
+		b.Errorf("No error when registering a built-in transform type")
+	}
+
+	if err := function.RegisterTransform(id, "DELTA", factory); err == nil {
+		b.Errorf("No error when registering a built-in transform name")
+	}
+
+	if err := function.RegisterTransform(id, "mydelta", factory); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer function.UnregisterTransform(id)
+
+	if err := function.RegisterTransform(id+1, "MYDELTA", factory); err == nil {
+		b.Errorf("No error when registering a name twice")
+	}
+
+	if function.GetName(function.GetType("mydelta+lz")) != "MYDELTA+LZ" {
+		b.Errorf("Incorrect type or name of the registered transform")
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 300000)
+	val := byte(0)
+
+	for i := range input {
+		val += byte(rnd.Intn(3))
+		input[i] = val
+	}
+
+	for _, transform := range []string{"MYDELTA", "MYDELTA+LZ", "RLT+MYDELTA"} {
+		fmt.Printf("Stream test for %v&HUFFMAN (registered transform)\n", transform)
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform": transform,
+			"codec":     "HUFFMAN",
+			"blockSize": uint(65536),
+			"jobs":      uint(2),
+			"checksum":  true,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		compressed := make([]byte, bs.Len())
+		bs.Read(compressed)
+		cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed), map[string]interface{}{"jobs": uint(2)})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err != nil {
+				b.Fatalf("%v: %v", transform, err)
+			}
+
+			if r == 0 {
+				break
+			}
+		}
+
+		if bytes.Equal(input, output) == false {
+			b.Errorf("%v: decompressed data differs from input", transform)
+		}
+
+		cis.Close()
+	}
+}
+
+func TestTextDictionaryStream(b *testing.T) {
+	input := []byte(strings.Repeat("The decoder verifies the checksum of the dictionary in the header. ", 1000))
+	dict := []byte("TheDecoderVerifiesChecksumOfDictionaryInHeader")
+	other := []byte("TheEncoderWritesChecksumOfDictionaryInHeader")
+
+	file, err := ioutil.TempFile("", "kanzi-dict-")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer os.Remove(file.Name())
+	file.Write(dict)
+	file.Close()
+
+	cases := []struct {
+		encode map[string]interface{}
+		decode map[string]interface{}
+		valid  bool
+	}{
+		{nil, nil, true},
+		{nil, map[string]interface{}{"textDictionary": dict}, true},
+		{map[string]interface{}{"textDictionary": dict}, map[string]interface{}{"textDictionary": dict}, true},
+		{map[string]interface{}{"textDictionaryFile": file.Name()}, map[string]interface{}{"textDictionary": dict}, true},
+		{map[string]interface{}{"textDictionary": dict}, map[string]interface{}{"textDictionaryFile": file.Name()}, true},
+		{map[string]interface{}{"textDictionary": dict}, nil, false},
+		{map[string]interface{}{"textDictionary": dict}, map[string]interface{}{"textDictionary": other}, false},
+	}
+
+	for i, c := range cases {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform": "TEXT",
+			"codec":     "HUFFMAN",
+			"blockSize": uint(1 << 16),
+			"jobs":      uint(1),
+			"checksum":  true,
+		}
+
+		for k, v := range c.encode {
+			ctx[k] = v
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("Case %d: %v", i, err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("Case %d: %v", i, err)
+		}
+
+		fmt.Printf("Case %d: %d => %d bytes\n", i, len(input), bs.Len())
+		dctx := map[string]interface{}{"jobs": uint(1)}
+
+		for k, v := range c.decode {
+			dctx[k] = v
+		}
+
+		cis, err := kio.NewCompressedInputStreamWithCtx(&bs, dctx)
+
+		if err != nil {
+			b.Fatalf("Case %d: %v", i, err)
+		}
+
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		cis.Close()
+
+		if c.valid == false {
+			if err == nil || strings.Contains(err.Error(), "text dictionary") == false {
+				b.Errorf("Case %d: expected text dictionary error, got %v", i, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			b.Errorf("Case %d: %v", i, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("Case %d: decompressed data differs from input", i)
+		}
+	}
+
+	ctx := map[string]interface{}{
+		"transform":          "TEXT",
+		"codec":              "HUFFMAN",
+		"blockSize":          uint(1 << 16),
+		"jobs":               uint(1),
+		"checksum":           false,
+		"textDictionaryFile": file.Name() + ".missing",
+	}
+
+	if _, err := kio.NewCompressedOutputStreamWithCtx(&util.BufferStream{}, ctx); err == nil {
+		b.Errorf("No error for a missing text dictionary file")
+	}
+}
+
+func TestSyncMarkers(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	blockSize := 4096
+	input := make([]byte, 40*blockSize)
+
+	for i := range input {
+		input[i] = byte('a' + rnd.Intn(8))
+	}
+
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform":   "LZ",
+		"codec":       "HUFFMAN",
+		"blockSize":   uint(blockSize),
+		"jobs":        uint(4),
+		"checksum":    true,
+		"syncMarkers": true,
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	cos.Write(input)
+
+	if err = cos.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	compressed := make([]byte, bs.Len())
+	bs.Read(compressed)
+
+	// One sync marker per block and one for the end block
+	if n := bytes.Count(compressed, []byte("KSYN")); n < 41 {
+		b.Errorf("Incorrect number of sync markers: %d, expected 41", n)
+	}
+
+	for test := 0; test < 12; test++ {
+		buf := append([]byte{}, compressed...)
+		start := 20 + rnd.Intn(len(buf)-40)
+
+		switch test % 3 {
+		case 0:
+			// Intact stream
+			start = -1
+		case 1:
+			// One bit flipped
+			buf[start] ^= byte(1 << uint(rnd.Intn(8)))
+		default:
+			// Zeroed area (up to the end of the stream)
+			for i := start; i < start+2000 && i < len(buf); i++ {
+				buf[i] = 0
+			}
+		}
+
+		jobs := uint(1 + 3*(test&1))
+		pipelined := test&2 != 0
+		fmt.Printf("Recovery test: corruption at %d, jobs=%d, pipelined=%v\n", start, jobs, pipelined)
+		var ibs util.BufferStream
+		ibs.Write(buf)
+		cis, err := kio.NewCompressedInputStreamWithCtx(&ibs, map[string]interface{}{
+			"jobs":     jobs,
+			"pipeline": pipelined,
+			"recover":  true,
+		})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output, err := readSyncMarkersStream(cis)
+
+		if err != nil {
+			b.Errorf("Corruption at %d: %v", start, err)
+			continue
+		}
+
+		lost := cis.GetLostBlocks()
+		cis.Close()
+
+		if start < 0 && (lost != 0 || bytes.Equal(input, output) == false) {
+			b.Errorf("Intact stream: %d lost blocks, decompressed data differs from input", lost)
+			continue
+		}
+
+		// Flipping a padding bit has no effect
+		if start >= 0 && lost == 0 && bytes.Equal(input, output) == false {
+			b.Errorf("Corruption at %d: no lost block, decompressed data differs from input", start)
+		}
+
+		// The blocks not lost are decoded, in order
+		if len(output) != len(input)-lost*blockSize {
+			b.Errorf("Corruption at %d: incorrect output size: %d, expected %d (%d lost blocks)",
+				start, len(output), len(input)-lost*blockSize, lost)
+			continue
+		}
+
+		for i, j := 0, 0; i < len(output); i += blockSize {
+			for j < len(input) && bytes.Equal(output[i:i+blockSize], input[j:j+blockSize]) == false {
+				j += blockSize
+			}
+
+			if j >= len(input) {
+				b.Errorf("Corruption at %d: incorrect output block at %d", start, i)
+				break
+			}
+
+			j += blockSize
+		}
+	}
+
+	// Without recovery, a corrupted sync marker is an error
+	compressed[len(compressed)/2+bytes.Index(compressed[len(compressed)/2:], []byte("KSYN"))] ^= 0x10
+	var ibs util.BufferStream
+	ibs.Write(compressed)
+	cis, err := kio.NewCompressedInputStreamWithCtx(&ibs, map[string]interface{}{"jobs": uint(1)})
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if _, err = readSyncMarkersStream(cis); err == nil {
+		b.Errorf("No error for a corrupted stream")
+	}
+}
+
+// Read returns 0 at the end of the stream
+func readSyncMarkersStream(cis *kio.CompressedInputStream) ([]byte, error) {
+	output := make([]byte, 0)
+	buf := make([]byte, 65536)
+
+	for {
+		r, err := cis.Read(buf)
+		output = append(output, buf[0:r]...)
+
+		if err != nil || r == 0 {
+			return output, err
+		}
+	}
+}
+
+// recordingMetrics counts the blocks, bytes and stage timings reported by a stream
+type recordingMetrics struct {
+	mutex     sync.Mutex
+	blocks    int
+	rawBlocks int
+	bytesIn   int64
+	bytesOut  int64
+	stages    map[string]int
+}
+
+func (this *recordingMetrics) AddBlock(bytesIn, bytesOut int64, raw bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.blocks++
+	this.bytesIn += bytesIn
+	this.bytesOut += bytesOut
+
+	if raw == true {
+		this.rawBlocks++
+	}
+}
+
+func (this *recordingMetrics) AddStageTime(stage, codec string, elapsed time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// A negative duration shows up as an unexpected stage
+	if elapsed < 0 {
+		codec = "negative time"
+	}
+
+	this.stages[stage+"."+codec]++
+}
+
+func TestMetrics(b *testing.T) {
+	fmt.Println("Metrics test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	blockSize := 65536
+
+	// 3 text blocks, 1 incompressible block (skipped) and a small last block (copied)
+	input := make([]byte, 4*blockSize+10)
+
+	for i := 0; i < 3*blockSize; i++ {
+		input[i] = "the quick brown fox jumps over the lazy dog "[rnd.Intn(44)]
+	}
+
+	rnd.Read(input[3*blockSize:])
+	m1 := &recordingMetrics{stages: make(map[string]int)}
+	// expvar names cannot be registered twice (EG. go test -count=2)
+	expMetrics := kio.NewExpvarMetrics(fmt.Sprintf("kanzi_test_metrics_%d", time.Now().UnixNano()))
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform":  "BWT",
+		"codec":      "HUFFMAN",
+		"blockSize":  uint(blockSize),
+		"jobs":       uint(2),
+		"checksum":   true,
+		"skipBlocks": true,
+		"metrics":    m1,
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	cos.Write(input)
+
+	if err = cos.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	compressedLen := int64(bs.Len())
+	m2 := &recordingMetrics{stages: make(map[string]int)}
+	cis, err := kio.NewCompressedInputStreamWithCtx(&bs, map[string]interface{}{"jobs": uint(2), "metrics": kio.Metrics(m2)})
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	output := make([]byte, 0, len(input))
+	buf := make([]byte, 5000)
+
+	for {
+		r, err := cis.Read(buf)
+		output = append(output, buf[0:r]...)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if r == 0 {
+			break
+		}
+	}
+
+	cis.Close()
+
+	if bytes.Equal(input, output) == false {
+		b.Fatalf("Decompressed data differs from input")
+	}
+
+	expected := []map[string]int{
+		{"transform.forward.BWT": 3, "transform.forward.NONE": 2, "entropy.encode.HUFFMAN": 3, "entropy.encode.NONE": 2},
+		{"entropy.decode.HUFFMAN": 3, "entropy.decode.NONE": 2, "transform.inverse.BWT": 3, "transform.inverse.NONE": 2},
+	}
+
+	for i, m := range []*recordingMetrics{m1, m2} {
+		fmt.Printf("%d blocks (%d raw), %d bytes in, %d bytes out, stages: %v\n", m.blocks, m.rawBlocks, m.bytesIn, m.bytesOut, m.stages)
+
+		if m.blocks != 5 || m.rawBlocks != 2 {
+			b.Errorf("Incorrect block counts: expected 5 blocks (2 raw), got %d (%d raw)", m.blocks, m.rawBlocks)
+		}
+
+		if len(m.stages) != len(expected[i]) {
+			b.Errorf("Incorrect stages: expected %v, got %v", expected[i], m.stages)
+		}
+
+		for k, v := range expected[i] {
+			if m.stages[k] != v {
+				b.Errorf("Incorrect count for stage %v: expected %d, got %d", k, v, m.stages[k])
+			}
+		}
+	}
+
+	// The encoded block sizes exclude the stream header and block lengths
+	if m1.bytesIn != int64(len(input)) || m1.bytesOut <= 0 || m1.bytesOut >= compressedLen {
+		b.Errorf("Incorrect encoder byte totals: %d in, %d out (stream of %d bytes)", m1.bytesIn, m1.bytesOut, compressedLen)
+	}
+
+	if m2.bytesIn != m1.bytesOut || m2.bytesOut != int64(len(input)) {
+		b.Errorf("Incorrect decoder byte totals: %d in, %d out (expected %d in, %d out)", m2.bytesIn, m2.bytesOut, m1.bytesOut, len(input))
+	}
+
+	// Same counters with expvar
+	var bs2 util.BufferStream
+	ctx["metrics"] = expMetrics
+	cos, _ = kio.NewCompressedOutputStreamWithCtx(&bs2, ctx)
+	cos.Write(input)
+
+	if err = cos.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for k, v := range map[string]int64{"blocks": 5, "raw_blocks": 2, "bytes_in": int64(len(input)), "bytes_out": m1.bytesOut} {
+		if val := expMetrics.Map().Get(k); val == nil || val.String() != fmt.Sprint(v) {
+			b.Errorf("Incorrect expvar counter %v: expected %d, got %v", k, v, val)
+		}
+	}
+
+	if val := expMetrics.Map().Get("time_ns.transform.forward.BWT"); val == nil {
+		b.Errorf("Missing expvar counter time_ns.transform.forward.BWT")
+	}
+}
+
+// labelTransform an external transform (identity) recording the pprof labels
+// of the goroutine running it
+type labelTransform struct {
+	labels *[]string
+}
+
+func (this labelTransform) Forward(src, dst []byte) (uint, uint, error) {
+	*this.labels = append(*this.labels, currentLabels())
+	copy(dst, src)
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this labelTransform) Inverse(src, dst []byte) (uint, uint, error) {
+	*this.labels = append(*this.labels, currentLabels())
+	copy(dst, src)
+	return uint(len(src)), uint(len(src)), nil
+}
+
+// currentLabels returns the labels of the goroutine running a labelTransform,
+// read from the goroutine profile (empty if not labelled)
+func currentLabels() string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		if strings.Contains(record, "labelTransform") == false {
+			continue
+		}
+
+		for _, line := range strings.Split(record, "\n") {
+			if strings.HasPrefix(line, "# labels: ") {
+				return strings.TrimPrefix(line, "# labels: ")
+			}
+		}
+	}
+
+	return ""
+}
+
+func TestProfileStages(b *testing.T) {
+	fmt.Println("Profile stages test")
+	var labels []string
+	id := function.FIRST_EXPERIMENTAL_TYPE + 1
+	factory := func(ctx map[string]interface{}) (kanzi.ByteTransform, error) {
+		return labelTransform{labels: &labels}, nil
+	}
+
+	if err := function.RegisterTransform(id, "LABELS", factory); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	defer function.UnregisterTransform(id)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 200000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&15))
+	}
+
+	// One job: the tasks do not record the labels concurrently
+	compressed := make([][]byte, 2)
+
+	for i, profile := range []bool{false, true} {
+		labels = labels[0:0]
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform":     "LABELS+BWT",
+			"codec":         "ANS0",
+			"blockSize":     uint(65536),
+			"jobs":          uint(1),
+			"checksum":      true,
+			"profileStages": profile,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		compressed[i] = make([]byte, bs.Len())
+		bs.Read(compressed[i])
+		expected := ""
+
+		if profile == true {
+			expected = `{"kanzi.codec":"LABELS+BWT", "kanzi.stage":"transform.forward"}`
+		}
+
+		if len(labels) != 4 {
+			b.Errorf("profileStages=%v: expected 4 forward transforms, got %d", profile, len(labels))
+		}
+
+		for _, l := range labels {
+			if l != expected {
+				b.Errorf("profileStages=%v: incorrect labels in forward transform: expected %q, got %q", profile, expected, l)
+				break
+			}
+		}
+
+		labels = labels[0:0]
+		cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed[i]),
+			map[string]interface{}{"jobs": uint(1), "profileStages": profile})
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			if r == 0 {
+				break
+			}
+		}
+
+		cis.Close()
+
+		if bytes.Equal(input, output) == false {
+			b.Errorf("profileStages=%v: decompressed data differs from input", profile)
+		}
+
+		if profile == true {
+			expected = `{"kanzi.codec":"LABELS+BWT", "kanzi.stage":"transform.inverse"}`
+		}
+
+		if len(labels) != 4 {
+			b.Errorf("profileStages=%v: expected 4 inverse transforms, got %d", profile, len(labels))
+		}
+
+		for _, l := range labels {
+			if l != expected {
+				b.Errorf("profileStages=%v: incorrect labels in inverse transform: expected %q, got %q", profile, expected, l)
+				break
+			}
+		}
+	}
+
+	if bytes.Equal(compressed[0], compressed[1]) == false {
+		b.Errorf("The output differs when the stages are profiled")
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/frame"
+)
+
+type countingConn struct {
+	net.Conn
+	written int
+}
+
+func (this *countingConn) Write(b []byte) (int, error) {
+	n, err := this.Conn.Write(b)
+	this.written += n
+	return n, err
+}
+
+func TestConnWrapper(b *testing.T) {
+	requestDict := []byte(strings.Repeat("GET /api/v1/orders?customer=&status=pending HTTP/1.1\n", 4))
+	responseDict := []byte(strings.Repeat(`{"status":"ok","orders":[{"id":0,"amount":0.0}]}`, 4))
+	c1, c2 := net.Pipe()
+	counter := &countingConn{Conn: c1}
+
+	// Client: writes requests with the request dictionary, reads responses
+	clientEnc, _ := frame.NewEncoderWithDictionary(2, 1, requestDict)
+	clientDec := frame.NewDecoder()
+	clientDec.AddDictionary(2, responseDict)
+	client, err := frame.NewConnWrapper(counter, clientEnc, clientDec)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Server: the other way around
+	serverEnc, _ := frame.NewEncoderWithDictionary(2, 2, responseDict)
+	serverDec := frame.NewDecoder()
+	serverDec.AddDictionary(1, requestDict)
+	server, _ := frame.NewConnWrapper(c2, serverEnc, serverDec)
+
+	// Line based echo protocol: each response is decoded as soon as it is written
+	go func() {
+		r := bufio.NewReader(server)
+
+		for {
+			line, err := r.ReadString('\n')
+
+			if err != nil {
+				server.Close()
+				return
+			}
+
+			fmt.Fprintf(server, `{"status":"ok","orders":[{"id":%d,"amount":1.5}]}`+"\n", len(line))
+		}
+	}()
+
+	reader := bufio.NewReader(client)
+	total := 0
+
+	for i := 0; i < 100; i++ {
+		request := fmt.Sprintf("GET /api/v1/orders?customer=%d&status=pending HTTP/1.1\n", i)
+		total += len(request)
+
+		if _, err = client.Write([]byte(request)); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		response, err := reader.ReadString('\n')
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if expected := fmt.Sprintf(`{"status":"ok","orders":[{"id":%d,"amount":1.5}]}`+"\n", len(request)); response != expected {
+			b.Fatalf("Incorrect response: %v", response)
+		}
+	}
+
+	fmt.Printf("Requests: %d bytes => %d bytes on the wire\n", total, counter.written)
+
+	if counter.written >= total {
+		b.Fatalf("No compression of the requests")
+	}
+
+	// Large write (several frames)
+	big := make([]byte, 3*1024*1024+17)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for i := 0; i < 1000; i++ {
+		big[i] = byte(65 + rnd.Intn(26))
+	}
+	done := make(chan error)
+
+	go func() {
+		_, err := client.Write(append(big, '\n'))
+		done <- err
+	}()
+
+	// The server echoes the size of the line
+	if err = <-done; err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	response, err := reader.ReadString('\n')
+
+	if err != nil || strings.Contains(response, fmt.Sprintf(`"id":%d`, len(big)+1)) == false {
+		b.Fatalf("Incorrect response to large write: %v %v", response, err)
+	}
+
+	client.Close()
+
+	if _, err = reader.ReadString('\n'); err == nil {
+		b.Fatalf("Closed connection not detected")
+	}
+
+	// Corrupted stream
+	c3, c4 := net.Pipe()
+	bad, _ := frame.NewConnWrapper(c3, clientEnc, nil)
+
+	go func() {
+		c4.Write([]byte{5, 0xFF, 1, 2, 3, 4})
+		c4.Close()
+	}()
+
+	if _, err = io.ReadAll(bad); err == nil {
+		b.Fatalf("Corrupted frame not detected")
+	}
+
+	fmt.Printf("Error: %v\n", err)
+
+	if _, err = frame.NewConnWrapper(c3, nil, nil); err == nil {
+		b.Fatalf("Missing encoder not detected")
+	}
+
+}
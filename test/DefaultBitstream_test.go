@@ -16,8 +16,14 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"testing"
@@ -38,6 +44,930 @@ func TestMisaligned(b *testing.T) {
 	testCorrectnessMisaligned2()
 }
 
+func TestSeek(b *testing.T) {
+	if err := testSeekInput(); err != nil {
+		b.Errorf(err.Error())
+	}
+
+	if err := testSeekOutput(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testSeekInput() error {
+	fmt.Printf("Correctness Test - seek input bitstream\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	values := make([]uint64, 20000)
+	widths := make([]uint, len(values))
+	positions := make([]uint64, len(values))
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 1024)
+
+	for i := range values {
+		widths[i] = uint(1 + rand.Intn(64))
+		values[i] = rand.Uint64() >> (64 - widths[i])
+		positions[i] = obs.Position()
+		obs.WriteBits(values[i], widths[i])
+	}
+
+	obs.Close()
+	fmt.Printf("Bits written: %v\n", obs.Written())
+
+	// Small buffer to seek both within and outside of the internal buffer
+	ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 1024)
+
+	for n := 0; n < 50000; n++ {
+		i := rand.Intn(len(values))
+
+		if n&1 == 0 {
+			// Seek (sequential reads otherwise)
+			if err := ibs.Seek(positions[i]); err != nil {
+				return fmt.Errorf("Seek to %v failed: %v", positions[i], err)
+			}
+		} else if i = (n >> 1) % len(values); ibs.Position() != positions[i] {
+			if err := ibs.Seek(positions[i]); err != nil {
+				return fmt.Errorf("Seek to %v failed: %v", positions[i], err)
+			}
+		}
+
+		if ibs.Position() != positions[i] {
+			return fmt.Errorf("Invalid position: %v, expected %v", ibs.Position(), positions[i])
+		}
+
+		if val := ibs.ReadBits(widths[i]); val != values[i] {
+			return fmt.Errorf("Invalid value at position %v: %v, expected %v", positions[i], val, values[i])
+		}
+	}
+
+	// Seek on a stream that cannot seek (beyond the internal buffer)
+	buf := make([]byte, bs.Len())
+	bs.Seek(0, io.SeekStart)
+	bs.Read(buf)
+	bs2 := util.NewBufferStream(buf)
+	ibs2, _ := bitstream.NewDefaultInputBitStream(&nonSeekableStream{bs2}, 1024)
+	ibs2.ReadBits(8)
+
+	if err := ibs2.Seek(4); err != nil {
+		return fmt.Errorf("Seek within the buffer failed: %v", err)
+	}
+
+	if err := ibs2.Seek(obs.Written() - 8); err == nil {
+		return errors.New("Seek beyond the buffer of a non seekable stream should fail")
+	}
+
+	ibs.Close()
+
+	if err := ibs.Seek(0); err == nil {
+		return errors.New("Seek on a closed stream should fail")
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func testSeekOutput() error {
+	fmt.Printf("Correctness Test - seek output bitstream\n")
+	file, err := os.CreateTemp("", "kanzi_seek")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+	obs, _ := bitstream.NewDefaultOutputBitStream(file, 1024)
+
+	for i := 0; i < 4096; i++ {
+		obs.WriteBits(uint64(i), 16)
+	}
+
+	end := obs.Position()
+
+	if err := obs.Seek(3); err == nil {
+		return errors.New("Seek to a misaligned position should fail")
+	}
+
+	// Patch 2 values
+	for _, i := range []uint64{4000, 10} {
+		if err := obs.Seek(i * 16); err != nil {
+			return fmt.Errorf("Seek to %v failed: %v", i*16, err)
+		}
+
+		obs.WriteBits(0xFFFF, 16)
+	}
+
+	obs.WriteBit(1)
+
+	if err := obs.Seek(end); err == nil {
+		return errors.New("Seek after an incomplete byte should fail")
+	}
+
+	obs.WriteBits(0x7F, 7)
+
+	if err := obs.Seek(end); err != nil {
+		return fmt.Errorf("Seek to %v failed: %v", end, err)
+	}
+
+	obs.Close()
+	data, err := os.ReadFile(file.Name())
+
+	if err != nil {
+		return err
+	}
+
+	if len(data) != 8192 {
+		return fmt.Errorf("Invalid file size: %v, expected 8192", len(data))
+	}
+
+	for i := 0; i < 4096; i++ {
+		val := int(data[2*i])<<8 | int(data[2*i+1])
+		expected := i
+
+		if i == 10 || i == 4000 {
+			expected = 0xFFFF
+		} else if i == 11 {
+			expected = 0xFF00 | i
+		}
+
+		if val != expected {
+			return fmt.Errorf("Invalid value at index %v: %v, expected %v", i, val, expected)
+		}
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func TestMmap(b *testing.T) {
+	if err := testMmap(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testMmap() error {
+	fmt.Printf("Correctness Test - memory mapped input bitstream\n")
+	file, err := os.CreateTemp("", "kanzi_mmap")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+	obs, _ := bitstream.NewDefaultOutputBitStream(file, 16384)
+
+	for i := 0; i < 100000; i++ {
+		obs.WriteBits(uint64(i), 17)
+	}
+
+	obs.Close()
+	ibs, err := bitstream.NewMmapInputBitStream(file)
+	file.Close()
+
+	if bitstream.MMAP_SUPPORTED == false {
+		// Built without the mmap tag
+		if err == nil {
+			return errors.New("Memory mapping should not be supported")
+		}
+
+		fmt.Printf("Not supported: %v\n", err)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Cannot map file: %v", err)
+	}
+
+	for i := 0; i < 100000; i++ {
+		if val := ibs.ReadBits(17); val != uint64(i) {
+			return fmt.Errorf("Invalid value at index %v: %v", i, val)
+		}
+	}
+
+	if more, _ := ibs.HasMoreToRead(); more == true {
+		return errors.New("No more bits expected")
+	}
+
+	// Seek back after the end of stream
+	if err := ibs.Seek(17 * 1234); err != nil {
+		return fmt.Errorf("Seek failed: %v", err)
+	}
+
+	if val := ibs.ReadBits(17); val != 1234 {
+		return fmt.Errorf("Invalid value after seek: %v", val)
+	}
+
+	if _, err := ibs.Close(); err != nil {
+		return fmt.Errorf("Cannot unmap file: %v", err)
+	}
+
+	testReadPostClose(ibs)
+	fmt.Println("Success")
+	return nil
+}
+
+func TestPeekBits(b *testing.T) {
+	if err := testPeekBits(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testPeekBits() error {
+	fmt.Printf("Correctness Test - peek bits\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	values := make([]uint64, 20000)
+	widths := make([]uint, len(values))
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 1024)
+
+	for i := range values {
+		widths[i] = uint(1 + rand.Intn(64))
+		values[i] = rand.Uint64() >> (64 - widths[i])
+		obs.WriteBits(values[i], widths[i])
+	}
+
+	obs.Close()
+
+	// Short reads from the underlying stream to split the peeked bits
+	ibs, _ := bitstream.NewDefaultInputBitStream(&shortReadStream{bs: &bs}, 1024)
+
+	for i := range values {
+		pos := ibs.Position()
+		count := uint(1 + rand.Intn(64))
+		peek := ibs.PeekBits(count)
+
+		if ibs.Position() != pos {
+			return fmt.Errorf("Invalid position after peek: %v, expected %v", ibs.Position(), pos)
+		}
+
+		if count <= widths[i] {
+			if expected := values[i] >> (widths[i] - count); peek != expected {
+				return fmt.Errorf("Invalid peeked bits at index %v: %x, expected %x", i, peek, expected)
+			}
+		} else if peek>>(count-widths[i]) != values[i] {
+			return fmt.Errorf("Invalid peeked bits at index %v: %x, expected %x", i, peek, values[i])
+		}
+
+		if val := ibs.ReadBits(widths[i]); val != values[i] {
+			return fmt.Errorf("Invalid value at index %v: %x, expected %x", i, val, values[i])
+		}
+	}
+
+	// Zeros past the end of stream
+	if peek := ibs.PeekBits(64); peek != 0 {
+		return fmt.Errorf("Invalid peeked bits at the end of stream: %x", peek)
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func TestArrays(b *testing.T) {
+	if err := testArrays(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testArrays() error {
+	fmt.Printf("Correctness Test - read/write arrays\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	data := make([]byte, 100000)
+	rand.Read(data)
+	res := make([]byte, len(data))
+
+	for t := 0; t < 200; t++ {
+		// Aligned and misaligned, smaller and larger than the buffers
+		shift := uint(t & 7)
+		count := uint(1 + rand.Intn(8*len(data)))
+
+		if t&8 == 0 {
+			count &= ^uint(7)
+
+			if count == 0 {
+				count = 8
+			}
+		}
+
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 1024)
+		obs.WriteBits(0x15, 5)
+		obs.WriteBits(0, shift)
+		obs.WriteArray(data, count)
+		obs.WriteBits(0x2A, 6)
+
+		if w := obs.Written(); w != uint64(11+shift+count) {
+			return fmt.Errorf("Invalid number of bits written: %v, expected %v", w, 11+shift+count)
+		}
+
+		obs.Close()
+		var ibs *bitstream.DefaultInputBitStream
+
+		if t&16 == 0 {
+			ibs, _ = bitstream.NewDefaultInputBitStream(&bs, 1024)
+		} else {
+			ibs, _ = bitstream.NewDefaultInputBitStream(&shortReadStream{bs: &bs}, 1024)
+		}
+
+		ibs.ReadBits(5 + shift)
+		ibs.ReadArray(res, count)
+
+		if val := ibs.ReadBits(6); val != 0x2A {
+			return fmt.Errorf("Invalid bits after array (shift=%v, count=%v): %x", shift, count, val)
+		}
+
+		if r := ibs.Read(); r != uint64(11+shift+count) {
+			return fmt.Errorf("Invalid number of bits read: %v, expected %v", r, 11+shift+count)
+		}
+
+		n := int(count >> 3)
+
+		if bytes.Equal(data[0:n], res[0:n]) == false {
+			return fmt.Errorf("Invalid array read (shift=%v, count=%v)", shift, count)
+		}
+
+		if tail := count & 7; tail != 0 && res[n]>>(8-tail) != data[n]>>(8-tail) {
+			return fmt.Errorf("Invalid last bits read (shift=%v, count=%v)", shift, count)
+		}
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func TestChecksum(b *testing.T) {
+	if err := testChecksum(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testChecksum() error {
+	fmt.Printf("Correctness Test - checksum bitstreams\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	data := make([]byte, 4096)
+	rand.Read(data)
+	var bs util.BufferStream
+	dobs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+	obs, _ := bitstream.NewChecksumOutputBitStream(dobs)
+	midPos := uint64(0)
+	midChecksum := uint32(0)
+
+	for i := 0; i < 2000; i++ {
+		switch rand.Intn(3) {
+		case 0:
+			obs.WriteBit(rand.Intn(2))
+		case 1:
+			obs.WriteBits(rand.Uint64(), uint(1+rand.Intn(64)))
+		default:
+			obs.WriteArray(data, uint(1+rand.Intn(len(data)*8)))
+		}
+
+		if i == 1000 {
+			midPos = obs.Written()
+			midChecksum = obs.Checksum()
+		}
+	}
+
+	// Byte aligned: the checksum is the CRC32-C of the stream
+	if pad := uint((8 - obs.Written()&7) & 7); pad != 0 {
+		obs.WriteBits(0, pad)
+	}
+
+	total := obs.Written()
+	checksum := obs.Checksum()
+	obs.Close()
+
+	if obs.Checksum() != checksum {
+		return errors.New("The checksum changed after close")
+	}
+
+	stream := make([]byte, bs.Len())
+	bs.Read(stream)
+	bs.Seek(0, io.SeekStart)
+
+	if crc := crc32.Checksum(stream, crc32.MakeTable(crc32.Castagnoli)); crc != checksum {
+		return fmt.Errorf("Invalid checksum: %x, expected CRC32-C %x", checksum, crc)
+	}
+
+	// Read the bits with other calls
+	dibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+	ibs, _ := bitstream.NewChecksumInputBitStream(dibs)
+	buf := make([]byte, len(data))
+
+	for _, end := range []uint64{midPos, total} {
+		for ibs.Read() < end {
+			readBits := rand.Intn(2) == 0
+			count := uint(1 + rand.Intn(8*len(buf)))
+
+			if readBits == true {
+				count = uint(1 + rand.Intn(64))
+			}
+
+			if uint64(count) > end-ibs.Read() {
+				count = uint(end - ibs.Read())
+			}
+
+			if readBits == true {
+				ibs.ReadBits(count)
+			} else {
+				ibs.ReadArray(buf, count)
+			}
+		}
+
+		expected := checksum
+
+		if end == midPos {
+			expected = midChecksum
+		}
+
+		if ibs.Checksum() != expected {
+			return fmt.Errorf("Invalid checksum after %v bits: %x, expected %x", end, ibs.Checksum(), expected)
+		}
+	}
+
+	ibs.ResetChecksum()
+
+	if ibs.Checksum() != 0 {
+		return errors.New("Invalid checksum after reset")
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func TestLSB(b *testing.T) {
+	if err := testLSB(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testLSB() error {
+	fmt.Printf("Correctness Test - LSB first bitstreams\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	// The first bit written is the least significant bit of the first byte
+	var bs util.BufferStream
+	obs, _ := bitstream.NewLSBOutputBitStream(&bs, 16384)
+	obs.WriteBits(1, 1)
+	obs.WriteBits(2, 2)
+	obs.WriteBits(0x1F, 5)
+	obs.Close()
+	testWritePostClose(obs)
+
+	if bs.Len() != 1 {
+		return fmt.Errorf("Invalid stream length: %v, expected 1", bs.Len())
+	}
+
+	val := make([]byte, 1)
+	bs.Read(val)
+
+	if val[0] != 0xFD {
+		return fmt.Errorf("Invalid byte: %x, expected fd", val[0])
+	}
+
+	data := make([]byte, 70000)
+	rand.Read(data)
+
+	// Stored DEFLATE blocks must be readable by compress/flate
+	if err := testLSBDeflateWrite(data); err != nil {
+		return err
+	}
+
+	// And stored DEFLATE blocks written by compress/flate must be readable
+	if err := testLSBDeflateRead(data); err != nil {
+		return err
+	}
+
+	// Round trip with random operations
+	for test := 0; test < 20; test++ {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewLSBOutputBitStream(&bs, 16384)
+		ops := make([]int, 500)
+		counts := make([]uint, len(ops))
+		values := make([]uint64, len(ops))
+
+		for i := range ops {
+			ops[i] = rand.Intn(3)
+
+			switch ops[i] {
+			case 0:
+				counts[i] = 1
+				values[i] = uint64(rand.Intn(2))
+				obs.WriteBit(int(values[i]))
+			case 1:
+				counts[i] = uint(1 + rand.Intn(64))
+				values[i] = rand.Uint64() & (0xFFFFFFFFFFFFFFFF >> (64 - counts[i]))
+				obs.WriteBits(values[i], counts[i])
+			default:
+				counts[i] = uint(1 + rand.Intn(len(data)*8))
+				obs.WriteArray(data, counts[i])
+			}
+		}
+
+		written := obs.Written()
+		obs.Close()
+
+		if bs.Len() != int((written+7)>>3) {
+			return fmt.Errorf("Invalid stream length: %v, expected %v", bs.Len(), (written+7)>>3)
+		}
+
+		ibs, _ := bitstream.NewLSBInputBitStream(&shortReadStream{bs: &bs}, 16384)
+		buf := make([]byte, len(data))
+
+		for i := range ops {
+			switch ops[i] {
+			case 0:
+				if val := ibs.ReadBit(); uint64(val) != values[i] {
+					return fmt.Errorf("Invalid bit at op %v: %v, expected %v", i, val, values[i])
+				}
+			case 1:
+				if val := ibs.PeekBits(counts[i]); val != values[i] {
+					return fmt.Errorf("Invalid peeked bits at op %v: %x, expected %x", i, val, values[i])
+				}
+
+				if val := ibs.ReadBits(counts[i]); val != values[i] {
+					return fmt.Errorf("Invalid bits at op %v: %x, expected %x", i, val, values[i])
+				}
+			default:
+				ibs.ReadArray(buf, counts[i])
+				n := counts[i] >> 3
+
+				if bytes.Equal(buf[0:n], data[0:n]) == false {
+					return fmt.Errorf("Invalid array at op %v", i)
+				}
+
+				if r := counts[i] & 7; r != 0 && (buf[n]^data[n])&byte((1<<r)-1) != 0 {
+					return fmt.Errorf("Invalid last bits at op %v: %x, expected %x", i, buf[n], data[n])
+				}
+			}
+		}
+
+		if ibs.Read() != written {
+			return fmt.Errorf("Invalid number of bits read: %v, expected %v", ibs.Read(), written)
+		}
+
+		ibs.Close()
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func testLSBDeflateWrite(data []byte) error {
+	var bs util.BufferStream
+	obs, _ := bitstream.NewLSBOutputBitStream(&bs, 16384)
+
+	for start := 0; start < len(data); start += 65535 {
+		end := start + 65535
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		// Header (BFINAL, BTYPE=00), padding, LEN, NLEN then the bytes
+		if end == len(data) {
+			obs.WriteBit(1)
+		} else {
+			obs.WriteBit(0)
+		}
+
+		obs.WriteBits(0, 2)
+		obs.WriteBits(0, uint((8-obs.Written()&7)&7))
+		obs.WriteBits(uint64(end-start), 16)
+		obs.WriteBits(uint64(^uint16(end-start)), 16)
+		obs.WriteArray(data[start:end], uint(8*(end-start)))
+	}
+
+	obs.Close()
+	res, err := io.ReadAll(flate.NewReader(&bs))
+
+	if err != nil {
+		return fmt.Errorf("Failed to inflate the stored blocks: %v", err)
+	}
+
+	if bytes.Equal(res, data) == false {
+		return errors.New("The inflated data differs from the original data")
+	}
+
+	return nil
+}
+
+func testLSBDeflateRead(data []byte) error {
+	var bs util.BufferStream
+	w, _ := flate.NewWriter(&bs, flate.NoCompression)
+	w.Write(data)
+	w.Close()
+	ibs, _ := bitstream.NewLSBInputBitStream(&bs, 16384)
+	res := make([]byte, 0, len(data))
+
+	for {
+		final := ibs.ReadBit()
+
+		btype := ibs.ReadBits(2)
+
+		if btype == 1 && final == 1 {
+			// Empty final block with fixed codes: end of block code only (7 zeros)
+			if eob := ibs.ReadBits(7); eob != 0 {
+				return fmt.Errorf("Unexpected DEFLATE code: %x, expected end of block", eob)
+			}
+
+			break
+		}
+
+		if btype != 0 {
+			return fmt.Errorf("Unexpected DEFLATE block type: %v", btype)
+		}
+
+		if pad := uint((8 - ibs.Read()&7) & 7); pad != 0 {
+			ibs.ReadBits(pad)
+		}
+
+		length := int(ibs.ReadBits(16))
+
+		if nlength := int(ibs.ReadBits(16)); length^nlength != 0xFFFF {
+			return fmt.Errorf("Invalid stored block length: %x, %x", length, nlength)
+		}
+
+		if length > 0 {
+			buf := make([]byte, length)
+			ibs.ReadArray(buf, uint(8*length))
+			res = append(res, buf...)
+		}
+
+		if final == 1 {
+			break
+		}
+	}
+
+	ibs.Close()
+	testReadPostClose(ibs)
+
+	if bytes.Equal(res, data) == false {
+		return errors.New("The stored blocks differ from the original data")
+	}
+
+	return nil
+}
+
+func TestAdapters(b *testing.T) {
+	if err := testAdapters(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testAdapters() error {
+	fmt.Printf("Correctness Test - reader and writer adapters\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	data := make([]byte, 100000)
+	rand.Read(data)
+
+	type header struct {
+		Magic  uint32
+		Length int64
+		Flags  [3]byte
+	}
+
+	hdr := header{Magic: 0x4B414E5A, Length: int64(len(data)), Flags: [3]byte{1, 2, 3}}
+
+	for test := 0; test < 4; test++ {
+		var bs util.BufferStream
+		var obs kanzi.OutputBitStream
+		obs, _ = bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+		if test&1 != 0 {
+			// Not a buffered bitstream: byte per byte reads
+			obs, _ = bitstream.NewChecksumOutputBitStream(obs)
+		}
+
+		w, _ := bitstream.NewWriterAdapter(obs)
+		obs.WriteBits(5, 3)
+
+		if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+			return fmt.Errorf("Failed to write the header: %v", err)
+		}
+
+		obs.WriteBits(0x1234, 13)
+
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("Failed to copy the data: %v", err)
+		}
+
+		obs.WriteBit(1)
+		w.Write(data[0:10])
+		obs.Close()
+
+		if _, err := w.Write(data[0:1]); err == nil {
+			return errors.New("Writing to a closed stream should fail")
+		}
+
+		var ibs kanzi.InputBitStream
+		ibs, _ = bitstream.NewDefaultInputBitStream(&shortReadStream{bs: &bs}, 16384)
+
+		if test&1 != 0 {
+			ibs, _ = bitstream.NewChecksumInputBitStream(ibs)
+		}
+
+		if test&2 != 0 {
+			// Whole stream in memory
+			buf := make([]byte, bs.Len())
+			bs.Read(buf)
+			ibs = bitstream.NewSliceInputBitStream(buf)
+		}
+
+		r, _ := bitstream.NewReaderAdapter(ibs)
+
+		if val := ibs.ReadBits(3); val != 5 {
+			return fmt.Errorf("Invalid bits: %v, expected 5", val)
+		}
+
+		var hdr2 header
+
+		if err := binary.Read(r, binary.LittleEndian, &hdr2); err != nil {
+			return fmt.Errorf("Failed to read the header: %v", err)
+		}
+
+		if hdr2 != hdr {
+			return fmt.Errorf("Invalid header: %+v, expected %+v", hdr2, hdr)
+		}
+
+		if val := ibs.ReadBits(13); val != 0x1234 {
+			return fmt.Errorf("Invalid bits: %x, expected 1234", val)
+		}
+
+		buf := make([]byte, len(data))
+
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("Failed to read the data: %v", err)
+		}
+
+		if bytes.Equal(buf, data) == false {
+			return errors.New("The data read differs from the data written")
+		}
+
+		if val := ibs.ReadBit(); val != 1 {
+			return fmt.Errorf("Invalid bit: %v, expected 1", val)
+		}
+
+		// Read up to the end of the bitstream
+		tail, err := ioutil.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read the end of the bitstream: %v", err)
+		}
+
+		if bytes.Equal(tail, data[0:10]) == false {
+			return errors.New("The end of the bitstream differs from the data written")
+		}
+
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			return fmt.Errorf("Invalid read at the end of the bitstream: %v, %v", n, err)
+		}
+
+		ibs.Close()
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+func TestSyncMarker(b *testing.T) {
+	if err := testSyncMarker(); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func testSyncMarker() error {
+	fmt.Printf("Correctness Test - sync markers\n")
+	rand.Seed(time.Now().UTC().UnixNano())
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+	data := make([]byte, 1000)
+	rand.Read(data)
+
+	// Random bits and data containing copies of the magic value
+	copy(data[100:], []byte("KSYN"))
+	copy(data[500:], []byte("KSYN\x00\x00\x00\x00\x00\x00"))
+
+	counts := make([]uint, 100)
+
+	for i := range counts {
+		counts[i] = uint(9 + rand.Intn(56))
+		obs.WriteBits(rand.Uint64(), counts[i])
+
+		if err := bitstream.WriteSyncMarker(obs, uint32(i)); err != nil {
+			return err
+		}
+
+		obs.WriteArray(data, uint(8*len(data)))
+	}
+
+	obs.Close()
+
+	if err := bitstream.WriteSyncMarker(obs, 100); err == nil {
+		return errors.New("Writing a sync marker to a closed stream should fail")
+	}
+
+	stream := make([]byte, bs.Len())
+	bs.Read(stream)
+
+	// Read the markers at their position
+	ibs := bitstream.NewSliceInputBitStream(stream)
+	buf := make([]byte, len(data))
+
+	for i := range counts {
+		ibs.ReadBits(counts[i])
+
+		if counter, err := bitstream.ReadSyncMarker(ibs); err != nil || counter != uint32(i) {
+			return fmt.Errorf("Invalid sync marker: %d, expected %d (%v)", counter, i, err)
+		}
+
+		ibs.ReadArray(buf, uint(8*len(buf)))
+	}
+
+	// No marker in the second byte (random bits)
+	ibs = bitstream.NewSliceInputBitStream(stream)
+	ibs.ReadBits(5)
+
+	if _, err := bitstream.ReadSyncMarker(ibs); err == nil {
+		return errors.New("No error for a missing sync marker")
+	}
+
+	// Find the markers after some bits (in the data or the next marker)
+	ibs = bitstream.NewSliceInputBitStream(stream)
+
+	for i := 0; i < 100; i++ {
+		ibs.ReadBits(uint(1 + rand.Intn(64)))
+		counter, err := bitstream.ScanSyncMarker(ibs)
+
+		if err != nil {
+			return err
+		}
+
+		if counter < uint32(i) {
+			return fmt.Errorf("Invalid sync marker counter: %d, expected at least %d", counter, i)
+		}
+
+		i = int(counter)
+	}
+
+	if _, err := bitstream.ScanSyncMarker(ibs); err == nil {
+		return errors.New("No error for a scan beyond the last sync marker")
+	}
+
+	// Corrupted marker (counter 50): the next one is found
+	stream[bytes.Index(stream, []byte("KSYN\x00\x00\x00\x32"))+7] ^= 1
+	ibs = bitstream.NewSliceInputBitStream(stream)
+	prev := uint32(0)
+
+	for i := 0; ; i++ {
+		counter, err := bitstream.ScanSyncMarker(ibs)
+
+		if err != nil {
+			if i != 99 {
+				return fmt.Errorf("Invalid number of sync markers found: %d, expected 99", i)
+			}
+
+			break
+		}
+
+		if i > 0 && counter != prev+1 && counter != prev+2 {
+			return fmt.Errorf("Invalid sync marker counter: %d after %d", counter, prev)
+		}
+
+		prev = counter
+	}
+
+	fmt.Println("Success")
+	return nil
+}
+
+// shortReadStream returns at most 7 bytes per read
+type shortReadStream struct {
+	bs *util.BufferStream
+}
+
+func (this *shortReadStream) Read(b []byte) (int, error) {
+	if len(b) > 7 {
+		b = b[0 : 1+rand.Intn(7)]
+	}
+
+	return this.bs.Read(b)
+}
+
+func (this *shortReadStream) Close() error {
+	return this.bs.Close()
+}
+
+// nonSeekableStream hides the Seek method of the stream
+type nonSeekableStream struct {
+	bs *util.BufferStream
+}
+
+func (this *nonSeekableStream) Read(b []byte) (int, error) {
+	return this.bs.Read(b)
+}
+
+func (this *nonSeekableStream) Close() error {
+	return this.bs.Close()
+}
+
 func testCorrectnessAligned1() error {
 	fmt.Printf("Correctness Test - write long - byte aligned\n")
 	values := make([]int, 100)
@@ -0,0 +1,223 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/flanglet/kanzi-go/dictionary"
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// Header and entropy tables of a dictionary produced by 'zstd --train'
+const _ZSTD_DICT_HEADER = "37a430ec24900d714010a8b3693a303333333333333333331351710832dbe4ee" +
+	"6fbbad95a546429aada1bccf88e6f743d97cc4eb7fdb751619dff018c3a7469a" +
+	"a00ef8ed9809fd0a26a30000141819a211ab760f000004c0801c2296980e0ec5" +
+	"027128c69110c4301403410cc318648831c600440e8ccc000000648a65c36343" +
+	"72d93414888422410e47393045505501000000010000000400000008000000"
+
+func TestDictionary(b *testing.T) {
+	header, _ := hex.DecodeString(_ZSTD_DICT_HEADER)
+	content := []byte("func (this *Request) Header() string { return this.header }\n")
+	dict, err := dictionary.Parse(append(header, content...))
+
+	if err != nil {
+		b.Errorf("Cannot parse zstd dictionary: %v", err)
+		return
+	}
+
+	if dict.ID != 0x710d9024 || bytes.Equal(dict.Content, content) == false {
+		b.Errorf("Incorrect zstd dictionary: ID %x, content %q", dict.ID, dict.Content)
+	}
+
+	if words := string(dict.TextDictionary(0)); words != "HeaderRequestThisReturnStringFunc" {
+		b.Errorf("Incorrect text dictionary: %v", words)
+	}
+
+	if _, err = dictionary.Parse(header[0:20]); err == nil {
+		b.Errorf("No error parsing truncated zstd dictionary")
+	}
+
+	// Raw dictionary
+	raw := bytes.Repeat(content, 50)
+
+	if dict, err = dictionary.Parse(raw); err != nil || dict.ID != 0 || len(dict.Content) != len(raw) {
+		b.Errorf("Incorrect raw dictionary")
+	}
+
+	input := []byte("// Header returns the header of the request\n" + string(content) +
+		"func (this *Request) String() string { return this.header + this.body }\n")
+
+	for _, p := range []string{"LZ&NONE", "TEXT&NONE", "TEXT+LZ&HUFFMAN", "NONE&CM", "NONE&TPAQ"} {
+		ctxs := []map[string]interface{}{
+			nil,
+			{"lzDictionary": dict.LZDictionary(0), "textDictionary": dict.TextDictionary(0),
+				"cmDictionary": dict.CMDictionary(0)},
+		}
+
+		sizes := make([]uint, len(ctxs))
+
+		for i, ctx := range ctxs {
+			if sizes[i], err = testDictionaryRoundTrip(p, ctx, input); err != nil {
+				b.Errorf("%v: %v", p, err)
+			}
+		}
+
+		fmt.Printf("%v: %d => %d bytes, with dictionary %d bytes\n", p, len(input), sizes[0], sizes[1])
+
+		if sizes[1] >= sizes[0] {
+			b.Errorf("%v: the dictionary did not improve compression", p)
+		}
+	}
+}
+
+func testDictionaryRoundTrip(str string, ctx map[string]interface{}, input []byte) (uint, error) {
+	desc, err := pipeline.Parse(str)
+
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := pipeline.NewPipelineWithCtx(desc, ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	encoded := make([]byte, p.MaxEncodedLen(len(input)))
+	_, n, err := p.Forward(input, encoded)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Use a new pipeline to decode
+	if p, err = pipeline.NewPipelineWithCtx(desc, ctx); err != nil {
+		return 0, err
+	}
+
+	decoded := make([]byte, len(input))
+	_, m, err := p.Inverse(encoded[0:n], decoded)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if bytes.Equal(input, decoded[0:m]) == false {
+		return 0, fmt.Errorf("Decoded data differs from input")
+	}
+
+	return n, nil
+}
+
+// The dictionaries force format version 10: kanzi 1.8 cannot decode the
+// blocks. Their codecs reject the dictionary in a version 9 stream.
+func TestDictionaryFormatVersion(b *testing.T) {
+	input := bytes.Repeat([]byte("func (this *Request) Header() string { return this.header }\n"), 200)
+	dict := input[0:4096]
+
+	tests := []struct {
+		transform string
+		codec     string
+		key       string
+	}{
+		{"LZ", "NONE", "lzDictionary"},
+		{"ROLZ", "NONE", "rolzDictionary"},
+		{"NONE", "CM", "cmDictionary"},
+		{"NONE", "TPAQ", "cmDictionary"},
+	}
+
+	for _, test := range tests {
+		var bs util.BufferStream
+		ctx := map[string]interface{}{
+			"transform": test.transform,
+			"codec":     test.codec,
+			"blockSize": uint(65536),
+			"jobs":      uint(1),
+			"checksum":  true,
+			test.key:    dict,
+		}
+
+		cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		compressed := make([]byte, bs.Len())
+		bs.Read(compressed)
+		fmt.Printf("%v: %v => %v\n", test.key, len(input), len(compressed))
+
+		if version := int(compressed[4] >> 3); version != kio.BITSTREAM_FORMAT_VERSION {
+			b.Errorf("%v: incorrect version written: %d, expected %d", test.key, version, kio.BITSTREAM_FORMAT_VERSION)
+		}
+
+		ctx = map[string]interface{}{"jobs": uint(1), test.key: dict}
+		cis, err := kio.NewCompressedInputStreamWithCtx(util.NewBufferStream(compressed), ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		// Read returns 0 at the end of the stream
+		output := make([]byte, 0, len(input))
+		buf := make([]byte, 65536)
+
+		for {
+			r, err2 := cis.Read(buf)
+			output = append(output, buf[0:r]...)
+
+			if err = err2; err != nil || r == 0 {
+				break
+			}
+		}
+
+		cis.Close()
+
+		if err != nil {
+			b.Errorf("%v: %v", test.key, err)
+		} else if bytes.Equal(input, output) == false {
+			b.Errorf("%v: decompressed data differs from input", test.key)
+		}
+
+		// Same dictionary in a version 9 stream
+		ctx = map[string]interface{}{"bsVersion": uint(9), test.key: dict}
+		desc, _ := pipeline.Parse(test.transform + "&" + test.codec)
+
+		p, err := pipeline.NewPipelineWithCtx(desc, ctx)
+
+		if err == nil {
+			_, _, err = p.Forward(input, make([]byte, p.MaxEncodedLen(len(input))))
+		}
+
+		if err == nil {
+			b.Errorf("%v: no error in a version 9 stream", test.key)
+		} else {
+			fmt.Printf("%v: %v\n", test.key, err)
+		}
+	}
+}
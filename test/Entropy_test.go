@@ -16,8 +16,10 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"testing"
@@ -26,6 +28,7 @@ import (
 	kanzi "github.com/flanglet/kanzi-go"
 	"github.com/flanglet/kanzi-go/bitstream"
 	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
 	"github.com/flanglet/kanzi-go/util"
 )
 
@@ -35,11 +38,33 @@ func TestHuffman(b *testing.T) {
 	}
 }
 
+func TestHuffmanOrder1(b *testing.T) {
+	if err := testEntropyCorrectness("HUFFMAN1"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func TestHuffmanRL(b *testing.T) {
+	if err := testEntropyCorrectness("HUFFMANRL"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
 func TestANS0(b *testing.T) {
 	if err := testEntropyCorrectness("ANS0"); err != nil {
 		b.Errorf(err.Error())
 	}
 }
+func TestFSE(b *testing.T) {
+	if err := testEntropyCorrectness("FSE"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+func TestAdaptiveHuffman(b *testing.T) {
+	if err := testEntropyCorrectness("AHUFF"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
 func TestANS1(b *testing.T) {
 	if err := testEntropyCorrectness("ANS1"); err != nil {
 		b.Errorf(err.Error())
@@ -60,6 +85,21 @@ func TestCM(b *testing.T) {
 		b.Errorf(err.Error())
 	}
 }
+func TestFPAQ32(b *testing.T) {
+	if err := testEntropyCorrectness("FPAQ32"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+func TestCM32(b *testing.T) {
+	if err := testEntropyCorrectness("CM32"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+func TestLZModel(b *testing.T) {
+	if err := testEntropyCorrectness("LZMODEL"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
 func TestTPAQ(b *testing.T) {
 	if err := testEntropyCorrectness("TPAQ"); err != nil {
 		b.Errorf(err.Error())
@@ -75,6 +115,11 @@ func TestRiceGolomb(b *testing.T) {
 		b.Errorf(err.Error())
 	}
 }
+func TestRice(b *testing.T) {
+	if err := testEntropyCorrectness("RICE"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
 
 func getPredictor(name string) kanzi.Predictor {
 	switch name {
@@ -82,10 +127,18 @@ func getPredictor(name string) kanzi.Predictor {
 		res, _ := entropy.NewTPAQPredictor(nil)
 		return res
 
-	case "CM":
+	case "CM", "CM32":
 		res, _ := entropy.NewCMPredictor()
 		return res
 
+	case "FPAQ32":
+		res, _ := entropy.NewFPAQPredictor()
+		return res
+
+	case "LZMODEL":
+		res, _ := entropy.NewLZModelPredictor()
+		return res
+
 	default:
 		panic(fmt.Errorf("Unsupported type: '%s'", name))
 	}
@@ -101,14 +154,26 @@ func getEncoder(name string, obs kanzi.OutputBitStream) kanzi.EntropyEncoder {
 		res, _ := entropy.NewBinaryEntropyEncoder(obs, getPredictor(name))
 		return res
 
-	case "CM":
+	case "CM", "LZMODEL":
 		res, _ := entropy.NewBinaryEntropyEncoder(obs, getPredictor(name))
 		return res
 
+	case "FPAQ32", "CM32":
+		res, _ := entropy.NewBinaryArithmeticEncoder(obs, getPredictor(name))
+		return res
+
 	case "HUFFMAN":
 		res, _ := entropy.NewHuffmanEncoder(obs)
 		return res
 
+	case "HUFFMAN1":
+		res, _ := entropy.NewHuffmanOrder1Encoder(obs)
+		return res
+
+	case "HUFFMANRL":
+		res, _ := entropy.NewHuffmanRLEncoder(obs)
+		return res
+
 	case "ANS0":
 		res, _ := entropy.NewANSRangeEncoder(obs, 0)
 		return res
@@ -121,6 +186,14 @@ func getEncoder(name string, obs kanzi.OutputBitStream) kanzi.EntropyEncoder {
 		res, _ := entropy.NewRangeEncoder(obs)
 		return res
 
+	case "FSE":
+		res, _ := entropy.NewFSEEncoder(obs)
+		return res
+
+	case "AHUFF":
+		res, _ := entropy.NewAdaptiveHuffmanEncoder(obs)
+		return res
+
 	case "EXPGOLOMB":
 		res, _ := entropy.NewExpGolombEncoder(obs, true)
 		return res
@@ -129,6 +202,10 @@ func getEncoder(name string, obs kanzi.OutputBitStream) kanzi.EntropyEncoder {
 		res, _ := entropy.NewRiceGolombEncoder(obs, true, 4)
 		return res
 
+	case "RICE":
+		res, _ := entropy.NewRiceGolombEncoder(obs, true, 0)
+		return res
+
 	default:
 		panic(fmt.Errorf("No such entropy encoder: '%s'", name))
 	}
@@ -150,7 +227,7 @@ func getDecoder(name string, ibs kanzi.InputBitStream) kanzi.EntropyDecoder {
 		res, _ := entropy.NewBinaryEntropyDecoder(ibs, pred)
 		return res
 
-	case "CM":
+	case "CM", "LZMODEL":
 		pred := getPredictor(name)
 
 		if pred == nil {
@@ -160,10 +237,22 @@ func getDecoder(name string, ibs kanzi.InputBitStream) kanzi.EntropyDecoder {
 		res, _ := entropy.NewBinaryEntropyDecoder(ibs, pred)
 		return res
 
+	case "FPAQ32", "CM32":
+		res, _ := entropy.NewBinaryArithmeticDecoder(ibs, getPredictor(name))
+		return res
+
 	case "HUFFMAN":
 		res, _ := entropy.NewHuffmanDecoder(ibs)
 		return res
 
+	case "HUFFMAN1":
+		res, _ := entropy.NewHuffmanOrder1Decoder(ibs)
+		return res
+
+	case "HUFFMANRL":
+		res, _ := entropy.NewHuffmanRLDecoder(ibs)
+		return res
+
 	case "ANS0":
 		res, _ := entropy.NewANSRangeDecoder(ibs, 0)
 		return res
@@ -176,6 +265,14 @@ func getDecoder(name string, ibs kanzi.InputBitStream) kanzi.EntropyDecoder {
 		res, _ := entropy.NewRangeDecoder(ibs)
 		return res
 
+	case "FSE":
+		res, _ := entropy.NewFSEDecoder(ibs)
+		return res
+
+	case "AHUFF":
+		res, _ := entropy.NewAdaptiveHuffmanDecoder(ibs)
+		return res
+
 	case "EXPGOLOMB":
 		res, _ := entropy.NewExpGolombDecoder(ibs, true)
 		return res
@@ -184,6 +281,10 @@ func getDecoder(name string, ibs kanzi.InputBitStream) kanzi.EntropyDecoder {
 		res, _ := entropy.NewRiceGolombDecoder(ibs, true, 4)
 		return res
 
+	case "RICE":
+		res, _ := entropy.NewRiceGolombDecoder(ibs, true, 0)
+		return res
+
 	default:
 		panic(fmt.Errorf("No such entropy decoder: '%s'", name))
 	}
@@ -290,3 +391,1085 @@ func testEntropyCorrectness(name string) error {
 
 	return error(nil)
 }
+
+func TestDecoderCache(b *testing.T) {
+	types := []string{"HUFFMAN", "AHUFF", "ANS0", "ANS1", "RANGE", "FSE", "FPAQ", "CM", "TPAQ", "FPAQ32", "CM32", "LZMODEL"}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, name := range types {
+		fmt.Printf("Decoder cache test for %v\n", name)
+		entropyType := entropy.GetType(name)
+		var cache entropy.DecoderCache
+		var first kanzi.EntropyDecoder
+
+		// Decode several blocks with different statistics with the same decoder.
+		// The cache is released half way: the next decoder must start afresh.
+		for blk := 0; blk < 6; blk++ {
+			values := make([]byte, 20000+rnd.Intn(20000))
+			rng := 2 + rnd.Intn(254)
+
+			for i := range values {
+				values[i] = byte(rnd.Intn(rng) & rnd.Intn(256))
+			}
+
+			ctx := make(map[string]interface{})
+			ctx["blockSize"] = uint(len(values))
+			ctx["size"] = uint(len(values))
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			ee, err := entropy.NewEntropyEncoder(obs, ctx, entropyType)
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			if _, err = ee.Write(values); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ee.Dispose()
+			obs.Close()
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+			ed, err := cache.NewEntropyDecoder(ibs, ctx, entropyType)
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			if blk == 0 || blk == 3 {
+				first = ed
+			} else if ed != first {
+				b.Fatalf("%v: the decoder was not reused", name)
+			}
+
+			values2 := make([]byte, len(values))
+
+			if _, err = ed.Read(values2); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ed.Dispose()
+
+			for i := range values {
+				if values[i] != values2[i] {
+					b.Fatalf("%v: block %d, different values at index %d", name, blk, i)
+				}
+			}
+
+			if blk == 2 {
+				cache.Release()
+			}
+		}
+
+		cache.Release()
+	}
+}
+
+func TestCodecPool(b *testing.T) {
+	types := []string{"HUFFMAN", "HUFFMAN1", "HUFFMANRL", "AHUFF", "ANS0", "ANS1", "RANGE", "FSE", "FPAQ", "CM", "TPAQ", "RICE", "FPAQ32", "CM32", "LZMODEL"}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var pool entropy.CodecPool
+	encoders := make(map[string]kanzi.EntropyEncoder)
+
+	for blk := 0; blk < 3*len(types); blk++ {
+		// Interleave the types: each codec is reused after the other ones
+		name := types[blk%len(types)]
+		entropyType := entropy.GetType(name)
+		values := make([]byte, 20000+rnd.Intn(20000))
+		rng := 2 + rnd.Intn(254)
+
+		for i := range values {
+			values[i] = byte(rnd.Intn(rng) & rnd.Intn(256))
+		}
+
+		ctx := make(map[string]interface{})
+		ctx["blockSize"] = uint(len(values))
+		ctx["size"] = uint(len(values))
+		encoded := make([][]byte, 2)
+
+		// A pooled encoder must produce the same output as a new encoder
+		for j := range encoded {
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			var ee kanzi.EntropyEncoder
+			var err error
+
+			if j == 0 {
+				ee, err = pool.NewEntropyEncoder(obs, ctx, entropyType)
+
+				// Only the codecs with tables or models are pooled
+				if prev, prst := encoders[name]; prst == true && name != "RICE" && ee != prev {
+					b.Fatalf("%v: the encoder was not reused", name)
+				}
+
+				encoders[name] = ee
+			} else {
+				ee, err = entropy.NewEntropyEncoder(obs, ctx, entropyType)
+			}
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			if _, err = ee.Write(values); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ee.Dispose()
+			obs.Close()
+			encoded[j] = make([]byte, bs.Len())
+			bs.Read(encoded[j])
+		}
+
+		if bytes.Equal(encoded[0], encoded[1]) == false {
+			b.Fatalf("%v: block %d, the pooled encoder output is different", name, blk)
+		}
+
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded[0]), 16384)
+		ed, err := pool.NewEntropyDecoder(ibs, ctx, entropyType)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		values2 := make([]byte, len(values))
+
+		if _, err = ed.Read(values2); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(values, values2) == false {
+			b.Fatalf("%v: block %d, different decoded values", name, blk)
+		}
+
+		if blk == 2*len(types)-1 {
+			fmt.Printf("Release codec pool after %d blocks\n", blk+1)
+			pool.Release()
+			encoders = make(map[string]kanzi.EntropyEncoder)
+		}
+	}
+
+	pool.Release()
+}
+
+func TestMaxEncodedLen(b *testing.T) {
+	types := []string{"NONE", "HUFFMAN", "HUFFMAN1", "HUFFMANRL", "AHUFF", "RICE", "ANS0", "ANS1", "RANGE", "FSE", "FPAQ", "CM", "TPAQ", "FPAQ32", "CM32", "LZMODEL"}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, name := range types {
+		fmt.Printf("Max encoded length test for %v\n", name)
+		entropyType := entropy.GetType(name)
+
+		// Incompressible, highly skewed and mixed blocks
+		for _, size := range []int{1, 17, 1000, 16385, 100000, 1 << 20} {
+			for pattern := 0; pattern < 3; pattern++ {
+				values := make([]byte, size)
+				rnd.Read(values)
+
+				for i := range values {
+					if pattern == 1 {
+						values[i] &= 1
+					} else if pattern == 2 && (i>>12)&1 == 0 {
+						values[i] = 0
+					}
+				}
+
+				var bs util.BufferStream
+				obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+				ctx := map[string]interface{}{"size": uint(size), "blockSize": uint(size), "ansInterleave": uint(4)}
+				ee, err := entropy.NewEntropyEncoder(obs, ctx, entropyType)
+
+				if err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+
+				if _, err = ee.Write(values); err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+
+				ee.Dispose()
+				obs.Close()
+
+				if maxLen := entropy.MaxEncodedLen(entropyType, size); bs.Len() > maxLen {
+					b.Errorf("%v: %d bytes encoded to %d bytes, max length is %d", name, size, bs.Len(), maxLen)
+				}
+			}
+		}
+	}
+}
+
+func TestANSInterleave(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Small blocks (one state), blocks with a remainder and multi chunk blocks
+	for _, size := range []int{100, 2047, 4099, 10001, 100003} {
+		values := make([]byte, size)
+
+		for i := range values {
+			values[i] = byte(rnd.ExpFloat64() * 8)
+		}
+
+		for order := uint(0); order < 2; order++ {
+			sizes := make([]int, 0)
+
+			for _, interleave := range []uint{1, 2, 4} {
+				fmt.Printf("ANS%d interleave test: %d states, %d bytes\n", order, interleave, size)
+				var bs util.BufferStream
+				obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+				ec, err := entropy.NewANSRangeEncoder(obs, order, 4096, 12, interleave)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				if _, err = ec.Write(values); err != nil {
+					b.Fatalf("Error during encoding: %v", err)
+				}
+
+				ec.Dispose()
+				obs.Close()
+				sizes = append(sizes, bs.Len())
+				ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+				ed, err := entropy.NewANSRangeDecoder(ibs, order, 4096<<(8*order), interleave)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				values2 := make([]byte, size)
+
+				if _, err = ed.Read(values2); err != nil {
+					b.Fatalf("Error during decoding: %v", err)
+				}
+
+				ed.Dispose()
+
+				for i := range values {
+					if values[i] != values2[i] {
+						b.Fatalf("Different at index %d: %d instead of %d", i, values2[i], values[i])
+					}
+				}
+			}
+
+			// Each additional state costs 4 bytes per chunk
+			if sizes[2] > sizes[0]+12*(1+size/4096) {
+				b.Errorf("Interleaved states are too expensive: %v", sizes)
+			}
+		}
+	}
+
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+	if _, err := entropy.NewANSRangeEncoder(obs, 0, 4096, 12, 3); err == nil {
+		b.Errorf("No error for an invalid interleave factor")
+	}
+}
+
+func TestAdaptiveHuffmanSmallBlocks(b *testing.T) {
+	// Small blocks do not pay the code table of the static Huffman codec
+	input := []byte("The adaptive Huffman codec builds its code tree on the fly, so that small blocks are compressed without sending a code table first.")
+	sizes := make(map[string]int)
+
+	for _, name := range []string{"HUFFMAN", "AHUFF"} {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+		ec := getEncoder(name, obs)
+
+		if _, err := ec.Write(input); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ec.Dispose()
+		obs.Close()
+		sizes[name] = bs.Len()
+		fmt.Printf("%v: %d bytes => %d bytes\n", name, len(input), bs.Len())
+	}
+
+	if sizes["AHUFF"] >= sizes["HUFFMAN"] {
+		b.Errorf("Adaptive Huffman output is not smaller: %v", sizes)
+	}
+}
+
+func TestHigherOrderEntropy(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ramp := make([]byte, 65536)
+	random := make([]byte, 65536)
+	text := make([]byte, 0, 65536)
+	words := []string{"the ", "entropy ", "of ", "a ", "block ", "depends ", "on ", "its ", "context ", "model. "}
+
+	for i := range ramp {
+		ramp[i] = byte(i)
+		random[i] = byte(rnd.Intn(256))
+	}
+
+	for len(text) < 65000 {
+		text = append(text, words[rnd.Intn(len(words))]...)
+	}
+
+	histo := make([]int, 256)
+
+	for _, t := range []struct {
+		name  string
+		data  []byte
+		lower bool
+	}{{"ramp", ramp, true}, {"text", text, true}, {"random", random, false}} {
+		e0 := entropy.ComputeFirstOrderEntropy1024(t.data, histo)
+		e1 := entropy.ComputeOrderNEntropy1024(t.data, 1)
+		e2 := entropy.ComputeSecondOrderEntropy1024(t.data)
+		fmt.Printf("%v: order 0=%d order 1=%d order 2=%d\n", t.name, e0, e1, e2)
+
+		if e2 < 0 || e2 > 1024 {
+			b.Errorf("%v: order 2 entropy out of range: %d", t.name, e2)
+		}
+
+		if t.lower == true && 2*e2 > e0 {
+			b.Errorf("%v: order 2 entropy too high: %d (order 0: %d)", t.name, e2, e0)
+		}
+
+		if t.lower == false && e2 < entropy.INCOMPRESSIBLE_THRESHOLD {
+			b.Errorf("%v: order 2 entropy too low: %d", t.name, e2)
+		}
+
+		skip, _ := entropy.IsIncompressible(t.data, histo)
+
+		if skip == t.lower {
+			b.Errorf("%v: incorrect incompressibility: %v", t.name, skip)
+		}
+	}
+
+	if entropy.ComputeSecondOrderEntropy1024([]byte{}) != 0 {
+		b.Errorf("Non zero entropy for an empty block")
+	}
+}
+
+func TestLargeAlphabet(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 8; ii++ {
+		size := 512 << uint(rnd.Intn(8))
+		alphabet := make([]int, 0, size)
+
+		for i := 0; i < size; i++ {
+			var keep bool
+
+			switch ii {
+			case 0: // empty
+			case 1: // full
+				keep = true
+			case 2: // sparse
+				keep = i == size-1 || rnd.Intn(200) == 0
+			case 3: // clustered
+				keep = (i>>5)%7 == 0
+			default: // dense
+				keep = rnd.Intn(1+ii) != 0
+			}
+
+			if keep == true {
+				alphabet = append(alphabet, i)
+			}
+		}
+
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+		if _, err := entropy.EncodeAlphabet(obs, alphabet); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		obs.Close()
+		encoded := bs.Len()
+		ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+		decoded := make([]int, entropy.MAX_ALPHABET_SIZE)
+		count, err := entropy.DecodeAlphabet(ibs, decoded)
+		ibs.Close()
+		fmt.Printf("Alphabet size %d: %d symbols => %d bytes\n", size, len(alphabet), encoded)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if count != len(alphabet) {
+			b.Fatalf("Incorrect number of symbols: %d instead of %d", count, len(alphabet))
+		}
+
+		for i := range alphabet {
+			if decoded[i] != alphabet[i] {
+				b.Fatalf("Incorrect symbol at index %d: %d instead of %d", i, decoded[i], alphabet[i])
+			}
+		}
+	}
+
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+	if _, err := entropy.EncodeAlphabet(obs, make([]int, 1, 2*entropy.MAX_ALPHABET_SIZE)); err == nil {
+		b.Errorf("No error for an alphabet too large")
+	}
+
+	if _, err := entropy.EncodeAlphabet(obs, append(make([]int, 0, 256), 0, 300)); err == nil {
+		b.Errorf("No error for an invalid symbol")
+	}
+}
+
+func TestRiceResiduals(b *testing.T) {
+	// Residuals with a geometric distribution centered on 0, and a spread
+	// changing from block to block
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, scale := range []float64{0.3, 2, 10, 40} {
+		input := make([]byte, 4096)
+
+		for i := range input {
+			v := int(rnd.ExpFloat64() * scale)
+
+			if v > 127 {
+				v = 127
+			}
+
+			if rnd.Intn(2) == 0 {
+				v = -v
+			}
+
+			input[i] = byte(v)
+		}
+
+		sizes := make(map[string]int)
+
+		for _, name := range []string{"HUFFMAN", "RICE"} {
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			ec := getEncoder(name, obs)
+
+			if _, err := ec.Write(input); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ec.Dispose()
+			obs.Close()
+			sizes[name] = bs.Len()
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+			ed := getDecoder(name, ibs)
+			output := make([]byte, len(input))
+
+			if _, err := ed.Read(output); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ed.Dispose()
+			ibs.Close()
+
+			if bytes.Equal(input, output) == false {
+				b.Fatalf("%v: incorrect decoded data", name)
+			}
+		}
+
+		fmt.Printf("Scale %v: HUFFMAN=%d bytes RICE=%d bytes\n", scale, sizes["HUFFMAN"], sizes["RICE"])
+
+		// No code table: Rice must be close to Huffman or better
+		if sizes["RICE"] > sizes["HUFFMAN"]+sizes["HUFFMAN"]/20 {
+			b.Errorf("Rice output too large: %v", sizes)
+		}
+	}
+}
+
+func TestCMDictionary(b *testing.T) {
+	// Small similar messages compress better with a model primed on samples
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	message := func() []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"user":{"name":"user%d","active":%v},"items":[{"sku":"A-%d","qty":%d}],"status":"ok"}`,
+			rnd.Intn(100000), rnd.Intn(1000), rnd.Intn(2) == 0, rnd.Intn(500), rnd.Intn(9)))
+	}
+
+	dict := make([]byte, 0)
+
+	for len(dict) < 8192 {
+		dict = append(dict, message()...)
+	}
+
+	for _, name := range []string{"CM", "TPAQ"} {
+		entropyType := entropy.GetType(name)
+		input := message()
+		sizes := make([]int, 2)
+
+		for j := range sizes {
+			ctx := make(map[string]interface{})
+			ctx["blockSize"] = uint(len(input))
+			ctx["size"] = uint(len(input))
+
+			if j == 1 {
+				ctx["cmDictionary"] = dict
+			}
+
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			ee, _ := entropy.NewEntropyEncoder(obs, ctx, entropyType)
+
+			if _, err := ee.Write(input); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ee.Dispose()
+			obs.Close()
+			sizes[j] = bs.Len()
+			ibs, _ := bitstream.NewDefaultInputBitStream(&bs, 16384)
+			ed, _ := entropy.NewEntropyDecoder(ibs, ctx, entropyType)
+			output := make([]byte, len(input))
+
+			if _, err := ed.Read(output); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ed.Dispose()
+			ibs.Close()
+
+			if bytes.Equal(input, output) == false {
+				b.Fatalf("%v: incorrect decoded data", name)
+			}
+		}
+
+		fmt.Printf("%v: %d bytes => %d bytes, %d bytes with dictionary\n", name, len(input), sizes[0], sizes[1])
+
+		if 2*sizes[1] > sizes[0] {
+			b.Errorf("%v: the dictionary does not help enough: %v", name, sizes)
+		}
+	}
+}
+
+func TestSegmentedCodec(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	values := make([]byte, 5*entropy.MIN_ENTROPY_SEGMENT_SIZE+rnd.Intn(1000))
+
+	for i := range values {
+		values[i] = byte(rnd.Intn(1+i&63) & rnd.Intn(256))
+	}
+
+	for _, name := range []string{"HUFFMAN", "ANS0", "FPAQ", "CM"} {
+		entropyType := entropy.GetType(name)
+		var reference []byte
+
+		for _, segments := range []uint{1, 3, 8} {
+			for _, jobs := range []uint{1, 4} {
+				fmt.Printf("Segmented %v with %d segments and %d jobs\n", name, segments, jobs)
+				ctx := map[string]interface{}{"blockSize": uint(len(values)), "size": uint(len(values)), "jobs": jobs}
+				var bs util.BufferStream
+				obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+				ee, err := entropy.NewSegmentedEncoder(obs, ctx, entropyType, segments)
+
+				if err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+
+				if _, err = ee.Write(values); err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+
+				ee.Dispose()
+				obs.Close()
+				encoded := make([]byte, bs.Len())
+				bs.Read(encoded)
+
+				if max := entropy.MaxSegmentedEncodedLen(entropyType, len(values), int(segments)); len(encoded) > max {
+					b.Errorf("%v: encoded size %d above bound %d", name, len(encoded), max)
+				}
+
+				// The output does not depend on the number of jobs
+				if jobs == 1 {
+					reference = encoded
+				} else if bytes.Equal(reference, encoded) == false {
+					b.Errorf("%v: the output depends on the number of jobs", name)
+				}
+
+				ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+				ed, _ := entropy.NewSegmentedDecoder(ibs, ctx, entropyType)
+				values2 := make([]byte, len(values))
+
+				if _, err = ed.Read(values2); err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+
+				ed.Dispose()
+
+				if bytes.Equal(values, values2) == false {
+					b.Errorf("%v: %d segments, decoded data differs from input", name, segments)
+				}
+			}
+		}
+	}
+
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+	if _, err := entropy.NewSegmentedEncoder(obs, nil, entropy.HUFFMAN_TYPE, entropy.MAX_ENTROPY_SEGMENTS+1); err == nil {
+		b.Errorf("No error for an invalid number of segments")
+	}
+}
+
+func TestHuffmanOrder1Text(b *testing.T) {
+	// Text from a small vocabulary: the next letter depends on the previous one
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"the ", "order ", "one ", "huffman ", "codec ", "uses ", "contexts ", "of ",
+		"previous ", "bytes ", "to ", "select ", "a ", "code ", "table ", "quickly ", "\n"}
+	var buf bytes.Buffer
+
+	for buf.Len() < 300000 {
+		buf.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	values := buf.Bytes()
+	sizes := make(map[string]int)
+
+	for _, name := range []string{"HUFFMAN", "HUFFMAN1", "RANGE"} {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+		ee := getEncoder(name, obs)
+
+		if _, err := ee.Write(values); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ee.Dispose()
+		obs.Close()
+		sizes[name] = bs.Len()
+		encoded := make([]byte, bs.Len())
+		bs.Read(encoded)
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+		ed := getDecoder(name, ibs)
+		values2 := make([]byte, len(values))
+
+		if _, err := ed.Read(values2); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(values, values2) == false {
+			b.Errorf("%v: decoded data differs from input", name)
+		}
+	}
+
+	fmt.Printf("Text: HUFFMAN=%d bytes HUFFMAN1=%d bytes RANGE=%d bytes\n", sizes["HUFFMAN"], sizes["HUFFMAN1"], sizes["RANGE"])
+
+	if sizes["HUFFMAN1"] >= sizes["RANGE"] {
+		b.Errorf("The order 1 Huffman codec does not beat the order 0 range codec: %v", sizes)
+	}
+}
+
+func TestEstimateCompressedSize(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	types := []string{"NONE", "HUFFMAN", "HUFFMAN1", "HUFFMANRL", "ANS0", "ANS1", "RANGE", "FSE", "FPAQ", "CM", "FPAQ32", "CM32", "LZMODEL"}
+
+	for _, size := range []int{1, 1000, 50000, 200000} {
+		values := make([]byte, size)
+		rng := 2 + rnd.Intn(254)
+
+		for i := range values {
+			values[i] = byte(rnd.Intn(rng) & rnd.Intn(256))
+		}
+
+		for _, name := range types {
+			entropyType := entropy.GetType(name)
+			estimated, err := entropy.EstimateCompressedSize(values, entropyType)
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			ctx := map[string]interface{}{"size": uint(size), "blockSize": uint(size)}
+			ee, _ := entropy.NewEntropyEncoder(obs, ctx, entropyType)
+
+			if _, err = ee.Write(values); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			ee.Dispose()
+			written := int((obs.Written() + 7) >> 3)
+			obs.Close()
+			fmt.Printf("%-8v %6d bytes: estimated %6d, written %6d\n", name, size, estimated, written)
+
+			if estimated != written {
+				b.Errorf("%v: incorrect estimate for %d bytes: %d instead of %d", name, size, estimated, written)
+			}
+		}
+	}
+
+	if _, err := entropy.EstimateCompressedSize(make([]byte, 10), 31); err == nil {
+		b.Errorf("No error for an invalid entropy type")
+	}
+}
+
+func TestFrequencyTable(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	lines := []string{"GET /index.html 200\n", "GET /favicon.ico 404\n", "POST /login 302\n"}
+	var sample bytes.Buffer
+
+	for sample.Len() < 100000 {
+		sample.WriteString(lines[rnd.Intn(len(lines))])
+	}
+
+	for _, logRange := range []uint{8, 12, 16} {
+		table, err := entropy.NewFrequencyTable(sample.Bytes(), logRange)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		// Persist and restore the table
+		data, _ := table.MarshalBinary()
+		var table2 entropy.FrequencyTable
+
+		if err := table2.UnmarshalBinary(data); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		fixedSize := 0
+		rangeSize := 0
+
+		for n := 0; n < 10; n++ {
+			// Small blocks of the same kind (plus bytes missing from the sample)
+			var buf bytes.Buffer
+
+			for buf.Len() < 2000 {
+				buf.WriteString(lines[rnd.Intn(len(lines))])
+			}
+
+			block := append(buf.Bytes(), byte(n), 0xFF)
+			var bs util.BufferStream
+			obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+			ee, _ := entropy.NewRangeEncoderWithTable(obs, table)
+
+			if _, err := ee.Write(block); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			ee.Dispose()
+			obs.Close()
+			fixedSize += bs.Len()
+			encoded := make([]byte, bs.Len())
+			bs.Read(encoded)
+			ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+			ed, _ := entropy.NewRangeDecoderWithTable(ibs, &table2)
+			decoded := make([]byte, len(block))
+
+			if _, err := ed.Read(decoded); err != nil {
+				b.Fatalf("%v", err)
+			}
+
+			if bytes.Equal(block, decoded) == false {
+				b.Errorf("logRange=%d: decoded data differs from input", logRange)
+			}
+
+			size, _ := entropy.EstimateCompressedSize(block, entropy.RANGE_TYPE)
+			rangeSize += size
+		}
+
+		fmt.Printf("Log range %d: fixed table=%d bytes, range=%d bytes\n", logRange, fixedSize, rangeSize)
+
+		if logRange == 12 && fixedSize >= rangeSize {
+			b.Errorf("The fixed table does not save the per block headers: %d >= %d", fixedSize, rangeSize)
+		}
+	}
+
+	// Symbols with a null frequency cannot be encoded
+	freqs := make([]int, 256)
+	freqs['a'] = 3000
+	freqs['b'] = 1096
+	table, err := entropy.NewFrequencyTableWithFrequencies(freqs, 12)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+	ee, _ := entropy.NewRangeEncoderWithTable(obs, table)
+
+	if _, err := ee.Write([]byte("abc")); err == nil {
+		b.Errorf("No error for a symbol missing from the frequency table")
+	}
+
+	freqs['b'] = 1000
+
+	if _, err := entropy.NewFrequencyTableWithFrequencies(freqs, 12); err == nil {
+		b.Errorf("No error for frequencies not summing to the range")
+	}
+}
+
+func TestHuffmanRLRuns(b *testing.T) {
+	// MTFT like data: mostly runs of zeros, small values in between
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	values := make([]byte, 200000)
+
+	for i := 0; i < len(values); {
+		run := rnd.Intn(40)
+
+		// Some long runs (escaped lengths)
+		if rnd.Intn(200) == 0 {
+			run = 2000 + rnd.Intn(8000)
+		}
+
+		i += run
+
+		if i < len(values) {
+			values[i] = byte(1 + rnd.Intn(1+rnd.Intn(20)))
+			i++
+		}
+	}
+
+	sizes := make(map[string]int)
+
+	for _, name := range []string{"HUFFMAN", "HUFFMANRL"} {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+		ee := getEncoder(name, obs)
+
+		if _, err := ee.Write(values); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ee.Dispose()
+		obs.Close()
+		sizes[name] = bs.Len()
+		encoded := make([]byte, bs.Len())
+		bs.Read(encoded)
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+		ed := getDecoder(name, ibs)
+		values2 := make([]byte, len(values))
+
+		if _, err := ed.Read(values2); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(values, values2) == false {
+			b.Errorf("%v: decoded data differs from input", name)
+		}
+	}
+
+	fmt.Printf("Runs: HUFFMAN=%d bytes HUFFMANRL=%d bytes\n", sizes["HUFFMAN"], sizes["HUFFMANRL"])
+
+	if sizes["HUFFMANRL"]*2 >= sizes["HUFFMAN"] {
+		b.Errorf("The run-length mode does not improve Huffman coding of runs: %v", sizes)
+	}
+}
+
+func TestStreamCodec(b *testing.T) {
+	types := []string{"NONE", "HUFFMAN", "HUFFMAN1", "HUFFMANRL", "AHUFF", "RICE", "ANS0", "ANS1", "RANGE", "FSE", "FPAQ", "CM", "TPAQ", "FPAQ32", "CM32", "LZMODEL"}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	values := make([]byte, 50000)
+
+	for i := range values {
+		values[i] = byte(64 + rnd.Intn(1+(i>>10)&31))
+	}
+
+	for _, name := range types {
+		fmt.Printf("Stream codec test for %v\n", name)
+		var bs util.BufferStream
+		se, err := entropy.NewStreamEncoder(&bs, entropy.GetType(name), 8192)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		// Writes of random sizes and a flush in the middle
+		for i := 0; i < len(values); {
+			n := 1 + rnd.Intn(5000)
+
+			if n > len(values)-i {
+				n = len(values) - i
+			}
+
+			if _, err := se.Write(values[i : i+n]); err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			i += n
+
+			if i >= len(values)/2 && i-n < len(values)/2 {
+				if err := se.Flush(); err != nil {
+					b.Fatalf("%v: %v", name, err)
+				}
+			}
+		}
+
+		if err := se.Close(); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		sd, err := entropy.NewStreamDecoder(&bs, entropy.GetType(name))
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		values2 := make([]byte, 0, len(values))
+		buf := make([]byte, 3000)
+
+		for {
+			r, err := sd.Read(buf)
+			values2 = append(values2, buf[0:r]...)
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+		}
+
+		if bytes.Equal(values, values2) == false {
+			b.Errorf("%v: decoded data differs from input", name)
+		}
+	}
+
+	var bs util.BufferStream
+
+	if _, err := entropy.NewStreamEncoder(&bs, 23); err == nil {
+		b.Errorf("No error for an invalid entropy type")
+	}
+
+	if _, err := entropy.NewStreamEncoder(&bs, entropy.HUFFMAN_TYPE, 100); err == nil {
+		b.Errorf("No error for an invalid chunk size")
+	}
+}
+
+func TestLZModelRatio(b *testing.T) {
+	// Binary records: counter, small values and patterns found by LZ
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	patterns := make([][]byte, 32)
+
+	for i := range patterns {
+		patterns[i] = make([]byte, 8+rnd.Intn(8))
+		rnd.Read(patterns[i])
+	}
+
+	input := make([]byte, 0, 300000)
+
+	for i := 0; len(input) < 250000; i++ {
+		input = append(input, byte(i*3), byte(i*3>>8), byte(i*3>>16), 0)
+		input = append(input, byte(rnd.Intn(4)), byte(rnd.Intn(16)))
+		input = append(input, patterns[rnd.Intn(len(patterns))]...)
+	}
+
+	lz, _ := function.NewLZCodec()
+	transformed := make([]byte, lz.MaxEncodedLen(len(input)))
+	_, dstIdx, err := lz.Forward(input, transformed)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	transformed = transformed[0:dstIdx]
+	sizes := make(map[string]int)
+
+	for _, name := range []string{"FPAQ", "CM", "LZMODEL"} {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+		ee := getEncoder(name, obs)
+
+		if _, err := ee.Write(transformed); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ee.Dispose()
+		obs.Close()
+		sizes[name] = bs.Len()
+		encoded := make([]byte, bs.Len())
+		bs.Read(encoded)
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+		ed := getDecoder(name, ibs)
+		decoded := make([]byte, len(transformed))
+
+		if _, err := ed.Read(decoded); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(transformed, decoded) == false {
+			b.Errorf("%v: decoded data differs from input", name)
+		}
+	}
+
+	fmt.Printf("LZ output (%d bytes): FPAQ=%d bytes CM=%d bytes LZMODEL=%d bytes\n",
+		len(transformed), sizes["FPAQ"], sizes["CM"], sizes["LZMODEL"])
+
+	if sizes["LZMODEL"] >= sizes["FPAQ"] || sizes["LZMODEL"] >= sizes["CM"] {
+		b.Errorf("The LZ models do not improve the coding of the LZ output: %v", sizes)
+	}
+}
+
+func TestHuffmanMaxCodeLen(b *testing.T) {
+	// Fibonacci frequencies: the Huffman codes are as long as possible
+	values := make([]byte, 0, 11000)
+	f0, f1 := 1, 1
+
+	for s := 0; s < 19; s++ {
+		for i := 0; i < f0; i++ {
+			values = append(values, byte(s))
+		}
+
+		f0, f1 = f1, f0+f1
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rnd.Shuffle(len(values), func(i, j int) { values[i], values[j] = values[j], values[i] })
+	sizes := make(map[uint]int)
+
+	for _, maxLen := range []uint{8, 12, 14, 16, 24} {
+		var bs util.BufferStream
+		obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+		ee, err := entropy.NewHuffmanEncoder(obs, 16384, maxLen)
+
+		if err != nil {
+			b.Fatalf("Max code length %v: %v", maxLen, err)
+		}
+
+		if _, err := ee.Write(values); err != nil {
+			b.Fatalf("Max code length %v: %v", maxLen, err)
+		}
+
+		ee.Dispose()
+		obs.Close()
+		size := bs.Len()
+		encoded := make([]byte, size)
+		bs.Read(encoded)
+		ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(encoded), 16384)
+		ed, _ := entropy.NewHuffmanDecoder(ibs)
+		values2 := make([]byte, len(values))
+
+		if _, err := ed.Read(values2); err != nil {
+			b.Fatalf("Max code length %v: %v", maxLen, err)
+		}
+
+		ed.Dispose()
+
+		if bytes.Equal(values, values2) == false {
+			b.Errorf("Max code length %v: decoded data differs from input", maxLen)
+		}
+
+		fmt.Printf("Max code length %v: %d bytes\n", maxLen, size)
+		sizes[maxLen] = size
+	}
+
+	// The code lengths in the chunk headers cost a bit more with long codes,
+	// check the gain of the longest codes only
+	if sizes[24] >= sizes[8] {
+		b.Errorf("Longer codes do not improve compression: %v", sizes)
+	}
+
+	var bs util.BufferStream
+	obs, _ := bitstream.NewDefaultOutputBitStream(&bs, 16384)
+
+	for _, maxLen := range []uint{7, 25} {
+		if _, err := entropy.NewHuffmanEncoder(obs, 16384, maxLen); err == nil {
+			b.Errorf("Max code length %v: no error", maxLen)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/frame"
+)
+
+func TestFrame(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	messages := [][]byte{
+		{},
+		[]byte("tiny"),
+		[]byte(strings.Repeat(`{"id":123,"name":"order","status":"shipped"},`, 20)),
+		make([]byte, 1000),
+		make([]byte, 70000),
+	}
+
+	// Incompressible message
+	rnd.Read(messages[3])
+
+	for i := range messages[4] {
+		messages[4][i] = byte(65 + rnd.Intn(1+i&7))
+	}
+
+	dict := []byte(strings.Repeat(`{"id":0,"name":"order","status":"pending"},`, 4))
+	decoder := frame.NewDecoder()
+
+	if err := decoder.AddDictionary(7, dict); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for level := 0; level <= 8; level++ {
+		for _, withDict := range []bool{false, true} {
+			var encoder *frame.Encoder
+			var err error
+
+			if withDict == true {
+				encoder, err = frame.NewEncoderWithDictionary(level, 7, dict)
+			} else {
+				encoder, err = frame.NewEncoder(level)
+			}
+
+			if err != nil {
+				b.Fatalf("Level %d: %v", level, err)
+			}
+
+			for _, msg := range messages {
+				f, err := encoder.Encode(nil, msg)
+
+				if err != nil {
+					b.Fatalf("Level %d: %v", level, err)
+				}
+
+				if len(f) > len(msg)+1 {
+					b.Errorf("Level %d: frame larger than stored message (%d => %d)", level, len(msg), len(f))
+				}
+
+				prefix := []byte("prefix")
+				res, err := decoder.Decode(prefix, f)
+
+				if err != nil {
+					b.Fatalf("Level %d, dictionary %v: %v", level, withDict, err)
+				}
+
+				if bytes.Equal(res[len(prefix):], msg) == false || string(res[0:len(prefix)]) != "prefix" {
+					b.Fatalf("Level %d, dictionary %v: incorrect decoded message", level, withDict)
+				}
+
+				if n, _ := frame.DecodedLen(f); n != len(msg) {
+					b.Errorf("Level %d: incorrect decoded length %d", level, n)
+				}
+			}
+
+			f, _ := encoder.Encode(nil, messages[2])
+			fmt.Printf("Level %d, dictionary %v: %d => %d bytes\n", level, withDict, len(messages[2]), len(f))
+		}
+	}
+
+	// Unknown dictionary and corrupted frames
+	encoder, _ := frame.NewEncoderWithDictionary(1, 8, dict)
+	f, _ := encoder.Encode(nil, messages[2])
+
+	if _, err := decoder.Decode(nil, f); err == nil {
+		b.Errorf("No error for unknown dictionary")
+	}
+
+	for _, corrupted := range [][]byte{{}, {0x31}, {0x81}, {0x0F, 0x00}, {0x01, 0x00, 0xFF}} {
+		if _, err := decoder.Decode(nil, corrupted); err == nil {
+			b.Errorf("No error for corrupted frame %x", corrupted)
+		}
+	}
+}
+
+func TestFrameConcurrency(b *testing.T) {
+	encoder, _ := frame.NewEncoder(2)
+	decoder := frame.NewDecoder()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(id int) {
+			defer wg.Done()
+
+			for n := 0; n < 100; n++ {
+				msg := []byte(strings.Repeat(fmt.Sprintf("message %d from goroutine %d;", n, id), 1+n%10))
+				f, err := encoder.Encode(nil, msg)
+
+				if err == nil {
+					var res []byte
+
+					if res, err = decoder.Decode(nil, f); err == nil && bytes.Equal(res, msg) == false {
+						err = fmt.Errorf("Incorrect decoded message")
+					}
+				}
+
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		b.Errorf("%v", err)
+	}
+}
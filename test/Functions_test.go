@@ -16,8 +16,12 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -74,6 +78,80 @@ func TestZRLT(b *testing.T) {
 	}
 }
 
+func TestMRLT(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, symbol := range []byte{0, ' ', 0xFF, 0xFE, 1} {
+		for ii := 0; ii < 10; ii++ {
+			// Runs of the dominant byte between random bytes
+			input := make([]byte, 1000+rnd.Intn(50000))
+
+			for i := 0; i < len(input); {
+				for end := i + rnd.Intn(64); i < end && i < len(input); i++ {
+					input[i] = symbol
+				}
+
+				for end := i + rnd.Intn(8); i < end && i < len(input); i++ {
+					input[i] = byte(rnd.Intn(256))
+				}
+			}
+
+			f, _ := function.NewMRLT()
+			output := make([]byte, f.MaxEncodedLen(len(input)))
+			_, dstIdx, err := f.Forward(input, output)
+
+			if err != nil {
+				b.Fatalf("Symbol %d: %v", symbol, err)
+			}
+
+			if output[0] != symbol {
+				b.Errorf("Symbol %d: incorrect symbol selected: %d", symbol, output[0])
+			}
+
+			if ii == 0 {
+				fmt.Printf("Symbol %d: %d => %d bytes\n", symbol, len(input), dstIdx)
+			}
+
+			// Same encoding as ZRLT for the zero runs
+			if symbol == 0 {
+				g, _ := function.NewZRLT()
+				ref := make([]byte, g.MaxEncodedLen(len(input)))
+
+				if _, n, _ := g.Forward(input, ref); bytes.Equal(ref[0:n], output[1:dstIdx]) == false {
+					b.Errorf("Symbol %d: output differs from ZRLT", symbol)
+				}
+			}
+
+			reverse := make([]byte, len(input))
+			_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+			if err != nil {
+				b.Fatalf("Symbol %d: %v", symbol, err)
+			}
+
+			if int(n) != len(input) || bytes.Equal(input, reverse) == false {
+				b.Errorf("Symbol %d: decompressed data differs from input", symbol)
+			}
+		}
+	}
+
+	// Forced symbol: no run of 0 in text
+	ctx := map[string]interface{}{"mrltSymbol": uint(0)}
+	f, _ := function.NewMRLTWithCtx(&ctx)
+	input := []byte(strings.Repeat("spaces    are the     dominant byte      of this text      ", 100))
+	output := make([]byte, f.MaxEncodedLen(len(input)))
+
+	if _, _, err := f.Forward(input, output); err == nil {
+		b.Errorf("No error for a forced symbol without runs")
+	}
+
+	ctx["mrltSymbol"] = uint(256)
+
+	if _, err := function.NewMRLTWithCtx(&ctx); err == nil {
+		b.Errorf("No error for an invalid symbol")
+	}
+}
+
 func TestRLT(b *testing.T) {
 	if err := testFunctionCorrectness("RLT"); err != nil {
 		b.Errorf(err.Error())
@@ -86,6 +164,99 @@ func TestSRT(b *testing.T) {
 	}
 }
 
+func TestSRTChunks(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		// Regions with different alphabets
+		input := make([]byte, 10000+rnd.Intn(200000))
+
+		for i := 0; i < len(input); {
+			base, size := rnd.Intn(200), 1+rnd.Intn(40)
+
+			for end := i + 1000 + rnd.Intn(30000); i < end && i < len(input); i++ {
+				input[i] = byte(base + rnd.Intn(size))
+			}
+		}
+
+		if ii == 0 {
+			for i := range input {
+				input[i] = 65
+			}
+		}
+
+		chunkSize := uint(1) << uint(12+rnd.Intn(6))
+		fmt.Printf("Test %d: %d bytes, chunks of %d bytes\n", ii, len(input), chunkSize)
+		ctx := map[string]interface{}{"srtChunkSize": chunkSize}
+		f, err := function.NewSRTWithCtx(&ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("Test %d: %v", ii, err)
+		}
+
+		// A block smaller than a chunk uses the format without chunks
+		if len(input) <= int(chunkSize) {
+			g, _ := function.NewSRT()
+			ref := make([]byte, g.MaxEncodedLen(len(input)))
+
+			if _, n, _ := g.Forward(input, ref); bytes.Equal(ref[0:n], output[0:dstIdx]) == false {
+				b.Errorf("Test %d: incorrect format of a single chunk", ii)
+			}
+		}
+
+		// The decoder does not need the chunk size
+		g, _ := function.NewSRT()
+		reverse := make([]byte, len(input))
+		_, n, err := g.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("Test %d: %v", ii, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse) == false {
+			b.Errorf("Test %d: decompressed data differs from input", ii)
+		}
+
+		// Truncated block
+		if len(input) > int(chunkSize) {
+			if _, _, err := g.Inverse(output[0:dstIdx-1], reverse); err == nil {
+				b.Errorf("Test %d: no error for a truncated block", ii)
+			}
+		}
+
+		// Chunks in a block of a version 9 stream
+		if len(input) > int(chunkSize) {
+			ctx = map[string]interface{}{"bsVersion": uint(9)}
+			g, _ = function.NewSRTWithCtx(&ctx)
+
+			if _, _, err := g.Inverse(output[0:dstIdx], reverse); err == nil {
+				b.Errorf("Test %d: no error for chunks in a version 9 stream", ii)
+			}
+		}
+	}
+
+	for _, chunkSize := range []uint{16, 4095, 1<<30 + 1} {
+		ctx := map[string]interface{}{"srtChunkSize": chunkSize}
+
+		if _, err := function.NewSRTWithCtx(&ctx); err == nil {
+			b.Errorf("No error for an invalid chunk size: %d", chunkSize)
+		}
+	}
+
+	ctx := map[string]interface{}{"srtChunkSize": uint(1 << 16), "bsVersion": uint(9)}
+
+	if _, err := function.NewSRTWithCtx(&ctx); err == nil {
+		b.Errorf("No error for a chunk size in a version 9 stream")
+	}
+}
+
 // func TestROLZX(b *testing.T) {
 // 	if err := testFunctionCorrectness("ROLZX"); err != nil {
 // 		b.Errorf(err.Error())
@@ -278,3 +449,1165 @@ func testFunctionCorrectness(name string) error {
 
 	return error(nil)
 }
+
+func TestLZShortDistances(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 0, 600000)
+
+	// Repeated patterns of 1 to 40 bytes: matches overlap their source
+	for len(input) < 500000 {
+		pattern := make([]byte, 1+rnd.Intn(40))
+		rnd.Read(pattern)
+		n := len(pattern) + rnd.Intn(300)
+
+		for i := 0; i < n; i++ {
+			input = append(input, pattern[i%len(pattern)])
+		}
+
+		input = append(input, byte(rnd.Intn(256)))
+	}
+
+	lzp := map[string]interface{}{"lz": function.LZP_TYPE}
+	codecs := map[string]func() (kanzi.ByteFunction, error){
+		"LZ":   func() (kanzi.ByteFunction, error) { return function.NewLZCodec() },
+		"LZP":  func() (kanzi.ByteFunction, error) { return function.NewLZCodecWithCtx(&lzp) },
+		"ROLZ": func() (kanzi.ByteFunction, error) { return function.NewROLZCodecWithFlag(false) },
+	}
+
+	for name, create := range codecs {
+		fmt.Printf("%v: short distance matches\n", name)
+		f, err := create()
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if f, err = create(); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) {
+			b.Fatalf("%v: incorrect size: %d instead of %d", name, n, len(input))
+		}
+
+		for i := range input {
+			if input[i] != reverse[i] {
+				b.Fatalf("%v: different values at index %d", name, i)
+			}
+		}
+	}
+}
+
+func TestLZParameters(b *testing.T) {
+	fmt.Println("LZ window, chain length and lazy matching")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// 1 MB of text like data, zeros and, 17 MB later, a copy of the text
+	input := make([]byte, 18*1024*1024)
+
+	for i := 0; i < 65536; i++ {
+		input[i] = byte(97 + rnd.Intn(16))
+	}
+
+	for i := 65536; i < 1024*1024; i++ {
+		if rnd.Intn(8) == 0 {
+			input[i] = byte(97 + rnd.Intn(16))
+		} else {
+			input[i] = input[i-65536+rnd.Intn(4096)]
+		}
+	}
+
+	copy(input[17*1024*1024:], input[0:1024*1024])
+
+	for _, bad := range []map[string]interface{}{
+		{"lzWindow": uint(3000)},
+		{"lzWindow": uint(1 << 29)},
+		{"lzChainLength": uint(0)},
+		{"lzLazyDepth": uint(5)},
+	} {
+		if _, err := function.NewLZCodecWithCtx(&bad); err == nil {
+			b.Errorf("%v: expected an error", bad)
+		}
+	}
+
+	sizes := make(map[string]int)
+
+	for _, params := range []map[string]interface{}{
+		{},
+		{"lzWindow": uint(1 << 16)},
+		{"lzWindow": uint(1 << 25)},
+		{"lzChainLength": uint(32)},
+		{"lzChainLength": uint(32), "lzLazyDepth": uint(2)},
+		{"lzWindow": uint(1 << 25), "lzChainLength": uint(16), "lzLazyDepth": uint(1)},
+	} {
+		name := fmt.Sprintf("%v", params)
+		f, err := function.NewLZCodecWithCtx(&params)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		fmt.Printf("%v: %v => %v\n", name, len(input), dstIdx)
+		sizes[name] = int(dstIdx)
+
+		// The decoder reads the window in the block header
+		f, _ = function.NewLZCodec()
+		reverse := make([]byte, len(input))
+
+		if _, _, err = f.Inverse(output[0:dstIdx], reverse); err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if bytes.Equal(input, reverse) == false {
+			b.Fatalf("%v: different output after inverse", name)
+		}
+
+		if params["lzWindow"] != nil {
+			// Invalid window in the block header (1 GB)
+			output[0] = 0x80 | 30
+
+			if _, _, err = f.Inverse(output[0:dstIdx], reverse); err == nil {
+				b.Errorf("%v: expected an invalid header error", name)
+			}
+		}
+	}
+
+	// The copy of the beginning can only be found with a window above 16 MB
+	if sizes["map[lzWindow:33554432]"] >= sizes["map[]"] {
+		b.Errorf("The 32 MB window should compress better than the default window")
+	}
+
+	if sizes["map[lzChainLength:32 lzLazyDepth:2]"] >= sizes["map[]"] {
+		b.Errorf("Chains and lazy matching should compress better than the default search")
+	}
+}
+
+func TestLZRepeat(b *testing.T) {
+	fmt.Println("LZ repeat codes")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Records copied from the previous one with a few bytes changed: the
+	// matches between the changes have the same distance
+	for _, size := range []int{300000, 1 << 20} {
+		for _, record := range []int{100, 65000} {
+			input := make([]byte, size)
+
+			for i := range input {
+				if i < record || rnd.Intn(24) == 0 {
+					input[i] = byte(rnd.Intn(256))
+				} else {
+					input[i] = input[i-record]
+				}
+			}
+
+			for _, window := range []uint{0, 1 << 25} {
+				sizes := make([]uint, 2)
+
+				for i, repeat := range []bool{false, true} {
+					ctx := map[string]interface{}{"lzRepeat": repeat}
+
+					if window != 0 {
+						ctx["lzWindow"] = window
+					}
+
+					f, _ := function.NewLZCodecWithCtx(&ctx)
+					output := make([]byte, f.MaxEncodedLen(len(input)))
+					_, dstIdx, err := f.Forward(input, output)
+
+					if err != nil {
+						b.Fatalf("Size %v, record %v: %v", size, record, err)
+					}
+
+					sizes[i] = dstIdx
+
+					// The decoder reads the repeat flag in the block header
+					f, _ = function.NewLZCodec()
+					reverse := make([]byte, len(input))
+
+					if _, _, err = f.Inverse(output[0:dstIdx], reverse); err != nil {
+						b.Fatalf("Size %v, record %v, repeat %v: %v", size, record, repeat, err)
+					}
+
+					if bytes.Equal(input, reverse) == false {
+						b.Fatalf("Size %v, record %v, repeat %v: different output after inverse", size, record, repeat)
+					}
+				}
+
+				fmt.Printf("Size %v, record %v, window %v: %v => %v (%v without repeat codes)\n",
+					size, record, window, size, sizes[1], sizes[0])
+
+				if sizes[1] >= sizes[0] {
+					b.Errorf("Size %v, record %v: the repeat codes should compress better", size, record)
+				}
+			}
+		}
+	}
+}
+
+func TestRunLengths(b *testing.T) {
+	codecs := map[string]func() (kanzi.ByteFunction, error){
+		"RLT":  func() (kanzi.ByteFunction, error) { return function.NewRLT() },
+		"ZRLT": func() (kanzi.ByteFunction, error) { return function.NewZRLT() },
+		"RLT (2 byte escape, 16 bit runs)": func() (kanzi.ByteFunction, error) {
+			ctx := map[string]interface{}{"rltEscape": uint(2), "rltRunWidth": uint(2)}
+			return function.NewRLTWithCtx(&ctx)
+		},
+	}
+
+	// Runs of every length around the 8 byte steps, at every alignment,
+	// and runs longer than the maximum RLT run
+	lengths := make([]int, 0)
+
+	for n := 1; n <= 40; n++ {
+		lengths = append(lengths, n)
+	}
+
+	lengths = append(lengths, 72170, 72171, 72172, 72180, 150000)
+
+	for name, create := range codecs {
+		fmt.Printf("%v: run lengths\n", name)
+
+		for _, length := range lengths {
+			for offset := 0; offset < 9; offset++ {
+				for _, val := range []byte{0, 1} {
+					input := make([]byte, 0, offset+length+9)
+
+					for i := 0; i < offset; i++ {
+						input = append(input, byte(2+i))
+					}
+
+					for i := 0; i < length; i++ {
+						input = append(input, val)
+					}
+
+					input = append(input, 7, 8, 9, 10, 11, 12, 13, 14, 15)
+					f, _ := create()
+					output := make([]byte, f.MaxEncodedLen(len(input)))
+					_, dstIdx, err := f.Forward(input, output)
+
+					// Short runs may not compress
+					if err != nil {
+						if length > 40 {
+							b.Fatalf("%v: length=%d, offset=%d: %v", name, length, offset, err)
+						}
+
+						continue
+					}
+
+					f, _ = create()
+					reverse := make([]byte, len(input))
+					_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+					if err != nil {
+						b.Fatalf("%v: length=%d, offset=%d: %v", name, length, offset, err)
+					}
+
+					if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+						b.Fatalf("%v: length=%d, offset=%d: incorrect output", name, length, offset)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRLTEscape(b *testing.T) {
+	fmt.Println("RLT escape test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// All the byte values with a few short runs: the escaped literals cost
+	// more than the runs save with a one byte escape
+	input := make([]byte, 100000)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	for i := 0; i < 150; i++ {
+		idx := 1 + rnd.Intn(len(input)-10)
+		copy(input[idx:idx+5], []byte{input[idx], input[idx], input[idx], input[idx], input[idx]})
+	}
+
+	for _, escape := range []uint{1, 0, 2} {
+		ctx := map[string]interface{}{"rltEscape": escape}
+		f, _ := function.NewRLTWithCtx(&ctx)
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if escape == 1 {
+			if err == nil {
+				b.Errorf("Input compressed with a one byte escape")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			b.Fatalf("escape=%d: %v", escape, err)
+		}
+
+		f, _ = function.NewRLT()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("escape=%d: %v", escape, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("escape=%d: incorrect output", escape)
+		}
+	}
+
+	ctx := map[string]interface{}{"rltRunWidth": uint(3)}
+
+	if _, err := function.NewRLTWithCtx(&ctx); err == nil {
+		b.Errorf("No error for an invalid run width")
+	}
+}
+
+func TestRLTTail(b *testing.T) {
+	fmt.Println("RLT tail test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	const rare = byte(7)
+
+	// The rarest byte (hence the escape) only appears in the last bytes,
+	// which are not scanned for runs
+	for _, tail := range [][]byte{{rare}, {rare, 1}, {1, rare}, {rare, rare}, {rare, 1, rare}, {2, 2, rare, 2}} {
+		var input []byte
+
+		for _, v := range rnd.Perm(256) {
+			if byte(v) != rare {
+				input = append(input, bytes.Repeat([]byte{byte(v)}, 20)...)
+			}
+		}
+
+		input = append(input, tail...)
+
+		for _, escape := range []uint{0, 1} {
+			ctx := map[string]interface{}{"rltEscape": escape}
+			f, _ := function.NewRLTWithCtx(&ctx)
+			output := make([]byte, f.MaxEncodedLen(len(input)))
+			_, dstIdx, err := f.Forward(append([]byte{}, input...), output)
+
+			if err != nil {
+				b.Fatalf("tail %v, escape=%d: %v", tail, escape, err)
+			}
+
+			reverse := make([]byte, len(input))
+			_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+			if err != nil {
+				b.Errorf("tail %v, escape=%d: %v", tail, escape, err)
+			} else if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+				b.Errorf("tail %v, escape=%d: incorrect output", tail, escape)
+			}
+		}
+	}
+}
+
+func TestDNA(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var fasta bytes.Buffer
+
+	// FASTA records: header, lines of 60 nucleotides with runs of N, lower
+	// case regions and a few IUPAC codes
+	for r := 0; r < 4; r++ {
+		fmt.Fprintf(&fasta, ">seq%d random sequence, %d bases\n", r, 20000+r)
+		seq := make([]byte, 20000+r)
+
+		for i := range seq {
+			seq[i] = "ACGT"[rnd.Intn(4)]
+		}
+
+		copy(seq[1000:], bytes.Repeat([]byte{'N'}, 500))
+		copy(seq[5000:], bytes.ToLower(seq[5000:6000]))
+		seq[7000] = 'R'
+		seq[7001] = 'y'
+
+		for i := 0; i < len(seq); i += 60 {
+			end := i + 60
+
+			if end > len(seq) {
+				end = len(seq)
+			}
+
+			fasta.Write(seq[i:end])
+			fasta.WriteByte('\n')
+		}
+	}
+
+	inputs := map[string][]byte{
+		"FASTA":      fasta.Bytes(),
+		"raw":        bytes.Repeat([]byte("ACGGTTCA"), 1001),
+		"lower case": bytes.Repeat([]byte("acgt"), 100),
+		"N block":    append(bytes.Repeat([]byte{'N'}, 300), bytes.Repeat([]byte("TGCA"), 200)...),
+	}
+
+	for name, input := range inputs {
+		fmt.Printf("DNA: %v (%d bytes)\n", name, len(input))
+		f, _ := function.NewDNACodec()
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(dstIdx) > len(input)/3 {
+			b.Errorf("%v: packed block too large: %d bytes", name, dstIdx)
+		}
+
+		f, _ = function.NewDNACodec()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+
+		// Truncated blocks must be rejected (or decoded), not crash
+		for i := uint(0); i < dstIdx; i += 1 + dstIdx/50 {
+			f.Inverse(output[0:i], reverse)
+		}
+	}
+
+	// Other data is not packed
+	text := []byte(strings.Repeat("The DNA codec only packs nucleotide sequences. ", 100))
+	f, _ := function.NewDNACodec()
+
+	if _, _, err := f.Forward(text, make([]byte, f.MaxEncodedLen(len(text)))); err == nil {
+		b.Errorf("No error for text input")
+	}
+}
+
+func TestJSON(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var records bytes.Buffer
+	records.WriteString("[\n")
+
+	for r := 0; r < 500; r++ {
+		fmt.Fprintf(&records, "  {\"id\": %d, \"name\": \"user\\\"%d\\\"\", \"score\": %d.%de-%d,", r, rnd.Intn(1000), rnd.Intn(100), rnd.Intn(1000), rnd.Intn(5))
+		fmt.Fprintf(&records, " \"active\": %v, \"tags\": [\"a\", \"b\"], \"pos\": {\"x\": -%d, \"y\": %d}},\n", rnd.Intn(2) == 0, rnd.Intn(500), rnd.Intn(500))
+	}
+
+	records.WriteString("  {}\n]\n")
+	input := records.Bytes()
+
+	inputs := map[string][]byte{
+		"records":         input,
+		"cut in a string": input[0 : len(input)/2+7],
+		"control bytes":   append([]byte("{\"\x01\": \x00\x02\x03, \"k\": \"\x03\"}"), input...),
+	}
+
+	for name, input := range inputs {
+		fmt.Printf("JSON: %v (%d bytes)\n", name, len(input))
+		ctx := make(map[string]interface{})
+		ctx["textcodec"] = 3
+		f, _ := function.NewTextCodecWithCtx(&ctx)
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		f, _ = function.NewTextCodecWithCtx(&ctx)
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+
+		// Truncated or corrupted blocks must be rejected (or decoded), not crash
+		for i := uint(0); i < dstIdx; i += 1 + dstIdx/50 {
+			f.Inverse(output[0:i], reverse)
+			output[i] ^= 0x55
+			f.Inverse(output[0:dstIdx], reverse)
+			output[i] ^= 0x55
+		}
+	}
+
+	// Other data is not split
+	text := []byte(strings.Repeat("The JSON codec only splits \"JSON\" blocks: 12, 3.4. ", 100))
+	ctx := make(map[string]interface{})
+	ctx["textcodec"] = 3
+	f, _ := function.NewTextCodecWithCtx(&ctx)
+
+	if _, _, err := f.Forward(text, make([]byte, f.MaxEncodedLen(len(text)))); err == nil {
+		b.Errorf("No error for text input")
+	}
+}
+
+func TestEXE(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	arm64 := make([]byte, 64*1024)
+	riscv := make([]byte, 64*1024)
+	rnd.Read(arm64)
+	rnd.Read(riscv)
+
+	// Calls to 16 functions: all the calls to a function must be identical
+	// after the transform
+	functions := make([]int, 16)
+
+	for i := range functions {
+		functions[i] = rnd.Intn(len(arm64)) &^ 3
+	}
+
+	calls := make([]int, 0)
+
+	for i := 0; i < len(arm64); i += 4 {
+		switch rnd.Intn(8) {
+		case 0: // BL
+			offset := uint32(functions[rnd.Intn(len(functions))]-i) >> 2
+			binary.LittleEndian.PutUint32(arm64[i:], 0x94000000|(offset&0x03FFFFFF))
+			calls = append(calls, i)
+
+		case 1: // ADRP x0, page
+			page := uint32(rnd.Intn(64) - 32)
+			binary.LittleEndian.PutUint32(arm64[i:], 0x90000000|((page&3)<<29)|((page>>2)&0x7FFFF)<<5)
+		}
+	}
+
+	for i := 0; i+8 <= len(riscv); i += 8 {
+		target := uint32(functions[rnd.Intn(len(functions))]-i) &^ 1
+
+		switch rnd.Intn(4) {
+		case 0: // JAL ra
+			imm := ((target & 0x100000) << 11) | ((target & 0x7FE) << 20) | ((target & 0x800) << 9) | (target & 0xFF000)
+			binary.LittleEndian.PutUint32(riscv[i:], imm|0x000000EF)
+			i -= 2 // then a compressed instruction
+
+		case 1: // AUIPC ra + JALR ra
+			low := uint32(int32(target<<20) >> 20)
+			binary.LittleEndian.PutUint32(riscv[i:], ((target-low)&0xFFFFF000)|0x00000097)
+			binary.LittleEndian.PutUint32(riscv[i+4:], (target<<20)|0x000080E7)
+		}
+	}
+
+	inputs := map[string][]byte{"ARM64": arm64, "RISCV": riscv}
+
+	for name, input := range inputs {
+		fmt.Printf("EXE: %v (%d bytes)\n", name, len(input))
+		ctx := make(map[string]interface{})
+		ctx["exe"] = function.ARM64_TYPE
+
+		if name == "RISCV" {
+			ctx["exe"] = function.RISCV_TYPE
+		}
+
+		f, _ := function.NewEXECodecWithCtx(&ctx)
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if name == "ARM64" {
+			targets := make(map[uint32]bool)
+
+			for _, i := range calls {
+				targets[binary.LittleEndian.Uint32(output[i:])] = true
+			}
+
+			if len(targets) > len(functions) {
+				b.Errorf("%v: %d different calls, expected %d", name, len(targets), len(functions))
+			}
+		}
+
+		f, _ = function.NewEXECodecWithCtx(&ctx)
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+
+		// Random data and text are not converted
+		rnd.Read(input)
+		text := []byte(strings.Repeat("The EXE codec only converts ARM64 and RISC-V code. ", 1000))
+
+		if _, _, err := f.Forward(input, output); err == nil {
+			b.Errorf("%v: no error for random input", name)
+		}
+
+		if _, _, err := f.Forward(text, output); err == nil {
+			b.Errorf("%v: no error for text input", name)
+		}
+	}
+}
+
+func TestAudio(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Decaying tones with some noise
+	tone := func(i, rate int) float64 {
+		t := float64(i%(rate/4)) / float64(rate)
+		f := 220.0 * float64(1+(i/(rate/4))%4)
+		return math.Exp(-3*t)*math.Sin(2*math.Pi*f*float64(i)/float64(rate)) + rnd.NormFloat64()*0.003
+	}
+
+	var wav bytes.Buffer
+	frames := 20000
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+4*frames))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, []uint32{16, 0x00020001, 44100, 4 * 44100, 0x00100004})
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(4*frames))
+
+	for i := 0; i < frames; i++ {
+		s := tone(i, 44100)
+		binary.Write(&wav, binary.LittleEndian, []int16{int16(s * 25000), int16(s * 20000)})
+	}
+
+	// Next block of a stereo file not starting at a frame boundary
+	stereo := wav.Bytes()[1001:]
+	mono := make([]byte, 50000)
+
+	for i := range mono {
+		mono[i] = byte(128 + int(tone(i, 8000)*100))
+	}
+
+	inputs := map[string][]byte{"WAV": wav.Bytes(), "stereo": stereo, "mono": mono}
+	modes := map[string]byte{"WAV": 0x49, "stereo": 0x49, "mono": 0x40}
+
+	for name, input := range inputs {
+		fmt.Printf("Audio: %v (%d bytes)\n", name, len(input))
+		f, _ := function.NewAudioCodec()
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if output[0] != modes[name] {
+			b.Errorf("%v: incorrect format: %#x", name, output[0])
+		}
+
+		f, _ = function.NewAudioCodec()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+
+		// Truncated blocks must be rejected (or decoded), not crash
+		for i := uint(0); i < dstIdx; i += 1 + dstIdx/50 {
+			f.Inverse(output[0:i], reverse)
+		}
+	}
+
+	// Other data is not predicted
+	text := []byte(strings.Repeat("The audio codec only predicts PCM samples. ", 100))
+	f, _ := function.NewAudioCodec()
+
+	if _, _, err := f.Forward(text, make([]byte, f.MaxEncodedLen(len(text)))); err == nil {
+		b.Errorf("No error for text input")
+	}
+}
+
+func TestImage(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Gradients with some noise
+	pixels := func(width, height, pixelSize, rowSize int) []byte {
+		res := make([]byte, height*rowSize)
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				for c := 0; c < pixelSize; c++ {
+					res[y*rowSize+x*pixelSize+c] = byte(x*(c+1) + y*2 + rnd.Intn(5))
+				}
+			}
+		}
+
+		return res
+	}
+
+	var bmp bytes.Buffer
+	bmp.WriteString("BM")
+	binary.Write(&bmp, binary.LittleEndian, []uint32{54 + 200*304, 0, 54, 40, 101, 200, 0x00180001, 0, 200 * 304, 2835, 2835, 0, 0})
+	bmp.Write(pixels(101, 200, 3, 304))
+
+	var ppm bytes.Buffer
+	ppm.WriteString("P5\n# gray\n300 200\n255\n")
+	ppm.Write(pixels(300, 200, 1, 300))
+
+	var tga bytes.Buffer
+	tga.Write([]byte{3, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 200, 0, 150, 0, 32, 8, 'i', 'd', '!'})
+	tga.Write(pixels(200, 150, 4, 800))
+
+	// Next block of a file: no header
+	raw := pixels(400, 300, 3, 1200)[1000:]
+
+	inputs := map[string][]byte{"BMP": bmp.Bytes(), "PPM": ppm.Bytes(), "TGA": tga.Bytes(), "raw": raw}
+	formats := map[string][]int{"BMP": {3, 304, 54}, "PPM": {1, 300, 22}, "TGA": {4, 800, 21}, "raw": {0, 1200, 0}}
+
+	for name, input := range inputs {
+		fmt.Printf("Image: %v (%d bytes)\n", name, len(input))
+		f, _ := function.NewImageCodec()
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		format := []int{int(output[0]), int(binary.BigEndian.Uint32(output[1:])), int(binary.BigEndian.Uint32(output[5:]))}
+
+		if name == "raw" {
+			// Any pixel size predicts as well with the previous row
+			format[0] = 0
+		}
+
+		if fmt.Sprint(format) != fmt.Sprint(formats[name]) {
+			b.Errorf("%v: incorrect format: %v, expected %v", name, format, formats[name])
+		}
+
+		f, _ = function.NewImageCodec()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+
+		// Truncated or corrupted blocks must be rejected (or decoded), not crash
+		for i := uint(0); i < dstIdx; i += 1 + dstIdx/50 {
+			f.Inverse(output[0:i], reverse)
+			output[i] ^= 0x55
+			f.Inverse(output[0:dstIdx], reverse)
+			output[i] ^= 0x55
+		}
+	}
+
+	// Other data is not filtered
+	words := strings.Fields("the image codec only filters the rows of raster images and leaves other data unchanged")
+	var text []byte
+
+	for len(text) < 10000 {
+		text = append(text, words[rnd.Intn(len(words))]...)
+		text = append(text, ' ')
+	}
+
+	f, _ := function.NewImageCodec()
+
+	if _, _, err := f.Forward(text, make([]byte, f.MaxEncodedLen(len(text)))); err == nil {
+		b.Errorf("No error for text input")
+	}
+}
+
+func TestDelta(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Timestamps (32 bits) with a jittered period, counter (16 bits) and
+	// slowly changing floating point values
+	var timestamps, counters, floats bytes.Buffer
+	ts := uint32(1600000000)
+	val := float32(20.0)
+
+	for i := 0; i < 20000; i++ {
+		ts += uint32(1000 + rnd.Intn(3))
+		binary.Write(&timestamps, binary.LittleEndian, ts)
+		binary.Write(&counters, binary.LittleEndian, uint16(i))
+		val += float32(rnd.Intn(3)-1) * 0.125
+		binary.Write(&floats, binary.LittleEndian, math.Float32bits(val))
+	}
+
+	inputs := map[string][]byte{"timestamps": timestamps.Bytes(), "counters": counters.Bytes()[1:], "floats": floats.Bytes()}
+	modes := map[string]byte{"timestamps": 0x06, "counters": 0x05}
+
+	for name, input := range inputs {
+		fmt.Printf("Delta: %v (%d bytes)\n", name, len(input))
+		f, _ := function.NewDeltaCodec()
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if m, exists := modes[name]; exists == true && output[0] != m {
+			b.Errorf("%v: incorrect mode: %#x", name, output[0])
+		}
+
+		f, _ = function.NewDeltaCodec()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+	}
+
+	// Every mode given in the context round trips any data
+	input := make([]byte, 1003)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	for _, stride := range []uint{1, 2, 4, 8} {
+		for _, order := range []uint{1, 2} {
+			for _, xor := range []bool{false, true} {
+				ctx := map[string]interface{}{"deltaStride": stride, "deltaOrder": order, "deltaXor": xor}
+				f, err := function.NewDeltaCodecWithCtx(&ctx)
+
+				if xor == true && order == 2 {
+					if err == nil {
+						b.Errorf("No error for xor with order 2")
+					}
+
+					continue
+				}
+
+				fmt.Printf("Delta: stride %d, order %d, xor %v\n", stride, order, xor)
+				output := make([]byte, f.MaxEncodedLen(len(input)))
+				_, dstIdx, err := f.Forward(input, output)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				reverse := make([]byte, len(input))
+				_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+				if err != nil {
+					b.Fatalf("%v", err)
+				}
+
+				if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+					b.Fatalf("Incorrect output for stride %d, order %d, xor %v", stride, order, xor)
+				}
+			}
+		}
+	}
+
+	// Other data is not predicted
+	text := []byte(strings.Repeat("The delta codec only predicts numbers. ", 100))
+	f, _ := function.NewDeltaCodec()
+
+	if _, _, err := f.Forward(text, make([]byte, f.MaxEncodedLen(len(text)))); err == nil {
+		b.Errorf("No error for text input")
+	}
+
+	if _, _, err := f.Inverse([]byte{0x0F, 1, 2}, make([]byte, 2)); err == nil {
+		b.Errorf("No error for an invalid mode")
+	}
+}
+
+func TestChain(b *testing.T) {
+	input := []byte(strings.Repeat("A chain of transforms is built from the names of its stages. ", 200))
+	names := []string{"RLT+TEXT+BWT+MTFT+ZRLT", "lz", "NONE+ROLZ", "BWTS+SRT+ZRLT", "NONE"}
+
+	for _, name := range names {
+		fmt.Printf("Chain: %v\n", name)
+		seq, err := function.NewChain(name)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		output := make([]byte, seq.MaxEncodedLen(len(input)))
+		_, dstIdx, err := seq.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		// The skip flags tell which stages have been applied
+		flags := seq.SkipFlags()
+		seq, _ = function.NewChain(name)
+		seq.SetSkipFlags(flags)
+		reverse := make([]byte, seq.MaxEncodedLen(len(input)))
+		_, n, err := seq.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+	}
+
+	// Invalid chains
+	for _, name := range []string{"", "RLT++BWT", "RLT+FOO", "BWT+MTFT+ZRLT+RLT+BWT+MTFT+ZRLT+RLT+TEXT"} {
+		if _, err := function.NewChain(name); err == nil {
+			b.Errorf("No error for '%v'", name)
+		} else {
+			fmt.Printf("Chain: %v\n", err)
+		}
+	}
+}
+
+func TestInverseInPlace(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	names := []string{"ZRLT", "MRLT", "RLT", "BWT", "MTFT", "RANK", "MTF1", "MTF2", "NONE",
+		"BWT+MTFT+ZRLT", "RLT+BWT+RANK+ZRLT", "BWT+MTF2+MRLT"}
+
+	for _, name := range names {
+		functionType, _ := function.ParseType(name)
+
+		if function.CanInverseInPlace(functionType) == false {
+			b.Fatalf("%v: cannot be inverted in place", name)
+		}
+
+		fmt.Printf("Inverse in place: %v\n", name)
+
+		for ii := 0; ii < 20; ii++ {
+			input := make([]byte, 1+rnd.Intn(100000))
+
+			// Runs, escapes (0xFE and 0xFF for ZRLT) and random bytes
+			for i := 0; i < len(input); {
+				val := byte(rnd.Intn(256))
+
+				switch ii % 4 {
+				case 0:
+					val = 0xFE + byte(rnd.Intn(2))
+				case 1:
+					val &= 3
+				}
+
+				for end := i + 1 + rnd.Intn(1+(ii%3)*40); i < end && i < len(input); i++ {
+					input[i] = val
+				}
+			}
+
+			ctx := map[string]interface{}{"size": uint(len(input))}
+			seq, _ := function.NewByteFunction(&ctx, functionType)
+			output := make([]byte, seq.MaxEncodedLen(len(input)))
+			_, dstIdx, err := seq.Forward(input, output)
+
+			if err != nil {
+				continue
+			}
+
+			flags := seq.SkipFlags()
+			seq, _ = function.NewByteFunction(&ctx, functionType)
+			seq.SetSkipFlags(flags)
+			// Same buffer size as the decoding tasks of the compressed streams
+			block := make([]byte, len(input)+1024)
+
+			if int(dstIdx)+256 > len(block) {
+				block = make([]byte, int(dstIdx)+256)
+			}
+
+			copy(block, output[0:dstIdx])
+			n, err := seq.InverseInPlace(block, dstIdx)
+
+			if err != nil {
+				b.Fatalf("%v: %v", name, err)
+			}
+
+			if int(n) != len(input) || bytes.Equal(input, block[0:n]) == false {
+				b.Fatalf("%v: decompressed data differs from input (size %d)", name, len(input))
+			}
+		}
+	}
+
+	for _, name := range []string{"LZ", "TEXT", "BWTS", "BWT+SRT+ZRLT"} {
+		if functionType, _ := function.ParseType(name); function.CanInverseInPlace(functionType) == true {
+			b.Errorf("%v: cannot be inverted in place", name)
+		}
+	}
+}
+
+func TestShuffle(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Floating point samples of a noisy signal (32 and 64 bits)
+	var floats, doubles bytes.Buffer
+	val := 20.0
+
+	for i := 0; i < 20000; i++ {
+		val += float64(rnd.Intn(5)-2) * 0.01
+		binary.Write(&floats, binary.LittleEndian, math.Float32bits(float32(val*math.Sin(float64(i)/300))))
+		binary.Write(&doubles, binary.LittleEndian, math.Float64bits(val))
+	}
+
+	inputs := map[string][]byte{"floats": floats.Bytes(), "doubles": doubles.Bytes()[3:]}
+	modes := map[string]byte{"floats": 2, "doubles": 3}
+
+	for name, input := range inputs {
+		fmt.Printf("Shuffle: %v (%d bytes)\n", name, len(input))
+		f, _ := function.NewShuffleCodec()
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if output[0] != modes[name] {
+			b.Errorf("%v: incorrect mode: %#x", name, output[0])
+		}
+
+		f, _ = function.NewShuffleCodec()
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("%v: incorrect output", name)
+		}
+	}
+
+	// Every width given in the context round trips any data
+	input := make([]byte, 1003)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(256))
+	}
+
+	for _, width := range []uint{2, 4, 8} {
+		fmt.Printf("Shuffle: width %d\n", width)
+		ctx := map[string]interface{}{"shuffleWidth": width}
+		f, err := function.NewShuffleCodecWithCtx(&ctx)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		output := make([]byte, f.MaxEncodedLen(len(input)))
+		_, dstIdx, err := f.Forward(input, output)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		reverse := make([]byte, len(input))
+		_, n, err := f.Inverse(output[0:dstIdx], reverse)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+			b.Fatalf("Incorrect output for width %d", width)
+		}
+	}
+
+	// Byte j of value i goes to plane j, the tail is unchanged
+	ctx := map[string]interface{}{"shuffleWidth": uint(2)}
+	f, _ := function.NewShuffleCodecWithCtx(&ctx)
+	output := make([]byte, 6)
+	f.Forward([]byte{1, 2, 3, 4, 5}, output)
+
+	if bytes.Equal(output, []byte{1, 1, 3, 2, 4, 5}) == false {
+		b.Errorf("Incorrect transposition: %v", output)
+	}
+
+	ctx = map[string]interface{}{"shuffleWidth": uint(3)}
+
+	if _, err := function.NewShuffleCodecWithCtx(&ctx); err == nil {
+		b.Errorf("No error for an invalid width")
+	}
+
+	// Random bytes and text are not transposed
+	random := make([]byte, 8192)
+	rnd.Read(random)
+	text := []byte(strings.Repeat("The shuffle codec only transposes numbers. ", 200))
+	f, _ = function.NewShuffleCodec()
+
+	for _, block := range [][]byte{random, text} {
+		if _, _, err := f.Forward(block, make([]byte, f.MaxEncodedLen(len(block)))); err == nil {
+			b.Errorf("No error for non numeric input")
+		}
+	}
+
+	if _, _, err := f.Inverse([]byte{4, 1, 2}, make([]byte, 2)); err == nil {
+		b.Errorf("No error for an invalid mode")
+	}
+
+	// Delta of the values, then transposition of the residuals
+	fmt.Println("Shuffle: DELTA+SHUFFLE")
+	ctx = map[string]interface{}{"deltaStride": uint(8), "deltaOrder": uint(1), "shuffleWidth": uint(8)}
+	seq, err := function.NewChainWithCtx(&ctx, "DELTA+SHUFFLE")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// The sequence uses its input as a work buffer
+	input = doubles.Bytes()
+	output = make([]byte, seq.MaxEncodedLen(len(input)))
+	_, dstIdx, err := seq.Forward(append([]byte{}, input...), output)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Both stages applied (skip flags of the first 2 stages cleared)
+	flags := seq.SkipFlags()
+
+	if flags&0xC0 != 0 {
+		b.Errorf("Incorrect skip flags: %#x", flags)
+	}
+
+	seq, _ = function.NewChainWithCtx(&ctx, "DELTA+SHUFFLE")
+	seq.SetSkipFlags(flags)
+	reverse := make([]byte, seq.MaxEncodedLen(len(input)))
+	_, n, err := seq.Inverse(output[0:dstIdx], reverse)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if int(n) != len(input) || bytes.Equal(input, reverse[0:n]) == false {
+		b.Fatalf("DELTA+SHUFFLE: incorrect output")
+	}
+}
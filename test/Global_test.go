@@ -0,0 +1,105 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestSquashStretch(b *testing.T) {
+	fmt.Println("Squash & Stretch test")
+
+	// The compact tables must return the same values as the full ones
+	for d := -2100; d <= 2100; d++ {
+		expected := 0
+
+		if d >= 2048 {
+			expected = 4095
+		} else if d > -2048 {
+			expected = kanzi.SQUASH[d+2047]
+		}
+
+		if kanzi.Squash(d) != expected {
+			b.Fatalf("Incorrect squash for %d: %d instead of %d", d, kanzi.Squash(d), expected)
+		}
+	}
+
+	for p := 0; p < 4096; p++ {
+		if kanzi.Stretch(p) != kanzi.STRETCH[p] {
+			b.Fatalf("Incorrect stretch for %d: %d instead of %d", p, kanzi.Stretch(p), kanzi.STRETCH[p])
+		}
+	}
+
+	// Values of the original (32 bit) tables
+	expected := map[int]int{0: -2047, 1: -1846, 100: -877, 1000: -269, 2048: 0,
+		3000: 241, 4000: 886, 4094: 1741, 4095: 2047}
+
+	for p, d := range expected {
+		if kanzi.Stretch(p) != d {
+			b.Errorf("Incorrect stretch for %d: %d instead of %d", p, kanzi.Stretch(p), d)
+		}
+	}
+
+	buf := make([]byte, 0, 4*len(kanzi.STRETCH))
+
+	for _, d := range kanzi.STRETCH {
+		buf = append(buf, byte(d), byte(d>>8), byte(d>>16), byte(d>>24))
+	}
+
+	if crc := crc32.ChecksumIEEE(buf); crc != 0xCE41B9A9 {
+		b.Errorf("Incorrect stretch table: checksum %#x", crc)
+	}
+
+	// Stretch is increasing and inverts Squash
+	for p := 1; p < 4096; p++ {
+		if kanzi.Stretch(p) < kanzi.Stretch(p-1) {
+			b.Fatalf("Stretch is not monotonic at %d", p)
+		}
+	}
+
+	for d := -2047; d <= 2047; d++ {
+		if p := kanzi.Squash(kanzi.Stretch(kanzi.Squash(d))); p != kanzi.Squash(d) {
+			b.Fatalf("Incorrect squash(stretch(squash(%d))): %d instead of %d", d, p, kanzi.Squash(d))
+		}
+	}
+}
+
+func TestLog2(b *testing.T) {
+	fmt.Println("Log2 test")
+
+	for i := uint(0); i < 32; i++ {
+		for _, x := range []uint32{1 << i, (1 << i) + 1, (2 << i) - 1} {
+			if x == 0 {
+				continue
+			}
+
+			expected := uint32(math.Floor(math.Log2(float64(x))))
+
+			if res := kanzi.Log2NoCheck(x); res != expected {
+				b.Fatalf("Incorrect log2 for %d: %d instead of %d", x, res, expected)
+			}
+		}
+	}
+
+	if _, err := kanzi.Log2(0); err == nil {
+		b.Errorf("No error for log2(0)")
+	}
+}
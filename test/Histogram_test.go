@@ -0,0 +1,64 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestHistogram(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	buffer := make([]byte, 300010)
+	freqs := make([]int, 257)
+
+	for _, size := range []int{0, 1, 7, 100, 4095, 4096, 4103, 65536, 299999} {
+		fmt.Printf("Histogram of %d bytes\n", size)
+
+		// Random bytes, then runs (same counter incremented repeatedly)
+		for test := 0; test < 2; test++ {
+			for i := range buffer {
+				if test == 0 {
+					buffer[i] = byte(rnd.Intn(256))
+				} else {
+					buffer[i] = byte(i >> 12)
+				}
+			}
+
+			// Unaligned start
+			block := buffer[test+3 : test+3+size]
+			expected := make([]int, 257)
+
+			for _, c := range block {
+				expected[c]++
+			}
+
+			expected[256] = len(block)
+			kanzi.ComputeHistogram(block, freqs, true, true)
+
+			for i := range expected {
+				if freqs[i] != expected[i] {
+					b.Fatalf("Size %d: incorrect frequency for %d: %d instead of %d",
+						size, i, freqs[i], expected[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/npy"
+)
+
+// Build a version 1.0 .npy file (header padded to 64 bytes as numpy does).
+// The dtype is a Python literal.
+func makeNpy(descr string, shape string, data []byte) []byte {
+	dict := fmt.Sprintf("{'descr': %s, 'fortran_order': False, 'shape': %s, }", descr, shape)
+
+	for (10+len(dict)+1)%64 != 0 {
+		dict += " "
+	}
+
+	dict += "\n"
+	res := []byte(npy.NPY_MAGIC + "\x01\x00")
+	res = append(res, byte(len(dict)), byte(len(dict)>>8))
+	res = append(res, dict...)
+	return append(res, data...)
+}
+
+func TestNpy(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	n := 100000
+	f8 := make([]byte, 8*n)
+	i4be := make([]byte, 4*n)
+	i4 := make([]byte, 4*n)
+	u2 := make([]byte, 2*n+1) // trailing partial element (truncated file)
+	val := uint32(0)
+
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint64(f8[8*i:], math.Float64bits(math.Sin(float64(i)/100)+rnd.NormFloat64()*1e-3))
+		val += uint32(rnd.Intn(10))
+		binary.BigEndian.PutUint32(i4be[4*i:], val)
+		binary.LittleEndian.PutUint32(i4[4*i:], val)
+		binary.LittleEndian.PutUint16(u2[2*i:], uint16(rnd.Intn(300)))
+	}
+
+	files := map[string][]byte{
+		"f8":   makeNpy("'<f8'", fmt.Sprintf("(%d,)", n), f8),
+		"i4be": makeNpy("'>i4'", fmt.Sprintf("(%d, 10)", n/10), i4be),
+		"i4":   makeNpy("'<i4'", fmt.Sprintf("(%d,)", n), i4),
+		"u2":   makeNpy("'<u2'", fmt.Sprintf("(%d,)", n), u2),
+		"str":  makeNpy("'|S5'", "(3,)", []byte("helloworldkanzi")),
+		"rec":  makeNpy("[('x', '<f4'), ('y', '<i2')]", "(2,)", make([]byte, 12)),
+		"zero": makeNpy("'<f4'", "()", []byte{0, 0, 128, 63}),
+	}
+
+	for name, file := range files {
+		h, err := npy.ParseHeader(file)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		filter, p := npy.Choose(h, file[h.DataOffset:])
+		compressed, err := npy.Encode(nil, file)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		fmt.Printf("%-5s dtype=%-28q shape=%v filter=%d pipeline=%-24v %7d => %7d bytes\n",
+			name, h.Descr, h.Shape, filter, p, len(file), len(compressed))
+
+		decompressed, err := npy.Decode(nil, compressed)
+
+		if err != nil {
+			b.Fatalf("%v: %v", name, err)
+		}
+
+		if bytes.Equal(decompressed, file) == false {
+			b.Fatalf("%v: incorrect data after round trip", name)
+		}
+
+		// Generic byte oriented compression of the same data for comparison
+		if name == "f8" || name == "i4be" || name == "i4" {
+			generic, _ := npy.EncodeWith(nil, file, npy.FILTER_NONE, "BWT+RANK+ZRLT&ANS0")
+
+			if len(generic) <= len(compressed) {
+				b.Fatalf("%v: no gain over generic compression (%d vs %d)", name, len(compressed), len(generic))
+			}
+		}
+	}
+
+	if h, _ := npy.ParseHeader(files["i4be"]); h.Kind != 'i' || h.ElemSize != 4 || h.BigEndian == false || h.Count() != n {
+		b.Fatalf("Incorrect header: %+v", h)
+	}
+
+	// Delta for increasing little endian integers only
+	for name, expected := range map[string]int{"i4": npy.FILTER_DELTA_SHUFFLE, "i4be": npy.FILTER_SHUFFLE, "f8": npy.FILTER_SHUFFLE} {
+		h, _ := npy.ParseHeader(files[name])
+
+		if filter, _ := npy.Choose(h, files[name][h.DataOffset:]); filter != expected {
+			b.Errorf("%v: incorrect filter: %d, expected %d", name, filter, expected)
+		}
+	}
+
+	if _, err := npy.EncodeWith(nil, files["i4be"], npy.FILTER_DELTA_SHUFFLE, "BWT&CM"); err == nil {
+		b.Errorf("No error for delta on big endian elements")
+	}
+
+	if _, err := npy.EncodeWith(nil, files["str"], npy.FILTER_SHUFFLE, "BWT&CM"); err == nil {
+		b.Errorf("No error for transposition of 5 byte elements")
+	}
+
+	if _, err := npy.ParseHeader([]byte("not a npy file")); err == nil {
+		b.Fatalf("Invalid npy file not detected")
+	}
+
+	compressed, _ := npy.Encode(nil, files["f8"])
+	compressed[len(compressed)/2] ^= 0x10
+
+	if res, err := npy.Decode(nil, compressed); err == nil && bytes.Equal(res, files["f8"]) == true {
+		b.Fatalf("Corrupted data not detected")
+	}
+
+	// .npz round trip
+	var npz bytes.Buffer
+	zw := zip.NewWriter(&npz)
+
+	for _, name := range []string{"f8", "u2"} {
+		fw, _ := zw.Create(name + ".npy")
+		fw.Write(files[name])
+	}
+
+	zw.Close()
+	headers, err := npy.NpzHeaders(bytes.NewReader(npz.Bytes()), int64(npz.Len()))
+
+	if err != nil || len(headers) != 2 || headers["u2.npy"].Descr != "<u2" {
+		b.Fatalf("Incorrect npz headers: %v", err)
+	}
+
+	var knpz, restored bytes.Buffer
+
+	if err = npy.EncodeNpz(&knpz, bytes.NewReader(npz.Bytes()), int64(npz.Len())); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if err = npy.DecodeNpz(&restored, bytes.NewReader(knpz.Bytes()), int64(knpz.Len())); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	fmt.Printf("npz: %d => %d bytes\n", npz.Len(), knpz.Len())
+	zr, _ := zip.NewReader(bytes.NewReader(restored.Bytes()), int64(restored.Len()))
+
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		var data bytes.Buffer
+		data.ReadFrom(rc)
+		rc.Close()
+
+		if bytes.Equal(data.Bytes(), files[f.Name[0:len(f.Name)-4]]) == false {
+			b.Fatalf("Incorrect npz member %v", f.Name)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/page"
+)
+
+func TestPageCodec(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	numeric := make([]byte, 8*8192)
+	val := uint64(1 << 40)
+
+	for i := 0; i < len(numeric); i += 8 {
+		val += uint64(rnd.Intn(64))
+		binary.LittleEndian.PutUint64(numeric[i:], val)
+	}
+
+	strs := []byte(strings.Repeat("alpha,beta,gamma,delta,epsilon,", 1000))
+	pages := [][]byte{{}, []byte("x"), numeric, strs}
+
+	for _, hint := range []page.ColumnHint{page.COLUMN_ANY, page.COLUMN_NUMERIC, page.COLUMN_STRING} {
+		var codec page.PageCodec
+		c, err := page.NewCodec(hint)
+
+		if err != nil {
+			b.Fatalf("Hint %d: %v", hint, err)
+		}
+
+		codec = c
+		fmt.Printf("Column hint %d: %v\n", hint, c)
+
+		for _, p := range pages {
+			// Append to a non empty destination
+			compressed, err := codec.Compress([]byte{1, 2, 3}, p)
+
+			if err != nil {
+				b.Fatalf("Hint %d: %v", hint, err)
+			}
+
+			if len(compressed)-3 > codec.CompressBound(len(p)) {
+				b.Fatalf("Hint %d: compressed size %d above bound %d", hint, len(compressed)-3, codec.CompressBound(len(p)))
+			}
+
+			decompressed, err := codec.Decompress([]byte{4}, compressed[3:])
+
+			if err != nil {
+				b.Fatalf("Hint %d: %v", hint, err)
+			}
+
+			if decompressed[0] != 4 || bytes.Equal(decompressed[1:], p) == false {
+				b.Fatalf("Hint %d: incorrect page after round trip", hint)
+			}
+
+			fmt.Printf("  %d => %d bytes\n", len(p), len(compressed)-3)
+		}
+
+		if _, err = codec.Decompress(nil, []byte{0, 0, 1}); err == nil {
+			b.Fatalf("Hint %d: truncated page not detected", hint)
+		}
+	}
+
+	if _, err := page.NewCodec(page.ColumnHint(9)); err == nil {
+		b.Fatalf("Invalid column hint not detected")
+	}
+
+	if _, err := page.NewCodecWithPipeline("BWT&FOO"); err == nil {
+		b.Fatalf("Invalid pipeline not detected")
+	}
+
+	// Concurrent use of one codec
+	codec, _ := page.NewCodec(page.COLUMN_STRING)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(id int) {
+			defer wg.Done()
+			data := []byte(strings.Repeat(fmt.Sprintf("worker %d page,", id), 500+id))
+
+			for n := 0; n < 20; n++ {
+				compressed, err := codec.Compress(nil, data)
+
+				if err == nil {
+					var decompressed []byte
+
+					if decompressed, err = codec.Decompress(nil, compressed); err == nil && bytes.Equal(decompressed, data) == false {
+						err = fmt.Errorf("Worker %d: incorrect page after round trip", id)
+					}
+				}
+
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		b.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+func TestPipelineParse(b *testing.T) {
+	tests := map[string]string{
+		"bwt+rank+zrlt&ans0": "BWT+RANK+ZRLT&ANS0",
+		"+NONE+LZ+":          "LZ&NONE",
+		"&CM":                "NONE&CM",
+		"TEXT+BWT":           "TEXT+BWT&NONE",
+	}
+
+	for in, expected := range tests {
+		desc, err := pipeline.Parse(in)
+
+		if err != nil {
+			b.Error(err)
+			continue
+		}
+
+		if desc.String() != expected {
+			b.Errorf("Parse(%q): expected %q, got %q", in, expected, desc.String())
+		}
+	}
+
+	for _, in := range []string{"", "FOO&CM", "BWT&BAR", "BWT&CM&CM", "LZ+LZ+LZ+LZ+LZ+LZ+LZ+LZ+LZ"} {
+		if _, err := pipeline.Parse(in); err == nil {
+			b.Errorf("Parse(%q): expected an error", in)
+		}
+	}
+}
+
+func TestPipeline(b *testing.T) {
+	names := []string{"TEXT+BWT+RANK+ZRLT&ANS0", "LZ&HUFFMAN", "NONE&NONE", "ROLZ&NONE",
+		"BWTS+SRT&RANGE", "RLT+TEXT&FPAQ", "X86+RLT+TEXT&TPAQ", "LZP+TEXT+BWT&CM"}
+
+	for _, name := range names {
+		if err := testPipelineCorrectness(name); err != nil {
+			b.Errorf("%v: %v", name, err)
+		}
+	}
+}
+
+func testPipelineCorrectness(name string) error {
+	fmt.Printf("Correctness test for %v\n", name)
+	p, err := pipeline.NewPipeline(name)
+
+	if err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 10; ii++ {
+		size := []int{1, 15, 16, 100, 1000, 65536}[ii%6]
+		input := make([]byte, size)
+
+		for i := range input {
+			input[i] = byte(65 + rnd.Intn(4*ii+1))
+		}
+
+		saved := make([]byte, size)
+		copy(saved, input)
+		output := make([]byte, p.MaxEncodedLen(size))
+		_, n, err := p.Forward(input, output)
+
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(input, saved) == false {
+			return fmt.Errorf("Forward modified its input")
+		}
+
+		reverse := make([]byte, size)
+		_, m, err := p.Inverse(output[0:n], reverse)
+
+		if err != nil {
+			return err
+		}
+
+		if int(m) != size || bytes.Equal(input, reverse) == false {
+			return fmt.Errorf("Input and inverse are different (size %d)", size)
+		}
+	}
+
+	return nil
+}
+
+func TestPipelineTune(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	words := []string{"the ", "quick ", "brown ", "fox ", "jumps ", "over ", "lazy ", "dog ", "\n"}
+	var text bytes.Buffer
+
+	for text.Len() < 400000 {
+		text.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	input := text.Bytes()
+	cfg := pipeline.TuneConfig{Candidates: []string{"NONE&NONE", "LZ&HUFFMAN", "TEXT+BWT+RANK+ZRLT&ANS0", "FOO&BAR"}}
+	desc, results, err := pipeline.Tune(input, cfg)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	fmt.Printf("Tuned pipeline: %v\n", desc)
+
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("%v: %v\n", r.Pipeline, r.Err)
+			continue
+		}
+
+		fmt.Printf("%v: %d => %d in %v\n", r.Pipeline, r.InputSize, r.OutputSize, r.Time)
+
+		if r.Cost < results[2].Cost {
+			b.Errorf("%v has a lower cost than the tuned pipeline", r.Pipeline)
+		}
+	}
+
+	if results[3].Err == nil {
+		b.Errorf("No error for an invalid candidate")
+	}
+
+	// The best ratio wins when the speed is ignored
+	if desc.String() != "TEXT+BWT+RANK+ZRLT&ANS0" {
+		b.Errorf("Unexpected tuned pipeline: %v", desc)
+	}
+
+	// A large speed weight favours the fastest candidate
+	cfg.SpeedWeight = 100
+	desc, _, err = pipeline.Tune(input, cfg)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if desc.String() != "NONE&NONE" {
+		b.Errorf("Unexpected tuned pipeline with speed weight: %v", desc)
+	}
+
+	if _, _, err = pipeline.Tune(input, pipeline.TuneConfig{Candidates: []string{"FOO"}}); err == nil {
+		b.Errorf("No error when all candidates fail")
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/seekable"
+)
+
+func createSeekable(b *testing.T, data []byte, blockSize uint) []byte {
+	var buf bytes.Buffer
+	w, err := seekable.NewWriter(&buf, "TEXT+BWT+RANK+ZRLT&ANS0", blockSize)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Odd write sizes to cross block boundaries
+	for i := 0; i < len(data); i += 777 {
+		end := i + 777
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if _, err = w.Write(data[i:end]); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestSeekable(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := make([]byte, 300000)
+
+	for i := range data {
+		data[i] = byte(97 + rnd.Intn(1+(i>>12)&15))
+	}
+
+	archive := createSeekable(b, data, 16384)
+	fmt.Printf("Seekable archive: %d => %d bytes\n", len(data), len(archive))
+	r, err := seekable.NewReaderWithCache(bytes.NewReader(archive), int64(len(archive)), 4)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if r.Size() != int64(len(data)) || r.BlockCount() != (len(data)+16383)/16384 {
+		b.Fatalf("Incorrect size %d or block count %d", r.Size(), r.BlockCount())
+	}
+
+	for n := 0; n < 200; n++ {
+		off := rnd.Int63n(int64(len(data)))
+		length := rnd.Int63n(50000)
+
+		if off+length > int64(len(data)) {
+			length = int64(len(data)) - off
+		}
+
+		res, err := r.DecodeRange(nil, off, length)
+
+		if err != nil {
+			b.Fatalf("Range [%d..%d): %v", off, off+length, err)
+		}
+
+		if bytes.Equal(res, data[off:off+length]) == false {
+			b.Fatalf("Range [%d..%d): incorrect data", off, off+length)
+		}
+	}
+
+	// ReadAt at the end of the data
+	buf := make([]byte, 100)
+
+	if n, err := r.ReadAt(buf, int64(len(data)-40)); n != 40 || err != io.EOF || bytes.Equal(buf[0:40], data[len(data)-40:]) == false {
+		b.Fatalf("Incorrect ReadAt at end of data: %d, %v", n, err)
+	}
+
+	if _, err = r.DecodeRange(nil, int64(len(data)-10), 20); err == nil {
+		b.Fatalf("Invalid range not detected")
+	}
+
+	// The whole data through io.SectionReader
+	all, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+
+	if err != nil || bytes.Equal(all, data) == false {
+		b.Fatalf("Incorrect data from section reader: %v", err)
+	}
+
+	// Empty archive
+	empty := createSeekable(b, nil, 1024)
+
+	if r, err := seekable.NewReader(bytes.NewReader(empty), int64(len(empty))); err != nil || r.Size() != 0 {
+		b.Fatalf("Incorrect empty archive: %v", err)
+	}
+
+	// Corrupted block and index
+	corrupted := append([]byte(nil), archive...)
+	corrupted[100] ^= 0x55
+	r, _ = seekable.NewReader(bytes.NewReader(corrupted), int64(len(corrupted)))
+
+	if _, err = r.DecodeRange(nil, 0, 100); err == nil {
+		b.Fatalf("Corrupted block not detected")
+	}
+
+	corrupted = append([]byte(nil), archive...)
+	corrupted[len(corrupted)-30] ^= 0x55
+
+	if _, err = seekable.NewReader(bytes.NewReader(corrupted), int64(len(corrupted))); err == nil {
+		b.Fatalf("Corrupted index not detected")
+	}
+
+	if _, err = seekable.NewReader(bytes.NewReader(archive[0:len(archive)-1]), int64(len(archive)-1)); err == nil {
+		b.Fatalf("Truncated archive not detected")
+	}
+}
+
+func TestSeekableHTTP(b *testing.T) {
+	data := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 20000)
+	archive := createSeekable(b, data, 65536)
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, req, "data.knzs", time.Time{}, bytes.NewReader(archive))
+	}))
+
+	defer server.Close()
+	r, err := seekable.OpenURL(server.URL, 2)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	// Size request, then header and tail (index) requests
+	fmt.Printf("Index read with %d requests\n", atomic.LoadInt32(&requests))
+	before := atomic.LoadInt32(&requests)
+	res, err := r.DecodeRange(nil, 100000, 1000)
+
+	if err != nil || bytes.Equal(res, data[100000:101000]) == false {
+		b.Fatalf("Incorrect remote range: %v", err)
+	}
+
+	// Same block: served by the cache
+	if res, err = r.DecodeRange(res[:0], 100500, 1000); err != nil || bytes.Equal(res, data[100500:101500]) == false {
+		b.Fatalf("Incorrect remote range: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&requests) - before; n != 1 {
+		b.Fatalf("Expected 1 block request, got %d", n)
+	}
+
+	// Server without Range support
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(archive)
+	}))
+
+	defer plain.Close()
+
+	if _, err = seekable.OpenURL(plain.URL, 2); err == nil {
+		b.Fatalf("Missing Range support not detected")
+	}
+
+	fmt.Printf("Error: %v\n", err)
+}
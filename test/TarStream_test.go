@@ -0,0 +1,152 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/tarstream"
+)
+
+type countingReaderAt struct {
+	r     io.ReaderAt
+	bytes int64
+}
+
+func (this *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := this.r.ReadAt(p, off)
+	atomic.AddInt64(&this.bytes, int64(n))
+	return n, err
+}
+
+func writeTar(b *testing.T, tw *tarstream.Writer, files map[string][]byte, names []string) {
+	for _, name := range names {
+		h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: time.Unix(1600000000, 0), Typeflag: tar.TypeReg}
+
+		if err := tw.WriteHeader(h); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if _, err := tw.Write(files[name]); err != nil {
+			b.Fatalf("%v", err)
+		}
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		b.Fatalf("%v", err)
+	}
+}
+
+func TestTarStream(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	names := []string{"README", "data/big.bin", "data/small.txt"}
+	files := map[string][]byte{
+		"README":         []byte(strings.Repeat("kanzi tar integration\n", 100)),
+		"data/big.bin":   make([]byte, 2000000),
+		"data/small.txt": []byte("small"),
+	}
+
+	for i := range files["data/big.bin"] {
+		files["data/big.bin"][i] = byte(rnd.Intn(256))
+	}
+
+	// Streaming .tar.knz
+	var buf bytes.Buffer
+	tw, err := tarstream.NewWriter(&buf, 2)
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	writeTar(b, tw, files, names)
+	tr, err := tarstream.NewReader(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for _, name := range names {
+		h, err := tr.Next()
+
+		if err != nil || h.Name != name {
+			b.Fatalf("Incorrect entry: %v", err)
+		}
+
+		if data, err := ioutil.ReadAll(tr); err != nil || bytes.Equal(data, files[name]) == false {
+			b.Fatalf("Incorrect content for '%v': %v", name, err)
+		}
+	}
+
+	tr.Close()
+
+	// Seekable tarball and index
+	buf.Reset()
+
+	if tw, err = tarstream.NewSeekableWriter(&buf, "TEXT+BWT+RANK+ZRLT&ANS0", 65536); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	writeTar(b, tw, files, names)
+	counter := &countingReaderAt{r: bytes.NewReader(buf.Bytes())}
+	index, err := tarstream.NewIndex(counter, int64(buf.Len()))
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	fmt.Printf("Seekable tarball: %d bytes, %d bytes read to build the index\n", buf.Len(), counter.bytes)
+
+	if counter.bytes > int64(buf.Len())/2 {
+		b.Fatalf("The index read too much data: %d bytes", counter.bytes)
+	}
+
+	if len(index.Headers()) != len(names)+1 {
+		b.Fatalf("Incorrect number of entries: %d", len(index.Headers()))
+	}
+
+	for _, name := range names {
+		r, err := index.Open(name)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if data, err := ioutil.ReadAll(r); err != nil || bytes.Equal(data, files[name]) == false {
+			b.Fatalf("Incorrect content for '%v': %v", name, err)
+		}
+	}
+
+	if _, err = index.Open("dir/"); err == nil {
+		b.Fatalf("Open of a directory not detected")
+	}
+
+	if _, err = index.Open("missing"); err == nil {
+		b.Fatalf("Missing entry not detected")
+	}
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -35,6 +36,14 @@ func getByteTransform(name string) (kanzi.ByteTransform, error) {
 		res, err := transform.NewSBRT(transform.SBRT_MODE_MTF)
 		return res, err
 
+	case "MTF1":
+		res, err := transform.NewSBRT(transform.SBRT_MODE_MTF1)
+		return res, err
+
+	case "MTF2":
+		res, err := transform.NewSBRT(transform.SBRT_MODE_MTF2)
+		return res, err
+
 	case "BWTS":
 		res, err := transform.NewBWTS()
 		return res, err
@@ -56,6 +65,91 @@ func TestMTFT(b *testing.T) {
 	}
 }
 
+func TestMTF1(b *testing.T) {
+	if err := testTransformCorrectness("MTF1"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func TestMTF2(b *testing.T) {
+	if err := testTransformCorrectness("MTF2"); err != nil {
+		b.Errorf(err.Error())
+	}
+}
+
+func TestMTF1Ranks(b *testing.T) {
+	fmt.Println("MTF1 and MTF2 ranks test")
+	input := []byte{1, 1, 0, 1, 1, 2, 2}
+
+	// With MTF2, 1 stays at the front after the run of 0 at rank 1
+	expected := map[int][]byte{
+		transform.SBRT_MODE_MTF1: {1, 0, 1, 1, 0, 2, 1},
+		transform.SBRT_MODE_MTF2: {1, 0, 1, 0, 0, 2, 1},
+	}
+
+	for mode, ranks := range expected {
+		f, _ := transform.NewSBRT(mode)
+		output := make([]byte, len(input))
+
+		if _, _, err := f.Forward(input, output); err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		if bytes.Equal(output, ranks) == false {
+			b.Errorf("Incorrect ranks for mode %d: %v instead of %v", mode, output, ranks)
+		}
+	}
+}
+
+func TestMTFTRanks(b *testing.T) {
+	fmt.Println("MTFT ranks test")
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 100000)
+
+	for i := range input {
+		// Mostly small ranks with a few far symbols
+		if rnd.Intn(10) == 0 {
+			input[i] = byte(rnd.Intn(256))
+		} else {
+			input[i] = byte(rnd.Intn(4))
+		}
+	}
+
+	// Reference move to front with a plain list
+	list := make([]byte, 256)
+
+	for i := range list {
+		list[i] = byte(i)
+	}
+
+	expected := make([]byte, len(input))
+
+	for i, c := range input {
+		r := 0
+
+		for list[r] != c {
+			r++
+		}
+
+		expected[i] = byte(r)
+		copy(list[1:r+1], list[0:r])
+		list[0] = c
+	}
+
+	f, _ := transform.NewSBRT(transform.SBRT_MODE_MTF)
+	output := make([]byte, len(input))
+
+	if _, _, err := f.Forward(input, output); err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	for i := range expected {
+		if output[i] != expected[i] {
+			b.Fatalf("Incorrect rank at index %d: %d instead of %d", i, output[i], expected[i])
+		}
+	}
+}
+
 func testTransformCorrectness(name string) error {
 	fmt.Printf("Correctness test for %v\n", name)
 	rng := 256
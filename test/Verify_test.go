@@ -0,0 +1,98 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flanglet/kanzi-go/verify"
+)
+
+func TestVerify(b *testing.T) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	input := make([]byte, 20000)
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(1+i&63))
+	}
+
+	fmt.Printf("Round trip of %d combinations\n", len(verify.Combinations()))
+
+	for _, c := range verify.Combinations() {
+		// TPAQ codecs are slow, only test them once
+		if c.Transform != "NONE" && (c.Entropy == "TPAQ" || c.Entropy == "TPAQX") {
+			continue
+		}
+
+		// Small blocks expand with some combinations (EG. BWT+SRT+ZRLT&ANS1)
+		if r := verify.RoundTrip(input, c, 4096, 1); r.Err != nil {
+			b.Errorf("%v: %v", r.Name, r.Err)
+		}
+	}
+
+	archive, err := verify.Compress(input, verify.Combination{Transform: "TEXT+BWT", Entropy: "ANS1"}, 16384, 1, true)
+
+	if err != nil {
+		b.Errorf("Compression failed: %v", err)
+		return
+	}
+
+	r := verify.VerifyArchive("archive", archive, input, 2)
+
+	if r.Err != nil || r.Checksum == false || r.BlockSize != 16384 || r.Combination.Entropy != "ANS1" {
+		b.Errorf("Incorrect verification result: %+v", r)
+	}
+
+	if r = verify.VerifyArchive("archive", archive, input[1:], 1); r.Err == nil {
+		b.Errorf("Verification against incorrect data did not fail")
+	}
+}
+
+// The reference archives of testdata were written by other releases or
+// implementations
+func TestVerifyReferences(b *testing.T) {
+	original, err := ioutil.ReadFile("testdata/mixed.bin")
+
+	if err != nil {
+		b.Fatalf("%v", err)
+	}
+
+	names, _ := filepath.Glob("testdata/mixed_*.knz")
+
+	if len(names) == 0 {
+		b.Fatalf("No reference archive")
+	}
+
+	for _, name := range names {
+		archive, err := ioutil.ReadFile(name)
+
+		if err != nil {
+			b.Fatalf("%v", err)
+		}
+
+		r := verify.VerifyArchive(filepath.Base(name), archive, original, 2)
+		fmt.Printf("%v [%v, block %d, checksum %v]\n", r.Name, r.Combination, r.BlockSize, r.Checksum)
+
+		if r.Err != nil {
+			b.Errorf("%v: %v", r.Name, r.Err)
+		}
+	}
+}
@@ -16,18 +16,30 @@ limitations under the License.
 package transform
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"sync"
 
 	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util"
 )
 
 const (
 	_BWT_MAX_BLOCK_SIZE = 1024 * 1024 * 1024 // 1 GB
-	_BWT_MAX_CHUNKS     = 8
+	_BWT_MAX_CHUNKS     = 32
+	_BWT_CHUNK_SIZE     = 1 << 20 // block size per primary index
+	_BWT_V9_MAX_CHUNKS  = 8       // stream format versions up to 9
+	_BWT_V9_CHUNK_SIZE  = 1 << 22
 	_BWT_NB_FASTBITS    = 17
 	_BWT_MASK_FASTBITS  = 1 << _BWT_NB_FASTBITS
+	_BWT_PARTITION_MIN  = 64 * 1024 * 1024 // block size for partitioned inverse construction
+	_BWT_SEGMENT_SIZE   = 1 << 22          // indexes partitioned per pass (16 MB)
+	_BWT_WINDOW_SIZE    = 1 << 16          // entries of 'data' written by a group (256 KB)
+	_BWT_SAIS_MIN_BLOCK = 1 << 20          // min block size checked for the SA-IS fallback
+	_BWT_SAIS_MAX_BLOCK = 1 << 24          // max block size of the SA-IS fallback (16 bytes per byte)
+	_BWT_REPEAT_SAMPLES = 4096             // positions sampled by the repetitiveness check
+	_BWT_REPEAT_LENGTH  = 256              // min length of the repeats of periodic data
 )
 
 // The Burrows-Wheeler Transform is a reversible transform based on
@@ -64,15 +76,25 @@ const (
 //
 // This implementation extends the canonical algorithm to use up to MAX_CHUNKS primary
 // indexes (based on input block size). Each primary index corresponds to a data chunk.
-// Chunks may be inverted concurrently.
+// Chunks may be inverted concurrently. Since stream format version 10, there is
+// a chunk per MB of block (up to 32), against a chunk per 4 MB (up to 8) before.
+//
+// The suffix array is built by DivSufSort (O(n.log(n)), very fast on usual
+// data, runs and periodic data). On highly repetitive data without period
+// (EG. Fibonacci or Thue-Morse words, made of few distinct substrings that
+// repeat at varying distances), the suffix comparisons get long and SA-IS
+// (O(n)) is used instead for blocks of up to 16 MB.
 
 // BWT Burrows Wheeler Transform
 type BWT struct {
 	buffer1        []uint32
 	buffer2        []int32
-	primaryIndexes [8]uint
+	primaryIndexes [_BWT_MAX_CHUNKS]uint
 	saAlgo         *DivSufSort
 	jobs           uint
+	v9Chunks       bool            // chunks of stream format versions up to 9
+	partitionMin   int             // min block size of the partitioned inverse construction
+	alloc          *util.Allocator // nil unless aligned allocation is requested
 }
 
 // NewBWT creates a new BWT instance with 1 job
@@ -80,18 +102,40 @@ func NewBWT() (*BWT, error) {
 	this := new(BWT)
 	this.buffer1 = make([]uint32, 0)
 	this.buffer2 = make([]int32, 0)
-	this.primaryIndexes = [8]uint{}
+	this.primaryIndexes = [_BWT_MAX_CHUNKS]uint{}
 	this.jobs = 1
+	this.partitionMin = _BWT_PARTITION_MIN
+	return this, nil
+}
+
+// NewBWTWithJobs creates a new BWT instance using up to 'jobs' concurrent
+// goroutines. Both the suffix sorting of the forward transform (for blocks
+// of 1 MB or more) and the inverse transform are concurrent. The output is
+// the same for any number of jobs.
+func NewBWTWithJobs(jobs int) (*BWT, error) {
+	if jobs < 1 {
+		return nil, fmt.Errorf("BWT: Invalid number of jobs: %v (must be at least 1)", jobs)
+	}
+
+	this, err := NewBWT()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.jobs = uint(jobs)
 	return this, nil
 }
 
 // NewBWTWithCtx creates a new BWT instance. The number of jobs is extracted
-// from the provided map or arguments.
+// from the provided map or arguments. The "bwtPartitionMin" entry (uint)
+// sets the min block size (64 MB by default) from which the inverse
+// transform scatters the indexes by symbol group (see scatterPartitioned).
 func NewBWTWithCtx(ctx *map[string]interface{}) (*BWT, error) {
 	this := new(BWT)
 	this.buffer1 = make([]uint32, 0)
 	this.buffer2 = make([]int32, 0)
-	this.primaryIndexes = [8]uint{}
+	this.primaryIndexes = [_BWT_MAX_CHUNKS]uint{}
 
 	if _, containsKey := (*ctx)["jobs"]; containsKey {
 		this.jobs = (*ctx)["jobs"].(uint)
@@ -99,9 +143,39 @@ func NewBWTWithCtx(ctx *map[string]interface{}) (*BWT, error) {
 		this.jobs = 1
 	}
 
+	if val, containsKey := (*ctx)["bsVersion"]; containsKey {
+		this.v9Chunks = val.(uint) < 10
+	}
+
+	this.partitionMin = _BWT_PARTITION_MIN
+
+	if val, containsKey := (*ctx)["bwtPartitionMin"]; containsKey {
+		this.partitionMin = int(val.(uint))
+	}
+
+	this.alloc = util.NewAllocatorWithCtx(ctx)
 	return this, nil
 }
 
+// Release hands the work buffers back to the shared pool in manual memory
+// mode (see util.Allocator), or drops them for the GC otherwise.
+func (this *BWT) Release() {
+	this.alloc.ReleaseUint32(this.buffer1)
+	this.alloc.ReleaseInt32(this.buffer2)
+	this.buffer1 = make([]uint32, 0)
+	this.buffer2 = make([]int32, 0)
+}
+
+// Chunks returns the number of chunks (and primary indexes) of a block of
+// the given size
+func (this *BWT) Chunks(size int) int {
+	if this.v9Chunks == true {
+		return getBWTChunksV9(size)
+	}
+
+	return GetBWTChunks(size)
+}
+
 // PrimaryIndex returns the primary index for the n-th chunk
 func (this *BWT) PrimaryIndex(n int) uint {
 	return this.primaryIndexes[n]
@@ -154,19 +228,25 @@ func (this *BWT) Forward(src, dst []byte) (uint, uint, error) {
 	if this.saAlgo == nil {
 		var err error
 
-		if this.saAlgo, err = NewDivSufSort(); err != nil {
+		if this.saAlgo, err = NewDivSufSortWithJobs(this.jobs); err != nil {
 			return 0, 0, err
 		}
 	}
 
 	// Lazy dynamic memory allocation
 	if len(this.buffer2) < count {
-		this.buffer2 = make([]int32, count)
+		this.buffer2 = this.alloc.MakeInt32(count)
 	}
 
 	sa := this.buffer2
-	this.saAlgo.ComputeSuffixArray(src[0:count], sa[0:count])
-	chunks := GetBWTChunks(count)
+
+	if count >= _BWT_SAIS_MIN_BLOCK && count <= _BWT_SAIS_MAX_BLOCK && isAperiodicRepeat(src[0:count]) == true {
+		computeSuffixArraySAIS(src[0:count], sa[0:count])
+	} else {
+		this.saAlgo.ComputeSuffixArray(src[0:count], sa[0:count])
+	}
+
+	chunks := this.Chunks(count)
 
 	if chunks == 1 {
 		dst[0] = src[count-1]
@@ -237,6 +317,29 @@ func (this *BWT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
+	return this.inverse(src, dst)
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *BWT) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length > uint(len(block)) {
+		errMsg := fmt.Sprintf("BWT inverse failed: output buffer size is %v, expected %v", len(block), length)
+		return 0, errors.New(errMsg)
+	}
+
+	if length == 0 {
+		return 0, nil
+	}
+
+	_, dstIdx, err := this.inverse(block[0:length], block)
+	return dstIdx, err
+}
+
+// The input is entirely read (to build the LF mapping) before the output is
+// written: src and dst may be the same slice
+func (this *BWT) inverse(src, dst []byte) (uint, uint, error) {
 	count := len(src)
 
 	if count > MaxBWTBlockSize() {
@@ -271,7 +374,7 @@ func (this *BWT) Inverse(src, dst []byte) (uint, uint, error) {
 func (this *BWT) inverseSmallBlock(src, dst []byte, count int) (uint, uint, error) {
 	// Lazy dynamic memory allocation
 	if len(this.buffer1) < count {
-		this.buffer1 = make([]uint32, count)
+		this.buffer1 = this.alloc.MakeUint32(count)
 	}
 
 	// Aliasing
@@ -321,7 +424,7 @@ func (this *BWT) inverseSmallBlock(src, dst []byte, count int) (uint, uint, erro
 func (this *BWT) inverseBigBlock(src, dst []byte, count int) (uint, uint, error) {
 	// Lazy dynamic memory allocations
 	if len(this.buffer1) < count+1 {
-		this.buffer1 = make([]uint32, count+1)
+		this.buffer1 = this.alloc.MakeUint32(count + 1)
 	}
 
 	pIdx := int(this.PrimaryIndex(0))
@@ -334,11 +437,24 @@ func (this *BWT) inverseBigBlock(src, dst []byte, count int) (uint, uint, error)
 	kanzi.ComputeHistogram(src[0:count], freqs[:], true, false)
 	buckets := make([]int, 65536)
 
-	for c, sum := 0, 1; c < 256; c++ {
+	// Symbols are gathered in groups of contiguous symbols so that the
+	// entries of 'data' written by a group fit in the L2 cache.
+	groups := [256]uint8{}
+	nbGroups := 0
+
+	for c, sum, size := 0, 1, 0; c < 256; c++ {
 		f := sum
 		sum += int(freqs[c])
 		freqs[c] = f
 
+		if size > 0 && size+sum-f > _BWT_WINDOW_SIZE {
+			nbGroups++
+			size = 0
+		}
+
+		groups[c] = uint8(nbGroups)
+		size += sum - f
+
 		if f != sum {
 			ptr := buckets[c<<8 : (c+1)<<8]
 			var hi, lo int
@@ -396,35 +512,39 @@ func (this *BWT) inverseBigBlock(src, dst []byte, count int) (uint, uint, error)
 
 	data := this.buffer1
 
-	for i := 0; i < pIdx; i++ {
-		c := int(src[i])
-		p := freqs[c]
-		freqs[c]++
-
-		if p < pIdx {
-			idx := (c << 8) | int(src[p])
-			data[buckets[idx]] = uint32(i)
-			buckets[idx]++
-		} else if p > pIdx {
-			idx := (c << 8) | int(src[p-1])
-			data[buckets[idx]] = uint32(i)
-			buckets[idx]++
+	if count >= this.partitionMin {
+		this.scatterPartitioned(src, buckets, freqs[:], groups[:], nbGroups, pIdx, count)
+	} else {
+		for i := 0; i < pIdx; i++ {
+			c := int(src[i])
+			p := freqs[c]
+			freqs[c]++
+
+			if p < pIdx {
+				idx := (c << 8) | int(src[p])
+				data[buckets[idx]] = uint32(i)
+				buckets[idx]++
+			} else if p > pIdx {
+				idx := (c << 8) | int(src[p-1])
+				data[buckets[idx]] = uint32(i)
+				buckets[idx]++
+			}
 		}
-	}
 
-	for i := pIdx; i < count; i++ {
-		c := int(src[i])
-		p := freqs[c]
-		freqs[c]++
-
-		if p < pIdx {
-			idx := (c << 8) | int(src[p])
-			data[buckets[idx]] = uint32(i + 1)
-			buckets[idx]++
-		} else if p > pIdx {
-			idx := (c << 8) | int(src[p-1])
-			data[buckets[idx]] = uint32(i + 1)
-			buckets[idx]++
+		for i := pIdx; i < count; i++ {
+			c := int(src[i])
+			p := freqs[c]
+			freqs[c]++
+
+			if p < pIdx {
+				idx := (c << 8) | int(src[p])
+				data[buckets[idx]] = uint32(i + 1)
+				buckets[idx]++
+			} else if p > pIdx {
+				idx := (c << 8) | int(src[p-1])
+				data[buckets[idx]] = uint32(i + 1)
+				buckets[idx]++
+			}
 		}
 	}
 
@@ -436,7 +556,7 @@ func (this *BWT) inverseBigBlock(src, dst []byte, count int) (uint, uint, error)
 		}
 	}
 
-	chunks := GetBWTChunks(count)
+	chunks := this.Chunks(count)
 
 	// Build inverse
 	if chunks == 1 {
@@ -522,6 +642,139 @@ func (this *BWT) inverseChunkTask(dst []byte, buckets []int, fastBits []uint16,
 	}
 }
 
+// Scattering the indexes directly into 'data' means random writes over the
+// whole block (and a TLB miss for most of them on big blocks). Instead, each
+// segment of the input is first partitioned by symbol group, then the groups
+// are processed in turn, each one writing to its own window of 'data'.
+// The order of the indexes for a given symbol is preserved.
+func (this *BWT) scatterPartitioned(src []byte, buckets, freqs []int, groups []uint8, nbGroups, pIdx, count int) {
+	data := this.buffer1
+	segSize := count
+
+	if segSize > _BWT_SEGMENT_SIZE {
+		segSize = _BWT_SEGMENT_SIZE
+	}
+
+	// Lazy dynamic memory allocation
+	if len(this.buffer2) < segSize {
+		this.buffer2 = this.alloc.MakeInt32(segSize)
+	}
+
+	part := this.buffer2
+
+	for n := 0; n < count; n += segSize {
+		end := n + segSize
+
+		if end > count {
+			end = count
+		}
+
+		var pos [257]int
+
+		for _, c := range src[n:end] {
+			pos[int(groups[c])+1]++
+		}
+
+		for g := 1; g <= nbGroups; g++ {
+			pos[g] += pos[g-1]
+		}
+
+		for i := n; i < end; i++ {
+			g := groups[src[i]]
+			part[pos[g]] = int32(i)
+			pos[g]++
+		}
+
+		for _, i := range part[0 : end-n] {
+			c := int(src[i])
+			p := freqs[c]
+			freqs[c]++
+			val := uint32(i)
+
+			if int(i) >= pIdx {
+				val++
+			}
+
+			if p < pIdx {
+				idx := (c << 8) | int(src[p])
+				data[buckets[idx]] = val
+				buckets[idx]++
+			} else if p > pIdx {
+				idx := (c << 8) | int(src[p-1])
+				data[buckets[idx]] = val
+				buckets[idx]++
+			}
+		}
+	}
+}
+
+// isAperiodicRepeat returns true if the block is made of few distinct
+// substrings (32 bytes sampled at regular positions) and if the data at most
+// sampled positions does not repeat the data at the last occurrence of the
+// same 8 bytes for long (otherwise, the block is periodic or made of runs).
+func isAperiodicRepeat(block []byte) bool {
+	step := len(block) / _BWT_REPEAT_SAMPLES
+	end := len(block) - _BWT_REPEAT_LENGTH
+	hashes := make(map[uint64]struct{})
+
+	for i := 0; i < _BWT_REPEAT_SAMPLES; i++ {
+		h := uint64(0)
+
+		for j := 0; j < 32; j += 8 {
+			h = (h + binary.LittleEndian.Uint64(block[i*step+j:])) * 0x9E3779B97F4A7C15
+		}
+
+		hashes[h] = struct{}{}
+	}
+
+	if len(hashes) > _BWT_REPEAT_SAMPLES/16 {
+		return false
+	}
+
+	// Find the last occurrence of the 8 bytes at each sampled position
+	const hashLog = 22
+	last := make([]int32, 1<<hashLog)
+	periodic := 0
+
+	for i := 0; i < end; i++ {
+		h := (binary.LittleEndian.Uint64(block[i:]) * 0x9E3779B97F4A7C15) >> (64 - hashLog)
+
+		if i%step == 0 {
+			if ref := int(last[h]) - 1; ref >= 0 {
+				n := 0
+
+				for n < _BWT_REPEAT_LENGTH && block[ref+n] == block[i+n] {
+					n++
+				}
+
+				if n == _BWT_REPEAT_LENGTH {
+					periodic++
+				}
+			}
+		}
+
+		last[h] = int32(i + 1)
+	}
+
+	return periodic < _BWT_REPEAT_SAMPLES/2
+}
+
+// computeSuffixArraySAIS builds the suffix array of the block with SA-IS
+func computeSuffixArraySAIS(block []byte, sa []int32) {
+	data := make([]int, len(block))
+	buf := make([]int, len(block))
+
+	for i := range block {
+		data[i] = int(block[i])
+	}
+
+	ComputeSuffixArray(data, buf, 0, len(block), 256, false)
+
+	for i := range buf {
+		sa[i] = int32(buf[i])
+	}
+}
+
 // MaxBWTBlockSize returns the maximum size of a block to transform
 func MaxBWTBlockSize() int {
 	return _BWT_MAX_BLOCK_SIZE
@@ -529,11 +782,11 @@ func MaxBWTBlockSize() int {
 
 // GetBWTChunks returns the number of chunks for a given block size
 func GetBWTChunks(size int) int {
-	if size < 4*1024*1024 {
+	if size < 2*_BWT_CHUNK_SIZE {
 		return 1
 	}
 
-	res := (size + (1 << 21)) >> 22
+	res := (size + (_BWT_CHUNK_SIZE >> 1)) / _BWT_CHUNK_SIZE
 
 	if res > _BWT_MAX_CHUNKS {
 		return _BWT_MAX_CHUNKS
@@ -541,3 +794,19 @@ func GetBWTChunks(size int) int {
 
 	return res
 }
+
+// getBWTChunksV9 returns the number of chunks for a given block size in
+// the stream format versions up to 9
+func getBWTChunksV9(size int) int {
+	if size < _BWT_V9_CHUNK_SIZE {
+		return 1
+	}
+
+	res := (size + (_BWT_V9_CHUNK_SIZE >> 1)) / _BWT_V9_CHUNK_SIZE
+
+	if res > _BWT_V9_MAX_CHUNKS {
+		return _BWT_V9_MAX_CHUNKS
+	}
+
+	return res
+}
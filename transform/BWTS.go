@@ -18,6 +18,8 @@ package transform
 import (
 	"errors"
 	"fmt"
+
+	"github.com/flanglet/kanzi-go/util"
 )
 
 const (
@@ -29,10 +31,15 @@ const (
 // index (hence the bijectivity). BWTS is about 10% slower than BWT.
 // Forward transform based on the code at https://code.google.com/p/mk-bwts/
 // by Neal Burns and DivSufSort (port of libDivSufSort by Yuta Mori)
+// The "bwtsMaxFactor" context entry (uint, 0 means no limit) bounds the length
+// of the Lyndon factors (see LyndonFactors): the forward transform of a block
+// with a longer factor fails (and the stage is skipped) instead of sorting it.
 type BWTS struct {
-	buffer1 []int32
-	buffer2 []int32
-	saAlgo  *DivSufSort
+	buffer1   []int32
+	buffer2   []int32
+	saAlgo    *DivSufSort
+	alloc     *util.Allocator // nil unless aligned allocation is requested
+	maxFactor int             // 0 => no limit
 }
 
 // NewBWTS creates a new instance of BWTS
@@ -49,9 +56,30 @@ func NewBWTSWithCtx(ctx *map[string]interface{}) (*BWTS, error) {
 	this := &BWTS{}
 	this.buffer1 = make([]int32, 0)
 	this.buffer2 = make([]int32, 0)
+	this.alloc = util.NewAllocatorWithCtx(ctx)
+
+	if val, containsKey := (*ctx)["bwtsMaxFactor"]; containsKey {
+		maxFactor := val.(uint)
+
+		if maxFactor > _BWTS_MAX_BLOCK_SIZE {
+			return nil, fmt.Errorf("Invalid BWTS max factor parameter: %v (must be at most %v)", maxFactor, _BWTS_MAX_BLOCK_SIZE)
+		}
+
+		this.maxFactor = int(maxFactor)
+	}
+
 	return this, nil
 }
 
+// Release hands the work buffers back to the shared pool in manual memory
+// mode (see util.Allocator), or drops them for the GC otherwise.
+func (this *BWTS) Release() {
+	this.alloc.ReleaseInt32(this.buffer1)
+	this.alloc.ReleaseInt32(this.buffer2)
+	this.buffer1 = make([]int32, 0)
+	this.buffer2 = make([]int32, 0)
+}
+
 // Forward applies the function to the src and writes the result
 // to the destination. Returns number of bytes read, number of bytes
 // written and possibly an error.
@@ -87,6 +115,17 @@ func (this *BWTS) Forward(src, dst []byte) (uint, uint, error) {
 		return uint(count), uint(count), nil
 	}
 
+	if this.maxFactor > 0 {
+		// Linear time check before the sort
+		bounded := forEachLyndonFactor(src[0:count], func(start, length int) bool {
+			return length <= this.maxFactor
+		})
+
+		if bounded == false {
+			return 0, 0, fmt.Errorf("Lyndon factor longer than %v bytes, skip", this.maxFactor)
+		}
+	}
+
 	if this.saAlgo == nil {
 		var err error
 
@@ -97,11 +136,11 @@ func (this *BWTS) Forward(src, dst []byte) (uint, uint, error) {
 
 	// Lazy dynamic memory allocations
 	if len(this.buffer1) < count {
-		this.buffer1 = make([]int32, count)
+		this.buffer1 = this.alloc.MakeInt32(count)
 	}
 
 	if len(this.buffer2) < count {
-		this.buffer2 = make([]int32, count)
+		this.buffer2 = this.alloc.MakeInt32(count)
 	}
 
 	// Aliasing
@@ -245,7 +284,7 @@ func (this *BWTS) Inverse(src, dst []byte) (uint, uint, error) {
 
 	// Lazy dynamic memory allocation
 	if len(this.buffer1) < count {
-		this.buffer1 = make([]int32, count)
+		this.buffer1 = this.alloc.MakeInt32(count)
 	}
 
 	// Aliasing
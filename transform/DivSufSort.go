@@ -15,6 +15,13 @@ limitations under the License.
 
 package transform
 
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
 const (
 	_SS_INSERTIONSORT_THRESHOLD = int32(8)
 	_SS_BLOCKSIZE               = int32(1024)
@@ -25,6 +32,9 @@ const (
 	_MASK_FFFF0000              = -65536    // make 32 bit systems happy
 	_MASK_FF000000              = -16777216 // make 32 bit systems happy
 	_MASK_0000FF00              = 65280     // make 32 bit systems happy
+
+	// Min block size to sort the B* substrings concurrently
+	_SS_PARALLEL_MIN_SIZE = int32(1 << 20)
 )
 
 var _SQQ_TABLE = []int32{
@@ -67,14 +77,27 @@ type DivSufSort struct {
 	ssStack    *stack
 	trStack    *stack
 	mergestack *stack
+	jobs       uint
 }
 
 // NewDivSufSort creates a new instance of DivSufSort
 func NewDivSufSort() (*DivSufSort, error) {
+	return NewDivSufSortWithJobs(1)
+}
+
+// NewDivSufSortWithJobs creates a new instance of DivSufSort sorting the
+// buckets of type B* substrings with up to 'jobs' concurrent goroutines.
+// The suffix array is the same for any number of jobs.
+func NewDivSufSortWithJobs(jobs uint) (*DivSufSort, error) {
+	if jobs == 0 {
+		return nil, errors.New("DivSufSort: The number of jobs must be at least 1")
+	}
+
 	this := new(DivSufSort)
 	this.ssStack = newStack(_SS_MISORT_STACKSIZE)
 	this.trStack = newStack(_TR_STACKSIZE)
 	this.mergestack = newStack(_SS_SMERGE_STACKSIZE)
+	this.jobs = jobs
 	return this, nil
 }
 
@@ -361,19 +384,24 @@ func (this *DivSufSort) sortTypeBstar(bucketA, bucketB []int32, n int32) int32 {
 
 		// Sort the type B* substrings using ssSort.
 		bufSize := n - m - m
-		x0 = 254
 
-		for j := m; j > 0; x0-- {
-			idx := x0 << 8
+		if this.jobs > 1 && n >= _SS_PARALLEL_MIN_SIZE {
+			this.ssSortBuckets(bucketB, pab, m, bufSize, n)
+		} else {
+			x0 = 254
 
-			for x1 := 255; x1 > x0; x1-- {
-				i := bucketB[idx+x1]
+			for j := m; j > 0; x0-- {
+				idx := x0 << 8
 
-				if j-i > 1 {
-					this.ssSort(pab, i, j, m, bufSize, 2, n, arr[i] == m-1)
-				}
+				for x1 := 255; x1 > x0; x1-- {
+					i := bucketB[idx+x1]
 
-				j = i
+					if j-i > 1 {
+						this.ssSort(pab, i, j, m, bufSize, 2, n, arr[i] == m-1)
+					}
+
+					j = i
+				}
 			}
 		}
 
@@ -491,6 +519,71 @@ func (this *DivSufSort) sortTypeBstar(bucketA, bucketB []int32, n int32) int32 {
 	return m
 }
 
+// ssSortBuckets sorts the buckets of type B* substrings concurrently. The
+// buckets occupy disjoint ranges of the suffix array, so each goroutine only
+// needs its own stacks and its own part of the work area (same approach as
+// the OpenMP version of libdivsufsort).
+func (this *DivSufSort) ssSortBuckets(bucketB []int32, pa, m, bufSize, n int32) {
+	buckets := make([][2]int32, 0, 1024)
+
+	for x0, j := 254, m; j > 0; x0-- {
+		idx := x0 << 8
+
+		for x1 := 255; x1 > x0; x1-- {
+			i := bucketB[idx+x1]
+
+			if j-i > 1 {
+				buckets = append(buckets, [2]int32{i, j})
+			}
+
+			j = i
+		}
+	}
+
+	// Biggest buckets first to balance the load
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i][1]-buckets[i][0] > buckets[j][1]-buckets[j][0]
+	})
+
+	jobs := int(this.jobs)
+
+	if jobs > len(buckets) {
+		jobs = len(buckets)
+	}
+
+	if jobs == 0 {
+		return
+	}
+
+	jobBufSize := bufSize / int32(jobs)
+	next := int32(-1)
+	var wg sync.WaitGroup
+
+	for k := 0; k < jobs; k++ {
+		wg.Add(1)
+
+		go func(buf int32) {
+			defer wg.Done()
+			worker := &DivSufSort{sa: this.sa, buffer: this.buffer}
+			worker.ssStack = newStack(_SS_MISORT_STACKSIZE)
+			worker.mergestack = newStack(_SS_SMERGE_STACKSIZE)
+
+			for {
+				b := int(atomic.AddInt32(&next, 1))
+
+				if b >= len(buckets) {
+					break
+				}
+
+				first, last := buckets[b][0], buckets[b][1]
+				worker.ssSort(pa, first, last, buf, jobBufSize, 2, n, this.sa[first] == m-1)
+			}
+		}(m + int32(k)*jobBufSize)
+	}
+
+	wg.Wait()
+}
+
 // Sub String Sort
 func (this *DivSufSort) ssSort(pa, first, last, buf, bufSize, depth, n int32, lastSuffix bool) {
 	if lastSuffix == true {
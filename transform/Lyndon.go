@@ -0,0 +1,79 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+// Lyndon factorization (Chen-Fox-Lyndon): every string is the unique
+// concatenation of Lyndon words (words strictly smaller than all their
+// rotations) in non increasing lexicographic order. BWTS sorts the rotations
+// of these factors. The factors are computed with Duval's algorithm (linear
+// time, no extra memory).
+
+// LyndonFactors returns the start offsets of the Lyndon factors of data.
+// Factor i is data[f[i]:f[i+1]], the last one ends at len(data).
+func LyndonFactors(data []byte) []int {
+	factors := make([]int, 0)
+
+	forEachLyndonFactor(data, func(start, length int) bool {
+		factors = append(factors, start)
+		return true
+	})
+
+	return factors
+}
+
+// LongestLyndonFactor returns the length of the longest Lyndon factor of data
+func LongestLyndonFactor(data []byte) int {
+	res := 0
+
+	forEachLyndonFactor(data, func(start, length int) bool {
+		if length > res {
+			res = length
+		}
+
+		return true
+	})
+
+	return res
+}
+
+// forEachLyndonFactor calls fn for each Lyndon factor of data, in order,
+// until fn returns false. Returns false if the factorization was interrupted.
+func forEachLyndonFactor(data []byte, fn func(start, length int) bool) bool {
+	n := len(data)
+
+	for k := 0; k < n; {
+		i, j := k, k+1
+
+		for j < n && data[i] <= data[j] {
+			if data[i] < data[j] {
+				i = k
+			} else {
+				i++
+			}
+
+			j++
+		}
+
+		// data[k:j] is a power of the Lyndon word of length j-i (plus a prefix)
+		for length := j - i; k <= i; k += length {
+			if fn(k, length) == false {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -29,6 +29,13 @@ import (
 // SBR(0)= Move to Front Transform
 // SBR(1)= Time Stamp Transform
 // This code implements SBR(0), SBR(1/2) and SBR(1). Code derived from openBWT
+// It also implements the MTF-1 and MTF-2 variants of Move to Front where a
+// symbol moves to the second position of the list and only the symbol
+// already there moves to the front (for MTF-2, only when the previous symbol
+// was not the first of the list). The symbols of short interruptions of a run
+// do not replace the symbol of the run at the front.
+// See [Modifications of the Burrows and Wheeler Data Compression Algorithm]
+// by Balkenhol, Kurtz and Shtarkov for details.
 
 const (
 	// SBRT_MODE_MTF mode MoveToFront
@@ -37,6 +44,10 @@ const (
 	SBRT_MODE_RANK = 2
 	// SBRT_MODE_TIMESTAMP mode TimeStamp
 	SBRT_MODE_TIMESTAMP = 3
+	// SBRT_MODE_MTF1 mode MoveToFront-1
+	SBRT_MODE_MTF1 = 4
+	// SBRT_MODE_MTF2 mode MoveToFront-2
+	SBRT_MODE_MTF2 = 5
 )
 
 // SBRT Sort By Rank Transform
@@ -49,7 +60,7 @@ type SBRT struct {
 
 // NewSBRT creates a new instance of SBRT
 func NewSBRT(mode int) (*SBRT, error) {
-	if mode != SBRT_MODE_MTF && mode != SBRT_MODE_RANK && mode != SBRT_MODE_TIMESTAMP {
+	if mode < SBRT_MODE_MTF || mode > SBRT_MODE_MTF2 {
 		return nil, errors.New("Invalid mode parameter")
 	}
 
@@ -86,7 +97,7 @@ func NewSBRTWithCtx(ctx *map[string]interface{}) (*SBRT, error) {
 		mode = (*ctx)["sbrt"].(int)
 	}
 
-	if mode != SBRT_MODE_MTF && mode != SBRT_MODE_RANK && mode != SBRT_MODE_TIMESTAMP {
+	if mode < SBRT_MODE_MTF || mode > SBRT_MODE_MTF2 {
 		return nil, errors.New("Invalid mode parameter")
 	}
 
@@ -133,6 +144,16 @@ func (this *SBRT) Forward(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New(errMsg)
 	}
 
+	if this.mode == SBRT_MODE_MTF {
+		forwardMTF(src, dst[0:count])
+		return uint(count), uint(count), nil
+	}
+
+	if this.mode == SBRT_MODE_MTF1 || this.mode == SBRT_MODE_MTF2 {
+		forwardMTF1(src, dst[0:count], this.mode == SBRT_MODE_MTF2)
+		return uint(count), uint(count), nil
+	}
+
 	s2r := [256]uint8{}
 	r2s := [256]uint8{}
 
@@ -181,6 +202,25 @@ func (this *SBRT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New("Input and output buffers cannot be equal")
 	}
 
+	return this.inverse(src, dst)
+}
+
+// InverseInPlace applies the reverse function to the first 'length' bytes
+// of the block and writes the result to the block. Returns the number of
+// bytes written and possibly an error.
+func (this *SBRT) InverseInPlace(block []byte, length uint) (uint, error) {
+	if length > uint(len(block)) {
+		errMsg := fmt.Sprintf("Block size is %v, output buffer length is %v", length, len(block))
+		return 0, errors.New(errMsg)
+	}
+
+	_, dstIdx, err := this.inverse(block[0:length], block)
+	return dstIdx, err
+}
+
+// Each symbol is read before the byte at the same index is written: src and
+// dst may be the same slice
+func (this *SBRT) inverse(src, dst []byte) (uint, uint, error) {
 	count := len(src)
 
 	if count > len(dst) {
@@ -188,6 +228,20 @@ func (this *SBRT) Inverse(src, dst []byte) (uint, uint, error) {
 		return 0, 0, errors.New(errMsg)
 	}
 
+	if count == 0 {
+		return 0, 0, nil
+	}
+
+	if this.mode == SBRT_MODE_MTF {
+		inverseMTF(src, dst[0:count])
+		return uint(count), uint(count), nil
+	}
+
+	if this.mode == SBRT_MODE_MTF1 || this.mode == SBRT_MODE_MTF2 {
+		inverseMTF1(src, dst[0:count], this.mode == SBRT_MODE_MTF2)
+		return uint(count), uint(count), nil
+	}
+
 	r2s := [256]uint8{}
 
 	for i := range r2s {
@@ -219,3 +273,117 @@ func (this *SBRT) Inverse(src, dst []byte) (uint, uint, error) {
 
 	return uint(count), uint(count), nil
 }
+
+// Move to front needs no access time: the list is a flat array of symbols
+// where the head is checked first (the most frequent case after a BWT), the
+// rank of other symbols is found by a short scan and the list is updated with
+// a single memmove. No rank table to maintain, no dependent loads.
+func forwardMTF(src, dst []byte) {
+	var list [256]uint8
+
+	for i := range &list {
+		list[i] = uint8(i)
+	}
+
+	dst = dst[0:len(src)]
+
+	for i, c := range src {
+		if list[0] == c {
+			dst[i] = 0
+			continue
+		}
+
+		r := uint8(1)
+
+		for list[r] != c {
+			r++
+		}
+
+		dst[i] = r
+		copy(list[1:int(r)+1], list[0:r])
+		list[0] = c
+	}
+}
+
+func inverseMTF(src, dst []byte) {
+	var list [256]uint8
+
+	for i := range &list {
+		list[i] = uint8(i)
+	}
+
+	dst = dst[0:len(src)]
+
+	for i, r := range src {
+		c := list[r]
+		dst[i] = c
+
+		if r != 0 {
+			copy(list[1:int(r)+1], list[0:r])
+			list[0] = c
+		}
+	}
+}
+
+// MTF-1 (MTF-2 when 'sticky' is true): the front symbol is only replaced by
+// the second one, other symbols move to the second position.
+func forwardMTF1(src, dst []byte, sticky bool) {
+	var list [256]uint8
+
+	for i := range &list {
+		list[i] = uint8(i)
+	}
+
+	dst = dst[0:len(src)]
+	last := uint8(1)
+
+	for i, c := range src {
+		r := uint8(0)
+
+		for list[r] != c {
+			r++
+		}
+
+		dst[i] = r
+
+		if r == 1 {
+			if sticky == false || last != 0 {
+				list[1] = list[0]
+				list[0] = c
+			}
+		} else if r > 1 {
+			copy(list[2:int(r)+1], list[1:r])
+			list[1] = c
+		}
+
+		last = r
+	}
+}
+
+func inverseMTF1(src, dst []byte, sticky bool) {
+	var list [256]uint8
+
+	for i := range &list {
+		list[i] = uint8(i)
+	}
+
+	dst = dst[0:len(src)]
+	last := uint8(1)
+
+	for i, r := range src {
+		c := list[r]
+		dst[i] = c
+
+		if r == 1 {
+			if sticky == false || last != 0 {
+				list[1] = list[0]
+				list[0] = c
+			}
+		} else if r > 1 {
+			copy(list[2:int(r)+1], list[1:r])
+			list[1] = c
+		}
+
+		last = r
+	}
+}
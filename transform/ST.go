@@ -0,0 +1,395 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flanglet/kanzi-go/util"
+)
+
+const (
+	// ST_MIN_ORDER is the min number of context bytes of the Schindler Transform
+	ST_MIN_ORDER = 3
+
+	// ST_MAX_ORDER is the max number of context bytes of the Schindler Transform
+	ST_MAX_ORDER = 6
+
+	_ST_MAX_BLOCK_SIZE = 1024 * 1024 * 1024 // 1 GB
+)
+
+// The Schindler Transform (or limited order Sort Transform) is a BWT where the
+// rotations of the block are sorted on their first N bytes only, the ties
+// being broken by position. The forward transform is a few passes of radix
+// sort instead of a full suffix sorting. The output is close to the BWT for
+// the contexts shorter than N, so that the compression is a little worse.
+// See Michael Schindler, [A fast block-sorting algorithm for lossless data
+// compression], DCC 1997
+
+// The inverse transform first rebuilds the context (order 1, 2, up to N) of
+// each sorted rotation: the contexts of order j are the sorted pairs made of
+// the last byte and the context of order j-1 of each rotation. Then the block is
+// rebuilt from the end: the rotations sharing a context are sorted by position,
+// so the rotation preceding the current one is the last rotation not visited
+// yet in its context.
+
+// ST Schindler Transform
+type ST struct {
+	order        uint
+	buffer1      []int32
+	buffer2      []int32
+	buffer3      []int32
+	buffer4      []byte
+	primaryIndex uint
+	alloc        *util.Allocator // nil unless aligned allocation is requested
+}
+
+// NewST creates a new instance of ST sorting the rotations on 'order' bytes
+// (in [ST_MIN_ORDER..ST_MAX_ORDER])
+func NewST(order uint) (*ST, error) {
+	if order < ST_MIN_ORDER || order > ST_MAX_ORDER {
+		return nil, fmt.Errorf("Invalid ST order: %v (must be in [%d..%d])", order, ST_MIN_ORDER, ST_MAX_ORDER)
+	}
+
+	this := &ST{}
+	this.order = order
+	this.buffer1 = make([]int32, 0)
+	this.buffer2 = make([]int32, 0)
+	this.buffer3 = make([]int32, 0)
+	this.buffer4 = make([]byte, 0)
+	return this, nil
+}
+
+// NewSTWithCtx creates a new instance of ST using a configuration map as
+// parameter. The order is extracted from the "st" key (default is 4).
+func NewSTWithCtx(ctx *map[string]interface{}) (*ST, error) {
+	order := uint(4)
+
+	if _, containsKey := (*ctx)["st"]; containsKey {
+		order = (*ctx)["st"].(uint)
+	}
+
+	this, err := NewST(order)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.alloc = util.NewAllocatorWithCtx(ctx)
+	return this, nil
+}
+
+// Release hands the work buffers back to the shared pool in manual memory
+// mode (see util.Allocator), or drops them for the GC otherwise.
+func (this *ST) Release() {
+	this.alloc.ReleaseInt32(this.buffer1)
+	this.alloc.ReleaseInt32(this.buffer2)
+	this.alloc.ReleaseInt32(this.buffer3)
+	this.alloc.ReleaseBytes(this.buffer4)
+	this.buffer1 = make([]int32, 0)
+	this.buffer2 = make([]int32, 0)
+	this.buffer3 = make([]int32, 0)
+	this.buffer4 = make([]byte, 0)
+}
+
+// Order returns the number of context bytes the rotations are sorted on
+func (this *ST) Order() uint {
+	return this.order
+}
+
+// PrimaryIndex returns the index of the first rotation in the sorted rotations
+func (this *ST) PrimaryIndex() uint {
+	return this.primaryIndex
+}
+
+// SetPrimaryIndex sets the index of the first rotation in the sorted rotations
+func (this *ST) SetPrimaryIndex(primaryIndex uint) {
+	this.primaryIndex = primaryIndex
+}
+
+// Forward applies the function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *ST) Forward(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if count > MaxSTBlockSize() {
+		// Not a recoverable error: instead of silently fail the transform,
+		// issue a fatal error.
+		errMsg := fmt.Sprintf("The max ST block size is %v, got %v", MaxSTBlockSize(), count)
+		panic(errors.New(errMsg))
+	}
+
+	if count > len(dst) {
+		errMsg := fmt.Sprintf("Block size is %v, output buffer length is %v", count, len(dst))
+		return 0, 0, errors.New(errMsg)
+	}
+
+	if count < 2 {
+		if count == 1 {
+			dst[0] = src[0]
+		}
+
+		this.primaryIndex = 0
+		return uint(count), uint(count), nil
+	}
+
+	// Lazy dynamic memory allocation
+	if len(this.buffer1) < count {
+		this.alloc.ReleaseInt32(this.buffer1)
+		this.buffer1 = this.alloc.MakeInt32(count)
+	}
+
+	if len(this.buffer2) < count {
+		this.alloc.ReleaseInt32(this.buffer2)
+		this.buffer2 = this.alloc.MakeInt32(count)
+	}
+
+	if len(this.buffer4) < count+ST_MAX_ORDER {
+		this.alloc.ReleaseBytes(this.buffer4)
+		this.buffer4 = this.alloc.MakeBytes(count + ST_MAX_ORDER)
+	}
+
+	// Rotations: the block followed by its first bytes
+	data := this.buffer4
+	copy(data, src[0:count])
+
+	for i := 0; i < ST_MAX_ORDER; i++ {
+		data[count+i] = data[i%count]
+	}
+
+	// LSD radix sort of the rotations on 'order' bytes (16 bits per pass
+	// except the first one for odd orders). Each pass is stable, so the ties
+	// remain sorted by position.
+	sa := this.buffer1[0:count]
+	tmp := this.buffer2[0:count]
+	var buckets [65536]int32
+	offset := int(this.order)
+	first := true
+
+	for offset > 0 {
+		var bits uint
+
+		if offset&1 == 1 {
+			offset--
+			bits = 8
+		} else {
+			offset -= 2
+			bits = 16
+		}
+
+		for i := range buckets {
+			buckets[i] = 0
+		}
+
+		if bits == 8 {
+			for _, c := range data[offset : offset+count] {
+				buckets[c]++
+			}
+		} else {
+			for i := offset; i < offset+count; i++ {
+				buckets[(int(data[i])<<8)|int(data[i+1])]++
+			}
+		}
+
+		sum := int32(0)
+
+		for i := range buckets[0 : 1<<bits] {
+			sum, buckets[i] = sum+buckets[i], sum
+		}
+
+		if first == true {
+			// Initial order: by position
+			if bits == 8 {
+				for i, c := range data[offset : offset+count] {
+					sa[buckets[c]] = int32(i)
+					buckets[c]++
+				}
+			} else {
+				for i := 0; i < count; i++ {
+					key := (int(data[offset+i]) << 8) | int(data[offset+i+1])
+					sa[buckets[key]] = int32(i)
+					buckets[key]++
+				}
+			}
+
+			first = false
+			continue
+		}
+
+		for _, p := range sa {
+			q := int(p) + offset
+			key := (int(data[q]) << 8) | int(data[q+1])
+			tmp[buckets[key]] = p
+			buckets[key]++
+		}
+
+		sa, tmp = tmp, sa
+	}
+
+	for i, p := range sa {
+		if p == 0 {
+			this.primaryIndex = uint(i)
+			dst[i] = src[count-1]
+		} else {
+			dst[i] = src[p-1]
+		}
+	}
+
+	return uint(count), uint(count), nil
+}
+
+// Inverse applies the reverse function to the src and writes the result
+// to the destination. Returns number of bytes read, number of bytes
+// written and possibly an error.
+func (this *ST) Inverse(src, dst []byte) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if &src[0] == &dst[0] {
+		return 0, 0, errors.New("Input and output buffers cannot be equal")
+	}
+
+	count := len(src)
+
+	if count > MaxSTBlockSize() {
+		errMsg := fmt.Sprintf("The max ST block size is %v, got %v", MaxSTBlockSize(), count)
+		return 0, 0, errors.New(errMsg)
+	}
+
+	if count > len(dst) {
+		errMsg := fmt.Sprintf("Block size is %v, output buffer length is %v", count, len(dst))
+		return 0, 0, errors.New(errMsg)
+	}
+
+	if count < 2 {
+		if count == 1 {
+			dst[0] = src[0]
+		}
+
+		return uint(count), uint(count), nil
+	}
+
+	if this.primaryIndex >= uint(count) {
+		return 0, 0, errors.New("Invalid primary index in bitstream")
+	}
+
+	// Lazy dynamic memory allocation
+	if len(this.buffer1) < count {
+		this.alloc.ReleaseInt32(this.buffer1)
+		this.buffer1 = this.alloc.MakeInt32(count)
+	}
+
+	if len(this.buffer2) < count {
+		this.alloc.ReleaseInt32(this.buffer2)
+		this.buffer2 = this.alloc.MakeInt32(count)
+	}
+
+	if len(this.buffer3) < count {
+		this.alloc.ReleaseInt32(this.buffer3)
+		this.buffer3 = this.alloc.MakeInt32(count)
+	}
+
+	// The context of a rotation is identified by the index of the first
+	// rotation with this context.
+	ctx := this.buffer1[0:count]
+	perm := this.buffer2[0:count]
+	next := this.buffer3[0:count]
+	var starts [257]int32
+
+	for _, c := range src[0:count] {
+		starts[int(c)+1]++
+	}
+
+	for i := 1; i <= 256; i++ {
+		starts[i] += starts[i-1]
+	}
+
+	// Order 1 contexts
+	for c := 0; c < 256; c++ {
+		for i := starts[c]; i < starts[c+1]; i++ {
+			ctx[i] = starts[c]
+		}
+	}
+
+	// Order 2 to 'order' contexts. The rotations are sorted by context of
+	// order j-1, so a stable sort by last byte sorts the pairs.
+	for j := uint(2); j <= this.order; j++ {
+		var pos [256]int32
+		copy(pos[:], starts[0:256])
+
+		for r, c := range src[0:count] {
+			perm[pos[c]] = int32(r)
+			pos[c]++
+		}
+
+		next[0] = 0
+
+		for i := 1; i < count; i++ {
+			if src[perm[i]] != src[perm[i-1]] || ctx[perm[i]] != ctx[perm[i-1]] {
+				next[i] = int32(i)
+			} else {
+				next[i] = next[i-1]
+			}
+		}
+
+		if j == this.order {
+			break
+		}
+
+		ctx, next = next, ctx
+	}
+
+	// The context of the rotation preceding rotation r is made of the last
+	// byte and the context of order 'order'-1 of r.
+	for i, r := range perm {
+		ctx[r] = next[i]
+	}
+
+	// Index after the last rotation not visited yet, by context
+	for i := 0; i < count; i++ {
+		if i == count-1 || next[i+1] != next[i] {
+			perm[next[i]] = int32(i + 1)
+		}
+	}
+
+	r := int32(this.primaryIndex)
+
+	for p := count - 1; p > 0; p-- {
+		dst[p] = src[r]
+		s := ctx[r]
+		perm[s]--
+		r = perm[s]
+	}
+
+	dst[0] = src[r]
+	return uint(count), uint(count), nil
+}
+
+// MaxSTBlockSize returns the maximum size of a block to transform
+func MaxSTBlockSize() int {
+	return _ST_MAX_BLOCK_SIZE
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"unsafe"
+)
+
+const (
+	// DEFAULT_ALIGN_THRESHOLD is the buffer size (in bytes) from which the
+	// allocator aligns buffers, unless "alignThreshold" is provided
+	DEFAULT_ALIGN_THRESHOLD = 64 * 1024 * 1024
+)
+
+// Allocator creates the large buffers (blocks, suffix arrays, inverse BWT
+// vectors, model tables). Buffers at least as large as the threshold are
+// aligned on a page boundary (a huge page on Linux, where transparent huge
+// pages are also requested) to reduce TLB pressure. In manual memory mode,
+// the buffers are taken from SharedPool and returned to it by the Release
+// methods, instead of waiting for the GC.
+// A nil Allocator is valid and always returns plain Go slices.
+type Allocator struct {
+	threshold int
+	aligned   bool
+	pool      *BufferPool
+}
+
+// NewAllocatorWithCtx returns an Allocator if the "alignedAlloc" or the
+// "manualMemory" parameter of the map is true, nil otherwise. The
+// "alignThreshold" parameter (in bytes) overrides the default threshold.
+func NewAllocatorWithCtx(ctx *map[string]interface{}) *Allocator {
+	if ctx == nil {
+		return nil
+	}
+
+	this := &Allocator{threshold: DEFAULT_ALIGN_THRESHOLD}
+
+	if val, containsKey := (*ctx)["alignedAlloc"]; containsKey {
+		this.aligned = val.(bool)
+	}
+
+	if val, containsKey := (*ctx)["manualMemory"]; containsKey && val.(bool) == true {
+		this.pool = SharedPool
+	}
+
+	if this.aligned == false && this.pool == nil {
+		return nil
+	}
+
+	if val, containsKey := (*ctx)["alignThreshold"]; containsKey {
+		this.threshold = int(val.(uint))
+	}
+
+	return this
+}
+
+// Aligned returns true if a buffer of 'size' bytes is aligned by the allocator
+func (this *Allocator) Aligned(size int) bool {
+	return this != nil && this.aligned == true && size > 0 && size >= this.threshold
+}
+
+// Pooled returns true if the allocator reuses released buffers
+func (this *Allocator) Pooled() bool {
+	return this != nil && this.pool != nil
+}
+
+// MakeBytes returns a zeroed slice of 'n' bytes
+func (this *Allocator) MakeBytes(n int) []byte {
+	aligned := this.Aligned(n)
+
+	if this.Pooled() == true && n >= _POOL_MIN_SIZE {
+		if buf := this.pool.get(n, aligned); buf != nil {
+			for i := range buf {
+				buf[i] = 0
+			}
+
+			return buf
+		}
+	}
+
+	if aligned == false {
+		return make([]byte, n)
+	}
+
+	return alignedBytes(n)
+}
+
+// MakeInt32 returns a zeroed slice of 'n' int32
+func (this *Allocator) MakeInt32(n int) []int32 {
+	if this.Aligned(4*n) == false && (this.Pooled() == false || 4*n < _POOL_MIN_SIZE) {
+		return make([]int32, n)
+	}
+
+	buf := this.MakeBytes(4 * n)
+	return unsafe.Slice((*int32)(unsafe.Pointer(&buf[0])), cap(buf)/4)[0:n]
+}
+
+// MakeUint32 returns a zeroed slice of 'n' uint32
+func (this *Allocator) MakeUint32(n int) []uint32 {
+	if this.Aligned(4*n) == false && (this.Pooled() == false || 4*n < _POOL_MIN_SIZE) {
+		return make([]uint32, n)
+	}
+
+	buf := this.MakeBytes(4 * n)
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&buf[0])), cap(buf)/4)[0:n]
+}
+
+// GrowBytes returns a slice of at least 'n' bytes starting with the content
+// of 'buf'. 'buf' is returned if it is large enough, otherwise it is released.
+func (this *Allocator) GrowBytes(buf []byte, n int) []byte {
+	if len(buf) >= n {
+		return buf
+	}
+
+	if this == nil {
+		extraBuf := make([]byte, n-len(buf))
+		return append(buf, extraBuf...)
+	}
+
+	res := this.MakeBytes(n)
+	copy(res, buf)
+	this.ReleaseBytes(buf)
+	return res
+}
+
+// ReleaseBytes returns the buffer to the pool in manual memory mode (and
+// does nothing otherwise). The buffer must not be used after this call.
+func (this *Allocator) ReleaseBytes(buf []byte) {
+	if this.Pooled() == false || cap(buf) < _POOL_MIN_SIZE {
+		return
+	}
+
+	buf = buf[0:cap(buf)]
+	this.pool.put(buf, isAligned(buf))
+}
+
+// ReleaseInt32 returns the buffer to the pool in manual memory mode (and
+// does nothing otherwise). The buffer must not be used after this call.
+func (this *Allocator) ReleaseInt32(buf []int32) {
+	if this.Pooled() == false || cap(buf) == 0 {
+		return
+	}
+
+	buf = buf[0:cap(buf)]
+	this.ReleaseBytes(unsafe.Slice((*byte)(unsafe.Pointer(&buf[0])), 4*len(buf)))
+}
+
+// ReleaseUint32 returns the buffer to the pool in manual memory mode (and
+// does nothing otherwise). The buffer must not be used after this call.
+func (this *Allocator) ReleaseUint32(buf []uint32) {
+	if this.Pooled() == false || cap(buf) == 0 {
+		return
+	}
+
+	buf = buf[0:cap(buf)]
+	this.ReleaseBytes(unsafe.Slice((*byte)(unsafe.Pointer(&buf[0])), 4*len(buf)))
+}
+
+func isAligned(buf []byte) bool {
+	return uintptr(unsafe.Pointer(&buf[0]))&(_ALLOC_ALIGN-1) == 0
+}
+
+// alignedBytes allocates 'n' bytes (n > 0) starting on an _ALLOC_ALIGN boundary.
+// The Go heap does not move objects, so the alignment holds for the lifetime
+// of the slice.
+func alignedBytes(n int) []byte {
+	buf := make([]byte, n+_ALLOC_ALIGN)
+	offset := 0
+
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (_ALLOC_ALIGN - 1)); rem != 0 {
+		offset = _ALLOC_ALIGN - rem
+	}
+
+	res := buf[offset : offset+n : offset+n]
+	adviseHugePages(res)
+	return res
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"syscall"
+)
+
+// Huge page size (x86-64 and most arm64 kernels)
+const _ALLOC_ALIGN = 2 * 1024 * 1024
+
+// Request transparent huge pages for the buffer. This is only a hint: the
+// error is ignored (EG. THP disabled in the kernel).
+func adviseHugePages(buf []byte) {
+	syscall.Madvise(buf, syscall.MADV_HUGEPAGE)
+}
@@ -17,6 +17,7 @@ package util
 
 import (
 	"errors"
+	"io"
 )
 
 // BufferStream a closable read/write stream of bytes backed by a slice
@@ -98,3 +99,30 @@ func (this *BufferStream) SetOffset(off int) error {
 	this.off = off
 	return nil
 }
+
+// Seek sets the offset of the read pointer (writes always append to the
+// stream), from the start, the current offset or the end of the stream
+// depending on 'whence'. Returns the new offset and an error if the offset
+// value is invalid or the stream is closed.
+func (this *BufferStream) Seek(offset int64, whence int) (int64, error) {
+	if this.closed == true {
+		return 0, errors.New("Stream closed")
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(this.off)
+	case io.SeekEnd:
+		offset += int64(this.Len())
+	default:
+		return 0, errors.New("Invalid whence")
+	}
+
+	if offset < 0 || offset > int64(this.Len()) {
+		return 0, errors.New("Invalid offset")
+	}
+
+	this.off = int(offset)
+	return offset, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/binary"
+)
+
+// Signatures of common file formats. 32 bit signatures are compared
+// with the first 4 bytes of the data, shorter signatures with the first
+// 1, 2 or 3 bytes.
+const (
+	NO_MAGIC      = uint(0)
+	JPG_MAGIC     = uint(0xFFD8FFE0)
+	GIF_MAGIC     = uint(0x47494638)
+	PDF_MAGIC     = uint(0x25504446)
+	ZIP_MAGIC     = uint(0x504B0304) // Also JAR, DOCX, APK, ...
+	LZMA_MAGIC    = uint(0x377ABCAF) // 7z
+	PNG_MAGIC     = uint(0x89504E47)
+	ELF_MAGIC     = uint(0x7F454C46)
+	MAC_MAGIC32   = uint(0xFEEDFACE)
+	MAC_CIGAM32   = uint(0xCEFAEDFE)
+	MAC_MAGIC64   = uint(0xFEEDFACF)
+	MAC_CIGAM64   = uint(0xCFFAEDFE)
+	ZSTD_MAGIC    = uint(0x28B52FFD)
+	BROTLI_MAGIC  = uint(0x81CFB2CE)
+	RIFF_MAGIC    = uint(0x52494646) // WAV, AVI, WEBP
+	CAB_MAGIC     = uint(0x4D534346)
+	FLAC_MAGIC    = uint(0x664C6143)
+	XZ_MAGIC      = uint(0xFD377A58) // Followed by 0x5A00
+	KNZ_MAGIC     = uint(0x4B414E5A)
+	RAR_MAGIC     = uint(0x52617221)
+	BZIP2_MAGIC   = uint(0x425A68)
+	MP3_ID3_MAGIC = uint(0x494433)
+	GZIP_MAGIC    = uint(0x1F8B)
+	BMP_MAGIC     = uint(0x424D)
+	WIN_MAGIC     = uint(0x4D5A)
+	PBM_MAGIC     = uint(0x5034) // bin only
+	PGM_MAGIC     = uint(0x5035) // bin only
+	PPM_MAGIC     = uint(0x5036) // bin only
+)
+
+var (
+	_KEYS32 = [...]uint{
+		GIF_MAGIC, PDF_MAGIC, ZIP_MAGIC, LZMA_MAGIC, PNG_MAGIC,
+		ELF_MAGIC, MAC_MAGIC32, MAC_CIGAM32, MAC_MAGIC64, MAC_CIGAM64,
+		ZSTD_MAGIC, BROTLI_MAGIC, RIFF_MAGIC, CAB_MAGIC, FLAC_MAGIC,
+		XZ_MAGIC, KNZ_MAGIC, RAR_MAGIC,
+	}
+
+	_KEYS16 = [...]uint{
+		GZIP_MAGIC, BMP_MAGIC, WIN_MAGIC,
+	}
+)
+
+// GetMagicType returns the signature of the format of the data (one of
+// the xxx_MAGIC values) or NO_MAGIC if the format is not recognized
+func GetMagicType(src []byte) uint {
+	if len(src) < 4 {
+		return NO_MAGIC
+	}
+
+	key := uint(binary.BigEndian.Uint32(src))
+
+	if key&^0x0F == JPG_MAGIC {
+		return JPG_MAGIC
+	}
+
+	for _, k := range _KEYS32 {
+		if key == k {
+			return key
+		}
+	}
+
+	if key>>8 == BZIP2_MAGIC || key>>8 == MP3_ID3_MAGIC {
+		return key >> 8
+	}
+
+	for _, k := range _KEYS16 {
+		if key>>16 == k {
+			return key >> 16
+		}
+	}
+
+	// PBM/PGM/PPM binary formats: 'P' then '4', '5' or '6' then whitespace
+	if key>>16 == PBM_MAGIC || key>>16 == PGM_MAGIC || key>>16 == PPM_MAGIC {
+		c := byte(key >> 8)
+
+		if c == 0x20 || c == 0x0A || c == 0x0D || c == 0x09 {
+			return key >> 16
+		}
+	}
+
+	return NO_MAGIC
+}
+
+// IsCompressed returns true if the signature is the one of a format
+// with compressed data (EG. JPG, ZIP, GZIP, ZSTD, etc...)
+func IsCompressed(magic uint) bool {
+	switch magic {
+	case JPG_MAGIC, GIF_MAGIC, PNG_MAGIC, LZMA_MAGIC, ZSTD_MAGIC,
+		BROTLI_MAGIC, CAB_MAGIC, ZIP_MAGIC, GZIP_MAGIC, BZIP2_MAGIC, FLAC_MAGIC,
+		MP3_ID3_MAGIC, XZ_MAGIC, KNZ_MAGIC, RAR_MAGIC:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// GetMagicName returns a short name for the format of the signature
+// (EG. "zstd") or an empty string if the signature is unknown
+func GetMagicName(magic uint) string {
+	switch magic {
+	case JPG_MAGIC:
+		return "jpeg"
+	case GIF_MAGIC:
+		return "gif"
+	case PDF_MAGIC:
+		return "pdf"
+	case ZIP_MAGIC:
+		return "zip"
+	case LZMA_MAGIC:
+		return "7z"
+	case PNG_MAGIC:
+		return "png"
+	case ELF_MAGIC:
+		return "elf"
+	case MAC_MAGIC32, MAC_CIGAM32, MAC_MAGIC64, MAC_CIGAM64:
+		return "mach-o"
+	case ZSTD_MAGIC:
+		return "zstd"
+	case BROTLI_MAGIC:
+		return "brotli"
+	case RIFF_MAGIC:
+		return "riff"
+	case CAB_MAGIC:
+		return "cab"
+	case FLAC_MAGIC:
+		return "flac"
+	case XZ_MAGIC:
+		return "xz"
+	case KNZ_MAGIC:
+		return "kanzi"
+	case RAR_MAGIC:
+		return "rar"
+	case BZIP2_MAGIC:
+		return "bzip2"
+	case MP3_ID3_MAGIC:
+		return "mp3"
+	case GZIP_MAGIC:
+		return "gzip"
+	case BMP_MAGIC:
+		return "bmp"
+	case WIN_MAGIC:
+		return "windows executable"
+	case PBM_MAGIC:
+		return "pbm"
+	case PGM_MAGIC:
+		return "pgm"
+	case PPM_MAGIC:
+		return "ppm"
+
+	default:
+		return ""
+	}
+}
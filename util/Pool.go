@@ -0,0 +1,147 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+)
+
+const (
+	// DEFAULT_POOL_SIZE is the default maximum number of bytes retained by SharedPool
+	DEFAULT_POOL_SIZE = 512 * 1024 * 1024
+
+	// Smaller buffers are left to the GC
+	_POOL_MIN_SIZE = 64 * 1024
+)
+
+// SharedPool is the pool of large buffers used by the streams and codecs
+// when the "manualMemory" parameter is true
+var SharedPool = NewBufferPool(DEFAULT_POOL_SIZE)
+
+// BufferPool keeps the large buffers released explicitly (see Allocator)
+// so that the next allocations reuse them instead of growing the heap.
+// Unlike a sync.Pool, the buffers are not dropped at each GC cycle: the
+// retained memory is bounded by the maximum size instead. Thread safe.
+type BufferPool struct {
+	lock        sync.Mutex
+	free        []pooledBuffer
+	retained    int
+	maxRetained int
+}
+
+type pooledBuffer struct {
+	buf     []byte
+	aligned bool
+}
+
+// NewBufferPool creates a new pool retaining at most 'maxRetained' bytes
+func NewBufferPool(maxRetained int) *BufferPool {
+	return &BufferPool{free: make([]pooledBuffer, 0), maxRetained: maxRetained}
+}
+
+// Retained returns the number of bytes held by the pool
+func (this *BufferPool) Retained() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.retained
+}
+
+// SetMaxRetained changes the maximum number of bytes held by the pool
+// (buffers are evicted as needed)
+func (this *BufferPool) SetMaxRetained(maxRetained int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.maxRetained = maxRetained
+	this.evict(0)
+}
+
+// Trim drops all the buffers held by the pool
+func (this *BufferPool) Trim() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.free = this.free[:0]
+	this.retained = 0
+}
+
+// get returns the smallest buffer with a capacity of at least 'n' bytes (and
+// at most 2*n to limit the waste) or nil. The content is undefined.
+func (this *BufferPool) get(n int, aligned bool) []byte {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	best := -1
+
+	for i := range this.free {
+		c := cap(this.free[i].buf)
+
+		if c < n || c > 2*n || (aligned == true && this.free[i].aligned == false) {
+			continue
+		}
+
+		if best < 0 || c < cap(this.free[best].buf) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return nil
+	}
+
+	res := this.free[best].buf
+	last := len(this.free) - 1
+	this.free[best] = this.free[last]
+	this.free[last] = pooledBuffer{}
+	this.free = this.free[:last]
+	this.retained -= cap(res)
+	return res[0:n]
+}
+
+func (this *BufferPool) put(buf []byte, aligned bool) {
+	if cap(buf) < _POOL_MIN_SIZE {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if cap(buf) > this.maxRetained {
+		return
+	}
+
+	// Keep the most recent buffers: they are the most likely to be needed
+	this.evict(cap(buf))
+	this.free = append(this.free, pooledBuffer{buf: buf[0:cap(buf)], aligned: aligned})
+	this.retained += cap(buf)
+}
+
+// Drop the oldest buffers until 'size' more bytes fit
+func (this *BufferPool) evict(size int) {
+	n := 0
+
+	for n < len(this.free) && this.retained+size > this.maxRetained {
+		this.retained -= cap(this.free[n].buf)
+		n++
+	}
+
+	if n > 0 {
+		remaining := copy(this.free, this.free[n:])
+
+		for i := remaining; i < len(this.free); i++ {
+			this.free[i] = pooledBuffer{}
+		}
+
+		this.free = this.free[:remaining]
+	}
+}
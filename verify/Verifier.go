@@ -0,0 +1,267 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package verify checks the compatibility of kanzi archives across
+// implementations (Go, C++, Java) and releases. Reference archives produced
+// by another implementation are decoded and the decoded content is compared
+// with the original data. The bitstreams themselves may differ between
+// implementations (EG. different match finders or block splits).
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+var (
+	// TRANSFORMS lists the transforms supported by all implementations
+	TRANSFORMS = []string{"NONE", "BWT", "BWTS", "LZ", "LZP", "RLT", "ZRLT",
+		"MTFT", "RANK", "SRT", "X86", "TEXT", "ROLZ", "ROLZX"}
+
+	// ENTROPY_CODECS lists the entropy codecs supported by all implementations
+	ENTROPY_CODECS = []string{"NONE", "HUFFMAN", "ANS0", "ANS1", "RANGE",
+		"FPAQ", "CM", "TPAQ", "TPAQX"}
+)
+
+// Combination is a transform (possibly a sequence) and an entropy codec
+type Combination struct {
+	Transform string
+	Entropy   string
+}
+
+func (this Combination) String() string {
+	return this.Transform + "&" + this.Entropy
+}
+
+// Result is the outcome of the verification of an archive or a combination
+type Result struct {
+	Name        string
+	Combination Combination
+	BlockSize   uint
+	Checksum    bool
+	Err         error // nil if the data was decoded correctly
+}
+
+// Combinations returns every entropy codec combined with every single
+// transform and with the transform sequences of the compression levels
+func Combinations() []Combination {
+	transforms := append([]string{}, TRANSFORMS...)
+
+	for level := 0; level <= 8; level++ {
+		desc, _ := pipeline.ForLevel(level)
+		name := desc.TransformName()
+		found := false
+
+		for _, t := range transforms {
+			if t == name {
+				found = true
+				break
+			}
+		}
+
+		if found == false {
+			transforms = append(transforms, name)
+		}
+	}
+
+	res := make([]Combination, 0, len(transforms)*len(ENTROPY_CODECS))
+
+	for _, t := range transforms {
+		for _, e := range ENTROPY_CODECS {
+			res = append(res, Combination{Transform: t, Entropy: e})
+		}
+	}
+
+	return res
+}
+
+// Compress returns the archive of the data compressed with the provided parameters
+func Compress(data []byte, c Combination, blockSize, jobs uint, checksum bool) (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var bs util.BufferStream
+	ctx := map[string]interface{}{
+		"transform": c.Transform,
+		"codec":     c.Entropy,
+		"blockSize": blockSize,
+		"jobs":      jobs,
+		"checksum":  checksum,
+		"fileSize":  int64(len(data)), // like the command line tools
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = cos.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = cos.Close(); err != nil {
+		return nil, err
+	}
+
+	res = make([]byte, bs.Len())
+	bs.Read(res)
+	return res, nil
+}
+
+// Decompress decodes the archive and returns the data as well as the
+// parameters read from the bitstream header ("transform", "codec",
+// "blockSize" and "checksum")
+func Decompress(archive []byte, jobs uint) (res []byte, ctx map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var bs util.BufferStream
+	bs.Write(archive)
+	ctx = map[string]interface{}{"jobs": jobs}
+	cis, err := kio.NewCompressedInputStreamWithCtx(&bs, ctx)
+
+	if err != nil {
+		return nil, ctx, err
+	}
+
+	var output bytes.Buffer
+	buf := make([]byte, 65536)
+
+	for {
+		n, err := cis.Read(buf)
+
+		if err != nil {
+			return nil, ctx, err
+		}
+
+		if n == 0 {
+			break
+		}
+
+		output.Write(buf[0:n])
+	}
+
+	if err = cis.Close(); err != nil {
+		return nil, ctx, err
+	}
+
+	return output.Bytes(), ctx, nil
+}
+
+// RoundTrip compresses and decompresses the data with the provided parameters
+// and compares the result with the data
+func RoundTrip(data []byte, c Combination, blockSize, jobs uint) Result {
+	res := Result{Name: c.String(), Combination: c, BlockSize: blockSize, Checksum: true}
+	archive, err := Compress(data, c, blockSize, jobs, true)
+
+	if err != nil {
+		res.Err = fmt.Errorf("Compression failed: %v", err)
+		return res
+	}
+
+	decoded, _, err := Decompress(archive, jobs)
+
+	if err != nil {
+		res.Err = fmt.Errorf("Decompression failed: %v", err)
+		return res
+	}
+
+	if bytes.Equal(data, decoded) == false {
+		res.Err = fmt.Errorf("Decompressed data differs from original data")
+		return res
+	}
+
+	return res
+}
+
+// RoundTripAll runs RoundTrip for every combination returned by Combinations
+// using 'jobs' concurrent round trips. The results are in combination order.
+func RoundTripAll(data []byte, blockSize, jobs uint) []Result {
+	combinations := Combinations()
+	results := make([]Result, len(combinations))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	if jobs == 0 {
+		jobs = 1
+	}
+
+	for i := uint(0); i < jobs; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for idx := range indexes {
+				results[idx] = RoundTrip(data, combinations[idx], blockSize, 1)
+			}
+		}()
+	}
+
+	for i := range combinations {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// VerifyArchive decodes an archive produced by any implementation and
+// compares the decoded content with the original data. The parameters read
+// from the header of the archive are returned in the result.
+func VerifyArchive(name string, archive, original []byte, jobs uint) Result {
+	res := Result{Name: name}
+	decoded, ctx, err := Decompress(archive, jobs)
+
+	if t, ok := ctx["transform"].(string); ok == true {
+		res.Combination.Transform = t
+	}
+
+	if e, ok := ctx["codec"].(string); ok == true {
+		res.Combination.Entropy = e
+	}
+
+	if bs, ok := ctx["blockSize"].(uint); ok == true {
+		res.BlockSize = bs
+	}
+
+	if cs, ok := ctx["checksum"].(bool); ok == true {
+		res.Checksum = cs
+	}
+
+	if err != nil {
+		res.Err = fmt.Errorf("Decompression failed: %v", err)
+		return res
+	}
+
+	if bytes.Equal(original, decoded) == false {
+		res.Err = fmt.Errorf("Decompressed data differs from original data")
+	}
+
+	return res
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command Verifier checks kanzi archives produced by other implementations
+// (C++, Java) against the original data:
+//
+//	Verifier [-jobs=N] original archive1.knz [archive2.knz ...]
+//
+// With -roundtrip, it compresses and decompresses the original data with
+// every combination of transforms and entropy codecs instead:
+//
+//	Verifier -roundtrip [-block=N] [-jobs=N] original
+//
+// The exit status is 0 if all verifications succeed and 1 otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/flanglet/kanzi-go/verify"
+)
+
+func main() {
+	roundTrip := flag.Bool("roundtrip", false, "round trip the original data with every combination")
+	blockSize := flag.Uint("block", 1024*1024, "block size for round trips")
+	jobs := flag.Uint("jobs", 1, "number of concurrent jobs")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) == 0 || (*roundTrip == false && len(args) < 2) {
+		fmt.Println("Usage: Verifier [-jobs=N] original archive [archive ...]")
+		fmt.Println("       Verifier -roundtrip [-block=N] [-jobs=N] original")
+		os.Exit(1)
+	}
+
+	original, err := ioutil.ReadFile(args[0])
+
+	if err != nil {
+		fmt.Printf("Cannot read %v: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	var results []verify.Result
+
+	if *roundTrip == true {
+		results = verify.RoundTripAll(original, *blockSize, *jobs)
+	} else {
+		for _, name := range args[1:] {
+			archive, err := ioutil.ReadFile(name)
+
+			if err != nil {
+				results = append(results, verify.Result{Name: name, Err: err})
+				continue
+			}
+
+			results = append(results, verify.VerifyArchive(name, archive, original, *jobs))
+		}
+	}
+
+	failures := 0
+
+	for _, r := range results {
+		if r.Err != nil {
+			failures++
+			fmt.Printf("FAIL  %v: %v\n", r.Name, r.Err)
+		} else if *roundTrip == true {
+			fmt.Printf("OK    %v\n", r.Name)
+		} else {
+			fmt.Printf("OK    %v [%v, block %d, checksum %v]\n", r.Name,
+				r.Combination, r.BlockSize, r.Checksum)
+		}
+	}
+
+	fmt.Printf("%d verification(s), %d failure(s)\n", len(results), failures)
+
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,156 @@
+//go:build js && wasm
+// +build js,wasm
+
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command wasm exposes kanzi compression to JavaScript. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o kanzi.wasm ./wasm
+//
+// and load it with the wasm_exec.js glue shipped with Go. It registers a
+// global 'kanzi' object:
+//
+//	const c = kanzi.newCompressor({pipeline: "TEXT+BWT+RANK+ZRLT&ANS0", blockSize: 1<<20});
+//	let out = c.write(chunk);  // Uint8Array, possibly empty
+//	out = c.close();           // remaining compressed bytes
+//
+//	const d = kanzi.newDecompressor();
+//	let data = d.write(chunk); // Uint8Array, possibly empty
+//	data = d.close();          // remaining decompressed bytes
+//
+// Instead of a pipeline, a compression level in [0..8] can be provided
+// (EG. {level: 2}). The methods return an Error object on failure.
+package main
+
+import (
+	"syscall/js"
+
+	kio "github.com/flanglet/kanzi-go/io"
+	"github.com/flanglet/kanzi-go/pipeline"
+)
+
+const (
+	_DEFAULT_LEVEL      = 3
+	_DEFAULT_BLOCK_SIZE = 1024 * 1024
+)
+
+func main() {
+	js.Global().Set("kanzi", js.ValueOf(map[string]interface{}{
+		"version":         kio.LIBRARY_VERSION,
+		"newCompressor":   js.FuncOf(newCompressor),
+		"newDecompressor": js.FuncOf(newDecompressor),
+	}))
+
+	// Keep the exported functions alive
+	select {}
+}
+
+func newCompressor(this js.Value, args []js.Value) interface{} {
+	desc, err := pipeline.ForLevel(_DEFAULT_LEVEL)
+	blockSize := uint(_DEFAULT_BLOCK_SIZE)
+	checksum := false
+
+	if len(args) > 0 && args[0].Type() == js.TypeObject {
+		opts := args[0]
+
+		if v := opts.Get("pipeline"); v.Type() == js.TypeString {
+			desc, err = pipeline.Parse(v.String())
+		} else if v := opts.Get("level"); v.Type() == js.TypeNumber {
+			desc, err = pipeline.ForLevel(v.Int())
+		}
+
+		if v := opts.Get("blockSize"); v.Type() == js.TypeNumber {
+			blockSize = uint(v.Int())
+		}
+
+		if v := opts.Get("checksum"); v.Type() == js.TypeBoolean {
+			checksum = v.Bool()
+		}
+	}
+
+	if err != nil {
+		return newError(err)
+	}
+
+	ctx := map[string]interface{}{
+		"transform": desc.TransformName(),
+		"codec":     desc.Entropy,
+		"blockSize": blockSize,
+		"checksum":  checksum,
+		"jobs":      uint(1),
+	}
+
+	c, err := kio.NewChunkCompressor(ctx)
+
+	if err != nil {
+		return newError(err)
+	}
+
+	return wrap(c.Write, c.Close)
+}
+
+func newDecompressor(this js.Value, args []js.Value) interface{} {
+	ctx := map[string]interface{}{"jobs": uint(1)}
+	d, err := kio.NewChunkDecompressor(ctx)
+
+	if err != nil {
+		return newError(err)
+	}
+
+	return wrap(d.Write, d.Close)
+}
+
+// wrap returns a JS object with 'write' and 'close' methods calling the
+// provided functions
+func wrap(write func([]byte) ([]byte, error), close func() ([]byte, error)) js.Value {
+	var writeFunc, closeFunc js.Func
+
+	writeFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) == 0 {
+			return toJS(nil, nil)
+		}
+
+		chunk := make([]byte, args[0].Get("length").Int())
+		js.CopyBytesToGo(chunk, args[0])
+		return toJS(write(chunk))
+	})
+
+	closeFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		res := toJS(close())
+		writeFunc.Release()
+		closeFunc.Release()
+		return res
+	})
+
+	return js.ValueOf(map[string]interface{}{
+		"write": writeFunc,
+		"close": closeFunc,
+	})
+}
+
+func toJS(data []byte, err error) interface{} {
+	if err != nil {
+		return newError(err)
+	}
+
+	res := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(res, data)
+	return res
+}
+
+func newError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}